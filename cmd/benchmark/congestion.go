@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/internal/congestion"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// congestionList parses the -congestion flag. "available" stands for every
+// controller the kernel can run.
+func congestionList(value string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		switch name {
+		case "":
+		case "available":
+			available := congestion.Available()
+			if len(available) == 0 {
+				return nil, fmt.Errorf("the available congestion controllers are unknown on this system")
+			}
+			names = append(names, available...)
+		default:
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// runCongestionMatrix runs the test of every protocol configuration once per
+// congestion controller and prints a comparison of the results
+func runCongestionMatrix(ctx context.Context, protocols []benchmark.TestConfig, controllers []string, logger logging.Logger) []benchmark.TestResult {
+	var results []benchmark.TestResult
+	for _, cc := range controllers {
+		for _, cfg := range protocols {
+			cfg.Congestion = cc
+			label := fmt.Sprintf("%s %s", strings.ToUpper(cfg.Protocol), cc)
+			log.Printf("Testing %s...", label)
+			result, err := benchmark.NewBenchmarker(cfg, logger).Run(ctx)
+			if err != nil {
+				log.Printf("%s test failed: %v", label, err)
+				continue
+			}
+			results = append(results, *result)
+			printResult(label, result)
+		}
+	}
+
+	fmt.Printf("\n=== Congestion Controller Comparison ===\n")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTOCOL\tCONTROLLER\tTHROUGHPUT rps\tBANDWIDTH Mbps\tP99 ms\tNOTE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%.2f\t%.2f\t%s\n", r.Protocol, r.Congestion, r.Throughput, r.Bandwidth, r.P99Latency, r.CongestionNote)
+	}
+	tw.Flush()
+	return results
+}
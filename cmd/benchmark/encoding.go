@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// encodingMatrix expands base, the QUIC test configuration, into one encoding
+// test per encoding, batch size and protocol
+func encodingMatrix(base benchmark.TestConfig, tcpAddr, tcpAdmin string, compare bool, encodings, batchSizes string) ([]benchmark.TestConfig, error) {
+	var encs []string
+	for _, e := range strings.Split(encodings, ",") {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+		if !slices.Contains(benchmark.Encodings, e) {
+			return nil, fmt.Errorf("unknown encoding %q, supported: %s", e, strings.Join(benchmark.Encodings, ", "))
+		}
+		encs = append(encs, e)
+	}
+	var sizes []int
+	for _, v := range strings.Split(batchSizes, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid batch size %q", v)
+		}
+		sizes = append(sizes, n)
+	}
+	if len(encs) == 0 || len(sizes) == 0 {
+		return nil, fmt.Errorf("at least one encoding and one batch size are required")
+	}
+
+	var configs []benchmark.TestConfig
+	for _, size := range sizes {
+		for _, enc := range encs {
+			for _, p := range protocolConfigs(base, tcpAddr, tcpAdmin, compare) {
+				p.Encoding, p.BatchSize = enc, size
+				configs = append(configs, p)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// protocolConfigs returns base, the QUIC test configuration, followed by its
+// TCP counterpart when compare is set
+func protocolConfigs(base benchmark.TestConfig, tcpAddr, tcpAdmin string, compare bool) []benchmark.TestConfig {
+	protocols := []benchmark.TestConfig{base}
+	if compare {
+		tcp := base
+		tcp.Protocol, tcp.Endpoint, tcp.AdminURL = "tcp", tcpAddr, tcpAdmin
+		protocols = append(protocols, tcp)
+	}
+	return protocols
+}
+
+// runEncodingMatrix runs configs one after the other and prints a comparison
+// of the results
+func runEncodingMatrix(ctx context.Context, configs []benchmark.TestConfig, logger logging.Logger) []benchmark.TestResult {
+	var results []benchmark.TestResult
+	for _, cfg := range configs {
+		label := fmt.Sprintf("%s %s x%d", strings.ToUpper(cfg.Protocol), cfg.Encoding, cfg.BatchSize)
+		log.Printf("Testing %s...", label)
+		result, err := benchmark.NewBenchmarker(cfg, logger).Run(ctx)
+		if err != nil {
+			log.Printf("%s test failed: %v", label, err)
+			continue
+		}
+		results = append(results, *result)
+		printResult(label, result)
+	}
+	printEncodingComparison(results)
+	return results
+}
+
+func printEncodingComparison(results []benchmark.TestResult) {
+	fmt.Printf("\n=== Encoding Comparison ===\n")
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROTOCOL\tENCODING\tBATCH\tREADINGS\tBYTES/READING\tENCODE µs/READING\tDECODE µs/READING\tP99 ms")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f\t%.2f\t%.2f\t%.2f\n", r.Protocol, r.Encoding, r.BatchSize,
+			r.Readings, r.BytesPerReading, r.ClientEncodeUs, r.ServerDecodeUs, r.P99Latency)
+	}
+	tw.Flush()
+}
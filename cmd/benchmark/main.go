@@ -7,47 +7,155 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/internal/iot/compress"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
 )
 
 func main() {
 	var (
 		quicAddr    = flag.String("quic", "https://localhost:8443", "QUIC server address")
 		tcpAddr     = flag.String("tcp", "https://localhost:8080", "TCP server address")
-		testType    = flag.String("test", "latency", "Test type (latency, throughput, iot, streaming)")
+		testType    = flag.String("test", "latency", "Test type (latency, throughput, iot, streaming, encoding)")
 		duration    = flag.Duration("duration", 30*time.Second, "Test duration")
 		clients     = flag.Int("clients", 10, "Number of concurrent clients")
 		requestSize = flag.Int("size", 1024, "Request payload size in bytes")
 		output      = flag.String("output", "", "Output file for results (JSON)")
 		compare     = flag.Bool("compare", true, "Compare QUIC vs TCP performance")
+		profileURL  = flag.String("profile-server", "", "Admin URL of the QUIC server to fetch a CPU profile from during the QUIC test (e.g. http://localhost:9090)")
+		profileTok  = flag.String("profile-token", os.Getenv("ADMIN_TOKEN"), "Bearer token for the admin debug endpoints")
+		condition   = flag.String("condition", "", "Label of the network condition under test, recorded in the results (e.g. lossy-2pct)")
+		report      = flag.String("report", "", "Output file for an HTML latency heatmap across network conditions")
+		reportFrom  = flag.String("report-inputs", "", "Comma-separated result files of earlier runs to include in the heatmap")
+		faultsFile  = flag.String("faults", "", "YAML fault schedule injected during each protocol's test")
+		quicAdmin   = flag.String("quic-admin", "", "Admin URL of the QUIC server, needed for server-restart faults and IoT reading loss")
+		tcpAdmin    = flag.String("tcp-admin", "", "Admin URL of the TCP server, needed for server-restart faults and IoT reading loss")
+		maxConns    = flag.Int("max-conns-per-host", 0, "Maximum connections per host in the client pool (0 for unlimited)")
+		newConns    = flag.Bool("new-conn-per-request", false, "Open a new connection for every request to measure handshake-inclusive latency")
+		encodings   = flag.String("encodings", strings.Join(benchmark.Encodings, ","), "Comma-separated IoT payload encodings run by the encoding test")
+		batchSizes  = flag.String("batch-sizes", "1,50", "Comma-separated readings per request run by the encoding test")
+		clockSync   = flag.Bool("clock-sync", false, "Estimate the server clock offset before each test and report one-way latencies")
+		syncCount   = flag.Int("sync-exchanges", benchmark.DefaultSyncExchanges, "Pings used to estimate the server clock offset")
+		maxSyncErr  = flag.Duration("max-sync-uncertainty", benchmark.DefaultMaxSyncUncertainty, "Clock offset uncertainty above which one-way latencies fall back to RTT/2")
+		ccFlag      = flag.String("congestion", "", "Comma-separated TCP congestion controllers of the client connections, one run each (\"available\" for all the kernel offers)")
+		compressTo  = flag.String("compress", "", "Codec of the IoT test's request and response bodies (zstd or gzip); the server needs iot.compression enabled")
+		windowFlag  = flag.String("quic-windows", "", "Comma-separated QUIC receive windows (e.g. 512KB,2MB,8MB), one run each with a real HTTP/3 client")
 	)
 	flag.Parse()
 
+	cfg := config.Load()
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
+
 	log.Printf("Starting benchmark tool")
 	log.Printf("Test type: %s", *testType)
 	log.Printf("Duration: %v", *duration)
 	log.Printf("Clients: %d", *clients)
 	log.Printf("Request size: %d bytes", *requestSize)
 
+	controllers, err := congestionList(*ccFlag)
+	if err != nil {
+		log.Fatal("Invalid congestion controllers:", err)
+	}
+	if len(controllers) > 1 && *testType == benchmark.TestTypeEncoding {
+		log.Fatal("The encoding test runs with a single congestion controller")
+	}
+	if *compressTo != "" && (*testType != "iot" || !compress.Supported(*compressTo)) {
+		log.Fatalf("-compress must be zstd or gzip and runs with the iot test")
+	}
+	windows, err := windowList(*windowFlag)
+	if err != nil {
+		log.Fatal("Invalid QUIC windows:", err)
+	}
+	if len(windows) > 0 && (len(controllers) > 1 || *testType == benchmark.TestTypeEncoding) {
+		log.Fatal("The window sweep runs with a single congestion controller and without the encoding test")
+	}
+
+	var faults []benchmark.Fault
+	if *faultsFile != "" {
+		faults, err = benchmark.LoadFaults(*faultsFile)
+		if err != nil {
+			log.Fatal("Failed to load fault schedule:", err)
+		}
+		log.Printf("Fault schedule: %d faults", len(faults))
+	}
+
 	ctx := context.Background()
 
 	var results []benchmark.TestResult
 
 	// Test QUIC
-	log.Println("Testing QUIC protocol...")
 	quicConfig := benchmark.TestConfig{
-		Protocol:    "quic",
-		Endpoint:    *quicAddr,
-		TestType:    *testType,
-		Duration:    *duration,
-		Clients:     *clients,
-		RequestSize: *requestSize,
+		Protocol:          "quic",
+		Endpoint:          *quicAddr,
+		TestType:          *testType,
+		Duration:          *duration,
+		Clients:           *clients,
+		RequestSize:       *requestSize,
+		Condition:         *condition,
+		Faults:            faults,
+		AdminURL:          *quicAdmin,
+		AdminToken:        *profileTok,
+		MaxConnsPerHost:   *maxConns,
+		NewConnPerRequest: *newConns,
+		ClockSync:         *clockSync,
+		SyncExchanges:     *syncCount,
+		MaxUncertainty:    *maxSyncErr,
+		Compression:       *compressTo,
+	}
+	if len(controllers) == 1 {
+		quicConfig.Congestion = controllers[0]
+	}
+
+	if len(controllers) > 1 {
+		protocols := protocolConfigs(quicConfig, *tcpAddr, *tcpAdmin, *compare)
+		results = runCongestionMatrix(ctx, protocols, controllers, logger.Named("benchmark"))
+		writeOutputs(*output, *report, *reportFrom, results)
+		return
+	}
+
+	if len(windows) > 0 {
+		results = runWindowSweep(ctx, quicConfig, windows, logger.Named("benchmark"))
+		writeOutputs(*output, *report, *reportFrom, results)
+		return
+	}
+
+	if *testType == benchmark.TestTypeEncoding {
+		configs, err := encodingMatrix(quicConfig, *tcpAddr, *tcpAdmin, *compare, *encodings, *batchSizes)
+		if err != nil {
+			log.Fatal("Invalid encoding matrix:", err)
+		}
+		results = runEncodingMatrix(ctx, configs, logger.Named("benchmark"))
+		writeOutputs(*output, *report, *reportFrom, results)
+		return
 	}
 
-	quicBench := benchmark.NewBenchmarker(quicConfig)
+	log.Println("Testing QUIC protocol...")
+	var profileDone chan error
+	profilePath := profileOutputPath(*output, "quic")
+	if *profileURL != "" {
+		profileDone = make(chan error, 1)
+		go func() {
+			profileDone <- fetchCPUProfile(*profileURL, *profileTok, *duration, profilePath)
+		}()
+	}
+
+	quicBench := benchmark.NewBenchmarker(quicConfig, logger.Named("benchmark"))
 	quicResult, err := quicBench.Run(ctx)
+
+	if profileDone != nil {
+		if err := <-profileDone; err != nil {
+			log.Printf("Failed to fetch server CPU profile: %v", err)
+		} else {
+			log.Printf("Server CPU profile saved to %s", profilePath)
+		}
+	}
 	if err != nil {
 		log.Printf("QUIC test failed: %v", err)
 	} else {
@@ -59,15 +167,25 @@ func main() {
 		// Test TCP
 		log.Println("Testing TCP protocol...")
 		tcpConfig := benchmark.TestConfig{
-			Protocol:    "tcp",
-			Endpoint:    *tcpAddr,
-			TestType:    *testType,
-			Duration:    *duration,
-			Clients:     *clients,
-			RequestSize: *requestSize,
+			Protocol:          "tcp",
+			Endpoint:          *tcpAddr,
+			TestType:          *testType,
+			Duration:          *duration,
+			Clients:           *clients,
+			RequestSize:       *requestSize,
+			Condition:         *condition,
+			Faults:            faults,
+			AdminURL:          *tcpAdmin,
+			AdminToken:        *profileTok,
+			MaxConnsPerHost:   *maxConns,
+			NewConnPerRequest: *newConns,
+			ClockSync:         *clockSync,
+			SyncExchanges:     *syncCount,
+			MaxUncertainty:    *maxSyncErr,
+			Congestion:        quicConfig.Congestion,
 		}
 
-		tcpBench := benchmark.NewBenchmarker(tcpConfig)
+		tcpBench := benchmark.NewBenchmarker(tcpConfig, logger.Named("benchmark"))
 		tcpResult, err := tcpBench.Run(ctx)
 		if err != nil {
 			log.Printf("TCP test failed: %v", err)
@@ -82,12 +200,25 @@ func main() {
 		}
 	}
 
-	// Save results to file if specified
-	if *output != "" {
-		if err := saveResults(*output, results); err != nil {
+	writeOutputs(*output, *report, *reportFrom, results)
+}
+
+// writeOutputs saves results and the HTML report to the files given on the
+// command line, if any
+func writeOutputs(output, report, reportFrom string, results []benchmark.TestResult) {
+	if output != "" {
+		if err := saveResults(output, results); err != nil {
 			log.Printf("Failed to save results: %v", err)
 		} else {
-			log.Printf("Results saved to %s", *output)
+			log.Printf("Results saved to %s", output)
+		}
+	}
+
+	if report != "" {
+		if err := saveReport(report, reportFrom, results); err != nil {
+			log.Printf("Failed to write report: %v", err)
+		} else {
+			log.Printf("Latency heatmap saved to %s", report)
 		}
 	}
 }
@@ -105,7 +236,48 @@ func printResult(protocol string, result *benchmark.TestResult) {
 	fmt.Printf("99th Percentile:   %.2f ms\n", result.P99Latency)
 	fmt.Printf("Bytes Sent:        %d\n", result.BytesSent)
 	fmt.Printf("Bytes Received:    %d\n", result.BytesReceived)
-	
+	if bodies := result.BytesSent + result.BytesReceived; result.Compression != "" && bodies > 0 {
+		share := float64(result.WireBytesSent+result.WireBytesReceived) / float64(bodies)
+		fmt.Printf("Wire Bytes:        %d sent, %d received (%s, %.1f%% of the bodies)\n", result.WireBytesSent, result.WireBytesReceived,
+			result.Compression, share*100)
+	}
+	fmt.Printf("Connections:       %d (%.1f requests/connection)\n", result.Connections, result.RequestsPerConn)
+	if result.Congestion != "" || result.CongestionNote != "" {
+		fmt.Printf("Congestion:        %s %s\n", result.Congestion, result.CongestionNote)
+	}
+	if result.QUICWindow > 0 {
+		fmt.Printf("QUIC Window:       %d KB (client blocked %.1f ms)\n", result.QUICWindow>>10, result.FlowBlockedMs)
+	}
+	if cs := result.ClockSync; cs != nil {
+		if cs.Error != "" {
+			fmt.Printf("Clock Sync:        failed: %s\n", cs.Error)
+		} else {
+			fmt.Printf("Clock Sync:        offset %.3f ms ± %.3f ms over %d exchanges\n", cs.OffsetMs, cs.UncertaintyMs, cs.Exchanges)
+		}
+	}
+	if ow := result.OneWay; ow != nil {
+		source := fmt.Sprintf("± %.3f ms", ow.UncertaintyMs)
+		if ow.RTTFallback {
+			source = "RTT/2, " + ow.Reason
+		}
+		fmt.Printf("Uplink Latency:    avg %.2f ms, p99 %.2f ms (%s)\n", ow.UplinkAvgMs, ow.UplinkP99Ms, source)
+		fmt.Printf("Downlink Latency:  avg %.2f ms, p99 %.2f ms (%s)\n", ow.DownlinkAvgMs, ow.DownlinkP99Ms, source)
+	}
+	if l := result.Loss; l != nil {
+		if l.Error != "" {
+			fmt.Printf("Reading Loss:      unknown: %s\n", l.Error)
+		} else {
+			fmt.Printf("Reading Loss:      %.2f%% (%d sent, %d received, %d duplicates, %d out of order)\n", l.LossPercent, l.Sent, l.Received, l.Duplicates, l.OutOfOrder)
+		}
+	}
+	for _, f := range result.Faults {
+		if f.Error != "" {
+			fmt.Printf("Fault:             %s at %dms failed: %s\n", f.Type, f.StartOffsetMs, f.Error)
+			continue
+		}
+		fmt.Printf("Fault:             %s %dms-%dms\n", f.Type, f.StartOffsetMs, f.EndOffsetMs)
+	}
+
 	if len(result.Errors) > 0 {
 		fmt.Printf("Errors:            %d\n", len(result.Errors))
 		for i, err := range result.Errors {
@@ -121,32 +293,32 @@ func printResult(protocol string, result *benchmark.TestResult) {
 
 func compareResults(quicResult, tcpResult *benchmark.TestResult) {
 	fmt.Printf("\n=== QUIC vs TCP Comparison ===\n")
-	
+
 	// Throughput comparison
 	throughputImprovement := (quicResult.Throughput - tcpResult.Throughput) / tcpResult.Throughput * 100
 	fmt.Printf("Throughput:        QUIC %.2f vs TCP %.2f RPS (%.2f%% improvement)\n",
 		quicResult.Throughput, tcpResult.Throughput, throughputImprovement)
-	
+
 	// Latency comparison
 	latencyImprovement := (tcpResult.AvgLatency - quicResult.AvgLatency) / tcpResult.AvgLatency * 100
 	fmt.Printf("Average Latency:   QUIC %.2f vs TCP %.2f ms (%.2f%% improvement)\n",
 		quicResult.AvgLatency, tcpResult.AvgLatency, latencyImprovement)
-	
+
 	// Bandwidth comparison
 	bandwidthImprovement := (quicResult.Bandwidth - tcpResult.Bandwidth) / tcpResult.Bandwidth * 100
 	fmt.Printf("Bandwidth:         QUIC %.2f vs TCP %.2f Mbps (%.2f%% improvement)\n",
 		quicResult.Bandwidth, tcpResult.Bandwidth, bandwidthImprovement)
-	
+
 	// Success rate comparison
 	quicSuccessRate := float64(quicResult.SuccessRequests) / float64(quicResult.TotalRequests) * 100
 	tcpSuccessRate := float64(tcpResult.SuccessRequests) / float64(tcpResult.TotalRequests) * 100
 	fmt.Printf("Success Rate:      QUIC %.2f%% vs TCP %.2f%%\n", quicSuccessRate, tcpSuccessRate)
-	
+
 	// P95 latency comparison
 	p95Improvement := (tcpResult.P95Latency - quicResult.P95Latency) / tcpResult.P95Latency * 100
 	fmt.Printf("95th Percentile:   QUIC %.2f vs TCP %.2f ms (%.2f%% improvement)\n",
 		quicResult.P95Latency, tcpResult.P95Latency, p95Improvement)
-	
+
 	// Summary
 	fmt.Printf("\nSummary:\n")
 	if throughputImprovement > 0 {
@@ -154,13 +326,13 @@ func compareResults(quicResult, tcpResult *benchmark.TestResult) {
 	} else {
 		fmt.Printf("✗ TCP shows %.2f%% better throughput\n", -throughputImprovement)
 	}
-	
+
 	if latencyImprovement > 0 {
 		fmt.Printf("✓ QUIC shows %.2f%% lower latency\n", latencyImprovement)
 	} else {
 		fmt.Printf("✗ TCP shows %.2f%% lower latency\n", -latencyImprovement)
 	}
-	
+
 	if bandwidthImprovement > 0 {
 		fmt.Printf("✓ QUIC shows %.2f%% better bandwidth utilization\n", bandwidthImprovement)
 	} else {
@@ -168,6 +340,45 @@ func compareResults(quicResult, tcpResult *benchmark.TestResult) {
 	}
 }
 
+// saveReport writes the latency heatmap of results, preceded by the results
+// stored in the comma-separated files of inputs
+func saveReport(filename, inputs string, results []benchmark.TestResult) error {
+	var all []benchmark.TestResult
+	for _, path := range strings.Split(inputs, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		previous, err := loadResults(path)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", path, err)
+		}
+		all = append(all, previous...)
+	}
+	all = append(all, results...)
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return benchmark.WriteHeatmapReport(file, all)
+}
+
+func loadResults(filename string) ([]benchmark.TestResult, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var saved struct {
+		Results []benchmark.TestResult `json:"results"`
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	return saved.Results, nil
+}
+
 func saveResults(filename string, results []benchmark.TestResult) error {
 	file, err := os.Create(filename)
 	if err != nil {
@@ -181,4 +392,4 @@ func saveResults(filename string, results []benchmark.TestResult) error {
 		"timestamp": time.Now(),
 		"results":   results,
 	})
-}
\ No newline at end of file
+}
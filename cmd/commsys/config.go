@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("usage: commsys config show [-config FILE]")
+	}
+
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	return cfg.Dump(os.Stdout)
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// demoRefresh is how often the demo prints its summary
+const demoRefresh = 2 * time.Second
+
+// demoChunkInterval is how often each viewer requests a chunk
+const demoChunkInterval = 250 * time.Millisecond
+
+var demoSensors = []string{"temperature", "humidity", "motion", "pressure", "light"}
+
+// runDemo starts the server, a few devices and viewers in one process and
+// prints what they are doing until the duration is up
+func runDemo(args []string) error {
+	fs := flag.NewFlagSet("demo", flag.ContinueOnError)
+	duration := fs.Duration("duration", 30*time.Second, "How long to run the demo")
+	devices := fs.Int("devices", 5, "Number of simulated IoT devices")
+	viewers := fs.Int("viewers", 2, "Number of streaming viewers")
+	seeds := fs.Int("seed-streams", 2, "Synthetic streams added to the catalog; every other viewer watches one")
+	interval := fs.Duration("interval", time.Second, "Interval between readings per device")
+	logLevel := fs.String("log-level", "warn", "Server log level")
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	cfg.Logging.Level = *logLevel
+	cfg.Streaming.SeedStreams = *seeds
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	srv, err := startDemoServer(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer srv.stop()
+
+	fmt.Printf("Server:  https://%s (HTTP/3)\n", srv.addr)
+	fmt.Printf("Admin:   http://%s (try /metrics, /healthz)\n", srv.adminAddr)
+	fmt.Printf("Running %d devices and %d viewers for %v\n\n", *devices, *viewers, *duration)
+
+	serverURL := "https://" + srv.addr
+	var wg sync.WaitGroup
+	fleet := make([]*demoDevice, *devices)
+	for i := range fleet {
+		fleet[i] = &demoDevice{id: fmt.Sprintf("demo_device_%02d", i+1), sensor: demoSensors[i%len(demoSensors)]}
+		wg.Add(1)
+		go func(d *demoDevice) {
+			defer wg.Done()
+			d.run(ctx, serverURL, *interval)
+		}(fleet[i])
+	}
+	audience := make([]*demoViewer, *viewers)
+	for i := range audience {
+		audience[i] = &demoViewer{
+			id:      fmt.Sprintf("viewer_%d", i+1),
+			stream:  fmt.Sprintf("stream_%03d", i%2+1),
+			quality: cfg.Streaming.Ladder[min(i%2, len(cfg.Streaming.Ladder)-1)].Name,
+		}
+		if i%2 == 1 && *seeds > 0 {
+			seed := i / 2 % *seeds
+			audience[i].stream = streaming.SeededStreamID(seed)
+			audience[i].loop = streaming.SeededChunks(cfg.Streaming.SeedDurations[seed%len(cfg.Streaming.SeedDurations)], cfg.Streaming.ChunkDuration)
+		}
+		wg.Add(1)
+		go func(v *demoViewer) {
+			defer wg.Done()
+			v.run(ctx, serverURL)
+		}(audience[i])
+	}
+
+	start, last := time.Now(), time.Now()
+	ticker := time.NewTicker(demoRefresh)
+	defer ticker.Stop()
+	var lastReadings int64
+	lastBytes := make([]int64, len(audience))
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			done = true
+		}
+		now := time.Now()
+		lastReadings = printDemoSummary(now.Sub(start), now.Sub(last), *interval, fleet, audience, lastReadings, lastBytes)
+		last = now
+	}
+
+	wg.Wait()
+	fmt.Println("Demo finished")
+	return nil
+}
+
+// demoServer runs the server of cmd/server and its admin API on ephemeral
+// ports
+type demoServer struct {
+	addr      string
+	adminAddr string
+	stop      func()
+}
+
+func startDemoServer(cfg *config.Config, logger logging.Logger) (*demoServer, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	cfg.Admin.Addr = adminListener.Addr().String()
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		conn.Close()
+		adminListener.Close()
+		return nil, err
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	srv.RunBackground(monitorCtx)
+	go func() {
+		if err := srv.Serve(conn); err != nil {
+			logger.Error("Server failed", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := srv.Admin().Serve(adminListener); err != nil {
+			logger.Error("Admin server failed", logging.Err(err))
+		}
+	}()
+
+	return &demoServer{
+		addr:      conn.LocalAddr().String(),
+		adminAddr: adminListener.Addr().String(),
+		stop: func() {
+			stopMonitor()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(ctx)
+			conn.Close()
+		},
+	}, nil
+}
+
+// newDemoClient returns an HTTP/3 client with its own QUIC connection
+func newDemoClient() (*http.Client, *http3.Transport) {
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}, transport
+}
+
+// demoDevice registers and posts a reading every interval
+type demoDevice struct {
+	id     string
+	sensor string
+
+	readings atomic.Int64
+	failures atomic.Int64
+	lastOK   atomic.Int64 // unix nanoseconds of the last accepted reading
+}
+
+func (d *demoDevice) run(ctx context.Context, serverURL string, interval time.Duration) {
+	client, transport := newDemoClient()
+	defer transport.Close()
+
+	version := iot.ProtocolV1
+	var registered iot.RegisterResponse
+	if err := demoPost(ctx, client, serverURL+"/iot/register", iot.RegisterRequest{
+		DeviceID: d.id, MinVersion: iot.MinProtocolVersion, MaxVersion: iot.MaxProtocolVersion,
+	}, nil, &registered); err == nil {
+		version = registered.Version
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reading := iot.SensorData{
+			DeviceID:   d.id,
+			SensorType: d.sensor,
+			Value:      rand.Float64() * 100,
+			Unit:       "demo",
+			Timestamp:  time.Now(),
+			Quality:    "reliable",
+		}
+		header := map[string]string{"X-Device-ID": d.id, iot.VersionHeader: fmt.Sprint(version)}
+		if err := demoPost(ctx, client, serverURL+"/iot/sensor", reading, header, &iot.Response{}); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			d.failures.Add(1)
+		} else {
+			d.readings.Add(1)
+			d.lastOK.Store(time.Now().UnixNano())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// online reports whether the device had a reading accepted recently
+func (d *demoDevice) online(interval time.Duration) bool {
+	last := d.lastOK.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < 2*interval+time.Second
+}
+
+func demoPost(ctx context.Context, client *http.Client, url string, body interface{}, header map[string]string, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(out)
+}
+
+// demoViewer fetches chunks of one stream at demoChunkInterval
+type demoViewer struct {
+	id      string
+	stream  string
+	quality string
+	loop    int // chunks before starting over, 0 to never wrap
+
+	chunks atomic.Int64
+	bytes  atomic.Int64
+	errors atomic.Int64
+}
+
+func (v *demoViewer) run(ctx context.Context, serverURL string) {
+	client, transport := newDemoClient()
+	defer transport.Close()
+
+	ticker := time.NewTicker(demoChunkInterval)
+	defer ticker.Stop()
+	for chunk := 0; ; chunk++ {
+		if v.loop > 0 {
+			chunk %= v.loop
+		}
+		url := fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=%d", serverURL, v.stream, v.quality, chunk)
+		if n, err := v.fetch(ctx, client, url); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			v.errors.Add(1)
+		} else {
+			v.chunks.Add(1)
+			v.bytes.Add(n)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (v *demoViewer) fetch(ctx context.Context, client *http.Client, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Session-ID", v.id)
+	req.Header.Set(streaming.ChunkIntervalHeader, demoChunkInterval.String())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, streaming.MaxChunkMessageSize))
+	if err != nil {
+		return n, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return n, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return n, nil
+}
+
+// printDemoSummary prints devices online, reading rate and per-viewer
+// bitrate over the window since the last summary, and returns the reading
+// total so far. lastBytes is updated in place.
+func printDemoSummary(elapsed, window, interval time.Duration, fleet []*demoDevice, audience []*demoViewer, lastReadings int64, lastBytes []int64) int64 {
+	online, readings, failures := 0, int64(0), int64(0)
+	for _, d := range fleet {
+		if d.online(interval) {
+			online++
+		}
+		readings += d.readings.Load()
+		failures += d.failures.Load()
+	}
+
+	secs := window.Seconds()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "[%v]\tdevices online %d/%d\treadings %.1f/s\tfailed %d\n",
+		elapsed.Round(time.Second), online, len(fleet), float64(readings-lastReadings)/secs, failures)
+	for i, v := range audience {
+		total := v.bytes.Load()
+		mbps := float64(total-lastBytes[i]) * 8 / secs / 1e6
+		lastBytes[i] = total
+		fmt.Fprintf(tw, "\t%s %s/%s\t%.2f Mbps\tchunks %d, errors %d\n",
+			v.id, v.stream, v.quality, mbps, v.chunks.Load(), v.errors.Load())
+	}
+	tw.Flush()
+	return readings
+}
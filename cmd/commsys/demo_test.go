@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs f and returns what it printed
+func captureStdout(t *testing.T, f func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		out <- string(data)
+	}()
+	err = f()
+	os.Stdout = stdout
+	w.Close()
+	return <-out, err
+}
+
+func TestDemoEndToEnd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the demo for seconds")
+	}
+	t.Setenv("CONFIG_FILE", "")
+	out, err := captureStdout(t, func() error {
+		return runDemo([]string{"-duration", "3s", "-devices", "3", "-viewers", "2", "-seed-streams", "1", "-interval", "200ms", "-log-level", "error"})
+	})
+	if err != nil {
+		t.Fatalf("demo failed: %v\n%s", err, out)
+	}
+	if !strings.HasSuffix(out, "Demo finished\n") {
+		t.Fatalf("demo didn't finish:\n%s", out)
+	}
+
+	// The last summary, printed when the duration is up
+	summaries := strings.Split(out, "\n[")
+	last := summaries[len(summaries)-1]
+	if !strings.Contains(last, "devices online 3/3") || !regexp.MustCompile(`failed 0\n`).MatchString(last) {
+		t.Errorf("devices not all online without failures:\n%s", out)
+	}
+	// One viewer of a built-in stream and one of the seeded stream
+	viewers := regexp.MustCompile(`(viewer_\d) (\S+)/\S+ .*chunks (\d+), errors (\d+)`).FindAllStringSubmatch(last, -1)
+	if len(viewers) != 2 {
+		t.Fatalf("%d viewers in the last summary, want 2:\n%s", len(viewers), out)
+	}
+	for i, stream := range []string{"stream_001", "seed_"} {
+		v := viewers[i]
+		chunks, _ := strconv.Atoi(v[3])
+		if !strings.HasPrefix(v[2], stream) || chunks == 0 || v[4] != "0" {
+			t.Errorf("%s watched %s: %s chunks, %s errors; want chunks of %s without errors", v[1], v[2], v[3], v[4], stream)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// Dashboard layout: panels per row of the Grafana grid and their size
+const (
+	panelsPerRow = 3
+	panelWidth   = 24 / panelsPerRow
+	panelHeight  = 8
+)
+
+func runMetrics(args []string) error {
+	if len(args) == 0 || args[0] != "dashboard" {
+		return fmt.Errorf("usage: commsys metrics dashboard [-o FILE] [-title TITLE]")
+	}
+
+	fs := flag.NewFlagSet("metrics dashboard", flag.ContinueOnError)
+	output := fs.String("o", "", "Write the dashboard to this file instead of stdout")
+	title := fs.String("title", "QUIC Communication System", "Dashboard title")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	infos, err := serverMetrics()
+	if err != nil {
+		return err
+	}
+	dashboard, err := dashboardJSON(*title, infos)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(dashboard)
+		return err
+	}
+	return os.WriteFile(*output, dashboard, 0o644)
+}
+
+// dashboardJSON returns the dashboard of infos as the indented JSON Grafana
+// imports
+func dashboardJSON(title string, infos []metrics.Info) ([]byte, error) {
+	dashboard, err := json.MarshalIndent(buildDashboard(title, infos), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(dashboard, '\n'), nil
+}
+
+// serverMetrics assembles the server without starting any listener and
+// returns the metrics it registers
+func serverMetrics() ([]metrics.Info, error) {
+	srv, err := server.New(config.Default(), logging.Nop())
+	if err != nil {
+		return nil, err
+	}
+	return srv.Registry().Metrics(), nil
+}
+
+// grafanaDashboard is the subset of the Grafana dashboard model the
+// generator fills in
+type grafanaDashboard struct {
+	UID           string         `json:"uid"`
+	Title         string         `json:"title"`
+	Tags          []string       `json:"tags"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Refresh       string         `json:"refresh"`
+	Time          grafanaRange   `json:"time"`
+	Templating    grafanaVars    `json:"templating"`
+	Panels        []grafanaPanel `json:"panels"`
+}
+
+type grafanaRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaVars struct {
+	List []grafanaVar `json:"list"`
+}
+
+type grafanaVar struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Query string `json:"query"`
+}
+
+type grafanaPanel struct {
+	ID          int               `json:"id"`
+	Type        string            `json:"type"`
+	Title       string            `json:"title"`
+	Description string            `json:"description,omitempty"`
+	GridPos     grafanaGridPos    `json:"gridPos"`
+	Datasource  *grafanaRef       `json:"datasource,omitempty"`
+	Targets     []grafanaTarget   `json:"targets,omitempty"`
+	FieldConfig *grafanaFieldConf `json:"fieldConfig,omitempty"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaRef struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Format       string `json:"format,omitempty"`
+}
+
+type grafanaFieldConf struct {
+	Defaults grafanaFieldDefaults `json:"defaults"`
+}
+
+type grafanaFieldDefaults struct {
+	Unit string `json:"unit"`
+}
+
+// buildDashboard lays out one row per subsystem with a panel per metric:
+// the per-second rate of counters, the current value of gauges and a
+// heatmap of histogram buckets
+func buildDashboard(title string, infos []metrics.Info) grafanaDashboard {
+	d := grafanaDashboard{
+		UID:           "commsys",
+		Title:         title,
+		Tags:          []string{"commsys", "generated"},
+		SchemaVersion: 39,
+		Refresh:       "30s",
+		Time:          grafanaRange{From: "now-1h", To: "now"},
+		Templating: grafanaVars{List: []grafanaVar{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		}},
+		Panels: []grafanaPanel{},
+	}
+	datasource := &grafanaRef{Type: "prometheus", UID: "${datasource}"}
+
+	y, subsystem, column := 0, "", 0
+	for _, info := range infos {
+		if info.Subsystem != subsystem {
+			if column > 0 {
+				y += panelHeight
+			}
+			subsystem, column = info.Subsystem, 0
+			d.Panels = append(d.Panels, grafanaPanel{
+				ID:      len(d.Panels) + 1,
+				Type:    "row",
+				Title:   subsystem,
+				GridPos: grafanaGridPos{X: 0, Y: y, W: 24, H: 1},
+			})
+			y++
+		}
+		if column == panelsPerRow {
+			y += panelHeight
+			column = 0
+		}
+
+		panel := metricPanel(info)
+		panel.ID = len(d.Panels) + 1
+		panel.GridPos = grafanaGridPos{X: column * panelWidth, Y: y, W: panelWidth, H: panelHeight}
+		panel.Datasource = datasource
+		d.Panels = append(d.Panels, panel)
+		column++
+	}
+	return d
+}
+
+// metricPanel returns the panel of one metric without its position
+func metricPanel(info metrics.Info) grafanaPanel {
+	by, legend := "", ""
+	if len(info.Labels) > 0 {
+		by = " by (" + strings.Join(info.Labels, ", ") + ")"
+		legend = "{{" + strings.Join(info.Labels, "}} {{") + "}}"
+	}
+	title := strings.TrimPrefix(info.Name, metrics.Namespace+"_"+info.Subsystem+"_")
+
+	switch info.Type {
+	case "counter":
+		return grafanaPanel{
+			Type:        "timeseries",
+			Title:       strings.TrimSuffix(title, "_total") + " per second",
+			Description: info.Help,
+			Targets: []grafanaTarget{{
+				RefID:        "A",
+				Expr:         fmt.Sprintf("sum%s (rate(%s[$__rate_interval]))", by, info.Name),
+				LegendFormat: legend,
+			}},
+			FieldConfig: &grafanaFieldConf{Defaults: grafanaFieldDefaults{Unit: counterUnit(info.Name)}},
+		}
+	case "histogram":
+		return grafanaPanel{
+			Type:        "heatmap",
+			Title:       title,
+			Description: info.Help,
+			Targets: []grafanaTarget{{
+				RefID:        "A",
+				Expr:         fmt.Sprintf("sum by (le) (rate(%s_bucket[$__rate_interval]))", info.Name),
+				LegendFormat: "{{le}}",
+				Format:       "heatmap",
+			}},
+		}
+	default:
+		return grafanaPanel{
+			Type:        "stat",
+			Title:       title,
+			Description: info.Help,
+			Targets: []grafanaTarget{{
+				RefID:        "A",
+				Expr:         fmt.Sprintf("sum%s (%s)", by, info.Name),
+				LegendFormat: legend,
+			}},
+			FieldConfig: &grafanaFieldConf{Defaults: grafanaFieldDefaults{Unit: "short"}},
+		}
+	}
+}
+
+// counterUnit picks the Grafana unit of a counter's rate from its name
+func counterUnit(name string) string {
+	switch {
+	case strings.HasSuffix(name, "_bytes_total"):
+		return "Bps"
+	case strings.HasSuffix(name, "_seconds_total"):
+		// Seconds per second, the share of time spent
+		return "percentunit"
+	default:
+		return "ops"
+	}
+}
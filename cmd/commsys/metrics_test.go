@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func TestDashboardGolden(t *testing.T) {
+	// A metric of each kind and unit, and a row with more metrics than fit
+	// on one line of the grid
+	infos := []metrics.Info{
+		{Name: "commsys_iot_devices_online", Subsystem: "iot", Type: "gauge", Help: "Devices online"},
+		{Name: "commsys_iot_readings_total", Subsystem: "iot", Type: "counter", Help: "Readings by sensor type", Labels: []string{"sensor_type"}},
+		{Name: "commsys_streaming_bytes_sent_total", Subsystem: "streaming", Type: "counter", Help: "Bytes sent by quality", Labels: []string{"quality"}},
+		{Name: "commsys_streaming_chunk_seconds", Subsystem: "streaming", Type: "histogram", Help: "Time to send a chunk"},
+		{Name: "commsys_streaming_delta_hold_seconds_total", Subsystem: "streaming", Type: "counter", Help: "Time delta chunks waited"},
+		{Name: "commsys_streaming_viewers", Subsystem: "streaming", Type: "gauge", Help: "Viewers by stream and quality", Labels: []string{"stream_id", "quality"}},
+	}
+	got, err := dashboardJSON("QUIC Communication System", infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "dashboard.golden.json")
+	if *update {
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v (run go test -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("dashboard differs from %s; run go test -update and review the diff:\n%s", golden, got)
+	}
+}
+
+func TestDashboardCoversServerMetrics(t *testing.T) {
+	infos, err := serverMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exprs := map[string]bool{}
+	for _, p := range buildDashboard("QUIC Communication System", infos).Panels {
+		for _, target := range p.Targets {
+			exprs[target.Expr] = true
+		}
+	}
+
+	// Metrics of every subsystem the assembled server registers, not only
+	// the ones the TCP server builds
+	for _, name := range []string{
+		"commsys_http_limit_violations_total",
+		"commsys_iot_devices_online",
+		"commsys_iot_sensor_readings_total",
+		"commsys_iot_commands_queued",
+		"commsys_iot_reconcile_attempts_total",
+		"commsys_quota_rejected_total",
+		"commsys_server_open_connections",
+		"commsys_streaming_bytes_sent_total",
+		"commsys_streaming_broadcast_viewers",
+		"commsys_streaming_unreliable_deliveries_total",
+	} {
+		found := false
+		for expr := range exprs {
+			if strings.Contains(expr, name+"[") || strings.Contains(expr, name+")") || strings.Contains(expr, name+"_bucket") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no panel for %s", name)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/internal/tcp"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// selftestDevice is the device ID used by the self-test
+const selftestDevice = "selftest_device"
+
+// selftestEnv is what each self-test check talks to
+type selftestEnv struct {
+	quicURL  string
+	tcpURL   string
+	adminURL string
+	quic     *http.Client
+	tcp      *http.Client
+	admin    *http.Client
+}
+
+// selftestCheck exercises one protocol path
+type selftestCheck struct {
+	name string
+	run  func(ctx context.Context, env *selftestEnv) error
+}
+
+var selftestChecks = []selftestCheck{
+	{"quic iot register", func(ctx context.Context, env *selftestEnv) error {
+		return checkRegister(ctx, env.quic, env.quicURL)
+	}},
+	{"quic iot reading", func(ctx context.Context, env *selftestEnv) error {
+		return checkReading(ctx, env.quic, env.quicURL)
+	}},
+	{"quic iot command", func(ctx context.Context, env *selftestEnv) error {
+		return checkCommand(ctx, env.quic, env.quicURL)
+	}},
+	{"quic stream list+chunk", func(ctx context.Context, env *selftestEnv) error {
+		return checkStream(ctx, env.quic, env.quicURL)
+	}},
+	{"tcp iot register", func(ctx context.Context, env *selftestEnv) error {
+		return checkRegister(ctx, env.tcp, env.tcpURL)
+	}},
+	{"tcp iot reading", func(ctx context.Context, env *selftestEnv) error {
+		return checkReading(ctx, env.tcp, env.tcpURL)
+	}},
+	{"tcp stream list+chunk", func(ctx context.Context, env *selftestEnv) error {
+		return checkStream(ctx, env.tcp, env.tcpURL)
+	}},
+	{"admin healthz", func(ctx context.Context, env *selftestEnv) error {
+		_, err := selftestGet(ctx, env.admin, env.adminURL+"/healthz")
+		return err
+	}},
+	{"admin quotas", func(ctx context.Context, env *selftestEnv) error {
+		body, err := selftestGet(ctx, env.admin, env.adminURL+"/api/quotas")
+		if err == nil && !json.Valid(body) {
+			err = errors.New("quota usage is not JSON")
+		}
+		return err
+	}},
+	{"admin metrics", func(ctx context.Context, env *selftestEnv) error {
+		body, err := selftestGet(ctx, env.admin, env.adminURL+"/metrics")
+		if err != nil {
+			return err
+		}
+		// Readings went over both transports by now
+		if !strings.Contains(string(body), "commsys_iot_sensor_readings_total") {
+			return errors.New("metrics lack commsys_iot_sensor_readings_total")
+		}
+		return nil
+	}},
+}
+
+// runSelftest starts every listener on ephemeral ports, runs a client
+// through each protocol path and reports pass or fail per path
+func runSelftest(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 10*time.Second, "Time allowed for the whole self-test")
+	logLevel := fs.String("log-level", "error", "Server log level")
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		return err
+	}
+	cfg.Logging.Level = *logLevel
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	env, stop, err := startSelftestServers(cfg, logger)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tRESULT\tTIME")
+	failed := 0
+	for _, check := range selftestChecks {
+		start := time.Now()
+		err := check.run(ctx, env)
+		result := "pass"
+		if err != nil {
+			result = "FAIL: " + err.Error()
+			failed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1fms\n", check.name, result, float64(time.Since(start).Microseconds())/1000)
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(selftestChecks))
+	}
+	return nil
+}
+
+// startSelftestServers starts the server of cmd/server, a TCP/TLS server
+// sharing its components and its admin API on ephemeral ports
+func startSelftestServers(cfg *config.Config, logger logging.Logger) (*selftestEnv, func(), error) {
+	tcpTLS, err := quiclib.NewTLSConfig(config.TLSConfig{}, "h2", "http/1.1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	var closers []func()
+	stop := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	fail := func(err error) (*selftestEnv, func(), error) {
+		stop()
+		return nil, nil, fmt.Errorf("failed to listen: %w", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	closers = append(closers, func() { conn.Close() })
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	closers = append(closers, func() { tcpListener.Close() })
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fail(err)
+	}
+	closers = append(closers, func() { adminListener.Close() })
+
+	cfg.Admin.Addr = adminListener.Addr().String()
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	tcpServer := tcp.NewServer(cfg, tcpTLS, logger.Named("tcp"), srv.TCPDeps())
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	srv.RunBackground(monitorCtx)
+	go func() {
+		if err := srv.Serve(conn); err != nil {
+			logger.Error("Server failed", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := tcpServer.Serve(tcpListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("TCP server failed", logging.Err(err))
+		}
+	}()
+	go func() {
+		if err := srv.Admin().Serve(adminListener); err != nil {
+			logger.Error("Admin server failed", logging.Err(err))
+		}
+	}()
+	closers = append(closers, stopMonitor, func() { tcpServer.Stop() }, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	insecure := &tls.Config{InsecureSkipVerify: true}
+	quicTransport := &http3.Transport{TLSClientConfig: insecure}
+	tcpTransport := &http.Transport{TLSClientConfig: insecure, ForceAttemptHTTP2: true}
+	closers = append(closers, func() { quicTransport.Close() }, tcpTransport.CloseIdleConnections)
+
+	env := &selftestEnv{
+		quicURL:  "https://" + conn.LocalAddr().String(),
+		tcpURL:   "https://" + tcpListener.Addr().String(),
+		adminURL: "http://" + adminListener.Addr().String(),
+		quic:     &http.Client{Transport: quicTransport, Timeout: 5 * time.Second},
+		tcp:      &http.Client{Transport: tcpTransport, Timeout: 5 * time.Second},
+		admin:    &http.Client{Timeout: 5 * time.Second},
+	}
+	return env, stop, nil
+}
+
+func checkRegister(ctx context.Context, client *http.Client, serverURL string) error {
+	var resp iot.RegisterResponse
+	if err := demoPost(ctx, client, serverURL+"/iot/register", iot.RegisterRequest{
+		DeviceID: selftestDevice, MinVersion: iot.MinProtocolVersion, MaxVersion: iot.MaxProtocolVersion,
+	}, nil, &resp); err != nil {
+		return err
+	}
+	if resp.Version != iot.MaxProtocolVersion {
+		return fmt.Errorf("negotiated version %d, want %d", resp.Version, iot.MaxProtocolVersion)
+	}
+	return nil
+}
+
+func checkReading(ctx context.Context, client *http.Client, serverURL string) error {
+	reading := iot.SensorData{
+		DeviceID:   selftestDevice,
+		SensorType: "temperature",
+		Value:      21.5,
+		Unit:       "celsius",
+		Timestamp:  time.Now(),
+		Quality:    "reliable",
+	}
+	header := map[string]string{"X-Device-ID": selftestDevice, iot.SeqHeader: "1"}
+	var resp iot.Response
+	if err := demoPost(ctx, client, serverURL+"/iot/sensor", reading, header, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "success" || resp.Seq != 1 {
+		return fmt.Errorf("unexpected response %+v", resp)
+	}
+	return nil
+}
+
+func checkCommand(ctx context.Context, client *http.Client, serverURL string) error {
+	cmd := iot.Command{DeviceID: selftestDevice, Action: "selftest", Priority: "low"}
+	header := map[string]string{"X-Device-ID": selftestDevice, iot.SeqHeader: "2"}
+	var resp iot.Response
+	if err := demoPost(ctx, client, serverURL+"/iot/command", cmd, header, &resp); err != nil {
+		return err
+	}
+	if resp.Status != "executed" || resp.CommandID == "" {
+		return fmt.Errorf("unexpected response %+v", resp)
+	}
+	return nil
+}
+
+func checkStream(ctx context.Context, client *http.Client, serverURL string) error {
+	body, err := selftestGet(ctx, client, serverURL+"/stream/list")
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Streams []streaming.StreamInfo `json:"streams"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return fmt.Errorf("invalid stream list: %w", err)
+	}
+	if len(list.Streams) == 0 || len(list.Streams[0].Bitrates) == 0 {
+		return errors.New("no streams in the catalog")
+	}
+	stream := list.Streams[0]
+	chunk, err := selftestGet(ctx, client, fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=0",
+		serverURL, stream.StreamID, stream.Bitrates[0].Quality))
+	if err != nil {
+		return err
+	}
+	if len(chunk) == 0 {
+		return errors.New("empty chunk")
+	}
+	return nil
+}
+
+func selftestGet(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, streaming.MaxChunkMessageSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return body, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"net/url"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// Reconnect attempts back off exponentially between these bounds
+const (
+	reconnectMin = time.Second
+	reconnectMax = 30 * time.Second
+)
+
+// flushBatch is the most buffered readings sent in one batch after a
+// reconnect
+const flushBatch = 100
+
+// readingBuffer is a ring of the readings taken while the server is
+// unreachable. Once full, each new reading displaces the oldest.
+type readingBuffer struct {
+	ring    []iot.SensorData
+	start   int // index of the oldest reading
+	n       int
+	dropped int
+}
+
+func newReadingBuffer(capacity int) *readingBuffer {
+	return &readingBuffer{ring: make([]iot.SensorData, capacity)}
+}
+
+// push adds a reading, dropping the oldest if the buffer is full
+func (b *readingBuffer) push(r iot.SensorData) {
+	if len(b.ring) == 0 {
+		b.dropped++
+		return
+	}
+	if b.n == len(b.ring) {
+		b.start = (b.start + 1) % len(b.ring)
+		b.n--
+		b.dropped++
+	}
+	b.ring[(b.start+b.n)%len(b.ring)] = r
+	b.n++
+}
+
+// peek returns up to max of the oldest readings without removing them
+func (b *readingBuffer) peek(max int) []iot.SensorData {
+	out := make([]iot.SensorData, 0, min(max, b.n))
+	for i := 0; i < b.n && i < max; i++ {
+		out = append(out, b.ring[(b.start+i)%len(b.ring)])
+	}
+	return out
+}
+
+// discard removes the n oldest readings, once they have been delivered
+func (b *readingBuffer) discard(n int) {
+	n = min(n, b.n)
+	b.start = (b.start + n) % max(len(b.ring), 1)
+	b.n -= n
+}
+
+func (b *readingBuffer) len() int {
+	return b.n
+}
+
+// backoff returns the delay before reconnect attempt n, counting from 0:
+// doubling from reconnectMin up to reconnectMax, with the upper half
+// randomized so that devices cut off together don't reconnect in lockstep
+func backoff(attempt int) time.Duration {
+	d := reconnectMax
+	if attempt < 16 {
+		d = min(reconnectMin<<attempt, reconnectMax)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// unreachable reports whether err means the request never got an answer
+// from the server, as opposed to an error response
+func unreachable(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
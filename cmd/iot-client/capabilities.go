@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// simulatedHardware is the hardware model a simulated device of each sensor
+// type claims, and whether it runs on battery
+var simulatedHardware = map[string]struct {
+	model   string
+	battery bool
+}{
+	"temperature": {"TH-200 climate node", true},
+	"humidity":    {"TH-200 climate node", true},
+	"motion":      {"PIR-40 motion sensor", true},
+	"pressure":    {"BP-10 barometer", false},
+	"light":       {"LX-5 light meter", false},
+}
+
+// simulatedCommands are the command actions every simulated device accepts
+var simulatedCommands = []string{"reboot", "calibrate", "set_interval", "identify"}
+
+// deviceInfo describes a simulated device of sensorType at registration
+func deviceInfo(sensorType, firmware string, labels labelFlags) iot.DeviceInfo {
+	hw, ok := simulatedHardware[sensorType]
+	if !ok {
+		hw.model = "generic sensor node"
+	}
+	return iot.DeviceInfo{
+		SensorType:      sensorType,
+		FirmwareVersion: firmware,
+		HardwareModel:   hw.model,
+		Commands:        simulatedCommands,
+		BatteryPowered:  hw.battery,
+		Labels:          labels,
+	}
+}
+
+// labelFlags collects repeated -label key=value flags
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	pairs := make([]string, 0, len(l))
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlags) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("label must be key=value, got %q", s)
+	}
+	l[k] = v
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// deviceClock is the clock readings are stamped with. It is off from the
+// real clock by -clock-skew, until corrected from the server's time.
+var deviceClock skewedClock
+
+// skewedClock simulates a device clock that is off by a fixed offset
+type skewedClock struct {
+	offset atomic.Int64 // nanoseconds ahead of the real clock
+}
+
+// Now returns the time on the device clock
+func (c *skewedClock) Now() time.Time {
+	return time.Now().Add(time.Duration(c.offset.Load()))
+}
+
+// Skew sets the clock off from the real one by offset
+func (c *skewedClock) Skew(offset time.Duration) {
+	c.offset.Store(int64(offset))
+}
+
+// Sync sets the clock to serverTime, as answered to a request sent at sent
+// and answered at received on the device clock, assuming the answer took
+// half the round trip
+func (c *skewedClock) Sync(serverTime, sent, received time.Time) {
+	rtt := received.Sub(sent)
+	off := received.Sub(serverTime.Add(rtt / 2))
+	if off.Abs() < time.Second {
+		return
+	}
+	c.offset.Add(-int64(off))
+	log.Printf("Device clock was %v off the server, corrected", off.Round(time.Millisecond))
+}
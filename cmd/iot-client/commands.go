@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// commandDurations is how long the simulated device takes to carry out each
+// action. A duration parameter, e.g. "10s", overrides it.
+var commandDurations = map[string]time.Duration{
+	"reboot":    3 * time.Second,
+	"calibrate": 5 * time.Second,
+}
+
+// commandRunner carries out the commands the device fetched, each in the
+// background, and reports on them to the server: acked when it starts,
+// completed when it's done. A cancel message stops a running command. A
+// sync_state command is passed on to resync, for the device to apply its
+// desired state again.
+type commandRunner struct {
+	client   *http.Client
+	deviceID string
+	resync   chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+}
+
+func newCommandRunner(client *http.Client, deviceID string) *commandRunner {
+	return &commandRunner{
+		client:   client,
+		deviceID: deviceID,
+		resync:   make(chan struct{}, 1),
+		running:  make(map[string]context.CancelFunc),
+	}
+}
+
+// run starts cmd, or stops the command a cancel message names
+func (c *commandRunner) run(serverAddr string, cmd iot.Command) {
+	if cmd.Action == iot.CancelAction {
+		id, _ := cmd.Parameters["command_id"].(string)
+		c.mu.Lock()
+		cancel, ok := c.running[id]
+		c.mu.Unlock()
+		if ok {
+			log.Printf("Cancelling command %s", id)
+			cancel()
+		}
+		return
+	}
+	log.Printf("Received command %s %s (priority %s): %v", cmd.ID, cmd.Action, cmd.Priority, cmd.Parameters)
+	if cmd.ID == "" {
+		return
+	}
+	if cmd.Action == iot.SyncStateAction {
+		select {
+		case c.resync <- struct{}{}:
+		default: // a resync is already due
+		}
+		if err := c.report(serverAddr, cmd.ID, iot.CommandCompleted, "desired state reapplied"); err != nil {
+			log.Printf("Command %s completion not reported: %v", cmd.ID, err)
+		}
+		return
+	}
+	took := commandDurations[cmd.Action]
+	if s, ok := cmd.Parameters["duration"].(string); ok {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			c.report(serverAddr, cmd.ID, iot.CommandFailed, "invalid duration "+s)
+			return
+		}
+		took = d
+	}
+	if err := c.report(serverAddr, cmd.ID, iot.CommandAcked, ""); err != nil {
+		log.Printf("Command %s not acknowledged: %v", cmd.ID, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), took)
+	c.mu.Lock()
+	c.running[cmd.ID] = cancel
+	c.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		c.mu.Lock()
+		delete(c.running, cmd.ID)
+		c.mu.Unlock()
+		if ctx.Err() == context.Canceled {
+			log.Printf("Command %s %s stopped", cmd.ID, cmd.Action)
+			return
+		}
+		if err := c.report(serverAddr, cmd.ID, iot.CommandCompleted, fmt.Sprintf("%s done in %v", cmd.Action, took)); err != nil {
+			log.Printf("Command %s completion not reported: %v", cmd.ID, err)
+			return
+		}
+		log.Printf("Command %s %s completed", cmd.ID, cmd.Action)
+	}()
+}
+
+// report tells the server the status of a command
+func (c *commandRunner) report(serverAddr, id, status, message string) error {
+	body, err := json.Marshal(iot.CommandReport{CommandID: id, DeviceID: c.deviceID, Status: status, Message: message})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/commands/status", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", c.deviceID)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
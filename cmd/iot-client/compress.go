@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/nik1740/quic-communication-system/internal/iot/compress"
+)
+
+// compression is the codec choice of the device, set by -compress and
+// agreed on at registration
+var compression codecChoice
+
+// codecChoice holds the codecs the device offers and the one the server
+// picked
+type codecChoice struct {
+	mu      sync.Mutex
+	offered []string
+	picked  string
+}
+
+// parseCodecs parses the comma-separated codecs of -compress
+func parseCodecs(s string) ([]string, error) {
+	var codecs []string
+	for _, codec := range strings.Split(s, ",") {
+		codec = strings.TrimSpace(codec)
+		if !compress.Supported(codec) {
+			return nil, fmt.Errorf("unknown codec %q, supported: %s", codec, strings.Join(compress.Codecs, ", "))
+		}
+		codecs = append(codecs, codec)
+	}
+	return codecs, nil
+}
+
+// Offer sets the codecs offered at registration, preferred first
+func (c *codecChoice) Offer(codecs []string) {
+	c.mu.Lock()
+	c.offered = codecs
+	c.mu.Unlock()
+}
+
+// Offered returns the codecs offered at registration
+func (c *codecChoice) Offered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offered
+}
+
+// Use records the codec the server picked at registration, "" for none
+func (c *codecChoice) Use(codec string) {
+	c.mu.Lock()
+	changed := codec != c.picked
+	c.picked = codec
+	c.mu.Unlock()
+	if changed && codec != "" {
+		log.Printf("Compressing requests and responses with %s", codec)
+	} else if changed {
+		log.Printf("Server declined compression, sending plain bodies")
+	}
+}
+
+// Picked returns the codec the server picked
+func (c *codecChoice) Picked() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.picked
+}
+
+// uncompressedPaths carry binary bodies or byte ranges, which the server
+// never compresses
+var uncompressedPaths = []string{"/iot/upload", "/iot/firmware", "/iot/datagrams"}
+
+// compressTransport compresses request bodies in the codec the server
+// picked, when that makes them shorter, and asks for compressed responses. Until the server has picked
+// one, requests go plain and responses may come in any codec offered.
+type compressTransport struct {
+	base interface {
+		http.RoundTripper
+		CloseIdleConnections()
+	}
+}
+
+func (t compressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for _, path := range uncompressedPaths {
+		if strings.HasPrefix(req.URL.Path, path) {
+			return t.base.RoundTrip(req)
+		}
+	}
+	req = req.Clone(req.Context())
+	picked := compression.Picked()
+	accept := compression.Offered()
+	if picked != "" {
+		accept = []string{picked}
+	}
+	req.Header.Set("Accept-Encoding", strings.Join(accept, ", "))
+	if picked != "" && req.Body != nil && req.Body != http.NoBody {
+		body, compressed, err := compressBody(picked, req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+		req.ContentLength = int64(len(body))
+		if compressed {
+			req.Header.Set("Content-Encoding", picked)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	codec := resp.Header.Get("Content-Encoding")
+	if codec == "" || !compress.Supported(codec) {
+		return resp, nil
+	}
+	resp.Body = &decodedBody{codec: codec, raw: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+func (t compressTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// compressBody reads body to its end and returns it compressed with codec,
+// or as it is if compressing doesn't make it shorter, as with short readings
+func compressBody(codec string, body io.ReadCloser) ([]byte, bool, error) {
+	plain, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, false, err
+	}
+	var buf bytes.Buffer
+	w, err := compress.NewWriter(codec, &buf)
+	if err != nil {
+		return nil, false, err
+	}
+	w.Write(plain)
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(plain) {
+		return plain, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// decodedBody decompresses a response body. The decoder starts at the first
+// read, as gzip reads its header at once and a stream may not have sent it
+// yet.
+type decodedBody struct {
+	codec string
+	raw   io.ReadCloser
+	r     io.ReadCloser
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	if b.r == nil {
+		r, err := compress.NewReader(b.codec, b.raw)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s response: %w", b.codec, err)
+		}
+		b.r = r
+	}
+	return b.r.Read(p)
+}
+
+func (b *decodedBody) Close() error {
+	if b.r != nil {
+		b.r.Close()
+	}
+	return b.raw.Close()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// datagramFlow sends sensor readings as HTTP/3 datagrams on their own QUIC
+// connection. Registration, commands and everything else stay on the
+// reliable client.
+type datagramFlow struct {
+	conn *quic.Conn
+	str  *http3.RequestStream
+	seq  uint32
+
+	sent    int
+	dropped int // too large for a datagram
+}
+
+// openDatagramFlow connects to serverAddr over HTTP/3 and opens a datagram
+// flow at iot.DatagramPath
+func openDatagramFlow(serverAddr, deviceID, token string, version int) (*datagramFlow, error) {
+	u, err := url.Parse(serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, u.Host, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{http3.NextProtoH3},
+	}, &quic.Config{EnableDatagrams: true, KeepAlivePeriod: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	fail := func(err error) (*datagramFlow, error) {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+
+	cc := (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn)
+	select {
+	case <-cc.ReceivedSettings():
+	case <-ctx.Done():
+		return fail(fmt.Errorf("no HTTP/3 settings from server: %w", ctx.Err()))
+	}
+	if !cc.Settings().EnableDatagrams {
+		return fail(errors.New("server does not support HTTP datagrams"))
+	}
+
+	str, err := cc.OpenRequestStream(ctx)
+	if err != nil {
+		return fail(fmt.Errorf("failed to open stream: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverAddr+iot.DatagramPath, nil)
+	if err != nil {
+		return fail(fmt.Errorf("failed to create request: %w", err))
+	}
+	req.Header.Set("X-Device-ID", deviceID)
+	if token != "" {
+		req.Header.Set(iot.TokenHeader, token)
+	}
+	req.Header.Set(iot.VersionHeader, strconv.Itoa(version))
+	if err := str.SendRequestHeader(req); err != nil {
+		return fail(fmt.Errorf("failed to send request: %w", err))
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		return fail(fmt.Errorf("failed to read response: %w", err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fail(fmt.Errorf("server returned status %d", resp.StatusCode))
+	}
+	return &datagramFlow{conn: conn, str: str}, nil
+}
+
+// send sends one reading. Readings too large for a datagram are dropped and
+// counted; delivery of the others is not confirmed.
+func (f *datagramFlow) send(data SensorData) error {
+	b, err := iot.EncodeDatagram(f.seq, iot.SensorData(data))
+	if err != nil {
+		return fmt.Errorf("failed to encode reading: %w", err)
+	}
+	f.seq++
+
+	if len(b) > iot.MaxDatagramSize {
+		f.dropped++
+		return nil
+	}
+	var tooLarge *quic.DatagramTooLargeError
+	if err := f.str.SendDatagram(b); errors.As(err, &tooLarge) {
+		f.dropped++
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to send datagram: %w", err)
+	}
+	f.sent++
+	return nil
+}
+
+// close ends the flow and the connection
+func (f *datagramFlow) close() {
+	f.str.Close()
+	f.conn.CloseWithError(0, "")
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// updateFirmware takes the firmware update the server offers and returns
+// the installed version. Chunks are appended to a partial file in the temp
+// directory, so a transfer cut off by a dropped connection or a restart
+// resumes after the last whole chunk. Offers that aren't newer than current
+// are declined.
+func updateFirmware(client *http.Client, serverAddr, deviceID, current string) (string, error) {
+	var offer iot.FirmwareOffer
+	if err := getJSON(client, serverAddr+"/iot/firmware", deviceID, &offer); err != nil {
+		return "", fmt.Errorf("fetch firmware offer: %w", err)
+	}
+	if compareVersions(offer.Version, current) <= 0 {
+		reason := fmt.Sprintf("downgrade from %s to %s", current, offer.Version)
+		if offer.Version == current {
+			reason = "version " + current + " is already installed"
+		}
+		log.Printf("Declining firmware %s: %s", offer.Version, reason)
+		return "", reportFirmware(client, serverAddr, iot.FirmwareResult{DeviceID: deviceID, Version: offer.Version, Status: iot.FirmwareRejected, Reason: reason})
+	}
+
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("iot-firmware-%s-%s", deviceID, offer.Version))
+	f, err := os.OpenFile(path+".part", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	// Only whole chunks count; a chunk cut off mid-write is fetched again
+	next := int(min(info.Size()/offer.ChunkSize, int64(offer.Chunks)))
+	if err := f.Truncate(int64(next) * offer.ChunkSize); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return "", err
+	}
+	if next > 0 {
+		log.Printf("Resuming firmware %s at chunk %d of %d", offer.Version, next, offer.Chunks)
+	} else {
+		log.Printf("Downloading firmware %s: %d bytes in %d chunks", offer.Version, offer.Size, offer.Chunks)
+	}
+
+	for n := next; n < offer.Chunks; n++ {
+		if err := fetchChunk(client, serverAddr, deviceID, offer.Version, n, f); err != nil {
+			return "", fmt.Errorf("chunk %d: %w", n, err)
+		}
+	}
+
+	// Verify the whole image, as written to disk
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != offer.SHA256 {
+		f.Close()
+		os.Remove(path + ".part")
+		log.Printf("Firmware %s failed verification: SHA-256 %s, expected %s", offer.Version, sum, offer.SHA256)
+		return "", reportFirmware(client, serverAddr, iot.FirmwareResult{DeviceID: deviceID, Version: offer.Version, Status: iot.FirmwareHashMismatch,
+			Reason: "received image has SHA-256 " + sum})
+	}
+	f.Close()
+	if err := os.Rename(path+".part", path+".bin"); err != nil {
+		return "", err
+	}
+	if err := reportFirmware(client, serverAddr, iot.FirmwareResult{DeviceID: deviceID, Version: offer.Version, Status: iot.FirmwareInstalled}); err != nil {
+		return "", err
+	}
+	log.Printf("Installed firmware %s (%s)", offer.Version, path+".bin")
+	return offer.Version, nil
+}
+
+// fetchChunk appends chunk n of the offered image to w
+func fetchChunk(client *http.Client, serverAddr, deviceID, version string, n int, w io.Writer) error {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/iot/firmware/chunks/%d?version=%s", serverAddr, n, url.QueryEscape(version)), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Device-ID", deviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get(iot.FirmwareChunkHeader); got != strconv.Itoa(n) {
+		return fmt.Errorf("server sent chunk %q", got)
+	}
+	// A chunk is written only once it has arrived in full
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// reportFirmware sends the outcome of a firmware update
+func reportFirmware(client *http.Client, serverAddr string, result iot.FirmwareResult) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/firmware/result", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", result.DeviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("firmware result returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// getJSON decodes the JSON answer to a GET request of the device
+func getJSON(client *http.Client, target, deviceID string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Device-ID", deviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(v)
+}
+
+// compareVersions compares dotted versions such as 1.10.2 numerically part
+// by part, falling back to comparing parts as strings. Missing parts count
+// as 0.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, errX := strconv.Atoi(x)
+		yn, errY := strconv.Atoi(y)
+		switch {
+		case errX == nil && errY == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (errX != nil || errY != nil) && x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// sensorTypes are the sensor types a simulated device can have
+var sensorTypes = []string{"temperature", "humidity", "motion", "pressure", "light"}
+
+// sensorShare is a sensor type's weight in a fleet's type mix
+type sensorShare struct {
+	sensorType string
+	weight     int
+}
+
+// parseTypeMix parses a type mix such as "temperature:50,humidity:30".
+// Weights are relative and need not add up to 100.
+func parseTypeMix(s string) ([]sensorShare, error) {
+	var mix []sensorShare
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not type:weight", part)
+		}
+		known := false
+		for _, t := range sensorTypes {
+			known = known || t == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown sensor type %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sensor type %q listed twice", name)
+		}
+		seen[name] = true
+		w, err := strconv.Atoi(weight)
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("weight of %s must be a positive integer", name)
+		}
+		mix = append(mix, sensorShare{name, w})
+	}
+	return mix, nil
+}
+
+// pickType returns the sensor type of device i of n, so that the fleet
+// matches the mix as closely as its size allows
+func pickType(mix []sensorShare, i, n int) string {
+	total := 0
+	for _, s := range mix {
+		total += s.weight
+	}
+	// The middle of the device's slot of the total weight
+	pos := (2*i + 1) * total / (2 * n)
+	for _, s := range mix {
+		if pos < s.weight {
+			return s.sensorType
+		}
+		pos -= s.weight
+	}
+	return mix[len(mix)-1].sensorType
+}
+
+// fleetConfig is how a simulated fleet connects and reports
+type fleetConfig struct {
+	serverAddr    string
+	protocol      string // quic or tcp
+	prefix        string
+	devices       int
+	connPerDevice bool
+	mix           []sensorShare
+	token         string
+	firmware      string
+	labels        labelFlags // of every device
+	maxVersion    int
+	interval      time.Duration
+	duration      time.Duration
+	statsInterval time.Duration
+}
+
+// fleetStats counts the activity of every virtual device
+type fleetStats struct {
+	registered atomic.Int64
+	sent       atomic.Int64
+	errors     atomic.Int64
+	reconnects atomic.Int64
+}
+
+// fleetReport is a JSON snapshot of fleetStats
+type fleetReport struct {
+	Devices    int    `json:"devices"`
+	Registered int64  `json:"registered"` // devices currently registered
+	Sent       int64  `json:"messages_sent"`
+	Errors     int64  `json:"errors"`
+	Reconnects int64  `json:"reconnects"`
+	Elapsed    string `json:"elapsed"`
+	Final      bool   `json:"final,omitempty"`
+}
+
+func (s *fleetStats) print(devices int, elapsed time.Duration, final bool) {
+	out, _ := json.Marshal(fleetReport{
+		Devices:    devices,
+		Registered: s.registered.Load(),
+		Sent:       s.sent.Load(),
+		Errors:     s.errors.Load(),
+		Reconnects: s.reconnects.Load(),
+		Elapsed:    elapsed.Round(time.Millisecond).String(),
+		Final:      final,
+	})
+	fmt.Println(string(out))
+}
+
+// fleetTransport is an HTTP/3 transport for quic, and an HTTP/2 one for tcp.
+// Either multiplexes all requests to a server over one connection.
+func fleetTransport(protocol string) interface {
+	http.RoundTripper
+	CloseIdleConnections()
+} {
+	if protocol == "quic" {
+		return quiclib.NewClientTransport(config.QUICConfig{}, nil)
+	}
+	return &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}
+}
+
+// runFleet simulates cfg.devices devices from one process. They share one
+// connection unless cfg.connPerDevice is set. Virtual devices only register
+// and send readings; stats go to stdout as JSON lines every statsInterval
+// and when the run ends.
+func runFleet(cfg fleetConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	newClient := func() (*http.Client, func()) {
+		transport := fleetTransport(cfg.protocol)
+		if compression.Offered() != nil {
+			transport = compressTransport{transport}
+		}
+		c := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+		if cfg.token != "" {
+			c.Transport = tokenTransport{transport, cfg.token}
+		}
+		return c, transport.CloseIdleConnections
+	}
+	var shared *http.Client
+	var closers []func()
+	if !cfg.connPerDevice {
+		var closeShared func()
+		shared, closeShared = newClient()
+		closers = append(closers, closeShared)
+	}
+
+	var stats fleetStats
+	pacer := client.NewPacer(0)
+	start := time.Now()
+	width := len(strconv.Itoa(cfg.devices))
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.devices; i++ {
+		d := &virtualDevice{
+			id:         fmt.Sprintf("%s-%0*d", cfg.prefix, width, i+1),
+			sensorType: pickType(cfg.mix, i, cfg.devices),
+			client:     shared,
+			cfg:        &cfg,
+			stats:      &stats,
+			pacer:      pacer,
+			numbers:    newReadingNumbers(),
+		}
+		if cfg.connPerDevice {
+			d.client, d.reset = newClient()
+			closers = append(closers, d.reset)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.run(ctx)
+		}()
+	}
+
+	ticker := time.NewTicker(cfg.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats.print(cfg.devices, time.Since(start), false)
+		case <-ctx.Done():
+			wg.Wait()
+			// close the connections, so the server ends the devices'
+			// sessions now rather than at its idle timeout
+			for _, closeConns := range closers {
+				closeConns()
+			}
+			stats.print(cfg.devices, time.Since(start), true)
+			return
+		}
+	}
+}
+
+// virtualDevice is one simulated device of a fleet
+type virtualDevice struct {
+	id         string
+	sensorType string
+	client     *http.Client
+	reset      func() // drops the device's own connection, nil when shared
+	cfg        *fleetConfig
+	stats      *fleetStats
+	pacer      *client.Pacer // shared, as the fleet never uploads
+
+	version int
+	seq     uint64
+	numbers *readingNumbers
+	head    []byte // the reading's JSON up to the value, the same every tick
+	body    []byte // reused for every reading
+}
+
+// run registers the device after a random phase offset, so that the fleet's
+// readings spread over the interval, and reports until ctx is done. A device
+// that loses the server registers again.
+func (d *virtualDevice) run(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(time.Duration(rand.Int63n(int64(d.cfg.interval)))):
+	}
+	for d.register(ctx) {
+		d.report(ctx)
+		d.stats.registered.Add(-1)
+		if ctx.Err() != nil {
+			return
+		}
+		d.stats.reconnects.Add(1)
+		if d.reset != nil {
+			d.reset()
+		}
+	}
+}
+
+// report sends a reading every interval until ctx is done or the server
+// can't be reached
+func (d *virtualDevice) report(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := d.send(ctx)
+		switch {
+		case err == nil:
+			d.stats.sent.Add(1)
+		case ctx.Err() != nil:
+			return
+		default:
+			d.stats.errors.Add(1)
+			if unreachable(err) {
+				return
+			}
+		}
+	}
+}
+
+// register registers the device, retrying with backoff until it succeeds
+// or ctx is done
+func (d *virtualDevice) register(ctx context.Context) bool {
+	for attempt := 0; ; attempt++ {
+		version, err := register(d.client, d.cfg.serverAddr, d.id, d.cfg.token, deviceInfo(d.sensorType, d.cfg.firmware, d.cfg.labels), d.numbers.epoch, d.cfg.maxVersion, d.pacer)
+		if err == nil {
+			d.version, d.seq = version, 0
+			d.stats.registered.Add(1)
+			return true
+		}
+		d.stats.errors.Add(1)
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+// send posts one reading. The JSON is appended to the device's own buffer,
+// so a tick allocates no encoder.
+func (d *virtualDevice) send(ctx context.Context) error {
+	data := generateSensorData(d.id, d.sensorType)
+	d.numbers.stamp(&data)
+	if d.head == nil {
+		d.head = readingHead(data)
+	}
+	d.body = append(d.body[:0], d.head...)
+	d.body = strconv.AppendFloat(d.body, data.Value, 'f', -1, 64)
+	d.body = append(d.body, `,"timestamp":"`...)
+	d.body = data.Timestamp.AppendFormat(d.body, time.RFC3339Nano)
+	d.body = append(d.body, `","seq":`...)
+	d.body = strconv.AppendUint(d.body, data.Seq, 10)
+	d.body = append(d.body, `,"epoch":`...)
+	d.body = strconv.AppendUint(d.body, data.Epoch, 10)
+	d.body = append(d.body, '}')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.cfg.serverAddr+"/iot/sensor", bytes.NewReader(d.body))
+	if err != nil {
+		return err
+	}
+	d.seq++
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", d.id)
+	req.Header.Set("X-Sensor-Type", d.sensorType)
+	req.Header.Set(iot.VersionHeader, strconv.Itoa(d.version))
+	req.Header.Set(iot.IntervalHeader, d.cfg.interval.String())
+	req.Header.Set(iot.SeqHeader, strconv.FormatUint(d.seq, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// readingHead encodes the fields of a device's readings that don't change
+// between ticks, leaving the value, timestamp and numbering to be appended
+func readingHead(data SensorData) []byte {
+	head, _ := json.Marshal(struct {
+		DeviceID   string `json:"device_id"`
+		SensorType string `json:"sensor_type"`
+		Unit       string `json:"unit"`
+		Quality    string `json:"quality"`
+	}{data.DeviceID, data.SensorType, data.Unit, data.Quality})
+	return append(head[:len(head)-1], `,"value":`...)
+}
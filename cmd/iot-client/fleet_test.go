@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+func TestParseTypeMix(t *testing.T) {
+	mix, err := parseTypeMix("temperature:50, humidity:30,motion:20")
+	want := []sensorShare{{"temperature", 50}, {"humidity", 30}, {"motion", 20}}
+	if err != nil || len(mix) != len(want) {
+		t.Fatalf("got %v, %v, want %v", mix, err, want)
+	}
+	for i := range want {
+		if mix[i] != want[i] {
+			t.Errorf("share %d is %v, want %v", i, mix[i], want[i])
+		}
+	}
+
+	for _, tt := range []struct{ mix, want string }{
+		{"temperature", "not type:weight"},
+		{"co2:10", "unknown sensor type"},
+		{"light:1,light:2", "listed twice"},
+		{"light:0", "positive integer"},
+		{"light:x", "positive integer"},
+	} {
+		if _, err := parseTypeMix(tt.mix); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%q: error %v, want %q", tt.mix, err, tt.want)
+		}
+	}
+}
+
+func TestPickTypeMatchesMix(t *testing.T) {
+	mix := []sensorShare{{"temperature", 5}, {"humidity", 3}, {"motion", 2}}
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		counts[pickType(mix, i, 100)]++
+	}
+	if counts["temperature"] != 50 || counts["humidity"] != 30 || counts["motion"] != 20 {
+		t.Errorf("100 devices got types %v, want 50, 30 and 20", counts)
+	}
+}
+
+// TestVirtualDeviceReadingsAccepted checks that the readings a virtual
+// device builds by hand are valid readings of every sensor type
+func TestVirtualDeviceReadingsAccepted(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Validation.Enabled = true
+	srv, h := newIoTServer(t, cfg)
+	fleet := &fleetConfig{serverAddr: srv.URL, interval: time.Second, maxVersion: iot.MaxProtocolVersion}
+
+	const readings = 3
+	for _, sensorType := range sensorTypes {
+		d := &virtualDevice{
+			id:         "fleet-" + sensorType,
+			sensorType: sensorType,
+			client:     srv.Client(),
+			cfg:        fleet,
+			stats:      &fleetStats{},
+			pacer:      client.NewPacer(0),
+			numbers:    newReadingNumbers(),
+		}
+		if !d.register(context.Background()) {
+			t.Fatalf("%s: registration failed", d.id)
+		}
+		for i := 0; i < readings; i++ {
+			if err := d.send(context.Background()); err != nil {
+				t.Errorf("%s: reading %d: %v", d.id, i+1, err)
+			}
+		}
+	}
+
+	s := h.Stats()
+	if want := int64(readings * len(sensorTypes)); s.Readings.Accepted != want || s.Readings.Dropped != 0 {
+		t.Errorf("got %d readings accepted and %d dropped, want %d and none", s.Readings.Accepted, s.Readings.Dropped, want)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// errHealthUnsupported is returned by servers that don't take health
+// reports
+var errHealthUnsupported = errors.New("health reports not supported")
+
+// deviceHealth simulates the health of a device: a battery that drains a
+// little with every report, a wandering signal strength and free memory
+type deviceHealth struct {
+	battery *float64 // nil when the device isn't on battery
+	rssi    int
+	started time.Time
+}
+
+// newDeviceHealth simulates a device of sensorType whose battery, if it has
+// one, starts at battery percent
+func newDeviceHealth(sensorType string, battery float64) *deviceHealth {
+	h := &deviceHealth{rssi: -60, started: time.Now()}
+	if simulatedHardware[sensorType].battery {
+		h.battery = &battery
+	}
+	return h
+}
+
+// next returns the health report of deviceID after another interval
+func (h *deviceHealth) next(deviceID string) iot.HealthReport {
+	if h.battery != nil {
+		*h.battery = max(*h.battery-0.2-rand.Float64()*0.3, 0)
+	}
+	h.rssi = min(max(h.rssi+rand.Intn(7)-3, -95), -40)
+	report := iot.HealthReport{
+		DeviceID:   deviceID,
+		RSSI:       h.rssi,
+		Uptime:     int64(time.Since(h.started).Seconds()),
+		FreeMemory: 48*1024 + rand.Int63n(16*1024),
+	}
+	if h.battery != nil {
+		battery := *h.battery
+		report.Battery = &battery
+	}
+	return report
+}
+
+// sendHealth posts a health report
+func sendHealth(client *http.Client, serverAddr string, report iot.HealthReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/health", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", report.DeviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return errHealthUnsupported
+	default:
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// heartbeater tells the server the device is alive. Unless an interval was
+// given, it sends heartbeats as often as the server asks for in each
+// response. It can correct the device clock from the server time in the
+// responses.
+type heartbeater struct {
+	client    *http.Client
+	deviceID  string
+	fixed     time.Duration // interval given by the user, 0 to follow the server
+	syncClock bool
+
+	mu     sync.Mutex
+	server string
+}
+
+func newHeartbeater(client *http.Client, serverAddr, deviceID string, interval time.Duration, syncClock bool) *heartbeater {
+	return &heartbeater{client: client, server: serverAddr, deviceID: deviceID, fixed: interval, syncClock: syncClock}
+}
+
+// SetServer sends later heartbeats to serverAddr, e.g. after a migration
+func (hb *heartbeater) SetServer(serverAddr string) {
+	hb.mu.Lock()
+	hb.server = serverAddr
+	hb.mu.Unlock()
+}
+
+// Run sends a heartbeat right away and then at the negotiated interval until
+// ctx is done. It stops if the server doesn't take heartbeats.
+func (hb *heartbeater) Run(ctx context.Context) {
+	interval := hb.fixed
+	if interval == 0 {
+		interval = 30 * time.Second // until the server says otherwise
+	}
+	for {
+		next, err := hb.send(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case errors.Is(err, errHeartbeatsUnsupported):
+			log.Printf("Server doesn't take heartbeats, not sending any")
+			return
+		case err != nil:
+			log.Printf("Heartbeat failed: %v", err)
+		case hb.fixed == 0 && next != interval:
+			log.Printf("Server expects heartbeats every %v", next)
+			interval = next
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+var errHeartbeatsUnsupported = errors.New("heartbeats not supported")
+
+// send sends one heartbeat and returns the interval the server expects
+func (hb *heartbeater) send(ctx context.Context) (time.Duration, error) {
+	hb.mu.Lock()
+	server := hb.server
+	hb.mu.Unlock()
+
+	body, err := json.Marshal(iot.HeartbeatRequest{DeviceID: hb.deviceID})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server+"/iot/heartbeat", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", hb.deviceID)
+	sent := deviceClock.Now()
+	resp, err := hb.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, errHeartbeatsUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return 0, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	var result iot.HeartbeatResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid heartbeat response: %w", err)
+	}
+	interval, err := time.ParseDuration(result.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid heartbeat interval %q", result.Interval)
+	}
+	if hb.syncClock && !result.ServerTime.IsZero() {
+		deviceClock.Sync(result.ServerTime, sent, deviceClock.Now())
+	}
+	return interval, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestHeartbeatFollowsServerInterval(t *testing.T) {
+	presence := iot.NewPresence(config.HeartbeatConfig{Interval: 5 * time.Second, Timeout: 15 * time.Second},
+		nil, logging.Nop(), clock.Real(), metrics.NewRegistry())
+	srv, _ := newIoTServer(t, config.Default(), iot.WithPresence(presence))
+
+	hb := newHeartbeater(srv.Client(), srv.URL, "dev1", 0, false)
+	interval, err := hb.send(context.Background())
+	if err != nil || interval != 5*time.Second {
+		t.Fatalf("heartbeat: interval %v, %v, want the server's 5s", interval, err)
+	}
+	list := presence.List()
+	if len(list) != 1 || list[0].DeviceID != "dev1" || !list[0].Online {
+		t.Errorf("got presence %+v, want dev1 online", list)
+	}
+}
+
+func TestHeartbeatStopsWhenUnsupported(t *testing.T) {
+	srv, _ := newIoTServer(t, config.Default())
+	hb := newHeartbeater(srv.Client(), srv.URL, "dev1", time.Millisecond, false)
+
+	done := make(chan struct{})
+	go func() {
+		hb.Run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("heartbeats are still sent to a server without the endpoint")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newIoTServer serves an IoT handler with cfg
+func newIoTServer(t *testing.T, cfg *config.Config, opts ...iot.Option) (*httptest.Server, *iot.Handler) {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	h := iot.NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), opts...)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv, h
+}
@@ -2,36 +2,150 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/pkg/config"
 )
 
 // SensorData represents sensor readings
 type SensorData struct {
-	DeviceID    string    `json:"device_id"`
-	SensorType  string    `json:"sensor_type"`
-	Value       float64   `json:"value"`
-	Unit        string    `json:"unit"`
-	Timestamp   time.Time `json:"timestamp"`
-	Quality     string    `json:"quality"`
+	DeviceID        string     `json:"device_id"`
+	SensorType      string     `json:"sensor_type"`
+	Value           float64    `json:"value"`
+	Unit            string     `json:"unit"`
+	Timestamp       time.Time  `json:"timestamp"`
+	DeviceTimestamp *time.Time `json:"device_timestamp,omitempty"` // set by the server when it corrected Timestamp
+	Quality         string     `json:"quality"`
+	Seq             uint64     `json:"seq,omitempty"`
+	Epoch           uint64     `json:"epoch,omitempty"`
 }
 
 func main() {
 	var (
 		serverAddr   = flag.String("server", "https://localhost:8443", "Server address")
 		deviceID     = flag.String("device", "iot_client_001", "Device ID")
-		sensorType   = flag.String("sensor", "temperature", "Sensor type (temperature, humidity, motion)")
+		sensorType   = flag.String("sensor", "temperature", "Sensor type (temperature, humidity, motion, pressure, light)")
 		interval     = flag.Duration("interval", 5*time.Second, "Data transmission interval")
 		duration     = flag.Duration("duration", 60*time.Second, "Total runtime duration")
 		protocol     = flag.String("protocol", "quic", "Protocol to use (quic or tcp)")
+		pingInterval = flag.Duration("ping-interval", 10*time.Second, "Application ping interval")
+		pingMisses   = flag.Int("ping-misses", 3, "Missed pings before reconnecting")
+		maxVersion   = flag.Int("max-version", iot.MaxProtocolVersion, "Highest IoT protocol version to offer")
+		uploadEvery  = flag.Duration("upload-interval", 0, "Interval between generated camera snapshot uploads (0 disables)")
+		batchSize    = flag.Int("batch", 0, "Readings per batch request (0 sends each reading on its own)")
+		batchEvery   = flag.Duration("batch-interval", 0, "Send a partial batch after this long (0 waits for a full batch)")
+		precision    = flag.Float64("delta-precision", 0.01, "Value precision of delta-encoded batches")
+		unreliable   = flag.Bool("unreliable", false, "Send readings as HTTP/3 datagrams instead of requests")
+		token        = flag.String("token", "", "Device token, for servers that set iot.device_tokens")
+		heartbeat    = flag.Duration("heartbeat", 0, "Heartbeat interval (0 uses the interval the server asks for)")
+		maxBuffer    = flag.Int("max-buffer", 1000, "Readings kept while the server is unreachable; the oldest are dropped when full")
+		firmware     = flag.String("firmware", "1.0.0", "Installed firmware version; offered updates to older or equal versions are declined")
+		devices      = flag.Int("devices", 0, "Simulate this many devices from one process (0 runs a single device)")
+		prefix       = flag.String("device-prefix", "device", "Prefix of the simulated device IDs, numbered from 1")
+		connPerDev   = flag.Bool("connection-per-device", false, "Give each simulated device its own connection instead of sharing one")
+		typeMix      = flag.String("type-mix", "", "Sensor types of the simulated devices by weight, e.g. temperature:50,humidity:30,motion:20 (default -sensor)")
+		statsEvery   = flag.Duration("stats-interval", 10*time.Second, "Interval between JSON stats lines of the simulated devices")
+		replay       = flag.String("replay", "", "Replay the readings of a CSV or JSON lines trace file instead of generating them")
+		speed        = flag.Float64("speed", 1, "Replay speed; 10 replays ten times as fast")
+		deviceMap    = flag.String("device-map", "", "Device IDs to replay trace devices as, e.g. sensor-7=dev-1,sensor-9=dev-2")
+		loop         = flag.Bool("loop", false, "Start the trace over at its end, until -duration has passed")
+		healthEvery  = flag.Int("health-every", 12, "Send a device health report every this many reporting intervals (0 disables)")
+		battery      = flag.Float64("battery", 100, "Starting battery percentage of battery-powered sensor types")
+		clockSkew    = flag.Duration("clock-skew", 0, "Simulate a device clock this far ahead of the real one (negative for behind)")
+		syncClock    = flag.Bool("sync-clock", false, "Correct the device clock from the server time of heartbeats")
+		compressFlag = flag.String("compress", "", "Codecs to offer the server for request and response bodies, preferred first, e.g. zstd,gzip (empty sends plain bodies)")
+		subscription = flag.String("subscribe", "", "Log the live readings selected by this query, e.g. device_id=dev-1&sensor_type=temperature, instead of sending any")
 	)
+	labels := labelFlags{}
+	flag.Var(labels, "label", "Label the device registers with, as key=value (repeatable)")
 	flag.Parse()
+	if *unreliable && *batchSize > 0 {
+		log.Fatal("-unreliable sends readings one by one and cannot be combined with -batch")
+	}
+	if *maxBuffer < 0 {
+		log.Fatal("-max-buffer must not be negative")
+	}
+	if *heartbeat < 0 {
+		log.Fatal("-heartbeat must not be negative")
+	}
+	if *healthEvery < 0 {
+		log.Fatal("-health-every must not be negative")
+	}
+	if *battery < 0 || *battery > 100 {
+		log.Fatal("-battery must be between 0 and 100")
+	}
+	deviceClock.Skew(*clockSkew)
+	if *compressFlag != "" {
+		codecs, err := parseCodecs(*compressFlag)
+		if err != nil {
+			log.Fatalf("Invalid -compress: %v", err)
+		}
+		compression.Offer(codecs)
+	}
+	if *batchEvery > 0 && *batchSize == 0 {
+		log.Fatal("-batch-interval requires -batch")
+	}
+
+	if *replay != "" && *devices > 0 {
+		log.Fatal("-replay and -devices cannot be combined")
+	}
+	if *speed <= 0 {
+		log.Fatal("-speed must be positive")
+	}
+
+	if *devices > 0 {
+		if *batchSize > 0 || *unreliable || *uploadEvery > 0 {
+			log.Fatal("-devices sends readings one by one and cannot be combined with -batch, -unreliable or -upload-interval")
+		}
+		if *protocol != "quic" && *protocol != "tcp" {
+			log.Fatalf("-protocol must be quic or tcp, not %q", *protocol)
+		}
+		if *statsEvery <= 0 {
+			log.Fatal("-stats-interval must be positive")
+		}
+		mix := []sensorShare{{*sensorType, 1}}
+		if *typeMix != "" {
+			var err error
+			if mix, err = parseTypeMix(*typeMix); err != nil {
+				log.Fatalf("Invalid -type-mix: %v", err)
+			}
+		}
+		log.Printf("Simulating %d devices (%s-*) against %s over %s", *devices, *prefix, *serverAddr, *protocol)
+		runFleet(fleetConfig{
+			serverAddr:    *serverAddr,
+			protocol:      *protocol,
+			prefix:        *prefix,
+			devices:       *devices,
+			connPerDevice: *connPerDev,
+			mix:           mix,
+			token:         *token,
+			firmware:      *firmware,
+			labels:        labels,
+			maxVersion:    *maxVersion,
+			interval:      *interval,
+			duration:      *duration,
+			statsInterval: *statsEvery,
+		})
+		return
+	}
 
 	log.Printf("Starting IoT client: %s", *deviceID)
 	log.Printf("Server: %s", *serverAddr)
@@ -41,52 +155,199 @@ func main() {
 	log.Printf("Protocol: %s", *protocol)
 
 	// Create HTTP client with TLS config
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	var transport interface {
+		http.RoundTripper
+		CloseIdleConnections()
+	} = &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
 		},
-		Timeout: 10 * time.Second,
+	}
+	if *unreliable || (*subscription != "" && *protocol == "quic") {
+		// Datagrams need HTTP/3, so the reliable requests use it too. A
+		// subscription over QUIC is a stream of its own.
+		transport = quiclib.NewClientTransport(config.QUICConfig{}, nil)
+	}
+	if *compressFlag != "" {
+		transport = compressTransport{transport}
+	}
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+	if *token != "" {
+		httpClient.Transport = tokenTransport{transport, *token}
 	}
 
-	// Run simulation
-	runSimulation(client, *serverAddr, *deviceID, *sensorType, *interval, *duration)
-}
-
-func runSimulation(client *http.Client, serverAddr, deviceID, sensorType string, interval, duration time.Duration) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	timeout := time.After(duration)
-	requestCount := 0
-	successCount := 0
-
-	for {
-		select {
-		case <-ticker.C:
-			data := generateSensorData(deviceID, sensorType)
-			
-			if err := sendSensorData(client, serverAddr, data); err != nil {
-				log.Printf("Failed to send data: %v", err)
-			} else {
-				successCount++
-				log.Printf("Sent data: %s=%.2f%s", data.SensorType, data.Value, data.Unit)
+	if *subscription != "" {
+		// Without the client timeout, which would cut the stream off
+		log.Printf("Subscribing to %s", *subscription)
+		runSubscribe(httpClient.Transport, *serverAddr, *deviceID, *subscription, *duration)
+		return
+	}
+
+	if *replay != "" {
+		if *batchSize > 0 || *unreliable || *uploadEvery > 0 {
+			log.Fatal("-replay sends readings one by one and cannot be combined with -batch, -unreliable or -upload-interval")
+		}
+		opts := iot.ReplayOptions{Speed: *speed, Loop: *loop}
+		if *deviceMap != "" {
+			var err error
+			if opts.DeviceMap, err = iot.ParseDeviceMap(*deviceMap); err != nil {
+				log.Fatalf("Invalid -device-map: %v", err)
 			}
-			requestCount++
-			
-		case <-timeout:
-			log.Printf("Simulation completed: %d/%d requests successful", successCount, requestCount)
-			return
 		}
+		log.Printf("Replaying %s at %gx speed", *replay, *speed)
+		runReplay(httpClient, client.NewPacer(0), *serverAddr, *token, *firmware, labels, *maxVersion, *replay, opts, *duration)
+		return
+	}
+
+	// Reconnect when the server stops answering pings
+	pinger := client.NewPinger(httpClient, *serverAddr, *pingInterval, *pingMisses, func() {
+		log.Printf("Server missed %d pings, reconnecting", *pingMisses)
+		transport.CloseIdleConnections()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pinger.Run(ctx)
+
+	// Batches and uploads are paced at the rate the server asks for
+	pacer := client.NewPacer(0)
+
+	// Readings are numbered so the server can tell which were lost
+	numbers := newReadingNumbers()
+
+	version, err := register(httpClient, *serverAddr, *deviceID, *token, deviceInfo(*sensorType, *firmware, labels), numbers.epoch, *maxVersion, pacer)
+	if err != nil {
+		log.Fatal("Registration failed:", err)
+	}
+	log.Printf("Protocol version: %d", version)
+
+	// Heartbeats keep the device online on the server between readings
+	heartbeats := newHeartbeater(httpClient, *serverAddr, *deviceID, *heartbeat, *syncClock)
+	go heartbeats.Run(ctx)
+
+	// Move to another server when asked to: acknowledge on the old one, drop
+	// its connections and register with the new one
+	migrate := func(from string, to iot.Reconnect) (int, error) {
+		if err := ackMigration(httpClient, from, *deviceID); err != nil {
+			log.Printf("Migration acknowledgement failed: %v", err)
+		}
+		transport.CloseIdleConnections()
+		pinger.SetServer(to.Addr)
+		heartbeats.SetServer(to.Addr)
+		return register(httpClient, to.Addr, *deviceID, *token, deviceInfo(*sensorType, *firmware, labels), numbers.epoch, *maxVersion, pacer)
+	}
+
+	// Register again after losing the server, on fresh connections
+	reconnect := func(serverAddr string) (int, error) {
+		transport.CloseIdleConnections()
+		return register(httpClient, serverAddr, *deviceID, *token, deviceInfo(*sensorType, *firmware, labels), numbers.epoch, *maxVersion, pacer)
+	}
+
+	// Run simulation
+	runSimulation(simulationConfig{
+		httpClient:     httpClient,
+		pinger:         pinger,
+		pacer:          pacer,
+		numbers:        numbers,
+		migrate:        migrate,
+		reconnect:      reconnect,
+		serverAddr:     *serverAddr,
+		deviceID:       *deviceID,
+		token:          *token,
+		sensorType:     *sensorType,
+		version:        version,
+		interval:       *interval,
+		uploadInterval: *uploadEvery,
+		duration:       *duration,
+		batchSize:      *batchSize,
+		batchInterval:  *batchEvery,
+		precision:      *precision,
+		unreliable:     *unreliable,
+		firmware:       firmware,
+		maxBuffer:      *maxBuffer,
+		health:         newDeviceHealth(*sensorType, *battery),
+		healthEvery:    *healthEvery,
+	})
+}
+
+// tokenTransport sends the device token on every request
+type tokenTransport struct {
+	base interface {
+		http.RoundTripper
+		CloseIdleConnections()
+	}
+	token string
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(iot.TokenHeader, t.token)
+	return t.base.RoundTrip(req)
+}
+
+func (t tokenTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+}
+
+// register negotiates the IoT protocol version, describes the device with
+// info and takes the server's upload pace and codec. The epoch is that of the device's reading numbers. Servers that
+// predate registration answer 404 and are spoken to with version 1,
+// unpaced.
+func register(client *http.Client, serverAddr, deviceID, token string, info iot.DeviceInfo, epoch uint64, maxVersion int, pacer *client.Pacer) (int, error) {
+	body, err := json.Marshal(iot.RegisterRequest{
+		DeviceID:    deviceID,
+		MinVersion:  iot.ProtocolV1,
+		MaxVersion:  maxVersion,
+		Token:       token,
+		Epoch:       epoch,
+		Compression: compression.Offered(),
+		DeviceInfo:  info,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Post(serverAddr+"/iot/register", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to send registration: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		pacer.SetRate(0)
+		if compression.Offered() != nil {
+			compression.Use("")
+		}
+		return iot.ProtocolV1, nil
 	}
+
+	var result iot.RegisterResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&result); err != nil {
+		return 0, fmt.Errorf("invalid registration response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned status %d: %s", resp.StatusCode, result.Error)
+	}
+	for _, warning := range result.Warnings {
+		log.Printf("Registration warning: %s", warning)
+	}
+	if result.Pace != pacer.Rate() {
+		log.Printf("Pacing batches and uploads at %d bytes/s", result.Pace)
+	}
+	pacer.SetRate(result.Pace)
+	if compression.Offered() != nil {
+		compression.Use(result.Compression)
+	}
+	return result.Version, nil
 }
 
 func generateSensorData(deviceID, sensorType string) SensorData {
 	data := SensorData{
 		DeviceID:   deviceID,
 		SensorType: sensorType,
-		Timestamp:  time.Now(),
+		Timestamp:  deviceClock.Now(),
 		Quality:    "reliable",
 	}
 
@@ -115,31 +376,268 @@ func generateSensorData(deviceID, sensorType string) SensorData {
 	return data
 }
 
-func sendSensorData(client *http.Client, serverAddr string, data SensorData) error {
+// readingNumbers numbers a device's readings from 1, so the server can
+// count the ones lost on the way. The epoch is taken when numbering starts
+// and tells the server that a restarted client starts over.
+type readingNumbers struct {
+	epoch uint64
+	last  uint64
+}
+
+func newReadingNumbers() *readingNumbers {
+	return &readingNumbers{epoch: uint64(time.Now().UnixNano())}
+}
+
+// stamp gives data the next sequence number
+func (n *readingNumbers) stamp(data *SensorData) {
+	n.last++
+	data.Seq, data.Epoch = n.last, n.epoch
+}
+
+// sendSensorData posts one reading taken at interval and returns the
+// response headers, which may carry a reconnect directive or a new interval
+func sendSensorData(client *http.Client, serverAddr string, data SensorData, version int, interval time.Duration, seq uint64) (http.Header, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return fmt.Errorf("failed to marshal data: %w", err)
+		return http.Header{}, fmt.Errorf("failed to marshal data: %w", err)
 	}
 
 	url := serverAddr + "/iot/sensor"
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return http.Header{}, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Device-ID", data.DeviceID)
 	req.Header.Set("X-Sensor-Type", data.SensorType)
+	req.Header.Set(iot.VersionHeader, strconv.Itoa(version))
+	req.Header.Set(iot.IntervalHeader, interval.String())
+	req.Header.Set(iot.SeqHeader, strconv.FormatUint(seq, 10))
+	if data.Seq != 0 {
+		// Identifies a retry of the reading if the response gets lost
+		req.Header.Set(iot.MessageIDHeader, fmt.Sprintf("%d-%d", data.Epoch, data.Seq))
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return http.Header{}, fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status %d", resp.StatusCode)
+		return resp.Header, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	return resp.Header, nil
+}
+
+// consecutive reports whether readings are numbered one after the other
+func consecutive(readings []iot.SensorData) bool {
+	for i, r := range readings[1:] {
+		if r.Epoch != readings[0].Epoch || r.Seq != readings[i].Seq+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// sendBatch posts readings in one request, delta-encoded when the negotiated
+// version supports it and as a JSON array otherwise
+func sendBatch(client *http.Client, pacer *client.Pacer, serverAddr string, readings []iot.SensorData, version int, precision float64, seq uint64) (http.Header, error) {
+	plain, err := json.Marshal(readings)
+	if err != nil {
+		return http.Header{}, fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	body, contentType, readingSeq, msgID := plain, "application/json", "", ""
+	first, last := readings[0], readings[len(readings)-1]
+	numbered := first.Seq != 0 && consecutive(readings)
+	if numbered {
+		msgID = fmt.Sprintf("%d-%d-%d", first.Epoch, first.Seq, last.Seq)
+	}
+	if version >= iot.ProtocolV3 {
+		if body, err = iot.EncodeDelta(readings, precision); err != nil {
+			return http.Header{}, fmt.Errorf("failed to encode batch: %w", err)
+		}
+		contentType = iot.DeltaContentType
+		// Delta batches carry the reading numbers in a header, which only
+		// holds a consecutive run
+		if numbered {
+			readingSeq = iot.FormatReadingSeq(first.Epoch, first.Seq)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/batch", pacer.Body(bytes.NewReader(body)))
+	if err != nil {
+		return http.Header{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Device-ID", readings[0].DeviceID)
+	req.Header.Set(iot.VersionHeader, strconv.Itoa(version))
+	req.Header.Set(iot.SeqHeader, strconv.FormatUint(seq, 10))
+	if readingSeq != "" {
+		req.Header.Set(iot.ReadingSeqHeader, readingSeq)
+	}
+	if msgID != "" {
+		req.Header.Set(iot.MessageIDHeader, msgID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.Header{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.Header, fmt.Errorf("server returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+
+	log.Printf("Sent batch of %d readings as %s: %d bytes (%d as JSON)", len(readings), contentType, len(body), len(plain))
+	return resp.Header, nil
+}
+
+// applyDesired fetches the device's twin, applies the desired properties it
+// supports and reports its state for the desired version, which it returns.
+// Only "interval" is supported; other properties are left unreported.
+func applyDesired(client *http.Client, serverAddr, deviceID string, interval *time.Duration) (int64, error) {
+	req, err := http.NewRequest(http.MethodGet, serverAddr+"/iot/twin", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Device-ID", deviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	var twin iot.DeviceTwin
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&twin); err != nil {
+		return 0, fmt.Errorf("invalid twin: %w", err)
+	}
+
+	// An applied value is reported as it was desired, e.g. "1m" rather than
+	// "1m0s", so that the server doesn't see a conflict
+	reported := interval.String()
+	if v, ok := twin.Desired["interval"].(string); ok {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			log.Printf("Applying desired reporting interval %v (version %d)", d, twin.DesiredVersion)
+			*interval, reported = d, v
+		} else {
+			log.Printf("Ignoring invalid desired interval %q", v)
+		}
+	}
+
+	body, err := json.Marshal(iot.StateReport{
+		DeviceID:   deviceID,
+		Version:    twin.DesiredVersion,
+		Properties: map[string]interface{}{"interval": reported},
+	})
+	if err != nil {
+		return 0, err
+	}
+	req, err = http.NewRequest(http.MethodPost, serverAddr+"/iot/state", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Device-ID", deviceID)
+	resp, err = client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("state report returned status %d", resp.StatusCode)
+	}
+	return twin.DesiredVersion, nil
+}
+
+// fetchCommands takes the commands waiting for the device on the server
+func fetchCommands(client *http.Client, serverAddr, deviceID string) ([]iot.Command, error) {
+	var cmds []iot.Command
+	if err := getJSON(client, serverAddr+"/iot/commands", deviceID, &cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+// ackMigration tells the old server the device is leaving
+func ackMigration(client *http.Client, serverAddr, deviceID string) error {
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/migrate", nil)
+	if err != nil {
+		return err
 	}
+	req.Header.Set("X-Device-ID", deviceID)
 
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
 	return nil
-}
\ No newline at end of file
+}
+
+// sendSnapshot uploads a generated JPEG as a camera snapshot
+func sendSnapshot(client *http.Client, pacer *client.Pacer, serverAddr, deviceID string) (iot.Upload, error) {
+	data, err := generateSnapshot()
+	if err != nil {
+		return iot.Upload{}, fmt.Errorf("failed to generate snapshot: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/iot/upload", pacer.Body(bytes.NewReader(data)))
+	if err != nil {
+		return iot.Upload{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = int64(len(data))
+	iot.UploadRequest{
+		DeviceID:    deviceID,
+		ContentType: "image/jpeg",
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}.SetHeaders(req.Header)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return iot.Upload{}, fmt.Errorf("failed to send upload: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return iot.Upload{}, fmt.Errorf("server returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	var upload iot.Upload
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&upload); err != nil {
+		return iot.Upload{}, fmt.Errorf("invalid upload ack: %w", err)
+	}
+	return upload, nil
+}
+
+// generateSnapshot renders a 320x240 gradient with a random tint as JPEG
+func generateSnapshot() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	tint := uint8(rand.Intn(256))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / 320), G: uint8(y * 255 / 240), B: tint, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
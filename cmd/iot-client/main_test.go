@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+func TestRegisterTakesServerPace(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.UploadPace = 20_000
+	srv, _ := newIoTServer(t, cfg)
+	pacer := client.NewPacer(0)
+
+	version, err := register(srv.Client(), srv.URL, "dev1", "", deviceInfo("temperature", "1.0.0", nil), 1, iot.MaxProtocolVersion, pacer)
+	if err != nil || version != iot.MaxProtocolVersion {
+		t.Fatalf("registration: version %d, %v", version, err)
+	}
+	if pacer.Rate() != 20_000 {
+		t.Errorf("pacing at %d bytes/s, want the server's 20000", pacer.Rate())
+	}
+
+	// A server without a pace lifts it again
+	unpaced, _ := newIoTServer(t, config.Default())
+	if _, err := register(unpaced.Client(), unpaced.URL, "dev1", "", deviceInfo("temperature", "1.0.0", nil), 1, iot.MaxProtocolVersion, pacer); err != nil {
+		t.Fatal(err)
+	}
+	if pacer.Rate() != 0 {
+		t.Errorf("pacing at %d bytes/s after registering with an unpaced server", pacer.Rate())
+	}
+}
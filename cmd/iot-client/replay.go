@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+)
+
+// replayDevice is a device seen in a replayed trace
+type replayDevice struct {
+	version int
+	seq     uint64
+	numbers *readingNumbers
+}
+
+// runReplay sends the readings of a trace file with their recorded gaps.
+// Each device is registered before its first reading is sent; one that
+// fails to register is tried again on its next reading. A looped replay
+// runs for duration.
+func runReplay(client *http.Client, pacer *client.Pacer, serverAddr, token, firmware string, labels labelFlags, maxVersion int, path string, opts iot.ReplayOptions, duration time.Duration) {
+	trace, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("Failed to open trace: %v", err)
+	}
+	defer trace.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.Loop {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, duration)
+		defer cancel()
+	}
+
+	devices := make(map[string]*replayDevice)
+	send := func(data iot.SensorData) error {
+		d := devices[data.DeviceID]
+		if d == nil {
+			numbers := newReadingNumbers()
+			version, err := register(client, serverAddr, data.DeviceID, token, deviceInfo(data.SensorType, firmware, labels), numbers.epoch, maxVersion, pacer)
+			if err != nil {
+				log.Printf("Failed to register %s: %v", data.DeviceID, err)
+				return err
+			}
+			d = &replayDevice{version: version, numbers: numbers}
+			devices[data.DeviceID] = d
+		}
+		reading := SensorData(data)
+		d.numbers.stamp(&reading)
+		d.seq++
+		if _, err := sendSensorData(client, serverAddr, reading, d.version, 0, d.seq); err != nil {
+			log.Printf("Failed to send %s reading: %v", data.DeviceID, err)
+			return err
+		}
+		return nil
+	}
+
+	start := time.Now()
+	replayer := iot.NewReplayer(opts, clock.Real())
+	err = replayer.Run(ctx, trace, send)
+	stats := replayer.Stats()
+	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Replay stopped: %v", err)
+	}
+	log.Printf("Replay completed in %v: %d readings sent from %d devices, %d failed, %d malformed lines skipped, %d loops",
+		time.Since(start).Round(time.Millisecond), stats.Sent, len(devices), stats.Failed, stats.Skipped, stats.Loops)
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// simulationConfig holds the settings of a simulated device and the
+// connection it sends over
+type simulationConfig struct {
+	httpClient *http.Client
+	pinger     *client.Pinger
+	pacer      *client.Pacer   // paces batches and uploads
+	numbers    *readingNumbers // number the readings
+	migrate    func(from string, to iot.Reconnect) (int, error)
+	reconnect  func(serverAddr string) (int, error)
+
+	serverAddr     string
+	deviceID       string
+	token          string
+	sensorType     string
+	version        int // negotiated at registration
+	interval       time.Duration
+	uploadInterval time.Duration // between snapshot uploads, 0 never
+	duration       time.Duration
+	batchSize      int           // readings per batch, 0 sends them one by one
+	batchInterval  time.Duration // longest a partial batch waits, 0 until full
+	precision      float64       // of delta batch values
+	unreliable     bool          // send readings as datagrams
+	firmware       *string       // reported at registration, updated by firmware updates
+	maxBuffer      int           // readings kept while the server is unreachable
+	health         *deviceHealth
+	healthEvery    int // readings between health reports, 0 never
+}
+
+// simulation is the state of a simulated device while it runs
+type simulation struct {
+	simulationConfig
+
+	ticker   *time.Ticker
+	flow     *datagramFlow // nil while readings go over requests
+	commands *commandRunner
+
+	// Pending server-initiated migration
+	moveAt <-chan time.Time
+	target iot.Reconnect
+
+	// Sequence numbers order the device's messages on the server and restart
+	// with each registration
+	seq uint64
+
+	// Version of the server's desired state last applied
+	twinVersion int64
+
+	// Readings taken while the server is unreachable are kept and sent,
+	// oldest first and with their original timestamps, once the device has
+	// registered again
+	buffer      *readingBuffer
+	reconnectAt <-chan time.Time
+	attempts    int
+
+	// Batches are sent when full, when batchInterval has passed since their
+	// first reading and when the simulation ends
+	batch    []iot.SensorData
+	batchDue <-chan time.Time
+
+	ticks int // readings taken, for health reports
+
+	// Firmware updates download in the background
+	updating     bool
+	firmwareDone chan string
+
+	requestCount int
+	successCount int
+}
+
+func runSimulation(cfg simulationConfig) {
+	s := &simulation{
+		simulationConfig: cfg,
+		ticker:           time.NewTicker(cfg.interval),
+		commands:         newCommandRunner(cfg.httpClient, cfg.deviceID),
+		buffer:           newReadingBuffer(cfg.maxBuffer),
+		firmwareDone:     make(chan string, 1),
+	}
+	defer s.ticker.Stop()
+
+	// Readings go over datagrams when the server supports them, and over
+	// requests otherwise
+	if s.unreliable {
+		s.openFlow()
+	}
+
+	var snapshots <-chan time.Time
+	if s.uploadInterval > 0 {
+		uploadTicker := time.NewTicker(s.uploadInterval)
+		defer uploadTicker.Stop()
+		snapshots = uploadTicker.C
+	}
+
+	timeout := time.After(s.duration)
+	for {
+		select {
+		case <-s.ticker.C:
+			s.reportHealth()
+			data := generateSensorData(s.deviceID, s.sensorType)
+			s.numbers.stamp(&data)
+			if s.batchSize > 0 {
+				s.follow(s.addToBatch(data))
+			} else {
+				s.follow(s.sendReading(data))
+			}
+
+		case <-s.batchDue:
+			s.follow(s.sendPending())
+
+		case <-s.moveAt:
+			s.moveAt = nil
+			s.migrateToTarget()
+
+		case <-s.reconnectAt:
+			s.follow(s.reconnectNow())
+
+		case <-s.commands.resync:
+			// The server resent desired state it never saw applied
+			if s.reconnectAt == nil {
+				s.applyDesired()
+			}
+
+		case installed := <-s.firmwareDone:
+			s.updating = false
+			if installed != "" {
+				// Reported at the next registration
+				*s.firmware = installed
+			}
+
+		case <-snapshots:
+			s.uploadSnapshot()
+
+		case <-timeout:
+			s.finish()
+			return
+		}
+	}
+}
+
+// openFlow sends readings as datagrams from now on, if the server supports
+// them
+func (s *simulation) openFlow() {
+	var err error
+	if s.flow, err = openDatagramFlow(s.serverAddr, s.deviceID, s.token, s.version); err != nil {
+		log.Printf("Datagrams unavailable, sending readings as requests: %v", err)
+	}
+}
+
+// disconnect buffers readings from now on and schedules a reconnect
+func (s *simulation) disconnect(err error) {
+	if s.reconnectAt != nil {
+		return
+	}
+	s.attempts = 0
+	delay := backoff(s.attempts)
+	log.Printf("Server unreachable, buffering readings and reconnecting in %v: %v", delay.Round(time.Millisecond), err)
+	s.reconnectAt = time.After(delay)
+}
+
+// reconnectNow registers again after the server became unreachable and
+// sends the buffered readings, or schedules another attempt
+func (s *simulation) reconnectNow() http.Header {
+	version, err := s.reconnect(s.serverAddr)
+	s.attempts++
+	if err != nil {
+		delay := backoff(s.attempts)
+		log.Printf("Reconnect attempt %d failed, retrying in %v: %v", s.attempts, delay.Round(time.Millisecond), err)
+		s.reconnectAt = time.After(delay)
+		return nil
+	}
+	s.reconnectAt = nil
+	s.version, s.seq = version, 0
+	log.Printf("Reconnected after %d attempts, sending %d buffered readings", s.attempts, s.buffer.len())
+	return s.flush()
+}
+
+// migrateToTarget moves to the server the device was asked to reconnect to
+func (s *simulation) migrateToTarget() {
+	version, err := s.migrate(s.serverAddr, s.target)
+	if err != nil {
+		log.Printf("Failed to migrate to %s: %v", s.target.Addr, err)
+		return
+	}
+	s.serverAddr, s.version, s.seq, s.twinVersion = s.target.Addr, version, 0, 0
+	log.Printf("Migrated to %s (protocol version %d)", s.serverAddr, s.version)
+	if s.flow != nil {
+		s.flow.close()
+		s.openFlow()
+	}
+}
+
+// sendReading sends a reading on its own, as a datagram or a request, or
+// buffers it while the server is unreachable
+func (s *simulation) sendReading(data SensorData) http.Header {
+	switch {
+	case s.flow != nil:
+		s.requestCount++
+		if err := s.flow.send(data); err != nil {
+			log.Printf("Failed to send datagram: %v", err)
+			return nil
+		}
+		s.successCount++
+		log.Printf("Sent datagram: %s=%.2f%s", data.SensorType, data.Value, data.Unit)
+		return nil
+	case s.reconnectAt != nil:
+		s.buffer.push(iot.SensorData(data))
+		return nil
+	}
+
+	s.seq++
+	s.requestCount++
+	header, err := sendSensorData(s.httpClient, s.serverAddr, data, s.version, s.interval, s.seq)
+	if err != nil {
+		log.Printf("Failed to send data: %v", err)
+		if unreachable(err) {
+			s.buffer.push(iot.SensorData(data))
+			s.disconnect(err)
+		}
+		return header
+	}
+	s.successCount++
+	log.Printf("Sent data: %s=%.2f%s", data.SensorType, data.Value, data.Unit)
+	return header
+}
+
+// addToBatch adds a reading to the pending batch and sends the batch once
+// it is full
+func (s *simulation) addToBatch(data SensorData) http.Header {
+	s.batch = append(s.batch, iot.SensorData(data))
+	if len(s.batch) == 1 && s.batchInterval > 0 {
+		s.batchDue = time.After(s.batchInterval)
+	}
+	if len(s.batch) < s.batchSize {
+		return nil
+	}
+	return s.sendPending()
+}
+
+// sendPending sends the pending batch, or buffers it while the server is
+// unreachable
+func (s *simulation) sendPending() http.Header {
+	s.batchDue = nil
+	if len(s.batch) == 0 {
+		return nil
+	}
+	defer func() { s.batch = s.batch[:0] }()
+	if s.reconnectAt != nil {
+		for _, r := range s.batch {
+			s.buffer.push(r)
+		}
+		return nil
+	}
+	s.seq++
+	s.requestCount++
+	header, err := sendBatch(s.httpClient, s.pacer, s.serverAddr, s.batch, s.version, s.precision, s.seq)
+	if err != nil {
+		log.Printf("Failed to send batch: %v", err)
+		if unreachable(err) {
+			for _, r := range s.batch {
+				s.buffer.push(r)
+			}
+			s.disconnect(err)
+		}
+		return header
+	}
+	s.successCount++
+	return header
+}
+
+// flush sends the buffered readings in batches. Readings the server
+// refuses are dropped rather than retried.
+func (s *simulation) flush() http.Header {
+	var header http.Header
+	for s.buffer.len() > 0 {
+		readings := s.buffer.peek(flushBatch)
+		s.seq++
+		s.requestCount++
+		h, err := sendBatch(s.httpClient, s.pacer, s.serverAddr, readings, s.version, s.precision, s.seq)
+		if err != nil && unreachable(err) {
+			s.disconnect(err)
+			return header
+		}
+		if err != nil {
+			log.Printf("Failed to send buffered readings: %v", err)
+		} else {
+			s.successCount++
+			header = h
+		}
+		s.buffer.discard(len(readings))
+	}
+	return header
+}
+
+// reportHealth sends a health report every healthEvery readings
+func (s *simulation) reportHealth() {
+	s.ticks++
+	if s.healthEvery == 0 || s.ticks%s.healthEvery != 0 || s.reconnectAt != nil {
+		return
+	}
+	report := s.health.next(s.deviceID)
+	err := sendHealth(s.httpClient, s.serverAddr, report)
+	switch {
+	case errors.Is(err, errHealthUnsupported):
+		log.Printf("Server doesn't take health reports, not sending any")
+		s.healthEvery = 0
+	case err != nil:
+		log.Printf("Failed to send health report: %v", err)
+	case report.Battery != nil:
+		log.Printf("Sent health: battery %.1f%%, RSSI %d dBm", *report.Battery, report.RSSI)
+	default:
+		log.Printf("Sent health: RSSI %d dBm", report.RSSI)
+	}
+}
+
+// uploadSnapshot uploads a camera snapshot
+func (s *simulation) uploadSnapshot() {
+	upload, err := sendSnapshot(s.httpClient, s.pacer, s.serverAddr, s.deviceID)
+	if err != nil {
+		log.Printf("Failed to upload snapshot: %v", err)
+		return
+	}
+	log.Printf("Uploaded snapshot %s (%d bytes)", upload.ID, upload.Size)
+}
+
+// applyDesired applies the desired state of the device's twin
+func (s *simulation) applyDesired() {
+	applied, err := applyDesired(s.httpClient, s.serverAddr, s.deviceID, &s.interval)
+	if err != nil {
+		log.Printf("Failed to apply desired state: %v", err)
+		return
+	}
+	s.ticker.Reset(s.interval)
+	s.twinVersion = applied
+}
+
+// follow acts on the directives of a response header
+func (s *simulation) follow(header http.Header) {
+	// The server widens or tightens the interval as readings settle or vary
+	if next, err := time.ParseDuration(header.Get(iot.IntervalHeader)); err == nil && next > 0 && next != s.interval {
+		log.Printf("Server changed reporting interval from %v to %v", s.interval, next)
+		s.interval = next
+		s.ticker.Reset(s.interval)
+	}
+
+	// Desired state may have been set while the device was offline
+	if v, err := strconv.ParseInt(header.Get(iot.DesiredHeader), 10, 64); err == nil && v > s.twinVersion {
+		s.applyDesired()
+	}
+
+	// A failed transfer is resumed on the next response that still carries
+	// the offer
+	if offered := header.Get(iot.FirmwareHeader); offered != "" && !s.updating {
+		s.updating = true
+		go func(serverAddr, current string) {
+			installed, err := updateFirmware(s.httpClient, serverAddr, s.deviceID, current)
+			if err != nil {
+				log.Printf("Firmware update failed: %v", err)
+			}
+			s.firmwareDone <- installed
+		}(s.serverAddr, *s.firmware)
+	}
+
+	// Commands sent to the device, e.g. over the MQTT bridge, wait on the
+	// server until fetched
+	if n, err := strconv.Atoi(header.Get(iot.CommandsHeader)); err == nil && n > 0 {
+		cmds, err := fetchCommands(s.httpClient, s.serverAddr, s.deviceID)
+		if err != nil {
+			log.Printf("Failed to fetch commands: %v", err)
+		}
+		for _, cmd := range cmds {
+			s.commands.run(s.serverAddr, cmd)
+		}
+	}
+
+	if directive := header.Get(iot.ReconnectHeader); directive != "" && s.moveAt == nil {
+		var err error
+		if s.target, err = iot.ParseReconnect(directive); err != nil {
+			log.Printf("Ignoring reconnect directive: %v", err)
+		} else {
+			log.Printf("Server asked to reconnect to %s in %v", s.target.Addr, s.target.After)
+			s.moveAt = time.After(s.target.After)
+		}
+	}
+}
+
+// finish sends what is still pending and logs the totals of the simulation
+func (s *simulation) finish() {
+	// Don't lose the readings of a partial batch
+	s.sendPending()
+	if s.reconnectAt == nil {
+		s.flush()
+	}
+	if s.buffer.len() > 0 || s.buffer.dropped > 0 {
+		log.Printf("Offline buffer: %d readings unsent, %d dropped when full", s.buffer.len(), s.buffer.dropped)
+	}
+	log.Printf("Simulation completed: %d/%d requests successful", s.successCount, s.requestCount)
+	if s.pacer.Rate() > 0 {
+		log.Printf("Pacing: %d bytes/s, bodies held back %v in total", s.pacer.Rate(), s.pacer.Delayed().Round(time.Millisecond))
+	}
+	if s.flow != nil {
+		log.Printf("Datagrams: %d sent, %d too large", s.flow.sent, s.flow.dropped)
+		s.flow.close()
+	}
+	log.Printf("Last ping RTT: %v", s.pinger.LastRTT())
+}
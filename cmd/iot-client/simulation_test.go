@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// batchRecorder is a server that records the size of each batch it gets
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches []int
+}
+
+func (b *batchRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var readings []iot.SensorData
+	if r.URL.Path != "/iot/batch" || json.NewDecoder(r.Body).Decode(&readings) != nil {
+		http.Error(w, "not a batch", http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	b.batches = append(b.batches, len(readings))
+	b.mu.Unlock()
+	json.NewEncoder(w).Encode(iot.Response{Status: "success"})
+}
+
+func (b *batchRecorder) sizes() []int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]int(nil), b.batches...)
+}
+
+// newTestSimulation creates a simulation batching batchSize readings for
+// the server at serverAddr, without starting it
+func newTestSimulation(serverAddr string, batchSize int, batchInterval time.Duration) *simulation {
+	httpClient := &http.Client{Timeout: 5 * time.Second}
+	return &simulation{
+		simulationConfig: simulationConfig{
+			httpClient:    httpClient,
+			pinger:        client.NewPinger(httpClient, serverAddr, time.Second, 3, nil),
+			pacer:         client.NewPacer(0),
+			numbers:       newReadingNumbers(),
+			serverAddr:    serverAddr,
+			deviceID:      "dev1",
+			sensorType:    "temperature",
+			version:       iot.ProtocolV1,
+			interval:      time.Second,
+			batchSize:     batchSize,
+			batchInterval: batchInterval,
+			precision:     0.01,
+			maxBuffer:     100,
+		},
+		commands: newCommandRunner(httpClient, "dev1"),
+		buffer:   newReadingBuffer(100),
+	}
+}
+
+// addReadings adds n readings to the pending batch of s
+func addReadings(s *simulation, n int) {
+	for i := 0; i < n; i++ {
+		data := generateSensorData(s.deviceID, s.sensorType)
+		s.numbers.stamp(&data)
+		s.follow(s.addToBatch(data))
+	}
+}
+
+func TestSimulationSendsFullBatches(t *testing.T) {
+	rec := &batchRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s := newTestSimulation(srv.URL, 3, 0)
+
+	addReadings(s, 7)
+	if got := rec.sizes(); len(got) != 2 || got[0] != 3 || got[1] != 3 {
+		t.Errorf("sent batches of %v readings, want two of 3", got)
+	}
+	if s.batchDue != nil {
+		t.Error("a partial batch is due without a batch interval")
+	}
+}
+
+func TestSimulationFlushesPartialBatchOnFinish(t *testing.T) {
+	rec := &batchRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s := newTestSimulation(srv.URL, 10, 0)
+
+	addReadings(s, 4)
+	if got := rec.sizes(); len(got) != 0 {
+		t.Fatalf("sent batches of %v readings before the batch was full", got)
+	}
+	s.finish()
+	if got := rec.sizes(); len(got) != 1 || got[0] != 4 {
+		t.Errorf("sent batches of %v readings at the end of the run, want one of 4", got)
+	}
+	if s.successCount != 1 || s.requestCount != 1 {
+		t.Errorf("%d/%d requests successful, want 1/1", s.successCount, s.requestCount)
+	}
+}
+
+func TestSimulationFlushesPartialBatchAfterInterval(t *testing.T) {
+	rec := &batchRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	s := newTestSimulation(srv.URL, 10, 20*time.Millisecond)
+
+	addReadings(s, 2)
+	select {
+	case <-s.batchDue:
+		s.follow(s.sendPending())
+	case <-time.After(time.Second):
+		t.Fatal("a partial batch isn't due after the batch interval")
+	}
+	if got := rec.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("sent batches of %v readings, want one of 2", got)
+	}
+	if s.batchDue != nil || len(s.batch) != 0 {
+		t.Errorf("%d readings still pending after the batch was sent", len(s.batch))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// runSubscribe subscribes to the live readings selected by query, e.g.
+// device_id=dev-1&sensor_type=temperature, and logs them until duration
+// has passed
+func runSubscribe(transport http.RoundTripper, serverAddr, deviceID, query string, duration time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	client := &http.Client{Transport: transport}
+	// Closing the connection tells the server the subscription is over
+	defer client.CloseIdleConnections()
+	received, err := subscribe(ctx, client, serverAddr, deviceID, query)
+	if err != nil && ctx.Err() == nil {
+		log.Fatalf("Subscription failed after %d readings: %v", received, err)
+	}
+	log.Printf("Subscription ended: %d readings received", received)
+}
+
+// subscribe streams the readings of a subscription until ctx is done or the
+// server ends it, and returns how many arrived
+func subscribe(ctx context.Context, client *http.Client, serverAddr, deviceID, query string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverAddr+"/iot/subscribe?"+query, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Device-ID", deviceID)
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("server returned status %d: %s", resp.StatusCode, msg)
+	}
+
+	var received int
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		var data iot.SensorData
+		if err := json.Unmarshal(lines.Bytes(), &data); err != nil {
+			return received, fmt.Errorf("invalid reading: %w", err)
+		}
+		received++
+		log.Printf("%s %s=%.2f%s at %s", data.DeviceID, data.SensorType, data.Value, data.Unit, data.Timestamp.Format(time.RFC3339))
+	}
+	return received, lines.Err()
+}
@@ -2,77 +2,116 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"fmt"
+	"flag"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/nik1740/quic-communication-system/internal/iot"
-	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
-	"github.com/nik1740/quic-communication-system/internal/streaming"
-	"github.com/quic-go/quic-go/http3"
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
 )
 
 func main() {
-	// Create TLS certificate for QUIC
-	cert, err := quiclib.GenerateSelfSignedCert()
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
+	dumpConfig := flag.Bool("dump-config", false, "Print the effective configuration and exit")
+	flag.Parse()
+
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
-		log.Fatal("Failed to generate certificate:", err)
+		log.Fatal("Failed to load config:", err)
+	}
+
+	if *dumpConfig {
+		if err := cfg.Dump(os.Stdout); err != nil {
+			log.Fatal("Failed to dump config:", err)
+		}
+		return
 	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		NextProtos:   []string{"h3"},
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("Failed to configure tracing", logging.Err(err))
+		os.Exit(1)
+	}
+
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		logger.Error("Failed to create server", logging.Err(err))
+		os.Exit(1)
 	}
 
-	// Create HTTP/3 server
-	server := &http3.Server{
-		Addr:      ":8443",
-		TLSConfig: tlsConfig,
+	// Admin API
+	if adminServer := srv.Admin(); adminServer != nil {
+		go func() {
+			if err := adminServer.Start(); err != nil {
+				logger.Error("Admin server failed", logging.Err(err))
+			}
+		}()
 	}
 
-	// Set up HTTP handlers
-	mux := http.NewServeMux()
-	
-	// IoT endpoints
-	mux.HandleFunc("/iot/", iot.Handler)
-	
-	// Video streaming endpoints
-	mux.HandleFunc("/stream/", streaming.Handler)
-	
-	// Health check
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, "QUIC server is running")
-	})
-
-	server.Handler = mux
+	// Warn about overrides for components that were never created
+	applyLogLevels(logger, cfg.Logging)
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	srv.RunBackground(monitorCtx)
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Starting QUIC server on :8443")
-		if err := server.ListenAndServe(); err != nil {
-			log.Fatal("Server failed:", err)
+		if err := srv.Start(); err != nil {
+			logger.Named("quic").Error("Server failed", logging.Err(err))
+			os.Exit(1)
 		}
 	}()
 
-	// Wait for interrupt signal
+	// Wait for interrupt signal, reloading log levels on SIGHUP
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloaded, err := config.LoadFile(*configFile)
+		if err != nil {
+			logger.Error("Config reload failed", logging.Err(err))
+			continue
+		}
+		logger.Info("Reloading log levels")
+		applyLogLevels(logger, reloaded.Logging)
+	}
 
-	log.Println("Shutting down server...")
+	logger.Info("Shutting down server...")
+	stopMonitor()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	
-	if err := server.Close(); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+
+	// End live sessions with an end-of-stream event before the listener closes
+	srv.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Tracing shutdown error", logging.Err(err))
 	}
-	
+
 	// Wait for context timeout
 	<-ctx.Done()
-}
\ No newline at end of file
+}
+
+// applyLogLevels applies the configured levels and warns about unknown components
+func applyLogLevels(logger logging.Logger, cfg config.LoggingConfig) {
+	unknown, err := logging.SetLevels(logger, cfg.Level, cfg.Levels)
+	if err != nil {
+		logger.Error("Invalid log levels", logging.Err(err))
+		return
+	}
+	for _, name := range unknown {
+		logger.Warn("Log level set for unknown component", logging.F("component", name))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/common/expfmt"
+)
+
+// checker runs the periodic soak assertions
+type checker struct {
+	logger        logging.Logger
+	health        *health.Registry
+	stats         *fleetStats
+	maxGoroutines int
+	maxHeapGrowth float64
+	maxDropRate   float64
+
+	baseGoroutines int
+	baseHeap       uint64
+	lastSent       int64
+	lastFailed     int64
+}
+
+// run waits for the warm-up, takes baselines and then checks every interval
+// until ctx is done. It returns the first failed assertion.
+func (c *checker) run(ctx context.Context, warmup, interval time.Duration) error {
+	select {
+	case <-time.After(warmup):
+	case <-ctx.Done():
+		return nil
+	}
+
+	c.baseGoroutines = runtime.NumGoroutine()
+	c.baseHeap = liveHeap()
+	c.lastSent = c.stats.sent.Load()
+	c.lastFailed = c.stats.failed.Load()
+	c.logger.Info("Baseline taken", logging.F("goroutines", c.baseGoroutines),
+		logging.F("heap_bytes", c.baseHeap))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.check(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (c *checker) check() error {
+	goroutines := runtime.NumGoroutine()
+	heap := liveHeap()
+
+	sent := c.stats.sent.Load()
+	failed := c.stats.failed.Load()
+	dropRate := 0.0
+	if delta := sent - c.lastSent; delta > 0 {
+		dropRate = float64(failed-c.lastFailed) / float64(delta)
+	}
+	c.lastSent, c.lastFailed = sent, failed
+
+	c.logger.Info("Soak check", logging.F("goroutines", goroutines), logging.F("heap_bytes", heap),
+		logging.F("readings", sent), logging.F("drop_rate", fmt.Sprintf("%.4f", dropRate)),
+		logging.F("chunks", c.stats.chunks.Load()))
+
+	if growth := goroutines - c.baseGoroutines; growth > c.maxGoroutines {
+		return fmt.Errorf("goroutines grew by %d (baseline %d, limit %d)", growth, c.baseGoroutines, c.maxGoroutines)
+	}
+	if limit := uint64(float64(c.baseHeap) * c.maxHeapGrowth); heap > limit {
+		return fmt.Errorf("live heap %d bytes exceeds %.1fx baseline of %d bytes", heap, c.maxHeapGrowth, c.baseHeap)
+	}
+	if dropRate > c.maxDropRate {
+		return fmt.Errorf("dropped %.2f%% of readings (limit %.2f%%), last error: %s",
+			dropRate*100, c.maxDropRate*100, c.stats.lastErr())
+	}
+	if report := c.health.Check(); !report.Healthy {
+		return fmt.Errorf("%d components unhealthy, first: %s: %s",
+			len(report.Unhealthy), report.Unhealthy[0].Name, report.Unhealthy[0].Reason)
+	}
+	return nil
+}
+
+// liveHeap returns the heap in use after a forced collection
+func liveHeap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// writeDiagnostics dumps goroutines, heap, metrics and health to dir
+func writeDiagnostics(dir string, reg *metrics.Registry, healthReg *health.Registry, stats *fleetStats) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create diagnostics directory: %w", err)
+	}
+
+	write := func(name string, fn func(f *os.File) error) error {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := fn(f); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if err := write("goroutines.txt", func(f *os.File) error {
+		return pprof.Lookup("goroutine").WriteTo(f, 2)
+	}); err != nil {
+		return err
+	}
+	if err := write("heap.pprof", func(f *os.File) error {
+		return pprof.Lookup("heap").WriteTo(f, 0)
+	}); err != nil {
+		return err
+	}
+	if err := write("metrics.txt", func(f *os.File) error {
+		families, err := reg.Gatherer().Gather()
+		if err != nil {
+			return err
+		}
+		for _, mf := range families {
+			if _, err := expfmt.MetricFamilyToText(f, mf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return write("health.json", func(f *os.File) error {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{
+			"health":          healthReg.Check(),
+			"readings_sent":   stats.sent.Load(),
+			"readings_failed": stats.failed.Load(),
+			"chunks":          stats.chunks.Load(),
+			"viewer_errors":   stats.errors.Load(),
+			"last_error":      stats.lastErr(),
+		})
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/quic-go/quic-go/http3"
+)
+
+var sensorTypes = []string{"temperature", "humidity", "motion"}
+var qualities = []string{"low", "medium", "high"}
+
+// fleetStats counts traffic generated by devices and viewers
+type fleetStats struct {
+	sent   atomic.Int64
+	failed atomic.Int64
+	chunks atomic.Int64
+	errors atomic.Int64 // viewer errors
+
+	mu        sync.Mutex
+	lastError string
+}
+
+func (s *fleetStats) recordError(err error) {
+	s.mu.Lock()
+	s.lastError = err.Error()
+	s.mu.Unlock()
+}
+
+func (s *fleetStats) lastErr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastError
+}
+
+// newClient returns an HTTP/3 client with its own QUIC connection
+func newClient(timeout time.Duration) (*http.Client, *http3.Transport) {
+	transport := &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, transport
+}
+
+// runFleet starts count devices that each post a reading every interval
+func runFleet(ctx context.Context, serverURL string, count int, interval time.Duration, stats *fleetStats) <-chan struct{} {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			runDevice(ctx, serverURL, fmt.Sprintf("soak_device_%d", id), interval, stats)
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func runDevice(ctx context.Context, serverURL, deviceID string, interval time.Duration, stats *fleetStats) {
+	client, transport := newClient(10 * time.Second)
+	defer transport.Close()
+
+	// Spread devices across the interval so they don't all fire at once
+	select {
+	case <-time.After(time.Duration(rand.Int63n(int64(interval)))):
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reading := iot.SensorData{
+			DeviceID:   deviceID,
+			SensorType: sensorTypes[rand.Intn(len(sensorTypes))],
+			Value:      rand.Float64() * 100,
+			Unit:       "soak",
+			Timestamp:  time.Now(),
+			Quality:    "reliable",
+		}
+		if err := sendReading(ctx, client, serverURL, reading); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			stats.failed.Add(1)
+			stats.recordError(err)
+		}
+		stats.sent.Add(1)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func sendReading(ctx context.Context, client *http.Client, serverURL string, reading iot.SensorData) error {
+	body, err := json.Marshal(reading)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverURL+"/iot/sensor", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runViewers starts count viewers that fetch chunks back to back
+func runViewers(ctx context.Context, serverURL string, count int, stats *fleetStats) <-chan struct{} {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			runViewer(ctx, serverURL, fmt.Sprintf("stream_%03d", id%3+1), stats)
+		}(i)
+	}
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
+
+func runViewer(ctx context.Context, serverURL, streamID string, stats *fleetStats) {
+	client, transport := newClient(30 * time.Second)
+	defer transport.Close()
+
+	for chunk := 0; ctx.Err() == nil; chunk++ {
+		quality := qualities[rand.Intn(len(qualities))]
+		url := fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=%d", serverURL, streamID, quality, chunk)
+		if err := fetchChunk(ctx, client, url); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			stats.errors.Add(1)
+			stats.recordError(err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		stats.chunks.Add(1)
+	}
+}
+
+func fetchChunk(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// soak runs the server of cmd/server, a simulated device fleet and streaming viewers in
+// one process and periodically checks for leaks and dropped readings.
+func main() {
+	var (
+		duration       = flag.Duration("duration", 10*time.Minute, "Total soak duration")
+		devices        = flag.Int("devices", 500, "Number of simulated IoT devices")
+		viewers        = flag.Int("viewers", 20, "Number of streaming viewers")
+		deviceInterval = flag.Duration("device-interval", 5*time.Second, "Interval between readings per device")
+		checkInterval  = flag.Duration("check-interval", 30*time.Second, "Interval between assertions")
+		warmup         = flag.Duration("warmup", time.Minute, "Time before baselines are taken")
+		maxGoroutines  = flag.Int("max-goroutine-growth", 200, "Allowed goroutine growth over the baseline")
+		maxHeapGrowth  = flag.Float64("max-heap-growth", 2.0, "Allowed live heap as a multiple of the baseline")
+		maxDropRate    = flag.Float64("max-drop-rate", 0.01, "Allowed fraction of failed readings per check interval")
+		diagDir        = flag.String("diag-dir", "soak-diagnostics", "Directory for diagnostics written on failure")
+		configFile     = flag.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
+	)
+	flag.Parse()
+
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
+	soakLogger := logger.Named("soak")
+
+	srv, addr, stopServer, err := startServer(cfg, logger)
+	if err != nil {
+		soakLogger.Error("Failed to start server", logging.Err(err))
+		os.Exit(1)
+	}
+	defer stopServer()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	serverURL := "https://" + addr
+	soakLogger.Info("Starting soak", logging.F("server", serverURL), logging.F("devices", *devices),
+		logging.F("viewers", *viewers), logging.F("duration", *duration))
+
+	stats := &fleetStats{}
+	fleetDone := runFleet(ctx, serverURL, *devices, *deviceInterval, stats)
+	viewersDone := runViewers(ctx, serverURL, *viewers, stats)
+
+	checker := &checker{
+		logger:        soakLogger,
+		health:        srv.Health(),
+		stats:         stats,
+		maxGoroutines: *maxGoroutines,
+		maxHeapGrowth: *maxHeapGrowth,
+		maxDropRate:   *maxDropRate,
+	}
+	failure := checker.run(ctx, *warmup, *checkInterval)
+
+	cancel()
+	<-fleetDone
+	<-viewersDone
+
+	if failure != nil {
+		soakLogger.Error("Soak failed", logging.Err(failure))
+		if err := writeDiagnostics(*diagDir, srv.Registry(), srv.Health(), stats); err != nil {
+			soakLogger.Error("Failed to write diagnostics", logging.Err(err))
+		} else {
+			soakLogger.Info("Diagnostics written", logging.F("dir", *diagDir))
+		}
+		os.Exit(1)
+	}
+
+	soakLogger.Info("Soak passed", logging.F("readings_sent", stats.sent.Load()),
+		logging.F("readings_failed", stats.failed.Load()), logging.F("chunks", stats.chunks.Load()))
+}
+
+// startServer runs the server of cmd/server on a random local port
+func startServer(cfg *config.Config, logger logging.Logger) (*server.Server, string, func(), error) {
+	srv, err := server.New(cfg, logger)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	srv.RunBackground(monitorCtx)
+
+	go func() {
+		if err := srv.Serve(conn); err != nil {
+			logger.Error("Server failed", logging.Err(err))
+		}
+	}()
+
+	stop := func() {
+		stopMonitor()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		conn.Close()
+	}
+	return srv, conn.LocalAddr().String(), stop, nil
+}
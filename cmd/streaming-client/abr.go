@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// reporter tells the server how playback goes every few chunks and follows
+// the quality it recommends. The buffer is modeled from the media received,
+// one chunk duration per chunk, less the time played since the start.
+type reporter struct {
+	client     *http.Client
+	pinger     *client.Pinger
+	serverAddr string
+	streamID   string
+	session    string // X-Session-ID of the chunk requests and reports
+	every      int    // chunks between reports
+	frameRate  int
+	chunk      time.Duration // playback time of a chunk
+
+	start    time.Time
+	media    time.Duration // received since the start
+	chunks   int           // received since the last report
+	failed   int           // chunks lost since the last report
+	corrupt  int           // chunks that failed their checksum since the last report
+	bytes    int64
+	download time.Duration
+}
+
+// newReporter creates a reporter for the session with ID session, or a
+// random ID if it is empty, of a stream in chunks of chunk duration
+func newReporter(httpClient *http.Client, pinger *client.Pinger, serverAddr, streamID, session string, every, frameRate int, chunk time.Duration) *reporter {
+	if session == "" {
+		id := make([]byte, 8)
+		rand.Read(id)
+		session = "player-" + hex.EncodeToString(id)
+	}
+	return &reporter{
+		client:     httpClient,
+		pinger:     pinger,
+		serverAddr: serverAddr,
+		streamID:   streamID,
+		session:    session,
+		every:      every,
+		frameRate:  frameRate,
+		chunk:      chunk,
+		start:      time.Now(),
+	}
+}
+
+// received counts a chunk of size bytes that took took to download
+func (p *reporter) received(size int, took time.Duration) {
+	p.media += p.chunk
+	p.chunks++
+	p.bytes += int64(size)
+	p.download += took
+}
+
+// lost counts a chunk that never arrived, whose frames are skipped
+func (p *reporter) lost() {
+	p.failed++
+}
+
+// corrupted counts a chunk that failed its checksum
+func (p *reporter) corrupted() {
+	p.corrupt++
+}
+
+// due reports whether enough chunks were fetched or lost for a report
+func (p *reporter) due() bool {
+	return p.chunks+p.failed >= p.every
+}
+
+// report sends what was measured since the last report while playing
+// quality, and returns the quality to request from now on
+func (p *reporter) report(quality string) (string, error) {
+	report := streaming.ClientReport{
+		Quality:       quality,
+		BufferSeconds: max(p.media-time.Since(p.start), 0).Seconds(),
+		DroppedFrames: int(float64(p.failed)*p.chunk.Seconds()) * p.frameRate,
+		RTTMillis:     float64(p.pinger.LastRTT().Microseconds()) / 1000,
+		CorruptChunks: p.corrupt,
+	}
+	if p.download > 0 {
+		report.BitrateKbps = float64(p.bytes) * 8 / 1000 / p.download.Seconds()
+	}
+	p.chunks, p.failed, p.corrupt, p.bytes, p.download = 0, 0, 0, 0, 0
+
+	body, _ := json.Marshal(report)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/stream/report/%s", p.serverAddr, p.streamID), bytes.NewReader(body))
+	if err != nil {
+		return quality, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-ID", p.session)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return quality, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return quality, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	var change streaming.QualityChange
+	if err := json.NewDecoder(resp.Body).Decode(&change); err != nil {
+		return quality, err
+	}
+	return change.Quality.Quality, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// errChunkIncomplete is returned for a chunk whose fragments didn't all
+// arrive in time. Unlike a failed request, it isn't worth asking again.
+var errChunkIncomplete = errors.New("chunk incomplete")
+
+// datagramChunks fetches chunks with unreliable delivery, on a QUIC
+// connection of its own with HTTP datagrams enabled. Delta chunks arrive as
+// fragments, keyframes and chunks the server couldn't fragment in the body.
+type datagramChunks struct {
+	serverAddr string
+	conn       *quic.Conn
+	cc         *http3.ClientConn
+	reassembly *streaming.Reassembler
+
+	datagram  int // chunks received as fragments
+	stream    int // chunks received in the body
+	discarded int // incomplete chunks
+}
+
+// dialDatagramChunks connects to serverAddr over HTTP/3 with datagrams
+func dialDatagramChunks(serverAddr string) (*datagramChunks, error) {
+	u, err := url.Parse(serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server address: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, u.Host, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{http3.NextProtoH3},
+	}, &quic.Config{EnableDatagrams: true, KeepAlivePeriod: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	return &datagramChunks{
+		serverAddr: serverAddr,
+		conn:       conn,
+		cc:         (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn),
+		reassembly: streaming.NewReassembler(streaming.FragmentTimeout),
+	}, nil
+}
+
+// get fetches a chunk and returns it with what the server tells of it, or
+// errChunkIncomplete if fragments of it were lost, or an error wrapping
+// streaming.ErrChunkCorrupt if it doesn't match its checksum
+func (d *datagramChunks) get(ctx context.Context, streamID, quality, session string, chunkIndex int) ([]byte, served, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	// Discard what is left of chunks given up on earlier
+	d.discarded += len(d.reassembly.Expire(time.Now()))
+
+	str, err := d.cc.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, served{}, fmt.Errorf("failed to open stream: %w", err)
+	}
+	defer str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+	u := fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=%d&%s=%s", d.serverAddr, streamID, quality, chunkIndex,
+		streaming.DeliveryParam, streaming.DeliveryUnreliable)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, served{}, err
+	}
+	req.Header.Set(streaming.ChunkIntervalHeader, chunkInterval.String())
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+	if err := str.SendRequestHeader(req); err != nil {
+		return nil, served{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	str.Close()
+
+	// Fragments can follow the response header at once, and are dropped
+	// once a few are waiting, so take them from the start
+	fragCtx, cancelFrags := context.WithCancel(ctx)
+	defer cancelFrags()
+	fragments := make(chan []byte, 1024)
+	go func() {
+		for {
+			b, err := str.ReceiveDatagram(fragCtx)
+			if err != nil {
+				return
+			}
+			select {
+			case fragments <- b:
+			case <-fragCtx.Done():
+				return
+			}
+		}
+	}()
+
+	resp, err := str.ReadResponse()
+	if err != nil {
+		return nil, served{}, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, served{}, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	chunk := servedBy(resp.Header)
+	crc := resp.Header.Get(streaming.CRCHeader)
+
+	// The body carries the chunk unless it comes as fragments, and then too
+	// if the server couldn't send them after all
+	body := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, streaming.MaxChunkMessageSize))
+		body <- data
+	}()
+	if resp.Header.Get(streaming.DeliveryHeader) != streaming.DeliveryDatagram {
+		d.stream++
+		data := <-body
+		return data, chunk, streaming.VerifyChunk(data, crc)
+	}
+
+	timeout := time.NewTimer(streaming.FragmentTimeout)
+	defer timeout.Stop()
+	for {
+		select {
+		case data := <-body:
+			if len(data) > 0 {
+				d.stream++
+				return data, chunk, streaming.VerifyChunk(data, crc)
+			}
+			body = nil
+		case <-timeout.C:
+			return nil, chunk, errChunkIncomplete
+		case b := <-fragments:
+			f, err := streaming.DecodeFragment(b)
+			if err != nil || f.Chunk != uint32(chunkIndex) {
+				continue
+			}
+			if data, done, err := d.reassembly.Add(f, time.Now()); err != nil {
+				return nil, chunk, err
+			} else if done {
+				d.datagram++
+				return data, chunk, streaming.VerifyChunk(data, crc)
+			}
+		}
+	}
+}
+
+// close closes the connection
+func (d *datagramChunks) close() {
+	d.conn.CloseWithError(0, "")
+}
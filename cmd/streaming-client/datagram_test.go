@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+func TestDatagramDelivery(t *testing.T) {
+	serverAddr := newQUICServer(t, config.Default())
+	d, err := dialDatagramChunks(serverAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.close()
+
+	keyframe, _, err := d.get(context.Background(), "stream_001", "high", "viewer", 10)
+	if err != nil || len(keyframe) == 0 {
+		t.Fatalf("keyframe: %d bytes, %v", len(keyframe), err)
+	}
+	// Fragments can be dropped even on loopback when the client is slow to
+	// take them, so the delta chunk is a small one at the lowest rung, and
+	// asked for again if it comes incomplete
+	var delta []byte
+	incomplete := 0
+	for {
+		delta, _, err = d.get(context.Background(), "stream_001", "low", "viewer", 11)
+		if !errors.Is(err, errChunkIncomplete) || incomplete == 4 {
+			break
+		}
+		incomplete++
+	}
+	if err != nil || len(delta) == 0 {
+		t.Fatalf("delta chunk: %d bytes, %v after %d incomplete", len(delta), err, incomplete)
+	}
+	// The keyframe comes in the body, the delta chunk as fragments checked
+	// against the CRC of the whole chunk
+	if d.stream != 1 || d.datagram != 1 || d.discarded > incomplete {
+		t.Errorf("%d chunks in the body, %d as fragments and %d discarded, want 1, 1 and at most %d", d.stream, d.datagram, d.discarded, incomplete)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newQUICServer serves cfg over HTTP/3 on a local port and returns its
+// address
+func newQUICServer(t *testing.T, cfg *config.Config) string {
+	t.Helper()
+	cfg.Admin.Addr = ""
+	srv, err := server.New(cfg, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		conn.Close()
+	})
+	return "https://" + conn.LocalAddr().String()
+}
+
+// chunkServer serves the streaming handler over HTTP/1.1 and logs the
+// chunks requested from it
+type chunkServer struct {
+	*httptest.Server
+	// corrupt flips a byte of the attempt-th response for chunk index,
+	// counted from 1, if it returns true
+	corrupt func(index, attempt int) bool
+
+	mu        sync.Mutex
+	requested []int
+	intact    [][]byte // bodies of the chunks sent uncorrupted
+}
+
+func newChunkServer(t *testing.T, opts ...streaming.Option) *chunkServer {
+	t.Helper()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := streaming.NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), opts...)
+	s := &chunkServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		index, err := strconv.Atoi(r.URL.Query().Get("chunk"))
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		s.mu.Lock()
+		s.requested = append(s.requested, index)
+		attempt := 0
+		for _, i := range s.requested {
+			if i == index {
+				attempt++
+			}
+		}
+		s.mu.Unlock()
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		body := rec.Body.Bytes()
+		if s.corrupt != nil && len(body) > 0 && s.corrupt(index, attempt) {
+			body[len(body)/2] ^= 0xff
+		} else if rec.Code == http.StatusOK {
+			s.mu.Lock()
+			s.intact = append(s.intact, body)
+			s.mu.Unlock()
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(body)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// chunks returns the chunks requested so far, in order
+func (s *chunkServer) chunks() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.requested...)
+}
+
+// play plays stream_001 at low from chunk 0 from s for d, with opts
+func (s *chunkServer) play(d time.Duration, opts playbackOptions) {
+	if opts.onCorrupt == "" {
+		opts.onCorrupt = corruptDiscard
+	}
+	if opts.chunk == 0 {
+		opts.chunk = 2 * time.Second
+	}
+	if opts.playout == nil {
+		opts.playout = client.NewPlayout(time.Now(), opts.chunk)
+	}
+	httpClient := s.Client()
+	pinger := client.NewPinger(httpClient, s.URL, time.Hour, 3, nil)
+	startStreaming(httpClient, pinger, nil, nil, s.URL, "stream_001", "low", "viewer", 0, d, opts)
+}
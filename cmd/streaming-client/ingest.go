@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// ingestFile pushes the contents of path into the server as the live stream
+// streamID at quality, one chunk of the quality's bitrate in the default
+// ladder every chunk duration, in real time. Every tenth chunk is sent as a
+// keyframe. The file is pushed again from the start until duration has
+// passed.
+func ingestFile(httpClient *http.Client, serverAddr, streamID, quality, path, token string, chunkDuration, duration time.Duration) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if len(content) == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	i := slices.IndexFunc(config.Default().Streaming.Ladder, func(q config.QualityConfig) bool { return q.Name == quality })
+	if i < 0 {
+		return fmt.Errorf("unknown quality %q", quality)
+	}
+	kbps := config.Default().Streaming.Ladder[i].BitrateKbps
+	size := min(int(float64(kbps)*1000/8*chunkDuration.Seconds()), streaming.MaxChunkSize)
+
+	body, pw := io.Pipe()
+	go func() {
+		line, _ := json.Marshal(streaming.IngestRequest{StreamID: streamID, Qualities: []string{quality}})
+		if _, err := pw.Write(append(line, '\n')); err != nil {
+			return
+		}
+		ticker := time.NewTicker(chunkDuration)
+		defer ticker.Stop()
+		timeout := time.After(duration)
+		offset := 0
+		for index := uint32(0); ; index++ {
+			chunk := make([]byte, size)
+			for n := 0; n < size; {
+				c := copy(chunk[n:], content[offset:])
+				n += c
+				offset = (offset + c) % len(content)
+			}
+			if err := streaming.WriteIngestFrame(pw, streaming.IngestFrame{Index: index, Keyframe: index%10 == 0, Data: chunk}); err != nil {
+				return
+			}
+			log.Printf("Ingested chunk %d: %d bytes", index, len(chunk))
+			select {
+			case <-ticker.C:
+			case <-timeout:
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/stream/ingest/%s", serverAddr, streamID), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := httpClient.Do(req)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("server returned status %d: %s", resp.StatusCode, msg)
+	}
+	var result streaming.IngestResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return fmt.Errorf("ingest cut short after %d chunks: %s", result.Chunks, result.Error)
+	}
+	log.Printf("Ingest completed: %d chunks, %d bytes", result.Chunks, result.Bytes)
+	return nil
+}
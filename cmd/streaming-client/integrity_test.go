@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+func TestCorruptChunkRejected(t *testing.T) {
+	s := newChunkServer(t)
+	s.corrupt = func(index, attempt int) bool { return index == 1 }
+
+	if _, _, err := getStreamChunk(context.Background(), s.Client(), s.URL, "stream_001", "low", "", 0, false); err != nil {
+		t.Errorf("intact chunk: %v", err)
+	}
+	if _, _, err := getStreamChunk(context.Background(), s.Client(), s.URL, "stream_001", "low", "", 1, false); !errors.Is(err, streaming.ErrChunkCorrupt) {
+		t.Errorf("corrupt chunk returned %v, want ErrChunkCorrupt", err)
+	}
+}
+
+func TestOnCorrupt(t *testing.T) {
+	tests := []struct {
+		onCorrupt string
+		corrupted int   // responses for chunk 1 corrupted
+		want      []int // first chunks requested
+	}{
+		{corruptRetransmit, 1, []int{0, 1, 1, 2}},
+		// Requested again at most maxRetransmits times
+		{corruptRetransmit, 5, []int{0, 1, 1, 1, 2}},
+		{corruptDiscard, 1, []int{0, 1, 2, 3}},
+	}
+	for _, tt := range tests {
+		s := newChunkServer(t)
+		s.corrupt = func(index, attempt int) bool { return index == 1 && attempt <= tt.corrupted }
+		s.play(time.Second, playbackOptions{onCorrupt: tt.onCorrupt})
+		if got := s.chunks(); len(got) < len(tt.want) || !slices.Equal(got[:len(tt.want)], tt.want) {
+			t.Errorf("%s of %d corrupt responses: requested chunks %v, want %v first", tt.onCorrupt, tt.corrupted, got, tt.want)
+		}
+	}
+}
@@ -1,26 +1,41 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync/atomic"
 	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
 )
 
 // StreamInfo represents video stream metadata
 type StreamInfo struct {
-	StreamID    string    `json:"stream_id"`
-	Title       string    `json:"title"`
-	Duration    int       `json:"duration"`
-	Bitrates    []Bitrate `json:"bitrates"`
-	Format      string    `json:"format"`
-	Resolution  string    `json:"resolution"`
-	FrameRate   int       `json:"frame_rate"`
-	CreatedAt   time.Time `json:"created_at"`
+	StreamID      string    `json:"stream_id"`
+	Title         string    `json:"title"`
+	Duration      int       `json:"duration"`
+	Bitrates      []Bitrate `json:"bitrates"`
+	Format        string    `json:"format"`
+	Resolution    string    `json:"resolution"`
+	FrameRate     int       `json:"frame_rate"`
+	ChunkDuration int       `json:"chunk_duration"` // milliseconds, 0 from servers that don't announce it
+	CreatedAt     time.Time `json:"created_at"`
+	StartChunk    int       `json:"start_chunk"` // of a live stream
 }
 
 // Bitrate represents different quality levels
@@ -33,13 +48,59 @@ type Bitrate struct {
 
 func main() {
 	var (
-		serverAddr = flag.String("server", "https://localhost:8443", "Server address")
-		streamID   = flag.String("stream", "stream_001", "Stream ID to play")
-		quality    = flag.String("quality", "medium", "Video quality (low, medium, high, ultra)")
-		duration   = flag.Duration("duration", 30*time.Second, "Playback duration")
-		protocol   = flag.String("protocol", "quic", "Protocol to use (quic or tcp)")
+		serverAddr   = flag.String("server", "https://localhost:8443", "Server address")
+		streamID     = flag.String("stream", "stream_001", "Stream ID to play")
+		quality      = flag.String("quality", "medium", "Video quality (low, medium, high, ultra)")
+		duration     = flag.Duration("duration", 30*time.Second, "Playback duration")
+		protocol     = flag.String("protocol", "quic", "Protocol to use (quic or tcp)")
+		pingInterval = flag.Duration("ping-interval", 10*time.Second, "Application ping interval")
+		pingMisses   = flag.Int("ping-misses", 3, "Missed pings before reconnecting")
+		abr          = flag.Bool("abr", false, "Report playback to the server and follow the quality it recommends")
+		reportEvery  = flag.Int("report-every", 5, "Chunks between playback reports with -abr")
+		session      = flag.String("session", "", "Session ID of the chunk requests, random with -abr if empty")
+		prioritize   = flag.Bool("prioritize-keyframes", false, "Request chunks without waiting for each other and have keyframes sent first")
+		delivery     = flag.String("delivery", streaming.DeliveryReliable, "Chunk delivery (reliable, or unreliable for delta chunks as datagrams over quic)")
+		maxKbps      = flag.Int("max-kbps", 0, "Ask the server to cap the session's bandwidth to this many kbps, 0 for its default")
+		save         = flag.String("save", "", "Append the payload of every chunk played to this file, in play order")
+		metricsOut   = flag.String("metrics-out", "", "Write the quality of experience of the playback to this file as JSON")
+		switchAt     = flag.String("switch-at", "", "Switch quality by hand at these times into the playback, e.g. 10s=high,30s=auto (auto hands it back to the server)")
+		onCorrupt    = flag.String("on-corrupt", corruptDiscard, "What to do with a chunk that fails its checksum (discard, or retransmit to request it again in the response body)")
+		ingest       = flag.String("ingest", "", "Push this file into the server as the live stream -stream at -quality instead of playing")
+		ingestToken  = flag.String("ingest-token", os.Getenv("INGEST_TOKEN"), "Bearer token for -ingest")
+		ingestChunk  = flag.Duration("ingest-chunk", streaming.SegmentDuration, "Playback time of each chunk pushed by -ingest, the server's chunk_duration")
 	)
 	flag.Parse()
+	if *protocol != "quic" && *protocol != "tcp" {
+		log.Fatalf("-protocol must be quic or tcp, not %q", *protocol)
+	}
+	if *reportEvery < 1 {
+		log.Fatal("-report-every must be at least 1")
+	}
+	if *abr && *prioritize {
+		log.Fatal("-abr and -prioritize-keyframes can't be combined")
+	}
+	if *delivery != streaming.DeliveryReliable && *delivery != streaming.DeliveryUnreliable {
+		log.Fatalf("-delivery must be reliable or unreliable, not %q", *delivery)
+	}
+	if *delivery == streaming.DeliveryUnreliable && (*protocol != "quic" || *prioritize) {
+		log.Fatal("-delivery unreliable needs -protocol quic, without -prioritize-keyframes")
+	}
+	if *maxKbps < 0 {
+		log.Fatal("-max-kbps must not be negative")
+	}
+	if (*save != "" || *metricsOut != "") && *prioritize {
+		log.Fatal("-save and -metrics-out can't be combined with -prioritize-keyframes")
+	}
+	if *onCorrupt != corruptDiscard && *onCorrupt != corruptRetransmit {
+		log.Fatalf("-on-corrupt must be discard or retransmit, not %q", *onCorrupt)
+	}
+	switches, err := parseSwitches(*switchAt)
+	if err != nil {
+		log.Fatal("Invalid -switch-at: ", err)
+	}
+	if len(switches) > 0 && *prioritize {
+		log.Fatal("-switch-at and -prioritize-keyframes can't be combined")
+	}
 
 	log.Printf("Starting streaming client")
 	log.Printf("Server: %s", *serverAddr)
@@ -47,19 +108,34 @@ func main() {
 	log.Printf("Quality: %s", *quality)
 	log.Printf("Duration: %v", *duration)
 	log.Printf("Protocol: %s", *protocol)
+	log.Printf("Delivery: %s", *delivery)
 
-	// Create HTTP client with TLS config
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
-		},
-		Timeout: 30 * time.Second,
+	// Create HTTP client with TLS config, over HTTP/3 to the QUIC server
+	transport := newTransport(*protocol)
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+	}
+
+	if *ingest != "" {
+		// An ingest lasts as long as -duration, beyond the request timeout
+		if err := ingestFile(&http.Client{Transport: transport}, *serverAddr, *streamID, *quality, *ingest, *ingestToken, *ingestChunk, *duration); err != nil {
+			log.Fatal("Ingest failed: ", err)
+		}
+		return
 	}
 
+	// Reconnect when the server stops answering pings
+	pinger := client.NewPinger(httpClient, *serverAddr, *pingInterval, *pingMisses, func() {
+		log.Printf("Server missed %d pings, reconnecting", *pingMisses)
+		transport.CloseIdleConnections()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pinger.Run(ctx)
+
 	// List available streams
-	streams, err := listStreams(client, *serverAddr)
+	streams, err := listStreams(httpClient, *serverAddr)
 	if err != nil {
 		log.Fatal("Failed to list streams:", err)
 	}
@@ -70,16 +146,81 @@ func main() {
 	}
 
 	// Get stream info
-	streamInfo, err := getStreamInfo(client, *serverAddr, *streamID)
+	streamInfo, err := getStreamInfo(httpClient, *serverAddr, *streamID)
 	if err != nil {
 		log.Fatal("Failed to get stream info:", err)
 	}
 
-	log.Printf("Stream info: %s - %s (%s, %d fps)", 
+	log.Printf("Stream info: %s - %s (%s, %d fps)",
 		streamInfo.StreamID, streamInfo.Title, streamInfo.Resolution, streamInfo.FrameRate)
 
+	chunk := time.Duration(streamInfo.ChunkDuration) * time.Millisecond
+	if chunk <= 0 {
+		chunk = streaming.SegmentDuration
+	}
+	var reports *reporter
+	if *abr {
+		reports = newReporter(httpClient, pinger, *serverAddr, *streamID, *session, *reportEvery, streamInfo.FrameRate, chunk)
+	}
+	if *maxKbps > 0 {
+		capSession := *session
+		if reports != nil {
+			capSession = reports.session
+		}
+		kbps, err := setBandwidthCap(httpClient, *serverAddr, capSession, *maxKbps)
+		if err != nil {
+			log.Fatal("Failed to cap bandwidth:", err)
+		}
+		log.Printf("Bandwidth cap: %d kbps", kbps)
+	}
+
 	// Start streaming
-	startStreaming(client, *serverAddr, *streamID, *quality, *duration)
+	if *prioritize {
+		startPrioritizedStreaming(httpClient, pinger, *serverAddr, *streamID, *quality, *session, streamInfo.StartChunk, *duration)
+		return
+	}
+	var chunks *datagramChunks
+	if *delivery == streaming.DeliveryUnreliable {
+		if chunks, err = dialDatagramChunks(*serverAddr); err != nil {
+			log.Fatal("Failed to open datagram connection:", err)
+		}
+		defer chunks.close()
+	}
+	opts := playbackOptions{onCorrupt: *onCorrupt, chunk: chunk, playout: client.NewPlayout(time.Now(), chunk), switches: switches}
+	if *save != "" {
+		f, err := os.Create(*save)
+		if err != nil {
+			log.Fatal("Failed to create -save file:", err)
+		}
+		defer f.Close()
+		opts.save = f
+	}
+	startStreaming(httpClient, pinger, reports, chunks, *serverAddr, *streamID, *quality, *session, streamInfo.StartChunk, *duration, opts)
+
+	qoe := opts.playout.Report(time.Now())
+	logQoE(qoe)
+	if *metricsOut != "" {
+		data, _ := json.MarshalIndent(qoe, "", "  ")
+		if err := os.WriteFile(*metricsOut, append(data, '\n'), 0o644); err != nil {
+			log.Fatal("Failed to write -metrics-out file:", err)
+		}
+	}
+}
+
+// newTransport returns an HTTP/3 transport for quic, and an HTTP/1.1 or
+// HTTP/2 one over TLS for tcp
+func newTransport(protocol string) interface {
+	http.RoundTripper
+	CloseIdleConnections()
+} {
+	if protocol == "quic" {
+		return quiclib.NewClientTransport(config.QUICConfig{}, nil)
+	}
+	return &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+	}
 }
 
 func listStreams(client *http.Client, serverAddr string) ([]StreamInfo, error) {
@@ -118,13 +259,73 @@ func getStreamInfo(client *http.Client, serverAddr, streamID string) (*StreamInf
 	return &streamInfo, nil
 }
 
-func startStreaming(client *http.Client, serverAddr, streamID, quality string, duration time.Duration) {
+// setBandwidthCap asks the server to cap session to kbps and returns the cap
+// it put in effect
+func setBandwidthCap(client *http.Client, serverAddr, session string, kbps int) (int, error) {
+	body, _ := json.Marshal(streaming.BandwidthCap{MaxKbps: kbps})
+	req, err := http.NewRequest(http.MethodPost, serverAddr+"/stream/cap", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	var c streaming.BandwidthCap
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return 0, err
+	}
+	return c.MaxKbps, nil
+}
+
+// chunkInterval is how often chunks are requested
+const chunkInterval = 100 * time.Millisecond
+
+// What startStreaming does with a chunk that fails its checksum: count it
+// and skip it like a lost one, or request it again, in the response body
+// even with unreliable delivery, up to maxRetransmits times before skipping
+// it
+const (
+	corruptDiscard    = "discard"
+	corruptRetransmit = "retransmit"
+)
+
+const maxRetransmits = 2
+
+// playbackOptions are the settings of startStreaming besides what to play
+type playbackOptions struct {
+	onCorrupt string
+	save      io.Writer     // receives the payload of every chunk played, nil for none
+	chunk     time.Duration // playback time of a chunk
+	playout   *client.Playout
+	switches  []scheduledSwitch
+}
+
+// startStreaming plays streamID from chunkIndex on for duration, one chunk
+// every chunkInterval, and plays out every chunk received in opts.playout
+func startStreaming(client *http.Client, pinger *client.Pinger, reports *reporter, chunks *datagramChunks, serverAddr, streamID, quality, session string, chunkIndex int, duration time.Duration, opts playbackOptions) {
+	// The response headers of a chunk are its first byte, and may be
+	// traced outside of this goroutine
+	var firstByte atomic.Int64
+	ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() { firstByte.CompareAndSwap(0, time.Now().UnixNano()) },
+	})
 	start := time.Now()
-	chunkIndex := 0
 	totalBytes := int64(0)
 	chunksReceived := 0
+	corrupt, retransmits := 0, 0
+	skipped := 0
+	var keyframes keyframeCadence
 
-	ticker := time.NewTicker(100 * time.Millisecond) // Request chunks every 100ms
+	ticker := time.NewTicker(chunkInterval)
 	defer ticker.Stop()
 
 	timeout := time.After(duration)
@@ -135,23 +336,103 @@ func startStreaming(client *http.Client, serverAddr, streamID, quality string, d
 		select {
 		case <-ticker.C:
 			chunkStart := time.Now()
-			
-			bytes, err := getStreamChunk(client, serverAddr, streamID, quality, chunkIndex)
-			if err != nil {
-				log.Printf("Failed to get chunk %d: %v", chunkIndex, err)
-				continue
-			}
 
+			if reports != nil {
+				session = reports.session
+			}
+			// The server switches at the next keyframe, and serves it at
+			// the quality switched to whatever is asked for
+			for len(opts.switches) > 0 && time.Since(start) >= opts.switches[0].at {
+				to := opts.switches[0].quality
+				opts.switches = opts.switches[1:]
+				if ack, err := requestSwitch(client, serverAddr, streamID, session, to, chunkIndex); err != nil {
+					log.Printf("Failed to switch to %s: %v", to, err)
+				} else if ack.Pinned {
+					log.Printf("Switch %d to %s from chunk %d", ack.Seq, ack.Quality, ack.FromChunk)
+				} else {
+					log.Printf("Switch %d: quality handed back to the server", ack.Seq)
+				}
+			}
+			var bytes []byte
+			var chunk served
+			var err error
+			if chunks != nil && retransmits == 0 {
+				bytes, chunk, err = chunks.get(ctx, streamID, quality, session, chunkIndex)
+			} else {
+				bytes, chunk, err = getStreamChunk(ctx, client, serverAddr, streamID, quality, session, chunkIndex, false)
+			}
 			latency := time.Since(chunkStart)
-			totalBytes += int64(len(bytes))
-			chunksReceived++
-			chunkIndex++
+			if t := firstByte.Load(); t != 0 {
+				opts.playout.FirstByte(time.Unix(0, t))
+			}
+			if errors.Is(err, streaming.ErrChunkCorrupt) {
+				corrupt++
+				if reports != nil {
+					reports.corrupted()
+				}
+				if opts.onCorrupt == corruptRetransmit && retransmits < maxRetransmits {
+					log.Printf("Chunk %d corrupt, requesting it again: %v", chunkIndex, err)
+					retransmits++
+				} else {
+					log.Printf("Chunk %d corrupt, skipped: %v", chunkIndex, err)
+					chunkIndex++
+					skipped++
+					retransmits = 0
+					if reports != nil {
+						reports.lost()
+					}
+				}
+			} else if errors.Is(err, errChunkIncomplete) {
+				// Lost fragments lose the chunk, which isn't asked for again
+				log.Printf("Chunk %d incomplete, skipped", chunkIndex)
+				chunkIndex++
+				skipped++
+				retransmits = 0
+				if reports != nil {
+					reports.lost()
+				}
+			} else if err != nil {
+				log.Printf("Failed to get chunk %d: %v", chunkIndex, err)
+				if reports != nil {
+					reports.lost()
+				}
+			} else {
+				totalBytes += int64(len(bytes))
+				chunksReceived++
+				if chunk.quality != "" && chunk.quality != quality {
+					log.Printf("Quality switched from %s to %s at chunk %d", quality, chunk.quality, chunkIndex)
+					quality = chunk.quality
+				}
+				if chunk.role == streaming.RoleKeyframe {
+					keyframes.add(chunkIndex)
+				}
+				opts.playout.Chunk(time.Now(), quality, len(bytes), opts.chunk, chunk.role == streaming.RoleKeyframe)
+				if opts.save != nil {
+					if _, err := opts.save.Write(bytes); err != nil {
+						log.Fatal("Failed to save chunk:", err)
+					}
+				}
+				chunkIndex++
+				retransmits = 0
+				if reports != nil {
+					reports.received(len(bytes), latency)
+				}
+				log.Printf("Chunk %d: %d bytes, %.2f ms latency", chunkIndex, len(bytes), float64(latency.Nanoseconds())/1e6)
+			}
 
-			log.Printf("Chunk %d: %d bytes, %.2f ms latency", chunkIndex, len(bytes), float64(latency.Nanoseconds())/1e6)
+			if reports != nil && reports.due() {
+				next, err := reports.report(quality)
+				if err != nil {
+					log.Printf("Failed to report playback: %v", err)
+				} else if next != quality {
+					log.Printf("Quality changed from %s to %s", quality, next)
+					quality = next
+				}
+			}
 
 		case <-timeout:
 			elapsed := time.Since(start)
-			avgBandwidth := float64(totalBytes*8) / elapsed.Seconds() / 1e6 // Mbps
+			avgBandwidth := float64(totalBytes*8) / elapsed.Seconds() / 1e6  // Mbps
 			avgLatency := elapsed.Seconds() * 1000 / float64(chunksReceived) // ms per chunk
 
 			log.Printf("Streaming completed:")
@@ -160,23 +441,122 @@ func startStreaming(client *http.Client, serverAddr, streamID, quality string, d
 			log.Printf("  Total bytes: %d", totalBytes)
 			log.Printf("  Average bandwidth: %.2f Mbps", avgBandwidth)
 			log.Printf("  Average chunk latency: %.2f ms", avgLatency)
+			log.Printf("  Chunks corrupt: %d", corrupt)
+			log.Printf("  Chunks skipped: %d", skipped)
+			log.Printf("  Keyframes: %d, %s", keyframes.count, keyframes)
+			if chunks != nil {
+				log.Printf("  Chunks as datagrams: %d", chunks.datagram)
+				log.Printf("  Chunks on the stream: %d", chunks.stream)
+				log.Printf("  Chunks incomplete: %d", chunks.discarded)
+			}
+			log.Printf("  Last ping RTT: %v", pinger.LastRTT())
 			return
 		}
 	}
 }
 
-func getStreamChunk(client *http.Client, serverAddr, streamID, quality string, chunkIndex int) ([]byte, error) {
+// served is what the server tells of a chunk it sent
+type served struct {
+	role    string // streaming.RoleKeyframe or streaming.RoleDelta
+	quality string // the one switched to by hand, if not the one asked for
+}
+
+// servedBy returns what the header of a chunk response tells of it
+func servedBy(h http.Header) served {
+	return served{role: h.Get(streaming.RoleHeader), quality: h.Get("X-Quality")}
+}
+
+// getStreamChunk fetches a chunk and returns it with what the server tells
+// of it
+func getStreamChunk(ctx context.Context, client *http.Client, serverAddr, streamID, quality, session string, chunkIndex int, prioritize bool) ([]byte, served, error) {
 	url := fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=%d", serverAddr, streamID, quality, chunkIndex)
-	
-	resp, err := client.Get(url)
+	if prioritize {
+		url += "&" + streaming.PrioritizeParam + "=true"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, served{}, err
+	}
+	// Lets the server measure pacing against the client's schedule
+	req.Header.Set(streaming.ChunkIntervalHeader, chunkInterval.String())
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, served{}, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+		return nil, served{}, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	// Never buffer more than the largest chunk the server can produce
+	data, err := io.ReadAll(io.LimitReader(resp.Body, streaming.MaxChunkMessageSize+1))
+	if err != nil {
+		return nil, served{}, err
 	}
+	if len(data) > streaming.MaxChunkMessageSize {
+		return nil, served{}, fmt.Errorf("chunk exceeds %d bytes", streaming.MaxChunkMessageSize)
+	}
+	// Chunks served from disk carry the checksum of the segment
+	if want := resp.Header.Get(streaming.ChecksumHeader); want != "" {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != want {
+			return nil, served{}, fmt.Errorf("chunk checksum %s does not match %s", got, want)
+		}
+	}
+	if err := streaming.VerifyChunk(data, resp.Header.Get(streaming.CRCHeader)); err != nil {
+		return nil, served{}, err
+	}
+	return data, servedBy(resp.Header), nil
+}
 
-	return io.ReadAll(resp.Body)
-}
\ No newline at end of file
+// keyframeCadence follows how far apart the keyframes played are
+type keyframeCadence struct {
+	count int
+	first int // index of the first keyframe
+	last  int // and of the latest one
+}
+
+// add counts the keyframe at chunk index
+func (k *keyframeCadence) add(index int) {
+	if k.count == 0 {
+		k.first = index
+	}
+	k.last = index
+	k.count++
+}
+
+// String describes the mean interval between keyframes
+func (k keyframeCadence) String() string {
+	if k.count < 2 {
+		return "cadence unknown"
+	}
+	return fmt.Sprintf("one every %.1f chunks", float64(k.last-k.first)/float64(k.count-1))
+}
+
+// logQoE prints the quality of experience of the playback
+func logQoE(q client.QoE) {
+	ms := func(v *float64) string {
+		if v == nil {
+			return "n/a"
+		}
+		return fmt.Sprintf("%.2f ms", *v)
+	}
+	log.Printf("Quality of experience:")
+	log.Printf("  Time to first byte: %s", ms(q.TimeToFirstByte))
+	log.Printf("  Time to first keyframe: %s", ms(q.TimeToFirstKeyframe))
+	log.Printf("  Startup delay: %s", ms(q.StartupDelay))
+	log.Printf("  Rebuffers: %d, %.2fs stalled", q.Rebuffers, q.StallTime)
+	log.Printf("  Watch time: %.2fs", q.WatchTime)
+	for quality, seconds := range q.QualityWatchTime {
+		log.Printf("    %s: %.2fs", quality, seconds)
+	}
+	log.Printf("  Average bitrate: %.0f kbps", q.AverageBitrate)
+	log.Printf("  Bytes received: %d", q.BytesReceived)
+	log.Printf("  Quality switches: %d", q.QualitySwitches)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// newTestClient creates a client over the transport of protocol
+func newTestClient(t *testing.T, protocol string) *http.Client {
+	t.Helper()
+	transport := newTransport(protocol)
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 5 * time.Second}
+}
+
+func TestPlaysOverHTTP3(t *testing.T) {
+	serverAddr := newQUICServer(t, config.Default())
+	httpClient := newTestClient(t, "quic")
+
+	resp, err := httpClient.Get(serverAddr + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 3 {
+		t.Errorf("ping answered over %s, want HTTP/3", resp.Proto)
+	}
+
+	streams, err := listStreams(httpClient, serverAddr)
+	if err != nil || len(streams) == 0 {
+		t.Fatalf("listed streams %v, %v", streams, err)
+	}
+	info, err := getStreamInfo(httpClient, serverAddr, streams[0].StreamID)
+	if err != nil || info.StreamID != streams[0].StreamID || len(info.Bitrates) == 0 {
+		t.Fatalf("stream info %+v, %v", info, err)
+	}
+	data, chunk, err := getStreamChunk(context.Background(), httpClient, serverAddr, info.StreamID, info.Bitrates[0].Quality, "", 0, false)
+	if err != nil || len(data) == 0 {
+		t.Fatalf("chunk 0: %d bytes, %v", len(data), err)
+	}
+	if chunk.role == "" {
+		t.Error("chunk 0 has no role")
+	}
+}
+
+func TestTCPTransport(t *testing.T) {
+	if _, ok := newTransport("tcp").(*http.Transport); !ok {
+		t.Error("tcp doesn't use an HTTP/1.1 or HTTP/2 transport")
+	}
+}
+
+func TestPlaybackFeedsPlayout(t *testing.T) {
+	s := newChunkServer(t)
+	start := time.Now()
+	playout := client.NewPlayout(start, 2*time.Second)
+	s.play(350*time.Millisecond, playbackOptions{playout: playout})
+
+	q := playout.Report(time.Now())
+	if q.Chunks != len(s.chunks()) || q.Chunks < 2 || q.BytesReceived == 0 {
+		t.Errorf("played out %d chunks of %d bytes, want the %d received", q.Chunks, q.BytesReceived, len(s.chunks()))
+	}
+	// Chunk 0 is a keyframe, and 2s of media start playback
+	if q.TimeToFirstByte == nil || q.TimeToFirstKeyframe == nil || q.StartupDelay == nil || *q.TimeToFirstByte > *q.TimeToFirstKeyframe {
+		t.Errorf("QoE %+v, want the times to first byte, keyframe and startup", q)
+	}
+}
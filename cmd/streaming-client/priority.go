@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/client"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// maxInFlight bounds the chunk requests outstanding with
+// -prioritize-keyframes
+const maxInFlight = 8
+
+// fetched is the outcome of a chunk request
+type fetched struct {
+	index   int
+	role    string
+	size    int
+	latency time.Duration
+	err     error
+}
+
+// startPrioritizedStreaming requests a chunk every chunkInterval, from chunk
+// next on, without waiting for the ones before, each on a stream of its own,
+// and has the server send keyframes ahead of delta chunks. Chunks are played in index
+// order: a delta chunk that arrives after a later keyframe is too late to
+// play and is skipped, like one that never arrives.
+func startPrioritizedStreaming(httpClient *http.Client, pinger *client.Pinger, serverAddr, streamID, quality, session string, next int, duration time.Duration) {
+	start := time.Now()
+	results := make(chan fetched, maxInFlight)
+	ticker := time.NewTicker(chunkInterval)
+	defer ticker.Stop()
+	timeout := time.After(duration)
+
+	inFlight := 0
+	keyframe := -1 // latest keyframe received
+	var keyframes, deltas, late, lost int
+	var keyframeLatency, deltaLatency time.Duration
+	var totalBytes int64
+
+	log.Printf("Starting stream playback with keyframe priority...")
+
+	for {
+		select {
+		case <-ticker.C:
+			if inFlight >= maxInFlight {
+				continue
+			}
+			inFlight++
+			go func(index int) {
+				chunkStart := time.Now()
+				data, chunk, err := getStreamChunk(context.Background(), httpClient, serverAddr, streamID, quality, session, index, true)
+				results <- fetched{index: index, role: chunk.role, size: len(data), latency: time.Since(chunkStart), err: err}
+			}(next)
+			next++
+
+		case f := <-results:
+			inFlight--
+			if f.err != nil {
+				lost++
+				log.Printf("Failed to get chunk %d: %v", f.index, f.err)
+				continue
+			}
+			totalBytes += int64(f.size)
+			switch {
+			case f.role == streaming.RoleKeyframe:
+				keyframes++
+				keyframeLatency += f.latency
+				keyframe = max(keyframe, f.index)
+			case f.index < keyframe:
+				late++
+				log.Printf("Chunk %d arrived after keyframe %d, skipped", f.index, keyframe)
+				continue
+			default:
+				deltas++
+				deltaLatency += f.latency
+			}
+			log.Printf("Chunk %d (%s): %d bytes, %.2f ms latency", f.index, f.role, f.size, float64(f.latency.Nanoseconds())/1e6)
+
+		case <-timeout:
+			elapsed := time.Since(start)
+			log.Printf("Streaming completed:")
+			log.Printf("  Duration: %v", elapsed)
+			log.Printf("  Keyframes received: %d", keyframes)
+			log.Printf("  Delta chunks received: %d", deltas)
+			log.Printf("  Delta chunks late: %d", late)
+			log.Printf("  Chunks lost: %d", lost)
+			log.Printf("  Total bytes: %d", totalBytes)
+			log.Printf("  Average bandwidth: %.2f Mbps", float64(totalBytes*8)/elapsed.Seconds()/1e6)
+			if keyframes > 0 {
+				log.Printf("  Average keyframe latency: %.2f ms", float64(keyframeLatency.Milliseconds())/float64(keyframes))
+			}
+			if deltas > 0 {
+				log.Printf("  Average delta chunk latency: %.2f ms", float64(deltaLatency.Milliseconds())/float64(deltas))
+			}
+			log.Printf("  Last ping RTT: %v", pinger.LastRTT())
+			return
+		}
+	}
+}
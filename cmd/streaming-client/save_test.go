@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestKeyframeCadence(t *testing.T) {
+	var k keyframeCadence
+	if got := k.String(); got != "cadence unknown" {
+		t.Errorf("no keyframes: %q", got)
+	}
+	k.add(10)
+	if got := k.String(); got != "cadence unknown" {
+		t.Errorf("one keyframe: %q", got)
+	}
+	k.add(20)
+	k.add(35)
+	if got := k.String(); k.count != 3 || got != "one every 12.5 chunks" {
+		t.Errorf("keyframes at 10, 20 and 35: %d, %q", k.count, got)
+	}
+}
+
+func TestSaveHoldsChunksPlayed(t *testing.T) {
+	s := newChunkServer(t)
+	s.corrupt = func(index, attempt int) bool { return index == 1 }
+	var saved bytes.Buffer
+	s.play(500*time.Millisecond, playbackOptions{save: &saved})
+
+	// Every intact chunk in play order, and the corrupt one skipped
+	want := bytes.Join(s.intact, nil)
+	if len(s.chunks()) < 3 || !bytes.Equal(saved.Bytes(), want) {
+		t.Errorf("saved %d bytes of chunks %v, want the %d bytes of the intact ones", saved.Len(), s.chunks(), len(want))
+	}
+}
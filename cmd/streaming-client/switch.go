@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// switchAuto in -switch-at hands the quality back to the server's adapter
+const switchAuto = "auto"
+
+// scheduledSwitch is a quality switch of -switch-at
+type scheduledSwitch struct {
+	at      time.Duration // into the playback
+	quality string        // or switchAuto
+}
+
+// parseSwitches parses -switch-at, e.g. "10s=high,30s=auto", into switches
+// ordered by time
+func parseSwitches(s string) ([]scheduledSwitch, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var switches []scheduledSwitch
+	for _, part := range strings.Split(s, ",") {
+		at, quality, ok := strings.Cut(part, "=")
+		if !ok || quality == "" {
+			return nil, fmt.Errorf("%q is not TIME=QUALITY", part)
+		}
+		d, err := time.ParseDuration(at)
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid time %q", at)
+		}
+		switches = append(switches, scheduledSwitch{at: d, quality: quality})
+	}
+	slices.SortStableFunc(switches, func(a, b scheduledSwitch) int { return int(a.at - b.at) })
+	return switches, nil
+}
+
+// requestSwitch asks the server to switch session to quality, or to hand it
+// back to the adapter, from chunk on
+func requestSwitch(client *http.Client, serverAddr, streamID, session, quality string, chunk int) (streaming.QualitySwitch, error) {
+	change := streaming.QualityChangeRequest{Quality: quality, Chunk: chunk}
+	if quality == switchAuto {
+		change = streaming.QualityChangeRequest{Auto: true, Chunk: chunk}
+	}
+	body, _ := json.Marshal(change)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/stream/quality/%s", serverAddr, streamID), bytes.NewReader(body))
+	if err != nil {
+		return streaming.QualitySwitch{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if session != "" {
+		req.Header.Set("X-Session-ID", session)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return streaming.QualitySwitch{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return streaming.QualitySwitch{}, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	var ack streaming.QualitySwitch
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+		return streaming.QualitySwitch{}, err
+	}
+	return ack, nil
+}
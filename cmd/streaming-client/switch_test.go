@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseSwitches(t *testing.T) {
+	switches, err := parseSwitches("30s=auto,10s=high,10s=medium")
+	want := []scheduledSwitch{{10 * time.Second, "high"}, {10 * time.Second, "medium"}, {30 * time.Second, switchAuto}}
+	if err != nil || len(switches) != len(want) {
+		t.Fatalf("got %v, %v, want %v", switches, err, want)
+	}
+	for i := range want {
+		if switches[i] != want[i] {
+			t.Errorf("switch %d is %v, want %v", i, switches[i], want[i])
+		}
+	}
+	if switches, err := parseSwitches(""); err != nil || switches != nil {
+		t.Errorf("no switches: %v, %v", switches, err)
+	}
+	for _, s := range []string{"10s", "10s=", "soon=high", "-1s=high"} {
+		if _, err := parseSwitches(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestRequestSwitch(t *testing.T) {
+	s := newChunkServer(t)
+	httpClient := s.Client()
+
+	ack, err := requestSwitch(httpClient, s.URL, "stream_001", "viewer", "high", 3)
+	if err != nil || !ack.Pinned || ack.Quality != "high" || ack.Seq != 1 || ack.FromChunk != 10 {
+		t.Fatalf("switch to high: %+v, %v, want it pinned from keyframe 10", ack, err)
+	}
+	_, chunk, err := getStreamChunk(context.Background(), httpClient, s.URL, "stream_001", "low", "viewer", 10, false)
+	if err != nil || chunk.quality != "high" {
+		t.Errorf("chunk 10 served at %q, %v, want high", chunk.quality, err)
+	}
+
+	if ack, err := requestSwitch(httpClient, s.URL, "stream_001", "viewer", switchAuto, 11); err != nil || ack.Pinned || ack.Seq != 2 {
+		t.Errorf("switch to auto: %+v, %v, want switch 2 unpinned", ack, err)
+	}
+	if _, err := requestSwitch(httpClient, s.URL, "stream_001", "viewer", "8k", 12); err == nil {
+		t.Error("switch to a quality off the ladder accepted")
+	}
+}
@@ -2,55 +2,82 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
+	"errors"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
-	"github.com/nik1740/quic-communication-system/internal/tcp"
+	"github.com/nik1740/quic-communication-system/internal/server"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
 )
 
 func main() {
 	var (
-		addr     = flag.String("addr", ":8080", "Server address")
-		protocol = flag.String("protocol", "tcp", "Protocol (tcp or quic)")
-		certFile = flag.String("cert", "", "TLS certificate file")
-		keyFile  = flag.String("key", "", "TLS key file")
+		addr       = flag.String("addr", "", "Server address (overrides server.tcp.addr)")
+		protocol   = flag.String("protocol", "tcp", "Protocol (tcp or quic)")
+		certFile   = flag.String("cert", "", "TLS certificate file (overrides server.tcp.tls)")
+		keyFile    = flag.String("key", "", "TLS key file (overrides server.tcp.tls)")
+		configFile = flag.String("config", os.Getenv("CONFIG_FILE"), "Path to YAML config file")
 	)
 	flag.Parse()
 
-	log.Printf("Starting %s server on %s", *protocol, *addr)
-
-	// Generate TLS certificate if not provided
-	var tlsConfig *tls.Config
-	if *certFile == "" || *keyFile == "" {
-		cert, err := quiclib.GenerateSelfSignedCert()
-		if err != nil {
-			log.Fatal("Failed to generate certificate:", err)
-		}
-		tlsConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	if *addr == "" {
+		*addr = cfg.Server.TCP.Addr
 	} else {
-		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
-		if err != nil {
-			log.Fatal("Failed to load certificate:", err)
-		}
-		tlsConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
+		cfg.Server.TCP.Addr = *addr
+		cfg.SetSource("server.tcp.addr", config.SourceFlag)
+	}
+	if *certFile != "" || *keyFile != "" {
+		cfg.Server.TCP.TLS = &config.TLSConfig{CertFile: *certFile, KeyFile: *keyFile}
+		cfg.SetSource("server.tcp.tls", config.SourceFlag)
+		if err := cfg.Validate(); err != nil {
+			log.Fatal("Invalid TLS flags:", err)
 		}
 	}
 
-	// Create and start server
-	server := tcp.NewServer(*addr, tlsConfig)
+	logger, err := logging.New(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to configure logging:", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal("Failed to configure tracing:", err)
+	}
+
+	log.Printf("Starting %s server on %s", *protocol, *addr)
+
+	srv, tcpServer, err := server.NewTCP(cfg, logger)
+	if err != nil {
+		log.Fatal("Failed to create server:", err)
+	}
+
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	srv.RunBackground(monitorCtx)
+
+	// Admin API with metrics
+	if adminServer := srv.Admin(); adminServer != nil {
+		go func() {
+			if err := adminServer.Start(); err != nil {
+				log.Printf("Admin server failed: %v", err)
+			}
+		}()
+	}
 
 	// Start server in a goroutine
 	go func() {
-		if err := server.Start(); err != nil {
+		if err := tcpServer.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatal("Server failed:", err)
 		}
 	}()
@@ -64,10 +91,16 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := server.Stop(); err != nil {
+	stopMonitor()
+	if err := tcpServer.Stop(); err != nil {
 		log.Printf("Server shutdown error: %v", err)
 	}
-	
+	// Stops the components and the admin API
+	srv.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Tracing shutdown error: %v", err)
+	}
+
 	// Wait for context timeout
 	<-ctx.Done()
-}
\ No newline at end of file
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// AggregatesHandler reports the windowed aggregates of a device's readings:
+//
+//	GET /api/aggregates?device_id=temp_01&window=5m
+//
+// Without window every configured window length is reported.
+func AggregatesHandler(aggregates *iot.Aggregator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		deviceID := r.URL.Query().Get("device_id")
+		if deviceID == "" {
+			writeError(w, http.StatusBadRequest, "device_id is required")
+			return
+		}
+		var window time.Duration
+		if v := r.URL.Query().Get("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || !slices.Contains(aggregates.Windows(), d) {
+				writeError(w, http.StatusBadRequest, "window must be one of the configured iot.aggregation.windows")
+				return
+			}
+			window = d
+		}
+		writeJSON(w, http.StatusOK, aggregates.Aggregates(deviceID, window))
+	}
+}
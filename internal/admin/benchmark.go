@@ -0,0 +1,242 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// Caps of an on-demand benchmark
+const (
+	maxBenchmarkDuration = 60 * time.Second
+	maxBenchmarkClients  = 50
+
+	// loopbackQUICWindow is the receive window of the HTTP/3 client,
+	// quic-go's default maximum stream window
+	loopbackQUICWindow = 6 << 20
+)
+
+// benchmarkTests are the test types an on-demand benchmark may run
+var benchmarkTests = map[string]bool{"latency": true, "throughput": true, "iot": true}
+
+// BenchmarkRequest is the scenario of POST /api/benchmark
+type BenchmarkRequest struct {
+	Test     string `json:"test"`               // latency, throughput or iot; default latency
+	Duration string `json:"duration"`           // at most 60s; default 10s
+	Clients  int    `json:"clients"`            // concurrent clients, at most 50; default 4
+	Size     int    `json:"size"`               // request payload size; default 1024
+	Endpoint string `json:"endpoint,omitempty"` // peer to benchmark instead of this server
+	Protocol string `json:"protocol,omitempty"` // of endpoint, quic or tcp; default this server's
+}
+
+// BenchmarkEvent is a line of the POST /api/benchmark response: progress
+// once a second, then the result or the error that ended the run
+type BenchmarkEvent struct {
+	ElapsedMs int64                 `json:"elapsed_ms"`
+	Requests  int64                 `json:"requests"`
+	Failed    int64                 `json:"failed"`
+	Result    *benchmark.TestResult `json:"result,omitempty"`
+	Error     string                `json:"error,omitempty"`
+}
+
+// benchmarkRunner runs one benchmark at a time and keeps the last result
+type benchmarkRunner struct {
+	protocol string
+	endpoint string
+	logger   logging.Logger
+
+	run sync.Mutex // held while a benchmark runs
+
+	mu   sync.Mutex
+	last *benchmark.TestResult
+}
+
+// EnableBenchmark mounts /api/benchmark, which benchmarks the server's
+// listener at addr (or a peer) with the benchmark client:
+//
+//	POST /api/benchmark  run a scenario, progress and result as NDJSON
+//	GET  /api/benchmark  whether a run is in progress and the last result
+//
+// protocol is the listener's, quic or tcp. Benchmarks generate load, so the
+// endpoint requires the admin token and refuses to run without one.
+func (s *Server) EnableBenchmark(token, protocol, addr string) {
+	b := &benchmarkRunner{
+		protocol: protocol,
+		endpoint: loopbackURL(addr),
+		logger:   s.logger.Named("benchmark"),
+	}
+	if token == "" {
+		s.HandleFunc("/api/benchmark", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "benchmarks require admin.token")
+		})
+		return
+	}
+	s.Handle("/api/benchmark", requireToken(token, b))
+}
+
+func (b *benchmarkRunner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		running := !b.run.TryLock()
+		if !running {
+			b.run.Unlock()
+		}
+		b.mu.Lock()
+		last := b.last
+		b.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"running": running, "last": last})
+	case http.MethodPost:
+		b.start(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (b *benchmarkRunner) start(w http.ResponseWriter, r *http.Request) {
+	// Read the body in full so that no read deadline outlives it
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body")
+		return
+	}
+	var req BenchmarkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	cfg, err := b.testConfig(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !b.run.TryLock() {
+		writeError(w, http.StatusConflict, "a benchmark is already running")
+		return
+	}
+	defer b.run.Unlock()
+
+	// The run outlasts the admin server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(cfg.Duration + 30*time.Second))
+
+	bench := benchmark.NewBenchmarker(cfg, b.logger)
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	type outcome struct {
+		result *benchmark.TestResult
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, err := bench.Run(ctx)
+		done <- outcome{result, err}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	start := time.Now()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			requests, failed := bench.Progress()
+			enc.Encode(BenchmarkEvent{ElapsedMs: time.Since(start).Milliseconds(), Requests: requests, Failed: failed})
+			rc.Flush()
+		case o := <-done:
+			event := BenchmarkEvent{ElapsedMs: time.Since(start).Milliseconds()}
+			if o.err != nil {
+				event.Error = o.err.Error()
+			} else {
+				event.Requests, event.Failed, event.Result = o.result.SuccessRequests+o.result.FailedRequests, o.result.FailedRequests, o.result
+				b.mu.Lock()
+				b.last = o.result
+				b.mu.Unlock()
+			}
+			enc.Encode(event)
+			return
+		}
+	}
+}
+
+// testConfig validates req and fills in its defaults
+func (b *benchmarkRunner) testConfig(req BenchmarkRequest) (benchmark.TestConfig, error) {
+	cfg := benchmark.TestConfig{
+		Protocol:    b.protocol,
+		Endpoint:    b.endpoint,
+		TestType:    "latency",
+		Duration:    10 * time.Second,
+		Clients:     4,
+		RequestSize: 1024,
+	}
+	if req.Test != "" {
+		if !benchmarkTests[req.Test] {
+			return cfg, errors.New("test must be latency, throughput or iot")
+		}
+		cfg.TestType = req.Test
+	}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxBenchmarkDuration {
+			return cfg, fmt.Errorf("duration must be between 0 and %v", maxBenchmarkDuration)
+		}
+		cfg.Duration = d
+	}
+	if req.Clients != 0 {
+		if req.Clients < 0 || req.Clients > maxBenchmarkClients {
+			return cfg, fmt.Errorf("clients must be between 1 and %d", maxBenchmarkClients)
+		}
+		cfg.Clients = req.Clients
+	}
+	if req.Size != 0 {
+		if req.Size < 0 || req.Size > 1<<20 {
+			return cfg, fmt.Errorf("size must be between 1 and %d", 1<<20)
+		}
+		cfg.RequestSize = req.Size
+	}
+	if req.Protocol != "" {
+		if req.Protocol != "quic" && req.Protocol != "tcp" {
+			return cfg, errors.New("protocol must be quic or tcp")
+		}
+		cfg.Protocol = req.Protocol
+	}
+	if req.Endpoint != "" {
+		u, err := url.Parse(req.Endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return cfg, errors.New("endpoint must be an http or https URL")
+		}
+		cfg.Endpoint = req.Endpoint
+	} else if cfg.Protocol != b.protocol {
+		return cfg, fmt.Errorf("protocol %s needs an endpoint", cfg.Protocol)
+	}
+	if cfg.Protocol == "quic" {
+		// Use the real HTTP/3 client, QUIC listeners don't accept TCP
+		cfg.QUICWindow = loopbackQUICWindow
+	}
+	return cfg, nil
+}
+
+// loopbackURL returns the https URL of the listener at addr, reaching
+// wildcard addresses over loopback
+func loopbackURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "https://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return "https://" + net.JoinHostPort(host, port)
+}
@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestBenchmarkAgainstListener(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/benchmark/", benchmark.EchoHandler("TCP", "keep-alive"))
+	listener := httptest.NewTLSServer(mux)
+	defer listener.Close()
+
+	s := NewServer("", logging.Nop())
+	s.EnableBenchmark(testToken, "tcp", listener.Listener.Addr().String())
+
+	if rec := call(s, http.MethodPost, "/api/benchmark", `{}`, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST without a token: status %d, want 401", rec.Code)
+	}
+	for _, body := range []string{
+		`{"test": "streaming"}`,
+		`{"duration": "2m"}`,
+		`{"clients": 51}`,
+		`{"protocol": "quic"}`,
+		`{"endpoint": "ftp://peer"}`,
+		`not json`,
+	} {
+		if rec := call(s, http.MethodPost, "/api/benchmark", body, testToken); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec := call(s, http.MethodPost, "/api/benchmark", `{"duration": "300ms", "clients": 2, "size": 64}`, testToken)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("POST: status %d %s", rec.Code, rec.Body)
+	}
+	var last BenchmarkEvent
+	for scanner := bufio.NewScanner(rec.Body); scanner.Scan(); {
+		if err := json.Unmarshal(scanner.Bytes(), &last); err != nil {
+			t.Fatalf("event %q: %v", scanner.Text(), err)
+		}
+	}
+	if last.Error != "" || last.Result == nil || last.Result.SuccessRequests == 0 || last.Requests != last.Result.TotalRequests {
+		t.Fatalf("last event %+v, want a result with successful requests", last)
+	}
+	if r := last.Result; r.Protocol != "tcp" || r.TestType != "latency" || r.BytesSent != 64*r.TotalRequests {
+		t.Errorf("result %+v, want a latency test of 64 byte requests", r)
+	}
+
+	var status struct {
+		Running bool                  `json:"running"`
+		Last    *benchmark.TestResult `json:"last"`
+	}
+	rec = call(s, http.MethodGet, "/api/benchmark", "", testToken)
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil || status.Running || status.Last == nil || status.Last.TotalRequests != last.Result.TotalRequests {
+		t.Errorf("GET: %+v (%v), want the last result", status, err)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableBenchmark("", "tcp", listener.Listener.Addr().String())
+	if rec := call(open, http.MethodPost, "/api/benchmark", `{}`, "anything"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "admin.token") {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// StreamChecksums is the response of GET /api/streams/{id}/checksums
+type StreamChecksums struct {
+	StreamID   string                         `json:"stream_id"`
+	Renditions []streaming.RenditionChecksums `json:"renditions"`
+}
+
+// StreamChecksumsHandler serves the SHA-256 of every segment of the streams
+// on disk, computed when the content was loaded:
+//
+//	GET /api/streams/{id}/checksums
+func StreamChecksumsHandler(content *streaming.Content) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/streams"), "/")
+		i := strings.LastIndex(path, "/")
+		if i <= 0 || path[i+1:] != "checksums" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		streamID, err := url.PathUnescape(path[:i])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid stream")
+			return
+		}
+		renditions, ok := content.Checksums(streamID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown stream")
+			return
+		}
+		writeJSON(w, http.StatusOK, StreamChecksums{StreamID: streamID, Renditions: renditions})
+	}
+}
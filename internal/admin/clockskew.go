@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// ClockSkewHandler reports the estimated clock offset of devices:
+//
+//	GET /api/clock-skew               every device that sent a reading
+//	GET /api/clock-skew/{device_id}   one device
+//
+// ?skewed=true keeps the devices whose clock is flagged.
+func ClockSkewHandler(skew *iot.ClockSkew) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if skew == nil {
+			writeError(w, http.StatusNotFound, "clock skew correction is disabled")
+			return
+		}
+		deviceID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/clock-skew"), "/")
+		if deviceID != "" {
+			clock, ok := skew.Device(deviceID)
+			if !ok {
+				writeError(w, http.StatusNotFound, "no reading from device "+deviceID)
+				return
+			}
+			writeJSON(w, http.StatusOK, clock)
+			return
+		}
+		clocks := skew.List()
+		if r.URL.Query().Get("skewed") == "true" {
+			kept := clocks[:0]
+			for _, c := range clocks {
+				if c.Skewed {
+					kept = append(kept, c)
+				}
+			}
+			clocks = kept
+		}
+		writeJSON(w, http.StatusOK, clocks)
+	}
+}
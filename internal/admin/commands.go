@@ -0,0 +1,85 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// EnableCommands mounts CommandsHandler. Queuing and cancelling commands
+// requires the admin token, and is refused without one.
+func (s *Server) EnableCommands(token string, outbox *iot.Outbox) {
+	gated := requireTokenToChange(token, "commands", CommandsHandler(outbox))
+	s.Handle("/api/commands", gated)
+	s.Handle("/api/commands/", gated)
+}
+
+// CommandsHandler queues commands for devices and follows them:
+//
+//	GET    /api/commands        every command remembered, oldest first
+//	POST   /api/commands        queue an iot.Command for its device
+//	GET    /api/commands/{id}   one command
+//	DELETE /api/commands/{id}   cancel a command
+//
+// The list can be narrowed with ?device_id= and ?status=. A command that
+// already finished can't be cancelled, and gets 409.
+func CommandsHandler(outbox *iot.Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/commands"), "/")
+		if id == "" {
+			switch r.Method {
+			case http.MethodGet:
+				q := r.URL.Query()
+				writeJSON(w, http.StatusOK, outbox.List(q.Get("device_id"), q.Get("status")))
+			case http.MethodPost:
+				var cmd iot.Command
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&cmd); err != nil {
+					writeError(w, http.StatusBadRequest, "invalid command body")
+					return
+				}
+				cmd, err := outbox.Enqueue(cmd)
+				switch {
+				case errors.Is(err, iot.ErrUnsupportedCommand):
+					writeError(w, http.StatusUnprocessableEntity, err.Error())
+					return
+				case errors.Is(err, iot.ErrOutboxFull):
+					writeError(w, http.StatusTooManyRequests, err.Error())
+					return
+				case err != nil:
+					writeError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				status, _ := outbox.Status(cmd.ID)
+				writeJSON(w, http.StatusCreated, status)
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			}
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			status, ok := outbox.Status(id)
+			if !ok {
+				writeError(w, http.StatusNotFound, iot.ErrUnknownCommand.Error()+" "+id)
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+		case http.MethodDelete:
+			status, err := outbox.Cancel(id)
+			switch {
+			case errors.Is(err, iot.ErrUnknownCommand):
+				writeError(w, http.StatusNotFound, err.Error()+" "+id)
+			case errors.Is(err, iot.ErrCommandFinished):
+				writeError(w, http.StatusConflict, "command "+id+" is already "+status.Status)
+			default:
+				writeJSON(w, http.StatusOK, status)
+			}
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func newOutbox() *iot.Outbox {
+	reg := metrics.NewRegistry()
+	devices := iot.NewDeviceRegistry(logging.Nop(), clock.Real(), reg)
+	return iot.NewOutbox(config.Default().IoT.Commands, logging.Nop(), devices, clock.Real(), reg)
+}
+
+func TestCommandsRequireToken(t *testing.T) {
+	outbox := newOutbox()
+	s := NewServer("", logging.Nop())
+	s.EnableCommands(testToken, outbox)
+
+	const reboot = `{"device_id": "dev1", "action": "reboot"}`
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPost, "/api/commands", reboot, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if got := outbox.List("", ""); len(got) != 0 {
+		t.Fatalf("commands queued without the token: %+v", got)
+	}
+
+	rec := call(s, http.MethodPost, "/api/commands", reboot, testToken)
+	var queued iot.CommandStatus
+	if err := json.NewDecoder(rec.Body).Decode(&queued); err != nil || rec.Code != http.StatusCreated {
+		t.Fatalf("POST with the token: status %d, %v", rec.Code, err)
+	}
+	target := "/api/commands/" + queued.ID
+	if rec := call(s, http.MethodDelete, target, "", "guess"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE with a wrong token: status %d, want 401", rec.Code)
+	}
+	if rec := call(s, http.MethodGet, target, "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without a token: status %d, want 200", rec.Code)
+	}
+	if rec := call(s, http.MethodDelete, target, "", testToken); rec.Code != http.StatusOK {
+		t.Errorf("DELETE with the token: status %d: %s", rec.Code, rec.Body)
+	}
+	if status, _ := outbox.Status(queued.ID); status.Status != iot.CommandCancelled {
+		t.Errorf("command %s, want it cancelled", status.Status)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableCommands("", outbox)
+	if rec := call(open, http.MethodPost, "/api/commands", reboot, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(open, http.MethodDelete, target, "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("DELETE without admin.token: status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,211 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// ConnTracker counts open connections per transport
+type ConnTracker struct {
+	mu         sync.Mutex
+	counts     map[string]int64
+	congestion map[string]string
+	blocked    map[string]time.Duration
+	gauge      *metrics.GaugeVec
+	total      *metrics.CounterVec
+	blockedSec *metrics.CounterVec
+}
+
+// NewConnTracker creates a new connection tracker reporting to reg
+func NewConnTracker(reg *metrics.Registry) *ConnTracker {
+	return &ConnTracker{
+		counts:     make(map[string]int64),
+		congestion: make(map[string]string),
+		blocked:    make(map[string]time.Duration),
+		gauge:      reg.GaugeVec("server", "open_connections", "Currently open connections by transport", "transport"),
+		total:      reg.CounterVec("server", "connections_total", "Accepted connections by transport", "transport"),
+		blockedSec: reg.CounterVec("server", "flow_control_blocked_seconds_total", "Time sends were blocked on the peer's flow control by transport", "transport"),
+	}
+}
+
+// Open records a new connection on transport
+func (t *ConnTracker) Open(transport string) {
+	t.mu.Lock()
+	t.counts[transport]++
+	t.mu.Unlock()
+	t.gauge.WithLabelValues(transport).Inc()
+	t.total.WithLabelValues(transport).Inc()
+}
+
+// Close records a closed connection on transport
+func (t *ConnTracker) Close(transport string) {
+	t.mu.Lock()
+	t.counts[transport]--
+	t.mu.Unlock()
+	t.gauge.WithLabelValues(transport).Dec()
+}
+
+// Snapshot returns the open connection count per transport
+func (t *ConnTracker) Snapshot() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	counts := make(map[string]int64, len(t.counts))
+	for transport, n := range t.counts {
+		counts[transport] = n
+	}
+	return counts
+}
+
+// SetCongestion records the congestion controller connections on transport
+// run
+func (t *ConnTracker) SetCongestion(transport, name string) {
+	t.mu.Lock()
+	t.congestion[transport] = name
+	t.mu.Unlock()
+}
+
+// Congestion returns the congestion controller per transport
+func (t *ConnTracker) Congestion() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]string, len(t.congestion))
+	for transport, name := range t.congestion {
+		out[transport] = name
+	}
+	return out
+}
+
+// AddFlowBlocked records time a connection on transport was blocked on the
+// peer's flow control
+func (t *ConnTracker) AddFlowBlocked(transport string, d time.Duration) {
+	t.mu.Lock()
+	t.blocked[transport] += d
+	t.mu.Unlock()
+	t.blockedSec.WithLabelValues(transport).Add(d.Seconds())
+}
+
+// FlowBlocked returns the time connections were blocked on flow control per
+// transport
+func (t *ConnTracker) FlowBlocked() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make(map[string]time.Duration, len(t.blocked))
+	for transport, d := range t.blocked {
+		out[transport] = d
+	}
+	return out
+}
+
+// RuntimeStats is the body of GET /debug/runtime
+type RuntimeStats struct {
+	Goroutines      int                `json:"goroutines"`
+	HeapAllocBytes  uint64             `json:"heap_alloc_bytes"`
+	HeapInuseBytes  uint64             `json:"heap_inuse_bytes"`
+	HeapObjects     uint64             `json:"heap_objects"`
+	NumGC           uint32             `json:"num_gc"`
+	GCPauseTotalMs  float64            `json:"gc_pause_total_ms"`
+	RecentGCPauses  []float64          `json:"recent_gc_pauses_ms"` // most recent first
+	OpenConnections map[string]int64   `json:"open_connections"`
+	Congestion      map[string]string  `json:"congestion,omitempty"`              // congestion controller by transport
+	FlowBlockedMs   map[string]float64 `json:"flow_control_blocked_ms,omitempty"` // total time sends were window-limited, by transport
+	Timestamp       time.Time          `json:"timestamp"`
+}
+
+// EnableDebug mounts net/http/pprof under /debug/pprof/ and runtime stats
+// under /debug/runtime. When token is set, requests must carry it as a
+// bearer token. Without this call the endpoints do not exist.
+func (s *Server) EnableDebug(token string, conns *ConnTracker) {
+	s.Handle("/debug/pprof/", requireToken(token, http.HandlerFunc(pprof.Index)))
+	s.Handle("/debug/pprof/cmdline", requireToken(token, http.HandlerFunc(pprof.Cmdline)))
+	s.Handle("/debug/pprof/profile", requireToken(token, http.HandlerFunc(pprof.Profile)))
+	s.Handle("/debug/pprof/symbol", requireToken(token, http.HandlerFunc(pprof.Symbol)))
+	s.Handle("/debug/pprof/trace", requireToken(token, http.HandlerFunc(pprof.Trace)))
+	s.Handle("/debug/runtime", requireToken(token, runtimeStatsHandler(conns)))
+
+	// CPU profiles run for the requested duration, longer than the default write timeout
+	s.server.WriteTimeout = 0
+	s.logger.Info("Debug endpoints enabled")
+}
+
+func runtimeStatsHandler(conns *ConnTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+
+		// PauseNs is a circular buffer indexed by NumGC
+		recent := make([]float64, 0, 10)
+		for i := uint32(0); i < 10 && i < mem.NumGC; i++ {
+			idx := (mem.NumGC - 1 - i) % uint32(len(mem.PauseNs))
+			recent = append(recent, float64(mem.PauseNs[idx])/1e6)
+		}
+
+		stats := RuntimeStats{
+			Goroutines:     runtime.NumGoroutine(),
+			HeapAllocBytes: mem.HeapAlloc,
+			HeapInuseBytes: mem.HeapInuse,
+			HeapObjects:    mem.HeapObjects,
+			NumGC:          mem.NumGC,
+			GCPauseTotalMs: float64(mem.PauseTotalNs) / 1e6,
+			RecentGCPauses: recent,
+			Timestamp:      time.Now(),
+		}
+		if conns != nil {
+			stats.OpenConnections = conns.Snapshot()
+			stats.Congestion = conns.Congestion()
+			if blocked := conns.FlowBlocked(); len(blocked) > 0 {
+				stats.FlowBlockedMs = make(map[string]float64, len(blocked))
+				for transport, d := range blocked {
+					stats.FlowBlockedMs[transport] = float64(d.Nanoseconds()) / 1e6
+				}
+			}
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
+
+// requireToken rejects requests without the bearer token. An empty token
+// disables the check.
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireTokenToChange serves GET and HEAD requests of next as they are, and
+// requests with any other method only with the bearer token. Without a
+// token those are refused with 403, naming what they change.
+func requireTokenToChange(token, what string, next http.Handler) http.Handler {
+	change := requireToken(token, next)
+	if token == "" {
+		change = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "changing "+what+" requires admin.token")
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		change.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// DeviceHealthHandler reports the last health report of devices:
+//
+//	GET /api/device-health               every device that reported
+//	GET /api/device-health/{device_id}   one device
+func DeviceHealthHandler(tracker *iot.DeviceHealthTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		deviceID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/device-health"), "/")
+		if deviceID == "" {
+			writeJSON(w, http.StatusOK, tracker.List())
+			return
+		}
+		health, ok := tracker.Device(deviceID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no health report from device "+deviceID)
+			return
+		}
+		writeJSON(w, http.StatusOK, health)
+	}
+}
+
+// AlertsHandler lists the recent device alerts, newest first, at
+// GET /api/alerts. ?kind= keeps the alerts of one kind.
+func AlertsHandler(alerts *iot.Alerts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		recent := alerts.Recent()
+		if kind := r.URL.Query().Get("kind"); kind != "" {
+			kept := recent[:0]
+			for _, a := range recent {
+				if a.Kind == kind {
+					kept = append(kept, a)
+				}
+			}
+			recent = kept
+		}
+		writeJSON(w, http.StatusOK, recent)
+	}
+}
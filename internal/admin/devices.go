@@ -0,0 +1,27 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// DevicesHandler lists registered devices with their metadata and
+// capabilities. Each label=key=value query parameter keeps only the devices
+// with that label:
+//
+//	GET /api/devices?label=site=berlin&label=tier=edge
+func DevicesHandler(devices *iot.DeviceRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		selector, err := iot.ParseLabelSelector(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, devices.List(selector))
+	}
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// DeviceSessionsHandler lists the devices in session on an open HTTP/3
+// connection, with their remote address and since when
+func DeviceSessionsHandler(sessions *iot.Sessions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, sessions.List())
+	}
+}
@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// EnableFirmware mounts FirmwareHandler. Offering an image requires the
+// admin token, since every device installs what it is offered, and is
+// refused without one.
+func (s *Server) EnableFirmware(token string, firmware *iot.FirmwareUpdates) {
+	gated := requireTokenToChange(token, "firmware", FirmwareHandler(firmware))
+	s.Handle("/api/firmware", gated)
+	s.Handle("/api/firmware/", gated)
+}
+
+// FirmwareHandler offers firmware images to devices and reports the progress
+// of their updates:
+//
+//	GET  /api/firmware                            every device's update
+//	POST /api/firmware/{device_id}?version=1.2.0  offer the image in the body
+func FirmwareHandler(firmware *iot.FirmwareUpdates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		deviceID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/firmware"), "/")
+		if deviceID == "" {
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			writeJSON(w, http.StatusOK, firmware.List())
+			return
+		}
+
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		version := r.URL.Query().Get("version")
+		if version == "" {
+			writeError(w, http.StatusBadRequest, "version is required")
+			return
+		}
+		status, err := firmware.StartFirmwareUpdate(deviceID, r.Body, version)
+		if errors.Is(err, iot.ErrFirmwareTooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		} else if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, status)
+	}
+}
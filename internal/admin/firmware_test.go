@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestFirmwareOfferRequiresToken(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	firmware := iot.NewFirmwareUpdates(config.Default().IoT.Firmware, logging.Nop(), fake, metrics.NewRegistry())
+	s := NewServer("", logging.Nop())
+	s.EnableFirmware(testToken, firmware)
+
+	const offer = "/api/firmware/dev1?version=1.2.0"
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPost, offer, "image", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if got := firmware.List(); len(got) != 0 {
+		t.Fatalf("firmware offered without the token: %+v", got)
+	}
+
+	if rec := call(s, http.MethodPost, offer, "image", testToken); rec.Code != http.StatusAccepted {
+		t.Fatalf("POST with the token: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := call(s, http.MethodGet, "/api/firmware", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without a token: status %d", rec.Code)
+	}
+	if got := firmware.List(); len(got) != 1 || got[0].Version != "1.2.0" {
+		t.Errorf("updates %+v, want 1.2.0 offered to dev1", got)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableFirmware("", firmware)
+	if rec := call(open, http.MethodPost, offer, "image", "guess"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http/httptest"
+	"strings"
+)
+
+const testToken = "s3cret"
+
+// call serves a request with body and, unless empty, the bearer token
+func call(s *Server, method, target, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	return rec
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/impair"
+)
+
+// Impairment is the admin API view of an impairment profile. Durations use
+// Go syntax, e.g. "250ms" or "10m".
+type Impairment struct {
+	Session        string    `json:"session"`
+	Latency        string    `json:"latency,omitempty"`
+	ThrottleBps    int64     `json:"throttle_bps,omitempty"`
+	DropNext       int       `json:"drop_next,omitempty"`
+	TTL            string    `json:"ttl"`
+	ExpiresAt      time.Time `json:"expires_at,omitempty"`
+	ChunksImpaired int64     `json:"chunks_impaired"`
+	ChunksDropped  int64     `json:"chunks_dropped"`
+}
+
+// EnableImpairments mounts ImpairmentsHandler. Attaching and removing
+// profiles degrades live traffic, so it requires the admin token and is
+// refused without one.
+func (s *Server) EnableImpairments(token string, reg *impair.Registry) {
+	gated := requireTokenToChange(token, "impairments", ImpairmentsHandler(reg))
+	s.Handle("/api/impairments", gated)
+	s.Handle("/api/impairments/", gated)
+}
+
+// ImpairmentsHandler lists (GET), attaches (POST) and removes
+// (DELETE /api/impairments/{session}) per-session impairment profiles
+func ImpairmentsHandler(reg *impair.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor := r.RemoteAddr
+
+		switch r.Method {
+		case http.MethodGet:
+			profiles := reg.List()
+			views := make([]Impairment, 0, len(profiles))
+			for _, p := range profiles {
+				views = append(views, impairmentView(p))
+			}
+			writeJSON(w, http.StatusOK, views)
+		case http.MethodPost:
+			var req Impairment
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid impairment body")
+				return
+			}
+			p := impair.Profile{
+				Session:     req.Session,
+				ThrottleBps: req.ThrottleBps,
+				DropNext:    req.DropNext,
+			}
+			var err error
+			if req.Latency != "" {
+				if p.Latency, err = time.ParseDuration(req.Latency); err != nil {
+					writeError(w, http.StatusBadRequest, "invalid latency: "+err.Error())
+					return
+				}
+			}
+			if p.TTL, err = time.ParseDuration(req.TTL); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid ttl: "+err.Error())
+				return
+			}
+
+			attached, err := reg.Attach(p, actor)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusCreated, impairmentView(attached))
+		case http.MethodDelete:
+			session := strings.TrimPrefix(r.URL.Path, "/api/impairments/")
+			if session == "" || session == r.URL.Path {
+				writeError(w, http.StatusBadRequest, "session required")
+				return
+			}
+			if !reg.Remove(session, actor) {
+				writeError(w, http.StatusNotFound, "no impairment for session")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+func impairmentView(p impair.Profile) Impairment {
+	view := Impairment{
+		Session:        p.Session,
+		ThrottleBps:    p.ThrottleBps,
+		DropNext:       p.DropNext,
+		TTL:            p.TTL.String(),
+		ExpiresAt:      p.ExpiresAt,
+		ChunksImpaired: p.ChunksImpaired,
+		ChunksDropped:  p.ChunksDropped,
+	}
+	if p.Latency > 0 {
+		view.Latency = p.Latency.String()
+	}
+	return view
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/impair"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestImpairmentsRequireToken(t *testing.T) {
+	reg := impair.NewRegistry(logging.Nop())
+	s := NewServer("", logging.Nop())
+	s.EnableImpairments(testToken, reg)
+
+	const profile = `{"session": "viewer-1", "latency": "200ms", "drop_next": 3, "ttl": "10m"}`
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPost, "/api/impairments", profile, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if got := reg.List(); len(got) != 0 {
+		t.Fatalf("profiles attached without the token: %+v", got)
+	}
+	if rec := call(s, http.MethodPost, "/api/impairments", profile, testToken); rec.Code != http.StatusCreated {
+		t.Fatalf("POST with the token: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := call(s, http.MethodDelete, "/api/impairments/viewer-1", "", "guess"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE with a wrong token: status %d, want 401", rec.Code)
+	}
+	if rec := call(s, http.MethodGet, "/api/impairments", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without a token: status %d, want 200", rec.Code)
+	}
+	if rec := call(s, http.MethodDelete, "/api/impairments/viewer-1", "", testToken); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE with the token: status %d: %s", rec.Code, rec.Body)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableImpairments("", reg)
+	if rec := call(open, http.MethodPost, "/api/impairments", profile, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+	if got := reg.List(); len(got) != 0 {
+		t.Errorf("profiles attached without admin.token: %+v", got)
+	}
+}
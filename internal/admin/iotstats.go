@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// IoTStatsHandler reports the IoT handler's message, reading and command
+// counts, the depth of its queues and when each device was last seen
+func IoTStatsHandler(stats func() iot.Stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, stats())
+	}
+}
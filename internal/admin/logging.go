@@ -0,0 +1,56 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// LogLevels is the body of GET and PUT /api/logging/levels
+type LogLevels struct {
+	Level   string            `json:"level"`
+	Levels  map[string]string `json:"levels"`
+	Unknown []string          `json:"unknown,omitempty"`
+}
+
+// EnableLogging mounts LoggingLevelsHandler of root at /api/logging/levels.
+// Changing levels can flood the disk or silence errors, so it requires the
+// admin token and is refused without one.
+func (s *Server) EnableLogging(token string, root logging.Logger) {
+	s.Handle("/api/logging/levels", requireTokenToChange(token, "log levels", LoggingLevelsHandler(root)))
+}
+
+// LoggingLevelsHandler serves and updates the runtime log levels of root
+func LoggingLevelsHandler(root logging.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			level, levels := logging.Levels(root)
+			writeJSON(w, http.StatusOK, LogLevels{Level: level, Levels: levels})
+		case http.MethodPut:
+			var req LogLevels
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid levels body")
+				return
+			}
+			if req.Level == "" {
+				req.Level, _ = logging.Levels(root)
+			}
+
+			unknown, err := logging.SetLevels(root, req.Level, req.Levels)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			for _, name := range unknown {
+				root.Warn("Log level set for unknown component", logging.F("component", name))
+			}
+
+			level, levels := logging.Levels(root)
+			writeJSON(w, http.StatusOK, LogLevels{Level: level, Levels: levels, Unknown: unknown})
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestLogLevelsRequireToken(t *testing.T) {
+	root, err := logging.New(config.LoggingConfig{Level: "info", Format: "json", File: filepath.Join(t.TempDir(), "log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", logging.Nop())
+	s.EnableLogging(testToken, root)
+
+	const debug = `{"level": "debug"}`
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPut, "/api/logging/levels", debug, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("PUT with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if level, _ := logging.Levels(root); level != "info" {
+		t.Fatalf("level %s after unauthorized changes, want info", level)
+	}
+	if rec := call(s, http.MethodGet, "/api/logging/levels", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without a token: status %d, want 200", rec.Code)
+	}
+	if rec := call(s, http.MethodPut, "/api/logging/levels", debug, testToken); rec.Code != http.StatusOK {
+		t.Fatalf("PUT with the token: status %d: %s", rec.Code, rec.Body)
+	}
+	if level, _ := logging.Levels(root); level != "debug" {
+		t.Errorf("level %s, want debug", level)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableLogging("", root)
+	if rec := call(open, http.MethodPut, "/api/logging/levels", `{"level": "error"}`, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("PUT without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(open, http.MethodGet, "/api/logging/levels", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without admin.token: status %d, want 200", rec.Code)
+	}
+}
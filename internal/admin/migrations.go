@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// MigrationRequest is the body of POST /api/migrations
+type MigrationRequest struct {
+	Target  string   `json:"target"`            // server address devices reconnect to, e.g. https://other:8443
+	After   string   `json:"after"`             // delay before reconnecting, e.g. "10s"
+	Devices []string `json:"devices,omitempty"` // empty drains every device
+}
+
+// EnableMigrations mounts MigrationsHandler at /api/migrations. Requesting
+// and cancelling migrations requires the admin token, since it points
+// devices at any server, and is refused without one.
+func (s *Server) EnableMigrations(token string, migrations *iot.Migrations) {
+	s.Handle("/api/migrations", requireTokenToChange(token, "migrations", MigrationsHandler(migrations)))
+}
+
+// MigrationsHandler asks devices to reconnect to another server (POST),
+// reports per-device migration status (GET) and cancels pending migrations
+// (DELETE)
+func MigrationsHandler(migrations *iot.Migrations) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, migrations.List())
+		case http.MethodPost:
+			var req MigrationRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid migration body")
+				return
+			}
+			if u, err := url.Parse(req.Target); err != nil || u.Scheme == "" || u.Host == "" {
+				writeError(w, http.StatusBadRequest, "target must be an absolute URL")
+				return
+			}
+			var after time.Duration
+			if req.After != "" {
+				d, err := time.ParseDuration(req.After)
+				if err != nil || d < 0 {
+					writeError(w, http.StatusBadRequest, "invalid after")
+					return
+				}
+				after = d
+			}
+
+			migrations.Request(iot.Reconnect{Addr: req.Target, After: after}, req.Devices)
+			writeJSON(w, http.StatusAccepted, migrations.List())
+		case http.MethodDelete:
+			migrations.Cancel()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// iotServer serves an IoT handler with opts over HTTP
+func iotServer(t *testing.T, opts ...iot.Option) (*iot.Handler, *httptest.Server) {
+	t.Helper()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := iot.NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), opts...)
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return h, srv
+}
+
+// postAsDevice sends body to the IoT server at addr as device dev1
+func postAsDevice(t *testing.T, addr, path, body string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, addr+path, strings.NewReader(body))
+	req.Header.Set("X-Device-ID", "dev1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST %s%s: status %d", addr, path, resp.StatusCode)
+	}
+	return resp
+}
+
+const (
+	registerDev1 = `{"device_id": "dev1", "min_version": 1, "max_version": 1}`
+	readingDev1  = `{"device_id": "dev1", "sensor_type": "temperature", "value": 21, "unit": "celsius"}`
+)
+
+func TestMigrationMovesDeviceToSecondServer(t *testing.T) {
+	migrations := iot.NewMigrations(logging.Nop(), clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	_, first := iotServer(t, iot.WithMigrations(migrations))
+	second, secondSrv := iotServer(t)
+	s := NewServer("", logging.Nop())
+	s.EnableMigrations(testToken, migrations)
+
+	postAsDevice(t, first.URL, "/iot/register", registerDev1)
+	body := `{"target": "` + secondSrv.URL + `", "after": "0s", "devices": ["dev1"]}`
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPost, "/api/migrations", body, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if resp := postAsDevice(t, first.URL, "/iot/sensor", readingDev1); resp.Header.Get(iot.ReconnectHeader) != "" {
+		t.Fatal("device told to reconnect without an authorized migration")
+	}
+	if rec := call(s, http.MethodPost, "/api/migrations", body, testToken); rec.Code != http.StatusAccepted {
+		t.Fatalf("POST with the token: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// The device learns where to go on its next response, and leaves
+	resp := postAsDevice(t, first.URL, "/iot/sensor", readingDev1)
+	target, err := iot.ParseReconnect(resp.Header.Get(iot.ReconnectHeader))
+	if err != nil {
+		t.Fatalf("reconnect header %q: %v", resp.Header.Get(iot.ReconnectHeader), err)
+	}
+	if target.Addr != secondSrv.URL {
+		t.Fatalf("told to reconnect to %s, want %s", target.Addr, secondSrv.URL)
+	}
+	postAsDevice(t, first.URL, "/iot/migrate", "")
+	if list := migrations.List(); len(list) != 1 || list[0].Status != iot.MigrationDone {
+		t.Errorf("migrations %+v, want dev1 migrated", list)
+	}
+
+	postAsDevice(t, target.Addr, "/iot/register", registerDev1)
+	if resp := postAsDevice(t, target.Addr, "/iot/sensor", readingDev1); resp.Header.Get(iot.ReconnectHeader) != "" {
+		t.Error("second server told the device to move on")
+	}
+	if got := second.Stats().Readings.Accepted; got != 1 {
+		t.Errorf("second server accepted %d readings, want 1", got)
+	}
+}
+
+func TestMigrationsDisabledWithoutToken(t *testing.T) {
+	migrations := iot.NewMigrations(logging.Nop(), clock.Real())
+	s := NewServer("", logging.Nop())
+	s.EnableMigrations("", migrations)
+
+	body := `{"target": "https://other:8443", "after": "10s"}`
+	if rec := call(s, http.MethodPost, "/api/migrations", body, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(s, http.MethodDelete, "/api/migrations", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("DELETE without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(s, http.MethodGet, "/api/migrations", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without admin.token: status %d, want 200", rec.Code)
+	}
+}
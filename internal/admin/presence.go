@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// PresenceHandler reports which devices are online, judged by their
+// heartbeats and sensor readings
+func PresenceHandler(presence *iot.Presence) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, presence.List())
+	}
+}
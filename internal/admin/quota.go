@@ -0,0 +1,47 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+)
+
+// QuotaUsage is the body of GET /api/quotas
+type QuotaUsage struct {
+	Devices  []quota.Usage `json:"devices"`
+	Sessions []quota.Usage `json:"sessions"`
+}
+
+// QuotaUsageHandler reports bytes used per device and per streaming session
+// in the current windows, largest first. ?limit=N returns the top N of each.
+func QuotaUsageHandler(quotas *quota.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		writeJSON(w, http.StatusOK, QuotaUsage{
+			Devices:  top(quotas.Devices.Snapshot(), limit),
+			Sessions: top(quotas.Sessions.Snapshot(), limit),
+		})
+	}
+}
+
+func top(usage []quota.Usage, limit int) []quota.Usage {
+	if limit > 0 && len(usage) > limit {
+		return usage[:limit]
+	}
+	return usage
+}
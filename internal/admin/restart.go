@@ -0,0 +1,180 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// maxRestartDown bounds how long a listener may be kept down
+const maxRestartDown = 5 * time.Minute
+
+// RestartFunc closes the server's listener and all of its connections, and
+// reopens it once down has elapsed. It returns once the listener is closed.
+type RestartFunc func(down time.Duration) error
+
+// EnableRestart mounts POST /debug/restart?down=3s, which takes the server's
+// listener down for the given duration to inject faults during benchmarks.
+// It only exists when debug endpoints are enabled, requires the admin token
+// and refuses to run without one.
+func (s *Server) EnableRestart(token string, restart RestartFunc) {
+	if token == "" {
+		s.HandleFunc("/debug/restart", func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "restarting the listener requires admin.token")
+		})
+		return
+	}
+	s.Handle("/debug/restart", requireToken(token, restartHandler(restart)))
+}
+
+func restartHandler(restart RestartFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		down, err := time.ParseDuration(r.URL.Query().Get("down"))
+		if err != nil || down <= 0 || down > maxRestartDown {
+			writeError(w, http.StatusBadRequest, "down must be a duration between 0 and "+maxRestartDown.String())
+			return
+		}
+
+		if err := restart(down); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]interface{}{
+			"down_ms":   down.Milliseconds(),
+			"timestamp": time.Now(),
+		})
+	}
+}
+
+// Listener keeps the state of a server's listener, which Restart and the
+// admin API take down and bring up again. The server supplies the actual
+// work: down closes the listener and every open connection, keeping a fresh
+// server to listen with later, and up listens again and serves in the
+// background. They are called with the Listener locked, so they never
+// overlap.
+type Listener struct {
+	name   string
+	logger logging.Logger
+	addr   func() string
+	down   func() error
+	up     func() error
+
+	mu      sync.Mutex
+	stopped bool
+	isDown  bool      // taken down by Restart or the admin API
+	downs   int       // times the listener was taken down
+	since   time.Time // when the listener last went up or down
+}
+
+// NewListener creates the controls of the listener name, e.g. "tcp", which
+// is up and listening on addr()
+func NewListener(name string, logger logging.Logger, addr func() string, down, up func() error) *Listener {
+	return &Listener{name: name, logger: logger, addr: addr, down: down, up: up, since: time.Now()}
+}
+
+// Restart closes the listener and every open connection, and listens again
+// once down has elapsed. It is the RestartFunc of the server.
+func (l *Listener) Restart(down time.Duration) error {
+	downs, err := l.takeDown()
+	if err != nil {
+		return err
+	}
+	l.logger.Warn("Restarting listener", logging.F("listener", l.name), logging.F("down", down))
+
+	go func() {
+		time.Sleep(down)
+		l.mu.Lock()
+		// Brought up or taken down again meanwhile over the admin API
+		stale := l.downs != downs || !l.isDown
+		l.mu.Unlock()
+		if stale {
+			return
+		}
+		if err := l.bringUp(); err != nil {
+			l.logger.Error("Server failed after restart", logging.F("listener", l.name), logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+// takeDown takes the listener down and returns the count of takedowns so far
+func (l *Listener) takeDown() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return 0, errors.New("server is stopped")
+	}
+	if l.isDown {
+		return 0, errors.New("listener is already down")
+	}
+	l.isDown, l.since = true, time.Now()
+	l.downs++
+	return l.downs, l.down()
+}
+
+// bringUp listens again after takeDown
+func (l *Listener) bringUp() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return errors.New("server is stopped")
+	}
+	if !l.isDown {
+		return errors.New("listener is already up")
+	}
+	if err := l.up(); err != nil {
+		return err
+	}
+	l.isDown, l.since = false, time.Now()
+	l.logger.Info("Listener back up", logging.F("listener", l.name), logging.F("addr", l.addr()))
+	return nil
+}
+
+// Close marks the server stopped for good, after which the listener can't
+// be brought up or taken down. The server closes its listener itself.
+func (l *Listener) Close() {
+	l.mu.Lock()
+	l.stopped = true
+	l.mu.Unlock()
+}
+
+// Subsystem controls the listener from the admin API
+func (l *Listener) Subsystem() Subsystem {
+	return listenerSubsystem{l}
+}
+
+type listenerSubsystem struct {
+	l *Listener
+}
+
+func (s listenerSubsystem) Start(ctx context.Context) error {
+	return s.l.bringUp()
+}
+
+func (s listenerSubsystem) Stop(ctx context.Context) error {
+	_, err := s.l.takeDown()
+	if err == nil {
+		s.l.logger.Warn("Listener stopped from the admin API", logging.F("listener", s.l.name))
+	}
+	return err
+}
+
+func (s listenerSubsystem) Status() SubsystemStatus {
+	s.l.mu.Lock()
+	defer s.l.mu.Unlock()
+	return SubsystemStatus{
+		Name:    s.l.name,
+		Running: !s.l.isDown && !s.l.stopped,
+		Addr:    s.l.addr(),
+		Since:   s.l.since,
+	}
+}
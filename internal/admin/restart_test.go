@@ -0,0 +1,42 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestRestartRequiresToken(t *testing.T) {
+	var downs []time.Duration
+	restart := func(down time.Duration) error {
+		downs = append(downs, down)
+		return nil
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableRestart("", restart)
+	if rec := call(open, http.MethodPost, "/debug/restart?down=1s", "", ""); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "admin.token") {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+
+	s := NewServer("", logging.Nop())
+	s.EnableRestart(testToken, restart)
+	if rec := call(s, http.MethodPost, "/debug/restart?down=1s", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("POST without a token: status %d, want 401", rec.Code)
+	}
+	if rec := call(s, http.MethodPost, "/debug/restart?down=1h", "", testToken); rec.Code != http.StatusBadRequest {
+		t.Errorf("POST beyond the longest down time: status %d, want 400", rec.Code)
+	}
+	if len(downs) != 0 {
+		t.Fatalf("listener restarted %d times by refused requests", len(downs))
+	}
+	if rec := call(s, http.MethodPost, "/debug/restart?down=3s", "", testToken); rec.Code != http.StatusAccepted {
+		t.Errorf("POST: status %d %s, want 202", rec.Code, rec.Body)
+	}
+	if len(downs) != 1 || downs[0] != 3*time.Second {
+		t.Errorf("restarts %v, want one of 3s", downs)
+	}
+}
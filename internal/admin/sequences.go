@@ -0,0 +1,33 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// SequencesHandler reports the readings found missing, duplicated or out of
+// order from their sequence numbers:
+//
+//	GET /api/sequences               every device and the totals
+//	GET /api/sequences/{device_id}   one device
+func SequencesHandler(gaps *iot.GapTracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		deviceID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sequences"), "/")
+		if deviceID == "" {
+			writeJSON(w, http.StatusOK, gaps.Report())
+			return
+		}
+		stats, ok := gaps.Device(deviceID)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no sequenced readings from device "+deviceID)
+			return
+		}
+		writeJSON(w, http.StatusOK, stats)
+	}
+}
@@ -0,0 +1,121 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/limits"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// Server is the HTTP listener serving operational endpoints, over TLS once
+// UseTLS was called
+type Server struct {
+	server *http.Server
+	mux    *http.ServeMux
+	logger logging.Logger
+}
+
+// NewServer creates a new admin server
+func NewServer(addr string, logger logging.Logger) *Server {
+	mux := http.NewServeMux()
+
+	return &Server{
+		server: &http.Server{
+			Addr:         addr,
+			Handler:      mux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		},
+		mux:    mux,
+		logger: logger,
+	}
+}
+
+// UseTLS makes Start and Serve serve the admin API over TLS with c
+func (s *Server) UseTLS(c *tls.Config) {
+	s.server.TLSConfig = c
+}
+
+// Handle registers a handler on the admin mux
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// HandleFunc registers a handler function on the admin mux
+func (s *Server) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// EnableMetrics mounts the Prometheus handler of reg at /metrics
+func (s *Server) EnableMetrics(reg *metrics.Registry) {
+	s.Handle("/metrics", reg.Handler())
+}
+
+// EnableHealth mounts the health report of h at /healthz
+func (s *Server) EnableHealth(h *health.Registry) {
+	s.Handle("/healthz", h.Handler())
+}
+
+// Limit enforces the listener limits of g on the admin API. Call it before
+// Start. The body timeout of g replaces the fixed read timeout, so that
+// uploads such as firmware images get as long as on the other listeners.
+func (s *Server) Limit(g *limits.Guard) {
+	g.ConfigureServer(s.server)
+	s.server.ReadTimeout = 0
+	s.server.Handler = g.Wrap("admin", s.mux)
+}
+
+// Start starts the admin server
+func (s *Server) Start() error {
+	s.logger.Info("Starting admin server", logging.F("addr", s.server.Addr), logging.F("tls", s.server.TLSConfig != nil))
+	var err error
+	if s.server.TLSConfig != nil {
+		err = s.server.ListenAndServeTLS("", "")
+	} else {
+		err = s.server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Serve serves the admin API on l, e.g. a listener on an ephemeral port
+func (s *Server) Serve(l net.Listener) error {
+	s.logger.Info("Starting admin server", logging.F("addr", l.Addr().String()), logging.F("tls", s.server.TLSConfig != nil))
+	var err error
+	if s.server.TLSConfig != nil {
+		err = s.server.ServeTLS(l, "", "")
+	} else {
+		err = s.server.Serve(l)
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops the admin server
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"testing"
+
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestServeOverTLS(t *testing.T) {
+	tlsConfig, err := quiclib.NewTLSConfig(config.TLSConfig{}, "h2", "http/1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", logging.Nop())
+	s.EnableHealth(health.NewRegistry())
+	s.UseTLS(tlsConfig)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Stop() })
+
+	if resp, err := http.Get("http://" + l.Addr().String() + "/healthz"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			t.Error("plain HTTP request served")
+		}
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://" + l.Addr().String() + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("HTTPS request: status %d, TLS %v", resp.StatusCode, resp.TLS != nil)
+	}
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// EnableStreams mounts the management of the stream catalog, and the
+// checksums of the streams on disk:
+//
+//	GET    /api/streams                  every registered stream
+//	POST   /api/streams                  register the stream in the body
+//	GET    /api/streams/{id}             one registered stream
+//	DELETE /api/streams/{id}             remove a registered stream
+//	GET    /api/streams/{id}/checksums   see StreamChecksumsHandler
+//
+// Either catalog or content may be nil, leaving its endpoints out. File
+// streams are read from any path the server can read, so registering and
+// removing streams requires the admin token and is refused without one.
+func (s *Server) EnableStreams(token string, catalog *streaming.Catalog, content *streaming.Content) {
+	gated := requireTokenToChange(token, "streams", streamsHandler(catalog, content))
+	s.Handle("/api/streams", gated)
+	s.Handle("/api/streams/", gated)
+}
+
+func streamsHandler(catalog *streaming.Catalog, content *streaming.Content) http.HandlerFunc {
+	var checksums http.HandlerFunc
+	if content != nil {
+		checksums = StreamChecksumsHandler(content)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/streams"), "/")
+		if strings.HasSuffix(path, "/checksums") {
+			if checksums == nil {
+				writeError(w, http.StatusNotFound, "no stream content")
+				return
+			}
+			checksums(w, r)
+			return
+		}
+		if catalog == nil {
+			writeError(w, http.StatusNotFound, "stream catalog is not enabled")
+			return
+		}
+
+		if path == "" {
+			switch r.Method {
+			case http.MethodGet:
+				writeJSON(w, http.StatusOK, catalog.Streams())
+			case http.MethodPost:
+				var meta streaming.StreamMeta
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&meta); err != nil {
+					writeError(w, http.StatusBadRequest, "invalid stream body")
+					return
+				}
+				registered, err := catalog.RegisterStream(meta)
+				switch {
+				case errors.Is(err, streaming.ErrStreamInvalid):
+					writeError(w, http.StatusBadRequest, err.Error())
+				case errors.Is(err, streaming.ErrStreamExists):
+					writeError(w, http.StatusConflict, err.Error())
+				case err != nil:
+					writeError(w, http.StatusInternalServerError, err.Error())
+				default:
+					writeJSON(w, http.StatusCreated, registered)
+				}
+			default:
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			}
+			return
+		}
+
+		streamID, err := url.PathUnescape(path)
+		if err != nil || strings.Contains(streamID, "/") {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			meta, ok := catalog.Stream(streamID)
+			if !ok {
+				writeError(w, http.StatusNotFound, "unknown stream")
+				return
+			}
+			writeJSON(w, http.StatusOK, meta)
+		case http.MethodDelete:
+			removed, err := catalog.RemoveStream(streamID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			} else if !removed {
+				writeError(w, http.StatusNotFound, "unknown stream")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func newStreamsServer(t *testing.T, token string) (*Server, *streaming.Catalog) {
+	t.Helper()
+	cfg := config.Default().Streaming
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	catalog, err := streaming.NewCatalog(filepath.Join(t.TempDir(), "catalog.json"), cfg.Ladder, cfg.ChunkDuration, logging.Nop(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", logging.Nop())
+	s.EnableStreams(token, catalog, nil)
+	return s, catalog
+}
+
+const lecture = `{"stream_id": "lecture", "title": "Lecture", "source": "synthetic", "duration": 60}`
+
+func TestStreamsManagementRequiresToken(t *testing.T) {
+	s, catalog := newStreamsServer(t, testToken)
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"without a token", "", http.StatusUnauthorized},
+		{"with a wrong token", "guess", http.StatusUnauthorized},
+		{"with the token", testToken, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if rec := call(s, http.MethodPost, "/api/streams", lecture, tt.token); rec.Code != tt.want {
+			t.Errorf("POST %s: status %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if rec := call(s, http.MethodDelete, "/api/streams/lecture", "", "guess"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("DELETE with a wrong token: status %d", rec.Code)
+	}
+	if _, ok := catalog.Stream("lecture"); !ok {
+		t.Fatal("stream removed with a wrong token")
+	}
+
+	// Reading the catalog needs no token
+	rec := call(s, http.MethodGet, "/api/streams", "", "")
+	var streams []streaming.StreamMeta
+	if err := json.NewDecoder(rec.Body).Decode(&streams); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET: status %d, %v", rec.Code, err)
+	}
+	if len(streams) != 1 || streams[0].StreamID != "lecture" {
+		t.Errorf("listed %+v, want lecture", streams)
+	}
+	if rec := call(s, http.MethodGet, "/api/streams/lecture", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET lecture: status %d", rec.Code)
+	}
+}
+
+func TestStreamsManagementDisabledWithoutToken(t *testing.T) {
+	s, catalog := newStreamsServer(t, "")
+	if rec := call(s, http.MethodPost, "/api/streams", lecture, ""); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(s, http.MethodPost, "/api/streams", lecture, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST with a token but without admin.token: status %d, want 403", rec.Code)
+	}
+	if _, err := catalog.RegisterStream(streaming.StreamMeta{StreamID: "camera", Source: streaming.SourceLive}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := call(s, http.MethodDelete, "/api/streams/camera", "", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("DELETE without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(s, http.MethodGet, "/api/streams/camera", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without admin.token: status %d, want 200", rec.Code)
+	}
+}
+
+func TestStreamsCRUD(t *testing.T) {
+	s, _ := newStreamsServer(t, testToken)
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{http.MethodPost, "/api/streams", lecture, http.StatusCreated},
+		{http.MethodPost, "/api/streams", lecture, http.StatusConflict},
+		{http.MethodPost, "/api/streams", `{"stream_id": "bad", "source": "file", "path": "/nonexistent"}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/streams", `{"stream_id":`, http.StatusBadRequest},
+		{http.MethodGet, "/api/streams/bad", "", http.StatusNotFound},
+		{http.MethodPut, "/api/streams", lecture, http.StatusMethodNotAllowed},
+		{http.MethodDelete, "/api/streams/lecture", "", http.StatusNoContent},
+		{http.MethodDelete, "/api/streams/lecture", "", http.StatusNotFound},
+		{http.MethodGet, "/api/streams/lecture", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := call(s, tt.method, tt.target, tt.body, testToken); rec.Code != tt.want {
+			t.Errorf("%s %s %s: status %d, want %d: %s", tt.method, tt.target, tt.body, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
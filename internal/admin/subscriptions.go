@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// SubscriptionsHandler lists the live subscribers to sensor readings with
+// their filters and the readings delivered to and dropped for each
+func SubscriptionsHandler(subs *iot.Subscriptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, subs.List())
+	}
+}
@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// subsystemTimeout bounds a start or stop requested over the admin API
+const subsystemTimeout = 10 * time.Second
+
+// Subsystem is a part of the server, such as a data listener, that can be
+// stopped and started again without restarting the process
+type Subsystem interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Status() SubsystemStatus
+}
+
+// SubsystemStatus reports whether a subsystem is running
+type SubsystemStatus struct {
+	Name    string    `json:"name"`
+	Running bool      `json:"running"`
+	Addr    string    `json:"addr,omitempty"`
+	Since   time.Time `json:"since"` // when it last started or stopped
+}
+
+// EnableSubsystems mounts the controls of subsystems, listed in start order:
+//
+//	GET  /api/subsystems               status of every subsystem
+//	POST /api/subsystems/{name}/stop   stop one, after those started after it
+//	POST /api/subsystems/{name}/start  start one, after those started before it
+//
+// A subsystem is only stopped once every later one is stopped, and only
+// started once every earlier one runs, so dependents never outlive what
+// they depend on. The admin API itself is not a subsystem. Stopping and
+// starting require the admin token, and are refused without one.
+func (s *Server) EnableSubsystems(token string, subsystems ...Subsystem) {
+	gated := requireTokenToChange(token, "subsystems", subsystemsHandler(subsystems))
+	s.Handle("/api/subsystems", gated)
+	s.Handle("/api/subsystems/", gated)
+}
+
+func subsystemsHandler(subsystems []Subsystem) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/subsystems"), "/")
+		if path == "" {
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			statuses := make([]SubsystemStatus, len(subsystems))
+			for i, sub := range subsystems {
+				statuses[i] = sub.Status()
+			}
+			writeJSON(w, http.StatusOK, statuses)
+			return
+		}
+
+		name, action, ok := strings.Cut(path, "/")
+		if !ok || (action != "start" && action != "stop") {
+			writeError(w, http.StatusNotFound, "expected /api/subsystems/{name}/start or /stop")
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		i := -1
+		for j, sub := range subsystems {
+			if sub.Status().Name == name {
+				i = j
+			}
+		}
+		if i < 0 {
+			writeError(w, http.StatusNotFound, "unknown subsystem "+name)
+			return
+		}
+
+		sub := subsystems[i]
+		if action == "stop" {
+			for _, later := range subsystems[i+1:] {
+				if status := later.Status(); status.Running {
+					writeError(w, http.StatusConflict, status.Name+" depends on "+name+" and is running")
+					return
+				}
+			}
+		} else {
+			for _, earlier := range subsystems[:i] {
+				if status := earlier.Status(); !status.Running {
+					writeError(w, http.StatusConflict, name+" depends on "+status.Name+", which is stopped")
+					return
+				}
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), subsystemTimeout)
+		defer cancel()
+		var err error
+		if action == "stop" {
+			err = sub.Stop(ctx)
+		} else {
+			err = sub.Start(ctx)
+		}
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, sub.Status())
+	}
+}
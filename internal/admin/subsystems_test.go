@@ -0,0 +1,114 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// fakeSubsystem records starts and stops in a shared log
+type fakeSubsystem struct {
+	name    string
+	running bool
+	log     *[]string
+}
+
+func (f *fakeSubsystem) Start(context.Context) error {
+	if f.running {
+		return errors.New("already running")
+	}
+	f.running = true
+	*f.log = append(*f.log, "start "+f.name)
+	return nil
+}
+
+func (f *fakeSubsystem) Stop(context.Context) error {
+	if !f.running {
+		return errors.New("already stopped")
+	}
+	f.running = false
+	*f.log = append(*f.log, "stop "+f.name)
+	return nil
+}
+
+func (f *fakeSubsystem) Status() SubsystemStatus {
+	return SubsystemStatus{Name: f.name, Running: f.running}
+}
+
+// newSubsystemsServer serves running subsystems named names, in start order
+func newSubsystemsServer(token string, names ...string) (*Server, *[]string) {
+	log := new([]string)
+	subsystems := make([]Subsystem, len(names))
+	for i, name := range names {
+		subsystems[i] = &fakeSubsystem{name: name, running: true, log: log}
+	}
+	s := NewServer("", logging.Nop())
+	s.EnableSubsystems(token, subsystems...)
+	return s, log
+}
+
+func TestSubsystemsStopAndStartInOrder(t *testing.T) {
+	s, log := newSubsystemsServer(testToken, "mqtt", "quic")
+	tests := []struct {
+		action string
+		want   int
+	}{
+		{"mqtt/stop", http.StatusConflict}, // quic depends on it and runs
+		{"quic/stop", http.StatusOK},
+		{"quic/stop", http.StatusConflict},
+		{"mqtt/stop", http.StatusOK},
+		{"quic/start", http.StatusConflict}, // mqtt is stopped
+		{"mqtt/start", http.StatusOK},
+		{"quic/start", http.StatusOK},
+		{"nope/start", http.StatusNotFound},
+		{"quic/restart", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := call(s, http.MethodPost, "/api/subsystems/"+tt.action, "", testToken); rec.Code != tt.want {
+			t.Errorf("POST %s: status %d, want %d: %s", tt.action, rec.Code, tt.want, rec.Body)
+		}
+	}
+	want := []string{"stop quic", "stop mqtt", "start mqtt", "start quic"}
+	if len(*log) != len(want) {
+		t.Fatalf("subsystems changed as %v, want %v", *log, want)
+	}
+	for i := range want {
+		if (*log)[i] != want[i] {
+			t.Fatalf("subsystems changed as %v, want %v", *log, want)
+		}
+	}
+
+	rec := call(s, http.MethodGet, "/api/subsystems", "", "")
+	var statuses []SubsystemStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "mqtt" || !statuses[0].Running || !statuses[1].Running {
+		t.Errorf("statuses %+v, want mqtt and quic running", statuses)
+	}
+}
+
+func TestSubsystemsRequireToken(t *testing.T) {
+	s, log := newSubsystemsServer(testToken, "quic")
+	for _, token := range []string{"", "guess"} {
+		rec := call(s, http.MethodPost, "/api/subsystems/quic/stop", "", token)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("POST with token %q: status %d, want 401 with a challenge", token, rec.Code)
+		}
+	}
+
+	open, openLog := newSubsystemsServer("", "quic")
+	if rec := call(open, http.MethodPost, "/api/subsystems/quic/stop", "", "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("POST without admin.token: status %d, want 403", rec.Code)
+	}
+	if rec := call(open, http.MethodGet, "/api/subsystems", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without admin.token: status %d, want 200", rec.Code)
+	}
+	if len(*log) != 0 || len(*openLog) != 0 {
+		t.Errorf("subsystems changed without the token: %v %v", *log, *openLog)
+	}
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+)
+
+// SessionPacing is an entry of GET /api/sessions
+type SessionPacing struct {
+	Session string           `json:"session"`
+	Pacing  streaming.Pacing `json:"pacing"`
+}
+
+// SessionTimelinesHandler serves the chunk send timelines of streaming
+// sessions:
+//
+//	GET /api/sessions                       pacing summary of every session
+//	GET /api/sessions/{id}/timeline?last=N  chunk timings as NDJSON, oldest first
+//	GET /api/sessions/{id}/pacing           pacing error statistics
+func SessionTimelinesHandler(timelines *streaming.Timelines) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/sessions"), "/")
+		if path == "" {
+			sessions := []SessionPacing{}
+			for _, id := range timelines.Sessions() {
+				if entries, ok := timelines.Timeline(id); ok {
+					sessions = append(sessions, SessionPacing{Session: id, Pacing: streaming.AnalyzePacing(entries)})
+				}
+			}
+			writeJSON(w, http.StatusOK, sessions)
+			return
+		}
+
+		i := strings.LastIndex(path, "/")
+		if i <= 0 {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		session, err := url.PathUnescape(path[:i])
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid session")
+			return
+		}
+		entries, ok := timelines.Timeline(session)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown session")
+			return
+		}
+
+		switch path[i+1:] {
+		case "timeline":
+			if v := r.URL.Query().Get("last"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					writeError(w, http.StatusBadRequest, "invalid last")
+					return
+				}
+				if n < len(entries) {
+					entries = entries[len(entries)-n:]
+				}
+			}
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(w)
+			for _, e := range entries {
+				enc.Encode(e)
+			}
+		case "pacing":
+			writeJSON(w, http.StatusOK, streaming.AnalyzePacing(entries))
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// DesiredStateRequest is the body of PUT /api/twins/{device_id}/desired
+type DesiredStateRequest struct {
+	Properties map[string]interface{} `json:"properties"`
+	Version    int64                  `json:"version,omitempty"` // 0 takes the next version
+}
+
+// EnableTwins mounts TwinsHandler. Devices apply the desired state they
+// are given, so setting it requires the admin token and is refused without
+// one.
+func (s *Server) EnableTwins(token string, twins *iot.Twins) {
+	gated := requireTokenToChange(token, "desired state", TwinsHandler(twins))
+	s.Handle("/api/twins", gated)
+	s.Handle("/api/twins/", gated)
+}
+
+// TwinsHandler serves device twins:
+//
+//	GET /api/twins                        every twin
+//	GET /api/twins/{device_id}            one twin
+//	PUT /api/twins/{device_id}/desired    replace the desired state
+//
+// Desired state may be set for devices that are offline. A version that
+// isn't newer than the current one is refused with 409.
+func TwinsHandler(twins *iot.Twins) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/twins"), "/")
+		if path == "" {
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			writeJSON(w, http.StatusOK, twins.List())
+			return
+		}
+
+		deviceID, rest, _ := strings.Cut(path, "/")
+		switch rest {
+		case "":
+			if r.Method != http.MethodGet {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			twin, ok := twins.Get(deviceID)
+			if !ok {
+				writeError(w, http.StatusNotFound, "no twin for device "+deviceID)
+				return
+			}
+			writeJSON(w, http.StatusOK, twin)
+		case "desired":
+			if r.Method != http.MethodPut {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			var req DesiredStateRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid desired state body")
+				return
+			}
+			if req.Version < 0 {
+				writeError(w, http.StatusBadRequest, "version must not be negative")
+				return
+			}
+			twin, err := twins.SetDesired(deviceID, req.Properties, req.Version)
+			if errors.Is(err, iot.ErrStaleVersion) {
+				writeError(w, http.StatusConflict, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, twin)
+		default:
+			writeError(w, http.StatusNotFound, "expected /api/twins/{device_id} or /api/twins/{device_id}/desired")
+		}
+	}
+}
+
+// DivergedTwinsHandler lists the devices whose reported state hasn't
+// converged to their desired state, with how long they have diverged and the
+// reconciler's last attempt, at GET /api/shadows/diverged
+func DivergedTwinsHandler(reconciler *iot.Reconciler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, reconciler.Diverged())
+	}
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestDesiredStateRequiresToken(t *testing.T) {
+	twins := iot.NewTwins(logging.Nop(), clock.Real())
+	s := NewServer("", logging.Nop())
+	s.EnableTwins(testToken, twins)
+
+	const desired = `{"properties": {"interval": "30s"}}`
+	for _, token := range []string{"", "guess"} {
+		if rec := call(s, http.MethodPut, "/api/twins/dev1/desired", desired, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("PUT with token %q: status %d, want 401", token, rec.Code)
+		}
+	}
+	if _, ok := twins.Get("dev1"); ok {
+		t.Fatal("desired state set without the token")
+	}
+	if rec := call(s, http.MethodPut, "/api/twins/dev1/desired", desired, testToken); rec.Code != http.StatusOK {
+		t.Fatalf("PUT with the token: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := call(s, http.MethodGet, "/api/twins/dev1", "", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET without a token: status %d, want 200", rec.Code)
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableTwins("", twins)
+	if rec := call(open, http.MethodPut, "/api/twins/dev2/desired", desired, "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("PUT without admin.token: status %d, want 403", rec.Code)
+	}
+	if _, ok := twins.Get("dev2"); ok {
+		t.Error("desired state set without admin.token")
+	}
+}
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// EnableUploads mounts DeviceUploadsHandler. Downloading a file requires the
+// admin token, since devices choose what they upload, and is refused without
+// one. Listing a device's uploads does not.
+func (s *Server) EnableUploads(token string, store *iot.UploadStore) {
+	s.Handle("/api/devices/", DeviceUploadsHandler(token, store))
+}
+
+// DeviceUploadsHandler serves the files uploaded by devices:
+//
+//	GET /api/devices/{id}/uploads         the device's uploads, newest first
+//	GET /api/devices/{id}/uploads/{file}  download one of them
+//
+// Downloads are always sent as application/octet-stream attachments, whatever
+// content type the device declared.
+func DeviceUploadsHandler(token string, store *iot.UploadStore) http.HandlerFunc {
+	download := requireToken(token, downloadHandler(store))
+	if token == "" {
+		download = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			writeError(w, http.StatusForbidden, "downloading uploads requires admin.token")
+		})
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] != "uploads" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+		if len(parts) == 3 {
+			download.ServeHTTP(w, r)
+			return
+		}
+
+		uploads, err := store.List(parts[0])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "unknown device")
+			return
+		}
+		writeJSON(w, http.StatusOK, uploads)
+	}
+}
+
+func downloadHandler(store *iot.UploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/devices/"), "/")
+		f, upload, err := store.Open(parts[0], parts[2])
+		if err != nil {
+			writeError(w, http.StatusNotFound, "unknown upload")
+			return
+		}
+		defer f.Close()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+upload.ID+`"`)
+		w.Header().Set(iot.UploadSHA256Header, upload.SHA256)
+		http.ServeContent(w, r, "", upload.ReceivedAt, f)
+	}
+}
@@ -0,0 +1,70 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestUploadDownloadsRequireToken(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	store, err := iot.NewUploadStore(config.UploadConfig{Dir: t.TempDir(), MaxBytes: 1024}, fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := "<script>alert(1)</script>"
+	sum := sha256.Sum256([]byte(page))
+	upload, err := store.Save(iot.UploadRequest{
+		DeviceID:    "cam1",
+		ContentType: "text/html",
+		Size:        int64(len(page)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}, strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer("", logging.Nop())
+	s.EnableUploads(testToken, store)
+
+	var uploads []iot.Upload
+	rec := call(s, http.MethodGet, "/api/devices/cam1/uploads", "", "")
+	if err := json.NewDecoder(rec.Body).Decode(&uploads); err != nil || len(uploads) != 1 || uploads[0].URL != upload.URL {
+		t.Fatalf("listing: %+v (%v), want the upload at %s", uploads, err, upload.URL)
+	}
+
+	if rec := call(s, http.MethodGet, upload.URL, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("download without a token: status %d, want 401", rec.Code)
+	}
+	if rec := call(s, http.MethodGet, "/api/devices/cam1/uploads/1-guess", "", testToken); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown upload: status %d, want 404", rec.Code)
+	}
+	rec = call(s, http.MethodGet, upload.URL, "", testToken)
+	if rec.Code != http.StatusOK || rec.Body.String() != page {
+		t.Fatalf("download: status %d %q", rec.Code, rec.Body)
+	}
+	for header, want := range map[string]string{
+		"Content-Type":           "application/octet-stream",
+		"X-Content-Type-Options": "nosniff",
+		"Content-Disposition":    `attachment; filename="` + upload.ID + `"`,
+	} {
+		if got := rec.Header().Get(header); got != want {
+			t.Errorf("%s %q, want %q", header, got, want)
+		}
+	}
+
+	open := NewServer("", logging.Nop())
+	open.EnableUploads("", store)
+	if rec := call(open, http.MethodGet, upload.URL, "", "anything"); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "admin.token") {
+		t.Errorf("download without admin.token: status %d, want 403", rec.Code)
+	}
+}
@@ -7,58 +7,156 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
 )
 
 // TestConfig represents benchmark test configuration
 type TestConfig struct {
-	Protocol      string        `json:"protocol"`       // "quic" or "tcp"
-	Endpoint      string        `json:"endpoint"`       // server endpoint
-	TestType      string        `json:"test_type"`      // "latency", "throughput", "iot", "streaming"
-	Duration      time.Duration `json:"duration"`       // test duration
-	Clients       int           `json:"clients"`        // concurrent clients
-	RequestSize   int           `json:"request_size"`   // request payload size
-	PacketLoss    float64       `json:"packet_loss"`    // simulated packet loss %
-	Bandwidth     int64         `json:"bandwidth"`      // bandwidth limit (bytes/s)
-	Jitter        time.Duration `json:"jitter"`         // network jitter
+	Protocol          string        `json:"protocol"`            // "quic" or "tcp"
+	Endpoint          string        `json:"endpoint"`            // server endpoint
+	TestType          string        `json:"test_type"`           // "latency", "throughput", "iot", "streaming", "encoding"
+	Duration          time.Duration `json:"duration"`            // test duration
+	Clients           int           `json:"clients"`             // concurrent clients
+	RequestSize       int           `json:"request_size"`        // request payload size
+	PacketLoss        float64       `json:"packet_loss"`         // simulated packet loss %
+	Bandwidth         int64         `json:"bandwidth"`           // bandwidth limit (bytes/s)
+	Jitter            time.Duration `json:"jitter"`              // network jitter
+	Condition         string        `json:"condition"`           // network condition label, e.g. "lossy-2pct"
+	Faults            []Fault       `json:"faults,omitempty"`    // fault schedule injected during the test
+	AdminURL          string        `json:"admin_url,omitempty"` // server admin API, needed for server-restart faults
+	AdminToken        string        `json:"-"`
+	MaxConnsPerHost   int           `json:"max_conns_per_host,omitempty"`   // connection pool size, 0 for the default
+	NewConnPerRequest bool          `json:"new_conn_per_request,omitempty"` // disable reuse to include the handshake in every request
+	Encoding          string        `json:"encoding,omitempty"`             // IoT payload encoding of an encoding test
+	BatchSize         int           `json:"batch_size,omitempty"`           // readings per request of an encoding test, 0 for 1
+	ClockSync         bool          `json:"clock_sync,omitempty"`           // estimate the server clock offset to measure one-way latency
+	SyncExchanges     int           `json:"sync_exchanges,omitempty"`       // pings per clock sync, 0 for DefaultSyncExchanges
+	MaxUncertainty    time.Duration `json:"max_sync_uncertainty,omitempty"` // above it one-way latency falls back to RTT/2, 0 for DefaultMaxSyncUncertainty
+	Congestion        string        `json:"congestion,omitempty"`           // congestion controller of the client's TCP connections, empty for the system default
+	QUICWindow        uint64        `json:"quic_window,omitempty"`          // max stream receive window of a real HTTP/3 client, 0 for the HTTP/2 simulation
+	Compression       string        `json:"compression,omitempty"`          // codec of IoT test bodies, empty for plain
 }
 
 // TestResult represents benchmark test results
 type TestResult struct {
-	Protocol        string        `json:"protocol"`
-	TestType        string        `json:"test_type"`
-	Duration        time.Duration `json:"duration"`
-	TotalRequests   int64         `json:"total_requests"`
-	SuccessRequests int64         `json:"success_requests"`
-	FailedRequests  int64         `json:"failed_requests"`
-	Throughput      float64       `json:"throughput_rps"`     // requests per second
-	Bandwidth       float64       `json:"bandwidth_mbps"`     // megabits per second
-	AvgLatency      float64       `json:"avg_latency_ms"`     // milliseconds
-	MinLatency      float64       `json:"min_latency_ms"`     // milliseconds
-	MaxLatency      float64       `json:"max_latency_ms"`     // milliseconds
-	P95Latency      float64       `json:"p95_latency_ms"`     // 95th percentile
-	P99Latency      float64       `json:"p99_latency_ms"`     // 99th percentile
-	BytesSent       int64         `json:"bytes_sent"`
-	BytesReceived   int64         `json:"bytes_received"`
-	Errors          []string      `json:"errors,omitempty"`
-	Timestamp       time.Time     `json:"timestamp"`
+	Protocol          string            `json:"protocol"`
+	TestType          string            `json:"test_type"`
+	Condition         string            `json:"condition,omitempty"`
+	Duration          time.Duration     `json:"duration"`
+	TotalRequests     int64             `json:"total_requests"`
+	SuccessRequests   int64             `json:"success_requests"`
+	FailedRequests    int64             `json:"failed_requests"`
+	Throughput        float64           `json:"throughput_rps"` // requests per second
+	Bandwidth         float64           `json:"bandwidth_mbps"` // megabits per second
+	AvgLatency        float64           `json:"avg_latency_ms"` // milliseconds
+	MinLatency        float64           `json:"min_latency_ms"` // milliseconds
+	MaxLatency        float64           `json:"max_latency_ms"` // milliseconds
+	P95Latency        float64           `json:"p95_latency_ms"` // 95th percentile
+	P99Latency        float64           `json:"p99_latency_ms"` // 99th percentile
+	BytesSent         int64             `json:"bytes_sent"`
+	BytesReceived     int64             `json:"bytes_received"`
+	WireBytesSent     int64             `json:"wire_bytes_sent"`     // request bodies as sent, compressed or not
+	WireBytesReceived int64             `json:"wire_bytes_received"` // response bodies as received
+	Compression       string            `json:"compression,omitempty"`
+	Connections       int64             `json:"connections"`        // distinct connections opened
+	ReusedConns       int64             `json:"reused_connections"` // requests served on an existing connection
+	RequestsPerConn   float64           `json:"requests_per_connection"`
+	Encoding          string            `json:"encoding,omitempty"`
+	BatchSize         int               `json:"batch_size,omitempty"`
+	Readings          int64             `json:"readings,omitempty"`                     // sensor readings accepted
+	BytesPerReading   float64           `json:"bytes_per_reading,omitempty"`            // request body bytes
+	ClientEncodeUs    float64           `json:"client_encode_us_per_reading,omitempty"` // time spent encoding requests
+	ServerDecodeUs    float64           `json:"server_decode_us_per_reading,omitempty"` // from the server's Server-Timing header
+	Congestion        string            `json:"congestion,omitempty"`                   // controller the client's TCP connections ran
+	CongestionNote    string            `json:"congestion_note,omitempty"`              // why the requested controller was not used
+	QUICWindow        uint64            `json:"quic_window,omitempty"`
+	FlowBlockedMs     float64           `json:"flow_control_blocked_ms,omitempty"` // client sends blocked on the server's windows
+	ClockSync         *ClockSync        `json:"clock_sync,omitempty"`
+	OneWay            *OneWayLatency    `json:"one_way,omitempty"`
+	Loss              *ObservedLoss     `json:"loss,omitempty"`              // IoT tests
+	LatencyHistogram  []HistogramBucket `json:"latency_histogram,omitempty"` // fixed LatencyBucketsMs bounds
+	Timeline          []TimelinePoint   `json:"timeline,omitempty"`
+	Faults            []FaultWindow     `json:"faults,omitempty"`
+	Errors            []string          `json:"errors,omitempty"`
+	Timestamp         time.Time         `json:"timestamp"`
+}
+
+// TimelinePoint summarizes the requests that completed in one second of the
+// test, so latency spikes can be matched with fault windows
+type TimelinePoint struct {
+	Second       int     `json:"second"`
+	Requests     int64   `json:"requests"`
+	Failed       int64   `json:"failed"`
+	MaxLatencyMs float64 `json:"max_latency_ms"`
 }
 
 // Benchmarker handles performance testing
 type Benchmarker struct {
-	config    TestConfig
+	config     TestConfig
 	httpClient *http.Client
-	results   *TestResult
-	latencies []float64
-	mutex     sync.Mutex
+	transport  idleCloser
+	blackhole  *blackholeTransport
+	results    *TestResult
+	latencies  []float64
+	timeline   []TimelinePoint
+	start      time.Time
+
+	// Encoding tests
+	sequences    []int // next reading of each client
+	readings     int64
+	readingBytes int64
+	encodeTime   time.Duration
+	decoded      int64 // readings with a server decode time
+	decodeTime   time.Duration
+
+	// IoT tests
+	iotSeqs  []uint64 // last reading sequence number of each client
+	iotEpoch uint64
+
+	// One-way latency
+	offset    time.Duration // server clock minus client clock
+	uplinks   []float64     // ms
+	downlinks []float64
+
+	flowBlocked atomic.Int64 // ns, HTTP/3 client only
+
+	mutex  sync.Mutex
+	logger logging.Logger
+	clock  clock.Clock
+}
+
+// idleCloser is the client transport, HTTP/2 or HTTP/3
+type idleCloser interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// Option configures a Benchmarker
+type Option func(*Benchmarker)
+
+// WithClock replaces the clock that paces the test window. Request latencies
+// are always measured with the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(b *Benchmarker) {
+		b.clock = c
+	}
 }
 
 // NewBenchmarker creates a new benchmarker
-func NewBenchmarker(config TestConfig) *Benchmarker {
+func NewBenchmarker(config TestConfig, logger logging.Logger, opts ...Option) *Benchmarker {
 	// Configure HTTP client based on protocol
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
 	transport := &http.Transport{
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: true,
@@ -67,44 +165,100 @@ func NewBenchmarker(config TestConfig) *Benchmarker {
 		MaxIdleConnsPerHost: 10,
 		IdleConnTimeout:     30 * time.Second,
 	}
+	if config.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = config.MaxConnsPerHost
+		transport.MaxIdleConnsPerHost = config.MaxConnsPerHost
+	}
+	if config.NewConnPerRequest {
+		// Every request dials and handshakes on a fresh connection
+		transport.DisableKeepAlives = true
+	}
+
+	b := &Benchmarker{
+		config:    config,
+		transport: transport,
+		latencies: make([]float64, 0),
+		logger:    logger,
+		clock:     clock.Real(),
+	}
 
 	// For HTTP/3 (QUIC), we would need a different transport
 	// This is a simplified version for HTTP/1.1 and HTTP/2 over TCP
-	if config.Protocol == "quic" {
+	if config.Protocol == "quic" && config.QUICWindow > 0 {
+		// Window sweeps need real QUIC flow control
+		b.transport = quiclib.NewClientTransport(WindowConfig(config.QUICWindow), func(d time.Duration) {
+			b.flowBlocked.Add(int64(d))
+		})
+	} else if config.Protocol == "quic" {
 		// In a real implementation, we'd use quic-go's HTTP/3 client
-		log.Println("Note: Using HTTP/2 client for QUIC endpoint simulation")
+		logger.Warn("Using HTTP/2 client for QUIC endpoint simulation")
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.results = &TestResult{
+		Protocol:  config.Protocol,
+		TestType:  config.TestType,
+		Condition: config.Condition,
+		Timestamp: b.clock.Now(),
 	}
+	b.selectCongestion(dialer)
+	transport.DialContext = b.dial(dialer)
 
-	client := &http.Client{
-		Transport: transport,
+	// Requests pass through the blackhole so faults can hold them
+	b.blackhole = &blackholeTransport{next: b.transport, clock: b.clock}
+	b.httpClient = &http.Client{
+		Transport: b.blackhole,
 		Timeout:   30 * time.Second,
 	}
-
-	return &Benchmarker{
-		config:     config,
-		httpClient: client,
-		results: &TestResult{
-			Protocol:  config.Protocol,
-			TestType:  config.TestType,
-			Timestamp: time.Now(),
-		},
-		latencies: make([]float64, 0),
+	if config.TestType == TestTypeEncoding {
+		b.sequences = make([]int, config.Clients)
+		b.results.Encoding = config.Encoding
+		b.results.BatchSize = max(config.BatchSize, 1)
 	}
+	if config.TestType == "iot" {
+		b.iotSeqs = make([]uint64, config.Clients)
+		b.iotEpoch = uint64(b.clock.Now().UnixNano())
+		b.results.Compression = config.Compression
+	}
+	return b
 }
 
 // Run executes the benchmark test
 func (b *Benchmarker) Run(ctx context.Context) (*TestResult, error) {
-	log.Printf("Starting %s benchmark: %s test with %d clients for %v",
-		b.config.Protocol, b.config.TestType, b.config.Clients, b.config.Duration)
+	b.logger.Info("Starting benchmark", logging.F("protocol", b.config.Protocol),
+		logging.F("test", b.config.TestType), logging.F("clients", b.config.Clients),
+		logging.F("duration", b.config.Duration))
 
-	start := time.Now()
-	endTime := start.Add(b.config.Duration)
+	if b.config.ClockSync {
+		b.results.ClockSync = b.syncClock(ctx)
+	}
+
+	start := b.clock.Now()
+	b.start = start
 
 	// Create worker goroutines
 	var wg sync.WaitGroup
-	clientCtx, cancel := context.WithDeadline(ctx, endTime)
+	clientCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if len(b.config.Faults) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.runFaults(clientCtx.Done(), start)
+		}()
+	}
+
+	// End the test window on the benchmark clock
+	go func() {
+		select {
+		case <-b.clock.After(b.config.Duration):
+			cancel()
+		case <-clientCtx.Done():
+		}
+	}()
+
 	for i := 0; i < b.config.Clients; i++ {
 		wg.Add(1)
 		go func(clientID int) {
@@ -115,16 +269,30 @@ func (b *Benchmarker) Run(ctx context.Context) (*TestResult, error) {
 
 	// Wait for all clients to finish
 	wg.Wait()
+	// Closing the connections ends any flow-control blocked period
+	b.transport.CloseIdleConnections()
+	if b.iotSeqs != nil {
+		b.results.Loss = b.observeLoss(ctx)
+	}
 
 	// Calculate final results
-	b.calculateResults(time.Since(start))
+	b.calculateResults(b.clock.Now().Sub(start))
 
-	log.Printf("Benchmark completed: %d requests, %.2f RPS, %.2f ms avg latency",
-		b.results.TotalRequests, b.results.Throughput, b.results.AvgLatency)
+	b.logger.Info("Benchmark completed", logging.F("requests", b.results.TotalRequests),
+		logging.F("rps", fmt.Sprintf("%.2f", b.results.Throughput)),
+		logging.F("avg_latency_ms", fmt.Sprintf("%.2f", b.results.AvgLatency)))
 
 	return b.results, nil
 }
 
+// Progress returns the requests completed and failed so far
+func (b *Benchmarker) Progress() (completed, failed int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	// Requests that got no response are only counted as failed
+	return b.results.SuccessRequests + b.results.FailedRequests, b.results.FailedRequests
+}
+
 func (b *Benchmarker) runClient(ctx context.Context, clientID int) {
 	for {
 		select {
@@ -136,6 +304,7 @@ func (b *Benchmarker) runClient(ctx context.Context, clientID int) {
 				b.mutex.Lock()
 				b.results.FailedRequests++
 				b.results.Errors = append(b.results.Errors, err.Error())
+				b.recordTimeline(0, true)
 				b.mutex.Unlock()
 			}
 		}
@@ -145,35 +314,80 @@ func (b *Benchmarker) runClient(ctx context.Context, clientID int) {
 func (b *Benchmarker) makeRequest(clientID int) error {
 	start := time.Now()
 
-	// Build request URL based on test type
-	url := b.buildRequestURL()
-	
-	// Create request payload
-	payload := b.createPayload()
-	
+	// Build request URL and payload based on test type
+	var url string
+	var payload []byte
+	var encoded encodedRequest
+	contentType := "application/json"
+	if b.config.TestType == TestTypeEncoding {
+		var err error
+		if encoded, err = b.nextEncoded(clientID); err != nil {
+			return err
+		}
+		url, payload, contentType = b.config.Endpoint+encoded.path, encoded.body, encoded.contentType
+	} else {
+		url, payload = b.buildRequestURL(), b.createPayload(clientID)
+	}
+
+	// IoT test bodies go compressed when asked to
+	body := payload
+	if b.results.Compression != "" {
+		var err error
+		if body, err = compressBody(b.results.Compression, payload); err != nil {
+			return err
+		}
+	}
+
 	// Make HTTP request
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
-	
-	req.Header.Set("Content-Type", "application/json")
+
+	req.Header.Set("Content-Type", contentType)
+	// Ask for the response as it is sent, so its bytes on the wire are
+	// counted rather than what the transport decompressed
+	req.Header.Set("Accept-Encoding", "identity")
+	if b.results.Compression != "" {
+		req.Header.Set("Content-Encoding", b.results.Compression)
+		req.Header.Set("Accept-Encoding", b.results.Compression)
+	}
 	req.Header.Set("X-Client-ID", fmt.Sprintf("client_%d", clientID))
-	
+	if b.config.TestType == TestTypeEncoding {
+		// Delta batches need ProtocolV3
+		req.Header.Set(iot.VersionHeader, strconv.Itoa(iot.ProtocolV3))
+	}
+
+	// Track whether the request opened a connection or reused a pooled one
+	var gotConn, reused bool
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			gotConn, reused = true, info.Reused
+		},
+	}))
+
+	sent := time.Now()
 	resp, err := b.httpClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	
+
 	// Read response
-	respBody, err := io.ReadAll(resp.Body)
+	wire, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return err
 	}
-	
-	latency := time.Since(start)
-	
+	respBody := wire
+	if codec := resp.Header.Get("Content-Encoding"); codec != "" {
+		if respBody, err = decompressBody(codec, wire); err != nil {
+			return err
+		}
+	}
+
+	received := time.Now()
+	latency := received.Sub(start)
+
 	// Record metrics
 	b.mutex.Lock()
 	b.results.TotalRequests++
@@ -184,15 +398,56 @@ func (b *Benchmarker) makeRequest(clientID int) error {
 	}
 	b.results.BytesSent += int64(len(payload))
 	b.results.BytesReceived += int64(len(respBody))
+	b.results.WireBytesSent += int64(len(body))
+	b.results.WireBytesReceived += int64(len(wire))
+	if gotConn && reused {
+		b.results.ReusedConns++
+	} else if gotConn {
+		b.results.Connections++
+	}
+	if encoded.readings > 0 && resp.StatusCode == 200 {
+		b.readings += int64(encoded.readings)
+		b.readingBytes += int64(len(payload))
+		b.encodeTime += encoded.encode
+		if d, ok := serverTiming(resp.Header, "decode"); ok {
+			b.decoded += int64(encoded.readings)
+			b.decodeTime += d
+		}
+	}
+	if b.results.ClockSync != nil && b.results.ClockSync.Error == "" && resp.StatusCode == 200 {
+		b.recordOneWay(resp, sent, received)
+	}
 	b.latencies = append(b.latencies, float64(latency.Nanoseconds())/1e6) // Convert to ms
+	b.recordTimeline(float64(latency.Nanoseconds())/1e6, resp.StatusCode != 200)
 	b.mutex.Unlock()
-	
+
 	return nil
 }
 
+// recordTimeline adds a completed request to the current second of the
+// timeline. The caller holds b.mutex.
+func (b *Benchmarker) recordTimeline(latencyMs float64, failed bool) {
+	second := int(b.clock.Now().Sub(b.start) / time.Second)
+	if second < 0 {
+		second = 0
+	}
+	for len(b.timeline) <= second {
+		b.timeline = append(b.timeline, TimelinePoint{Second: len(b.timeline)})
+	}
+
+	p := &b.timeline[second]
+	p.Requests++
+	if failed {
+		p.Failed++
+	}
+	if latencyMs > p.MaxLatencyMs {
+		p.MaxLatencyMs = latencyMs
+	}
+}
+
 func (b *Benchmarker) buildRequestURL() string {
 	baseURL := b.config.Endpoint
-	
+
 	switch b.config.TestType {
 	case "latency":
 		return baseURL + "/benchmark/"
@@ -201,31 +456,22 @@ func (b *Benchmarker) buildRequestURL() string {
 	case "iot":
 		return baseURL + "/iot/sensor"
 	case "streaming":
-		return baseURL + "/stream/chunk/test_stream"
+		return baseURL + "/stream/chunk/stream_001"
 	default:
 		return baseURL + "/health"
 	}
 }
 
-func (b *Benchmarker) createPayload() []byte {
+func (b *Benchmarker) createPayload(clientID int) []byte {
 	switch b.config.TestType {
 	case "iot":
-		data := map[string]interface{}{
-			"device_id":    fmt.Sprintf("bench_device_%d", time.Now().UnixNano()),
-			"sensor_type":  "temperature",
-			"value":        25.5,
-			"unit":         "celsius",
-			"timestamp":    time.Now(),
-			"quality":      "reliable",
-		}
-		payload, _ := json.Marshal(data)
-		return payload
+		return b.iotPayload(clientID)
 	case "streaming":
 		// Simulate video chunk request
 		data := map[string]interface{}{
 			"stream_id": "benchmark_stream",
 			"quality":   "medium",
-			"chunk":     time.Now().UnixNano() % 1000,
+			"chunk":     b.clock.Now().UnixNano() % 1000,
 		}
 		payload, _ := json.Marshal(data)
 		return payload
@@ -242,20 +488,39 @@ func (b *Benchmarker) createPayload() []byte {
 func (b *Benchmarker) calculateResults(duration time.Duration) {
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
-	
+
 	b.results.Duration = duration
-	
+	b.results.Timeline = b.timeline
+	if b.config.QUICWindow > 0 {
+		b.results.QUICWindow = b.config.QUICWindow
+		b.results.FlowBlockedMs = float64(b.flowBlocked.Load()) / 1e6
+	}
+	if b.results.Connections > 0 {
+		b.results.RequestsPerConn = float64(b.results.Connections+b.results.ReusedConns) / float64(b.results.Connections)
+	}
+	if b.readings > 0 {
+		b.results.Readings = b.readings
+		b.results.BytesPerReading = float64(b.readingBytes) / float64(b.readings)
+		b.results.ClientEncodeUs = float64(b.encodeTime.Nanoseconds()) / 1e3 / float64(b.readings)
+	}
+	if b.decoded > 0 {
+		b.results.ServerDecodeUs = float64(b.decodeTime.Nanoseconds()) / 1e3 / float64(b.decoded)
+	}
+	if b.config.ClockSync {
+		b.results.OneWay = b.oneWayLatency()
+	}
+
 	if duration.Seconds() > 0 {
 		b.results.Throughput = float64(b.results.TotalRequests) / duration.Seconds()
 		b.results.Bandwidth = float64(b.results.BytesSent+b.results.BytesReceived) * 8 / duration.Seconds() / 1e6 // Mbps
 	}
-	
+
 	if len(b.latencies) > 0 {
 		// Calculate latency statistics
 		sum := 0.0
 		min := b.latencies[0]
 		max := b.latencies[0]
-		
+
 		for _, lat := range b.latencies {
 			sum += lat
 			if lat < min {
@@ -265,20 +530,26 @@ func (b *Benchmarker) calculateResults(duration time.Duration) {
 				max = lat
 			}
 		}
-		
+
+		hist := NewHistogram()
+		for _, lat := range b.latencies {
+			hist.Record(lat)
+		}
+		b.results.LatencyHistogram = hist.Buckets()
+
 		b.results.AvgLatency = sum / float64(len(b.latencies))
 		b.results.MinLatency = min
 		b.results.MaxLatency = max
-		
+
 		// Calculate percentiles (simplified)
 		if len(b.latencies) >= 20 {
 			p95Index := int(float64(len(b.latencies)) * 0.95)
 			p99Index := int(float64(len(b.latencies)) * 0.99)
-			
+
 			// Sort latencies for percentile calculation
 			sortedLatencies := make([]float64, len(b.latencies))
 			copy(sortedLatencies, b.latencies)
-			
+
 			// Simple sort (for production use a proper sorting algorithm)
 			for i := 0; i < len(sortedLatencies); i++ {
 				for j := i + 1; j < len(sortedLatencies); j++ {
@@ -287,9 +558,9 @@ func (b *Benchmarker) calculateResults(duration time.Duration) {
 					}
 				}
 			}
-			
+
 			b.results.P95Latency = sortedLatencies[p95Index]
 			b.results.P99Latency = sortedLatencies[p99Index]
 		}
 	}
-}
\ No newline at end of file
+}
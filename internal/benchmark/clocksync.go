@@ -0,0 +1,221 @@
+package benchmark
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// Clock sync defaults
+const (
+	DefaultSyncExchanges      = 8
+	DefaultMaxSyncUncertainty = 5 * time.Millisecond
+)
+
+// SyncSample is one clock sync exchange: when the client sent the request
+// and received the response, and the server time in between
+type SyncSample struct {
+	Sent     time.Time
+	Server   time.Time
+	Received time.Time
+}
+
+// ClockSync is the estimated offset of the server clock from the client
+// clock. The true offset lies within Offset ± Uncertainty as long as the
+// clocks don't drift apart during the test.
+type ClockSync struct {
+	OffsetMs      float64 `json:"offset_ms"` // server minus client
+	UncertaintyMs float64 `json:"uncertainty_ms"`
+	MinRTTMs      float64 `json:"min_rtt_ms"`
+	Exchanges     int     `json:"exchanges"`
+	Error         string  `json:"error,omitempty"`
+}
+
+// OneWayLatency splits request latency into the client to server and server
+// to client legs. With RTTFallback set the clocks could not be synchronized
+// closely enough and both legs are half the round trip.
+type OneWayLatency struct {
+	RTTFallback   bool    `json:"rtt_fallback"`
+	Reason        string  `json:"reason,omitempty"`
+	Samples       int     `json:"samples"`
+	UplinkAvgMs   float64 `json:"uplink_avg_ms"`
+	UplinkP99Ms   float64 `json:"uplink_p99_ms"`
+	DownlinkAvgMs float64 `json:"downlink_avg_ms"`
+	DownlinkP99Ms float64 `json:"downlink_p99_ms"`
+	UncertaintyMs float64 `json:"uncertainty_ms"` // of each one-way latency
+}
+
+// EstimateOffset estimates the server clock offset, NTP style. Each exchange
+// bounds the offset to [Server-Received, Server-Sent]; the estimate is the
+// middle of the intersection of all bounds and the uncertainty half its
+// width. If the bounds don't intersect, because of drift or a server time
+// taken outside the exchange, the exchange with the shortest round trip is
+// used alone.
+func EstimateOffset(samples []SyncSample) (offset, uncertainty time.Duration, err error) {
+	if len(samples) == 0 {
+		return 0, 0, errors.New("no clock sync exchanges")
+	}
+
+	best := samples[0]
+	lower, upper := best.Server.Sub(best.Received), best.Server.Sub(best.Sent)
+	for _, s := range samples[1:] {
+		if s.Received.Sub(s.Sent) < best.Received.Sub(best.Sent) {
+			best = s
+		}
+		lower = max(lower, s.Server.Sub(s.Received))
+		upper = min(upper, s.Server.Sub(s.Sent))
+	}
+	if lower > upper {
+		lower, upper = best.Server.Sub(best.Received), best.Server.Sub(best.Sent)
+	}
+	return lower + (upper-lower)/2, (upper - lower) / 2, nil
+}
+
+// syncClock estimates the server clock offset from pings to the server's
+// /ping endpoint
+func (b *Benchmarker) syncClock(ctx context.Context) *ClockSync {
+	exchanges := b.config.SyncExchanges
+	if exchanges <= 0 {
+		exchanges = DefaultSyncExchanges
+	}
+
+	var samples []SyncSample
+	var lastErr error
+	for i := 0; i < exchanges; i++ {
+		s, err := b.syncExchange(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	result := &ClockSync{Exchanges: len(samples)}
+	offset, uncertainty, err := EstimateOffset(samples)
+	if err != nil {
+		if lastErr != nil {
+			err = lastErr
+		}
+		result.Error = err.Error()
+		b.logger.Warn("Clock sync failed", logging.Err(err))
+		return result
+	}
+	b.offset = offset
+
+	minRTT := samples[0].Received.Sub(samples[0].Sent)
+	for _, s := range samples[1:] {
+		minRTT = min(minRTT, s.Received.Sub(s.Sent))
+	}
+	result.OffsetMs = float64(offset.Nanoseconds()) / 1e6
+	result.UncertaintyMs = float64(uncertainty.Nanoseconds()) / 1e6
+	result.MinRTTMs = float64(minRTT.Nanoseconds()) / 1e6
+	b.logger.Info("Clock synchronized", logging.F("offset_ms", fmt.Sprintf("%.3f", result.OffsetMs)),
+		logging.F("uncertainty_ms", fmt.Sprintf("%.3f", result.UncertaintyMs)), logging.F("exchanges", len(samples)))
+	return result
+}
+
+// syncExchange pings the server once
+func (b *Benchmarker) syncExchange(ctx context.Context) (SyncSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.config.Endpoint+"/ping", nil)
+	if err != nil {
+		return SyncSample{}, err
+	}
+
+	sent := time.Now()
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return SyncSample{}, err
+	}
+	defer resp.Body.Close()
+
+	var pong struct {
+		Timestamp int64 `json:"timestamp"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&pong)
+	received := time.Now()
+	if resp.StatusCode != http.StatusOK {
+		return SyncSample{}, fmt.Errorf("ping: %s", resp.Status)
+	}
+	if err != nil {
+		return SyncSample{}, fmt.Errorf("ping: %w", err)
+	}
+	return SyncSample{Sent: sent, Server: time.Unix(0, pong.Timestamp), Received: received}, nil
+}
+
+// recordOneWay splits the latency of a request into one-way legs using the
+// server receive time in resp. The caller holds b.mutex.
+func (b *Benchmarker) recordOneWay(resp *http.Response, sent, received time.Time) {
+	v := resp.Header.Get(health.ServerTimeHeader)
+	if v == "" {
+		return
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return
+	}
+	// Server time in the client clock
+	at := time.Unix(0, ns).Add(-b.offset)
+	b.uplinks = append(b.uplinks, float64(at.Sub(sent).Nanoseconds())/1e6)
+	b.downlinks = append(b.downlinks, float64(received.Sub(at).Nanoseconds())/1e6)
+}
+
+// oneWayLatency summarizes the one-way legs, or half the round trips when
+// the clocks are not synchronized within MaxUncertainty. The caller holds
+// b.mutex.
+func (b *Benchmarker) oneWayLatency() *OneWayLatency {
+	limit := b.config.MaxUncertainty
+	if limit <= 0 {
+		limit = DefaultMaxSyncUncertainty
+	}
+
+	ow := &OneWayLatency{}
+	cs := b.results.ClockSync
+	switch {
+	case cs == nil || cs.Error != "":
+		ow.RTTFallback, ow.Reason = true, "clock sync failed"
+	case cs.UncertaintyMs > float64(limit.Nanoseconds())/1e6:
+		ow.RTTFallback = true
+		ow.Reason = fmt.Sprintf("sync uncertainty %.3f ms exceeds %v", cs.UncertaintyMs, limit)
+	case len(b.uplinks) == 0:
+		ow.RTTFallback, ow.Reason = true, "server sent no "+health.ServerTimeHeader+" header"
+	}
+
+	uplinks, downlinks := b.uplinks, b.downlinks
+	if ow.RTTFallback {
+		uplinks = make([]float64, len(b.latencies))
+		for i, l := range b.latencies {
+			uplinks[i] = l / 2
+		}
+		downlinks = uplinks
+	} else {
+		ow.UncertaintyMs = cs.UncertaintyMs
+	}
+	ow.Samples = len(uplinks)
+	ow.UplinkAvgMs, ow.UplinkP99Ms = meanP99(uplinks)
+	ow.DownlinkAvgMs, ow.DownlinkP99Ms = meanP99(downlinks)
+	return ow
+}
+
+// meanP99 returns the mean and 99th percentile of values
+func meanP99(values []float64) (mean, p99 float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	return sum / float64(len(sorted)), sorted[int(float64(len(sorted))*0.99)]
+}
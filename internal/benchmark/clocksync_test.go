@@ -0,0 +1,89 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestEstimateOffset(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(n int) time.Time { return base.Add(time.Duration(n) * time.Millisecond) }
+	// The server clock is 50ms ahead; each sample bounds it within its round
+	// trip
+	samples := []SyncSample{
+		{Sent: ms(0), Server: ms(56), Received: ms(10)},  // [46, 56]
+		{Sent: ms(20), Server: ms(72), Received: ms(26)}, // [46, 52]
+		{Sent: ms(40), Server: ms(89), Received: ms(48)}, // [41, 49]
+	}
+	offset, uncertainty, err := EstimateOffset(samples)
+	if err != nil || offset != 47500*time.Microsecond || uncertainty != 1500*time.Microsecond {
+		t.Errorf("offset %v ± %v (%v), want 47.5ms ± 1.5ms", offset, uncertainty, err)
+	}
+
+	// Bounds that don't intersect fall back to the shortest round trip
+	samples = append(samples, SyncSample{Sent: ms(60), Server: ms(200), Received: ms(62)})
+	offset, uncertainty, err = EstimateOffset(samples)
+	if err != nil || offset != 139*time.Millisecond || uncertainty != time.Millisecond {
+		t.Errorf("offset %v ± %v (%v), want 139ms ± 1ms from the shortest exchange", offset, uncertainty, err)
+	}
+
+	if _, _, err := EstimateOffset(nil); err == nil {
+		t.Error("offset estimated without exchanges")
+	}
+}
+
+func TestOneWayLatencyWithSkewedServer(t *testing.T) {
+	// The server's clock runs an hour ahead of the client's
+	skew := time.Hour
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now().Add(skew)
+		if r.URL.Path == "/ping" {
+			fmt.Fprintf(w, `{"timestamp": %d}`, now.UnixNano())
+			return
+		}
+		w.Header().Set(health.ServerTimeHeader, strconv.FormatInt(now.UnixNano(), 10))
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	for _, limit := range []time.Duration{time.Second, time.Nanosecond} {
+		b := NewBenchmarker(TestConfig{Protocol: "tcp", Endpoint: server.URL, TestType: "latency", ClockSync: true, MaxUncertainty: limit}, logging.Nop())
+		b.start = b.clock.Now()
+		b.results.ClockSync = b.syncClock(context.Background())
+		if cs := b.results.ClockSync; cs.Error != "" || cs.Exchanges != DefaultSyncExchanges || math.Abs(cs.OffsetMs-3.6e6) > cs.UncertaintyMs+1 {
+			t.Fatalf("clock sync %+v, want an hour ahead", cs)
+		}
+		for i := 0; i < 5; i++ {
+			if err := b.makeRequest(0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b.calculateResults(time.Second)
+
+		ow := b.results.OneWay
+		if ow.Samples != 5 {
+			t.Errorf("limit %v: %d one-way samples, want 5", limit, ow.Samples)
+		}
+		if limit == time.Nanosecond {
+			if !ow.RTTFallback || !strings.Contains(ow.Reason, "exceeds") || ow.UplinkAvgMs != ow.DownlinkAvgMs {
+				t.Errorf("one-way %+v with an uncertainty over the limit, want half round trips", ow)
+			}
+			continue
+		}
+		// Legs are in the client's clock, so neither is off by the skew
+		if ow.RTTFallback || ow.UplinkAvgMs < -ow.UncertaintyMs-1 || ow.DownlinkAvgMs < -ow.UncertaintyMs-1 ||
+			ow.UplinkAvgMs+ow.DownlinkAvgMs > b.results.MaxLatency+1 {
+			t.Errorf("one-way %+v, want legs within the round trip of at most %.3fms", ow, b.results.MaxLatency)
+		}
+	}
+}
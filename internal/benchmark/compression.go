@@ -0,0 +1,36 @@
+package benchmark
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/nik1740/quic-communication-system/internal/iot/compress"
+)
+
+// compressBody returns payload compressed with codec, as a device that
+// negotiated it would send it
+func compressBody(codec string, payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := compress.NewWriter(codec, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressBody returns a response body received compressed with codec
+func decompressBody(codec string, body []byte) ([]byte, error) {
+	r, err := compress.NewReader(codec, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,45 @@
+package benchmark
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/nik1740/quic-communication-system/internal/congestion"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// selectCongestion makes the client's TCP connections run the requested
+// congestion controller, or records why they run the system default
+func (b *Benchmarker) selectCongestion(dialer *net.Dialer) {
+	name := b.config.Congestion
+	if name == "" {
+		return
+	}
+	if !slices.Contains(congestion.Available(), name) {
+		b.results.CongestionNote = fmt.Sprintf("congestion controller %q not available, using the system default", name)
+		b.logger.Warn("Congestion controller not available, using the system default", logging.F("congestion", name))
+		return
+	}
+	dialer.Control = congestion.Control(name)
+}
+
+// dial opens connections with dialer and records the congestion controller
+// they run
+func (b *Benchmarker) dial(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			if name, err := congestion.Of(tcp); err == nil {
+				b.mutex.Lock()
+				b.results.Congestion = name
+				b.mutex.Unlock()
+			}
+		}
+		return conn, nil
+	}
+}
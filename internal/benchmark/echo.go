@@ -0,0 +1,70 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/health"
+)
+
+// maxEchoBody bounds the echo payload accepted by EchoHandler
+const maxEchoBody = 16 << 20
+
+// EchoHandler serves /benchmark/, the target of latency and throughput
+// tests. GET describes the server and POST reads the body and reports how
+// long that took. protocol ("QUIC" or "TCP") and connection label the
+// responses.
+func EchoHandler(protocol, connection string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("X-Protocol", protocol)
+			writeEchoJSON(w, map[string]interface{}{
+				"protocol":   protocol,
+				"connection": connection,
+				"timestamp":  time.Now().Unix(),
+			})
+
+		case http.MethodPost:
+			start := time.Now()
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxEchoBody))
+			if err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					http.Error(w, "Body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, "Failed to read body", http.StatusBadRequest)
+				return
+			}
+			latency := time.Since(start)
+			latencyMs := float64(latency.Nanoseconds()) / 1e6
+
+			w.Header().Set("X-Protocol", protocol)
+			w.Header().Set("X-Latency-Ms", fmt.Sprintf("%.2f", latencyMs))
+			w.Header().Set(health.ServerTimeHeader, strconv.FormatInt(start.UnixNano(), 10))
+			writeEchoJSON(w, map[string]interface{}{
+				"protocol":   protocol,
+				"bytes_read": len(body),
+				"latency_ns": latency.Nanoseconds(),
+				"latency_ms": latencyMs,
+				"timestamp":  time.Now().Unix(),
+			})
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeEchoJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		http.Error(w, "Failed to write response", http.StatusInternalServerError)
+	}
+}
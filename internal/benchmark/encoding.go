@@ -0,0 +1,115 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// TestTypeEncoding sends sensor readings to the IoT endpoints in the payload
+// encoding of TestConfig.Encoding, TestConfig.BatchSize readings per request.
+// Every configuration sends the same readings, so their results compare the
+// encodings on identical data.
+const TestTypeEncoding = "encoding"
+
+// IoT payload encodings
+const (
+	EncodingJSON  = "json"  // a SensorData object, or an array of them when batched
+	EncodingDelta = "delta" // an iot.DeltaContentType batch, even for single readings
+)
+
+// Encodings lists the payload encodings accepted by the IoT endpoints
+var Encodings = []string{EncodingJSON, EncodingDelta}
+
+// deltaPrecision quantizes delta-encoded values; the readings are generated
+// with two decimals so no precision is lost
+const deltaPrecision = 0.01
+
+// readingEpoch is the timestamp of the first reading of every client
+var readingEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// encodedRequest is the next request of a client in an encoding test
+type encodedRequest struct {
+	path        string
+	contentType string
+	body        []byte
+	readings    int
+	encode      time.Duration
+}
+
+// benchReading returns reading seq of a client: a slowly varying temperature
+// sampled once a second
+func benchReading(clientID, seq int) iot.SensorData {
+	value := 21 + 4*math.Sin(float64(seq)/20) + float64(seq%7)*0.03
+	return iot.SensorData{
+		DeviceID:   fmt.Sprintf("bench_device_%d", clientID),
+		SensorType: "temperature",
+		Value:      math.Round(value*100) / 100,
+		Unit:       "celsius",
+		Timestamp:  readingEpoch.Add(time.Duration(seq) * time.Second),
+		Quality:    "reliable",
+	}
+}
+
+// nextEncoded encodes the next readings of a client. Only the client's own
+// goroutine calls it, so its sequence needs no lock.
+func (b *Benchmarker) nextEncoded(clientID int) (encodedRequest, error) {
+	n := max(b.config.BatchSize, 1)
+	seq := b.sequences[clientID]
+	b.sequences[clientID] += n
+
+	readings := make([]iot.SensorData, n)
+	for i := range readings {
+		readings[i] = benchReading(clientID, seq+i)
+	}
+
+	req := encodedRequest{path: "/iot/batch", contentType: "application/json", readings: n}
+	start := time.Now()
+	var err error
+	switch b.config.Encoding {
+	case EncodingJSON:
+		if n == 1 {
+			req.path = "/iot/sensor"
+			req.body, err = json.Marshal(readings[0])
+		} else {
+			req.body, err = json.Marshal(readings)
+		}
+	case EncodingDelta:
+		req.contentType = iot.DeltaContentType
+		req.body, err = iot.EncodeDelta(readings, deltaPrecision)
+	default:
+		err = fmt.Errorf("unknown encoding %q", b.config.Encoding)
+	}
+	req.encode = time.Since(start)
+	return req, err
+}
+
+// serverTiming returns the duration of metric in a Server-Timing header
+func serverTiming(h http.Header, metric string) (time.Duration, bool) {
+	for _, v := range h.Values("Server-Timing") {
+		for _, entry := range strings.Split(v, ",") {
+			params := strings.Split(entry, ";")
+			if strings.TrimSpace(params[0]) != metric {
+				continue
+			}
+			for _, p := range params[1:] {
+				name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if name != "dur" {
+					continue
+				}
+				ms, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return 0, false
+				}
+				return time.Duration(ms * float64(time.Millisecond)), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,114 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestServerTiming(t *testing.T) {
+	h := http.Header{}
+	h.Add("Server-Timing", "receive;dur=0.5, queue;desc=\"wait\";dur=2")
+	h.Add("Server-Timing", "decode;dur=1.250")
+
+	tests := []struct {
+		metric string
+		want   time.Duration
+		ok     bool
+	}{
+		{"decode", 1250 * time.Microsecond, true},
+		{"queue", 2 * time.Millisecond, true},
+		{"process", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := serverTiming(h, tt.metric); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.metric, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestEncodingsSendTheSameReadings checks that every encoding encodes the
+// same readings of a client, so that their results compare like with like
+func TestEncodingsSendTheSameReadings(t *testing.T) {
+	encoded := make(map[string]encodedRequest)
+	for _, encoding := range Encodings {
+		b := NewBenchmarker(TestConfig{TestType: TestTypeEncoding, Protocol: "tcp", Clients: 2, Encoding: encoding, BatchSize: 20}, logging.Nop())
+		b.nextEncoded(1)
+		req, err := b.nextEncoded(1)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if req.path != "/iot/batch" || req.readings != 20 {
+			t.Errorf("%s: %d readings to %s, want a batch of 20", encoding, req.readings, req.path)
+		}
+		encoded[encoding] = req
+	}
+
+	var fromJSON []iot.SensorData
+	if err := json.Unmarshal(encoded[EncodingJSON].body, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	fromDelta, err := iot.DecodeDelta(encoded[EncodingDelta].body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fromJSON) != 20 || len(fromDelta) != 20 {
+		t.Fatalf("decoded %d JSON and %d delta readings, want 20", len(fromJSON), len(fromDelta))
+	}
+	for i := range fromJSON {
+		j, d := fromJSON[i], fromDelta[i]
+		if j != benchReading(1, 20+i) || math.Abs(d.Value-j.Value) > 1e-9 || !d.Timestamp.Equal(j.Timestamp) || d.DeviceID != j.DeviceID {
+			t.Errorf("reading %d: JSON %+v and delta %+v, want the second batch of client 1", i, j, d)
+		}
+	}
+}
+
+// TestEncodingBenchmark runs every encoding against the IoT handler and
+// checks the per-reading results
+func TestEncodingBenchmark(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	server := httptest.NewServer(iot.NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg)))
+	defer server.Close()
+
+	bytesPerReading := make(map[string]float64)
+	for _, encoding := range Encodings {
+		for _, batch := range []int{1, 10} {
+			b := NewBenchmarker(TestConfig{TestType: TestTypeEncoding, Protocol: "tcp", Endpoint: server.URL, Clients: 1, Encoding: encoding, BatchSize: batch}, logging.Nop())
+			b.start = b.clock.Now()
+			for i := 0; i < 3; i++ {
+				if err := b.makeRequest(0); err != nil {
+					t.Fatalf("%s batch %d: %v", encoding, batch, err)
+				}
+			}
+			b.calculateResults(time.Second)
+
+			r := b.results
+			if r.SuccessRequests != 3 || b.readings != int64(3*batch) {
+				t.Errorf("%s batch %d: %d of %d requests succeeded with %d readings", encoding, batch, r.SuccessRequests, r.TotalRequests, b.readings)
+			}
+			if b.decoded != b.readings {
+				t.Errorf("%s batch %d: decode time of %d readings out of %d reported", encoding, batch, b.decoded, b.readings)
+			}
+			if r.Encoding != encoding || r.BatchSize != batch || r.BytesPerReading <= 0 {
+				t.Errorf("%s batch %d: got %s batch %d at %.1f bytes per reading", encoding, batch, r.Encoding, r.BatchSize, r.BytesPerReading)
+			}
+			if batch == 10 {
+				bytesPerReading[encoding] = r.BytesPerReading
+			}
+		}
+	}
+	if bytesPerReading[EncodingDelta] >= bytesPerReading[EncodingJSON] {
+		t.Errorf("delta batches take %.1f bytes per reading, JSON ones %.1f", bytesPerReading[EncodingDelta], bytesPerReading[EncodingJSON])
+	}
+}
@@ -0,0 +1,199 @@
+package benchmark
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// Fault types supported in a fault schedule
+const (
+	// FaultServerRestart takes the server's listener down through its admin API
+	FaultServerRestart = "server-restart"
+	// FaultBlackhole holds every request of the benchmark client, as if all
+	// packets were lost, until the window ends
+	FaultBlackhole = "blackhole"
+	// FaultAddressChange drops the client's pooled connections so the next
+	// requests come from new source addresses
+	FaultAddressChange = "address-change"
+)
+
+// Fault is one entry of a fault schedule
+type Fault struct {
+	At       time.Duration `json:"at" yaml:"at"`             // offset from the start of the test
+	Type     string        `json:"type" yaml:"type"`         // one of the Fault* constants
+	Duration time.Duration `json:"duration" yaml:"duration"` // length of the outage, unused for address-change
+}
+
+// FaultWindow records when a fault was actually injected. Offsets are from
+// the start of the test, like the Timeline seconds.
+type FaultWindow struct {
+	Type          string    `json:"type"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	StartOffsetMs int64     `json:"start_offset_ms"`
+	EndOffsetMs   int64     `json:"end_offset_ms"`
+	Error         string    `json:"error,omitempty"` // set if injection failed
+}
+
+// Validate checks that f can be injected
+func (f Fault) Validate() error {
+	if f.At < 0 {
+		return fmt.Errorf("fault at %v: offset must not be negative", f.At)
+	}
+	switch f.Type {
+	case FaultServerRestart, FaultBlackhole:
+		if f.Duration <= 0 {
+			return fmt.Errorf("%s fault at %v: duration must be positive", f.Type, f.At)
+		}
+	case FaultAddressChange:
+	default:
+		return fmt.Errorf("fault at %v: unknown type %q (want %s, %s or %s)",
+			f.At, f.Type, FaultServerRestart, FaultBlackhole, FaultAddressChange)
+	}
+	return nil
+}
+
+// LoadFaults reads a fault schedule from a YAML file of the form
+//
+//	faults:
+//	  - at: 15s
+//	    type: server-restart
+//	    duration: 3s
+func LoadFaults(path string) ([]Fault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var schedule struct {
+		Faults []Fault `yaml:"faults"`
+	}
+	if err := yaml.Unmarshal(data, &schedule); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	for _, f := range schedule.Faults {
+		if err := f.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	sort.SliceStable(schedule.Faults, func(i, j int) bool { return schedule.Faults[i].At < schedule.Faults[j].At })
+	return schedule.Faults, nil
+}
+
+// blackholeTransport holds requests while a blackhole fault is active
+type blackholeTransport struct {
+	next  http.RoundTripper
+	clock clock.Clock
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// hold blackholes requests until the given time
+func (t *blackholeTransport) hold(until time.Time) {
+	t.mu.Lock()
+	if until.After(t.until) {
+		t.until = until
+	}
+	t.mu.Unlock()
+}
+
+func (t *blackholeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	wait := t.until.Sub(t.clock.Now())
+	t.mu.Unlock()
+
+	if wait > 0 {
+		select {
+		case <-t.clock.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// runFaults injects the schedule relative to start until done is closed
+func (b *Benchmarker) runFaults(done <-chan struct{}, start time.Time) {
+	for _, f := range b.config.Faults {
+		wait := start.Add(f.At).Sub(b.clock.Now())
+		if wait > 0 {
+			select {
+			case <-b.clock.After(wait):
+			case <-done:
+				return
+			}
+		}
+
+		window := b.injectFault(f)
+		window.StartOffsetMs = window.Start.Sub(start).Milliseconds()
+		window.EndOffsetMs = window.End.Sub(start).Milliseconds()
+
+		b.mutex.Lock()
+		b.results.Faults = append(b.results.Faults, window)
+		b.mutex.Unlock()
+	}
+}
+
+func (b *Benchmarker) injectFault(f Fault) FaultWindow {
+	now := b.clock.Now()
+	window := FaultWindow{Type: f.Type, Start: now, End: now.Add(f.Duration)}
+	log := b.logger.With(logging.F("fault", f.Type), logging.F("duration", f.Duration))
+
+	var err error
+	switch f.Type {
+	case FaultServerRestart:
+		err = b.restartServer(f.Duration)
+	case FaultBlackhole:
+		b.blackhole.hold(window.End)
+	case FaultAddressChange:
+		window.End = now
+		b.transport.CloseIdleConnections()
+	}
+
+	if err != nil {
+		log.Warn("Fault injection failed", logging.Err(err))
+		window.Error = err.Error()
+		window.End = window.Start
+		return window
+	}
+	log.Info("Fault injected")
+	return window
+}
+
+// restartServer asks the server's admin API to take its listener down
+func (b *Benchmarker) restartServer(down time.Duration) error {
+	if b.config.AdminURL == "" {
+		return fmt.Errorf("no admin URL configured for %s", b.config.Protocol)
+	}
+
+	url := fmt.Sprintf("%s/debug/restart?down=%s", strings.TrimSuffix(b.config.AdminURL, "/"), down)
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	if b.config.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+b.config.AdminToken)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("admin API returned status %d (is admin.debug enabled?)", resp.StatusCode)
+	}
+	return nil
+}
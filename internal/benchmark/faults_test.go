@@ -0,0 +1,138 @@
+package benchmark
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestLoadFaults(t *testing.T) {
+	load := func(schedule string) ([]Fault, error) {
+		path := filepath.Join(t.TempDir(), "faults.yaml")
+		if err := os.WriteFile(path, []byte(schedule), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return LoadFaults(path)
+	}
+
+	faults, err := load(`faults:
+  - at: 20s
+    type: blackhole
+    duration: 2s
+  - at: 5s
+    type: server-restart
+    duration: 3s
+  - at: 10s
+    type: address-change
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range faults {
+		got = append(got, f.At.String()+" "+f.Type)
+	}
+	if strings.Join(got, ", ") != "5s server-restart, 10s address-change, 20s blackhole" {
+		t.Errorf("schedule %v, want it sorted by offset", got)
+	}
+
+	for schedule, want := range map[string]string{
+		"faults:\n  - at: 1s\n    type: flood\n":           `unknown type "flood"`,
+		"faults:\n  - at: 1s\n    type: blackhole\n":       "duration must be positive",
+		"faults:\n  - at: -1s\n    type: address-change\n": "must not be negative",
+		"faults: [": "parse",
+	} {
+		if _, err := load(schedule); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", schedule, err, want)
+		}
+	}
+}
+
+func TestFaultsInjectedAndRecorded(t *testing.T) {
+	var restarts []string
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		restarts = append(restarts, r.URL.String()+" "+r.Header.Get("Authorization"))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer admin.Close()
+
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := NewBenchmarker(TestConfig{
+		Protocol:   "tcp",
+		AdminURL:   admin.URL + "/",
+		AdminToken: "secret",
+		Faults: []Fault{
+			{Type: FaultServerRestart, Duration: 3 * time.Second},
+			{Type: FaultAddressChange},
+			{Type: FaultBlackhole, Duration: 2 * time.Second},
+		},
+	}, logging.Nop(), WithClock(c))
+	// Every fault is due when the schedule starts
+	b.runFaults(make(chan struct{}), c.Now())
+
+	if len(restarts) != 1 || restarts[0] != "/debug/restart?down=3s Bearer secret" {
+		t.Errorf("admin API called with %v", restarts)
+	}
+	want := []struct {
+		kind string
+		end  int64
+	}{{FaultServerRestart, 3000}, {FaultAddressChange, 0}, {FaultBlackhole, 2000}}
+	if len(b.results.Faults) != len(want) {
+		t.Fatalf("%d fault windows, want %d", len(b.results.Faults), len(want))
+	}
+	for i, w := range b.results.Faults {
+		if w.Type != want[i].kind || w.StartOffsetMs != 0 || w.EndOffsetMs != want[i].end || w.Error != "" {
+			t.Errorf("window %d: %+v, want %s ending at %dms", i, w, want[i].kind, want[i].end)
+		}
+	}
+
+	// The blackhole holds requests until its window ends
+	b.blackhole.next = roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.blackhole.RoundTrip(httptest.NewRequest(http.MethodGet, "http://server/ping", nil))
+	}()
+	select {
+	case <-done:
+		t.Fatal("request sent during the blackhole")
+	case <-time.After(20 * time.Millisecond):
+	}
+	for held := true; held; {
+		c.Advance(time.Second)
+		select {
+		case <-done:
+			held = false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if c.Now().Before(b.results.Faults[2].End) {
+		t.Errorf("request released at %v, before the blackhole ended at %v", c.Now(), b.results.Faults[2].End)
+	}
+}
+
+func TestFailedFaultHasEmptyWindow(t *testing.T) {
+	admin := httptest.NewServer(http.NotFoundHandler())
+	defer admin.Close()
+
+	for url, want := range map[string]string{"": "no admin URL", admin.URL: "is admin.debug enabled?"} {
+		b := NewBenchmarker(TestConfig{Protocol: "quic", AdminURL: url}, logging.Nop())
+		w := b.injectFault(Fault{Type: FaultServerRestart, Duration: time.Second})
+		if !strings.Contains(w.Error, want) || !w.End.Equal(w.Start) {
+			t.Errorf("admin URL %q: window %+v, want error %q and no outage", url, w, want)
+		}
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
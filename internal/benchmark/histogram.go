@@ -0,0 +1,70 @@
+package benchmark
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// LatencyBucketsMs are the fixed upper bounds of the latency histogram in
+// milliseconds. They are the same for every test so results from different
+// runs and network conditions can be compared bucket by bucket.
+var LatencyBucketsMs = []float64{
+	0.1, 0.2, 0.5,
+	1, 2, 5,
+	10, 20, 50,
+	100, 200, 500,
+	1000, 2000, 5000,
+	10000,
+}
+
+// HistogramBucket is one exported histogram bucket. The last bucket of a
+// histogram has an infinite upper bound, encoded as 0 with Overflow set.
+type HistogramBucket struct {
+	UpperMs  float64 `json:"le_ms"`
+	Overflow bool    `json:"overflow,omitempty"`
+	Count    int64   `json:"count"`
+}
+
+// Histogram counts latencies into LatencyBucketsMs
+type Histogram struct {
+	counts []int64 // one per bound plus the overflow bucket
+}
+
+// NewHistogram creates an empty histogram
+func NewHistogram() *Histogram {
+	return &Histogram{counts: make([]int64, len(LatencyBucketsMs)+1)}
+}
+
+// Record adds one latency in milliseconds
+func (h *Histogram) Record(ms float64) {
+	h.counts[sort.SearchFloat64s(LatencyBucketsMs, ms)]++
+}
+
+// Buckets exports the histogram, including empty buckets
+func (h *Histogram) Buckets() []HistogramBucket {
+	buckets := make([]HistogramBucket, len(h.counts))
+	for i, c := range h.counts {
+		if i < len(LatencyBucketsMs) {
+			buckets[i] = HistogramBucket{UpperMs: LatencyBucketsMs[i], Count: c}
+		} else {
+			buckets[i] = HistogramBucket{Overflow: true, Count: c}
+		}
+	}
+	return buckets
+}
+
+// Label formats the bucket range for reports, e.g. "≤ 5ms" or "> 10s"
+func (b HistogramBucket) Label() string {
+	if b.Overflow {
+		return "> " + formatMs(LatencyBucketsMs[len(LatencyBucketsMs)-1])
+	}
+	return "≤ " + formatMs(b.UpperMs)
+}
+
+func formatMs(ms float64) string {
+	if ms >= 1000 {
+		return fmt.Sprintf("%gs", ms/1000)
+	}
+	return strconv.FormatFloat(ms, 'g', -1, 64) + "ms"
+}
@@ -0,0 +1,114 @@
+package benchmark
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// ObservedLoss is the reading loss of an IoT test as seen by the server.
+// Every client sends as one device and numbers its readings, and the
+// server's sequence counters tell which ones it accepted. A reading the
+// server refused, e.g. over a quota, counts as lost.
+type ObservedLoss struct {
+	Sent        int64   `json:"sent"`
+	Received    int64   `json:"received"`
+	Missing     int64   `json:"missing"` // gaps between received readings
+	Duplicates  int64   `json:"duplicates"`
+	OutOfOrder  int64   `json:"out_of_order"`
+	LossPercent float64 `json:"loss_percent"` // sent readings never received
+	Error       string  `json:"error,omitempty"`
+}
+
+// benchDevice is the device ID of an IoT test client
+func benchDevice(clientID int) string {
+	return fmt.Sprintf("bench_device_%d", clientID)
+}
+
+// iotPayload builds the next reading of an IoT test client
+func (b *Benchmarker) iotPayload(clientID int) []byte {
+	b.iotSeqs[clientID]++
+	payload, _ := json.Marshal(iot.SensorData{
+		DeviceID:   benchDevice(clientID),
+		SensorType: "temperature",
+		Value:      25.5,
+		Unit:       "celsius",
+		Timestamp:  b.clock.Now(),
+		Quality:    "reliable",
+		Seq:        b.iotSeqs[clientID],
+		Epoch:      b.iotEpoch,
+	})
+	return payload
+}
+
+// observeLoss collects the sequence counters of the test's devices from
+// the server's admin API
+func (b *Benchmarker) observeLoss(ctx context.Context) *ObservedLoss {
+	loss := &ObservedLoss{}
+	for _, n := range b.iotSeqs {
+		loss.Sent += int64(n)
+	}
+	if b.config.AdminURL == "" {
+		loss.Error = "no admin URL configured for " + b.config.Protocol
+		return loss
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	for i := range b.iotSeqs {
+		stats, found, err := b.sequenceStats(ctx, client, benchDevice(i))
+		if err != nil {
+			loss.Error = err.Error()
+			b.logger.Warn("Failed to fetch reading sequences", logging.Err(err))
+			return loss
+		}
+		if !found || stats.Epoch != b.iotEpoch {
+			// None of the client's readings arrived
+			continue
+		}
+		loss.Received += stats.Received
+		loss.Missing += stats.Missing
+		loss.Duplicates += stats.Duplicates
+		loss.OutOfOrder += stats.OutOfOrder
+	}
+	if loss.Sent > 0 {
+		lost := max(loss.Sent-(loss.Received-loss.Duplicates), 0)
+		loss.LossPercent = float64(lost) / float64(loss.Sent) * 100
+	}
+	return loss
+}
+
+// sequenceStats fetches the sequence counters of deviceID
+func (b *Benchmarker) sequenceStats(ctx context.Context, client *http.Client, deviceID string) (iot.SequenceStats, bool, error) {
+	var stats iot.SequenceStats
+	url := fmt.Sprintf("%s/api/sequences/%s", strings.TrimSuffix(b.config.AdminURL, "/"), deviceID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return stats, false, err
+	}
+	if b.config.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+b.config.AdminToken)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return stats, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+			return stats, false, fmt.Errorf("invalid sequence stats: %w", err)
+		}
+		return stats, true, nil
+	case http.StatusNotFound:
+		return stats, false, nil
+	default:
+		return stats, false, fmt.Errorf("admin API returned status %d", resp.StatusCode)
+	}
+}
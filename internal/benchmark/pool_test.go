@@ -0,0 +1,72 @@
+package benchmark
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	var opened atomic.Int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			opened.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		cfg       TestConfig
+		clients   int
+		wantConns int64 // opened at most
+		wantReuse bool
+	}{
+		{"pooled", TestConfig{}, 1, 1, true},
+		{"new connection per request", TestConfig{NewConnPerRequest: true}, 1, 8, false},
+		{"pool of two", TestConfig{MaxConnsPerHost: 2}, 4, 2, true},
+	}
+	for _, tt := range tests {
+		opened.Store(0)
+		cfg := tt.cfg
+		cfg.Protocol, cfg.Endpoint, cfg.TestType, cfg.RequestSize = "tcp", server.URL, "latency", 16
+		b := NewBenchmarker(cfg, logging.Nop())
+		b.start = b.clock.Now()
+
+		var wg sync.WaitGroup
+		for c := 0; c < tt.clients; c++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 8/tt.clients; i++ {
+					if err := b.makeRequest(c); err != nil {
+						t.Errorf("%s: %v", tt.name, err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		b.calculateResults(time.Second)
+
+		r := b.results
+		if r.Connections+r.ReusedConns != 8 || r.Connections != opened.Load() {
+			t.Errorf("%s: %d connections and %d reused, want 8 requests on the %d opened", tt.name, r.Connections, r.ReusedConns, opened.Load())
+		}
+		if r.Connections > tt.wantConns || (r.ReusedConns > 0) != tt.wantReuse {
+			t.Errorf("%s: %d connections, %d reused, want at most %d", tt.name, r.Connections, r.ReusedConns, tt.wantConns)
+		}
+		if want := 8 / float64(r.Connections); r.RequestsPerConn != want {
+			t.Errorf("%s: %.2f requests per connection, want %.2f", tt.name, r.RequestsPerConn, want)
+		}
+	}
+}
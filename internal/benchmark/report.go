@@ -0,0 +1,195 @@
+package benchmark
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+)
+
+// heatmapCell is one histogram bucket of one condition
+type heatmapCell struct {
+	Count    int64
+	Fraction float64 // of the condition's requests
+}
+
+// heatmapRow is one latency bucket across conditions
+type heatmapRow struct {
+	Label string
+	Cells []heatmapCell
+}
+
+// heatmap is the latency distribution of one protocol across conditions
+type heatmap struct {
+	Protocol   string
+	TestType   string
+	Conditions []string
+	Rows       []heatmapRow // slowest bucket first
+}
+
+// encodingRanking orders the encoding test results of one network condition
+// and batch size, best first
+type encodingRanking struct {
+	Condition string
+	BatchSize int
+	Results   []TestResult
+}
+
+// reportData is the content of the HTML report
+type reportData struct {
+	Heatmaps []heatmap
+	Rankings []encodingRanking
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"shade": func(f float64) template.CSS {
+		return template.CSS(fmt.Sprintf("background: rgba(200, 40, 40, %.3f)", f))
+	},
+	"percent": func(f float64) string {
+		return fmt.Sprintf("%.1f%%", f*100)
+	},
+	"inc": func(i int) int {
+		return i + 1
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Latency distribution by network condition</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ddd; padding: 4px 10px; text-align: right; font-size: 13px; }
+th { background: #f4f4f4; }
+</style>
+</head>
+<body>
+<h1>Latency distribution by network condition</h1>
+{{range .Heatmaps}}
+<h2>{{.Protocol}} ({{.TestType}})</h2>
+<table>
+<tr><th>latency</th>{{range .Conditions}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th>{{.Label}}</th>{{range .Cells}}<td style="{{shade .Fraction}}" title="{{.Count}} requests">{{if .Count}}{{percent .Fraction}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+{{if .Rankings}}<h1>IoT payload encodings by network condition</h1>
+{{range .Rankings}}
+<h2>{{.Condition}}, {{if eq .BatchSize 1}}single readings{{else}}batches of {{.BatchSize}} readings{{end}}</h2>
+<table>
+<tr><th>rank</th><th>protocol</th><th>encoding</th><th>p99 latency</th><th>bytes/reading</th><th>client encode/reading</th><th>server decode/reading</th><th>readings</th><th>failed requests</th></tr>
+{{range $i, $r := .Results}}<tr><td>{{inc $i}}</td><td>{{$r.Protocol}}</td><td>{{$r.Encoding}}</td><td>{{printf "%.2f ms" $r.P99Latency}}</td><td>{{printf "%.1f" $r.BytesPerReading}}</td><td>{{printf "%.2f µs" $r.ClientEncodeUs}}</td><td>{{printf "%.2f µs" $r.ServerDecodeUs}}</td><td>{{$r.Readings}}</td><td>{{$r.FailedRequests}}</td></tr>
+{{end}}</table>
+{{end}}{{end}}
+</body>
+</html>
+`))
+
+// WriteHeatmapReport renders an HTML heatmap of the latency histograms in
+// results, one table per protocol and test type with a column per network
+// condition. Results without a histogram are skipped. Encoding test results
+// are also ranked per network condition and batch size.
+func WriteHeatmapReport(w io.Writer, results []TestResult) error {
+	return reportTemplate.Execute(w, reportData{
+		Heatmaps: buildHeatmaps(results),
+		Rankings: rankEncodings(results),
+	})
+}
+
+func buildHeatmaps(results []TestResult) []heatmap {
+	var maps []*heatmap
+	index := make(map[string]*heatmap)
+
+	for _, r := range results {
+		if len(r.LatencyHistogram) == 0 {
+			continue
+		}
+		testType := r.TestType
+		if r.TestType == TestTypeEncoding {
+			testType = fmt.Sprintf("%s %s x%d", r.TestType, r.Encoding, r.BatchSize)
+		}
+		if r.Congestion != "" {
+			testType += ", " + r.Congestion
+		}
+		if r.QUICWindow > 0 {
+			testType += fmt.Sprintf(", %d KB window", r.QUICWindow>>10)
+		}
+		key := r.Protocol + "/" + testType
+		hm, ok := index[key]
+		if !ok {
+			hm = &heatmap{Protocol: r.Protocol, TestType: testType}
+			for i := len(r.LatencyHistogram) - 1; i >= 0; i-- {
+				hm.Rows = append(hm.Rows, heatmapRow{Label: r.LatencyHistogram[i].Label()})
+			}
+			index[key] = hm
+			maps = append(maps, hm)
+		}
+		if len(r.LatencyHistogram) != len(hm.Rows) {
+			continue // recorded with different bucket boundaries
+		}
+
+		condition := r.Condition
+		if condition == "" {
+			condition = "default"
+		}
+		hm.Conditions = append(hm.Conditions, condition)
+
+		var total int64
+		for _, b := range r.LatencyHistogram {
+			total += b.Count
+		}
+		for i := range hm.Rows {
+			b := r.LatencyHistogram[len(r.LatencyHistogram)-1-i]
+			cell := heatmapCell{Count: b.Count}
+			if total > 0 {
+				cell.Fraction = float64(b.Count) / float64(total)
+			}
+			hm.Rows[i].Cells = append(hm.Rows[i].Cells, cell)
+		}
+	}
+
+	out := make([]heatmap, len(maps))
+	for i, hm := range maps {
+		out[i] = *hm
+	}
+	return out
+}
+
+// rankEncodings groups encoding test results by network condition and batch
+// size and orders each group by p99 latency, then bytes per reading. Results
+// that delivered no readings come last.
+func rankEncodings(results []TestResult) []encodingRanking {
+	var rankings []encodingRanking
+	index := make(map[string]int)
+
+	for _, r := range results {
+		if r.TestType != TestTypeEncoding {
+			continue
+		}
+		condition := r.Condition
+		if condition == "" {
+			condition = "default"
+		}
+		key := fmt.Sprintf("%s/%d", condition, r.BatchSize)
+		i, ok := index[key]
+		if !ok {
+			i = len(rankings)
+			index[key] = i
+			rankings = append(rankings, encodingRanking{Condition: condition, BatchSize: r.BatchSize})
+		}
+		rankings[i].Results = append(rankings[i].Results, r)
+	}
+
+	for _, rk := range rankings {
+		sort.SliceStable(rk.Results, func(i, j int) bool {
+			a, b := rk.Results[i], rk.Results[j]
+			if (a.Readings == 0) != (b.Readings == 0) {
+				return b.Readings == 0
+			}
+			if a.P99Latency != b.P99Latency {
+				return a.P99Latency < b.P99Latency
+			}
+			return a.BytesPerReading < b.BytesPerReading
+		})
+	}
+	return rankings
+}
@@ -0,0 +1,79 @@
+package benchmark
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	h := NewHistogram()
+	for _, ms := range []float64{0.05, 0.1, 0.15, 7, 10000, 20000} {
+		h.Record(ms)
+	}
+	buckets := h.Buckets()
+	if len(buckets) != len(LatencyBucketsMs)+1 {
+		t.Fatalf("%d buckets, want one per bound and the overflow", len(buckets))
+	}
+	// Bounds are inclusive
+	want := map[string]int64{"≤ 0.1ms": 2, "≤ 0.2ms": 1, "≤ 10ms": 1, "≤ 10s": 1, "> 10s": 1}
+	for _, b := range buckets {
+		if b.Count != want[b.Label()] {
+			t.Errorf("bucket %s: %d latencies, want %d", b.Label(), b.Count, want[b.Label()])
+		}
+	}
+	if last := buckets[len(buckets)-1]; !last.Overflow || last.UpperMs != 0 {
+		t.Errorf("last bucket %+v, want the overflow", last)
+	}
+}
+
+func TestHeatmapColumnPerCondition(t *testing.T) {
+	result := func(protocol, condition string, latencies ...float64) TestResult {
+		h := NewHistogram()
+		for _, ms := range latencies {
+			h.Record(ms)
+		}
+		return TestResult{Protocol: protocol, TestType: "iot", Condition: condition, LatencyHistogram: h.Buckets()}
+	}
+	results := []TestResult{
+		result("quic", "", 1, 1, 1, 40),
+		result("tcp", "lossy-2pct", 3),
+		{Protocol: "quic", TestType: "iot", Condition: "no-histogram"},
+		result("quic", "lossy-2pct", 40, 40),
+	}
+
+	maps := buildHeatmaps(results)
+	if len(maps) != 2 || maps[0].Protocol != "quic" || maps[1].Protocol != "tcp" {
+		t.Fatalf("heatmaps %+v, want quic then tcp", maps)
+	}
+	quic := maps[0]
+	if strings.Join(quic.Conditions, ",") != "default,lossy-2pct" {
+		t.Errorf("conditions %v, want default and lossy-2pct", quic.Conditions)
+	}
+	if quic.Rows[0].Label != "> 10s" || quic.Rows[len(quic.Rows)-1].Label != "≤ 0.1ms" {
+		t.Errorf("rows from %s to %s, want the slowest first", quic.Rows[0].Label, quic.Rows[len(quic.Rows)-1].Label)
+	}
+	for _, row := range quic.Rows {
+		var want []heatmapCell
+		switch row.Label {
+		case "≤ 1ms":
+			want = []heatmapCell{{3, 0.75}, {0, 0}}
+		case "≤ 50ms":
+			want = []heatmapCell{{1, 0.25}, {2, 1}}
+		default:
+			want = []heatmapCell{{}, {}}
+		}
+		if len(row.Cells) != 2 || row.Cells[0] != want[0] || row.Cells[1] != want[1] {
+			t.Errorf("row %s: %+v, want %+v", row.Label, row.Cells, want)
+		}
+	}
+
+	var out strings.Builder
+	if err := WriteHeatmapReport(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"<h2>quic (iot)</h2>", "<th>lossy-2pct</th>", "75.0%"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("report is missing %q", s)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package benchmark
+
+import (
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// WindowConfig returns the client receive windows of a window sweep: every
+// stream starts and stays at window, the connection allows one and a half
+// streams so a single transfer is limited by the stream window alone
+func WindowConfig(window uint64) config.QUICConfig {
+	return config.QUICConfig{
+		InitialStreamWindow:     window,
+		MaxStreamWindow:         window,
+		InitialConnectionWindow: window * 3 / 2,
+		MaxConnectionWindow:     window * 3 / 2,
+	}
+}
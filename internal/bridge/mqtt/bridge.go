@@ -0,0 +1,303 @@
+// Package mqtt bridges the IoT handler to an MQTT broker: accepted sensor
+// readings are published to the broker, and commands published to the
+// broker are queued for their devices.
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// connectTimeout bounds a connection attempt, and publishTimeout the
+	// wait for the broker to acknowledge a message
+	connectTimeout = 10 * time.Second
+	publishTimeout = 10 * time.Second
+
+	// flushBatch is the most buffered messages published before waiting
+	// for their acknowledgements
+	flushBatch = 100
+)
+
+// topicEscaper keeps device IDs and sensor types within one topic level
+var topicEscaper = strings.NewReplacer("/", "_", "+", "_", "#", "_")
+
+// message is a reading waiting to be published
+type message struct {
+	seq     uint64 // order in which readings were buffered
+	topic   string
+	payload []byte
+}
+
+// Bridge publishes readings to an MQTT broker and queues the commands it
+// receives in an outbox. Readings are buffered while the broker is
+// unreachable, dropping the oldest once the buffer is full, and sent in
+// order once it is reachable again.
+type Bridge struct {
+	cfg    config.MQTTBridgeConfig
+	logger logging.Logger
+	client paho.Client
+	outbox *iot.Outbox   // nil takes no commands
+	lost   chan struct{} // signalled when the connection is lost
+
+	mu    sync.Mutex
+	ring  []message
+	start int // index of the oldest message
+	n     int
+	seq   uint64
+	wake  chan struct{} // signalled when a message is buffered
+
+	published prometheus.Counter
+	dropped   prometheus.Counter
+	buffered  prometheus.Gauge
+	connected prometheus.Gauge
+	commands  *metrics.CounterVec
+}
+
+// New creates a bridge to the broker of cfg. Commands are queued in outbox
+// if it isn't nil and cfg has a command topic. The bridge connects once
+// Run is called.
+func New(cfg config.MQTTBridgeConfig, outbox *iot.Outbox, logger logging.Logger, reg *metrics.Registry) *Bridge {
+	b := &Bridge{
+		cfg:       cfg,
+		logger:    logger,
+		outbox:    outbox,
+		lost:      make(chan struct{}, 1),
+		ring:      make([]message, cfg.BufferSize),
+		wake:      make(chan struct{}, 1),
+		published: reg.Counter("mqtt", "published_total", "Sensor readings published to the MQTT broker"),
+		dropped:   reg.Counter("mqtt", "dropped_total", "Sensor readings dropped from a full buffer while the MQTT broker was unreachable"),
+		buffered:  reg.Gauge("mqtt", "buffered", "Sensor readings waiting to be published to the MQTT broker"),
+		connected: reg.Gauge("mqtt", "connected", "Whether the bridge is connected to the MQTT broker"),
+		commands:  reg.CounterVec("mqtt", "commands_total", "Commands received from the MQTT broker by outcome", "outcome"),
+	}
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(false). // Run reconnects, with backoff
+		SetConnectTimeout(connectTimeout).
+		SetOrderMatters(false).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			b.connected.Set(0)
+			b.logger.Warn("Lost connection to MQTT broker", logging.Err(err))
+			select {
+			case b.lost <- struct{}{}:
+			default:
+			}
+		})
+	b.client = paho.NewClient(opts)
+	return b
+}
+
+// Publish buffers a reading for the broker. It never blocks.
+func (b *Bridge) Publish(data iot.SensorData) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	topic := strings.NewReplacer(
+		"{device_id}", topicEscaper.Replace(data.DeviceID),
+		"{type}", topicEscaper.Replace(data.SensorType),
+	).Replace(b.cfg.Topic)
+
+	b.mu.Lock()
+	if b.n == len(b.ring) {
+		b.start = (b.start + 1) % len(b.ring)
+		b.n--
+		b.dropped.Inc()
+	}
+	b.seq++
+	b.ring[(b.start+b.n)%len(b.ring)] = message{b.seq, topic, payload}
+	b.n++
+	b.buffered.Set(float64(b.n))
+	b.mu.Unlock()
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run connects to the broker and publishes buffered readings until ctx is
+// done. A failed or lost connection is retried with exponential backoff up
+// to cfg.MaxReconnectInterval.
+func (b *Bridge) Run(ctx context.Context) {
+	defer b.client.Disconnect(250)
+	attempt := 0
+	for {
+		if !b.client.IsConnectionOpen() {
+			if err := b.dial(); err != nil {
+				delay := b.backoff(attempt)
+				attempt++
+				b.logger.Warn("MQTT broker unreachable", logging.Err(err), logging.F("attempt", attempt), logging.F("retry_in", delay.Round(time.Millisecond)))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+			b.logger.Info("Connected to MQTT broker", logging.F("broker", b.cfg.Broker), logging.F("attempts", attempt+1))
+			attempt = 0
+		}
+		b.flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.wake:
+		case <-b.lost:
+		}
+	}
+}
+
+// dial connects to the broker and subscribes to the command topic
+func (b *Bridge) dial() error {
+	token := b.client.Connect()
+	if !token.WaitTimeout(connectTimeout) {
+		return errors.New("MQTT connect timed out")
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+	b.connected.Set(1)
+	if b.outbox == nil || b.cfg.CommandTopic == "" {
+		return nil
+	}
+	filter := strings.ReplaceAll(b.cfg.CommandTopic, "{device_id}", "+")
+	token = b.client.Subscribe(filter, byte(b.cfg.QoS), b.handleCommand)
+	if !token.WaitTimeout(connectTimeout) {
+		b.client.Disconnect(0)
+		return errors.New("MQTT subscribe timed out")
+	}
+	if err := token.Error(); err != nil {
+		b.client.Disconnect(0)
+		return err
+	}
+	return nil
+}
+
+// flush publishes buffered readings, oldest first, until the buffer is
+// empty or the broker fails to acknowledge one. Unacknowledged readings
+// stay buffered for the next attempt.
+func (b *Bridge) flush() {
+	for {
+		b.mu.Lock()
+		batch := make([]message, 0, min(b.n, flushBatch))
+		for i := 0; i < cap(batch); i++ {
+			batch = append(batch, b.ring[(b.start+i)%len(b.ring)])
+		}
+		b.mu.Unlock()
+		if len(batch) == 0 {
+			return
+		}
+
+		tokens := make([]paho.Token, len(batch))
+		for i, m := range batch {
+			tokens[i] = b.client.Publish(m.topic, byte(b.cfg.QoS), false, m.payload)
+		}
+		sent := 0
+		for _, token := range tokens {
+			if !token.WaitTimeout(publishTimeout) || token.Error() != nil {
+				break
+			}
+			sent++
+		}
+		if sent > 0 {
+			b.discard(batch[sent-1].seq, sent)
+		}
+		if sent < len(batch) {
+			b.logger.Warn("MQTT publish failed, keeping readings buffered", logging.F("buffered", b.len()))
+			return
+		}
+	}
+}
+
+// discard removes the sent messages, up to seq, from the front of the
+// buffer. A full buffer may have dropped some of them meanwhile.
+func (b *Bridge) discard(seq uint64, sent int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for b.n > 0 && b.ring[b.start].seq <= seq {
+		b.ring[b.start] = message{}
+		b.start = (b.start + 1) % len(b.ring)
+		b.n--
+	}
+	b.published.Add(float64(sent))
+	b.buffered.Set(float64(b.n))
+}
+
+func (b *Bridge) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.n
+}
+
+// handleCommand queues a command published to the command topic. The
+// device ID comes from the topic, or from the command when the topic has
+// no {device_id} level; a command naming another device is refused.
+func (b *Bridge) handleCommand(_ paho.Client, m paho.Message) {
+	var cmd iot.Command
+	if err := json.Unmarshal(m.Payload(), &cmd); err != nil {
+		b.commands.WithLabelValues("invalid").Inc()
+		b.logger.Warn("Invalid command from MQTT", logging.F("topic", m.Topic()), logging.Err(err))
+		return
+	}
+	if id := b.topicDevice(m.Topic()); id != "" {
+		if cmd.DeviceID != "" && cmd.DeviceID != id {
+			b.commands.WithLabelValues("invalid").Inc()
+			b.logger.Warn("Command device doesn't match its topic", logging.F("topic", m.Topic()), logging.F("device_id", cmd.DeviceID))
+			return
+		}
+		cmd.DeviceID = id
+	}
+	cmd, err := b.outbox.Enqueue(cmd)
+	if err != nil {
+		outcome := "rejected"
+		if errors.Is(err, iot.ErrUnsupportedCommand) {
+			outcome = "unsupported"
+		}
+		b.commands.WithLabelValues(outcome).Inc()
+		b.logger.Warn("Command from MQTT not queued", logging.F("device_id", cmd.DeviceID), logging.Err(err))
+		return
+	}
+	b.commands.WithLabelValues("queued").Inc()
+	b.logger.Debug("Command from MQTT queued", logging.F("device_id", cmd.DeviceID), logging.F("action", cmd.Action),
+		logging.F("command_id", cmd.ID))
+}
+
+// topicDevice returns the {device_id} level of a command topic, or "" if
+// the command topic has none
+func (b *Bridge) topicDevice(topic string) string {
+	levels := strings.Split(topic, "/")
+	for i, level := range strings.Split(b.cfg.CommandTopic, "/") {
+		if level == "{device_id}" && i < len(levels) {
+			return levels[i]
+		}
+	}
+	return ""
+}
+
+// backoff returns the wait before connection attempt n+1: doubling from a
+// second up to cfg.MaxReconnectInterval, with the upper half randomized
+func (b *Bridge) backoff(attempt int) time.Duration {
+	d := b.cfg.MaxReconnectInterval
+	if attempt < 16 {
+		d = min(time.Second<<attempt, d)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
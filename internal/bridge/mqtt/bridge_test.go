@@ -0,0 +1,305 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+var errBrokerDown = errors.New("broker down")
+
+// token is a paho.Token that has already completed
+type token struct{ err error }
+
+func (t token) Wait() bool                     { return true }
+func (t token) WaitTimeout(time.Duration) bool { return true }
+func (t token) Done() <-chan struct{}          { c := make(chan struct{}); close(c); return c }
+func (t token) Error() error                   { return t.err }
+
+// brokerMessage is a message published to or by the fake broker
+type brokerMessage struct {
+	paho.Message
+	topic   string
+	payload []byte
+}
+
+func (m brokerMessage) Topic() string   { return m.topic }
+func (m brokerMessage) Payload() []byte { return m.payload }
+
+// fakeBroker is an in-process broker behind the paho.Client interface. The
+// methods the bridge doesn't use panic through the nil embedded client.
+type fakeBroker struct {
+	paho.Client
+
+	mu        sync.Mutex
+	up        bool
+	connected bool
+	published []brokerMessage
+	handlers  map[string]paho.MessageHandler
+}
+
+func newFakeBroker(up bool) *fakeBroker {
+	return &fakeBroker{up: up, handlers: make(map[string]paho.MessageHandler)}
+}
+
+func (f *fakeBroker) IsConnectionOpen() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connected
+}
+
+func (f *fakeBroker) Connect() paho.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.up {
+		return token{errBrokerDown}
+	}
+	f.connected = true
+	return token{}
+}
+
+func (f *fakeBroker) Disconnect(uint) {
+	f.mu.Lock()
+	f.connected = false
+	f.mu.Unlock()
+}
+
+func (f *fakeBroker) Publish(topic string, _ byte, _ bool, payload interface{}) paho.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.up {
+		return token{errBrokerDown}
+	}
+	f.published = append(f.published, brokerMessage{topic: topic, payload: payload.([]byte)})
+	return token{}
+}
+
+func (f *fakeBroker) Subscribe(filter string, _ byte, handler paho.MessageHandler) paho.Token {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[filter] = handler
+	return token{}
+}
+
+// setUp takes the broker up or down; going down drops the connection
+func (f *fakeBroker) setUp(up bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.up = up
+	if !up {
+		f.connected = false
+	}
+}
+
+// send delivers a message published by another client to the subscriber
+// of filter
+func (f *fakeBroker) send(t *testing.T, filter, topic string, payload []byte) {
+	t.Helper()
+	f.mu.Lock()
+	handler := f.handlers[filter]
+	f.mu.Unlock()
+	if handler == nil {
+		t.Fatalf("no subscription to %s", filter)
+	}
+	handler(f, brokerMessage{topic: topic, payload: payload})
+}
+
+// values returns the values of the readings published, in order
+func (f *fakeBroker) values(t *testing.T) []float64 {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var values []float64
+	for _, m := range f.published {
+		var data iot.SensorData
+		if err := json.Unmarshal(m.payload, &data); err != nil {
+			t.Fatalf("payload on %s: %v", m.topic, err)
+		}
+		values = append(values, data.Value)
+	}
+	return values
+}
+
+func newTestBridge(cfg config.MQTTBridgeConfig, broker *fakeBroker, outbox *iot.Outbox) *Bridge {
+	b := New(cfg, outbox, logging.Nop(), metrics.NewRegistry())
+	b.client = broker
+	return b
+}
+
+func reading(device, sensorType string, value float64) iot.SensorData {
+	return iot.SensorData{DeviceID: device, SensorType: sensorType, Value: value, Unit: "C", Timestamp: time.Now()}
+}
+
+func TestPublishTopics(t *testing.T) {
+	broker := newFakeBroker(true)
+	b := newTestBridge(config.Default().Bridge.MQTT, broker, nil)
+	if err := b.dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	b.Publish(reading("dev1", "temperature", 21.5))
+	b.Publish(reading("floor/2#a", "humidity+raw", 40))
+	b.flush()
+
+	var topics []string
+	for _, m := range broker.published {
+		topics = append(topics, m.topic)
+	}
+	// Device IDs and types stay within their topic level
+	if want := []string{"iot/dev1/temperature", "iot/floor_2_a/humidity_raw"}; !slices.Equal(topics, want) {
+		t.Errorf("published to %v, want %v", topics, want)
+	}
+	if values := broker.values(t); !slices.Equal(values, []float64{21.5, 40}) {
+		t.Errorf("published values %v", values)
+	}
+	if b.len() != 0 {
+		t.Errorf("%d readings still buffered", b.len())
+	}
+}
+
+func TestCommandInjection(t *testing.T) {
+	cfg := config.Default()
+	broker := newFakeBroker(true)
+	outbox := iot.NewOutbox(cfg.IoT.Commands, logging.Nop(), nil, clock.Real(), metrics.NewRegistry())
+	b := newTestBridge(cfg.Bridge.MQTT, broker, outbox)
+	if err := b.dial(); err != nil {
+		t.Fatal(err)
+	}
+
+	filter := "iot/+/commands"
+	broker.send(t, filter, "iot/dev1/commands", []byte(`{"action": "reboot", "priority": "high"}`))
+	broker.send(t, filter, "iot/dev2/commands", []byte(`{"device_id": "dev2", "action": "calibrate"}`))
+	// Refused: another device than the topic's, and not JSON
+	broker.send(t, filter, "iot/dev3/commands", []byte(`{"device_id": "dev1", "action": "reboot"}`))
+	broker.send(t, filter, "iot/dev3/commands", []byte(`reboot`))
+
+	for device, action := range map[string]string{"dev1": "reboot", "dev2": "calibrate"} {
+		cmds := outbox.List(device, iot.CommandQueued)
+		if len(cmds) != 1 || cmds[0].Action != action {
+			t.Errorf("%s has commands %+v, want one %s", device, cmds, action)
+		}
+	}
+	if cmds := outbox.List("dev3", ""); len(cmds) != 0 {
+		t.Errorf("refused commands queued: %+v", cmds)
+	}
+}
+
+func TestBufferDuringOutage(t *testing.T) {
+	cfg := config.Default().Bridge.MQTT
+	cfg.BufferSize = 3
+	cfg.MaxReconnectInterval = 10 * time.Millisecond
+	broker := newFakeBroker(false)
+	b := newTestBridge(cfg, broker, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+
+	// The oldest readings are dropped once the buffer is full
+	for i := 1; i <= 5; i++ {
+		b.Publish(reading("dev1", "temperature", float64(i)))
+	}
+	time.Sleep(30 * time.Millisecond)
+	if n := b.len(); n != cfg.BufferSize {
+		t.Fatalf("%d readings buffered while the broker is down, want %d", n, cfg.BufferSize)
+	}
+
+	broker.setUp(true)
+	waitFor(t, func() bool { return len(broker.values(t)) == 3 })
+	if values := broker.values(t); !slices.Equal(values, []float64{3, 4, 5}) {
+		t.Errorf("published %v after the outage, want the newest readings in order", values)
+	}
+
+	// A lost connection is redialled and publishing goes on
+	broker.setUp(false)
+	b.lost <- struct{}{}
+	b.Publish(reading("dev1", "temperature", 6))
+	time.Sleep(30 * time.Millisecond)
+	broker.setUp(true)
+	waitFor(t, func() bool { return len(broker.values(t)) == 4 })
+
+	cancel()
+	<-done
+}
+
+func TestSubsystemStopKeepsReadingsBuffered(t *testing.T) {
+	broker := newFakeBroker(true)
+	sub := newTestBridge(config.Default().Bridge.MQTT, broker, nil).Subsystem()
+	ctx := context.Background()
+	if sub.Status().Running {
+		t.Fatal("bridge runs before Start")
+	}
+	if err := sub.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub.b.Publish(reading("dev1", "temperature", 1))
+	waitFor(t, func() bool { return len(broker.values(t)) == 1 })
+
+	if err := sub.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if broker.IsConnectionOpen() || sub.Status().Running {
+		t.Fatal("bridge still connected once stopped")
+	}
+	if err := sub.Stop(ctx); err == nil {
+		t.Error("stopped a stopped bridge")
+	}
+	sub.b.Publish(reading("dev1", "temperature", 2))
+	time.Sleep(10 * time.Millisecond)
+	if n := len(broker.values(t)); n != 1 {
+		t.Fatalf("%d readings published while stopped", n)
+	}
+
+	// Started again, it publishes what it buffered meanwhile
+	if err := sub.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Start(ctx); err == nil {
+		t.Error("started a running bridge")
+	}
+	waitFor(t, func() bool { return len(broker.values(t)) == 2 })
+	if status := sub.Status(); status.Name != "mqtt" || !status.Running {
+		t.Errorf("status %+v, want mqtt running", status)
+	}
+	sub.Stop(ctx)
+}
+
+func TestBackoff(t *testing.T) {
+	b := &Bridge{cfg: config.MQTTBridgeConfig{MaxReconnectInterval: 30 * time.Second}}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second} {
+		for i := 0; i < 100; i++ {
+			if d := b.backoff(attempt); d < max/2 || d > max {
+				t.Fatalf("attempt %d waits %v, want %v to %v", attempt, d, max/2, max)
+			}
+		}
+	}
+	if d := b.backoff(100); d > 30*time.Second {
+		t.Errorf("attempt 100 waits %v", d)
+	}
+}
+
+// waitFor fails the test if cond doesn't hold within a second
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met within a second")
+}
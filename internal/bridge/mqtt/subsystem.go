@@ -0,0 +1,87 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/admin"
+)
+
+// Subsystem runs the bridge, and lets the admin API stop and start it.
+// Readings published while it is stopped stay buffered until it runs again.
+type Subsystem struct {
+	b *Bridge
+
+	mu     sync.Mutex
+	cancel context.CancelFunc // nil while stopped
+	done   chan struct{}      // closed once Run has returned
+	since  time.Time
+}
+
+// Subsystem returns the bridge as the "mqtt" subsystem, stopped
+func (b *Bridge) Subsystem() *Subsystem {
+	return &Subsystem{b: b, since: time.Now()}
+}
+
+// Start runs the bridge in the background until Stop. ctx only bounds the
+// start itself.
+func (s *Subsystem) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return errors.New("bridge is already running")
+	}
+	if s.done != nil {
+		// Stopped before, but still disconnecting
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.b.Run(runCtx)
+	}()
+	s.cancel, s.done, s.since = cancel, done, time.Now()
+	return nil
+}
+
+// Stop disconnects from the broker, waiting until the bridge has or ctx is
+// done
+func (s *Subsystem) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	if s.cancel == nil {
+		s.mu.Unlock()
+		return errors.New("bridge is already stopped")
+	}
+	s.cancel()
+	s.cancel, s.since = nil, time.Now()
+	done := s.done
+	s.mu.Unlock()
+
+	s.b.logger.Warn("MQTT bridge stopped")
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Status reports whether the bridge runs, and its broker
+func (s *Subsystem) Status() admin.SubsystemStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return admin.SubsystemStatus{
+		Name:    "mqtt",
+		Running: s.cancel != nil,
+		Addr:    s.b.cfg.Broker,
+		Since:   s.since,
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// paceQuantum is the share of a second a paced read covers, so that a body
+// leaves in small, evenly spaced writes
+const paceQuantum = 20 * time.Millisecond
+
+// Pacer spreads request bodies over time at a byte rate. Bulk uploads of a
+// device then share a constrained link with latency-sensitive traffic, such
+// as video chunks, instead of arriving in one burst ahead of it.
+type Pacer struct {
+	rate    atomic.Int64 // bytes per second, 0 disables pacing
+	delayed atomic.Int64 // nanoseconds bodies were held back
+}
+
+// NewPacer creates a pacer sending bytesPerSec, 0 for unpaced bodies
+func NewPacer(bytesPerSec int64) *Pacer {
+	p := &Pacer{}
+	p.SetRate(bytesPerSec)
+	return p
+}
+
+// SetRate changes the rate of bodies created afterwards
+func (p *Pacer) SetRate(bytesPerSec int64) {
+	p.rate.Store(max(bytesPerSec, 0))
+}
+
+// Rate returns the current rate in bytes per second, 0 when disabled
+func (p *Pacer) Rate() int64 {
+	return p.rate.Load()
+}
+
+// Delayed returns how long paced bodies have been held back in total
+func (p *Pacer) Delayed() time.Duration {
+	return time.Duration(p.delayed.Load())
+}
+
+// Body wraps r so that reading it, and so sending it, takes at least its
+// size divided by the rate
+func (p *Pacer) Body(r io.Reader) io.Reader {
+	rate := p.Rate()
+	if rate == 0 {
+		return r
+	}
+	return &pacedReader{r: r, pacer: p, rate: rate, chunk: max(int(rate*int64(paceQuantum)/int64(time.Second)), 512)}
+}
+
+type pacedReader struct {
+	r     io.Reader
+	pacer *Pacer
+	rate  int64
+	chunk int
+
+	start time.Time
+	sent  int64
+}
+
+func (pr *pacedReader) Read(b []byte) (int, error) {
+	if pr.start.IsZero() {
+		pr.start = time.Now()
+	}
+	// Hold the read until the bytes already sent are due
+	due := pr.start.Add(time.Duration(pr.sent * int64(time.Second) / pr.rate))
+	if wait := time.Until(due); wait > 0 {
+		time.Sleep(wait)
+		pr.pacer.delayed.Add(int64(wait))
+	}
+	if len(b) > pr.chunk {
+		b = b[:pr.chunk]
+	}
+	n, err := pr.r.Read(b)
+	pr.sent += int64(n)
+	return n, err
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPacerHoldsBodyToRate(t *testing.T) {
+	const rate = 100_000 // 2000 bytes every 20ms
+	p := NewPacer(rate)
+	body := bytes.Repeat([]byte("0123456789"), 1000)
+
+	start := time.Now()
+	got, err := io.ReadAll(p.Body(bytes.NewReader(body)))
+	elapsed := time.Since(start)
+	if err != nil || !bytes.Equal(got, body) {
+		t.Fatalf("read %d of %d bytes, %v", len(got), len(body), err)
+	}
+	// The last 2000 bytes are due 80ms after the first
+	if elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Errorf("%d bytes at %d bytes/s took %v, want about 100ms", len(body), rate, elapsed)
+	}
+	// Sleeps that overrun leave later reads nothing to wait for, so only
+	// part of the time is counted
+	if p.Delayed() <= 0 || p.Delayed() > elapsed {
+		t.Errorf("body held back %v in total, want part of the %v", p.Delayed(), elapsed)
+	}
+}
+
+func TestPacerUnpaced(t *testing.T) {
+	p := NewPacer(-5)
+	if p.Rate() != 0 {
+		t.Errorf("rate %d, want a negative rate to disable pacing", p.Rate())
+	}
+	r := bytes.NewReader([]byte("body"))
+	if p.Body(r) != io.Reader(r) {
+		t.Error("an unpaced body is wrapped")
+	}
+
+	// A body created before a rate change keeps its rate
+	p.SetRate(1000)
+	paced := p.Body(bytes.NewReader([]byte("body")))
+	p.SetRate(0)
+	if pr, ok := paced.(*pacedReader); !ok || pr.rate != 1000 {
+		t.Error("a body created at 1000 bytes/s isn't paced at that rate")
+	}
+}
@@ -0,0 +1,131 @@
+// Package client holds helpers shared by the command-line clients.
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pinger sends application-level pings to the server's /ping endpoint and
+// reports the server dead after maxMisses consecutive failures. Transport
+// keep-alives only prove the connection is up; a ping proves the server is
+// still answering requests.
+type Pinger struct {
+	client    *http.Client
+	interval  time.Duration
+	maxMisses int
+	onDead    func()
+
+	mu       sync.Mutex
+	url      string
+	lastRTT  time.Duration
+	lastPong time.Time
+	misses   int
+}
+
+// NewPinger creates a pinger for serverAddr. onDead is called each time the
+// miss threshold is reached, typically to drop and re-establish connections.
+func NewPinger(client *http.Client, serverAddr string, interval time.Duration, maxMisses int, onDead func()) *Pinger {
+	return &Pinger{
+		client:    client,
+		url:       serverAddr + "/ping",
+		interval:  interval,
+		maxMisses: maxMisses,
+		onDead:    onDead,
+	}
+}
+
+// Run pings every interval until ctx is done
+func (p *Pinger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rtt, err := p.ping(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			p.record(rtt, err)
+		}
+	}
+}
+
+func (p *Pinger) ping(ctx context.Context) (time.Duration, error) {
+	// A ping that takes longer than the interval counts as missed
+	ctx, cancel := context.WithTimeout(ctx, p.interval)
+	defer cancel()
+
+	p.mu.Lock()
+	url := p.url
+	p.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+func (p *Pinger) record(rtt time.Duration, err error) {
+	p.mu.Lock()
+	if err == nil {
+		p.lastRTT = rtt
+		p.lastPong = time.Now()
+		p.misses = 0
+		p.mu.Unlock()
+		return
+	}
+
+	p.misses++
+	dead := p.misses >= p.maxMisses
+	if dead {
+		p.misses = 0
+	}
+	p.mu.Unlock()
+
+	if dead && p.onDead != nil {
+		p.onDead()
+	}
+}
+
+// SetServer points the pinger at another server, e.g. after a migration
+func (p *Pinger) SetServer(serverAddr string) {
+	p.mu.Lock()
+	p.url = serverAddr + "/ping"
+	p.misses = 0
+	p.mu.Unlock()
+}
+
+// LastRTT returns the round-trip time of the last successful ping
+func (p *Pinger) LastRTT() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastRTT
+}
+
+// LastPong returns when the server last answered a ping
+func (p *Pinger) LastPong() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastPong
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"time"
+)
+
+// Playout simulates the playout clock of a player against the arrival times
+// of its chunks, to measure the quality of experience of a stream. Each chunk
+// adds its playback time to the buffer. Playback starts once startup of
+// media is buffered, and stalls whenever the buffer runs dry until startup
+// is buffered again. It isn't safe for concurrent use.
+type Playout struct {
+	start   time.Time
+	startup time.Duration
+
+	firstByte     time.Duration // since start, -1 until known
+	firstKeyframe time.Duration
+	startedAt     time.Duration // when playback first started
+
+	playing  bool
+	buffered time.Duration // media ahead of the playhead at updated
+	updated  time.Time
+	stalled  time.Time // when the current stall began, zero unless stalled
+
+	rebuffers int
+	stall     time.Duration // of the stalls that ended
+	chunks    []playoutChunk
+	bytes     int64
+	switches  int
+}
+
+// playoutChunk is the media of a chunk that arrived
+type playoutChunk struct {
+	quality string
+	media   time.Duration
+}
+
+// QoE is the quality of experience of a stream as played out by a Playout.
+// Times that weren't reached are null.
+type QoE struct {
+	TimeToFirstByte     *float64           `json:"time_to_first_byte_ms"`
+	TimeToFirstKeyframe *float64           `json:"time_to_first_keyframe_ms"`
+	StartupDelay        *float64           `json:"startup_delay_ms"` // until playback started
+	Rebuffers           int                `json:"rebuffers"`        // stalls after playback started
+	StallTime           float64            `json:"stall_seconds"`
+	WatchTime           float64            `json:"watch_seconds"` // media played
+	QualityWatchTime    map[string]float64 `json:"quality_watch_seconds"`
+	AverageBitrate      float64            `json:"average_bitrate_kbps"` // of the media received
+	BytesReceived       int64              `json:"bytes_received"`
+	Chunks              int                `json:"chunks"`
+	QualitySwitches     int                `json:"quality_switches"`
+}
+
+// NewPlayout creates the playout of a stream requested at start, which
+// starts, and resumes after a stall, once startup of media is buffered
+func NewPlayout(start time.Time, startup time.Duration) *Playout {
+	return &Playout{start: start, startup: startup, firstByte: -1, firstKeyframe: -1, startedAt: -1, updated: start}
+}
+
+// FirstByte records that the first byte of a chunk arrived at t. Only the
+// first call counts.
+func (p *Playout) FirstByte(t time.Time) {
+	if p.firstByte < 0 {
+		p.firstByte = t.Sub(p.start)
+	}
+}
+
+// Chunk records that a chunk of size bytes at quality, playing for media,
+// arrived whole at t
+func (p *Playout) Chunk(t time.Time, quality string, size int, media time.Duration, keyframe bool) {
+	p.advance(t)
+	p.FirstByte(t)
+	if keyframe && p.firstKeyframe < 0 {
+		p.firstKeyframe = t.Sub(p.start)
+	}
+	if n := len(p.chunks); n > 0 && p.chunks[n-1].quality != quality {
+		p.switches++
+	}
+	p.chunks = append(p.chunks, playoutChunk{quality, media})
+	p.bytes += int64(size)
+	p.buffered += media
+	if !p.playing && p.buffered >= p.startup {
+		p.playing = true
+		if p.startedAt < 0 {
+			p.startedAt = t.Sub(p.start)
+		}
+		if !p.stalled.IsZero() {
+			p.stall += t.Sub(p.stalled)
+			p.stalled = time.Time{}
+		}
+	}
+}
+
+// advance plays the buffer out until t, stalling if it runs dry
+func (p *Playout) advance(t time.Time) {
+	if p.playing {
+		if elapsed := t.Sub(p.updated); elapsed >= p.buffered {
+			p.playing = false
+			p.stalled = p.updated.Add(p.buffered)
+			p.rebuffers++
+			p.buffered = 0
+		} else {
+			p.buffered -= elapsed
+		}
+	}
+	p.updated = t
+}
+
+// Report plays the buffer out until t and returns the quality of experience
+// so far. A stall still going on at t counts until t.
+func (p *Playout) Report(t time.Time) QoE {
+	p.advance(t)
+	q := QoE{
+		TimeToFirstByte:     millis(p.firstByte),
+		TimeToFirstKeyframe: millis(p.firstKeyframe),
+		StartupDelay:        millis(p.startedAt),
+		Rebuffers:           p.rebuffers,
+		StallTime:           p.stall.Seconds(),
+		QualityWatchTime:    make(map[string]float64),
+		BytesReceived:       p.bytes,
+		Chunks:              len(p.chunks),
+		QualitySwitches:     p.switches,
+	}
+	if !p.stalled.IsZero() {
+		q.StallTime += t.Sub(p.stalled).Seconds()
+	}
+
+	// What is still buffered is the end of the latest chunks
+	var received time.Duration
+	unplayed := p.buffered
+	if p.startedAt < 0 {
+		unplayed = 0
+		for _, c := range p.chunks {
+			unplayed += c.media
+		}
+	}
+	for i := len(p.chunks) - 1; i >= 0; i-- {
+		c := p.chunks[i]
+		received += c.media
+		played := c.media - min(unplayed, c.media)
+		unplayed -= c.media - played
+		if played > 0 {
+			q.QualityWatchTime[c.quality] += played.Seconds()
+			q.WatchTime += played.Seconds()
+		}
+	}
+	if received > 0 {
+		q.AverageBitrate = float64(p.bytes) * 8 / 1000 / received.Seconds()
+	}
+	return q
+}
+
+// millis returns d in milliseconds, or nil if it is negative
+func millis(d time.Duration) *float64 {
+	if d < 0 {
+		return nil
+	}
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
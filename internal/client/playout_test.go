@@ -0,0 +1,75 @@
+package client
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPlayoutStallsAndResumes(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	p := NewPlayout(start, 2*time.Second)
+
+	p.FirstByte(at(100))
+	p.Chunk(at(200), "low", 250_000, 2*time.Second, true)
+	p.Chunk(at(1200), "low", 250_000, 2*time.Second, false)
+	// 3s buffered at 1.2s run dry at 4.2s, and the next chunk comes at 5.2s
+	p.Chunk(at(5200), "high", 500_000, 2*time.Second, false)
+	q := p.Report(at(6200))
+
+	for _, f := range []struct {
+		name string
+		got  *float64
+		want float64
+	}{
+		{"time to first byte", q.TimeToFirstByte, 100},
+		{"time to first keyframe", q.TimeToFirstKeyframe, 200},
+		{"startup delay", q.StartupDelay, 200},
+	} {
+		if f.got == nil || *f.got != f.want {
+			t.Errorf("%s %v, want %vms", f.name, f.got, f.want)
+		}
+	}
+	if q.Rebuffers != 1 || q.StallTime != 1 {
+		t.Errorf("%d rebuffers, %vs stalled, want 1 of 1s", q.Rebuffers, q.StallTime)
+	}
+	// 1s of the last chunk is still buffered
+	if q.WatchTime != 5 || q.QualityWatchTime["low"] != 4 || q.QualityWatchTime["high"] != 1 {
+		t.Errorf("watched %vs, %v by quality, want 4s of low and 1s of high", q.WatchTime, q.QualityWatchTime)
+	}
+	if q.Chunks != 3 || q.BytesReceived != 1_000_000 || q.QualitySwitches != 1 {
+		t.Errorf("%d chunks, %d bytes and %d switches, want 3, 1000000 and 1", q.Chunks, q.BytesReceived, q.QualitySwitches)
+	}
+	if want := 1_000_000 * 8 / 1000 / 6.0; math.Abs(q.AverageBitrate-want) > 1e-9 {
+		t.Errorf("average bitrate %v kbps, want %v", q.AverageBitrate, want)
+	}
+}
+
+func TestPlayoutNeverStarted(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPlayout(start, 4*time.Second)
+	q := p.Report(start.Add(time.Second))
+	if q.TimeToFirstByte != nil || q.TimeToFirstKeyframe != nil || q.StartupDelay != nil || q.AverageBitrate != 0 {
+		t.Errorf("QoE of nothing received %+v, want the times unknown", q)
+	}
+
+	p.Chunk(start.Add(2*time.Second), "low", 100_000, 2*time.Second, false)
+	q = p.Report(start.Add(10 * time.Second))
+	if q.StartupDelay != nil || q.TimeToFirstKeyframe != nil || q.WatchTime != 0 || q.Rebuffers != 0 || q.StallTime != 0 {
+		t.Errorf("QoE before startup %+v, want nothing played", q)
+	}
+	if q.TimeToFirstByte == nil || *q.TimeToFirstByte != 2000 {
+		t.Errorf("time to first byte %v, want the chunk's arrival", q.TimeToFirstByte)
+	}
+}
+
+func TestPlayoutStallInProgress(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	p := NewPlayout(start, 2*time.Second)
+	p.Chunk(start, "low", 100_000, 2*time.Second, true)
+	q := p.Report(start.Add(5 * time.Second))
+	if q.Rebuffers != 1 || q.StallTime != 3 || q.WatchTime != 2 {
+		t.Errorf("%d rebuffers, %vs stalled, %vs watched, want 1, 3s and 2s", q.Rebuffers, q.StallTime, q.WatchTime)
+	}
+}
@@ -0,0 +1,40 @@
+// Package congestion selects the congestion controller of QUIC and TCP
+// connections.
+package congestion
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Congestion controllers
+const (
+	Reno  = "reno"
+	Cubic = "cubic"
+	BBR   = "bbr"
+)
+
+// QUIC is the controller of every QUIC connection. The linked quic-go
+// version hard-codes NewReno and exposes no option to select another.
+const QUIC = Reno
+
+// ResolveQUIC returns the controller QUIC connections run when requested is
+// asked for. The error explains a fallback to QUIC; an empty request is the
+// default and never fails.
+func ResolveQUIC(requested string) (string, error) {
+	if requested != "" && requested != QUIC {
+		return QUIC, fmt.Errorf("quic-go does not support congestion controller %q, using %s", requested, QUIC)
+	}
+	return QUIC, nil
+}
+
+// Available lists the TCP congestion controllers the kernel can run. It is
+// empty where the list is unknown.
+func Available() []string {
+	data, err := os.ReadFile("/proc/sys/net/ipv4/tcp_available_congestion_control")
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
@@ -0,0 +1,38 @@
+package congestion
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Control returns a net.Dialer or net.ListenConfig Control function that
+// sets the TCP congestion controller of the socket to name. Accepted
+// connections inherit the controller of their listener.
+func Control(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var serr error
+		if err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, name)
+		}); err != nil {
+			return err
+		}
+		return serr
+	}
+}
+
+// Of returns the congestion controller of a TCP socket
+func Of(conn syscall.Conn) (string, error) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+	var name string
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		name, serr = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	}); err != nil {
+		return "", err
+	}
+	return name, serr
+}
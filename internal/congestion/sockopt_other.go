@@ -0,0 +1,23 @@
+//go:build !linux
+
+package congestion
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errUnsupported = errors.New("selecting the TCP congestion controller is only supported on Linux")
+
+// Control returns a Control function that fails, the platform cannot select
+// the TCP congestion controller
+func Control(name string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		return errUnsupported
+	}
+}
+
+// Of fails, the platform cannot report the TCP congestion controller
+func Of(conn syscall.Conn) (string, error) {
+	return "", errUnsupported
+}
@@ -0,0 +1,215 @@
+// Package impair degrades the responses of selected streaming sessions so
+// client behavior can be studied without external network tooling.
+package impair
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// throttleSlice is how often a throttled writer releases bytes
+const throttleSlice = 100 * time.Millisecond
+
+// Profile describes how a session's chunks are degraded
+type Profile struct {
+	Session     string
+	Latency     time.Duration // added before each chunk
+	ThrottleBps int64         // write rate limit in bytes per second, 0 for none
+	DropNext    int           // number of upcoming chunks to drop
+	TTL         time.Duration // lifetime of the profile
+	ExpiresAt   time.Time     // set by Attach
+
+	ChunksImpaired int64 // chunks delayed or throttled under this profile
+	ChunksDropped  int64
+}
+
+// Validate checks that p describes a usable impairment
+func (p Profile) Validate() error {
+	switch {
+	case p.Session == "":
+		return fmt.Errorf("session is required")
+	case p.TTL <= 0:
+		return fmt.Errorf("ttl must be positive")
+	case p.Latency < 0 || p.ThrottleBps < 0 || p.DropNext < 0:
+		return fmt.Errorf("latency, throttle_bps and drop_next must not be negative")
+	case p.Latency == 0 && p.ThrottleBps == 0 && p.DropNext == 0:
+		return fmt.Errorf("profile has no impairment")
+	}
+	return nil
+}
+
+// Registry holds the active impairment profiles by session
+type Registry struct {
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	profiles map[string]*Profile
+}
+
+// Option configures a Registry
+type Option func(*Registry)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(r *Registry) {
+		r.clock = c
+	}
+}
+
+// NewRegistry creates an empty registry. Every change is logged to logger
+// as an audit trail.
+func NewRegistry(logger logging.Logger, opts ...Option) *Registry {
+	r := &Registry{
+		logger:   logger,
+		clock:    clock.Real(),
+		profiles: make(map[string]*Profile),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Attach installs p for its session, replacing any existing profile
+func (r *Registry) Attach(p Profile, actor string) (Profile, error) {
+	if err := p.Validate(); err != nil {
+		return Profile{}, err
+	}
+	p.ExpiresAt = r.clock.Now().Add(p.TTL)
+	p.ChunksImpaired, p.ChunksDropped = 0, 0
+
+	r.mu.Lock()
+	r.profiles[p.Session] = &p
+	r.mu.Unlock()
+
+	r.logger.Info("Impairment attached", logging.F("session", p.Session), logging.F("actor", actor),
+		logging.F("latency", p.Latency), logging.F("throttle_bps", p.ThrottleBps),
+		logging.F("drop_next", p.DropNext), logging.F("ttl", p.TTL))
+	return p, nil
+}
+
+// Remove deletes the profile of session. It returns false if there was none.
+func (r *Registry) Remove(session, actor string) bool {
+	r.mu.Lock()
+	_, ok := r.profiles[session]
+	delete(r.profiles, session)
+	r.mu.Unlock()
+
+	if ok {
+		r.logger.Info("Impairment removed", logging.F("session", session), logging.F("actor", actor))
+	}
+	return ok
+}
+
+// List returns the active profiles ordered by session
+func (r *Registry) List() []Profile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	profiles := make([]Profile, 0, len(r.profiles))
+	for session, p := range r.profiles {
+		if r.expired(session, p, now) {
+			continue
+		}
+		profiles = append(profiles, *p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Session < profiles[j].Session })
+	return profiles
+}
+
+// Apply degrades one chunk response for session. It returns the writer to
+// send the chunk through, or drop=true if the chunk must not be sent.
+// Sessions without a profile get w back unchanged.
+func (r *Registry) Apply(w http.ResponseWriter, req *http.Request, session string) (out http.ResponseWriter, drop bool) {
+	if r == nil {
+		return w, false
+	}
+
+	r.mu.Lock()
+	p, ok := r.profiles[session]
+	if !ok || r.expired(session, p, r.clock.Now()) {
+		r.mu.Unlock()
+		return w, false
+	}
+	if p.DropNext > 0 {
+		p.DropNext--
+		p.ChunksDropped++
+		r.mu.Unlock()
+		return w, true
+	}
+	p.ChunksImpaired++
+	latency, bps := p.Latency, p.ThrottleBps
+	r.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-r.clock.After(latency):
+		case <-req.Context().Done():
+		}
+	}
+	if bps > 0 {
+		return &throttledWriter{ResponseWriter: w, req: req, clock: r.clock, perSlice: sliceBytes(bps)}, false
+	}
+	return w, false
+}
+
+// expired removes p if it has expired. The caller holds r.mu.
+func (r *Registry) expired(session string, p *Profile, now time.Time) bool {
+	if now.Before(p.ExpiresAt) {
+		return false
+	}
+	delete(r.profiles, session)
+	r.logger.Info("Impairment expired", logging.F("session", session),
+		logging.F("chunks_impaired", p.ChunksImpaired), logging.F("chunks_dropped", p.ChunksDropped))
+	return true
+}
+
+func sliceBytes(bps int64) int {
+	n := bps * int64(throttleSlice) / int64(time.Second)
+	if n < 1 {
+		n = 1
+	}
+	return int(n)
+}
+
+// throttledWriter releases at most perSlice bytes every throttleSlice
+type throttledWriter struct {
+	http.ResponseWriter
+	req      *http.Request
+	clock    clock.Clock
+	perSlice int
+}
+
+func (t *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		end := written + t.perSlice
+		if end > len(b) {
+			end = len(b)
+		}
+		n, err := t.ResponseWriter.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if f, ok := t.ResponseWriter.(http.Flusher); ok {
+			f.Flush()
+		}
+		if written < len(b) {
+			select {
+			case <-t.clock.After(throttleSlice):
+			case <-t.req.Context().Done():
+				return written, t.req.Context().Err()
+			}
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,280 @@
+package iot
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of aggregate
+const (
+	AggregateMeasurement = "measurement" // min, max and avg of the values
+	AggregateEvents      = "events"      // number of nonzero values, e.g. motion detected
+)
+
+// eventSensors are the sensor types whose readings report events rather
+// than measurements
+var eventSensors = map[string]bool{SensorMotion: true}
+
+// aggregateTick is how often the aggregator closes windows that have ended
+const aggregateTick = time.Second
+
+// aggregateBuffer is the number of closed windows held for a slow consumer
+// of Closed before more are dropped
+const aggregateBuffer = 1024
+
+// Aggregate summarizes the readings of one sensor type of a device over a
+// window. Min, Max and Avg are only set for measurements, Events only for
+// event sensors.
+type Aggregate struct {
+	DeviceID   string    `json:"device_id"`
+	SensorType string    `json:"sensor_type"`
+	Kind       string    `json:"kind"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Partial    bool      `json:"partial,omitempty"` // the window had not ended when this was taken
+	Count      int       `json:"count"`
+	Last       float64   `json:"last"`
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Avg        float64   `json:"avg"`
+	Events     int       `json:"events"`
+
+	sum float64
+}
+
+func (a *Aggregate) add(value float64) {
+	a.Count++
+	a.Last = value
+	if a.Kind == AggregateEvents {
+		if value != 0 {
+			a.Events++
+		}
+		return
+	}
+	if a.Count == 1 {
+		a.Min, a.Max = value, value
+	}
+	a.Min = min(a.Min, value)
+	a.Max = max(a.Max, value)
+	a.sum += value
+	a.Avg = a.sum / float64(a.Count)
+}
+
+type aggregateKey struct {
+	deviceID   string
+	sensorType string
+	window     time.Duration
+}
+
+// Aggregator summarizes the accepted readings of each device and sensor type
+// over fixed windows aligned to wall-clock boundaries, e.g. 12:00 to 12:05.
+// Readings fall in the window of their timestamp, corrected for the clock of
+// their device, while that window is open. Readings stamped in a window that
+// has closed, or in the future, fall in the current window, so a device with
+// a wrong clock can't add to windows that have already closed. Closed
+// windows are sent on Closed. The last closed window of a device is kept
+// until the window after it has ended too, so devices that stop sending are
+// forgotten.
+type Aggregator struct {
+	windows []time.Duration
+	clock   clock.Clock
+	out     chan Aggregate
+
+	mu      sync.Mutex
+	open    map[aggregateKey]*Aggregate
+	last    map[aggregateKey]Aggregate // most recently closed window
+	stopped bool
+
+	dropped prometheus.Counter
+}
+
+// NewAggregator creates an aggregator for the windows of cfg, or nil if none
+// are configured
+func NewAggregator(cfg config.AggregationConfig, c clock.Clock, reg *metrics.Registry) *Aggregator {
+	if len(cfg.Windows) == 0 {
+		return nil
+	}
+	return &Aggregator{
+		windows: cfg.Windows,
+		clock:   c,
+		out:     make(chan Aggregate, aggregateBuffer),
+		open:    make(map[aggregateKey]*Aggregate),
+		last:    make(map[aggregateKey]Aggregate),
+		dropped: reg.Counter("iot", "aggregates_dropped_total", "Closed aggregate windows dropped because no one consumed them"),
+	}
+}
+
+// WithAggregator summarizes accepted readings in a
+func WithAggregator(a *Aggregator) Option {
+	return func(h *Handler) {
+		h.aggregates = a
+	}
+}
+
+// aggregate adds an accepted reading to the aggregates, if enabled
+func (h *Handler) aggregate(data SensorData) {
+	if h.aggregates != nil {
+		h.aggregates.Add(data)
+	}
+}
+
+// Windows returns the configured window lengths
+func (a *Aggregator) Windows() []time.Duration {
+	return a.windows
+}
+
+// Closed returns the channel closed windows are sent on. It is closed by
+// Close. Windows are dropped while the channel is full.
+func (a *Aggregator) Closed() <-chan Aggregate {
+	return a.out
+}
+
+// LogClosed logs each closed window at debug level until Close. It is the
+// consumer of Closed when nothing else reads it.
+func (a *Aggregator) LogClosed(logger logging.Logger) {
+	for agg := range a.out {
+		logger.Debug("Aggregate window closed", logging.F("device_id", agg.DeviceID), logging.F("sensor_type", agg.SensorType),
+			logging.F("start", agg.Start), logging.F("window", agg.End.Sub(agg.Start)), logging.F("count", agg.Count),
+			logging.F("partial", agg.Partial))
+	}
+}
+
+// Add adds an accepted reading to the current window of each length
+func (a *Aggregator) Add(data SensorData) {
+	now := a.clock.Now()
+	kind := AggregateMeasurement
+	if eventSensors[data.SensorType] {
+		kind = AggregateEvents
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return
+	}
+	for _, window := range a.windows {
+		key := aggregateKey{data.DeviceID, data.SensorType, window}
+		start := now.Truncate(window)
+		agg := a.open[key]
+		// A reading of the last window that hasn't been closed yet
+		if agg != nil && agg.Start.Before(start) && !data.Timestamp.Before(agg.Start) && data.Timestamp.Before(agg.End) {
+			agg.add(data.Value)
+			continue
+		}
+		if agg != nil && !agg.Start.Equal(start) {
+			a.closeLocked(key, now)
+			agg = nil
+		}
+		if agg == nil {
+			agg = &Aggregate{DeviceID: data.DeviceID, SensorType: data.SensorType, Kind: kind, Start: start, End: start.Add(window)}
+			a.open[key] = agg
+		}
+		agg.add(data.Value)
+	}
+}
+
+// closeLocked moves the open window of key to the closed ones and sends it.
+// a.mu must be held.
+func (a *Aggregator) closeLocked(key aggregateKey, now time.Time) {
+	agg := *a.open[key]
+	delete(a.open, key)
+	agg.Partial = now.Before(agg.End)
+	a.last[key] = agg
+	select {
+	case a.out <- agg:
+	default:
+		a.dropped.Inc()
+	}
+}
+
+// Run closes windows as they end until ctx is done
+func (a *Aggregator) Run(ctx context.Context) {
+	ticker := a.clock.NewTicker(aggregateTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			a.closeEnded(now)
+		}
+	}
+}
+
+// closeEnded closes the windows that have ended by now and forgets the
+// closed windows of devices that sent nothing in the window after them
+func (a *Aggregator) closeEnded(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, agg := range a.open {
+		if !now.Before(agg.End) {
+			a.closeLocked(key, now)
+		}
+	}
+	for key, agg := range a.last {
+		if _, ok := a.open[key]; !ok && !now.Before(agg.End.Add(key.window)) {
+			delete(a.last, key)
+		}
+	}
+}
+
+// Close sends every open window, marked partial, and closes the Closed
+// channel, so that the last windows aren't lost at shutdown. Readings added
+// afterwards are ignored.
+func (a *Aggregator) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return
+	}
+	a.stopped = true
+	now := a.clock.Now()
+	for key := range a.open {
+		a.closeLocked(key, now)
+	}
+	close(a.out)
+}
+
+// Aggregates returns the most recently closed and the current window of
+// length window for each sensor type of deviceID, or of every length if
+// window is zero. The current window is marked partial.
+func (a *Aggregator) Aggregates(deviceID string, window time.Duration) []Aggregate {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	aggs := []Aggregate{}
+	matches := func(key aggregateKey) bool {
+		return key.deviceID == deviceID && (window == 0 || key.window == window)
+	}
+	for key, agg := range a.last {
+		if matches(key) {
+			aggs = append(aggs, agg)
+		}
+	}
+	for key, agg := range a.open {
+		if matches(key) {
+			current := *agg
+			current.Partial = true
+			aggs = append(aggs, current)
+		}
+	}
+	sort.Slice(aggs, func(i, j int) bool {
+		if aggs[i].SensorType != aggs[j].SensorType {
+			return aggs[i].SensorType < aggs[j].SensorType
+		}
+		if d1, d2 := aggs[i].End.Sub(aggs[i].Start), aggs[j].End.Sub(aggs[j].Start); d1 != d2 {
+			return d1 < d2
+		}
+		return aggs[i].Start.Before(aggs[j].Start)
+	})
+	return aggs
+}
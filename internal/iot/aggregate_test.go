@@ -0,0 +1,152 @@
+package iot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// aggregateStart is 12:00, a boundary of every window used by the tests
+var aggregateStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestAggregator creates an aggregator of 1 and 5 minute windows on a
+// fake clock set to aggregateStart
+func newTestAggregator(t *testing.T) (*Aggregator, *clock.Fake) {
+	t.Helper()
+	c := clock.NewFake(aggregateStart)
+	a := NewAggregator(config.AggregationConfig{Windows: []time.Duration{time.Minute, 5 * time.Minute}}, c, metrics.NewRegistry())
+	return a, c
+}
+
+// addAt advances the clock to offset past aggregateStart and adds a reading
+// of deviceID stamped stamp past aggregateStart
+func addAt(a *Aggregator, c *clock.Fake, offset, stamp time.Duration, deviceID, sensorType string, value float64) {
+	c.Set(aggregateStart.Add(offset))
+	a.Add(SensorData{DeviceID: deviceID, SensorType: sensorType, Value: value, Timestamp: aggregateStart.Add(stamp)})
+}
+
+// closedAggregate receives the next closed window
+func closedAggregate(t *testing.T, a *Aggregator) Aggregate {
+	t.Helper()
+	select {
+	case agg, ok := <-a.Closed():
+		if !ok {
+			t.Fatal("Closed was closed")
+		}
+		return agg
+	default:
+		t.Fatal("no window was closed")
+		return Aggregate{}
+	}
+}
+
+// checkMeasurement compares the summary of a measurement window
+func checkMeasurement(t *testing.T, agg Aggregate, start time.Time, window time.Duration, count int, last, lo, hi, avg float64) {
+	t.Helper()
+	if !agg.Start.Equal(start) || !agg.End.Equal(start.Add(window)) {
+		t.Errorf("window is %v to %v, want %v to %v", agg.Start, agg.End, start, start.Add(window))
+	}
+	if agg.Kind != AggregateMeasurement || agg.Count != count || agg.Last != last || agg.Min != lo || agg.Max != hi || agg.Avg != avg {
+		t.Errorf("got %s count=%d last=%v min=%v max=%v avg=%v, want measurement count=%d last=%v min=%v max=%v avg=%v",
+			agg.Kind, agg.Count, agg.Last, agg.Min, agg.Max, agg.Avg, count, last, lo, hi, avg)
+	}
+}
+
+// TestAggregatorWindowBoundaries feeds readings across the boundaries of the
+// 1 minute window and checks the summary of each window, including a late
+// reading of a window that hadn't been closed yet
+func TestAggregatorWindowBoundaries(t *testing.T) {
+	a, c := newTestAggregator(t)
+	addAt(a, c, 10*time.Second, 10*time.Second, "dev1", SensorTemperature, 20)
+	addAt(a, c, 40*time.Second, 40*time.Second, "dev1", SensorTemperature, 30)
+	addAt(a, c, 50*time.Second, 50*time.Second, "dev1", SensorTemperature, 10)
+	// Arrives after 12:01 but is stamped 12:00:59, before the tick closed
+	// the first window
+	addAt(a, c, 60500*time.Millisecond, 59*time.Second, "dev1", SensorTemperature, 50)
+	// The first reading of the second window closes the first one
+	addAt(a, c, 61*time.Second, 61*time.Second, "dev1", SensorTemperature, 40)
+
+	checkMeasurement(t, closedAggregate(t, a), aggregateStart, time.Minute, 4, 50, 10, 50, 27.5)
+
+	// Stamped in the closed first window, so it counts in the current one
+	addAt(a, c, 70*time.Second, 30*time.Second, "dev1", SensorTemperature, 0)
+	a.closeEnded(aggregateStart.Add(2 * time.Minute))
+	second := closedAggregate(t, a)
+	checkMeasurement(t, second, aggregateStart.Add(time.Minute), time.Minute, 2, 0, 0, 40, 20)
+	if second.Partial {
+		t.Error("a window closed after it ended is marked partial")
+	}
+
+	aggs := a.Aggregates("dev1", 5*time.Minute)
+	if len(aggs) != 1 || !aggs[0].Partial {
+		t.Fatalf("got 5 minute aggregates %+v, want the current window", aggs)
+	}
+	checkMeasurement(t, aggs[0], aggregateStart, 5*time.Minute, 6, 0, 0, 50, 25)
+}
+
+// TestAggregatorCountsEvents checks that motion readings count the nonzero
+// values instead of summarizing them
+func TestAggregatorCountsEvents(t *testing.T) {
+	a, c := newTestAggregator(t)
+	for i, value := range []float64{1, 0, 1, 1, 0} {
+		offset := time.Duration(i+1) * 10 * time.Second
+		addAt(a, c, offset, offset, "dev1", SensorMotion, value)
+	}
+	a.closeEnded(aggregateStart.Add(time.Minute))
+
+	agg := closedAggregate(t, a)
+	if agg.Kind != AggregateEvents || agg.Count != 5 || agg.Events != 3 || agg.Min != 0 || agg.Max != 0 || agg.Avg != 0 {
+		t.Errorf("got %+v, want 3 events in 5 readings", agg)
+	}
+}
+
+// TestAggregatorForgetsIdleDevices checks that the last closed window of a
+// device is dropped once the device has sent nothing for a whole window
+func TestAggregatorForgetsIdleDevices(t *testing.T) {
+	a, c := newTestAggregator(t)
+	addAt(a, c, 10*time.Second, 10*time.Second, "dev1", SensorTemperature, 20)
+
+	a.closeEnded(aggregateStart.Add(time.Minute))
+	closedAggregate(t, a)
+	if aggs := a.Aggregates("dev1", time.Minute); len(aggs) != 1 {
+		t.Fatalf("got %d 1 minute aggregates right after the window closed, want 1", len(aggs))
+	}
+	a.closeEnded(aggregateStart.Add(2 * time.Minute))
+	if aggs := a.Aggregates("dev1", time.Minute); len(aggs) != 0 {
+		t.Errorf("got 1 minute aggregates %+v of a device idle for a whole window, want none", aggs)
+	}
+	if aggs := a.Aggregates("dev1", 5*time.Minute); len(aggs) != 1 {
+		t.Errorf("got %d 5 minute aggregates, want the open window", len(aggs))
+	}
+
+	a.closeEnded(aggregateStart.Add(10 * time.Minute))
+	closedAggregate(t, a)
+	if len(a.open) != 0 || len(a.last) != 0 {
+		t.Errorf("%d open and %d closed windows kept for an idle device, want none", len(a.open), len(a.last))
+	}
+}
+
+// TestAggregatorCloseFlushesPartialWindows checks that Close sends the open
+// windows marked partial and ignores later readings
+func TestAggregatorCloseFlushesPartialWindows(t *testing.T) {
+	a, c := newTestAggregator(t)
+	addAt(a, c, 10*time.Second, 10*time.Second, "dev1", SensorTemperature, 20)
+	addAt(a, c, 20*time.Second, 20*time.Second, "dev1", SensorTemperature, 22)
+	a.Close()
+	addAt(a, c, 30*time.Second, 30*time.Second, "dev1", SensorTemperature, 99)
+
+	var windows []time.Duration
+	for agg := range a.Closed() {
+		if !agg.Partial {
+			t.Errorf("window of %v flushed at Close isn't marked partial", agg.End.Sub(agg.Start))
+		}
+		checkMeasurement(t, agg, aggregateStart, agg.End.Sub(agg.Start), 2, 22, 20, 22, 21)
+		windows = append(windows, agg.End.Sub(agg.Start))
+	}
+	if len(windows) != 2 {
+		t.Errorf("Close sent windows %v, want the 1 and 5 minute ones", windows)
+	}
+}
@@ -0,0 +1,92 @@
+package iot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxRecentAlerts is how many alerts Alerts keeps for Recent
+const maxRecentAlerts = 100
+
+// Alert is a condition of a device that needs attention
+type Alert struct {
+	Kind     string    `json:"kind"` // e.g. "low_battery"
+	DeviceID string    `json:"device_id"`
+	Message  string    `json:"message"`
+	Value    float64   `json:"value"` // the value that raised the alert
+	At       time.Time `json:"at"`
+}
+
+// Alerts collects the alerts raised about devices. Each alert is logged,
+// kept among the recent ones and passed to every subscriber.
+type Alerts struct {
+	logger logging.Logger
+
+	mu     sync.Mutex
+	recent []Alert // oldest first
+	subs   []chan Alert
+
+	raised  *metrics.CounterVec
+	dropped prometheus.Counter
+}
+
+// NewAlerts creates an alert engine without alerts or subscribers
+func NewAlerts(logger logging.Logger, reg *metrics.Registry) *Alerts {
+	return &Alerts{
+		logger:  logger,
+		raised:  reg.CounterVec("iot", "alerts_total", "Device alerts raised by kind", "kind"),
+		dropped: reg.Counter("iot", "alerts_dropped_total", "Alerts not passed to a subscriber that was behind"),
+	}
+}
+
+// Raise records a and passes it to the subscribers. A subscriber whose
+// channel is full misses it rather than holding up the caller. Raising on a
+// nil Alerts does nothing.
+func (a *Alerts) Raise(alert Alert) {
+	if a == nil {
+		return
+	}
+	a.logger.Warn("Device alert", logging.F("kind", alert.Kind), logging.F("device_id", alert.DeviceID),
+		logging.F("message", alert.Message))
+	a.raised.WithLabelValues(alert.Kind).Inc()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.recent) == maxRecentAlerts {
+		copy(a.recent, a.recent[1:])
+		a.recent = a.recent[:maxRecentAlerts-1]
+	}
+	a.recent = append(a.recent, alert)
+	for _, ch := range a.subs {
+		select {
+		case ch <- alert:
+		default:
+			a.dropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every later alert. It holds up
+// to buffer alerts the subscriber hasn't taken yet.
+func (a *Alerts) Subscribe(buffer int) <-chan Alert {
+	ch := make(chan Alert, buffer)
+	a.mu.Lock()
+	a.subs = append(a.subs, ch)
+	a.mu.Unlock()
+	return ch
+}
+
+// Recent returns the last alerts raised, newest first
+func (a *Alerts) Recent() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	out := make([]Alert, len(a.recent))
+	for i, alert := range a.recent {
+		out[len(out)-1-i] = alert
+	}
+	return out
+}
@@ -0,0 +1,263 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertAnomaly is the kind of alert raised for a reading that strays from
+// the recent readings of its device and sensor type
+const AlertAnomaly = "anomaly"
+
+// minDeviation stands in for the deviation of a series that never varied,
+// so that any change in it is an anomaly without dividing by zero
+const minDeviation = 1e-6
+
+// Anomaly is a reading outside the range its series led to expect
+type Anomaly struct {
+	DeviceID   string    `json:"device_id"`
+	SensorType string    `json:"sensor_type"`
+	Value      float64   `json:"value"`
+	Min        float64   `json:"expected_min"`
+	Max        float64   `json:"expected_max"`
+	Score      float64   `json:"score"` // how far out the value is, e.g. in standard deviations
+	At         time.Time `json:"at"`
+}
+
+// AnomalyDetector judges each reading against the earlier ones of its
+// device and sensor type. Observe and Reset may be called concurrently.
+type AnomalyDetector interface {
+	// Observe adds data to its series and reports whether it is an anomaly
+	Observe(data SensorData) (Anomaly, bool)
+	// Reset forgets the series of deviceID
+	Reset(deviceID string)
+}
+
+type seriesKey struct {
+	deviceID   string
+	sensorType string
+}
+
+// ZScoreDetector flags a reading further than sigma standard deviations
+// from the mean of the last window readings of its series. A series isn't
+// judged until it has warm-up readings. Anomalous readings join the window
+// too, so a lasting change of level stops being flagged once the window has
+// taken it in.
+type ZScoreDetector struct {
+	window int
+	sigma  float64
+	warmUp int
+
+	mu     sync.Mutex
+	series map[seriesKey]*zSeries
+}
+
+// zSeries is the last readings of a series, as a ring
+type zSeries struct {
+	values []float64
+	next   int // where the next reading goes once values is full
+}
+
+// NewZScoreDetector creates a detector with the window, sigma and warm-up
+// of cfg
+func NewZScoreDetector(cfg config.AnomalyConfig) *ZScoreDetector {
+	return &ZScoreDetector{
+		window: cfg.Window,
+		sigma:  cfg.Sigma,
+		warmUp: cfg.WarmUp,
+		series: make(map[seriesKey]*zSeries),
+	}
+}
+
+// Observe adds data to its series and reports whether it is further than
+// sigma deviations from the mean of the readings before it
+func (d *ZScoreDetector) Observe(data SensorData) (Anomaly, bool) {
+	key := seriesKey{data.DeviceID, data.SensorType}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.series[key]
+	if !ok {
+		s = &zSeries{values: make([]float64, 0, d.window)}
+		d.series[key] = s
+	}
+
+	var anomaly Anomaly
+	var found bool
+	if len(s.values) >= d.warmUp {
+		mean, deviation := meanDeviation(s.values)
+		deviation = max(deviation, minDeviation)
+		score := math.Abs(data.Value-mean) / deviation
+		if score > d.sigma {
+			anomaly = Anomaly{
+				DeviceID:   data.DeviceID,
+				SensorType: data.SensorType,
+				Value:      data.Value,
+				Min:        mean - d.sigma*deviation,
+				Max:        mean + d.sigma*deviation,
+				Score:      score,
+				At:         data.Timestamp,
+			}
+			found = true
+		}
+	}
+
+	if len(s.values) < d.window {
+		s.values = append(s.values, data.Value)
+	} else {
+		s.values[s.next] = data.Value
+		s.next = (s.next + 1) % d.window
+	}
+	return anomaly, found
+}
+
+// Reset forgets the series of deviceID, which warms up again
+func (d *ZScoreDetector) Reset(deviceID string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key := range d.series {
+		if key.deviceID == deviceID {
+			delete(d.series, key)
+		}
+	}
+}
+
+// meanDeviation returns the mean and population standard deviation of values
+func meanDeviation(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+// Anomalies runs accepted readings through a detector apart from ingestion.
+// Readings wait in a bounded queue for Run, and are skipped while it is
+// full, so a slow detector never holds up a request. Each anomaly raises an
+// alert and is passed to every subscriber.
+type Anomalies struct {
+	detector AnomalyDetector
+	alerts   *Alerts
+	clock    clock.Clock
+	queue    chan SensorData
+
+	mu   sync.Mutex
+	subs []chan Anomaly
+
+	detected *metrics.CounterVec
+	skipped  prometheus.Counter
+	dropped  prometheus.Counter
+}
+
+// NewAnomalies creates an anomaly pipeline that judges readings with
+// detector and raises its alerts on alerts, which may be nil. It returns nil
+// if cfg isn't enabled.
+func NewAnomalies(cfg config.AnomalyConfig, detector AnomalyDetector, alerts *Alerts, c clock.Clock, reg *metrics.Registry) *Anomalies {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &Anomalies{
+		detector: detector,
+		alerts:   alerts,
+		clock:    c,
+		queue:    make(chan SensorData, cfg.Buffer),
+		detected: reg.CounterVec("iot", "anomalies_total", "Anomalous sensor readings by sensor type", "sensor_type"),
+		skipped:  reg.Counter("iot", "anomaly_readings_skipped_total", "Readings not checked for anomalies because the detector was behind"),
+		dropped:  reg.Counter("iot", "anomalies_dropped_total", "Anomalies not passed to a subscriber that was behind"),
+	}
+}
+
+// WithAnomalies checks accepted readings for anomalies with a, and starts
+// each device's series over when it registers
+func WithAnomalies(a *Anomalies) Option {
+	return func(h *Handler) {
+		if a != nil {
+			h.anomalies = a
+			h.publishers = append(h.publishers, a)
+		}
+	}
+}
+
+// Publish queues a reading for the detector. It never blocks.
+func (a *Anomalies) Publish(data SensorData) {
+	select {
+	case a.queue <- data:
+	default:
+		a.skipped.Inc()
+	}
+}
+
+// Run checks queued readings until ctx is done
+func (a *Anomalies) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-a.queue:
+			if anomaly, ok := a.detector.Observe(data); ok {
+				a.raise(anomaly)
+			}
+		}
+	}
+}
+
+func (a *Anomalies) raise(anomaly Anomaly) {
+	if anomaly.At.IsZero() {
+		anomaly.At = a.clock.Now()
+	}
+	a.detected.WithLabelValues(anomaly.SensorType).Inc()
+	a.alerts.Raise(Alert{
+		Kind:     AlertAnomaly,
+		DeviceID: anomaly.DeviceID,
+		Message: fmt.Sprintf("%s reading %.4g outside %.4g to %.4g (score %.1f)", anomaly.SensorType, anomaly.Value,
+			anomaly.Min, anomaly.Max, anomaly.Score),
+		Value: anomaly.Value,
+		At:    anomaly.At,
+	})
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, ch := range a.subs {
+		select {
+		case ch <- anomaly:
+		default:
+			a.dropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every later anomaly. It holds
+// up to buffer anomalies the subscriber hasn't taken yet; later ones are
+// dropped for it.
+func (a *Anomalies) Subscribe(buffer int) <-chan Anomaly {
+	ch := make(chan Anomaly, buffer)
+	a.mu.Lock()
+	a.subs = append(a.subs, ch)
+	a.mu.Unlock()
+	return ch
+}
+
+// Reset starts the series of deviceID over, e.g. as it registers again.
+// Resetting a nil Anomalies does nothing.
+func (a *Anomalies) Reset(deviceID string) {
+	if a != nil {
+		a.detector.Reset(deviceID)
+	}
+}
+
+// queueDepths reports the readings waiting for the detector
+func (a *Anomalies) queueDepths(into map[string]int) {
+	into["anomalies"] = len(a.queue)
+}
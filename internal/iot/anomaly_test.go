@@ -0,0 +1,166 @@
+package iot
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// stable is the ith reading of a temperature that wobbles around 20
+func stable(deviceID string, i int) SensorData {
+	return SensorData{DeviceID: deviceID, SensorType: "temperature", Value: 20 + 0.1*float64(i%5)}
+}
+
+func TestZScoreDetectorFlagsSpikeOnce(t *testing.T) {
+	cfg := config.Default().IoT.Anomaly
+	d := NewZScoreDetector(cfg)
+
+	var found []Anomaly
+	for i := 0; i < 200; i++ {
+		data := stable("dev1", i)
+		if i == 100 {
+			data.Value = 35
+		}
+		if a, ok := d.Observe(data); ok {
+			found = append(found, a)
+		}
+	}
+	if len(found) != 1 {
+		t.Fatalf("%d anomalies, want only the spike: %+v", len(found), found)
+	}
+	a := found[0]
+	if a.Value != 35 || a.DeviceID != "dev1" || a.SensorType != "temperature" {
+		t.Errorf("anomaly %+v, want the spike of dev1", a)
+	}
+	if a.Min > 20 || a.Max < 20.4 || a.Max > 35 {
+		t.Errorf("expected range %g to %g, want it around the stable values", a.Min, a.Max)
+	}
+	if a.Score <= cfg.Sigma {
+		t.Errorf("score %g within sigma %g", a.Score, cfg.Sigma)
+	}
+}
+
+func TestZScoreDetectorWarmUp(t *testing.T) {
+	cfg := config.Default().IoT.Anomaly
+	d := NewZScoreDetector(cfg)
+
+	// Nothing is judged before warm_up readings, however far off
+	for i := 0; i < cfg.WarmUp; i++ {
+		data := stable("dev1", i)
+		if i == cfg.WarmUp-1 {
+			data.Value = 1000
+		}
+		if a, ok := d.Observe(data); ok {
+			t.Fatalf("reading %d of %d flagged during warm-up: %+v", i+1, cfg.WarmUp, a)
+		}
+	}
+	for i := 0; i < cfg.WarmUp; i++ {
+		d.Observe(stable("dev2", i))
+	}
+	if _, ok := d.Observe(SensorData{DeviceID: "dev2", SensorType: "temperature", Value: 1000}); !ok {
+		t.Error("spike after warm-up not flagged")
+	}
+
+	// Other series of the device warm up on their own
+	if _, ok := d.Observe(SensorData{DeviceID: "dev2", SensorType: "humidity", Value: 1000}); ok {
+		t.Error("first reading of another sensor type flagged")
+	}
+
+	// A device that registers again warms up again
+	d.Reset("dev2")
+	if _, ok := d.Observe(SensorData{DeviceID: "dev2", SensorType: "temperature", Value: 2000}); ok {
+		t.Error("reading flagged right after a reset")
+	}
+}
+
+func TestAnomaliesRaiseAlertAndNotify(t *testing.T) {
+	cfg := config.Default().IoT.Anomaly
+	cfg.Enabled = true
+	reg := metrics.NewRegistry()
+	alerts := NewAlerts(logging.Nop(), reg)
+	raised := alerts.Subscribe(10)
+	a := NewAnomalies(cfg, NewZScoreDetector(cfg), alerts, clock.Real(), reg)
+	detected := a.Subscribe(10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go a.Run(ctx)
+	for i := 0; i < cfg.WarmUp; i++ {
+		a.Publish(stable("dev1", i))
+	}
+	a.Publish(SensorData{DeviceID: "dev1", SensorType: "temperature", Value: 35})
+
+	select {
+	case anomaly := <-detected:
+		if anomaly.Value != 35 || anomaly.At.IsZero() {
+			t.Errorf("anomaly %+v", anomaly)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no anomaly within a second")
+	}
+	select {
+	case alert := <-raised:
+		if alert.Kind != AlertAnomaly || alert.DeviceID != "dev1" {
+			t.Errorf("alert %+v", alert)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert within a second")
+	}
+}
+
+func TestAnomaliesNeverBlockIngestion(t *testing.T) {
+	cfg := config.Default().IoT.Anomaly
+	cfg.Enabled = true
+	cfg.Buffer = 10
+	reg := metrics.NewRegistry()
+	a := NewAnomalies(cfg, NewZScoreDetector(cfg), nil, clock.Real(), reg)
+
+	// Without Run nothing takes readings off the queue
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			a.Publish(stable("dev1", i))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a detector that is behind")
+	}
+	if got := metricValue(t, reg, "commsys_iot_anomaly_readings_skipped_total"); got != "90" {
+		t.Errorf("%s readings skipped, want 90", got)
+	}
+}
+
+func TestAnomaliesDisabled(t *testing.T) {
+	cfg := config.Default().IoT.Anomaly
+	if a := NewAnomalies(cfg, NewZScoreDetector(cfg), nil, clock.Real(), metrics.NewRegistry()); a != nil {
+		t.Error("anomaly detection created while disabled")
+	}
+}
+
+func TestRegistrationResetsAnomalySeries(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Anomaly.Enabled = true
+	reg := metrics.NewRegistry()
+	detector := NewZScoreDetector(cfg.IoT.Anomaly)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg),
+		WithAnomalies(NewAnomalies(cfg.IoT.Anomaly, detector, nil, clock.Real(), reg)))
+
+	for i := 0; i < cfg.IoT.Anomaly.WarmUp; i++ {
+		detector.Observe(stable("dev1", i))
+	}
+	send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev1", "min_version": 1, "max_version": 1}`)
+	if _, ok := detector.Observe(SensorData{DeviceID: "dev1", SensorType: "temperature", Value: 1000}); ok {
+		t.Error("series of a device kept across its registration")
+	}
+}
@@ -0,0 +1,77 @@
+package iot
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// TokenHeader carries the device's token on every request after registration
+const TokenHeader = "X-Device-Token"
+
+// AuthErrorCode is returned in the body of requests rejected for a missing
+// or invalid device token
+const AuthErrorCode = "unauthenticated"
+
+// Errors returned by an Authenticator
+var (
+	ErrTokenMissing = errors.New("device token required")
+	ErrTokenInvalid = errors.New("invalid device token")
+)
+
+// Authenticator verifies that a token belongs to a device ID
+type Authenticator interface {
+	Authenticate(deviceID, token string) error
+}
+
+// StaticTokens authenticates devices against a fixed token per device ID,
+// as configured in iot.device_tokens. Unknown devices are refused.
+type StaticTokens map[string]string
+
+// Authenticate implements Authenticator
+func (t StaticTokens) Authenticate(deviceID, token string) error {
+	if token == "" {
+		return ErrTokenMissing
+	}
+	want, ok := t[deviceID]
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(want)) != 1 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// WithAuthenticator requires every device to prove its ID with a token
+// accepted by a, replacing the static tokens of iot.device_tokens
+func WithAuthenticator(a Authenticator) Option {
+	return func(h *Handler) {
+		h.auth = a
+	}
+}
+
+// authorize checks the TokenHeader of r against deviceID. It answers 401
+// with AuthErrorCode and returns false if the device is not authenticated.
+// Without an authenticator every device is trusted. An authorized device is
+// put in session on the connection of r.
+func (h *Handler) authorize(w http.ResponseWriter, r *http.Request, deviceID string) bool {
+	var err error
+	if h.auth != nil {
+		err = h.auth.Authenticate(deviceID, r.Header.Get(TokenHeader))
+	}
+	if err == nil {
+		h.attach(r, deviceID)
+		return true
+	}
+	h.metrics.authFailed.Inc()
+	h.logger.Debug("Device not authenticated", logging.F("device_id", deviceID),
+		logging.F("remote_addr", r.RemoteAddr), logging.Err(err))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": err.Error(),
+		"code":  AuthErrorCode,
+	})
+	return false
+}
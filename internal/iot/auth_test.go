@@ -0,0 +1,73 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestStaticTokens(t *testing.T) {
+	tokens := StaticTokens{"dev1": "token-one", "dev2": "token-two"}
+	tests := []struct {
+		name     string
+		deviceID string
+		token    string
+		want     error
+	}{
+		{"own token", "dev1", "token-one", nil},
+		{"missing token", "dev1", "", ErrTokenMissing},
+		{"wrong token", "dev1", "token-on", ErrTokenInvalid},
+		{"token of another device", "dev1", "token-two", ErrTokenInvalid},
+		{"unknown device", "dev3", "token-one", ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		if err := tokens.Authenticate(tt.deviceID, tt.token); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Authenticate(%s) = %v, want %v", tt.name, tt.deviceID, err, tt.want)
+		}
+	}
+}
+
+func TestReadingsRequireDeviceToken(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.DeviceTokens = map[string]string{"dev1": "token-one", "dev2": "token-two"}
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	tests := []struct {
+		name    string
+		headers []string
+		want    error
+	}{
+		{"missing token", nil, ErrTokenMissing},
+		{"wrong token", []string{TokenHeader, "guess"}, ErrTokenInvalid},
+		{"token of another device", []string{TokenHeader, "token-two"}, ErrTokenInvalid},
+	}
+	for _, tt := range tests {
+		rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21), tt.headers...)
+		var body map[string]string
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnauthorized || body["code"] != AuthErrorCode || body["error"] != tt.want.Error() {
+			t.Errorf("%s: status %d, %v, want 401 with %q", tt.name, rec.Code, body, tt.want)
+		}
+	}
+	if got := h.Stats().Readings.Accepted; got != 0 {
+		t.Fatalf("%d readings accepted without the device's token", got)
+	}
+	if got := metricValue(t, reg, "commsys_iot_unauthenticated_total"); got != "3" {
+		t.Errorf("unauthenticated_total %s, want 3", got)
+	}
+
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21), TokenHeader, "token-one"); rec.Code != http.StatusOK {
+		t.Errorf("own token: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := h.Stats().Readings.Accepted; got != 1 {
+		t.Errorf("%d readings accepted with the device's token, want 1", got)
+	}
+}
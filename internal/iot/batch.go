@@ -0,0 +1,295 @@
+package iot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DeltaContentType marks a delta-encoded sensor batch. Batches sent as
+// application/json are a plain array of SensorData.
+const DeltaContentType = "application/x-iot-delta"
+
+// A delta batch holds readings of one device and sensor. After a header of
+// device ID, sensor type, unit, quality, precision and count, the first
+// reading carries its absolute Unix millisecond timestamp and value; every
+// later one carries the millisecond delta to the previous timestamp and the
+// value delta in units of precision. Strings are uvarint length-prefixed,
+// deltas zigzag varints and absolute values little-endian float64 bits.
+// Decoded values are within precision/2 of the originals and timestamps are
+// truncated to milliseconds.
+
+// EncodeDelta encodes readings, which must share device, sensor type, unit
+// and quality, as a delta batch quantized to precision
+func EncodeDelta(readings []SensorData, precision float64) ([]byte, error) {
+	if len(readings) == 0 {
+		return nil, errors.New("empty batch")
+	}
+	if !(precision > 0) || math.IsInf(precision, 0) {
+		return nil, fmt.Errorf("invalid precision %v", precision)
+	}
+
+	first := readings[0]
+	b := appendString(nil, first.DeviceID)
+	b = appendString(b, first.SensorType)
+	b = appendString(b, first.Unit)
+	b = appendString(b, first.Quality)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(precision))
+	b = binary.AppendUvarint(b, uint64(len(readings)))
+
+	lastMs := first.Timestamp.UnixMilli()
+	last := first.Value
+	b = binary.AppendVarint(b, lastMs)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(last))
+
+	for i, r := range readings[1:] {
+		if r.DeviceID != first.DeviceID || r.SensorType != first.SensorType || r.Unit != first.Unit || r.Quality != first.Quality {
+			return nil, fmt.Errorf("reading %d: device, sensor type, unit and quality must match the first reading", i+1)
+		}
+		steps := math.Round((r.Value - last) / precision)
+		if math.IsNaN(steps) || math.Abs(steps) > 1<<53 {
+			return nil, fmt.Errorf("reading %d: value %v out of range at precision %v", i+1, r.Value, precision)
+		}
+		ms := r.Timestamp.UnixMilli()
+		b = binary.AppendVarint(b, ms-lastMs)
+		b = binary.AppendVarint(b, int64(steps))
+
+		// Deltas are taken from the decoded value so rounding errors don't add up
+		lastMs, last = ms, last+steps*precision
+	}
+	return b, nil
+}
+
+// DecodeDelta decodes a delta batch back into full readings
+func DecodeDelta(b []byte) ([]SensorData, error) {
+	d := deltaDecoder{b: b}
+	var proto SensorData
+	proto.DeviceID = d.string()
+	proto.SensorType = d.string()
+	proto.Unit = d.string()
+	proto.Quality = d.string()
+	precision := d.float()
+	count := d.uvarint()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if !(precision > 0) || math.IsInf(precision, 0) {
+		return nil, fmt.Errorf("invalid precision %v", precision)
+	}
+	// Every reading takes at least two bytes, which bounds the allocation
+	if count == 0 || count > uint64(len(d.b)) {
+		return nil, fmt.Errorf("invalid reading count %d", count)
+	}
+
+	readings := make([]SensorData, 0, count)
+	ms := d.varint()
+	value := d.float()
+	for i := uint64(0); d.err == nil; i++ {
+		r := proto
+		r.Timestamp = time.UnixMilli(ms).UTC()
+		r.Value = value
+		readings = append(readings, r)
+		if i+1 == count {
+			break
+		}
+		ms += d.varint()
+		value += float64(d.varint()) * precision
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.b) != 0 {
+		return nil, fmt.Errorf("%d trailing bytes after batch", len(d.b))
+	}
+	return readings, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// deltaDecoder reads batch fields, keeping the first error
+type deltaDecoder struct {
+	b   []byte
+	err error
+}
+
+var errTruncated = errors.New("truncated batch")
+
+func (d *deltaDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *deltaDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *deltaDecoder) float() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 8 {
+		d.err = errTruncated
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return v
+}
+
+func (d *deltaDecoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > uint64(len(d.b)) {
+		d.err = errTruncated
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+// handleBatch accepts several readings in one request, either as a JSON array
+// or, from ProtocolV3, delta-encoded
+func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var readings []SensorData
+	var n int64
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == DeltaContentType {
+		if err := requireVersion(r.Context(), "delta batches", ProtocolV3); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxMessageBytes))
+		n = int64(len(body))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				h.rejectOversized(w, r)
+			} else {
+				http.Error(w, "Failed to read batch", http.StatusBadRequest)
+			}
+			return
+		}
+		start := time.Now()
+		readings, err = DecodeDelta(body)
+		setDecodeTiming(w, time.Since(start))
+		if err != nil {
+			http.Error(w, "Invalid delta batch: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := numberReadings(r.Header.Get(ReadingSeqHeader), readings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		var ok bool
+		if n, ok = h.decode(w, r, &readings, "sensor batch"); !ok {
+			return
+		}
+		if len(readings) == 0 {
+			http.Error(w, "Empty sensor batch", http.StatusBadRequest)
+			return
+		}
+	}
+
+	deviceID := readings[0].DeviceID
+	for i, data := range readings {
+		if (i == 0 || data.DeviceID != readings[i-1].DeviceID) && !h.authorize(w, r, data.DeviceID) {
+			return
+		}
+	}
+	msgID, ok := messageID(w, r)
+	if !ok {
+		return
+	}
+	response := Response{
+		Status:  "success",
+		Message: fmt.Sprintf("%d sensor readings received", len(readings)),
+	}
+	ok = h.ordered(w, r, deviceID, func(seq uint64) bool {
+		response.Seq = seq
+		if h.duplicate(deviceID, msgID, "batch") {
+			h.stats.duplicate.Add(int64(len(readings)))
+			response.Message = fmt.Sprintf("Duplicate batch of %d sensor readings ignored", len(readings))
+			return true
+		}
+		// Rejected inside the order so that later batches don't wait for it
+		if len(readings) > h.maxBatch {
+			h.stats.dropped.Add(int64(len(readings)))
+			http.Error(w, fmt.Sprintf("Sensor batch of %d readings exceeds %d", len(readings), h.maxBatch), http.StatusRequestEntityTooLarge)
+			return false
+		}
+		if !h.throttle(w, deviceID) {
+			h.stats.throttled.Add(int64(len(readings)))
+			return false
+		}
+		for i := range readings {
+			readings[i] = h.correctTimestamp(readings[i])
+		}
+		if !h.validate(w, readings, true) {
+			return false
+		}
+		if !h.quotas.ChargeDevice(w, r, deviceID, n) {
+			h.stats.dropped.Add(int64(len(readings)))
+			return false
+		}
+		_, span := h.tracer.Start(r.Context(), "iot.process_sensor_batch",
+			trace.WithAttributes(tracing.String("device_id", deviceID), tracing.Int("readings", len(readings))))
+		for _, data := range readings {
+			h.metrics.sensorReadings.WithLabelValues(data.SensorType).Inc()
+			h.stats.accepted.Add(1)
+			h.aggregate(data)
+			h.publish(data)
+			h.checkSequence(data)
+		}
+		h.markSeen(deviceID)
+		h.processed(deviceID, msgID)
+		h.logger.Debug("Received sensor batch", logging.F("device_id", deviceID),
+			logging.F("readings", len(readings)), logging.F("bytes", n), logging.F("encoding", mediaType), logging.F("seq", seq))
+		span.End()
+		return true
+	})
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
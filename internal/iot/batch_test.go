@@ -0,0 +1,159 @@
+package iot
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// walk returns n temperature readings of dev1 taken about every second,
+// drifting from 21 degrees
+func walk(n int) []SensorData {
+	rng := rand.New(rand.NewSource(1))
+	at := time.Date(2026, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	value := 21.0
+	readings := make([]SensorData, n)
+	for i := range readings {
+		readings[i] = SensorData{DeviceID: "dev1", SensorType: "temperature", Unit: "celsius", Quality: "reliable", Value: value, Timestamp: at}
+		at = at.Add(time.Second + time.Duration(rng.Intn(50))*time.Millisecond)
+		value += rng.NormFloat64() * 0.3
+	}
+	return readings
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	const precision = 0.01
+	readings := walk(500)
+	b, err := EncodeDelta(readings, precision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeDelta(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != len(readings) {
+		t.Fatalf("%d readings decoded, want %d", len(decoded), len(readings))
+	}
+	for i, r := range decoded {
+		want := readings[i]
+		// The error of the last reading is no larger than that of the first
+		if math.Abs(r.Value-want.Value) > precision/2+1e-9 {
+			t.Errorf("reading %d: value %v, want %v within %v", i, r.Value, want.Value, precision/2)
+		}
+		if !r.Timestamp.Equal(want.Timestamp.Truncate(time.Millisecond)) {
+			t.Errorf("reading %d: timestamp %v, want %v to the millisecond", i, r.Timestamp, want.Timestamp)
+		}
+		if r.DeviceID != want.DeviceID || r.SensorType != want.SensorType || r.Unit != want.Unit || r.Quality != want.Quality {
+			t.Errorf("reading %d: %+v, want the header of %+v", i, r, want)
+		}
+	}
+
+	asJSON, _ := json.Marshal(readings)
+	if len(b)*5 > len(asJSON) {
+		t.Errorf("delta batch of %d bytes, want under a fifth of the %d bytes of JSON", len(b), len(asJSON))
+	}
+}
+
+func TestEncodeDeltaRejects(t *testing.T) {
+	mixed := walk(3)
+	mixed[2].DeviceID = "dev2"
+	tests := []struct {
+		name      string
+		readings  []SensorData
+		precision float64
+		want      string
+	}{
+		{"empty", nil, 0.01, "empty batch"},
+		{"zero precision", walk(2), 0, "invalid precision"},
+		{"NaN precision", walk(2), math.NaN(), "invalid precision"},
+		{"two devices", mixed, 0.01, "reading 2: device"},
+		{"value out of range", append(walk(1), SensorData{DeviceID: "dev1", SensorType: "temperature", Unit: "celsius", Quality: "reliable", Value: 1e300}), 0.01, "out of range"},
+	}
+	for _, tt := range tests {
+		if _, err := EncodeDelta(tt.readings, tt.precision); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	b, _ := EncodeDelta(walk(3), 0.01)
+	if _, err := DecodeDelta(append(b, 0)); err == nil || !strings.Contains(err.Error(), "trailing") {
+		t.Errorf("batch with a trailing byte: error %v", err)
+	}
+	if _, err := DecodeDelta(b[:len(b)-1]); err == nil {
+		t.Error("truncated batch decoded")
+	}
+}
+
+func TestDeltaBatchNeedsV3(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+	b, err := EncodeDelta(walk(10), 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := send(t, h, http.MethodPost, "/iot/batch", string(b), "Content-Type", DeltaContentType, VersionHeader, "2")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "delta batches unsupported") {
+		t.Errorf("delta batch at version 2: %d %q, want 400", rec.Code, rec.Body)
+	}
+	rec = send(t, h, http.MethodPost, "/iot/batch", string(b), "Content-Type", DeltaContentType, VersionHeader, "3")
+	var resp Response
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Message != "10 sensor readings received" {
+		t.Errorf("delta batch at version 3: %d %+v, want 10 readings", rec.Code, resp)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/batch", "not a batch", "Content-Type", DeltaContentType, VersionHeader, "3"); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed delta batch: status %d, want 400", rec.Code)
+	}
+}
+
+// TestBatchOverMaxSizeRejected checks that a batch larger than
+// iot.max_batch is answered with 413 and that the sequenced batch after it
+// doesn't wait for it
+func TestBatchOverMaxSizeRejected(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.MaxBatch = 3
+	cfg.IoT.OrderWait = time.Minute
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+	batch := func(n int) string {
+		readings := make([]string, n)
+		for i := range readings {
+			readings[i] = reading("dev1", 21)
+		}
+		return "[" + strings.Join(readings, ", ") + "]"
+	}
+
+	rec := send(t, h, http.MethodPost, "/iot/batch", batch(4), SeqHeader, "1")
+	if rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "exceeds 3") {
+		t.Errorf("batch of 4 readings: %d %q, want 413", rec.Code, rec.Body)
+	}
+
+	done := make(chan int)
+	go func() {
+		done <- send(t, h, http.MethodPost, "/iot/batch", batch(3), SeqHeader, "2").Code
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusOK {
+			t.Errorf("batch of 3 readings after the rejected one: status %d, want 200", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the batch after a rejected one waits for it")
+	}
+	if s := h.Stats(); s.Readings.Accepted != 3 || s.Readings.Dropped != 4 {
+		t.Errorf("got %d readings accepted and %d dropped, want 3 and 4", s.Readings.Accepted, s.Readings.Dropped)
+	}
+}
@@ -0,0 +1,189 @@
+package iot
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertClockSkew is the kind of alert raised when a device's clock is
+// further off, or jumps further, than iot.clock_skew.threshold
+const AlertClockSkew = "clock_skew"
+
+// DeviceClock is the estimated offset of a device's clock from the server's
+type DeviceClock struct {
+	DeviceID   string    `json:"device_id"`
+	Offset     float64   `json:"offset_seconds"`      // moving average of device time minus server time
+	Jitter     float64   `json:"jitter_seconds"`      // moving average of how far samples stray from Offset
+	LastSample float64   `json:"last_sample_seconds"` // offset of the last reading
+	Samples    int64     `json:"samples"`
+	Skewed     bool      `json:"skewed"` // Offset or Jitter is beyond the threshold
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ClockSkew estimates the offset of each device's clock from the server's,
+// as the difference between a reading's timestamp and the time it arrived,
+// smoothed with an exponentially weighted moving average. Timestamps of
+// devices whose offset is beyond the tolerance are corrected by it. A clock
+// that is off by more than the threshold, or jumps by more than it, is
+// flagged and raises an alert, and again only after it has recovered.
+// Devices that send no reading for the idle timeout are forgotten.
+type ClockSkew struct {
+	smoothing float64
+	tolerance time.Duration
+	threshold time.Duration
+	alerts    *Alerts
+	clock     clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*DeviceClock
+	sweep   idleSweep
+
+	corrected prometheus.Counter
+	skewed    prometheus.Gauge
+}
+
+// NewClockSkew creates a tracker with the settings of cfg that raises its
+// alerts on alerts, which may be nil, and forgets devices silent for idle,
+// unless it is 0. It returns nil if cfg isn't enabled.
+func NewClockSkew(cfg config.ClockSkewConfig, idle time.Duration, alerts *Alerts, c clock.Clock, reg *metrics.Registry) *ClockSkew {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &ClockSkew{
+		smoothing: cfg.Smoothing,
+		tolerance: cfg.Tolerance,
+		threshold: cfg.Threshold,
+		alerts:    alerts,
+		clock:     c,
+		devices:   make(map[string]*DeviceClock),
+		sweep:     idleSweep{after: idle},
+		corrected: reg.Counter("iot", "clock_corrected_readings_total", "Readings whose timestamp was corrected for the device's clock"),
+		skewed:    reg.Gauge("iot", "devices_clock_skewed", "Devices whose clock is beyond iot.clock_skew.threshold"),
+	}
+}
+
+// Observe updates the offset of deviceID with a reading it stamped at
+// stamped, arriving now
+func (s *ClockSkew) Observe(deviceID string, stamped time.Time) {
+	now := s.clock.Now()
+	sample := stamped.Sub(now).Seconds()
+
+	s.mu.Lock()
+	sweepIdle(&s.sweep, s.devices, now, func(d *DeviceClock) time.Time { return d.UpdatedAt }, func(d *DeviceClock) {
+		if d.Skewed {
+			s.skewed.Dec()
+		}
+	})
+	d, ok := s.devices[deviceID]
+	if !ok {
+		d = &DeviceClock{DeviceID: deviceID, Offset: sample}
+		s.devices[deviceID] = d
+	}
+	d.Jitter += s.smoothing * (math.Abs(sample-d.Offset) - d.Jitter)
+	d.Offset += s.smoothing * (sample - d.Offset)
+	d.LastSample, d.UpdatedAt = sample, now
+	d.Samples++
+	wasSkewed := d.Skewed
+	limit := s.threshold.Seconds()
+	d.Skewed = math.Abs(d.Offset) > limit || d.Jitter > limit
+	clock := *d
+	s.mu.Unlock()
+
+	switch {
+	case clock.Skewed && !wasSkewed:
+		s.skewed.Inc()
+		s.alerts.Raise(Alert{
+			Kind:     AlertClockSkew,
+			DeviceID: deviceID,
+			Message:  fmt.Sprintf("clock off by %.1fs with %.1fs jitter, beyond %v", clock.Offset, clock.Jitter, s.threshold),
+			Value:    clock.Offset,
+			At:       now,
+		})
+	case !clock.Skewed && wasSkewed:
+		s.skewed.Dec()
+	}
+}
+
+// Correct returns stamped, a timestamp of deviceID, shifted by the device's
+// offset, and whether the offset was beyond the tolerance. Within the
+// tolerance stamped is returned as is.
+func (s *ClockSkew) Correct(deviceID string, stamped time.Time) (time.Time, bool) {
+	s.mu.Lock()
+	d, ok := s.devices[deviceID]
+	var offset time.Duration
+	if ok {
+		offset = time.Duration(d.Offset * float64(time.Second))
+	}
+	s.mu.Unlock()
+	if offset.Abs() <= s.tolerance {
+		return stamped, false
+	}
+	s.corrected.Inc()
+	return stamped.Add(-offset), true
+}
+
+// Device returns the clock estimate of deviceID
+func (s *ClockSkew) Device(deviceID string) (DeviceClock, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[deviceID]
+	if !ok {
+		return DeviceClock{}, false
+	}
+	return *d, true
+}
+
+// List returns the clock estimate of every device that sent a reading,
+// ordered by device ID
+func (s *ClockSkew) List() []DeviceClock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]DeviceClock, 0, len(s.devices))
+	for _, d := range s.devices {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// WithClockSkew corrects the timestamps of readings for the clocks of their
+// devices, as estimated by s
+func WithClockSkew(s *ClockSkew) Option {
+	return func(h *Handler) {
+		h.clockSkew = s
+	}
+}
+
+// observeClock updates the clock estimate of data's device, if clocks are
+// tracked. Only readings sent as soon as they were taken tell the offset;
+// batches may hold readings the device kept back.
+func (h *Handler) observeClock(data SensorData) {
+	if h.clockSkew != nil && !data.Timestamp.IsZero() {
+		h.clockSkew.Observe(data.DeviceID, data.Timestamp)
+	}
+}
+
+// correctTimestamp returns data with its timestamp corrected for the clock
+// of its device, keeping the device's own in DeviceTimestamp
+func (h *Handler) correctTimestamp(data SensorData) SensorData {
+	if h.clockSkew == nil {
+		return data
+	}
+	data.DeviceTimestamp = nil
+	if data.Timestamp.IsZero() {
+		return data
+	}
+	if corrected, ok := h.clockSkew.Correct(data.DeviceID, data.Timestamp); ok {
+		stamped := data.Timestamp
+		data.Timestamp, data.DeviceTimestamp = corrected, &stamped
+	}
+	return data
+}
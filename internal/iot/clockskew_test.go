@@ -0,0 +1,107 @@
+package iot
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newTestClockSkew creates a tracker with the default settings on a fake
+// clock, raising its alerts on the returned Alerts
+func newTestClockSkew(t *testing.T) (*ClockSkew, *Alerts, *clock.Fake, *metrics.Registry) {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	alerts := NewAlerts(logging.Nop(), reg)
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	return NewClockSkew(config.Default().IoT.ClockSkew, time.Hour, alerts, c, reg), alerts, c, reg
+}
+
+// TestClockSkewCorrectionConverges sets a device's clock 30s ahead after it
+// was right and checks that the correction converges on the offset without
+// flagging the clock
+func TestClockSkewCorrectionConverges(t *testing.T) {
+	s, alerts, c, _ := newTestClockSkew(t)
+	for i := 0; i < 5; i++ {
+		c.Advance(time.Second)
+		s.Observe("dev1", c.Now())
+	}
+	if _, corrected := s.Correct("dev1", c.Now()); corrected {
+		t.Error("a device on time was corrected")
+	}
+
+	const ahead = 30 * time.Second
+	for i := 0; i < 40; i++ {
+		c.Advance(time.Second)
+		s.Observe("dev1", c.Now().Add(ahead))
+	}
+
+	d, _ := s.Device("dev1")
+	if math.Abs(d.Offset-ahead.Seconds()) > 0.1 || d.LastSample != ahead.Seconds() {
+		t.Errorf("offset %.3fs after the last sample %.3fs, want %v", d.Offset, d.LastSample, ahead)
+	}
+	stamped := c.Now().Add(ahead)
+	corrected, ok := s.Correct("dev1", stamped)
+	if !ok || corrected.Sub(c.Now()).Abs() > 100*time.Millisecond {
+		t.Errorf("%v corrected to %v (%v), want about %v", stamped, corrected, ok, c.Now())
+	}
+	if d.Skewed || len(alerts.Recent()) != 0 {
+		t.Errorf("a clock off by %v, within the threshold, was flagged", ahead)
+	}
+}
+
+// TestClockSkewFlagsJumpyClock checks that a clock jumping back and forth
+// by more than the threshold is flagged for its jitter even though its
+// average offset is small
+func TestClockSkewFlagsJumpyClock(t *testing.T) {
+	s, alerts, c, reg := newTestClockSkew(t)
+	for i := 0; i < 5; i++ {
+		c.Advance(time.Second)
+		s.Observe("dev1", c.Now())
+	}
+	for i := 0; i < 20; i++ {
+		c.Advance(time.Second)
+		jump := 100 * time.Second
+		if i%2 == 1 {
+			jump = -jump
+		}
+		s.Observe("dev1", c.Now().Add(jump))
+	}
+
+	d, _ := s.Device("dev1")
+	if !d.Skewed || math.Abs(d.Offset) >= time.Minute.Seconds() || d.Jitter <= time.Minute.Seconds() {
+		t.Errorf("got %+v, want a skewed clock by its jitter alone", d)
+	}
+	recent := alerts.Recent()
+	if len(recent) != 1 || recent[0].Kind != AlertClockSkew || recent[0].DeviceID != "dev1" {
+		t.Errorf("got alerts %+v, want one clock_skew alert for dev1", recent)
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_clock_skewed"); got != "1" {
+		t.Errorf("iot_devices_clock_skewed = %s, want 1", got)
+	}
+}
+
+// TestClockSkewForgetsIdleDevices checks that a device that stopped sending
+// readings is forgotten and no longer counted as skewed
+func TestClockSkewForgetsIdleDevices(t *testing.T) {
+	s, _, c, reg := newTestClockSkew(t)
+	s.Observe("idle", c.Now().Add(5*time.Minute))
+	if d, _ := s.Device("idle"); !d.Skewed {
+		t.Fatal("a clock 5 minutes ahead isn't flagged")
+	}
+
+	c.Advance(2 * time.Hour)
+	for i := 0; i < idleSweepEvery; i++ {
+		s.Observe("busy", c.Now())
+	}
+	if _, ok := s.Device("idle"); ok {
+		t.Error("a device silent for longer than the idle timeout is still tracked")
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_clock_skewed"); got != "0" {
+		t.Errorf("iot_devices_clock_skewed = %s after the skewed device was forgotten, want 0", got)
+	}
+}
@@ -0,0 +1,241 @@
+// Package compress wraps IoT request and response bodies in the codecs a
+// device and the server agree on, for devices on metered links. Writers
+// flush each message as it is written out, so compression adds no delay to
+// streamed responses.
+package compress
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs, named as in Content-Encoding
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Codecs lists the supported codecs, in the server's default order of
+// preference
+var Codecs = []string{Zstd, Gzip}
+
+// maxWindow bounds the zstd window a peer can make the decoder allocate
+const maxWindow = 8 << 20
+
+// Supported reports whether codec is one of Codecs
+func Supported(codec string) bool {
+	return slices.Contains(Codecs, codec)
+}
+
+// Negotiate picks the first of enabled, in the server's order of
+// preference, that the peer offered. It returns "" when they have none in
+// common, and the body stays plain.
+func Negotiate(offered, enabled []string) string {
+	for _, codec := range enabled {
+		if slices.Contains(offered, codec) {
+			return codec
+		}
+	}
+	return ""
+}
+
+// ParseAccept returns the codecs listed in an Accept-Encoding header, leaving
+// out those refused with q=0
+func ParseAccept(header string) []string {
+	var codecs []string
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		codecs = append(codecs, name)
+	}
+	return codecs
+}
+
+// Writer compresses what is written to it. Flush writes out everything
+// written so far, so the peer can decode it without waiting for more.
+type Writer interface {
+	io.Writer
+	Flush() error
+	Close() error
+}
+
+var gzipWriters = sync.Pool{New: func() any {
+	return gzip.NewWriter(nil)
+}}
+
+var gzipReaders sync.Pool // *gzip.Reader, created by the first NewReader
+
+var zstdEncoders = sync.Pool{New: func() any {
+	// Options are valid, so NewWriter can't fail. TLS already checks
+	// integrity, so frames go without a checksum.
+	e, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true),
+		zstd.WithEncoderCRC(false))
+	return e
+}}
+
+var zstdDecoders = sync.Pool{New: func() any {
+	d, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxWindow(maxWindow))
+	return d
+}}
+
+// NewWriter returns a Writer that compresses into w with codec
+func NewWriter(codec string, w io.Writer) (Writer, error) {
+	switch codec {
+	case Gzip:
+		gw := gzipWriters.Get().(*gzip.Writer)
+		gw.Reset(w)
+		return &gzipWriter{Writer: gw}, nil
+	case Zstd:
+		e := zstdEncoders.Get().(*zstd.Encoder)
+		e.Reset(w)
+		return &zstdWriter{Encoder: e}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+}
+
+// gzipWriter returns its writer to the pool once closed
+type gzipWriter struct {
+	*gzip.Writer
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.Writer == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return w.Writer.Write(p)
+}
+
+func (w *gzipWriter) Flush() error {
+	if w.Writer == nil {
+		return io.ErrClosedPipe
+	}
+	return w.Writer.Flush()
+}
+
+func (w *gzipWriter) Close() error {
+	if w.Writer == nil {
+		return nil
+	}
+	err := w.Writer.Close()
+	w.Writer.Reset(nil)
+	gzipWriters.Put(w.Writer)
+	w.Writer = nil
+	return err
+}
+
+// zstdWriter returns its encoder to the pool once closed
+type zstdWriter struct {
+	*zstd.Encoder
+}
+
+func (w *zstdWriter) Write(p []byte) (int, error) {
+	if w.Encoder == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return w.Encoder.Write(p)
+}
+
+func (w *zstdWriter) Flush() error {
+	if w.Encoder == nil {
+		return io.ErrClosedPipe
+	}
+	return w.Encoder.Flush()
+}
+
+func (w *zstdWriter) Close() error {
+	if w.Encoder == nil {
+		return nil
+	}
+	err := w.Encoder.Close()
+	w.Encoder.Reset(nil)
+	zstdEncoders.Put(w.Encoder)
+	w.Encoder = nil
+	return err
+}
+
+// NewReader returns a reader that decompresses r with codec. Closing it
+// doesn't close r.
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case Gzip:
+		if gr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+			if err := gr.Reset(r); err != nil {
+				gzipReaders.Put(gr)
+				return nil, err
+			}
+			return &gzipReader{Reader: gr}, nil
+		}
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipReader{Reader: gr}, nil
+	case Zstd:
+		d := zstdDecoders.Get().(*zstd.Decoder)
+		if err := d.Reset(r); err != nil {
+			zstdDecoders.Put(d)
+			return nil, err
+		}
+		return &zstdReader{Decoder: d}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+}
+
+// gzipReader returns its reader to the pool once closed
+type gzipReader struct {
+	*gzip.Reader
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	if r.Reader == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *gzipReader) Close() error {
+	if r.Reader != nil {
+		gzipReaders.Put(r.Reader)
+		r.Reader = nil
+	}
+	return nil
+}
+
+// zstdReader returns its decoder to the pool once closed
+type zstdReader struct {
+	*zstd.Decoder
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	if r.Decoder == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return r.Decoder.Read(p)
+}
+
+func (r *zstdReader) Close() error {
+	if r.Decoder != nil {
+		r.Decoder.Reset(nil)
+		zstdDecoders.Put(r.Decoder)
+		r.Decoder = nil
+	}
+	return nil
+}
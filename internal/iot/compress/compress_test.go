@@ -0,0 +1,201 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// message is a reading as a device sends it, one JSON line each
+func message(i int) string {
+	return fmt.Sprintf(`{"device_id":"dev1","sensor_type":"temperature","value":%d.5,"unit":"celsius","quality":"reliable"}`+"\n", i)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, codec := range Codecs {
+		t.Run(codec, func(t *testing.T) {
+			var want strings.Builder
+			for i := 0; i < 100; i++ {
+				want.WriteString(message(i))
+			}
+
+			var wire bytes.Buffer
+			w, err := NewWriter(codec, &wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.WriteString(w, want.String()); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if wire.Len() >= want.Len() {
+				t.Errorf("%d bytes compressed to %d", want.Len(), wire.Len())
+			}
+
+			r, err := NewReader(codec, &wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != want.String() {
+				t.Errorf("decoded %d bytes that differ from the %d written", len(got), want.Len())
+			}
+		})
+	}
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	if _, err := NewWriter("br", io.Discard); err == nil {
+		t.Error("NewWriter accepted an unsupported codec")
+	}
+	if _, err := NewReader("br", strings.NewReader("")); err == nil {
+		t.Error("NewReader accepted an unsupported codec")
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept  string
+		enabled []string
+		want    string
+	}{
+		{"gzip, zstd", []string{Zstd, Gzip}, Zstd},
+		{"gzip", []string{Zstd, Gzip}, Gzip},
+		{"zstd;q=0, gzip", []string{Zstd, Gzip}, Gzip},
+		{"br", []string{Zstd, Gzip}, ""},
+		{"gzip, zstd", nil, ""}, // compression disabled
+		{"", []string{Zstd, Gzip}, ""},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(ParseAccept(tt.accept), tt.enabled); got != tt.want {
+			t.Errorf("Negotiate(%q, %v) = %q, want %q", tt.accept, tt.enabled, got, tt.want)
+		}
+	}
+}
+
+// TestFlushPerMessage checks that each message can be decoded as soon as it
+// is flushed, before the next one is written
+func TestFlushPerMessage(t *testing.T) {
+	for _, codec := range Codecs {
+		t.Run(codec, func(t *testing.T) {
+			pr, pw := io.Pipe()
+			defer pr.Close()
+			w, err := NewWriter(codec, pw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := make(chan string)
+			go func() {
+				defer close(lines)
+				r, err := NewReader(codec, pr)
+				if err != nil {
+					return
+				}
+				defer r.Close()
+				scanner := bufio.NewScanner(r)
+				for scanner.Scan() {
+					lines <- scanner.Text() + "\n"
+				}
+			}()
+
+			for i := 0; i < 10; i++ {
+				io.WriteString(w, message(i))
+				if err := w.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case got := <-lines:
+					if got != message(i) {
+						t.Fatalf("decoded %q, want %q", got, message(i))
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("message %d not decodable a second after it was flushed", i)
+				}
+			}
+			w.Close()
+			pw.Close()
+		})
+	}
+}
+
+func TestResponseWriterSmallBodyGoesPlain(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec, Gzip, 256)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"status":"ok"}`)
+	w.Close()
+
+	if got := rec.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding %q on a body under the minimum size", got)
+	}
+	if rec.Body.String() != `{"status":"ok"}` {
+		t.Errorf("body %q", rec.Body)
+	}
+	if payload, wire := w.Bytes(); payload != 0 || wire != 0 {
+		t.Errorf("counted %d payload and %d wire bytes for a plain body", payload, wire)
+	}
+}
+
+func TestResponseWriterFlushesEachMessage(t *testing.T) {
+	for _, codec := range Codecs {
+		t.Run(codec, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			w := NewResponseWriter(rec, codec, 256)
+			var sizes []int
+			for i := 0; i < 3; i++ {
+				io.WriteString(w, message(i))
+				w.Flush()
+				sizes = append(sizes, rec.Body.Len())
+			}
+			if !rec.Flushed {
+				t.Error("underlying writer not flushed")
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != codec {
+				t.Fatalf("Content-Encoding %q, want %q for a flushed stream", got, codec)
+			}
+			// Every flush put the message on the wire
+			for i := 1; i < len(sizes); i++ {
+				if sizes[i] <= sizes[i-1] {
+					t.Errorf("flush %d wrote nothing: body sizes %v", i, sizes)
+				}
+			}
+			w.Close()
+
+			r, err := NewReader(codec, rec.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, _ := io.ReadAll(r)
+			if want := message(0) + message(1) + message(2); string(got) != want {
+				t.Errorf("decoded %q, want %q", got, want)
+			}
+			if payload, wire := w.Bytes(); payload != int64(len(got)) || wire == 0 {
+				t.Errorf("counted %d payload and %d wire bytes, want %d payload", payload, wire, len(got))
+			}
+		})
+	}
+}
+
+func TestResponseWriterNoBody(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec, Zstd, 0)
+	w.WriteHeader(http.StatusNoContent)
+	w.Close()
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("status %d, Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,152 @@
+package compress
+
+import (
+	"io"
+	"net/http"
+)
+
+// ResponseWriter compresses a response body with codec. Bodies shorter than
+// a minimum size go plain, as compressing them would only add the codec's
+// framing. Flush, which streaming handlers call after each message, always
+// compresses and writes out everything compressed so far before flushing
+// the underlying writer.
+type ResponseWriter struct {
+	http.ResponseWriter
+	codec   string
+	minSize int
+
+	status  int    // written by the handler, 0 before WriteHeader
+	pending []byte // body held back until it reaches minSize
+	decided bool   // the header went out, compressed or not
+	zw      Writer // nil while undecided or plain
+	wire    counter
+	payload int64 // bytes written before compression
+}
+
+// NewResponseWriter returns a writer that compresses the response to w with
+// codec once the body reaches minSize bytes or is flushed. It must be closed
+// once the handler returns.
+func NewResponseWriter(w http.ResponseWriter, codec string, minSize int) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w, codec: codec, minSize: minSize, wire: counter{w: w}}
+}
+
+// WriteHeader holds the status back until it is known whether the body is
+// compressed
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false) // no body to compress
+	}
+}
+
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		if len(w.pending)+len(p) < w.minSize {
+			w.pending = append(w.pending, p...)
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	if w.zw == nil {
+		return w.ResponseWriter.Write(p)
+	}
+	n, err := w.zw.Write(p)
+	w.payload += int64(n)
+	return n, err
+}
+
+// decide writes the header, announcing the codec if compress, and then the
+// body held back so far
+func (w *ResponseWriter) decide(compress bool) error {
+	w.decided = true
+	if compress {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.codec)
+		h.Add("Vary", "Accept-Encoding")
+		zw, err := NewWriter(w.codec, &w.wire)
+		if err != nil {
+			return err
+		}
+		w.zw = zw
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	pending := w.pending
+	w.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	if w.zw == nil {
+		_, err := w.ResponseWriter.Write(pending)
+		return err
+	}
+	n, err := w.zw.Write(pending)
+	w.payload += int64(n)
+	return err
+}
+
+// Flush sends what was written so far to the client. A stream flushed
+// before its first message starts with the codec's header, so the client
+// can set up its decoder.
+func (w *ResponseWriter) Flush() {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.zw != nil {
+		w.zw.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the body. A body still shorter than the minimum size goes
+// plain.
+func (w *ResponseWriter) Close() error {
+	if w.status == 0 {
+		return nil // nothing written, the server answers as usual
+	}
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.zw == nil {
+		return nil
+	}
+	return w.zw.Close()
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Bytes returns the body bytes written by the handler and those sent
+// compressed, both 0 if the body went plain
+func (w *ResponseWriter) Bytes() (payload, wire int64) {
+	return w.payload, w.wire.n
+}
+
+// counter counts the bytes written to w
+type counter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package iot
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/nik1740/quic-communication-system/internal/iot/compress"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// uncompressedEndpoints carry binary bodies, byte ranges or a stream taken
+// over from HTTP, and are never compressed
+var uncompressedEndpoints = map[string]bool{
+	"upload":    true,
+	"uploads":   true,
+	"firmware":  true,
+	"datagrams": true,
+}
+
+// compression decodes request bodies and encodes responses in the codecs
+// of iot.compression. A device offers codecs at registration and the server
+// picks one; the device then sends its bodies with that Content-Encoding and
+// asks for it in Accept-Encoding.
+type compression struct {
+	codecs  []string // in order of preference
+	minSize int
+	wire    *metrics.CounterVec
+	payload *metrics.CounterVec
+}
+
+func newCompression(cfg config.CompressionConfig, reg *metrics.Registry) *compression {
+	return &compression{
+		codecs:  cfg.Codecs,
+		minSize: cfg.MinSize,
+		wire:    reg.CounterVec("iot", "compression_wire_bytes_total", "Compressed IoT body bytes by codec and direction", "codec", "direction"),
+		payload: reg.CounterVec("iot", "compression_payload_bytes_total", "IoT body bytes before compression by codec and direction", "codec", "direction"),
+	}
+}
+
+// pickCompression returns the codec to use with a device that offered
+// offered at registration, or "" for plain bodies
+func (h *Handler) pickCompression(offered []string) string {
+	if h.compression == nil {
+		return ""
+	}
+	return compress.Negotiate(offered, h.compression.codecs)
+}
+
+// negotiateCompression decodes the body of r if it was sent compressed, and
+// returns w wrapped to compress the response if the client accepts one of
+// the codecs and it isn't shorter than iot.compression.min_size. done must be called once the response is written. It answers
+// 415 and returns false when the body's codec isn't enabled.
+func (h *Handler) negotiateCompression(w http.ResponseWriter, r *http.Request, endpoint string) (http.ResponseWriter, func(), bool) {
+	done := func() {}
+	codec := r.Header.Get("Content-Encoding")
+	covered := h.compression != nil && !uncompressedEndpoints[endpoint]
+	if codec != "" && codec != "identity" {
+		if !covered || !slices.Contains(h.compression.codecs, codec) {
+			accept := "identity"
+			if covered {
+				accept = strings.Join(h.compression.codecs, ", ")
+			}
+			w.Header().Set("Accept-Encoding", accept)
+			http.Error(w, fmt.Sprintf("Unsupported Content-Encoding %q", codec), http.StatusUnsupportedMediaType)
+			return w, done, false
+		}
+		wire := &countingReader{r: r.Body}
+		body, err := compress.NewReader(codec, wire)
+		if err != nil {
+			http.Error(w, "Invalid "+codec+" body", http.StatusBadRequest)
+			return w, done, false
+		}
+		payload := &countingReader{r: body}
+		r.Body = io.NopCloser(payload)
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		done = func() {
+			body.Close()
+			h.compression.wire.WithLabelValues(codec, "request").Add(float64(wire.n))
+			h.compression.payload.WithLabelValues(codec, "request").Add(float64(payload.n))
+		}
+	}
+
+	if !covered || r.Method == http.MethodHead {
+		return w, done, true
+	}
+	accept := compress.Negotiate(compress.ParseAccept(r.Header.Get("Accept-Encoding")), h.compression.codecs)
+	if accept == "" {
+		return w, done, true
+	}
+	cw := compress.NewResponseWriter(w, accept, h.compression.minSize)
+	decoded := done
+	return cw, func() {
+		decoded()
+		cw.Close()
+		if payload, wire := cw.Bytes(); wire > 0 {
+			h.compression.wire.WithLabelValues(accept, "response").Add(float64(wire))
+			h.compression.payload.WithLabelValues(accept, "response").Add(float64(payload))
+		}
+	}, true
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
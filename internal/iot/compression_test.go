@@ -0,0 +1,111 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot/compress"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func newCompressionHandler(enabled bool) *Handler {
+	cfg := config.Default()
+	cfg.IoT.Compression.Enabled = enabled
+	cfg.IoT.Compression.MinSize = 0
+	reg := metrics.NewRegistry()
+	return NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+}
+
+// postReading posts a reading with its body in codec, or plain if codec is
+// empty, and asks for the response in codec
+func postReading(t *testing.T, h *Handler, codec string) *httptest.ResponseRecorder {
+	t.Helper()
+	body := `{"device_id": "dev1", "sensor_type": "temperature", "value": 21.5, "unit": "celsius"}`
+	var buf bytes.Buffer
+	if codec == "" {
+		buf.WriteString(body)
+	} else {
+		w, err := compress.NewWriter(codec, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, body)
+		w.Close()
+	}
+	req := httptest.NewRequest(http.MethodPost, "/iot/sensor", &buf)
+	req.Header.Set("Content-Type", "application/json")
+	if codec != "" {
+		req.Header.Set("Content-Encoding", codec)
+		req.Header.Set("Accept-Encoding", codec)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCompressionNegotiated(t *testing.T) {
+	h := newCompressionHandler(true)
+	if got := register(t, h, compress.Gzip, compress.Zstd); got != compress.Zstd {
+		t.Errorf("picked %q, want the server's preferred %q", got, compress.Zstd)
+	}
+	if got := register(t, h, compress.Gzip); got != compress.Gzip {
+		t.Errorf("picked %q, want the only codec offered", got)
+	}
+
+	for _, codec := range compress.Codecs {
+		rec := postReading(t, h, codec)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", codec, rec.Code, rec.Body)
+		}
+		if got := rec.Header().Get("Content-Encoding"); got != codec {
+			t.Fatalf("%s: response Content-Encoding %q", codec, got)
+		}
+		r, err := compress.NewReader(codec, rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(r)
+		r.Close()
+		if !json.Valid(body) {
+			t.Errorf("%s: decoded response %q isn't JSON", codec, body)
+		}
+	}
+}
+
+func TestCompressionDisabledFallsBackToPlain(t *testing.T) {
+	h := newCompressionHandler(false)
+	if got := register(t, h, compress.Zstd, compress.Gzip); got != "" {
+		t.Errorf("picked %q with compression disabled, want plain", got)
+	}
+
+	// A device that didn't register asks for a compressed response
+	req := httptest.NewRequest(http.MethodPost, "/iot/sensor",
+		strings.NewReader(`{"device_id": "dev1", "sensor_type": "temperature", "value": 21.5, "unit": "celsius"}`))
+	req.Header.Set("Accept-Encoding", "zstd, gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("status %d, Content-Encoding %q, want a plain 200", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+	if !json.Valid(rec.Body.Bytes()) {
+		t.Errorf("response %q isn't plain JSON", rec.Body)
+	}
+
+	// and one sends a compressed body anyway
+	rec = postReading(t, h, compress.Gzip)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("compressed body answered %d, want %d", rec.Code, http.StatusUnsupportedMediaType)
+	}
+	if got := rec.Header().Get("Accept-Encoding"); got != "identity" {
+		t.Errorf("Accept-Encoding %q, want identity", got)
+	}
+}
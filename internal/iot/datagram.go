@@ -0,0 +1,175 @@
+package iot
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// DatagramPath opens an unreliable sensor reading flow. The device sends a
+// POST without a body over HTTP/3 and, once it gets a 200, sends each
+// reading as an HTTP datagram (RFC 9297) on the request stream. Closing the
+// request stream ends the flow.
+const DatagramPath = "/iot/datagrams"
+
+// MaxDatagramSize bounds an enveloped reading. Larger datagrams could not
+// cross a minimum QUIC path MTU and are dropped.
+const MaxDatagramSize = 1024
+
+// datagramVersion is the first byte of every datagram envelope
+const datagramVersion = 1
+
+// datagramHeaderLen is the version byte and a 4-byte sequence number
+const datagramHeaderLen = 5
+
+// EncodeDatagram envelopes a reading: a version byte, the sequence number of
+// the reading in its flow, big endian, and the reading as JSON. The sequence
+// lets the server count lost datagrams.
+func EncodeDatagram(seq uint32, data SensorData) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, datagramHeaderLen, datagramHeaderLen+len(payload))
+	b[0] = datagramVersion
+	binary.BigEndian.PutUint32(b[1:], seq)
+	return append(b, payload...), nil
+}
+
+// DecodeDatagram reverses EncodeDatagram
+func DecodeDatagram(b []byte) (uint32, SensorData, error) {
+	var data SensorData
+	if len(b) < datagramHeaderLen {
+		return 0, data, errors.New("datagram too short")
+	}
+	if b[0] != datagramVersion {
+		return 0, data, fmt.Errorf("unknown datagram version %d", b[0])
+	}
+	if err := json.Unmarshal(b[datagramHeaderLen:], &data); err != nil {
+		return 0, data, fmt.Errorf("invalid sensor data: %w", err)
+	}
+	return binary.BigEndian.Uint32(b[1:]), data, nil
+}
+
+// handleDatagrams accepts a datagram flow and processes its readings until
+// the device closes the request stream
+func (h *Handler) handleDatagrams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	deviceID := r.Header.Get("X-Device-ID")
+	if !h.authorize(w, r, deviceID) {
+		return
+	}
+	hijacker, ok := w.(http3.Hijacker)
+	if !ok {
+		http.Error(w, "Datagrams require HTTP/3", http.StatusBadRequest)
+		return
+	}
+	conn := hijacker.Connection()
+	select {
+	case <-conn.ReceivedSettings():
+	case <-r.Context().Done():
+		return
+	}
+	if !conn.Settings().EnableDatagrams || !conn.ConnectionState().SupportsDatagrams {
+		http.Error(w, "Client did not enable HTTP datagrams", http.StatusBadRequest)
+		return
+	}
+
+	// The flow outlives any request body timeout
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	w.WriteHeader(http.StatusOK)
+	str := w.(http3.HTTPStreamer).HTTPStream()
+	defer str.Close()
+
+	// The device ends the flow by closing its side of the stream
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		io.Copy(io.Discard, str)
+		cancel()
+	}()
+
+	log := h.logger.With(logging.F("device_id", deviceID))
+	log.Debug("Datagram flow opened")
+	var received, lost int64
+	var next uint32
+	for {
+		b, err := str.ReceiveDatagram(ctx)
+		if err != nil {
+			break
+		}
+		h.stats.message("datagram")
+		if len(b) > MaxDatagramSize {
+			h.metrics.datagrams.WithLabelValues("oversized").Inc()
+			h.stats.dropped.Add(1)
+			continue
+		}
+		seq, data, err := DecodeDatagram(b)
+		if err != nil {
+			h.metrics.datagrams.WithLabelValues("invalid").Inc()
+			h.stats.dropped.Add(1)
+			log.Debug("Dropped invalid datagram", logging.Err(err))
+			continue
+		}
+		// Datagrams carry no response, so a rejected reading is dropped. The
+		// flow was authenticated for its own device only.
+		if h.auth != nil && data.DeviceID != deviceID {
+			h.metrics.datagrams.WithLabelValues("unauthenticated").Inc()
+			h.stats.dropped.Add(1)
+			continue
+		}
+		h.observeClock(data)
+		data = h.correctTimestamp(data)
+		if h.validator != nil {
+			if err := h.validator.Check(data); err != nil {
+				h.metrics.invalid.WithLabelValues(data.SensorType, err.(*InvalidReadingError).Field).Inc()
+				h.metrics.datagrams.WithLabelValues("invalid").Inc()
+				h.stats.dropped.Add(1)
+				continue
+			}
+		}
+		if h.limiter != nil {
+			if ok, _ := h.limiter.Allow(data.DeviceID); !ok {
+				h.metrics.throttled.WithLabelValues(data.DeviceID).Inc()
+				h.metrics.datagrams.WithLabelValues("throttled").Inc()
+				h.stats.throttled.Add(1)
+				continue
+			}
+		}
+		if h.quotas.Devices.Add(data.DeviceID, int64(len(b))) == quota.Reject {
+			h.metrics.datagrams.WithLabelValues("over_quota").Inc()
+			h.stats.dropped.Add(1)
+			continue
+		}
+		if seq > next {
+			lost += int64(seq - next)
+			h.metrics.datagrams.WithLabelValues("lost").Add(float64(seq - next))
+		}
+		next = max(next, seq+1)
+		received++
+
+		h.metrics.datagrams.WithLabelValues("received").Inc()
+		h.metrics.sensorReadings.WithLabelValues(data.SensorType).Inc()
+		h.stats.accepted.Add(1)
+		h.aggregate(data)
+		h.publish(data)
+		h.checkSequence(data)
+		h.markSeen(data.DeviceID)
+		log.Debug("Received sensor datagram", logging.F("sensor_type", data.SensorType),
+			logging.F("value", data.Value), logging.F("seq", seq))
+	}
+	log.Debug("Datagram flow closed", logging.F("received", received), logging.F("lost", lost))
+}
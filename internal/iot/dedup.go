@@ -0,0 +1,144 @@
+package iot
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MessageIDHeader carries an ID a device gives a sensor reading, batch or
+// command, so that a retry of a message the server already processed, e.g.
+// after its response was lost, is acknowledged without being processed
+// again. IDs are scoped to the device.
+const MessageIDHeader = "X-IoT-Message-ID"
+
+// maxMessageIDLen bounds the IDs kept per message
+const maxMessageIDLen = 128
+
+// Deduplicator remembers the IDs of recently processed messages. It holds at
+// most size IDs, evicting the least recently seen one when full, and forgets
+// an ID once it hasn't been seen for ttl.
+type Deduplicator struct {
+	size  int
+	ttl   time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	entries map[dedupKey]*list.Element
+	recency *list.List // of *dedupEntry, most recently seen first
+
+	cached prometheus.Gauge
+}
+
+type dedupKey struct {
+	deviceID  string
+	messageID string
+}
+
+type dedupEntry struct {
+	key  dedupKey
+	seen time.Time
+}
+
+// NewDeduplicator creates a deduplicator with the size and TTL of cfg
+func NewDeduplicator(cfg config.DedupConfig, c clock.Clock, reg *metrics.Registry) *Deduplicator {
+	return &Deduplicator{
+		size:    cfg.Size,
+		ttl:     cfg.TTL,
+		clock:   c,
+		entries: make(map[dedupKey]*list.Element),
+		recency: list.New(),
+		cached:  reg.Gauge("iot", "dedup_message_ids", "Message IDs remembered to detect retried messages"),
+	}
+}
+
+// Seen reports whether messageID of deviceID was remembered within the
+// TTL, and if so counts this as another sighting
+func (d *Deduplicator) Seen(deviceID, messageID string) bool {
+	now := d.clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	e, ok := d.entries[dedupKey{deviceID, messageID}]
+	if !ok {
+		return false
+	}
+	e.Value.(*dedupEntry).seen = now
+	d.recency.MoveToFront(e)
+	return true
+}
+
+// Remember records that messageID of deviceID was processed
+func (d *Deduplicator) Remember(deviceID, messageID string) {
+	now := d.clock.Now()
+	key := dedupKey{deviceID, messageID}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.expire(now)
+	if e, ok := d.entries[key]; ok {
+		e.Value.(*dedupEntry).seen = now
+		d.recency.MoveToFront(e)
+		return
+	}
+	if d.recency.Len() >= d.size {
+		d.remove(d.recency.Back())
+	}
+	d.entries[key] = d.recency.PushFront(&dedupEntry{key: key, seen: now})
+	d.cached.Set(float64(d.recency.Len()))
+}
+
+// Len returns the number of remembered IDs
+func (d *Deduplicator) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.recency.Len()
+}
+
+// expire forgets the IDs not seen for the TTL. The least recently seen are
+// at the back, so it stops at the first one still fresh.
+func (d *Deduplicator) expire(now time.Time) {
+	for e := d.recency.Back(); e != nil && now.Sub(e.Value.(*dedupEntry).seen) >= d.ttl; e = d.recency.Back() {
+		d.remove(e)
+	}
+	d.cached.Set(float64(d.recency.Len()))
+}
+
+func (d *Deduplicator) remove(e *list.Element) {
+	delete(d.entries, e.Value.(*dedupEntry).key)
+	d.recency.Remove(e)
+}
+
+// messageID reads the MessageIDHeader of r, writing an error response if
+// it is too long
+func messageID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := r.Header.Get(MessageIDHeader)
+	if len(id) > maxMessageIDLen {
+		http.Error(w, fmt.Sprintf("%s longer than %d bytes", MessageIDHeader, maxMessageIDLen), http.StatusBadRequest)
+		return "", false
+	}
+	return id, true
+}
+
+// duplicate reports whether a message of deviceID with id was already
+// processed. Messages without an ID are never duplicates.
+func (h *Handler) duplicate(deviceID, id, endpoint string) bool {
+	if h.dedup == nil || id == "" || !h.dedup.Seen(deviceID, id) {
+		return false
+	}
+	h.metrics.duplicates.WithLabelValues(endpoint).Inc()
+	return true
+}
+
+// processed records that a message of deviceID with id was processed
+func (h *Handler) processed(deviceID, id string) {
+	if h.dedup != nil && id != "" {
+		h.dedup.Remember(deviceID, id)
+	}
+}
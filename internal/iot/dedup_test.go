@@ -0,0 +1,98 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestDeduplicatorForgetsAfterTTL(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDeduplicator(config.DedupConfig{Size: 10, TTL: time.Minute}, fake, metrics.NewRegistry())
+
+	d.Remember("dev1", "m1")
+	d.Remember("dev1", "m2")
+	fake.Advance(50 * time.Second)
+	// A retry refreshes its ID
+	if !d.Seen("dev1", "m1") {
+		t.Fatal("m1 forgotten within the TTL")
+	}
+	if d.Seen("dev2", "m1") {
+		t.Error("IDs are scoped to their device")
+	}
+
+	fake.Advance(10 * time.Second)
+	if d.Seen("dev1", "m2") {
+		t.Error("m2 remembered a TTL after it was last seen")
+	}
+	if !d.Seen("dev1", "m1") {
+		t.Error("refreshed m1 forgotten")
+	}
+	if d.Len() != 1 {
+		t.Errorf("%d IDs remembered, want 1", d.Len())
+	}
+}
+
+func TestDeduplicatorEvictsLeastRecentlySeen(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	d := NewDeduplicator(config.DedupConfig{Size: 2, TTL: time.Hour}, fake, metrics.NewRegistry())
+
+	d.Remember("dev1", "m1")
+	d.Remember("dev1", "m2")
+	d.Seen("dev1", "m1")
+	d.Remember("dev1", "m3")
+	if d.Seen("dev1", "m2") || !d.Seen("dev1", "m1") || !d.Seen("dev1", "m3") {
+		t.Error("full deduplicator did not evict the least recently seen ID")
+	}
+}
+
+// TestRetriedMessagesAcknowledgedOnce checks that a reading, batch or
+// command sent again with the same message ID is acknowledged without being
+// processed again
+func TestRetriedMessagesAcknowledgedOnce(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	messages := []struct {
+		endpoint, target, body, duplicate string
+	}{
+		{"sensor", "/iot/sensor", reading("dev1", 21), "Duplicate"},
+		{"batch", "/iot/batch", "[" + reading("dev1", 21) + ", " + reading("dev1", 22) + "]", "Duplicate batch"},
+		{"command", "/iot/command", `{"device_id": "dev1", "action": "reboot"}`, "already executed"},
+	}
+	for _, m := range messages {
+		for try := 1; try <= 2; try++ {
+			rec := send(t, h, http.MethodPost, m.target, m.body, MessageIDHeader, m.endpoint+"-1")
+			var resp Response
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s try %d: status %d, want 200", m.endpoint, try, rec.Code)
+			}
+			if retried := strings.Contains(resp.Message, m.duplicate); retried != (try == 2) {
+				t.Errorf("%s try %d: message %q", m.endpoint, try, resp.Message)
+			}
+		}
+		if got := metricValue(t, reg, `commsys_iot_duplicate_messages_total{endpoint="`+m.endpoint+`"}`); got != "1" {
+			t.Errorf("duplicate %s messages = %s, want 1", m.endpoint, got)
+		}
+	}
+
+	if s := h.Stats(); s.Readings.Accepted != 3 || s.Readings.Duplicate != 3 {
+		t.Errorf("got %d readings accepted and %d duplicates, want 3 and 3", s.Readings.Accepted, s.Readings.Duplicate)
+	}
+	// The same ID from another device is a different message
+	send(t, h, http.MethodPost, "/iot/sensor", reading("dev2", 21), MessageIDHeader, "sensor-1")
+	if s := h.Stats(); s.Readings.Accepted != 4 {
+		t.Errorf("a reading of dev2 with an ID dev1 used was not accepted")
+	}
+}
@@ -0,0 +1,237 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AlertLowBattery is the kind of alert raised when a device's battery falls
+// below iot.device_health.low_battery
+const AlertLowBattery = "low_battery"
+
+// HealthReport is sent by a device to POST /iot/health to report its own
+// health, apart from its sensor readings
+type HealthReport struct {
+	DeviceID   string   `json:"device_id"`
+	Battery    *float64 `json:"battery_percent,omitempty"` // nil for devices not on battery
+	RSSI       int      `json:"rssi_dbm"`                  // received signal strength
+	Uptime     int64    `json:"uptime_seconds"`
+	FreeMemory int64    `json:"free_memory_bytes"`
+}
+
+// validate checks that the report's values are in range
+func (r HealthReport) validate() error {
+	if r.DeviceID == "" {
+		return fmt.Errorf("device_id is required")
+	}
+	if r.Battery != nil && (*r.Battery < 0 || *r.Battery > 100) {
+		return fmt.Errorf("battery_percent %.1f out of range [0, 100]", *r.Battery)
+	}
+	if r.Uptime < 0 || r.FreeMemory < 0 {
+		return fmt.Errorf("uptime_seconds and free_memory_bytes must not be negative")
+	}
+	return nil
+}
+
+// DeviceHealth is the last health report of a device
+type DeviceHealth struct {
+	HealthReport
+	ReportedAt time.Time `json:"reported_at"`
+	LowBattery bool      `json:"low_battery"`
+	Stale      bool      `json:"stale"` // a battery device that hasn't reported for iot.device_health.stale_after
+}
+
+// DeviceHealthTracker keeps the last health report of each device. It
+// raises a low-battery alert when a device's battery falls below the
+// threshold, and again only after the battery was recharged above it.
+// Devices on battery that stop reporting their health are stale, which
+// Presence counts as offline. Devices that send no report for the idle
+// timeout are forgotten.
+type DeviceHealthTracker struct {
+	lowBattery float64
+	staleAfter time.Duration
+	alerts     *Alerts
+	clock      clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*DeviceHealth
+	sweep   idleSweep
+	subs    []chan DeviceHealth
+
+	reports       prometheus.Counter
+	lowBatteryNow prometheus.Gauge
+	dropped       prometheus.Counter
+}
+
+// NewDeviceHealthTracker creates a tracker with the thresholds of cfg that
+// raises its alerts on alerts, which may be nil, and forgets devices silent
+// for idle, unless it is 0
+func NewDeviceHealthTracker(cfg config.DeviceHealthConfig, idle time.Duration, alerts *Alerts, c clock.Clock, reg *metrics.Registry) *DeviceHealthTracker {
+	return &DeviceHealthTracker{
+		lowBattery:    cfg.LowBattery,
+		staleAfter:    cfg.StaleAfter,
+		alerts:        alerts,
+		clock:         c,
+		devices:       make(map[string]*DeviceHealth),
+		sweep:         idleSweep{after: idle},
+		reports:       reg.Counter("iot", "health_reports_total", "Device health reports received"),
+		lowBatteryNow: reg.Gauge("iot", "devices_low_battery", "Devices whose last health report was below iot.device_health.low_battery"),
+		dropped:       reg.Counter("iot", "health_updates_dropped_total", "Health updates not passed to a subscriber that was behind"),
+	}
+}
+
+// Report records the health report of a device and returns its health
+func (t *DeviceHealthTracker) Report(report HealthReport) DeviceHealth {
+	now := t.clock.Now()
+	t.reports.Inc()
+
+	t.mu.Lock()
+	sweepIdle(&t.sweep, t.devices, now, func(d *DeviceHealth) time.Time { return d.ReportedAt }, func(d *DeviceHealth) {
+		if d.LowBattery {
+			t.lowBatteryNow.Dec()
+		}
+	})
+	d, ok := t.devices[report.DeviceID]
+	if !ok {
+		d = &DeviceHealth{}
+		t.devices[report.DeviceID] = d
+	}
+	wasLow := d.LowBattery
+	d.HealthReport, d.ReportedAt = report, now
+	d.LowBattery = report.Battery != nil && *report.Battery < t.lowBattery
+	switch {
+	case d.LowBattery && !wasLow:
+		t.lowBatteryNow.Inc()
+	case !d.LowBattery && wasLow:
+		t.lowBatteryNow.Dec()
+	}
+	health := *d
+	for _, ch := range t.subs {
+		select {
+		case ch <- health:
+		default:
+			t.dropped.Inc()
+		}
+	}
+	t.mu.Unlock()
+
+	if health.LowBattery && !wasLow {
+		t.alerts.Raise(Alert{
+			Kind:     AlertLowBattery,
+			DeviceID: report.DeviceID,
+			Message:  fmt.Sprintf("battery at %.1f%%, below %.1f%%", *report.Battery, t.lowBattery),
+			Value:    *report.Battery,
+			At:       now,
+		})
+	}
+	return health
+}
+
+// Subscribe returns a channel that receives the health of every device
+// that reports from now on. It holds up to buffer updates the subscriber
+// hasn't taken yet; further ones are dropped.
+func (t *DeviceHealthTracker) Subscribe(buffer int) <-chan DeviceHealth {
+	ch := make(chan DeviceHealth, buffer)
+	t.mu.Lock()
+	t.subs = append(t.subs, ch)
+	t.mu.Unlock()
+	return ch
+}
+
+// Device returns the last health of deviceID
+func (t *DeviceHealthTracker) Device(deviceID string) (DeviceHealth, bool) {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[deviceID]
+	if !ok {
+		return DeviceHealth{}, false
+	}
+	health := *d
+	health.Stale = t.stale(d, now)
+	return health, true
+}
+
+// List returns the last health of every device that reported, ordered by
+// device ID
+func (t *DeviceHealthTracker) List() []DeviceHealth {
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]DeviceHealth, 0, len(t.devices))
+	for _, d := range t.devices {
+		health := *d
+		health.Stale = t.stale(d, now)
+		out = append(out, health)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// Stale reports whether deviceID is on battery and hasn't reported its
+// health for the stale_after of the tracker at now. Devices that never
+// reported, or report no battery, are never stale. A nil tracker has no
+// stale devices.
+func (t *DeviceHealthTracker) Stale(deviceID string, now time.Time) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[deviceID]
+	return ok && t.stale(d, now)
+}
+
+func (t *DeviceHealthTracker) stale(d *DeviceHealth, now time.Time) bool {
+	return t.staleAfter > 0 && d.Battery != nil && now.Sub(d.ReportedAt) > t.staleAfter
+}
+
+// WithDeviceHealth records the health reports of devices in t
+func WithDeviceHealth(t *DeviceHealthTracker) Option {
+	return func(h *Handler) {
+		h.deviceHealth = t
+	}
+}
+
+// handleHealth records a device's health report. It counts as a sign of
+// life, and brings a device that went offline for a stale report back
+// online.
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var report HealthReport
+	if _, ok := h.decode(w, r, &report, "health"); !ok {
+		return
+	}
+	if !h.authorize(w, r, report.DeviceID) {
+		return
+	}
+	if h.deviceHealth == nil {
+		http.Error(w, "Device health reports are disabled", http.StatusNotFound)
+		return
+	}
+	if err := report.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	health := h.deviceHealth.Report(report)
+	h.markSeen(report.DeviceID)
+
+	response := Response{Status: "success", Message: "Device health received"}
+	if health.LowBattery {
+		response.Message = "Device health received, battery low"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,122 @@
+package iot
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// battery returns a pointer to percent, for HealthReport.Battery
+func battery(percent float64) *float64 {
+	return &percent
+}
+
+func TestDeviceHealthForgetsIdleDevices(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewDeviceHealthTracker(config.Default().IoT.DeviceHealth, time.Hour, nil, c, reg)
+	if !tracker.Report(HealthReport{DeviceID: "idle", Battery: battery(5)}).LowBattery {
+		t.Fatal("a battery at 5% isn't low")
+	}
+
+	c.Advance(2 * time.Hour)
+	for i := 0; i < idleSweepEvery; i++ {
+		tracker.Report(HealthReport{DeviceID: "busy", Battery: battery(80)})
+	}
+	if _, ok := tracker.Device("idle"); ok {
+		t.Error("a device silent for longer than the idle timeout is still tracked")
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_low_battery"); got != "0" {
+		t.Errorf("iot_devices_low_battery = %s after the low device was forgotten, want 0", got)
+	}
+}
+
+// TestLowBatteryAlertRaisedOnce checks that a low battery raises one alert
+// until the battery is reported above the threshold again
+func TestLowBatteryAlertRaisedOnce(t *testing.T) {
+	reg := metrics.NewRegistry()
+	alerts := NewAlerts(logging.Nop(), reg)
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewDeviceHealthTracker(config.DeviceHealthConfig{LowBattery: 20}, 0, alerts, c, reg)
+	updates := tracker.Subscribe(10)
+
+	steps := []struct {
+		battery float64
+		low     bool
+		alerts  int
+		gauge   string
+	}{
+		{50, false, 0, "0"},
+		{15, true, 1, "1"},
+		{10, true, 1, "1"},
+		{60, false, 1, "0"},
+		{12, true, 2, "1"},
+	}
+	for _, step := range steps {
+		c.Advance(time.Minute)
+		if got := tracker.Report(HealthReport{DeviceID: "dev1", Battery: battery(step.battery)}); got.LowBattery != step.low {
+			t.Errorf("battery at %v%%: low %v, want %v", step.battery, got.LowBattery, step.low)
+		}
+		if got := len(alerts.Recent()); got != step.alerts {
+			t.Errorf("battery at %v%%: %d alerts, want %d", step.battery, got, step.alerts)
+		}
+		if got := metricValue(t, reg, "commsys_iot_devices_low_battery"); got != step.gauge {
+			t.Errorf("battery at %v%%: iot_devices_low_battery = %s, want %s", step.battery, got, step.gauge)
+		}
+	}
+	if first := alerts.Recent()[1]; first.Kind != AlertLowBattery || first.DeviceID != "dev1" || first.Value != 15 {
+		t.Errorf("first alert %+v, want a low_battery alert of dev1 at 15%%", first)
+	}
+	if len(updates) != len(steps) {
+		t.Errorf("subscriber got %d updates, want %d", len(updates), len(steps))
+	}
+}
+
+// TestStaleHealthTakesDeviceOffline checks that a battery device whose
+// health reports stop goes offline even while it sends readings, and that
+// its next report brings it back
+func TestStaleHealthTakesDeviceOffline(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	tracker := NewDeviceHealthTracker(cfg.IoT.DeviceHealth, 0, nil, fake, reg)
+	presence := NewPresence(cfg.IoT.Heartbeat, tracker, logging.Nop(), fake, reg)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg),
+		WithClock(fake), WithPresence(presence), WithDeviceHealth(tracker))
+
+	send(t, h, http.MethodPost, "/iot/health", `{"device_id": "battery", "battery_percent": 80}`)
+	send(t, h, http.MethodPost, "/iot/health", `{"device_id": "mains", "rssi_dbm": -60}`)
+	for elapsed := time.Duration(0); elapsed <= cfg.IoT.DeviceHealth.StaleAfter; elapsed += cfg.IoT.Heartbeat.Interval {
+		fake.Advance(cfg.IoT.Heartbeat.Interval)
+		send(t, h, http.MethodPost, "/iot/sensor", reading("battery", 21))
+		send(t, h, http.MethodPost, "/iot/sensor", reading("mains", 21))
+		presence.sweep(fake.Now())
+	}
+
+	if d := presenceOf(t, presence, "battery"); d.Online || d.Reason != "health_stale" {
+		t.Errorf("battery device without health reports got %+v, want offline for stale health", d)
+	}
+	if d := presenceOf(t, presence, "mains"); !d.Online {
+		t.Errorf("device not on battery got %+v, want online", d)
+	}
+	if d, _ := tracker.Device("battery"); !d.Stale {
+		t.Error("health of the battery device isn't stale")
+	}
+
+	if rec := send(t, h, http.MethodPost, "/iot/health", `{"device_id": "battery", "battery_percent": 79}`); rec.Code != http.StatusOK {
+		t.Fatalf("health report: status %d", rec.Code)
+	}
+	if d := presenceOf(t, presence, "battery"); !d.Online {
+		t.Errorf("battery device got %+v after a health report, want online", d)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/health", `{"device_id": "battery", "battery_percent": 120}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("battery at 120%%: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,227 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Limits on the metadata a device registers with
+const (
+	maxDeviceLabels   = 32
+	maxLabelKeyLen    = 63
+	maxLabelValueLen  = 256
+	maxDeviceCommands = 64
+)
+
+// ErrUnsupportedCommand is returned for a command whose action the device
+// didn't declare in its supported commands
+var ErrUnsupportedCommand = errors.New("command not supported by the device")
+
+// DeviceInfo describes a device and what it can do, as declared at
+// registration
+type DeviceInfo struct {
+	SensorType      string            `json:"sensor_type,omitempty"` // type of the readings the device sends
+	FirmwareVersion string            `json:"firmware_version,omitempty"`
+	HardwareModel   string            `json:"hardware_model,omitempty"`
+	Commands        []string          `json:"commands,omitempty"` // actions the device accepts; empty accepts any
+	BatteryPowered  bool              `json:"battery_powered,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+// validate checks the limits on labels and commands
+func (i DeviceInfo) validate() error {
+	if len(i.Labels) > maxDeviceLabels {
+		return fmt.Errorf("more than %d labels", maxDeviceLabels)
+	}
+	for k, v := range i.Labels {
+		if k == "" || len(k) > maxLabelKeyLen || strings.ContainsAny(k, "=,") {
+			return fmt.Errorf("invalid label key %q: must be 1-%d bytes without '=' or ','", k, maxLabelKeyLen)
+		}
+		if len(v) > maxLabelValueLen {
+			return fmt.Errorf("label %s longer than %d bytes", k, maxLabelValueLen)
+		}
+	}
+	if len(i.Commands) > maxDeviceCommands {
+		return fmt.Errorf("more than %d commands", maxDeviceCommands)
+	}
+	for _, c := range i.Commands {
+		if c == "" {
+			return errors.New("empty command name")
+		}
+	}
+	return nil
+}
+
+// Device is a registered device
+type Device struct {
+	DeviceID string `json:"device_id"`
+	DeviceInfo
+	RegisteredAt time.Time `json:"registered_at"` // last registration
+}
+
+// Supports reports whether the device accepts commands with action
+func (d Device) Supports(action string) bool {
+	return len(d.Commands) == 0 || slices.Contains(d.Commands, action)
+}
+
+// Matches reports whether the device has every label of selector
+func (d Device) Matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := d.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// DeviceRegistry keeps the metadata devices registered with. A device
+// registering again replaces its metadata.
+type DeviceRegistry struct {
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.RWMutex
+	devices map[string]*Device
+
+	registered prometheus.Gauge
+}
+
+// NewDeviceRegistry creates a registry without devices
+func NewDeviceRegistry(logger logging.Logger, c clock.Clock, reg *metrics.Registry) *DeviceRegistry {
+	return &DeviceRegistry{
+		logger:     logger,
+		clock:      c,
+		devices:    make(map[string]*Device),
+		registered: reg.Gauge("iot", "devices_registered", "Devices that registered their metadata"),
+	}
+}
+
+// Register records the metadata of deviceID
+func (r *DeviceRegistry) Register(deviceID string, info DeviceInfo) {
+	d := &Device{DeviceID: deviceID, DeviceInfo: info, RegisteredAt: r.clock.Now()}
+	d.Commands = slices.Clone(info.Commands)
+	if info.Labels != nil {
+		d.Labels = make(map[string]string, len(info.Labels))
+		for k, v := range info.Labels {
+			d.Labels[k] = v
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.devices[deviceID]; !ok {
+		r.registered.Inc()
+	}
+	r.devices[deviceID] = d
+	r.logger.Debug("Device described", logging.F("device_id", deviceID),
+		logging.F("hardware_model", info.HardwareModel), logging.F("firmware", info.FirmwareVersion))
+}
+
+// Get returns the metadata of deviceID
+func (r *DeviceRegistry) Get(deviceID string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[deviceID]
+	if !ok {
+		return Device{}, false
+	}
+	return *d, true
+}
+
+// List returns the devices with every label of selector, ordered by device
+// ID. An empty selector returns every device.
+func (r *DeviceRegistry) List(selector map[string]string) []Device {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Device, 0, len(r.devices))
+	for _, d := range r.devices {
+		if d.Matches(selector) {
+			out = append(out, *d)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// CheckCommand returns an error wrapping ErrUnsupportedCommand if cmd's
+// device declared its commands and cmd.Action isn't one of them. Commands
+// to devices that never registered are let through.
+func (r *DeviceRegistry) CheckCommand(cmd Command) error {
+	if r == nil {
+		return nil
+	}
+	d, ok := r.Get(cmd.DeviceID)
+	if !ok || d.Supports(cmd.Action) {
+		return nil
+	}
+	return fmt.Errorf("%w: device %s accepts %s, not %q", ErrUnsupportedCommand, cmd.DeviceID, strings.Join(d.Commands, ", "), cmd.Action)
+}
+
+// WithDevices records the metadata devices register with in r, and refuses
+// commands they don't support
+func WithDevices(r *DeviceRegistry) Option {
+	return func(h *Handler) {
+		h.devices = r
+	}
+}
+
+// ParseLabelSelector parses label=key=value query parameters into the
+// selector of DeviceRegistry.List
+func ParseLabelSelector(query url.Values) (map[string]string, error) {
+	selector := make(map[string]string)
+	for _, label := range query["label"] {
+		k, v, ok := strings.Cut(label, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("label filter must be key=value, got %q", label)
+		}
+		selector[k] = v
+	}
+	return selector, nil
+}
+
+// listRegisteredDevices answers GET /iot/devices with the registered
+// devices, filtered by label=key=value query parameters
+func (h *Handler) listRegisteredDevices(w http.ResponseWriter, r *http.Request) {
+	selector, err := ParseLabelSelector(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	devices := h.devices.List(selector)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"devices": devices,
+		"count":   len(devices),
+	})
+}
+
+// registerDevice records the metadata of a device, if devices are tracked
+func (h *Handler) registerDevice(deviceID string, info DeviceInfo) {
+	if h.devices != nil {
+		h.devices.Register(deviceID, info)
+	}
+}
+
+// checkCommand answers 422 and returns false if the device doesn't support
+// cmd
+func (h *Handler) checkCommand(w http.ResponseWriter, cmd Command) bool {
+	if err := h.devices.CheckCommand(cmd); err != nil {
+		h.logger.Warn("Unsupported command refused", logging.F("device_id", cmd.DeviceID),
+			logging.F("action", cmd.Action))
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return false
+	}
+	return true
+}
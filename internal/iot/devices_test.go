@@ -0,0 +1,125 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newTestDevices creates a handler keeping device metadata in the returned
+// registry
+func newTestDevices(t *testing.T) (*Handler, *DeviceRegistry, *metrics.Registry) {
+	t.Helper()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	devices := NewDeviceRegistry(logging.Nop(), clock.Real(), reg)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithDevices(devices))
+	return h, devices, reg
+}
+
+func TestRegisteredDevicesFilteredByLabel(t *testing.T) {
+	h, _, reg := newTestDevices(t)
+	for _, body := range []string{
+		`{"device_id": "dev1", "min_version": 1, "max_version": 1, "hardware_model": "th-1", "labels": {"site": "berlin", "floor": "2"}}`,
+		`{"device_id": "dev2", "min_version": 1, "max_version": 1, "labels": {"site": "berlin", "floor": "3"}}`,
+		`{"device_id": "dev3", "min_version": 1, "max_version": 1, "labels": {"site": "paris"}}`,
+	} {
+		if rec := send(t, h, http.MethodPost, "/iot/register", body); rec.Code != http.StatusOK {
+			t.Fatalf("registration %s: status %d", body, rec.Code)
+		}
+	}
+	// Registering again replaces the metadata rather than adding a device
+	send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev3", "min_version": 1, "max_version": 1, "labels": {"site": "berlin", "floor": "2"}}`)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"dev1", "dev2", "dev3"}},
+		{"?label=site=berlin", []string{"dev1", "dev2", "dev3"}},
+		{"?label=site=berlin&label=floor=2", []string{"dev1", "dev3"}},
+		{"?label=site=paris", nil},
+	}
+	for _, tt := range tests {
+		rec := send(t, h, http.MethodGet, "/iot/devices"+tt.query, "")
+		var resp struct {
+			Devices []Device `json:"devices"`
+			Count   int      `json:"count"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		var got []string
+		for _, d := range resp.Devices {
+			got = append(got, d.DeviceID)
+		}
+		if len(got) != len(tt.want) || resp.Count != len(tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+				break
+			}
+		}
+		if tt.query == "" && resp.Devices[0].HardwareModel != "th-1" {
+			t.Errorf("dev1 listed with hardware model %q, want th-1", resp.Devices[0].HardwareModel)
+		}
+	}
+
+	if rec := send(t, h, http.MethodGet, "/iot/devices?label=site", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("label filter without a value: status %d, want 400", rec.Code)
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_registered"); got != "3" {
+		t.Errorf("iot_devices_registered = %s, want 3", got)
+	}
+}
+
+func TestRegistrationRejectsInvalidMetadata(t *testing.T) {
+	h, devices, _ := newTestDevices(t)
+	for _, body := range []string{
+		`{"device_id": "dev1", "min_version": 1, "max_version": 1, "labels": {"a=b": "c"}}`,
+		`{"device_id": "dev1", "min_version": 1, "max_version": 1, "commands": ["reboot", ""]}`,
+	} {
+		if rec := send(t, h, http.MethodPost, "/iot/register", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("registration %s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if _, ok := devices.Get("dev1"); ok {
+		t.Error("device with invalid metadata was registered")
+	}
+}
+
+func TestUnsupportedCommandRefused(t *testing.T) {
+	h, devices, _ := newTestDevices(t)
+	send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev1", "min_version": 1, "max_version": 1, "commands": ["reboot", "calibrate"]}`)
+	send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev2", "min_version": 1, "max_version": 1}`)
+
+	tests := []struct {
+		deviceID, action string
+		want             int
+	}{
+		{"dev1", "reboot", http.StatusOK},
+		{"dev1", "set_interval", http.StatusUnprocessableEntity},
+		// Devices that declared no commands, or never registered, take any
+		{"dev2", "set_interval", http.StatusOK},
+		{"dev3", "set_interval", http.StatusOK},
+	}
+	for _, tt := range tests {
+		body := `{"device_id": "` + tt.deviceID + `", "action": "` + tt.action + `"}`
+		if rec := send(t, h, http.MethodPost, "/iot/command", body); rec.Code != tt.want {
+			t.Errorf("%s to %s: status %d, want %d", tt.action, tt.deviceID, rec.Code, tt.want)
+		}
+	}
+	if err := devices.CheckCommand(Command{DeviceID: "dev1", Action: "set_interval"}); err == nil {
+		t.Error("CheckCommand let an undeclared action through")
+	}
+}
@@ -0,0 +1,359 @@
+package iot
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// FirmwareHeader carries the version of a firmware update offered to a
+// device on IoT responses until the device reports its outcome. The device
+// fetches the offer with GET /iot/firmware, downloads the image chunk by
+// chunk from GET /iot/firmware/chunks/{n} and reports the result with POST
+// /iot/firmware/result.
+const FirmwareHeader = "X-IoT-Firmware"
+
+// FirmwareChunkHeader carries the sequence number of a firmware chunk
+const FirmwareChunkHeader = "X-IoT-Firmware-Chunk"
+
+// Firmware update states of a device
+const (
+	FirmwareOffered      = "offered"       // the device has not fetched a chunk yet
+	FirmwareTransferring = "transferring"  // chunks before Acked have been received
+	FirmwareInstalled    = "installed"     // the device verified the image
+	FirmwareRejected     = "rejected"      // the device declined the offer, e.g. a downgrade
+	FirmwareHashMismatch = "hash_mismatch" // the received image didn't match the offered hash
+)
+
+// Errors returned by FirmwareUpdates
+var (
+	ErrFirmwareTooLarge  = errors.New("firmware image exceeds iot.firmware.max_image_bytes")
+	ErrNoFirmwareOffered = errors.New("no firmware update offered to device")
+)
+
+// FirmwareOffer describes a firmware image offered to a device. A device
+// resuming an interrupted transfer continues at NextChunk.
+type FirmwareOffer struct {
+	Version   string `json:"version"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256"` // hex
+	ChunkSize int64  `json:"chunk_size"`
+	Chunks    int    `json:"chunks"`
+	NextChunk int    `json:"next_chunk"` // first chunk not acknowledged
+}
+
+// FirmwareResult is sent by a device to POST /iot/firmware/result once it
+// has verified the image, or to decline the offer
+type FirmwareResult struct {
+	DeviceID string `json:"device_id"`
+	Version  string `json:"version"`
+	Status   string `json:"status"` // FirmwareInstalled, FirmwareRejected or FirmwareHashMismatch
+	Reason   string `json:"reason,omitempty"`
+}
+
+// FirmwareStatus is the progress of the firmware update of one device
+type FirmwareStatus struct {
+	DeviceID  string    `json:"device_id"`
+	Version   string    `json:"version"`
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Chunks    int       `json:"chunks"`
+	Acked     int       `json:"acked"` // chunks the device has acknowledged
+	State     string    `json:"state"`
+	Reason    string    `json:"reason,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// active reports whether the update still waits for the device
+func (s *FirmwareStatus) active() bool {
+	return s.State == FirmwareOffered || s.State == FirmwareTransferring
+}
+
+type firmwareImage struct {
+	data   []byte
+	sha256 string
+}
+
+// FirmwareUpdates holds the firmware images offered to devices and the
+// progress of each device's transfer. Images are kept in memory.
+type FirmwareUpdates struct {
+	logger    logging.Logger
+	clock     clock.Clock
+	chunkSize int64
+	maxBytes  int64
+
+	mu      sync.Mutex
+	images  map[string]*firmwareImage // by version
+	devices map[string]*FirmwareStatus
+
+	outcomes *metrics.CounterVec
+}
+
+// NewFirmwareUpdates creates an empty firmware update registry
+func NewFirmwareUpdates(cfg config.FirmwareConfig, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *FirmwareUpdates {
+	return &FirmwareUpdates{
+		logger:    logger,
+		clock:     c,
+		chunkSize: cfg.ChunkSize,
+		maxBytes:  cfg.MaxImageBytes,
+		images:    make(map[string]*firmwareImage),
+		devices:   make(map[string]*FirmwareStatus),
+		outcomes:  reg.CounterVec("iot", "firmware_updates_total", "Firmware updates by outcome", "outcome"),
+	}
+}
+
+// StartFirmwareUpdate offers the firmware image read from image to deviceID
+// as version, replacing any update the device has not finished. Images are
+// shared by version, so offering a version again with another image fails.
+func (f *FirmwareUpdates) StartFirmwareUpdate(deviceID string, image io.Reader, version string) (FirmwareStatus, error) {
+	if deviceID == "" || version == "" {
+		return FirmwareStatus{}, fmt.Errorf("device ID and version are required")
+	}
+	data, err := io.ReadAll(io.LimitReader(image, f.maxBytes+1))
+	if err != nil {
+		return FirmwareStatus{}, fmt.Errorf("read firmware image: %w", err)
+	}
+	if int64(len(data)) > f.maxBytes {
+		return FirmwareStatus{}, ErrFirmwareTooLarge
+	}
+	if len(data) == 0 {
+		return FirmwareStatus{}, fmt.Errorf("firmware image is empty")
+	}
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if img, ok := f.images[version]; ok && img.sha256 != hash {
+		return FirmwareStatus{}, fmt.Errorf("firmware version %s was already offered with another image", version)
+	}
+	f.images[version] = &firmwareImage{data: data, sha256: hash}
+
+	now := f.clock.Now()
+	status := &FirmwareStatus{
+		DeviceID:  deviceID,
+		Version:   version,
+		Size:      int64(len(data)),
+		SHA256:    hash,
+		Chunks:    int((int64(len(data)) + f.chunkSize - 1) / f.chunkSize),
+		State:     FirmwareOffered,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	f.devices[deviceID] = status
+	f.logger.Info("Firmware update offered", logging.F("device_id", deviceID), logging.F("version", version),
+		logging.F("bytes", status.Size), logging.F("chunks", status.Chunks))
+	return *status, nil
+}
+
+// List returns the firmware update of every device ordered by device ID
+func (f *FirmwareUpdates) List() []FirmwareStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := make([]FirmwareStatus, 0, len(f.devices))
+	for _, s := range f.devices {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// offered returns the version offered to deviceID while its update is active
+func (f *FirmwareUpdates) offered(deviceID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.devices[deviceID]
+	if !ok || !s.active() {
+		return "", false
+	}
+	return s.Version, true
+}
+
+// offer returns the active offer of deviceID
+func (f *FirmwareUpdates) offer(deviceID string) (FirmwareOffer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.devices[deviceID]
+	if !ok || !s.active() {
+		return FirmwareOffer{}, ErrNoFirmwareOffered
+	}
+	return FirmwareOffer{
+		Version:   s.Version,
+		Size:      s.Size,
+		SHA256:    s.SHA256,
+		ChunkSize: f.chunkSize,
+		Chunks:    s.Chunks,
+		NextChunk: s.Acked,
+	}, nil
+}
+
+// chunk returns chunk n of the image offered to deviceID as version.
+// Requesting chunk n acknowledges the chunks before it.
+func (f *FirmwareUpdates) chunk(deviceID, version string, n int) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.devices[deviceID]
+	if !ok || !s.active() || s.Version != version {
+		return nil, ErrNoFirmwareOffered
+	}
+	if n < 0 || n >= s.Chunks {
+		return nil, fmt.Errorf("chunk %d out of range, the image has %d", n, s.Chunks)
+	}
+	s.State = FirmwareTransferring
+	s.Acked = max(s.Acked, n)
+	s.UpdatedAt = f.clock.Now()
+	data := f.images[version].data
+	start := int64(n) * f.chunkSize
+	return data[start:min(start+f.chunkSize, int64(len(data)))], nil
+}
+
+// finish records the outcome reported by a device
+func (f *FirmwareUpdates) finish(result FirmwareResult) (FirmwareStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.devices[result.DeviceID]
+	if !ok || !s.active() || s.Version != result.Version {
+		return FirmwareStatus{}, ErrNoFirmwareOffered
+	}
+	switch result.Status {
+	case FirmwareInstalled:
+		s.Acked = s.Chunks
+	case FirmwareRejected, FirmwareHashMismatch:
+	default:
+		return FirmwareStatus{}, fmt.Errorf("unknown firmware result %q", result.Status)
+	}
+	s.State, s.Reason = result.Status, result.Reason
+	s.UpdatedAt = f.clock.Now()
+	f.outcomes.WithLabelValues(result.Status).Inc()
+
+	fields := []logging.Field{logging.F("device_id", s.DeviceID), logging.F("version", s.Version), logging.F("state", s.State)}
+	if result.Reason != "" {
+		fields = append(fields, logging.F("reason", result.Reason))
+	}
+	if s.State == FirmwareInstalled {
+		f.logger.Info("Firmware update finished", fields...)
+	} else {
+		f.logger.Warn("Firmware update failed", fields...)
+	}
+	return *s, nil
+}
+
+// WithFirmware enables firmware updates of devices
+func WithFirmware(f *FirmwareUpdates) Option {
+	return func(h *Handler) {
+		h.firmware = f
+	}
+}
+
+// setFirmwareOffer adds the firmware version offered to deviceID to the
+// response headers
+func (h *Handler) setFirmwareOffer(w http.ResponseWriter, deviceID string) {
+	if h.firmware == nil || deviceID == "" {
+		return
+	}
+	if version, ok := h.firmware.offered(deviceID); ok {
+		w.Header().Set(FirmwareHeader, version)
+	}
+}
+
+// handleFirmware serves the firmware update of the device named by the
+// X-Device-ID header:
+//
+//	GET  /iot/firmware             the offer, with the chunk to resume at
+//	GET  /iot/firmware/chunks/{n}  chunk n of the offered version
+//	POST /iot/firmware/result      the device's verdict, as FirmwareResult
+func (h *Handler) handleFirmware(w http.ResponseWriter, r *http.Request, parts []string) {
+	id := r.Header.Get("X-Device-ID")
+	if len(parts) > 1 && parts[1] == "result" {
+		h.handleFirmwareResult(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r, id) {
+		return
+	}
+	if h.firmware == nil {
+		http.Error(w, "Firmware updates are disabled", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		offer, err := h.firmware.offer(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(offer)
+	case len(parts) == 3 && parts[1] == "chunks":
+		n, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.Error(w, "Invalid chunk number", http.StatusBadRequest)
+			return
+		}
+		version := r.URL.Query().Get("version")
+		chunk, err := h.firmware.chunk(id, version, n)
+		if errors.Is(err, ErrNoFirmwareOffered) {
+			// The offer may have been replaced by a newer version
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set(FirmwareChunkHeader, strconv.Itoa(n))
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(chunk))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *Handler) handleFirmwareResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var result FirmwareResult
+	if _, ok := h.decode(w, r, &result, "firmware result"); !ok {
+		return
+	}
+	if !h.authorize(w, r, result.DeviceID) {
+		return
+	}
+	if h.firmware == nil {
+		http.Error(w, "Firmware updates are disabled", http.StatusNotFound)
+		return
+	}
+	status, err := h.firmware.finish(result)
+	if errors.Is(err, ErrNoFirmwareOffered) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// The update is over, so the offer set before it was recorded is stale
+	w.Header().Del(FirmwareHeader)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success", Message: "Firmware result recorded", Data: status})
+}
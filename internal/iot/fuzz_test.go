@@ -0,0 +1,96 @@
+package iot
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func FuzzDecodeDatagram(f *testing.F) {
+	b, _ := EncodeDatagram(7, SensorData{DeviceID: "dev1", SensorType: "temperature", Value: 21.5, Unit: "C"})
+	f.Add(b)
+	f.Add([]byte{datagramVersion, 0, 0, 0, 1})
+	f.Add([]byte{datagramVersion, 0, 0, 0, 1, 'n', 'u', 'l', 'l'})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		seq, data, err := DecodeDatagram(b)
+		if err != nil {
+			return
+		}
+		again, err := EncodeDatagram(seq, data)
+		if err != nil {
+			return // e.g. NaN isn't valid JSON
+		}
+		seq2, data2, err := DecodeDatagram(again)
+		if err != nil || seq2 != seq || data2.DeviceID != data.DeviceID || data2.SensorType != data.SensorType {
+			t.Fatalf("re-encoded datagram decodes to %d %+v, %v; want %d %+v", seq2, data2, err, seq, data)
+		}
+	})
+}
+
+func FuzzDecodeDelta(f *testing.F) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var readings []SensorData
+	for i := 0; i < 5; i++ {
+		readings = append(readings, SensorData{DeviceID: "dev1", SensorType: "temperature", Unit: "C",
+			Value: 20 + float64(i)/10, Timestamp: start.Add(time.Duration(i) * time.Second)})
+	}
+	b, err := EncodeDelta(readings, 0.01)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b)
+	f.Add(b[:len(b)-1])
+	f.Add([]byte{0, 0, 0, 0})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		readings, err := DecodeDelta(b)
+		if err != nil {
+			return
+		}
+		// Every reading after the first takes at least two bytes
+		if len(readings) == 0 || len(readings) > len(b) {
+			t.Fatalf("%d readings from %d bytes", len(readings), len(b))
+		}
+	})
+}
+
+// FuzzHandlerMessages posts arbitrary bodies and protocol headers to the
+// JSON endpoints. Rejections are fine; panics and server errors aren't.
+func FuzzHandlerMessages(f *testing.F) {
+	cfg := config.Default()
+	cfg.IoT.OrderWait = time.Millisecond
+	cfg.IoT.MaxMessageBytes = 4 << 10
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+	endpoints := []string{"/iot/sensor", "/iot/command", "/iot/register", "/iot/batch", "/iot/state"}
+
+	f.Add(uint8(0), "", "", []byte(`{"device_id": "dev1", "sensor_type": "temperature", "value": 21.5, "unit": "C"}`))
+	f.Add(uint8(1), "2", "1", []byte(`{"device_id": "dev1", "action": "reboot", "priority": "high", "trace_id": "abc"}`))
+	f.Add(uint8(2), "", "", []byte(`{"device_id": "dev1", "protocol_version": 2}`))
+	f.Add(uint8(3), "1", "", []byte(`[{"device_id": "dev1", "sensor_type": "humidity", "value": 40}]`))
+	f.Add(uint8(4), "", "2", []byte(`{"device_id": "dev1", "version": 1, "properties": {"interval": "30s"}}`))
+	f.Add(uint8(0), "99", "x", []byte(`{"device_id": 1, "value": "hot"}`))
+	f.Add(uint8(1), "", "", bytes.Repeat([]byte("["), 10000))
+	f.Fuzz(func(t *testing.T, endpoint uint8, version, seq string, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, endpoints[int(endpoint)%len(endpoints)], bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if version != "" {
+			req.Header.Set(VersionHeader, version)
+		}
+		if seq != "" {
+			req.Header.Set(SeqHeader, seq)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusInternalServerError {
+			t.Fatalf("POST %s: status %d: %s", req.URL.Path, rec.Code, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package iot
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReadingSeqHeader numbers the readings of a delta batch, which has no room
+// for sequence numbers. "<epoch>:<first>" gives the readings of the batch
+// the consecutive numbers from first on, in epoch.
+const ReadingSeqHeader = "X-IoT-Reading-Seq"
+
+// FormatReadingSeq returns the ReadingSeqHeader of a batch whose readings
+// are numbered from first in epoch
+func FormatReadingSeq(epoch, first uint64) string {
+	return strconv.FormatUint(epoch, 10) + ":" + strconv.FormatUint(first, 10)
+}
+
+// numberReadings gives readings the sequence numbers of a ReadingSeqHeader.
+// An empty header leaves them unnumbered.
+func numberReadings(header string, readings []SensorData) error {
+	if header == "" {
+		return nil
+	}
+	e, f, ok := strings.Cut(header, ":")
+	epoch, err1 := strconv.ParseUint(e, 10, 64)
+	first, err2 := strconv.ParseUint(f, 10, 64)
+	if !ok || err1 != nil || err2 != nil || first == 0 {
+		return fmt.Errorf("invalid %s header %q", ReadingSeqHeader, header)
+	}
+	for i := range readings {
+		readings[i].Seq, readings[i].Epoch = first+uint64(i), epoch
+	}
+	return nil
+}
+
+// gapWindow is how far below the highest sequence number of a device a late
+// reading can still be told apart from a duplicate
+const gapWindow = 1024
+
+// SequenceCounts counts what the sequence numbers of readings reveal. A
+// missing reading that arrives late, within gapWindow of the highest
+// sequence number, is no longer counted as missing.
+type SequenceCounts struct {
+	Received   int64 `json:"received"`
+	Missing    int64 `json:"missing"`
+	Duplicates int64 `json:"duplicates"`
+	OutOfOrder int64 `json:"out_of_order"` // late readings, including ones from an earlier epoch
+	Resets     int64 `json:"resets"`       // epochs started after the first
+}
+
+func (c *SequenceCounts) add(o SequenceCounts) {
+	c.Received += o.Received
+	c.Missing += o.Missing
+	c.Duplicates += o.Duplicates
+	c.OutOfOrder += o.OutOfOrder
+	c.Resets += o.Resets
+}
+
+// SequenceStats is the sequence state of a device
+type SequenceStats struct {
+	DeviceID string `json:"device_id"`
+	Epoch    uint64 `json:"epoch"`
+	LastSeq  uint64 `json:"last_seq"` // highest sequence number of the epoch
+	SequenceCounts
+}
+
+// SequenceReport holds the sequence stats of every device and their totals
+type SequenceReport struct {
+	Totals  SequenceCounts  `json:"totals"`
+	Devices []SequenceStats `json:"devices"`
+}
+
+// deviceSequence is the sequence state of one device
+type deviceSequence struct {
+	stats SequenceStats
+	base  uint64                 // lowest sequence number tracked in the epoch
+	seen  [gapWindow / 64]uint64 // bit s%gapWindow is set once s, within gapWindow of LastSeq, arrived
+	last  time.Time              // when the device last registered or sent a numbered reading
+}
+
+// GapTracker detects lost, duplicated and reordered readings from the
+// sequence numbers devices stamp on them. A device numbers its readings
+// from 1 in each epoch, so a device that restarts its numbering, e.g. after
+// a reboot, does so with a new epoch rather than looking like a huge gap.
+// Readings before the tracker first heard of a device are not counted as
+// missing. A device silent for the idle timeout is forgotten; its counts
+// stay in the totals of Report.
+type GapTracker struct {
+	clock clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*deviceSequence
+	sweep   idleSweep
+	retired SequenceCounts // counts of the forgotten devices
+
+	readings *metrics.CounterVec
+	missing  prometheus.Gauge
+}
+
+// NewGapTracker creates a tracker that has seen no devices. Devices silent
+// for idle are forgotten; 0 keeps them.
+func NewGapTracker(idle time.Duration, c clock.Clock, reg *metrics.Registry) *GapTracker {
+	return &GapTracker{
+		clock:    c,
+		devices:  make(map[string]*deviceSequence),
+		sweep:    idleSweep{after: idle},
+		readings: reg.CounterVec("iot", "sequenced_readings_total", "Readings with a sequence number by outcome", "outcome"),
+		missing:  reg.Gauge("iot", "readings_missing", "Sequenced readings not received, across devices"),
+	}
+}
+
+// Register starts a new epoch for deviceID if epoch differs from the
+// device's current one. A device registering again in the same epoch, e.g.
+// after a reconnect, keeps its state, so readings lost meanwhile count as
+// missing. Epoch 0 leaves the state alone.
+func (t *GapTracker) Register(deviceID string, epoch uint64) {
+	if epoch == 0 {
+		return
+	}
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[deviceID]
+	if !ok {
+		return
+	}
+	d.last = now
+	if d.stats.Epoch != epoch {
+		d.restart(epoch)
+	}
+}
+
+// Observe checks the sequence number of a reading. Readings without one
+// are ignored.
+func (t *GapTracker) Observe(data SensorData) {
+	if data.Seq == 0 {
+		return
+	}
+	now := t.clock.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sweepIdle(&t.sweep, t.devices, now, func(d *deviceSequence) time.Time { return d.last }, func(d *deviceSequence) {
+		t.retired.add(d.stats.SequenceCounts)
+	})
+	d, ok := t.devices[data.DeviceID]
+	if !ok {
+		d = &deviceSequence{
+			stats: SequenceStats{DeviceID: data.DeviceID, Epoch: data.Epoch, LastSeq: data.Seq - 1},
+			base:  data.Seq,
+		}
+		t.devices[data.DeviceID] = d
+	}
+	d.last = now
+	d.stats.Received++
+
+	switch {
+	case data.Epoch > d.stats.Epoch:
+		d.restart(data.Epoch)
+	case data.Epoch < d.stats.Epoch:
+		// Sent before the device's numbering restarted
+		d.stats.OutOfOrder++
+		t.readings.WithLabelValues("out_of_order").Inc()
+		return
+	}
+
+	last := d.stats.LastSeq
+	switch {
+	case data.Seq > last:
+		outcome := "in_order"
+		if gap := data.Seq - last - 1; gap > 0 {
+			d.stats.Missing += int64(gap)
+			t.missing.Add(float64(gap))
+			outcome = "after_gap"
+		}
+		d.advance(data.Seq)
+		t.readings.WithLabelValues(outcome).Inc()
+	case data.Seq < d.base || last-data.Seq >= gapWindow:
+		// Too old to tell whether it was missing or is a duplicate
+		d.stats.OutOfOrder++
+		t.readings.WithLabelValues("out_of_order").Inc()
+	case d.has(data.Seq):
+		d.stats.Duplicates++
+		t.readings.WithLabelValues("duplicate").Inc()
+	default:
+		d.mark(data.Seq)
+		d.stats.Missing--
+		t.missing.Dec()
+		d.stats.OutOfOrder++
+		t.readings.WithLabelValues("out_of_order").Inc()
+	}
+}
+
+// Device returns the sequence stats of deviceID
+func (t *GapTracker) Device(deviceID string) (SequenceStats, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.devices[deviceID]
+	if !ok {
+		return SequenceStats{}, false
+	}
+	return d.stats, true
+}
+
+// Report returns the sequence stats of every device, ordered by device ID,
+// and their totals
+func (t *GapTracker) Report() SequenceReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	report := SequenceReport{Totals: t.retired, Devices: make([]SequenceStats, 0, len(t.devices))}
+	for _, d := range t.devices {
+		report.Devices = append(report.Devices, d.stats)
+		report.Totals.add(d.stats.SequenceCounts)
+	}
+	sort.Slice(report.Devices, func(i, j int) bool { return report.Devices[i].DeviceID < report.Devices[j].DeviceID })
+	return report
+}
+
+// restart starts epoch, whose readings are numbered from 1. Readings
+// missing from the previous epoch stay counted.
+func (d *deviceSequence) restart(epoch uint64) {
+	d.stats.Epoch, d.stats.LastSeq = epoch, 0
+	d.stats.Resets++
+	d.base = 1
+	d.seen = [gapWindow / 64]uint64{}
+}
+
+// advance moves the highest sequence number up to seq, forgetting the
+// numbers that fall out of the window
+func (d *deviceSequence) advance(seq uint64) {
+	if seq-d.stats.LastSeq >= gapWindow {
+		d.seen = [gapWindow / 64]uint64{}
+	} else {
+		for s := d.stats.LastSeq + 1; s < seq; s++ {
+			d.seen[s%gapWindow/64] &^= 1 << (s % 64)
+		}
+	}
+	d.stats.LastSeq = seq
+	d.mark(seq)
+}
+
+func (d *deviceSequence) mark(seq uint64) {
+	d.seen[seq%gapWindow/64] |= 1 << (seq % 64)
+}
+
+func (d *deviceSequence) has(seq uint64) bool {
+	return d.seen[seq%gapWindow/64]&(1<<(seq%64)) != 0
+}
+
+// WithGapTracker checks the sequence numbers of accepted readings in t
+func WithGapTracker(t *GapTracker) Option {
+	return func(h *Handler) {
+		h.gaps = t
+	}
+}
+
+// checkSequence passes an accepted reading to the gap tracker, if there is
+// one
+func (h *Handler) checkSequence(data SensorData) {
+	if h.gaps != nil {
+		h.gaps.Observe(data)
+	}
+}
+
+// registerEpoch tells the gap tracker, if there is one, the epoch a device
+// registered with
+func (h *Handler) registerEpoch(deviceID string, epoch uint64) {
+	if h.gaps != nil {
+		h.gaps.Register(deviceID, epoch)
+	}
+}
@@ -0,0 +1,85 @@
+package iot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// observeSeqs passes readings of deviceID numbered seqs in epoch to t
+func observeSeqs(t *GapTracker, deviceID string, epoch uint64, seqs ...uint64) {
+	for _, seq := range seqs {
+		t.Observe(SensorData{DeviceID: deviceID, SensorType: SensorTemperature, Seq: seq, Epoch: epoch})
+	}
+}
+
+func TestGapTrackerInOrderStreamHasNoGaps(t *testing.T) {
+	reg := metrics.NewRegistry()
+	g := NewGapTracker(time.Hour, clock.NewFake(time.Unix(0, 0)), reg)
+	for seq := uint64(1); seq <= 100; seq++ {
+		observeSeqs(g, "dev1", 1, seq)
+	}
+
+	stats, ok := g.Device("dev1")
+	if !ok {
+		t.Fatal("dev1 isn't tracked")
+	}
+	want := SequenceStats{DeviceID: "dev1", Epoch: 1, LastSeq: 100, SequenceCounts: SequenceCounts{Received: 100}}
+	if stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+	if got := metricValue(t, reg, "commsys_iot_readings_missing"); got != "0" {
+		t.Errorf("iot_readings_missing = %s, want 0", got)
+	}
+}
+
+func TestGapTrackerReportsGaps(t *testing.T) {
+	reg := metrics.NewRegistry()
+	g := NewGapTracker(time.Hour, clock.NewFake(time.Unix(0, 0)), reg)
+	// 3, 4 and 7 are lost, then 4 arrives late and 5 twice
+	observeSeqs(g, "dev1", 1, 1, 2, 5, 6, 8, 4, 5)
+
+	stats, _ := g.Device("dev1")
+	want := SequenceCounts{Received: 7, Missing: 2, Duplicates: 1, OutOfOrder: 1}
+	if stats.SequenceCounts != want || stats.LastSeq != 8 {
+		t.Errorf("got %+v, want %+v up to 8", stats, want)
+	}
+	if got := metricValue(t, reg, "commsys_iot_readings_missing"); got != "2" {
+		t.Errorf("iot_readings_missing = %s, want 2", got)
+	}
+	if got := metricValue(t, reg, `commsys_iot_sequenced_readings_total{outcome="after_gap"}`); got != "2" {
+		t.Errorf("after_gap readings = %s, want 2", got)
+	}
+
+	// A new epoch starts the count over instead of looking like a gap
+	observeSeqs(g, "dev1", 2, 1, 2)
+	stats, _ = g.Device("dev1")
+	if stats.Epoch != 2 || stats.LastSeq != 2 || stats.Missing != 2 || stats.Resets != 1 {
+		t.Errorf("after a new epoch got %+v, want epoch 2 up to 2 with 2 missing and 1 reset", stats)
+	}
+}
+
+func TestGapTrackerForgetsIdleDevices(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	g := NewGapTracker(time.Hour, c, metrics.NewRegistry())
+	observeSeqs(g, "idle", 1, 1, 3)
+
+	c.Advance(2 * time.Hour)
+	for i := 0; i < idleSweepEvery; i++ {
+		observeSeqs(g, "busy", 1, uint64(i+1))
+	}
+
+	if _, ok := g.Device("idle"); ok {
+		t.Error("a device silent for longer than the idle timeout is still tracked")
+	}
+	if _, ok := g.Device("busy"); !ok {
+		t.Error("an active device was forgotten")
+	}
+	report := g.Report()
+	if len(report.Devices) != 1 || report.Totals.Received != idleSweepEvery+2 || report.Totals.Missing != 1 {
+		t.Errorf("got %d devices and totals %+v, want 1 device and the forgotten counts kept in the totals",
+			len(report.Devices), report.Totals)
+	}
+}
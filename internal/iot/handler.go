@@ -1,89 +1,323 @@
 package iot
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // SensorData represents sensor readings
 type SensorData struct {
-	DeviceID    string    `json:"device_id"`
-	SensorType  string    `json:"sensor_type"`
-	Value       float64   `json:"value"`
-	Unit        string    `json:"unit"`
-	Timestamp   time.Time `json:"timestamp"`
-	Quality     string    `json:"quality"` // "reliable" or "unreliable"
+	DeviceID        string     `json:"device_id"`
+	SensorType      string     `json:"sensor_type"`
+	Value           float64    `json:"value"`
+	Unit            string     `json:"unit"`
+	Timestamp       time.Time  `json:"timestamp"`
+	DeviceTimestamp *time.Time `json:"device_timestamp,omitempty"` // as stamped by the device, when Timestamp was corrected for its clock
+	Quality         string     `json:"quality"`                    // "reliable" or "unreliable"
+	Seq             uint64     `json:"seq,omitempty"`              // numbers the device's readings from 1 in each epoch, 0 if unnumbered
+	Epoch           uint64     `json:"epoch,omitempty"`            // changes when the device restarts its numbering
 }
 
 // Command represents a device command
 type Command struct {
-	DeviceID  string                 `json:"device_id"`
-	Action    string                 `json:"action"`
+	ID         string                 `json:"id,omitempty"` // set on commands queued for the device to fetch
+	DeviceID   string                 `json:"device_id"`
+	Action     string                 `json:"action"`
 	Parameters map[string]interface{} `json:"parameters"`
-	Priority  string                 `json:"priority"` // "high", "medium", "low"
+	Priority   string                 `json:"priority"`           // "high", "medium", "low"
+	TraceID    string                 `json:"trace_id,omitempty"` // correlates the device response with server spans
 }
 
 // Response represents a command response
 type Response struct {
-	CommandID string `json:"command_id"`
-	Status    string `json:"status"`
-	Message   string `json:"message"`
+	CommandID string      `json:"command_id"`
+	Status    string      `json:"status"`
+	Message   string      `json:"message"`
 	Data      interface{} `json:"data,omitempty"`
+	TraceID   string      `json:"trace_id,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"` // echoes the SeqHeader of the request
 }
 
 // Handler handles IoT HTTP requests
-func Handler(w http.ResponseWriter, r *http.Request) {
+type Handler struct {
+	logger  logging.Logger
+	tracer  trace.Tracer
+	metrics handlerMetrics
+	health  *health.Registry
+	quotas  *quota.Manager
+	clock   clock.Clock
+	uploads *UploadStore  // nil when uploads are disabled
+	auth    Authenticator // nil trusts every device ID
+
+	migrations    *Migrations     // nil when migration is disabled
+	sampling      *SamplingPolicy // nil when adaptive sampling is disabled
+	order         *Sequencer
+	dedup         *Deduplicator        // nil when retried messages are not detected
+	limiter       *RateLimiter         // nil when messages are not rate limited
+	validator     *Validator           // nil when readings are not validated
+	aggregates    *Aggregator          // nil when readings are not aggregated
+	twins         *Twins               // nil when device twins are disabled
+	firmware      *FirmwareUpdates     // nil when firmware updates are disabled
+	presence      *Presence            // nil when device presence is not tracked
+	deviceHealth  *DeviceHealthTracker // nil when device health reports are disabled
+	outbox        *Outbox              // nil when no commands are sent to devices
+	gaps          *GapTracker          // nil when reading sequence numbers are not checked
+	devices       *DeviceRegistry      // nil when device metadata is not kept
+	subscriptions *Subscriptions       // nil when readings can't be subscribed to
+	clockSkew     *ClockSkew           // nil when timestamps are not corrected for device clocks
+	sessions      *Sessions            // nil when connections are not followed
+	compression   *compression         // nil when bodies are never compressed
+	publishers    []Publisher          // receive every accepted reading
+	stats         *handlerStats
+	anomalies     *Anomalies
+
+	maxMessageBytes int64
+	maxViolations   int   // oversized messages before a connection is closed
+	maxBatch        int   // readings per sensor batch
+	uploadPace      int64 // bytes per second, advertised at registration
+
+	versionsMu    sync.RWMutex
+	versions      map[string]*negotiatedVersion // by device ID
+	versionsSweep idleSweep
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(h *Handler) {
+		h.clock = c
+	}
+}
+
+type handlerMetrics struct {
+	requests       *metrics.CounterVec
+	sensorReadings *metrics.CounterVec
+	commands       *metrics.CounterVec
+	commandHops    *metrics.HistogramVec
+	datagrams      *metrics.CounterVec
+	oversized      prometheus.Counter
+	authFailed     prometheus.Counter
+	throttled      *metrics.CounterVec
+	invalid        *metrics.CounterVec
+	peersClosed    prometheus.Counter
+	duplicates     *metrics.CounterVec
+}
+
+// NewHandler creates a new IoT handler
+func NewHandler(cfg config.IoTConfig, logger logging.Logger, reg *metrics.Registry, healthReg *health.Registry, quotas *quota.Manager, opts ...Option) *Handler {
+	h := &Handler{
+		logger:          logger,
+		tracer:          tracing.Tracer("iot"),
+		health:          healthReg,
+		quotas:          quotas,
+		clock:           clock.Real(),
+		maxMessageBytes: cfg.MaxMessageBytes,
+		maxViolations:   cfg.MaxViolations,
+		maxBatch:        cfg.MaxBatch,
+		uploadPace:      cfg.UploadPace,
+		versions:        make(map[string]*negotiatedVersion),
+		versionsSweep:   idleSweep{after: cfg.ForgetAfter},
+		metrics: handlerMetrics{
+			requests:       reg.CounterVec("iot", "requests_total", "IoT API requests by endpoint", "endpoint"),
+			sensorReadings: reg.CounterVec("iot", "sensor_readings_total", "Sensor readings received by sensor type", "sensor_type"),
+			commands:       reg.CounterVec("iot", "commands_total", "Device commands received by priority", "priority"),
+			commandHops: reg.HistogramVec("iot", "command_hop_seconds", "Server-side time of each command hop: receive, queue or process",
+				prometheus.ExponentialBuckets(0.0001, 4, 10), "hop"),
+			datagrams:   reg.CounterVec("iot", "datagrams_total", "Sensor datagrams by outcome", "outcome"),
+			oversized:   reg.Counter("iot", "oversized_messages_total", "Messages rejected for exceeding max_message_bytes"),
+			peersClosed: reg.Counter("iot", "oversized_peers_closed_total", "Connections closed for repeatedly sending oversized messages"),
+			authFailed:  reg.Counter("iot", "unauthenticated_total", "Requests refused for a missing or invalid device token"),
+			throttled:   reg.CounterVec("iot", "throttled_messages_total", "Sensor messages rejected for exceeding the device's message rate", "device_id"),
+			invalid:     reg.CounterVec("iot", "invalid_readings_total", "Sensor readings rejected by validation by sensor type and field", "sensor_type", "field"),
+			duplicates:  reg.CounterVec("iot", "duplicate_messages_total", "Retried messages acknowledged without being processed again by endpoint", "endpoint"),
+		},
+	}
+	if len(cfg.DeviceTokens) > 0 {
+		h.auth = StaticTokens(cfg.DeviceTokens)
+	}
+	if cfg.Compression.Enabled {
+		h.compression = newCompression(cfg.Compression, reg)
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if cfg.Sampling.Enabled {
+		h.sampling = NewSamplingPolicy(cfg.Sampling, cfg.ForgetAfter, logger.Named("sampling"), h.clock)
+	}
+	h.order = NewSequencer(cfg.OrderWait, cfg.Heartbeat.Timeout, h.clock, reg)
+	h.stats = newHandlerStats(h.clock.Now())
+	if cfg.Dedup.Size > 0 {
+		h.dedup = NewDeduplicator(cfg.Dedup, h.clock, reg)
+	}
+	if h.limiter == nil {
+		h.limiter = NewRateLimiter(cfg, h.clock)
+	}
+	if cfg.Validation.Enabled {
+		h.validator = NewValidator(cfg.Validation, h.clock)
+	}
+	return h
+}
+
+// ServeHTTP routes IoT requests to the matching endpoint
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse the URL path
 	path := strings.TrimPrefix(r.URL.Path, "/iot/")
 	parts := strings.Split(path, "/")
-	
+
 	if len(parts) == 0 {
 		http.Error(w, "Invalid IoT endpoint", http.StatusBadRequest)
 		return
 	}
 
+	ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := h.tracer.Start(ctx, "iot."+parts[0], trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	h.metrics.requests.WithLabelValues(parts[0]).Inc()
+	h.stats.message(parts[0])
+
+	version, err := h.requestVersion(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r = r.WithContext(WithVersion(ctx, version))
+	w, done, ok := h.negotiateCompression(w, r, parts[0])
+	if !ok {
+		return
+	}
+	defer done()
+	if parts[0] != "migrate" {
+		h.setReconnect(w, r)
+	}
+	h.setDesired(w, r.Header.Get("X-Device-ID"))
+	h.setFirmwareOffer(w, r.Header.Get("X-Device-ID"))
+	h.setPendingCommands(w, r.Header.Get("X-Device-ID"))
+
 	switch parts[0] {
+	case "register":
+		h.handleRegister(w, r)
 	case "sensor":
-		handleSensorData(w, r)
+		h.handleSensorData(w, r)
+	case "batch":
+		h.handleBatch(w, r)
 	case "command":
-		handleCommand(w, r)
+		h.handleCommand(w, r)
+	case "commands":
+		h.handleCommands(w, r, parts)
 	case "devices":
-		handleDeviceList(w, r)
+		h.handleDeviceList(w, r)
 	case "simulate":
-		handleSimulation(w, r)
+		h.handleSimulation(w, r)
+	case "upload":
+		h.handleUpload(w, r)
+	case "migrate":
+		h.handleMigrate(w, r)
+	case "datagrams":
+		h.handleDatagrams(w, r)
+	case "twin":
+		h.handleTwin(w, r)
+	case "state":
+		h.handleState(w, r)
+	case "firmware":
+		h.handleFirmware(w, r, parts)
+	case "heartbeat":
+		h.handleHeartbeat(w, r)
+	case "health":
+		h.handleHealth(w, r)
+	case "subscribe":
+		h.handleSubscribe(w, r)
 	default:
 		http.Error(w, "Unknown IoT endpoint", http.StatusNotFound)
 	}
 }
 
-func handleSensorData(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleSensorData(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
 		// Return simulated sensor data
-		sensors := generateSensorData()
+		sensors := generateSensorData(h.clock.Now())
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(sensors)
 	case http.MethodPost:
 		// Accept sensor data from devices
 		var data SensorData
-		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-			http.Error(w, "Invalid sensor data", http.StatusBadRequest)
+		n, ok := h.decode(w, r, &data, "sensor data")
+		if !ok {
+			return
+		}
+		if !h.authorize(w, r, data.DeviceID) {
 			return
 		}
-		
-		log.Printf("Received sensor data: %+v", data)
-		
+		msgID, ok := messageID(w, r)
+		if !ok {
+			return
+		}
+
 		response := Response{
 			Status:  "success",
 			Message: "Sensor data received",
 		}
-		
+		ok = h.ordered(w, r, data.DeviceID, func(seq uint64) bool {
+			response.Seq = seq
+			if h.duplicate(data.DeviceID, msgID, "sensor") {
+				h.stats.duplicate.Add(1)
+				response.Message = "Duplicate sensor data ignored"
+				return true
+			}
+			if !h.throttle(w, data.DeviceID) {
+				h.stats.throttled.Add(1)
+				return false
+			}
+			h.observeClock(data)
+			data = h.correctTimestamp(data)
+			if !h.validate(w, []SensorData{data}, false) {
+				return false
+			}
+			if !h.quotas.ChargeDevice(w, r, data.DeviceID, n) {
+				h.stats.dropped.Add(1)
+				return false
+			}
+			_, span := h.tracer.Start(r.Context(), "iot.process_sensor_data",
+				trace.WithAttributes(tracing.String("device_id", data.DeviceID), tracing.String("sensor_type", data.SensorType)))
+			h.logger.Debug("Received sensor data", logging.F("device_id", data.DeviceID),
+				logging.F("sensor_type", data.SensorType), logging.F("value", data.Value), logging.F("seq", seq))
+			h.metrics.sensorReadings.WithLabelValues(data.SensorType).Inc()
+			h.stats.accepted.Add(1)
+			h.aggregate(data)
+			h.publish(data)
+			h.checkSequence(data)
+			h.markSeen(data.DeviceID)
+			h.adjustInterval(w, r, data)
+			h.processed(data.DeviceID, msgID)
+			span.End()
+			return true
+		})
+		if !ok {
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	default:
@@ -91,24 +325,86 @@ func handleSensorData(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleCommand(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleCommand(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
+		// Hops are measured on the server's clock only, so the client gets
+		// the network time by subtracting them from its own round trip
+		received := h.clock.Now()
 		var cmd Command
-		if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
-			http.Error(w, "Invalid command", http.StatusBadRequest)
+		n, ok := h.decode(w, r, &cmd, "command")
+		if !ok {
 			return
 		}
-		
-		log.Printf("Received command: %+v", cmd)
-		
-		// Simulate command processing
-		response := Response{
-			CommandID: fmt.Sprintf("cmd_%d", time.Now().Unix()),
-			Status:    "executed",
-			Message:   fmt.Sprintf("Command %s executed on device %s", cmd.Action, cmd.DeviceID),
+		if !h.authorize(w, r, cmd.DeviceID) {
+			return
+		}
+		msgID, ok := messageID(w, r)
+		if !ok {
+			return
 		}
-		
+		queued := h.clock.Now()
+		hops := commandHops{receive: queued.Sub(received)}
+
+		// Trace IDs on commands were added in ProtocolV2
+		v2 := VersionFromContext(r.Context()) >= ProtocolV2
+		if cmd.TraceID != "" {
+			if err := requireVersion(r.Context(), "trace_id", ProtocolV2); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var response Response
+		ok = h.ordered(w, r, cmd.DeviceID, func(seq uint64) bool {
+			started := h.clock.Now()
+			hops.queue = started.Sub(queued)
+			defer func() { hops.process = h.clock.Now().Sub(started) }()
+			if h.duplicate(cmd.DeviceID, msgID, "command") {
+				response = Response{
+					Status:  "executed",
+					Message: fmt.Sprintf("Command %s already executed on device %s", cmd.Action, cmd.DeviceID),
+					Seq:     seq,
+				}
+				return true
+			}
+			if !h.checkCommand(w, cmd) {
+				return false
+			}
+			if !h.quotas.ChargeDevice(w, r, cmd.DeviceID, n) {
+				return false
+			}
+			ctx, span := h.tracer.Start(r.Context(), "iot.process_command",
+				trace.WithAttributes(tracing.String("device_id", cmd.DeviceID), tracing.String("action", cmd.Action)))
+			if cmd.TraceID == "" {
+				cmd.TraceID = tracing.TraceID(ctx)
+			}
+
+			h.logger.Info("Received command", logging.F("device_id", cmd.DeviceID),
+				logging.F("action", cmd.Action), logging.F("priority", cmd.Priority), logging.F("trace_id", cmd.TraceID),
+				logging.F("seq", seq))
+			h.metrics.commands.WithLabelValues(cmd.Priority).Inc()
+			h.markSeen(cmd.DeviceID)
+
+			// Simulate command processing
+			response = Response{
+				CommandID: fmt.Sprintf("cmd_%d", h.clock.Now().Unix()),
+				Status:    "executed",
+				Message:   fmt.Sprintf("Command %s executed on device %s", cmd.Action, cmd.DeviceID),
+				Seq:       seq,
+			}
+			if v2 {
+				response.TraceID = cmd.TraceID
+			}
+			h.processed(cmd.DeviceID, msgID)
+			span.End()
+			return true
+		})
+		if !ok {
+			return
+		}
+		h.recordHops(w, hops)
+
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(response)
 	default:
@@ -116,14 +412,79 @@ func handleCommand(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func handleDeviceList(w http.ResponseWriter, r *http.Request) {
+// commandHops are the server-side phases of a command: reading and decoding
+// the body, waiting for the device's earlier messages, and processing
+type commandHops struct {
+	receive time.Duration
+	queue   time.Duration
+	process time.Duration
+}
+
+// recordHops observes the hops of a completed command and reports them in
+// the Server-Timing header next to the decode time
+func (h *Handler) recordHops(w http.ResponseWriter, hops commandHops) {
+	for _, hop := range []struct {
+		name string
+		d    time.Duration
+	}{{"receive", hops.receive}, {"queue", hops.queue}, {"process", hops.process}} {
+		h.metrics.commandHops.WithLabelValues(hop.name).Observe(hop.d.Seconds())
+		w.Header().Add("Server-Timing", serverTiming(hop.name, hop.d))
+	}
+}
+
+// decode reads a JSON request body of at most maxMessageBytes into v and
+// returns the number of bytes read. It writes the error response and returns
+// false if the body is rejected. The body is read in full before decoding so
+// the reported decode time excludes the transfer.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, v interface{}, what string) (int64, bool) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.maxMessageBytes))
+	n := int64(len(data))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.rejectOversized(w, r)
+			return n, false
+		}
+		http.Error(w, "Failed to read "+what, http.StatusBadRequest)
+		return n, false
+	}
+
+	start := time.Now()
+	err = json.NewDecoder(bytes.NewReader(data)).Decode(v)
+	setDecodeTiming(w, time.Since(start))
+	if err != nil {
+		http.Error(w, "Invalid "+what, http.StatusBadRequest)
+		return n, false
+	}
+	return n, true
+}
+
+// setDecodeTiming reports the time spent decoding the request body as the
+// "decode" metric of the Server-Timing header, for clients comparing payload
+// encodings. It must be called before the response is written.
+func setDecodeTiming(w http.ResponseWriter, d time.Duration) {
+	w.Header().Set("Server-Timing", serverTiming("decode", d))
+}
+
+// serverTiming formats a Server-Timing metric with its duration in
+// milliseconds
+func serverTiming(name string, d time.Duration) string {
+	return fmt.Sprintf("%s;dur=%.3f", name, float64(d.Microseconds())/1000)
+}
+
+func (h *Handler) handleDeviceList(w http.ResponseWriter, r *http.Request) {
+	if h.devices != nil {
+		h.listRegisteredDevices(w, r)
+		return
+	}
 	devices := []map[string]interface{}{
 		{"id": "temp_01", "type": "temperature", "status": "online", "location": "room_a"},
 		{"id": "humid_01", "type": "humidity", "status": "online", "location": "room_a"},
 		{"id": "motion_01", "type": "motion", "status": "online", "location": "hallway"},
+		{"id": "press_01", "type": "pressure", "status": "online", "location": "room_a"},
 		{"id": "temp_02", "type": "temperature", "status": "offline", "location": "room_b"},
 	}
-	
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"devices": devices,
@@ -131,7 +492,7 @@ func handleDeviceList(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func handleSimulation(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleSimulation(w http.ResponseWriter, r *http.Request) {
 	// Query parameters for simulation
 	deviceCount := 10
 	if dc := r.URL.Query().Get("devices"); dc != "" {
@@ -139,19 +500,19 @@ func handleSimulation(w http.ResponseWriter, r *http.Request) {
 			deviceCount = count
 		}
 	}
-	
+
 	duration := 60 * time.Second
 	if d := r.URL.Query().Get("duration"); d != "" {
 		if dur, err := time.ParseDuration(d); err == nil {
 			duration = dur
 		}
 	}
-	
-	log.Printf("Starting IoT simulation: %d devices for %v", deviceCount, duration)
-	
+
+	h.logger.Info("Starting IoT simulation", logging.F("devices", deviceCount), logging.F("duration", duration))
+
 	// Start simulation in background
-	go runSimulation(deviceCount, duration)
-	
+	go h.runSimulation(deviceCount, duration)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":   "started",
@@ -160,8 +521,7 @@ func handleSimulation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func generateSensorData() []SensorData {
-	now := time.Now()
+func generateSensorData(now time.Time) []SensorData {
 	data := []SensorData{
 		{
 			DeviceID:   "temp_01",
@@ -172,7 +532,7 @@ func generateSensorData() []SensorData {
 			Quality:    "reliable",
 		},
 		{
-			DeviceID:   "humid_01", 
+			DeviceID:   "humid_01",
 			SensorType: "humidity",
 			Value:      40.0 + rand.Float64()*20,
 			Unit:       "percent",
@@ -187,32 +547,47 @@ func generateSensorData() []SensorData {
 			Timestamp:  now,
 			Quality:    "unreliable",
 		},
+		{
+			DeviceID:   "press_01",
+			SensorType: SensorPressure,
+			Value:      1000.0 + rand.Float64()*50, // 1000-1050 hPa
+			Unit:       SensorTypes[SensorPressure].Units[0],
+			Timestamp:  now,
+			Quality:    "reliable",
+		},
 	}
-	
+
 	return data
 }
 
-func runSimulation(deviceCount int, duration time.Duration) {
-	end := time.Now().Add(duration)
-	ticker := time.NewTicker(time.Second)
+func (h *Handler) runSimulation(deviceCount int, duration time.Duration) {
+	end := h.clock.Now().Add(duration)
+	ticker := h.clock.NewTicker(time.Second)
 	defer ticker.Stop()
-	
-	for time.Now().Before(end) {
+
+	// Each simulation is its own component so overlapping runs don't share a beat
+	name := fmt.Sprintf("iot.simulation.%d", h.clock.Now().UnixNano())
+	beat := h.health.Register(name, 5*time.Second, 0)
+	defer h.health.Unregister(name)
+
+	for h.clock.Now().Before(end) {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			beat.Beat(0)
 			for i := 0; i < deviceCount; i++ {
 				data := SensorData{
 					DeviceID:   fmt.Sprintf("sim_device_%d", i),
-					SensorType: []string{"temperature", "humidity", "motion"}[rand.Intn(3)],
+					SensorType: sensorTypeNames[rand.Intn(len(sensorTypeNames))],
 					Value:      rand.Float64() * 100,
 					Unit:       "simulated",
-					Timestamp:  time.Now(),
+					Timestamp:  h.clock.Now(),
 					Quality:    []string{"reliable", "unreliable"}[rand.Intn(2)],
 				}
-				log.Printf("Simulated data: %+v", data)
+				h.logger.Debug("Simulated data", logging.F("device_id", data.DeviceID),
+					logging.F("sensor_type", data.SensorType), logging.F("value", data.Value))
 			}
 		}
 	}
-	
-	log.Println("IoT simulation completed")
-}
\ No newline at end of file
+
+	h.logger.Info("IoT simulation completed")
+}
@@ -0,0 +1,43 @@
+package iot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestCommandHopsUseHandlerClock(t *testing.T) {
+	// The fake clock stands still, so every hop measured on it is zero
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(fake))
+
+	req := httptest.NewRequest(http.MethodPost, "/iot/command",
+		strings.NewReader(`{"device_id": "dev1", "action": "reboot", "priority": "high"}`))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	hops := make(map[string]string)
+	for _, v := range rec.Header().Values("Server-Timing") {
+		name, dur, _ := strings.Cut(v, ";")
+		hops[name] = dur
+	}
+	for _, name := range []string{"receive", "queue", "process"} {
+		if hops[name] != "dur=0.000" {
+			t.Errorf("%s hop %q, want dur=0.000 on a stopped clock", name, hops[name])
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// send serves a request with body, and headers as name/value pairs
+func send(t *testing.T, h *Handler, method, target, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// reading is the body of a temperature reading of deviceID
+func reading(deviceID string, value float64) string {
+	return fmt.Sprintf(`{"device_id": %q, "sensor_type": "temperature", "value": %g, "unit": "celsius"}`, deviceID, value)
+}
+
+// metricValue returns the value of series in the exposition of reg
+func metricValue(t *testing.T, reg *metrics.Registry, series string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// register registers dev1 offering codecs and returns the codec picked
+func register(t *testing.T, h *Handler, codecs ...string) string {
+	t.Helper()
+	body, _ := json.Marshal(RegisterRequest{DeviceID: "dev1", MinVersion: MinProtocolVersion, MaxVersion: MaxProtocolVersion, Compression: codecs})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/iot/register", bytes.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("registration status %d: %s", rec.Code, rec.Body)
+	}
+	var resp RegisterResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp.Compression
+}
+
+// presenceOf returns the presence of deviceID
+func presenceOf(t *testing.T, p *Presence, deviceID string) DevicePresence {
+	t.Helper()
+	for _, d := range p.List() {
+		if d.DeviceID == deviceID {
+			return d
+		}
+	}
+	t.Fatalf("%s not tracked", deviceID)
+	return DevicePresence{}
+}
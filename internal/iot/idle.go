@@ -0,0 +1,46 @@
+package iot
+
+import "time"
+
+// idleSweepEvery controls how often the per-device state of a component is
+// swept for devices that have gone silent
+const idleSweepEvery = 1024
+
+// idleSweep bounds the state a component keeps per device by forgetting
+// devices that have sent nothing for iot.forget_after. The component calls
+// due each time it updates a device, under its own lock, and when due
+// reports true drops the devices for which idle does. Sweeping every
+// idleSweepEvery updates keeps the cost per message constant.
+type idleSweep struct {
+	after time.Duration // 0 keeps devices forever
+	calls int
+}
+
+// due counts an update and reports whether it is time to sweep
+func (s *idleSweep) due() bool {
+	s.calls++
+	return s.after > 0 && s.calls%idleSweepEvery == 0
+}
+
+// idle reports whether a device last heard from at last has been silent
+// for too long at now
+func (s *idleSweep) idle(last, now time.Time) bool {
+	return now.Sub(last) > s.after
+}
+
+// sweepIdle counts an update of the devices of m and, when a sweep is due,
+// deletes those whose last activity, as returned by last, is too old.
+// forget, unless nil, is called with each entry before it is deleted.
+func sweepIdle[V any](s *idleSweep, m map[string]V, now time.Time, last func(V) time.Time, forget func(V)) {
+	if !s.due() {
+		return
+	}
+	for deviceID, v := range m {
+		if s.idle(last(v), now) {
+			if forget != nil {
+				forget(v)
+			}
+			delete(m, deviceID)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// ReconnectHeader carries a migration directive on IoT responses, e.g.
+// "https://other:8443; after=10". Devices finish their in-flight requests,
+// acknowledge with POST /iot/migrate and reconnect to the address once the
+// delay has passed.
+const ReconnectHeader = "X-IoT-Reconnect"
+
+// Migration states of a device
+const (
+	MigrationPending  = "pending"  // requested, the device has not contacted the server since
+	MigrationNotified = "notified" // the directive was delivered on a response
+	MigrationDone     = "migrated" // the device acknowledged and disconnected
+)
+
+// Reconnect is a directive to move to another server
+type Reconnect struct {
+	Addr  string
+	After time.Duration
+}
+
+// String formats r as a ReconnectHeader value
+func (r Reconnect) String() string {
+	return fmt.Sprintf("%s; after=%d", r.Addr, int(r.After/time.Second))
+}
+
+// ParseReconnect parses a ReconnectHeader value
+func ParseReconnect(v string) (Reconnect, error) {
+	addr, params, _ := strings.Cut(v, ";")
+	r := Reconnect{Addr: strings.TrimSpace(addr)}
+	if r.Addr == "" {
+		return r, fmt.Errorf("missing reconnect address")
+	}
+	if params = strings.TrimSpace(params); params != "" {
+		secs, ok := strings.CutPrefix(params, "after=")
+		n, err := strconv.Atoi(secs)
+		if !ok || err != nil || n < 0 {
+			return r, fmt.Errorf("invalid reconnect parameters %q", params)
+		}
+		r.After = time.Duration(n) * time.Second
+	}
+	return r, nil
+}
+
+// MigrationStatus is the migration state of one device
+type MigrationStatus struct {
+	DeviceID    string    `json:"device_id"`
+	Target      string    `json:"target"`
+	After       string    `json:"after"`
+	Status      string    `json:"status"`
+	RequestedAt time.Time `json:"requested_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Migrations holds the devices asked to move to another server. A drain of
+// all devices applies to every device that contacts the server afterwards.
+type Migrations struct {
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	all     *Reconnect // set while draining every device
+	allAt   time.Time
+	devices map[string]*MigrationStatus
+}
+
+// NewMigrations creates an empty migration registry
+func NewMigrations(logger logging.Logger, c clock.Clock) *Migrations {
+	return &Migrations{
+		logger:  logger,
+		clock:   c,
+		devices: make(map[string]*MigrationStatus),
+	}
+}
+
+// Request asks deviceIDs, or every device if deviceIDs is empty, to
+// reconnect to r.Addr. It replaces earlier requests for the same devices.
+func (m *Migrations) Request(r Reconnect, deviceIDs []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	if len(deviceIDs) == 0 {
+		m.all, m.allAt = &r, now
+		for _, s := range m.devices {
+			if s.Status != MigrationDone {
+				m.devices[s.DeviceID] = m.newStatus(s.DeviceID, r, now)
+			}
+		}
+		m.logger.Info("Draining all devices", logging.F("target", r.Addr), logging.F("after", r.After))
+		return
+	}
+	for _, id := range deviceIDs {
+		m.devices[id] = m.newStatus(id, r, now)
+	}
+	m.logger.Info("Migrating devices", logging.F("target", r.Addr), logging.F("after", r.After),
+		logging.F("devices", len(deviceIDs)))
+}
+
+// Cancel drops every migration that has not completed
+func (m *Migrations) Cancel() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.all = nil
+	for id, s := range m.devices {
+		if s.Status != MigrationDone {
+			delete(m.devices, id)
+		}
+	}
+	m.logger.Info("Migrations cancelled")
+}
+
+// List returns the state of every device with a migration, by device ID
+func (m *Migrations) List() []MigrationStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]MigrationStatus, 0, len(m.devices))
+	for _, s := range m.devices {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].DeviceID < list[j].DeviceID })
+	return list
+}
+
+// directive returns the reconnect directive for deviceID, if any, and marks
+// it delivered
+func (m *Migrations) directive(deviceID string) (Reconnect, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.devices[deviceID]
+	if !ok {
+		if m.all == nil {
+			return Reconnect{}, false
+		}
+		s = m.newStatus(deviceID, *m.all, m.allAt)
+		m.devices[deviceID] = s
+	}
+	if s.Status == MigrationDone {
+		return Reconnect{}, false
+	}
+	if s.Status == MigrationPending {
+		s.Status, s.UpdatedAt = MigrationNotified, m.clock.Now()
+		m.logger.Info("Device notified of migration", logging.F("device_id", deviceID), logging.F("target", s.Target))
+	}
+	after, _ := time.ParseDuration(s.After)
+	return Reconnect{Addr: s.Target, After: after}, true
+}
+
+// complete records that deviceID acknowledged its migration
+func (m *Migrations) complete(deviceID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.devices[deviceID]
+	if !ok || s.Status == MigrationDone {
+		return false
+	}
+	s.Status, s.UpdatedAt = MigrationDone, m.clock.Now()
+	m.logger.Info("Device migrated", logging.F("device_id", deviceID), logging.F("target", s.Target))
+	return true
+}
+
+func (m *Migrations) newStatus(deviceID string, r Reconnect, at time.Time) *MigrationStatus {
+	return &MigrationStatus{
+		DeviceID:    deviceID,
+		Target:      r.Addr,
+		After:       r.After.String(),
+		Status:      MigrationPending,
+		RequestedAt: at,
+		UpdatedAt:   at,
+	}
+}
+
+// WithMigrations enables server-initiated migration of devices
+func WithMigrations(m *Migrations) Option {
+	return func(h *Handler) {
+		h.migrations = m
+	}
+}
+
+// setReconnect adds the migration directive of the requesting device to the
+// response headers
+func (h *Handler) setReconnect(w http.ResponseWriter, r *http.Request) {
+	if h.migrations == nil {
+		return
+	}
+	if id := r.Header.Get("X-Device-ID"); id != "" {
+		if directive, ok := h.migrations.directive(id); ok {
+			w.Header().Set(ReconnectHeader, directive.String())
+		}
+	}
+}
+
+// handleMigrate records that a device is leaving for its migration target
+func (h *Handler) handleMigrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.Header.Get("X-Device-ID")
+	if !h.authorize(w, r, id) {
+		return
+	}
+	if h.migrations == nil || id == "" || !h.migrations.complete(id) {
+		http.Error(w, "No migration pending for device", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success", Message: "Migration acknowledged"})
+}
@@ -0,0 +1,221 @@
+package iot
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// SeqHeader carries the sequence number a device gives each sensor reading,
+// batch and command, starting at 1 after registration. A device sending on
+// several streams at once can't control which request the server reads
+// first; the server applies the messages of a device in sequence order and
+// echoes the number in the response header and Response.Seq. Messages
+// without a sequence number are applied in the order they arrive.
+const SeqHeader = "X-IoT-Seq"
+
+// orderSweepEvery controls how often idle devices are dropped from a
+// Sequencer
+const orderSweepEvery = 1024
+
+// Sequencer is the per-device serialization point of the IoT handler. State
+// that depends on the order of a device's messages, such as its sampling
+// history, is only changed inside Apply. The sequence of a device is
+// forgotten when it registers again, or once it has sent nothing for the
+// idle timeout.
+type Sequencer struct {
+	wait  time.Duration
+	idle  time.Duration
+	clock clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*deviceOrder
+	applies int
+
+	outcomes *metrics.CounterVec
+}
+
+type deviceOrder struct {
+	mu       sync.Mutex    // held while a message of the device is applied
+	next     uint64        // next expected sequence number, 0 before the first message
+	advanced chan struct{} // closed when next moves on
+	epoch    uint64        // counts the resets of the sequence
+
+	// Guarded by Sequencer.mu
+	users int       // Apply calls holding or waiting for mu
+	last  time.Time // when the last Apply call ended
+}
+
+// NewSequencer creates a sequencer that lets a message wait up to wait for
+// the messages before it. After that the missing ones are skipped. Devices
+// silent for idle are forgotten; 0 keeps them.
+func NewSequencer(wait, idle time.Duration, c clock.Clock, reg *metrics.Registry) *Sequencer {
+	return &Sequencer{
+		wait:     wait,
+		idle:     idle,
+		clock:    c,
+		devices:  make(map[string]*deviceOrder),
+		outcomes: reg.CounterVec("iot", "ordered_messages_total", "Device messages by ordering outcome", "outcome"),
+	}
+}
+
+// acquire returns the order of deviceID, held until release
+func (s *Sequencer) acquire(deviceID string) *deviceOrder {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applies++
+	if s.idle > 0 && s.applies%orderSweepEvery == 0 {
+		s.sweepLocked(s.clock.Now())
+	}
+	d, ok := s.devices[deviceID]
+	if !ok {
+		d = &deviceOrder{advanced: make(chan struct{})}
+		s.devices[deviceID] = d
+	}
+	d.users++
+	return d
+}
+
+func (s *Sequencer) release(d *deviceOrder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.users--
+	d.last = s.clock.Now()
+}
+
+// sweepLocked drops the devices no message arrived from for the idle
+// timeout. s.mu must be held.
+func (s *Sequencer) sweepLocked(now time.Time) {
+	for id, d := range s.devices {
+		if d.users == 0 && now.Sub(d.last) > s.idle {
+			delete(s.devices, id)
+		}
+	}
+}
+
+// Reset forgets the sequence of deviceID, e.g. when it registers again.
+// While Apply calls hold the order of the device it is reset in place, so
+// they keep serializing with the messages of the new sequence; messages
+// still waiting for their turn in the old one are applied as late.
+func (s *Sequencer) Reset(deviceID string) {
+	s.mu.Lock()
+	d, ok := s.devices[deviceID]
+	if !ok || d.users == 0 {
+		delete(s.devices, deviceID)
+		s.mu.Unlock()
+		return
+	}
+	d.users++
+	s.mu.Unlock()
+	defer s.release(d)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.epoch++
+	d.advance(0)
+}
+
+// Apply runs fn once the messages of deviceID numbered before seq have been
+// applied, or their wait has expired, and no other message of the device is
+// being applied. A zero seq runs fn in arrival order. Apply returns
+// ctx.Err() without running fn if ctx ends while waiting.
+func (s *Sequencer) Apply(ctx context.Context, deviceID string, seq uint64, fn func()) error {
+	d := s.acquire(deviceID)
+	defer s.release(d)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if seq == 0 {
+		s.outcomes.WithLabelValues("unsequenced").Inc()
+		fn()
+		return nil
+	}
+	if d.next == 0 {
+		// Sequences start at 1, and start again once the device registers
+		// again. A device the server lost track of, e.g. after a restart,
+		// waits once for messages it will never see.
+		d.next = 1
+	}
+
+	outcome := "in_order"
+	epoch := d.epoch
+	var timeout <-chan time.Time
+	for seq > d.next {
+		if timeout == nil {
+			timeout = s.clock.After(s.wait)
+			outcome = "reordered"
+		}
+		advanced := d.advanced
+		d.mu.Unlock()
+		select {
+		case <-advanced:
+			d.mu.Lock()
+		case <-timeout:
+			d.mu.Lock()
+			if d.epoch == epoch && seq > d.next {
+				s.outcomes.WithLabelValues("skipped").Add(float64(seq - d.next))
+				d.advance(seq)
+			}
+		case <-ctx.Done():
+			d.mu.Lock()
+			return ctx.Err()
+		}
+		if d.epoch != epoch {
+			break
+		}
+	}
+
+	if seq < d.next || d.epoch != epoch {
+		// A duplicate, a message that arrived after it was skipped, or one
+		// sent before the device registered again. Its place in the order
+		// is gone.
+		s.outcomes.WithLabelValues("late").Inc()
+		fn()
+		return nil
+	}
+	s.outcomes.WithLabelValues(outcome).Inc()
+	fn()
+	d.advance(seq + 1)
+	return nil
+}
+
+func (d *deviceOrder) advance(next uint64) {
+	d.next = next
+	close(d.advanced)
+	d.advanced = make(chan struct{})
+}
+
+// requestSeq parses the SeqHeader of r, zero if absent
+func requestSeq(r *http.Request) (uint64, error) {
+	v := r.Header.Get(SeqHeader)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// ordered runs fn at the serialization point of deviceID and stamps the
+// request's sequence number on the response. fn returns false if it wrote
+// an error response, e.g. for a quota; the message still takes its place in
+// the order. ordered returns false if fn did not run or failed.
+func (h *Handler) ordered(w http.ResponseWriter, r *http.Request, deviceID string, fn func(seq uint64) bool) bool {
+	seq, err := requestSeq(r)
+	if err != nil {
+		http.Error(w, "Invalid "+SeqHeader+" header", http.StatusBadRequest)
+		return false
+	}
+	ok := false
+	if err := h.order.Apply(r.Context(), deviceID, seq, func() { ok = fn(seq) }); err != nil {
+		http.Error(w, "Request cancelled", http.StatusServiceUnavailable)
+		return false
+	}
+	if ok && seq != 0 {
+		w.Header().Set(SeqHeader, strconv.FormatUint(seq, 10))
+	}
+	return ok
+}
@@ -0,0 +1,249 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// applyWithin runs Apply in the background and fails the test if it has not
+// returned within a second of real time
+func applyWithin(t *testing.T, s *Sequencer, deviceID string, seq uint64, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		s.Apply(context.Background(), deviceID, seq, fn)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("message %d of %s is stuck waiting", seq, deviceID)
+	}
+}
+
+// TestSequencerInterleavedBursts sends a sensor burst and commands of two
+// devices on concurrent streams, each in a random order, and checks that
+// every run ends in the same state
+func TestSequencerInterleavedBursts(t *testing.T) {
+	const (
+		runs     = 100
+		messages = 50
+	)
+	devices := []string{"dev1", "dev2"}
+
+	var want map[string]string
+	for run := 0; run < runs; run++ {
+		s := NewSequencer(time.Minute, 0, clock.Real(), metrics.NewRegistry())
+		var mu sync.Mutex
+		applied := make(map[string][]uint64)
+		state := make(map[string]uint64)
+
+		var wg sync.WaitGroup
+		for _, deviceID := range devices {
+			// Readings take the odd numbers, commands the even ones, and
+			// each stream sends its part shuffled
+			for _, parity := range []uint64{1, 0} {
+				var seqs []uint64
+				for seq := uint64(1); seq <= messages; seq++ {
+					if seq%2 == parity {
+						seqs = append(seqs, seq)
+					}
+				}
+				rand.Shuffle(len(seqs), func(i, j int) { seqs[i], seqs[j] = seqs[j], seqs[i] })
+				for _, seq := range seqs {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						s.Apply(context.Background(), deviceID, seq, func() {
+							mu.Lock()
+							defer mu.Unlock()
+							applied[deviceID] = append(applied[deviceID], seq)
+							// The state depends on the order of the updates
+							h := fnv.New64a()
+							fmt.Fprintf(h, "%d/%d", state[deviceID], seq)
+							state[deviceID] = h.Sum64()
+						})
+					}()
+				}
+			}
+		}
+		wg.Wait()
+
+		got := make(map[string]string)
+		for _, deviceID := range devices {
+			if !slices.IsSorted(applied[deviceID]) || len(applied[deviceID]) != messages {
+				t.Fatalf("run %d: %s applied %v", run, deviceID, applied[deviceID])
+			}
+			got[deviceID] = fmt.Sprint(state[deviceID])
+		}
+		if want == nil {
+			want = got
+		} else if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("run %d ended in %v, run 0 in %v", run, got, want)
+		}
+	}
+}
+
+func TestSequencerRetriedFirstMessage(t *testing.T) {
+	s := NewSequencer(time.Minute, 0, clock.NewFake(time.Unix(0, 0)), metrics.NewRegistry())
+	var applied []uint64
+	for _, seq := range []uint64{1, 2, 3, 1, 4} {
+		applyWithin(t, s, "dev1", seq, func() { applied = append(applied, seq) })
+	}
+	// The retried first message is late, and doesn't rewind the sequence
+	if want := []uint64{1, 2, 3, 1, 4}; !slices.Equal(applied, want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+}
+
+func TestSequencerResetOnRegistration(t *testing.T) {
+	s := NewSequencer(time.Minute, 0, clock.NewFake(time.Unix(0, 0)), metrics.NewRegistry())
+	for seq := uint64(1); seq <= 3; seq++ {
+		applyWithin(t, s, "dev1", seq, func() {})
+	}
+	s.Reset("dev1")
+	applied := false
+	applyWithin(t, s, "dev1", 1, func() { applied = true })
+	if !applied {
+		t.Error("first message after a reset was not applied")
+	}
+}
+
+func TestSequencerResetReleasesWaitingMessage(t *testing.T) {
+	s := NewSequencer(time.Minute, 0, clock.NewFake(time.Unix(0, 0)), metrics.NewRegistry())
+	applyWithin(t, s, "dev1", 1, func() {})
+
+	// Message 3 of the old sequence waits for message 2 until the reset
+	done := make(chan struct{})
+	go func() {
+		s.Apply(context.Background(), "dev1", 3, func() {})
+		close(done)
+	}()
+	// It may not be waiting yet at the first reset
+	for deadline := time.After(time.Second); ; {
+		s.Reset("dev1")
+		select {
+		case <-done:
+		case <-time.After(time.Millisecond):
+			continue
+		case <-deadline:
+			t.Fatal("message of the old sequence still waits after the reset")
+		}
+		break
+	}
+
+	var applied []uint64
+	for seq := uint64(1); seq <= 2; seq++ {
+		applyWithin(t, s, "dev1", seq, func() { applied = append(applied, seq) })
+	}
+	if want := []uint64{1, 2}; !slices.Equal(applied, want) {
+		t.Errorf("applied %v after the reset, want %v", applied, want)
+	}
+}
+
+// TestSequencerResetWhileApplying resets a device while its messages are
+// applied and checks that no two of them are ever applied at once
+func TestSequencerResetWhileApplying(t *testing.T) {
+	s := NewSequencer(5*time.Millisecond, 0, clock.Real(), metrics.NewRegistry())
+	var (
+		mu     sync.Mutex
+		inside bool
+		wg     sync.WaitGroup
+	)
+	apply := func() {
+		mu.Lock()
+		if inside {
+			t.Error("two messages of dev1 applied at once")
+		}
+		inside = true
+		mu.Unlock()
+		time.Sleep(10 * time.Microsecond)
+		mu.Lock()
+		inside = false
+		mu.Unlock()
+	}
+	for stream := 0; stream < 4; stream++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := uint64(1); seq <= 50; seq++ {
+				s.Apply(context.Background(), "dev1", seq*uint64(stream%2), apply)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	resets := make(chan struct{})
+	go func() {
+		defer close(resets)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				s.Reset("dev1")
+				time.Sleep(50 * time.Microsecond)
+			}
+		}
+	}()
+	wg.Wait()
+	close(stop)
+	<-resets
+}
+
+func TestSequencerSkipsAfterWait(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewSequencer(time.Second, 0, fake, metrics.NewRegistry())
+	applyWithin(t, s, "dev1", 1, func() {})
+
+	done := make(chan struct{})
+	go func() {
+		s.Apply(context.Background(), "dev1", 3, func() {})
+		close(done)
+	}()
+	// Until the wait expires, message 3 waits for message 2
+	for i := 0; i < 100; i++ {
+		select {
+		case <-done:
+			t.Fatal("message 3 was applied before message 2 or the wait")
+		default:
+		}
+		fake.Advance(5 * time.Millisecond)
+		time.Sleep(time.Millisecond)
+	}
+	fake.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("message 3 still waits after order_wait")
+	}
+}
+
+func TestSequencerForgetsIdleDevices(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	s := NewSequencer(time.Second, time.Minute, fake, metrics.NewRegistry())
+	applyWithin(t, s, "idle", 1, func() {})
+	applyWithin(t, s, "busy", 1, func() {})
+
+	fake.Advance(2 * time.Minute)
+	for i := 0; i < orderSweepEvery; i++ {
+		s.Apply(context.Background(), "busy", 0, func() {})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.devices["idle"]; ok {
+		t.Error("idle device still tracked")
+	}
+	if _, ok := s.devices["busy"]; !ok {
+		t.Error("busy device forgotten")
+	}
+}
@@ -0,0 +1,434 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CommandsHeader is set on responses to a device with commands waiting for
+// it, to the number waiting. The device fetches them from GET /iot/commands.
+const CommandsHeader = "X-IoT-Commands"
+
+// maxQueuedCommands is the most commands waiting for one device
+const maxQueuedCommands = 100
+
+// Statuses of a queued command
+const (
+	CommandQueued    = "queued"    // waiting for the device to fetch it
+	CommandSent      = "sent"      // fetched by the device
+	CommandAcked     = "acked"     // the device started it
+	CommandCompleted = "completed" // the device finished it
+	CommandFailed    = "failed"    // the device couldn't carry it out
+	CommandCancelled = "cancelled" // cancelled before the device finished it
+)
+
+// CancelAction is the action of the message that tells a device to stop a
+// command it was already sent, named by the command_id parameter
+const CancelAction = "cancel"
+
+var (
+	// ErrOutboxFull is returned when a device already has
+	// maxQueuedCommands commands waiting
+	ErrOutboxFull = errors.New("too many commands waiting for the device")
+	// ErrUnknownCommand is returned for a command ID the outbox doesn't
+	// know, or no longer remembers
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrCommandFinished is returned when a command that was completed,
+	// failed or cancelled is reported on or cancelled again
+	ErrCommandFinished = errors.New("command already finished")
+)
+
+// CommandEvent is a change of a command's status
+type CommandEvent struct {
+	Status  string    `json:"status"`
+	At      time.Time `json:"at"`
+	Message string    `json:"message,omitempty"`
+}
+
+// CommandStatus is the lifecycle of a queued command
+type CommandStatus struct {
+	ID       string         `json:"id"`
+	DeviceID string         `json:"device_id"`
+	Action   string         `json:"action"`
+	Status   string         `json:"status"`
+	Message  string         `json:"message,omitempty"` // of the last change
+	History  []CommandEvent `json:"history"`           // oldest first
+
+	order uint64 // of enqueueing
+}
+
+// finished reports whether the command can't change any more
+func (s *CommandStatus) finished() bool {
+	return s.Status == CommandCompleted || s.Status == CommandFailed || s.Status == CommandCancelled
+}
+
+// CommandReport is sent by a device to POST /iot/commands/status as it
+// carries out a command
+type CommandReport struct {
+	CommandID string `json:"command_id"`
+	DeviceID  string `json:"device_id"`
+	Status    string `json:"status"` // CommandAcked, CommandCompleted or CommandFailed
+	Message   string `json:"message,omitempty"`
+}
+
+// Outbox holds commands sent to devices, e.g. from the MQTT bridge, until
+// the devices fetch them, and follows each command until the device reports
+// it finished. Finished commands are remembered up to the configured
+// history, the oldest forgotten first.
+type Outbox struct {
+	logger  logging.Logger
+	devices *DeviceRegistry // refuses commands a device doesn't support; nil accepts any
+	clock   clock.Clock
+	history int
+
+	mu       sync.Mutex
+	pending  map[string][]Command
+	statuses map[string]*CommandStatus
+	finished []string // IDs of finished commands, oldest first
+	nextID   uint64
+	totals   map[string]int64 // commands that reached each status, for Stats
+
+	queued    prometheus.Gauge
+	delivered prometheus.Counter
+	changes   *metrics.CounterVec
+}
+
+// NewOutbox creates an empty outbox that remembers cfg.History finished
+// commands. Commands whose action the device declared it doesn't support,
+// per devices, are refused.
+func NewOutbox(cfg config.CommandConfig, logger logging.Logger, devices *DeviceRegistry, c clock.Clock, reg *metrics.Registry) *Outbox {
+	return &Outbox{
+		logger:    logger,
+		devices:   devices,
+		clock:     c,
+		history:   cfg.History,
+		pending:   make(map[string][]Command),
+		statuses:  make(map[string]*CommandStatus),
+		totals:    make(map[string]int64),
+		queued:    reg.Gauge("iot", "commands_queued", "Commands waiting for their device to fetch them"),
+		delivered: reg.Counter("iot", "commands_delivered_total", "Commands fetched by their device"),
+		changes:   reg.CounterVec("iot", "command_status_total", "Queued commands that reached each status", "status"),
+	}
+}
+
+// Enqueue queues cmd for cmd.DeviceID and returns it with its ID. A command
+// without an ID is given one.
+func (o *Outbox) Enqueue(cmd Command) (Command, error) {
+	if cmd.DeviceID == "" {
+		return cmd, errors.New("command has no device_id")
+	}
+	if cmd.Action == CancelAction {
+		return cmd, fmt.Errorf("%q is reserved for cancelling commands", CancelAction)
+	}
+	if err := o.devices.CheckCommand(cmd); err != nil {
+		return cmd, err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.pending[cmd.DeviceID]) >= maxQueuedCommands {
+		return cmd, ErrOutboxFull
+	}
+	if _, ok := o.statuses[cmd.ID]; ok && cmd.ID != "" {
+		return cmd, fmt.Errorf("command ID %s already in use", cmd.ID)
+	}
+	o.nextID++
+	if cmd.ID == "" {
+		cmd.ID = fmt.Sprintf("cmd_%d_%s", o.nextID, randomSuffix())
+	}
+	o.pending[cmd.DeviceID] = append(o.pending[cmd.DeviceID], cmd)
+	o.queued.Inc()
+	status := &CommandStatus{ID: cmd.ID, DeviceID: cmd.DeviceID, Action: cmd.Action, order: o.nextID}
+	o.statuses[cmd.ID] = status
+	o.setLocked(status, CommandQueued, "")
+	o.logger.Debug("Command queued", logging.F("device_id", cmd.DeviceID), logging.F("action", cmd.Action),
+		logging.F("command_id", cmd.ID))
+	return cmd, nil
+}
+
+// setLocked moves status to s, and into the history if that finishes it.
+// o.mu must be held.
+func (o *Outbox) setLocked(status *CommandStatus, s, message string) {
+	status.Status, status.Message = s, message
+	status.History = append(status.History, CommandEvent{Status: s, At: o.clock.Now(), Message: message})
+	o.changes.WithLabelValues(s).Inc()
+	o.totals[s]++
+	if !status.finished() {
+		return
+	}
+	o.finished = append(o.finished, status.ID)
+	if len(o.finished) > o.history {
+		delete(o.statuses, o.finished[0])
+		o.finished = o.finished[1:]
+	}
+}
+
+// CommandStats counts the outbox's commands by status
+type CommandStats struct {
+	Queued    int   `json:"queued"`    // waiting for their device to fetch them
+	Delivered int64 `json:"delivered"` // fetched by their device
+	Completed int64 `json:"completed"`
+	Failed    int64 `json:"failed"`
+	Cancelled int64 `json:"cancelled"`
+}
+
+// Stats returns the number of commands waiting now, and of those that
+// reached each status since the outbox was created
+func (o *Outbox) Stats() CommandStats {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	s := CommandStats{
+		Delivered: o.totals[CommandSent],
+		Completed: o.totals[CommandCompleted],
+		Failed:    o.totals[CommandFailed],
+		Cancelled: o.totals[CommandCancelled],
+	}
+	for _, cmds := range o.pending {
+		s.Queued += len(cmds)
+	}
+	return s
+}
+
+// Status returns the status of the command with id
+func (o *Outbox) Status(id string) (CommandStatus, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status, ok := o.statuses[id]
+	if !ok {
+		return CommandStatus{}, false
+	}
+	return status.copy(), true
+}
+
+// copy returns s with a history of its own
+func (s *CommandStatus) copy() CommandStatus {
+	c := *s
+	c.History = append([]CommandEvent(nil), s.History...)
+	return c
+}
+
+// List returns the commands of deviceID, or of every device if it is empty,
+// that have status, or any status if it is empty, oldest first
+func (o *Outbox) List(deviceID, status string) []CommandStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []CommandStatus{}
+	for _, s := range o.statuses {
+		if (deviceID == "" || s.DeviceID == deviceID) && (status == "" || s.Status == status) {
+			out = append(out, s.copy())
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].order < out[j].order })
+	return out
+}
+
+// Cancel cancels the command with id. A command still waiting is removed
+// from the outbox; one the device already fetched gets a cancel message
+// queued after it. Commands that are finished can't be cancelled.
+func (o *Outbox) Cancel(id string) (CommandStatus, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status, ok := o.statuses[id]
+	if !ok {
+		return CommandStatus{}, ErrUnknownCommand
+	}
+	if status.finished() {
+		return status.copy(), ErrCommandFinished
+	}
+	if status.Status == CommandQueued {
+		cmds := o.pending[status.DeviceID]
+		for i, cmd := range cmds {
+			if cmd.ID == id {
+				o.pending[status.DeviceID] = append(cmds[:i:i], cmds[i+1:]...)
+				if len(o.pending[status.DeviceID]) == 0 {
+					delete(o.pending, status.DeviceID)
+				}
+				o.queued.Dec()
+				break
+			}
+		}
+		o.setLocked(status, CommandCancelled, "removed before the device fetched it")
+	} else {
+		// The cancel message isn't followed itself, and may exceed the limit
+		// of waiting commands
+		o.pending[status.DeviceID] = append(o.pending[status.DeviceID], Command{
+			DeviceID:   status.DeviceID,
+			Action:     CancelAction,
+			Parameters: map[string]interface{}{"command_id": id},
+			Priority:   "high",
+		})
+		o.queued.Inc()
+		o.setLocked(status, CommandCancelled, "cancel sent to the device")
+	}
+	o.logger.Info("Command cancelled", logging.F("device_id", status.DeviceID), logging.F("command_id", id),
+		logging.F("action", status.Action))
+	return status.copy(), nil
+}
+
+// report records a device's report on a command it was sent
+func (o *Outbox) report(r CommandReport) (CommandStatus, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	status, ok := o.statuses[r.CommandID]
+	if !ok || status.DeviceID != r.DeviceID {
+		return CommandStatus{}, ErrUnknownCommand
+	}
+	if status.finished() {
+		return status.copy(), ErrCommandFinished
+	}
+	switch {
+	case r.Status == CommandAcked && status.Status == CommandSent,
+		(r.Status == CommandCompleted || r.Status == CommandFailed) && status.Status != CommandQueued:
+	default:
+		return status.copy(), fmt.Errorf("command %s is %s and can't become %q", r.CommandID, status.Status, r.Status)
+	}
+	o.setLocked(status, r.Status, r.Message)
+	return status.copy(), nil
+}
+
+// failInFlight fails the commands deviceID fetched but hasn't finished
+func (o *Outbox) failInFlight(deviceID, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var failed []*CommandStatus
+	for _, status := range o.statuses {
+		if status.DeviceID == deviceID && (status.Status == CommandSent || status.Status == CommandAcked) {
+			failed = append(failed, status)
+		}
+	}
+	// Finished in the order they were queued, so the history forgets the
+	// oldest first
+	sort.Slice(failed, func(i, j int) bool { return failed[i].order < failed[j].order })
+	for _, status := range failed {
+		o.setLocked(status, CommandFailed, reason)
+	}
+	if len(failed) > 0 {
+		o.logger.Info("Commands in flight failed", logging.F("device_id", deviceID), logging.F("commands", len(failed)),
+			logging.F("reason", reason))
+	}
+}
+
+// waiting returns the number of commands waiting for deviceID
+func (o *Outbox) waiting(deviceID string) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending[deviceID])
+}
+
+// take removes and returns the commands waiting for deviceID, oldest first
+func (o *Outbox) take(deviceID string) []Command {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cmds := o.pending[deviceID]
+	delete(o.pending, deviceID)
+	o.queued.Sub(float64(len(cmds)))
+	o.delivered.Add(float64(len(cmds)))
+	for _, cmd := range cmds {
+		if status, ok := o.statuses[cmd.ID]; ok { // cancel messages aren't followed
+			o.setLocked(status, CommandSent, "")
+		}
+	}
+	return cmds
+}
+
+// WithOutbox delivers the commands queued in o to devices
+func WithOutbox(o *Outbox) Option {
+	return func(h *Handler) {
+		h.outbox = o
+	}
+}
+
+// setPendingCommands tells a device how many commands are waiting for it
+func (h *Handler) setPendingCommands(w http.ResponseWriter, deviceID string) {
+	if h.outbox == nil || deviceID == "" {
+		return
+	}
+	if n := h.outbox.waiting(deviceID); n > 0 {
+		w.Header().Set(CommandsHeader, strconv.Itoa(n))
+	}
+}
+
+// handleCommands serves GET /iot/commands and POST /iot/commands/status
+func (h *Handler) handleCommands(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	case len(parts) == 1 || parts[1] == "":
+		h.handleCommandQueue(w, r)
+	case len(parts) == 2 && parts[1] == "status":
+		h.handleCommandReport(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// handleCommandQueue hands a device the commands waiting for it and removes
+// them from the outbox
+func (h *Handler) handleCommandQueue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.Header.Get("X-Device-ID")
+	if !h.authorize(w, r, id) {
+		return
+	}
+	if id == "" {
+		http.Error(w, "X-Device-ID is required", http.StatusBadRequest)
+		return
+	}
+	cmds := []Command{}
+	if h.outbox != nil {
+		if taken := h.outbox.take(id); taken != nil {
+			cmds = taken
+		}
+	}
+	w.Header().Del(CommandsHeader)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cmds)
+}
+
+// handleCommandReport records that a device acknowledged, completed or
+// failed a command it fetched. Reports on a cancelled command get 409.
+func (h *Handler) handleCommandReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var report CommandReport
+	if _, ok := h.decode(w, r, &report, "command report"); !ok {
+		return
+	}
+	if !h.authorize(w, r, report.DeviceID) {
+		return
+	}
+	if h.outbox == nil {
+		http.Error(w, "Queued commands are disabled", http.StatusNotFound)
+		return
+	}
+	status, err := h.outbox.report(report)
+	switch {
+	case errors.Is(err, ErrUnknownCommand):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, ErrCommandFinished):
+		http.Error(w, fmt.Sprintf("Command %s is %s", status.ID, status.Status), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.logger.Debug("Command status reported", logging.F("device_id", report.DeviceID),
+		logging.F("command_id", report.CommandID), logging.F("status", report.Status))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{CommandID: status.ID, Status: "success", Message: "Command status recorded", Data: status})
+}
@@ -0,0 +1,75 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// peer counts the oversized messages received on one connection and keeps
+// the devices that used it
+type peer struct {
+	violations atomic.Int32
+	closed     <-chan struct{} // nil if the closing isn't reported
+
+	mu      sync.Mutex
+	devices []string
+}
+
+type peerKey struct{}
+
+// WithPeer returns a connection context that tracks oversized messages, for
+// use as the ConnContext of a server. Without it a peer sending oversized
+// messages only ever gets 413s. closed is done once the connection has
+// closed, which ends the sessions of its devices; it may be nil.
+func WithPeer(ctx context.Context, closed <-chan struct{}) context.Context {
+	return context.WithValue(ctx, peerKey{}, &peer{closed: closed})
+}
+
+// attach records that deviceID used the connection, and reports whether it
+// is the first device to
+func (p *peer) attach(deviceID string) (first bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, id := range p.devices {
+		if id == deviceID {
+			return false
+		}
+	}
+	p.devices = append(p.devices, deviceID)
+	return len(p.devices) == 1
+}
+
+// attached returns the devices that used the connection
+func (p *peer) attached() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.devices...)
+}
+
+// rejectOversized answers a message over maxMessageBytes with 413. Once a
+// connection has sent maxViolations of them it is closed: HTTP/3
+// connections with H3_EXCESSIVE_LOAD, others after the response.
+func (h *Handler) rejectOversized(w http.ResponseWriter, r *http.Request) {
+	h.metrics.oversized.Inc()
+	p, _ := r.Context().Value(peerKey{}).(*peer)
+	if p == nil || h.maxViolations <= 0 || int(p.violations.Add(1)) < h.maxViolations {
+		http.Error(w, fmt.Sprintf("Message exceeds %d bytes", h.maxMessageBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	h.logger.Warn("Closing connection after oversized messages", logging.F("remote_addr", r.RemoteAddr),
+		logging.F("violations", h.maxViolations))
+	h.metrics.peersClosed.Inc()
+	w.Header().Set("Connection", "close")
+	http.Error(w, fmt.Sprintf("Message exceeds %d bytes", h.maxMessageBytes), http.StatusRequestEntityTooLarge)
+	if hijacker, ok := w.(http3.Hijacker); ok {
+		hijacker.Connection().CloseWithError(quic.ApplicationErrorCode(http3.ErrCodeExcessiveLoad), "message too large")
+	}
+}
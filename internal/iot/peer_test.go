@@ -0,0 +1,57 @@
+package iot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestOversizedMessagesClosePeer(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.MaxMessageBytes = 1024
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	// A value of 2 KiB, over the limit before the JSON decoder sees it
+	oversized := `{"device_id": "dev1", "sensor_type": "temperature", "value": 1` + strings.Repeat("0", 2048) + `}`
+	post := func(ctx context.Context, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/iot/sensor", strings.NewReader(body)).WithContext(ctx)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	conn := WithPeer(context.Background(), nil)
+	for i := 1; i <= cfg.IoT.MaxViolations; i++ {
+		rec := post(conn, oversized)
+		closed := rec.Header().Get("Connection") == "close"
+		if rec.Code != http.StatusRequestEntityTooLarge || closed != (i == cfg.IoT.MaxViolations) {
+			t.Errorf("oversized message %d: status %d, closed %v", i, rec.Code, closed)
+		}
+	}
+	// Messages within the limit don't count
+	if rec := post(WithPeer(context.Background(), nil), reading("dev1", 21)); rec.Code != http.StatusOK {
+		t.Errorf("message within the limit: status %d", rec.Code)
+	}
+	// Without a peer the connection is never closed
+	for i := 0; i < cfg.IoT.MaxViolations; i++ {
+		if rec := post(context.Background(), oversized); rec.Header().Get("Connection") == "close" {
+			t.Errorf("oversized message %d without a peer closed the connection", i+1)
+		}
+	}
+
+	if got := metricValue(t, reg, "commsys_iot_oversized_messages_total"); got != "6" {
+		t.Errorf("%s oversized messages, want 6", got)
+	}
+	if got := metricValue(t, reg, "commsys_iot_oversized_peers_closed_total"); got != "1" {
+		t.Errorf("%s peers closed, want 1", got)
+	}
+}
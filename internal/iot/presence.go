@@ -0,0 +1,215 @@
+package iot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HeartbeatRequest is sent by a device to POST /iot/heartbeat
+type HeartbeatRequest struct {
+	DeviceID string `json:"device_id"`
+}
+
+// HeartbeatResponse tells a device how often the server expects heartbeats
+// and how long it may stay silent before it is considered offline. It
+// carries the server's time, by which a device can correct its own clock.
+type HeartbeatResponse struct {
+	DeviceID   string    `json:"device_id"`
+	Interval   string    `json:"interval"` // e.g. "30s"
+	Timeout    string    `json:"timeout"`
+	ServerTime time.Time `json:"server_time"`
+}
+
+// DevicePresence is whether a device is online
+type DevicePresence struct {
+	DeviceID      string    `json:"device_id"`
+	Online        bool      `json:"online"`
+	LastSeen      time.Time `json:"last_seen"`        // last heartbeat or sensor reading
+	LastHeartbeat time.Time `json:"last_heartbeat"`   // zero if the device never sent one
+	Since         time.Time `json:"since"`            // when it last went online or offline
+	Reason        string    `json:"reason,omitempty"` // why an offline device is offline: "silent", "health_stale" or "disconnected"
+}
+
+// Presence tracks which devices are online. Heartbeats and accepted sensor
+// readings both count as signs of life, so a device that streams readings
+// stays online without sending heartbeats. A battery device whose health
+// reports went stale is offline however active it is otherwise, since it
+// may be about to run flat.
+type Presence struct {
+	interval time.Duration
+	timeout  time.Duration
+	health   *DeviceHealthTracker // nil ignores device health
+	logger   logging.Logger
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*DevicePresence
+
+	online prometheus.Gauge
+}
+
+// NewPresence creates a presence tracker with the heartbeat interval and
+// timeout of cfg. Devices stale in health, which may be nil, are offline.
+func NewPresence(cfg config.HeartbeatConfig, health *DeviceHealthTracker, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Presence {
+	return &Presence{
+		interval: cfg.Interval,
+		timeout:  cfg.Timeout,
+		health:   health,
+		logger:   logger,
+		clock:    c,
+		devices:  make(map[string]*DevicePresence),
+		online:   reg.Gauge("iot", "devices_online", "Devices that sent a heartbeat or reading within iot.heartbeat.timeout"),
+	}
+}
+
+// Heartbeat records a heartbeat of deviceID
+func (p *Presence) Heartbeat(deviceID string) {
+	p.seen(deviceID, true)
+}
+
+// Seen records other activity of deviceID, such as a sensor reading
+func (p *Presence) Seen(deviceID string) {
+	p.seen(deviceID, false)
+}
+
+func (p *Presence) seen(deviceID string, heartbeat bool) {
+	now := p.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[deviceID]
+	if !ok {
+		d = &DevicePresence{DeviceID: deviceID}
+		p.devices[deviceID] = d
+	}
+	d.LastSeen = now
+	if heartbeat {
+		d.LastHeartbeat = now
+	}
+	if !d.Online && !p.health.Stale(deviceID, now) {
+		d.Online, d.Since, d.Reason = true, now, ""
+		p.online.Inc()
+		p.logger.Info("Device online", logging.F("device_id", deviceID))
+	}
+}
+
+// Disconnected marks deviceID offline at once because its connection closed
+func (p *Presence) Disconnected(deviceID string) {
+	now := p.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d, ok := p.devices[deviceID]
+	if !ok || !d.Online {
+		return
+	}
+	d.Online, d.Since, d.Reason = false, now, "disconnected"
+	p.online.Dec()
+	p.logger.Warn("Device offline", logging.F("device_id", deviceID), logging.F("reason", d.Reason))
+}
+
+// Monitor marks devices offline once they have been silent for the timeout,
+// checking every interval until ctx is done
+func (p *Presence) Monitor(ctx context.Context) {
+	ticker := p.clock.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			p.sweep(now)
+		}
+	}
+}
+
+func (p *Presence) sweep(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, d := range p.devices {
+		if !d.Online {
+			continue
+		}
+		reason := ""
+		switch {
+		case now.Sub(d.LastSeen) > p.timeout:
+			reason = "silent"
+		case p.health.Stale(id, now):
+			reason = "health_stale"
+		default:
+			continue
+		}
+		d.Online, d.Since, d.Reason = false, now, reason
+		p.online.Dec()
+		p.logger.Warn("Device offline", logging.F("device_id", id), logging.F("last_seen", d.LastSeen.Format(time.RFC3339)),
+			logging.F("reason", reason))
+	}
+}
+
+// List returns the presence of every device seen, ordered by device ID
+func (p *Presence) List() []DevicePresence {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]DevicePresence, 0, len(p.devices))
+	for _, d := range p.devices {
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// WithPresence tracks which devices are online in p
+func WithPresence(p *Presence) Option {
+	return func(h *Handler) {
+		h.presence = p
+	}
+}
+
+// markSeen records activity of an authenticated device, if presence is
+// tracked
+func (h *Handler) markSeen(deviceID string) {
+	if h.presence != nil {
+		h.presence.Seen(deviceID)
+	}
+}
+
+// handleHeartbeat records a heartbeat and answers with the interval the
+// server expects them at
+func (h *Handler) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req HeartbeatRequest
+	if _, ok := h.decode(w, r, &req, "heartbeat"); !ok {
+		return
+	}
+	if !h.authorize(w, r, req.DeviceID) {
+		return
+	}
+	if h.presence == nil {
+		http.Error(w, "Heartbeats are disabled", http.StatusNotFound)
+		return
+	}
+	if req.DeviceID == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+	h.presence.Heartbeat(req.DeviceID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HeartbeatResponse{
+		DeviceID:   req.DeviceID,
+		Interval:   h.presence.interval.String(),
+		Timeout:    h.presence.timeout.String(),
+		ServerTime: h.clock.Now(),
+	})
+}
@@ -0,0 +1,111 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestPresenceOfflineAfterTimeout(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.HeartbeatConfig{Interval: 10 * time.Second, Timeout: 30 * time.Second}
+	p := NewPresence(cfg, nil, logging.Nop(), fake, metrics.NewRegistry())
+
+	p.Heartbeat("quiet")
+	p.Heartbeat("busy")
+	for i := 0; i < 6; i++ {
+		fake.Advance(cfg.Interval)
+		// Readings keep a device online without heartbeats
+		p.Seen("busy")
+		p.sweep(fake.Now())
+	}
+
+	quiet := presenceOf(t, p, "quiet")
+	if quiet.Online || quiet.Reason != "silent" {
+		t.Errorf("quiet device online %v, reason %q after %v of silence", quiet.Online, quiet.Reason, 6*cfg.Interval)
+	}
+	// It went offline on the first sweep past the timeout
+	if want := quiet.LastSeen.Add(cfg.Timeout + cfg.Interval); !quiet.Since.Equal(want) {
+		t.Errorf("offline since %v, want %v", quiet.Since, want)
+	}
+	if busy := presenceOf(t, p, "busy"); !busy.Online {
+		t.Error("device sending readings went offline")
+	}
+
+	p.Heartbeat("quiet")
+	if quiet := presenceOf(t, p, "quiet"); !quiet.Online || quiet.Reason != "" {
+		t.Errorf("quiet device online %v, reason %q after a heartbeat", quiet.Online, quiet.Reason)
+	}
+}
+
+func TestHeartbeatCarriesServerTime(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	presence := NewPresence(cfg.IoT.Heartbeat, nil, logging.Nop(), fake, reg)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(fake), WithPresence(presence))
+
+	fake.Advance(90 * time.Second)
+	rec := send(t, h, http.MethodPost, "/iot/heartbeat", `{"device_id": "dev1"}`)
+	var resp HeartbeatResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: status %d, %v", rec.Code, err)
+	}
+	if !resp.ServerTime.Equal(fake.Now()) {
+		t.Errorf("server_time %v, want %v", resp.ServerTime, fake.Now())
+	}
+	if resp.Interval != cfg.IoT.Heartbeat.Interval.String() || resp.Timeout != cfg.IoT.Heartbeat.Timeout.String() {
+		t.Errorf("interval %s and timeout %s, want %v and %v", resp.Interval, resp.Timeout, cfg.IoT.Heartbeat.Interval, cfg.IoT.Heartbeat.Timeout)
+	}
+}
+
+// TestPresenceFollowsHandlerActivity checks that readings accepted by the
+// handler keep a device online and that iot_devices_online follows the
+// transitions
+func TestPresenceFollowsHandlerActivity(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	presence := NewPresence(cfg.IoT.Heartbeat, nil, logging.Nop(), fake, reg)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(fake), WithPresence(presence))
+
+	send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21))
+	send(t, h, http.MethodPost, "/iot/heartbeat", `{"device_id": "dev2"}`)
+	if d := presenceOf(t, presence, "dev1"); !d.Online || !d.LastHeartbeat.IsZero() {
+		t.Errorf("after a reading got %+v, want online without a heartbeat", d)
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_online"); got != "2" {
+		t.Errorf("iot_devices_online = %s, want 2", got)
+	}
+
+	fake.Advance(cfg.IoT.Heartbeat.Timeout + time.Second)
+	send(t, h, http.MethodPost, "/iot/batch", "["+reading("dev1", 21)+"]")
+	presence.sweep(fake.Now())
+	if d := presenceOf(t, presence, "dev2"); d.Online || d.Reason != "silent" {
+		t.Errorf("device silent for the timeout got %+v, want offline", d)
+	}
+	presence.Disconnected("dev1")
+	if d := presenceOf(t, presence, "dev1"); d.Online || d.Reason != "disconnected" {
+		t.Errorf("disconnected device got %+v, want offline", d)
+	}
+	if got := metricValue(t, reg, "commsys_iot_devices_online"); got != "0" {
+		t.Errorf("iot_devices_online = %s, want 0", got)
+	}
+}
+
+func TestHeartbeatsDisabledWithoutPresence(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+	if rec := send(t, h, http.MethodPost, "/iot/heartbeat", `{"device_id": "dev1"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("heartbeat without presence tracking: status %d, want 404", rec.Code)
+	}
+}
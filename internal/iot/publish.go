@@ -0,0 +1,25 @@
+package iot
+
+// Publisher receives every accepted sensor reading, e.g. to forward it to
+// a message broker. Publish is called while the request is handled and
+// must not block.
+type Publisher interface {
+	Publish(data SensorData)
+}
+
+// WithPublisher passes accepted readings to p, after any publishers added
+// before it. A nil p is ignored.
+func WithPublisher(p Publisher) Option {
+	return func(h *Handler) {
+		if p != nil {
+			h.publishers = append(h.publishers, p)
+		}
+	}
+}
+
+// publish passes an accepted reading to the publishers
+func (h *Handler) publish(data SensorData) {
+	for _, p := range h.publishers {
+		p.Publish(data)
+	}
+}
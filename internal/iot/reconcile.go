@@ -0,0 +1,240 @@
+package iot
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SyncStateAction is the action of the command that resends a device the
+// part of its desired state it hasn't reported. Its parameters are the
+// desired "version" and the "delta" of properties to apply. The device
+// applies them and reports its state as for the DesiredHeader.
+const SyncStateAction = "sync_state"
+
+// AlertTwinDiverged is the kind of alert raised for a device whose reported
+// state hasn't converged to its desired state within the deadline
+const AlertTwinDiverged = "twin_diverged"
+
+// DivergedTwin is a device whose reported state hasn't converged to its
+// desired state: it hasn't applied the desired version yet, or reported
+// other values than desired
+type DivergedTwin struct {
+	DeviceID        string                 `json:"device_id"`
+	DesiredVersion  int64                  `json:"desired_version"`
+	ReportedVersion int64                  `json:"reported_version"`
+	Delta           map[string]interface{} `json:"delta"` // desired properties not reported with their desired value
+	Since           time.Time              `json:"since"` // when the desired version was set
+	Age             string                 `json:"age"`   // e.g. "4m30s"
+	Attempts        int                    `json:"attempts"`
+	LastAttempt     time.Time              `json:"last_attempt"`         // zero if the delta was never resent
+	CommandID       string                 `json:"command_id,omitempty"` // of the last resent delta
+	Escalated       bool                   `json:"escalated"`
+}
+
+// diverged reports whether the reported state of t hasn't converged
+func (t DeviceTwin) diverged() bool {
+	return t.Pending() || len(t.Conflicts) > 0
+}
+
+// delta returns the desired properties of t that weren't reported with
+// their desired value
+func (t DeviceTwin) delta() map[string]interface{} {
+	delta := make(map[string]interface{})
+	for name, want := range t.Desired {
+		if got, ok := t.Reported[name]; !ok || !reflect.DeepEqual(got, want) {
+			delta[name] = want
+		}
+	}
+	return delta
+}
+
+// Reconciler periodically scans the twins for devices whose reported state
+// hasn't converged to their desired state. Each command TTL a device stays
+// diverged, its delta is resent as a SyncStateAction command through the
+// outbox, replacing the previous one if the device never fetched it, and
+// within the device's message rate. A device still diverged after the
+// deadline is escalated with an AlertTwinDiverged alert, once per desired
+// version, and left to the operator.
+type Reconciler struct {
+	cfg     config.ReconcileConfig
+	twins   *Twins
+	outbox  *Outbox
+	limiter *RateLimiter // nil if devices aren't rate limited
+	alerts  *Alerts
+	logger  logging.Logger
+	clock   clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*reconcileState
+
+	diverged prometheus.Gauge
+	attempts *metrics.CounterVec
+}
+
+// reconcileState is the reconciliation of one desired version of a device
+type reconcileState struct {
+	version     int64
+	attempts    int
+	lastAttempt time.Time
+	commandID   string
+	escalated   bool
+}
+
+// NewReconciler creates a reconciler with the settings of cfg, resending
+// deltas within the message rates of limiter. Passing the limiter of the
+// IoT handler counts resends against the rate of the device's own
+// messages. It returns nil if the job is disabled.
+func NewReconciler(cfg config.ReconcileConfig, twins *Twins, outbox *Outbox, limiter *RateLimiter, alerts *Alerts, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Reconciler {
+	if cfg.Interval <= 0 {
+		return nil
+	}
+	return &Reconciler{
+		cfg:      cfg,
+		twins:    twins,
+		outbox:   outbox,
+		limiter:  limiter,
+		alerts:   alerts,
+		logger:   logger,
+		clock:    c,
+		devices:  make(map[string]*reconcileState),
+		diverged: reg.Gauge("iot", "twins_diverged", "Devices whose reported state hasn't converged to their desired state"),
+		attempts: reg.CounterVec("iot", "reconcile_attempts_total", "Deltas the reconciler resent or held back by result", "result"),
+	}
+}
+
+// Run scans the twins every interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := r.clock.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C():
+			r.reconcile(now)
+		}
+	}
+}
+
+// reconcile resends or escalates each diverged twin that is due, and
+// forgets the devices that converged
+func (r *Reconciler) reconcile(now time.Time) {
+	twins := r.twins.List()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, twin := range twins {
+		if !twin.diverged() {
+			continue
+		}
+		seen[twin.DeviceID] = true
+		s := r.devices[twin.DeviceID]
+		if s == nil || s.version != twin.DesiredVersion {
+			// A new desired version starts over
+			s = &reconcileState{version: twin.DesiredVersion}
+			r.devices[twin.DeviceID] = s
+		}
+		switch {
+		case s.escalated:
+		case now.Sub(twin.DesiredAt) > r.cfg.Deadline:
+			s.escalated = true
+			r.alerts.Raise(Alert{
+				Kind:     AlertTwinDiverged,
+				DeviceID: twin.DeviceID,
+				Message: fmt.Sprintf("reported state hasn't converged to desired version %d within %v after %d attempts",
+					twin.DesiredVersion, r.cfg.Deadline, s.attempts),
+				Value: now.Sub(twin.DesiredAt).Seconds(),
+				At:    now,
+			})
+		default:
+			r.resendLocked(twin, s, now)
+		}
+	}
+	for id := range r.devices {
+		if !seen[id] {
+			delete(r.devices, id)
+		}
+	}
+	r.diverged.Set(float64(len(seen)))
+}
+
+// resendLocked resends the delta of twin once the command TTL has passed
+// since it was set or last resent. r.mu must be held.
+func (r *Reconciler) resendLocked(twin DeviceTwin, s *reconcileState, now time.Time) {
+	due := twin.DesiredAt
+	if !s.lastAttempt.IsZero() {
+		due = s.lastAttempt
+	}
+	if now.Sub(due) < r.cfg.CommandTTL {
+		return
+	}
+	if r.limiter != nil {
+		if ok, _ := r.limiter.Allow(twin.DeviceID); !ok {
+			r.attempts.WithLabelValues("rate_limited").Inc()
+			return
+		}
+	}
+	// A delta the device never fetched has expired
+	if status, ok := r.outbox.Status(s.commandID); ok && status.Status == CommandQueued {
+		r.outbox.Cancel(s.commandID)
+	}
+
+	s.lastAttempt = now
+	cmd, err := r.outbox.Enqueue(Command{
+		DeviceID:   twin.DeviceID,
+		Action:     SyncStateAction,
+		Parameters: map[string]interface{}{"version": twin.DesiredVersion, "delta": twin.delta()},
+		Priority:   "medium",
+	})
+	if err != nil {
+		r.attempts.WithLabelValues("refused").Inc()
+		r.logger.Warn("Desired state not resent", logging.F("device_id", twin.DeviceID),
+			logging.F("version", twin.DesiredVersion), logging.Err(err))
+		return
+	}
+	s.attempts++
+	s.commandID = cmd.ID
+	r.attempts.WithLabelValues("sent").Inc()
+	r.logger.Info("Desired state resent", logging.F("device_id", twin.DeviceID), logging.F("version", twin.DesiredVersion),
+		logging.F("attempt", s.attempts), logging.F("command_id", cmd.ID))
+}
+
+// Diverged returns the devices whose reported state hasn't converged to
+// their desired state, ordered by device ID
+func (r *Reconciler) Diverged() []DivergedTwin {
+	now := r.clock.Now()
+	twins := r.twins.List()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := []DivergedTwin{}
+	for _, twin := range twins {
+		if !twin.diverged() {
+			continue
+		}
+		d := DivergedTwin{
+			DeviceID:        twin.DeviceID,
+			DesiredVersion:  twin.DesiredVersion,
+			ReportedVersion: twin.ReportedVersion,
+			Delta:           twin.delta(),
+			Since:           twin.DesiredAt,
+			Age:             now.Sub(twin.DesiredAt).Round(time.Second).String(),
+		}
+		if s := r.devices[twin.DeviceID]; s != nil && s.version == twin.DesiredVersion {
+			d.Attempts, d.LastAttempt, d.CommandID, d.Escalated = s.attempts, s.lastAttempt, s.commandID, s.escalated
+		}
+		out = append(out, d)
+	}
+	return out
+}
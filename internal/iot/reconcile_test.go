@@ -0,0 +1,221 @@
+package iot
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+type reconcileFixture struct {
+	clock      *clock.Fake
+	twins      *Twins
+	outbox     *Outbox
+	alerts     *Alerts
+	raised     <-chan Alert
+	limiter    *RateLimiter
+	reconciler *Reconciler
+}
+
+func newReconcileFixture(t *testing.T, cfg config.IoTConfig) *reconcileFixture {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	f := &reconcileFixture{clock: clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))}
+	f.twins = NewTwins(logging.Nop(), f.clock)
+	f.outbox = NewOutbox(cfg.Commands, logging.Nop(), nil, f.clock, reg)
+	f.alerts = NewAlerts(logging.Nop(), reg)
+	f.raised = f.alerts.Subscribe(1000)
+	f.limiter = NewRateLimiter(cfg, f.clock)
+	f.reconciler = NewReconciler(cfg.Reconcile, f.twins, f.outbox, f.limiter, f.alerts, logging.Nop(), f.clock, reg)
+	if f.reconciler == nil {
+		t.Fatal("reconciler is disabled")
+	}
+	return f
+}
+
+// advance moves the clock on by d and runs a scan
+func (f *reconcileFixture) advance(d time.Duration) {
+	f.clock.Advance(d)
+	f.reconciler.reconcile(f.clock.Now())
+}
+
+// escalated returns the devices escalated since the last call
+func (f *reconcileFixture) escalated() int {
+	n := 0
+	for {
+		select {
+		case a := <-f.raised:
+			if a.Kind == AlertTwinDiverged {
+				n++
+			}
+		default:
+			return n
+		}
+	}
+}
+
+func TestReconcilerEscalatesOnlyAfterDeadline(t *testing.T) {
+	const devices = 100
+	cfg := config.Default().IoT
+	f := newReconcileFixture(t, cfg)
+	deadline := cfg.Reconcile.Deadline
+
+	for i := 0; i < devices; i++ {
+		if _, err := f.twins.SetDesired(fmt.Sprintf("dev%03d", i), map[string]interface{}{"interval": "30s"}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(f.reconciler.Diverged()); got != devices {
+		t.Fatalf("%d diverged twins, want %d", got, devices)
+	}
+
+	for elapsed := cfg.Reconcile.Interval; elapsed <= deadline; elapsed += cfg.Reconcile.Interval {
+		f.advance(cfg.Reconcile.Interval)
+		if n := f.escalated(); n > 0 {
+			t.Fatalf("%d devices escalated %v after their desired state was set, within the %v deadline", n, elapsed, deadline)
+		}
+	}
+	f.advance(cfg.Reconcile.Interval)
+	if n := f.escalated(); n != devices {
+		t.Fatalf("%d devices escalated after the deadline, want %d", n, devices)
+	}
+	for _, d := range f.reconciler.Diverged() {
+		if !d.Escalated {
+			t.Fatalf("%s not escalated after the deadline", d.DeviceID)
+		}
+	}
+
+	// Escalated once per desired version
+	f.advance(cfg.Reconcile.Interval)
+	if n := f.escalated(); n != 0 {
+		t.Errorf("%d escalations after another scan, want none", n)
+	}
+}
+
+func TestReconcilerResendsDeltaAfterCommandTTL(t *testing.T) {
+	cfg := config.Default().IoT
+	cfg.Reconcile.Interval = time.Second
+	cfg.Reconcile.CommandTTL = time.Minute
+	f := newReconcileFixture(t, cfg)
+
+	f.twins.SetDesired("dev1", map[string]interface{}{"interval": "30s", "threshold": 5.0}, 0)
+	f.twins.Report(StateReport{DeviceID: "dev1", Properties: map[string]interface{}{"threshold": 5.0}})
+
+	f.advance(30 * time.Second)
+	if got := len(f.outbox.List("dev1", "")); got != 0 {
+		t.Fatalf("%d commands queued before the command TTL", got)
+	}
+
+	f.advance(31 * time.Second)
+	cmds := f.outbox.List("dev1", CommandQueued)
+	if len(cmds) != 1 || cmds[0].Action != SyncStateAction {
+		t.Fatalf("queued %+v, want one %s command", cmds, SyncStateAction)
+	}
+	first := cmds[0].ID
+	sent := f.outbox.take("dev1")
+	delta, _ := sent[0].Parameters["delta"].(map[string]interface{})
+	if len(delta) != 1 || delta["interval"] != "30s" {
+		t.Errorf("delta %v, want only the unreported interval", delta)
+	}
+	if v, _ := sent[0].Parameters["version"].(int64); v != 1 {
+		t.Errorf("version parameter %v, want 1", sent[0].Parameters["version"])
+	}
+
+	// The next attempt waits for the TTL again; a fetched delta isn't
+	// cancelled
+	f.advance(59 * time.Second)
+	if d := f.reconciler.Diverged()[0]; d.Attempts != 1 || d.CommandID != first {
+		t.Fatalf("after %d attempts, last %s, want 1 attempt", d.Attempts, d.CommandID)
+	}
+	f.advance(2 * time.Second)
+	if d := f.reconciler.Diverged()[0]; d.Attempts != 2 {
+		t.Fatalf("%d attempts after two TTLs, want 2", d.Attempts)
+	}
+	if s, _ := f.outbox.Status(first); s.Status != CommandSent {
+		t.Errorf("fetched delta is %s, want %s", s.Status, CommandSent)
+	}
+
+	// A delta never fetched is replaced
+	second := f.reconciler.Diverged()[0].CommandID
+	f.advance(61 * time.Second)
+	if s, _ := f.outbox.Status(second); s.Status != CommandCancelled {
+		t.Errorf("unfetched delta is %s, want %s", s.Status, CommandCancelled)
+	}
+	if got := len(f.outbox.List("dev1", CommandQueued)); got != 1 {
+		t.Errorf("%d deltas queued, want 1", got)
+	}
+
+	// Converged devices are forgotten
+	f.twins.Report(StateReport{DeviceID: "dev1", Version: 1, Properties: map[string]interface{}{"interval": "30s"}})
+	f.advance(time.Second)
+	if got := f.reconciler.Diverged(); len(got) != 0 {
+		t.Errorf("diverged %+v after the device converged", got)
+	}
+	if len(f.reconciler.devices) != 0 {
+		t.Errorf("reconciler still tracks %d devices", len(f.reconciler.devices))
+	}
+}
+
+func TestReconcilerRespectsDeviceRateLimit(t *testing.T) {
+	cfg := config.Default().IoT
+	cfg.Reconcile.Interval = time.Second
+	cfg.Reconcile.CommandTTL = time.Second
+	cfg.DeviceRates = map[string]float64{"slow": 0.1}
+	f := newReconcileFixture(t, cfg)
+
+	f.twins.SetDesired("slow", map[string]interface{}{"interval": "30s"}, 0)
+	f.twins.SetDesired("fast", map[string]interface{}{"interval": "30s"}, 0)
+	for i := 0; i < 5; i++ {
+		f.advance(2 * time.Second)
+	}
+	attempts := make(map[string]int)
+	for _, d := range f.reconciler.Diverged() {
+		attempts[d.DeviceID] = d.Attempts
+	}
+	// One message every 10s for slow, no limit for fast
+	if attempts["slow"] != 1 || attempts["fast"] != 5 {
+		t.Errorf("attempts %v, want slow 1 and fast 5", attempts)
+	}
+}
+
+func TestReconcilerSharesHandlerRateLimit(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Reconcile.Interval = time.Second
+	cfg.IoT.Reconcile.CommandTTL = time.Second
+	cfg.IoT.DeviceRates = map[string]float64{"slow": 0.1}
+	f := newReconcileFixture(t, cfg.IoT)
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(f.clock), WithRateLimiter(f.limiter))
+
+	f.twins.SetDesired("slow", map[string]interface{}{"interval": "30s"}, 0)
+	// The device's own reading takes its one message every 10s
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("slow", 21)); rec.Code != http.StatusOK {
+		t.Fatalf("reading: status %d", rec.Code)
+	}
+	f.advance(2 * time.Second)
+	if d := f.reconciler.Diverged()[0]; d.Attempts != 0 {
+		t.Fatalf("%d attempts right after a reading of the device, want 0", d.Attempts)
+	}
+	f.advance(8 * time.Second)
+	if d := f.reconciler.Diverged()[0]; d.Attempts != 1 {
+		t.Errorf("%d attempts once the rate allows one, want 1", d.Attempts)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("slow", 21)); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("reading after the resend: status %d, want 429", rec.Code)
+	}
+}
+
+func TestReconcilerDisabled(t *testing.T) {
+	cfg := config.Default().IoT
+	cfg.Reconcile.Interval = 0
+	if r := NewReconciler(cfg.Reconcile, nil, nil, nil, nil, logging.Nop(), clock.Real(), metrics.NewRegistry()); r != nil {
+		t.Error("reconciler created with a zero interval")
+	}
+}
@@ -0,0 +1,282 @@
+package iot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+)
+
+// maxTraceLine is the longest line a trace may have
+const maxTraceLine = 64 * 1024
+
+// ReplayOptions control how a trace is replayed
+type ReplayOptions struct {
+	Speed     float64           // 2 replays twice as fast; 0 means 1
+	Loop      bool              // start over at the end of the trace
+	DeviceMap map[string]string // trace device ID to the ID to send as; unmapped IDs are kept
+}
+
+// ReplayStats counts what a replay did
+type ReplayStats struct {
+	Sent    int64 `json:"sent"`
+	Failed  int64 `json:"failed"`  // send returned an error
+	Skipped int64 `json:"skipped"` // malformed lines
+	Loops   int64 `json:"loops"`   // times the trace started over
+}
+
+// ErrEmptyTrace is returned when a looped trace has no valid readings
+var ErrEmptyTrace = errors.New("trace has no valid readings")
+
+// Replayer replays recorded sensor readings with the gaps between them.
+// A trace is CSV or JSON lines, told apart by its first line. CSV columns
+// are device_id, type, value, unit and timestamp, in that order unless a
+// header row names them; fields can't contain commas. JSON lines have the
+// same fields, with sensor_type accepted for type. Timestamps are RFC 3339
+// or Unix seconds. Blank lines are ignored; lines that don't parse are
+// skipped and counted.
+type Replayer struct {
+	opts  ReplayOptions
+	clock clock.Clock
+	stats ReplayStats
+}
+
+// NewReplayer creates a replayer that waits on c
+func NewReplayer(opts ReplayOptions, c clock.Clock) *Replayer {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+	return &Replayer{opts: opts, clock: c}
+}
+
+// Stats returns what the last Run did
+func (rp *Replayer) Stats() ReplayStats {
+	return rp.stats
+}
+
+// Run replays trace until its end, or until ctx is done when looping. The
+// first reading is sent right away and each later one when its offset from
+// the first, divided by the speed, has passed. Readings that are out of
+// order are sent right after the one before. Sent readings carry the time
+// they are sent as their timestamp. A send error is counted and the replay
+// goes on. A looped trace starts over right after its last reading.
+func (rp *Replayer) Run(ctx context.Context, trace io.ReadSeeker, send func(SensorData) error) error {
+	rp.stats = ReplayStats{}
+	for {
+		sent := rp.stats.Sent + rp.stats.Failed
+		if err := rp.replay(ctx, trace, send); err != nil {
+			return err
+		}
+		if !rp.opts.Loop {
+			return nil
+		}
+		if rp.stats.Sent+rp.stats.Failed == sent {
+			return ErrEmptyTrace
+		}
+		if _, err := trace.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("rewind trace: %w", err)
+		}
+		rp.stats.Loops++
+	}
+}
+
+// replay replays trace once
+func (rp *Replayer) replay(ctx context.Context, trace io.Reader, send func(SensorData) error) error {
+	scanner := bufio.NewScanner(trace)
+	scanner.Buffer(make([]byte, 0, 4096), maxTraceLine)
+	var parse func(line []byte) (SensorData, error)
+	var first time.Time // timestamp of the first reading
+	var start time.Time // when it was sent
+	var last time.Duration
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if parse == nil {
+			if line[0] == '{' {
+				parse = parseJSONReading
+			} else {
+				columns := newCSVColumns(line)
+				parse = columns.parse
+				if columns.header {
+					continue
+				}
+			}
+		}
+		data, err := parse(line)
+		if err != nil {
+			rp.stats.Skipped++
+			continue
+		}
+
+		if first.IsZero() {
+			first, start = data.Timestamp, rp.clock.Now()
+		}
+		offset := time.Duration(float64(data.Timestamp.Sub(first)) / rp.opts.Speed)
+		last = max(last, offset)
+		if wait := start.Add(last).Sub(rp.clock.Now()); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-rp.clock.After(wait):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if id, ok := rp.opts.DeviceMap[data.DeviceID]; ok {
+			data.DeviceID = id
+		}
+		data.Timestamp = rp.clock.Now()
+		if err := send(data); err != nil {
+			rp.stats.Failed++
+		} else {
+			rp.stats.Sent++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read trace: %w", err)
+	}
+	return nil
+}
+
+// ParseDeviceMap parses a device map such as "sensor-7=dev-1,sensor-9=dev-2"
+func ParseDeviceMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("%q is not original=simulated", pair)
+		}
+		if _, dup := m[from]; dup {
+			return nil, fmt.Errorf("device %q mapped twice", from)
+		}
+		m[from] = to
+	}
+	return m, nil
+}
+
+// traceReading is a JSON line of a trace
+type traceReading struct {
+	DeviceID   string          `json:"device_id"`
+	Type       string          `json:"type"`
+	SensorType string          `json:"sensor_type"`
+	Value      *float64        `json:"value"`
+	Unit       string          `json:"unit"`
+	Timestamp  json.RawMessage `json:"timestamp"`
+}
+
+func parseJSONReading(line []byte) (SensorData, error) {
+	var r traceReading
+	if err := json.Unmarshal(line, &r); err != nil {
+		return SensorData{}, err
+	}
+	if r.Type == "" {
+		r.Type = r.SensorType
+	}
+	if r.Value == nil {
+		return SensorData{}, errors.New("no value")
+	}
+	ts, err := parseTraceTime(strings.Trim(string(r.Timestamp), `"`))
+	if err != nil {
+		return SensorData{}, err
+	}
+	return newTraceReading(r.DeviceID, r.Type, *r.Value, r.Unit, ts)
+}
+
+// csvColumns is the index of each field in the rows of a CSV trace
+type csvColumns struct {
+	deviceID, sensorType, value, unit, timestamp int
+	header                                       bool
+}
+
+// newCSVColumns takes the columns from the header row of a CSV trace, or
+// the default order if the first row isn't a header
+func newCSVColumns(first []byte) *csvColumns {
+	c := &csvColumns{0, 1, 2, 3, 4, false}
+	fields := strings.Split(string(first), ",")
+	index := make(map[string]int, len(fields))
+	for i, f := range fields {
+		index[strings.ToLower(strings.TrimSpace(f))] = i
+	}
+	if _, ok := index["device_id"]; !ok {
+		return c
+	}
+	c.header = true
+	for _, col := range []struct {
+		field *int
+		names []string
+	}{
+		{&c.deviceID, []string{"device_id"}},
+		{&c.sensorType, []string{"type", "sensor_type"}},
+		{&c.value, []string{"value"}},
+		{&c.unit, []string{"unit"}},
+		{&c.timestamp, []string{"timestamp"}},
+	} {
+		*col.field = -1
+		for _, name := range col.names {
+			if i, ok := index[name]; ok {
+				*col.field = i
+				break
+			}
+		}
+	}
+	return c
+}
+
+func (c *csvColumns) parse(line []byte) (SensorData, error) {
+	fields := strings.Split(string(line), ",")
+	field := func(i int) string {
+		if i < 0 || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+	value, err := strconv.ParseFloat(field(c.value), 64)
+	if err != nil {
+		return SensorData{}, err
+	}
+	ts, err := parseTraceTime(field(c.timestamp))
+	if err != nil {
+		return SensorData{}, err
+	}
+	return newTraceReading(field(c.deviceID), field(c.sensorType), value, field(c.unit), ts)
+}
+
+// parseTraceTime parses an RFC 3339 time or Unix seconds, e.g. 1700000000.25
+func parseTraceTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) || secs <= 0 {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
+
+func newTraceReading(deviceID, sensorType string, value float64, unit string, ts time.Time) (SensorData, error) {
+	if deviceID == "" || sensorType == "" {
+		return SensorData{}, errors.New("device_id and type are required")
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return SensorData{}, errors.New("value is not a number")
+	}
+	quality := "reliable"
+	if sensorType == "motion" {
+		quality = "unreliable"
+	}
+	return SensorData{DeviceID: deviceID, SensorType: sensorType, Value: value, Unit: unit, Timestamp: ts, Quality: quality}, nil
+}
@@ -0,0 +1,144 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+)
+
+// skipClock is a fake clock on which waiting takes no time: After moves
+// the clock on by the wait and fires at once
+type skipClock struct {
+	*clock.Fake
+}
+
+func (c skipClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.Now()
+	return fired
+}
+
+// sentReading is a reading a replay sent, with its offset from the start
+type sentReading struct {
+	data SensorData
+	at   time.Duration
+}
+
+// replay runs trace on a skipClock and returns what it sent
+func replay(t *testing.T, ctx context.Context, opts ReplayOptions, trace string, send func(SensorData) error) ([]sentReading, *Replayer, error) {
+	t.Helper()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := skipClock{clock.NewFake(start)}
+	rp := NewReplayer(opts, c)
+	var sent []sentReading
+	err := rp.Run(ctx, strings.NewReader(trace), func(data SensorData) error {
+		sent = append(sent, sentReading{data, c.Now().Sub(start)})
+		if send != nil {
+			return send(data)
+		}
+		return nil
+	})
+	return sent, rp, err
+}
+
+func TestReplayerScalesGaps(t *testing.T) {
+	trace := strings.Join([]string{
+		"timestamp,value,device_id,type,unit",
+		"2024-05-01T10:00:00Z,21.5,sensor-7,temperature,celsius",
+		"2024-05-01T10:00:10Z,21.7,sensor-9,humidity,percent",
+		"not,a,reading",
+		"",
+		"2024-05-01T10:00:30Z,22,sensor-7,temperature,celsius",
+		// Out of order, so sent right after the one before
+		"2024-05-01T10:00:20Z,1,sensor-7,motion,boolean",
+	}, "\n")
+	sent, rp, err := replay(t, context.Background(), ReplayOptions{Speed: 2, DeviceMap: map[string]string{"sensor-7": "dev-1"}}, trace, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		deviceID, sensorType string
+		value                float64
+		at                   time.Duration
+	}{
+		{"dev-1", SensorTemperature, 21.5, 0},
+		{"sensor-9", SensorHumidity, 21.7, 5 * time.Second},
+		{"dev-1", SensorTemperature, 22, 15 * time.Second},
+		{"dev-1", SensorMotion, 1, 15 * time.Second},
+	}
+	if len(sent) != len(want) {
+		t.Fatalf("sent %d readings, want %d", len(sent), len(want))
+	}
+	for i, w := range want {
+		got := sent[i]
+		if got.data.DeviceID != w.deviceID || got.data.SensorType != w.sensorType || got.data.Value != w.value || got.at != w.at {
+			t.Errorf("reading %d: %s %s %v at %v, want %s %s %v at %v", i,
+				got.data.DeviceID, got.data.SensorType, got.data.Value, got.at, w.deviceID, w.sensorType, w.value, w.at)
+		}
+		if !got.data.Timestamp.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Add(w.at)) {
+			t.Errorf("reading %d stamped %v, want the time it was sent", i, got.data.Timestamp)
+		}
+	}
+	if sent[3].data.Quality != "unreliable" {
+		t.Errorf("motion reading has quality %q, want unreliable", sent[3].data.Quality)
+	}
+	if s := rp.Stats(); s != (ReplayStats{Sent: 4, Skipped: 1}) {
+		t.Errorf("got stats %+v, want 4 sent and 1 skipped", s)
+	}
+}
+
+func TestReplayerLoopsJSONLines(t *testing.T) {
+	trace := `{"device_id": "dev1", "sensor_type": "temperature", "value": 20, "unit": "celsius", "timestamp": 1700000000}
+{"device_id": "dev1", "type": "temperature", "value": 21, "unit": "celsius", "timestamp": "1700000000.5"}
+{"device_id": "dev1", "type": "temperature", "unit": "celsius", "timestamp": 1700000001}
+`
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failed := errors.New("refused")
+	n := 0
+	sent, rp, err := replay(t, ctx, ReplayOptions{Loop: true}, trace, func(SensorData) error {
+		n++
+		switch n {
+		case 3:
+			return failed
+		case 4:
+			// Checked before the first reading of the third loop
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("looped replay returned %v, want it cancelled", err)
+	}
+	if len(sent) != 4 || sent[1].at != 500*time.Millisecond || sent[2].at != 500*time.Millisecond {
+		t.Errorf("sent %+v, want 4 readings with the second loop right after the first", sent)
+	}
+	if s := rp.Stats(); s != (ReplayStats{Sent: 3, Failed: 1, Skipped: 2, Loops: 2}) {
+		t.Errorf("got stats %+v, want 3 sent, 1 failed, 2 skipped and 2 loops", s)
+	}
+}
+
+func TestReplayerEmptyLoopedTrace(t *testing.T) {
+	_, _, err := replay(t, context.Background(), ReplayOptions{Loop: true}, "device_id,type,value,unit,timestamp\nbad,line\n", nil)
+	if !errors.Is(err, ErrEmptyTrace) {
+		t.Errorf("looping a trace without readings returned %v, want ErrEmptyTrace", err)
+	}
+}
+
+func TestParseDeviceMap(t *testing.T) {
+	m, err := ParseDeviceMap("sensor-7=dev-1, sensor-9=dev-2")
+	if err != nil || len(m) != 2 || m["sensor-7"] != "dev-1" || m["sensor-9"] != "dev-2" {
+		t.Errorf("got %v, %v", m, err)
+	}
+	for _, s := range []string{"sensor-7", "=dev-1", "sensor-7=", "a=b,a=c"} {
+		if _, err := ParseDeviceMap(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
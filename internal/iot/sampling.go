@@ -0,0 +1,125 @@
+package iot
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// IntervalHeader carries a device's reporting interval, e.g. "30s". Devices
+// send their current interval with each sensor reading; the server answers
+// with a different one when the sampling policy wants it changed.
+const IntervalHeader = "X-IoT-Interval"
+
+// SamplingPolicy adjusts device reporting intervals from the variance of
+// their recent readings. A stable series has its interval doubled once a
+// full window of readings has arrived since the last change; a volatile one
+// has it halved on every reading until it settles or reaches the minimum.
+// The history of a device that sends no reading for the idle timeout is
+// forgotten.
+type SamplingPolicy struct {
+	cfg    config.SamplingConfig
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	devices map[string]*samplingState
+	sweep   idleSweep
+}
+
+type samplingState struct {
+	readings []float64 // last cfg.Window readings, oldest first
+	since    int       // readings since the interval last changed
+	last     time.Time // when the last reading arrived
+}
+
+// NewSamplingPolicy creates a policy with no device history that forgets
+// devices silent for idle, unless it is 0. Every interval change is logged
+// to logger as an audit trail.
+func NewSamplingPolicy(cfg config.SamplingConfig, idle time.Duration, logger logging.Logger, c clock.Clock) *SamplingPolicy {
+	return &SamplingPolicy{
+		cfg:     cfg,
+		logger:  logger,
+		clock:   c,
+		devices: make(map[string]*samplingState),
+		sweep:   idleSweep{after: idle},
+	}
+}
+
+// Observe records a reading the device took at interval and returns the
+// interval it should report at from now on
+func (p *SamplingPolicy) Observe(deviceID string, value float64, interval time.Duration) time.Duration {
+	now := p.clock.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sweepIdle(&p.sweep, p.devices, now, func(s *samplingState) time.Time { return s.last }, nil)
+	s, ok := p.devices[deviceID]
+	if !ok {
+		s = &samplingState{readings: make([]float64, 0, p.cfg.Window)}
+		p.devices[deviceID] = s
+	}
+	s.last = now
+	if len(s.readings) == p.cfg.Window {
+		copy(s.readings, s.readings[1:])
+		s.readings = s.readings[:len(s.readings)-1]
+	}
+	s.readings = append(s.readings, value)
+	s.since++
+
+	if len(s.readings) < 2 {
+		return interval
+	}
+	sd := stdDev(s.readings)
+
+	next, reason := interval, ""
+	switch {
+	case sd > p.cfg.VolatileStdDev && interval > p.cfg.MinInterval:
+		next, reason = max(interval/2, p.cfg.MinInterval), "volatile"
+	case sd < p.cfg.StableStdDev && s.since >= p.cfg.Window && interval < p.cfg.MaxInterval:
+		next, reason = min(interval*2, p.cfg.MaxInterval), "stable"
+	}
+	if next == interval {
+		return interval
+	}
+
+	s.since = 0
+	p.logger.Info("Sampling interval changed", logging.F("device_id", deviceID), logging.F("from", interval),
+		logging.F("to", next), logging.F("reason", reason), logging.F("stddev", sd))
+	return next
+}
+
+func stdDev(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sq / float64(len(values)))
+}
+
+// adjustInterval feeds a reading to the sampling policy and answers with a
+// new interval when it changes. Devices that don't report their interval
+// can't be adjusted and are left alone.
+func (h *Handler) adjustInterval(w http.ResponseWriter, r *http.Request, data SensorData) {
+	if h.sampling == nil {
+		return
+	}
+	interval, err := time.ParseDuration(r.Header.Get(IntervalHeader))
+	if err != nil || interval <= 0 {
+		return
+	}
+	if next := h.sampling.Observe(data.DeviceID, data.Value, interval); next != interval {
+		w.Header().Set(IntervalHeader, next.String())
+	}
+}
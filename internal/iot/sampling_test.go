@@ -0,0 +1,88 @@
+package iot
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestSamplingPolicy(t *testing.T) {
+	cfg := config.Default().IoT.Sampling
+	cfg.Window = 4
+	p := NewSamplingPolicy(cfg, 0, logging.Nop(), clock.Real())
+
+	// A stable series is widened once a full window has arrived, and again a
+	// window later
+	interval := 10 * time.Second
+	var changes []time.Duration
+	for i := 0; i < 8; i++ {
+		if next := p.Observe("dev1", 21, interval); next != interval {
+			changes = append(changes, next)
+			interval = next
+		}
+	}
+	if len(changes) != 2 || changes[0] != 20*time.Second || changes[1] != 40*time.Second {
+		t.Errorf("stable series changed the interval to %v, want 20s then 40s", changes)
+	}
+
+	// A volatile one is narrowed on every reading down to the minimum
+	for _, v := range []float64{30, 10, 30} {
+		interval = p.Observe("dev1", v, interval)
+	}
+	if interval != cfg.MinInterval {
+		t.Errorf("interval %v after three volatile readings, want the minimum %v", interval, cfg.MinInterval)
+	}
+	if next := p.Observe("dev1", 10, interval); next != cfg.MinInterval {
+		t.Errorf("interval %v below the minimum", next)
+	}
+
+	// Nor is a stable series widened past the maximum
+	for i := 0; i < 4; i++ {
+		if next := p.Observe("dev2", 5, cfg.MaxInterval); next != cfg.MaxInterval {
+			t.Fatalf("interval %v above the maximum", next)
+		}
+	}
+}
+
+func TestSensorAnswersWithNewInterval(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Sampling.Enabled = true
+	cfg.IoT.Sampling.Window = 2
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21), IntervalHeader, "30s"); rec.Header().Get(IntervalHeader) != "" {
+		t.Errorf("first reading answered with interval %q", rec.Header().Get(IntervalHeader))
+	}
+	rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21), IntervalHeader, "30s")
+	if rec.Code != http.StatusOK || rec.Header().Get(IntervalHeader) != "1m0s" {
+		t.Errorf("stable readings: status %d, interval %q, want 1m0s", rec.Code, rec.Header().Get(IntervalHeader))
+	}
+	// Devices that don't report their interval are left alone
+	for i := 0; i < 3; i++ {
+		if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev2", 21)); rec.Header().Get(IntervalHeader) != "" {
+			t.Errorf("device without an interval answered with %q", rec.Header().Get(IntervalHeader))
+		}
+	}
+}
+
+func TestSamplingPolicyForgetsIdleDevices(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	p := NewSamplingPolicy(config.Default().IoT.Sampling, time.Hour, logging.Nop(), c)
+	p.Observe("idle", 21, 10*time.Second)
+
+	c.Advance(2 * time.Hour)
+	for i := 0; i < idleSweepEvery; i++ {
+		p.Observe("busy", 21, 10*time.Second)
+	}
+	if _, ok := p.devices["idle"]; ok || len(p.devices) != 1 {
+		t.Errorf("history kept for %d devices, want only the busy one", len(p.devices))
+	}
+}
@@ -0,0 +1,140 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// Sensor types known to the server. Readings of other types are accepted,
+// but devices registering with one are warned.
+const (
+	SensorTemperature = "temperature"
+	SensorHumidity    = "humidity"
+	SensorMotion      = "motion"
+	SensorPressure    = "pressure"
+	SensorLight       = "light"
+)
+
+// SensorTypes maps each known sensor type to the built-in rule its readings
+// are validated against. The first unit is the one devices should send.
+var SensorTypes = map[string]config.SensorRule{
+	SensorTemperature: {Min: -40, Max: 85, Units: []string{"celsius"}},
+	SensorHumidity:    {Min: 0, Max: 100, Units: []string{"percent"}},
+	SensorMotion:      {Min: 0, Max: 1, Units: []string{"boolean"}, Integer: true},
+	SensorPressure:    {Min: 300, Max: 1100, Units: []string{"hPa"}},
+	SensorLight:       {Min: 0, Max: 200000, Units: []string{"lux"}},
+}
+
+// sensorTypeNames lists the known sensor types in a fixed order
+var sensorTypeNames = []string{SensorTemperature, SensorHumidity, SensorMotion, SensorPressure, SensorLight}
+
+// sensorTypeWarning returns the warning for a device registering with
+// sensorType, or "" if the type is known or not given
+func sensorTypeWarning(sensorType string) string {
+	if _, ok := SensorTypes[sensorType]; ok || sensorType == "" {
+		return ""
+	}
+	return fmt.Sprintf("unknown sensor type %q, known types are %s", sensorType, strings.Join(sensorTypeNames, ", "))
+}
+
+// InvalidReadingCode is returned in the body of readings that fail
+// validation
+const InvalidReadingCode = "invalid_reading"
+
+// InvalidReadingError describes the field of a reading that failed
+// validation
+type InvalidReadingError struct {
+	Field  string // value, unit or timestamp
+	Reason string
+}
+
+func (e *InvalidReadingError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// Validator checks readings against the rule of their sensor type and
+// rejects timestamps too far in the future
+type Validator struct {
+	rules   map[string]config.SensorRule
+	maxSkew time.Duration
+	clock   clock.Clock
+}
+
+// NewValidator creates a validator with the built-in rules, replaced by
+// those in cfg.Sensors
+func NewValidator(cfg config.ValidationConfig, c clock.Clock) *Validator {
+	rules := make(map[string]config.SensorRule, len(SensorTypes)+len(cfg.Sensors))
+	for sensorType, rule := range SensorTypes {
+		rules[sensorType] = rule
+	}
+	for sensorType, rule := range cfg.Sensors {
+		rules[sensorType] = rule
+	}
+	return &Validator{rules: rules, maxSkew: cfg.MaxSkew, clock: c}
+}
+
+// Check returns an *InvalidReadingError if data is implausible
+func (v *Validator) Check(data SensorData) error {
+	if math.IsNaN(data.Value) || math.IsInf(data.Value, 0) {
+		return &InvalidReadingError{"value", "must be a finite number"}
+	}
+	if limit := v.clock.Now().Add(v.maxSkew); data.Timestamp.After(limit) {
+		return &InvalidReadingError{"timestamp", fmt.Sprintf("%s is more than %v ahead of the server",
+			data.Timestamp.Format(time.RFC3339), v.maxSkew)}
+	}
+
+	rule, ok := v.rules[data.SensorType]
+	if !ok {
+		return nil
+	}
+	if data.Value < rule.Min || data.Value > rule.Max {
+		return &InvalidReadingError{"value", fmt.Sprintf("%g is outside %g to %g for %s", data.Value, rule.Min, rule.Max, data.SensorType)}
+	}
+	if rule.Integer && data.Value != math.Trunc(data.Value) {
+		return &InvalidReadingError{"value", fmt.Sprintf("%g is not a whole number, as %s requires", data.Value, data.SensorType)}
+	}
+	if len(rule.Units) > 0 && !slices.Contains(rule.Units, data.Unit) {
+		return &InvalidReadingError{"unit", fmt.Sprintf("%q is not a unit of %s, expected %s", data.Unit, data.SensorType, strings.Join(rule.Units, " or "))}
+	}
+	return nil
+}
+
+// validate checks the readings of a message when validation is enabled. It
+// answers the first invalid one with 422 and InvalidReadingCode and returns
+// false. The field of a batch reading is reported with its index, e.g.
+// readings[3].value.
+func (h *Handler) validate(w http.ResponseWriter, readings []SensorData, batch bool) bool {
+	if h.validator == nil {
+		return true
+	}
+	for i, data := range readings {
+		err := h.validator.Check(data)
+		if err == nil {
+			continue
+		}
+		invalid := err.(*InvalidReadingError)
+		h.metrics.invalid.WithLabelValues(data.SensorType, invalid.Field).Inc()
+		h.stats.dropped.Add(int64(len(readings)))
+		field := invalid.Field
+		if batch {
+			field = fmt.Sprintf("readings[%d].%s", i, invalid.Field)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": invalid.Reason,
+			"field": field,
+			"code":  InvalidReadingCode,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package iot
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestValidatorCheck(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	v := NewValidator(config.ValidationConfig{
+		Enabled: true,
+		MaxSkew: time.Minute,
+		Sensors: map[string]config.SensorRule{
+			SensorHumidity: {Min: 10, Max: 90, Units: []string{"percent"}},
+			"co2":          {Min: 0, Max: 5000, Units: []string{"ppm"}},
+		},
+	}, clock.NewFake(now))
+
+	sample := func(sensorType string, value float64, unit string) SensorData {
+		return SensorData{DeviceID: "dev1", SensorType: sensorType, Value: value, Unit: unit, Timestamp: now}
+	}
+	future := sample(SensorTemperature, 21, "celsius")
+	future.Timestamp = now.Add(2 * time.Minute)
+	slightlyAhead := sample(SensorTemperature, 21, "celsius")
+	slightlyAhead.Timestamp = now.Add(30 * time.Second)
+
+	tests := []struct {
+		name  string
+		data  SensorData
+		field string // failed field, empty if valid
+	}{
+		{"valid", sample(SensorTemperature, 21.5, "celsius"), ""},
+		{"at the limit", sample(SensorTemperature, 85, "celsius"), ""},
+		{"out of range", sample(SensorTemperature, 150, "celsius"), "value"},
+		{"NaN", sample(SensorTemperature, math.NaN(), "celsius"), "value"},
+		{"infinite", sample("unknown", math.Inf(1), ""), "value"},
+		{"wrong unit", sample(SensorTemperature, 21, "fahrenheit"), "unit"},
+		{"whole motion", sample(SensorMotion, 1, "boolean"), ""},
+		{"fractional motion", sample(SensorMotion, 0.5, "boolean"), "value"},
+		{"timestamp within the skew", slightlyAhead, ""},
+		{"timestamp too far ahead", future, "timestamp"},
+		{"unknown type", sample("unknown", -1e6, "anything"), ""},
+		{"rule replaced by config", sample(SensorHumidity, 5, "percent"), "value"},
+		{"rule added by config", sample("co2", 400, "ppm"), ""},
+		{"unit of a rule added by config", sample("co2", 400, "percent"), "unit"},
+	}
+	for _, tt := range tests {
+		err := v.Check(tt.data)
+		if tt.field == "" {
+			if err != nil {
+				t.Errorf("%s: %v, want valid", tt.name, err)
+			}
+			continue
+		}
+		invalid, ok := err.(*InvalidReadingError)
+		if !ok || invalid.Field != tt.field {
+			t.Errorf("%s: error %v, want an invalid %s", tt.name, err, tt.field)
+		}
+	}
+}
+
+// TestInvalidReadingsRejected checks that the handler answers invalid
+// readings and batches with 422 naming the field, and counts them
+func TestInvalidReadingsRejected(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Validation.Enabled = true
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	tests := []struct {
+		name   string
+		target string
+		body   string
+		field  string
+	}{
+		{"reading", "/iot/sensor", `{"device_id": "dev1", "sensor_type": "temperature", "value": 21, "unit": "kelvin"}`, "unit"},
+		{"batch", "/iot/batch", "[" + reading("dev1", 21) + ", " + reading("dev1", 300) + "]", "readings[1].value"},
+	}
+	for _, tt := range tests {
+		rec := send(t, h, http.MethodPost, tt.target, tt.body)
+		var body map[string]string
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnprocessableEntity || body["code"] != InvalidReadingCode || body["field"] != tt.field {
+			t.Errorf("%s: %d %v, want 422 %s for %s", tt.name, rec.Code, body, InvalidReadingCode, tt.field)
+		}
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21)); rec.Code != http.StatusOK {
+		t.Errorf("valid reading: status %d, want 200", rec.Code)
+	}
+
+	if s := h.Stats(); s.Readings.Accepted != 1 || s.Readings.Dropped != 3 {
+		t.Errorf("got %d readings accepted and %d dropped, want 1 and 3", s.Readings.Accepted, s.Readings.Dropped)
+	}
+	if got := metricValue(t, reg, `commsys_iot_invalid_readings_total{field="value",sensor_type="temperature"}`); got != "1" {
+		t.Errorf("invalid values = %s, want 1", got)
+	}
+}
+
+func TestRegistrationWarnsAboutUnknownSensorType(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	tests := []struct {
+		sensorType string
+		warned     bool
+	}{
+		{SensorPressure, false},
+		{"", false},
+		{"co2", true},
+	}
+	for _, tt := range tests {
+		rec := send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev1", "min_version": 1, "max_version": 1, "sensor_type": "`+tt.sensorType+`"}`)
+		var resp RegisterResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%q: status %d, want the device registered", tt.sensorType, rec.Code)
+		}
+		if warned := len(resp.Warnings) == 1 && strings.Contains(resp.Warnings[0], "pressure"); warned != tt.warned {
+			t.Errorf("%q: warnings %q, want a warning listing the known types %v", tt.sensorType, resp.Warnings, tt.warned)
+		}
+	}
+}
+
+// TestSimulatedReadingsCoverKnownTypes checks that GET /iot/sensor returns
+// a reading of every known sensor type, each valid for its type
+func TestSimulatedReadingsCoverKnownTypes(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+	v := NewValidator(cfg.IoT.Validation, clock.Real())
+
+	var readings []SensorData
+	if err := json.NewDecoder(send(t, h, http.MethodGet, "/iot/sensor", "").Body).Decode(&readings); err != nil {
+		t.Fatal(err)
+	}
+	types := make(map[string]bool)
+	for _, r := range readings {
+		types[r.SensorType] = true
+		if err := v.Check(r); err != nil {
+			t.Errorf("simulated %s reading %v %s: %v", r.SensorType, r.Value, r.Unit, err)
+		}
+	}
+	for _, sensorType := range []string{SensorTemperature, SensorHumidity, SensorMotion, SensorPressure} {
+		if !types[sensorType] {
+			t.Errorf("no simulated %s reading", sensorType)
+		}
+	}
+}
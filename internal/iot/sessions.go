@@ -0,0 +1,183 @@
+package iot
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of SessionEvent
+const (
+	SessionConnected    = "connected"
+	SessionDisconnected = "disconnected"
+)
+
+// Session is a device's use of a connection, from its first request on the
+// connection until the connection closes or the device moves to another
+type Session struct {
+	DeviceID   string    `json:"device_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Since      time.Time `json:"since"`
+}
+
+// SessionEvent tells that a device connected or disconnected
+type SessionEvent struct {
+	Kind string `json:"kind"` // SessionConnected or SessionDisconnected
+	Session
+	At time.Time `json:"at"`
+}
+
+type session struct {
+	Session
+	peer *peer
+}
+
+// Sessions keeps the current session of each device on a connection that
+// reports its closing, i.e. HTTP/3. A device on several connections, e.g.
+// while it migrates, is in session on the one it used last, and only the
+// closing of that one ends the session.
+type Sessions struct {
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	current map[string]*session
+	subs    []chan SessionEvent
+
+	active  prometheus.Gauge
+	ended   prometheus.Counter
+	dropped prometheus.Counter
+}
+
+// NewSessions creates a session tracker without sessions
+func NewSessions(logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Sessions {
+	return &Sessions{
+		logger:  logger,
+		clock:   c,
+		current: make(map[string]*session),
+		active:  reg.Gauge("iot", "sessions", "Devices in session on an open connection"),
+		ended:   reg.Counter("iot", "sessions_closed_total", "Device sessions ended by their connection closing"),
+		dropped: reg.Counter("iot", "session_events_dropped_total", "Session events not passed to a subscriber that was behind"),
+	}
+}
+
+// open puts deviceID in session on p, unless it already is
+func (s *Sessions) open(deviceID string, p *peer, remoteAddr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.current[deviceID]
+	if ok && cur.peer == p {
+		return
+	}
+	if !ok {
+		s.active.Inc()
+	}
+	cur = &session{Session: Session{DeviceID: deviceID, RemoteAddr: remoteAddr, Since: s.clock.Now()}, peer: p}
+	s.current[deviceID] = cur
+	s.logger.Debug("Device session opened", logging.F("device_id", deviceID), logging.F("remote_addr", remoteAddr))
+	s.emitLocked(SessionEvent{Kind: SessionConnected, Session: cur.Session, At: cur.Since})
+}
+
+// close ends the session of deviceID on p, and reports false if the device
+// isn't in session on p
+func (s *Sessions) close(deviceID string, p *peer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.current[deviceID]
+	if !ok || cur.peer != p {
+		return false
+	}
+	delete(s.current, deviceID)
+	s.active.Dec()
+	s.ended.Inc()
+	now := s.clock.Now()
+	s.logger.Info("Device disconnected", logging.F("device_id", deviceID), logging.F("remote_addr", cur.RemoteAddr),
+		logging.F("session", now.Sub(cur.Since).Round(time.Millisecond)))
+	s.emitLocked(SessionEvent{Kind: SessionDisconnected, Session: cur.Session, At: now})
+	return true
+}
+
+// emitLocked passes e to the subscribers. s.mu must be held.
+func (s *Sessions) emitLocked(e SessionEvent) {
+	for _, ch := range s.subs {
+		select {
+		case ch <- e:
+		default:
+			s.dropped.Inc()
+		}
+	}
+}
+
+// Subscribe returns a channel that receives every later session event. It
+// holds up to buffer events the subscriber hasn't taken yet.
+func (s *Sessions) Subscribe(buffer int) <-chan SessionEvent {
+	ch := make(chan SessionEvent, buffer)
+	s.mu.Lock()
+	s.subs = append(s.subs, ch)
+	s.mu.Unlock()
+	return ch
+}
+
+// List returns the current sessions, ordered by device ID
+func (s *Sessions) List() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Session, 0, len(s.current))
+	for _, cur := range s.current {
+		out = append(out, cur.Session)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// WithSessions tracks the sessions of devices in s. When the connection of
+// a session closes, the device is offline at once and its commands in
+// flight fail, without waiting for the heartbeat timeout.
+func WithSessions(s *Sessions) Option {
+	return func(h *Handler) {
+		h.sessions = s
+	}
+}
+
+// attach puts an authorized device in session on the connection of r, if
+// sessions are tracked and the connection reports its closing
+func (h *Handler) attach(r *http.Request, deviceID string) {
+	if h.sessions == nil || deviceID == "" {
+		return
+	}
+	p, _ := r.Context().Value(peerKey{}).(*peer)
+	if p == nil || p.closed == nil {
+		return
+	}
+	select {
+	case <-p.closed:
+		return // a request still running on a closed connection
+	default:
+	}
+	h.sessions.open(deviceID, p, r.RemoteAddr)
+	if p.attach(deviceID) {
+		go h.teardown(p)
+	}
+}
+
+// teardown ends the sessions on p once its connection has closed
+func (h *Handler) teardown(p *peer) {
+	<-p.closed
+	for _, deviceID := range p.attached() {
+		if !h.sessions.close(deviceID, p) {
+			continue // the device moved to another connection
+		}
+		if h.presence != nil {
+			h.presence.Disconnected(deviceID)
+		}
+		if h.outbox != nil {
+			h.outbox.failInFlight(deviceID, "connection closed")
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package iot
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+type sessionFixture struct {
+	handler  *Handler
+	sessions *Sessions
+	presence *Presence
+	outbox   *Outbox
+	events   <-chan SessionEvent
+}
+
+func newSessionFixture(t *testing.T) *sessionFixture {
+	t.Helper()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	f := &sessionFixture{
+		sessions: NewSessions(logging.Nop(), fake, reg),
+		presence: NewPresence(cfg.IoT.Heartbeat, nil, logging.Nop(), fake, reg),
+		outbox:   NewOutbox(cfg.IoT.Commands, logging.Nop(), nil, fake, reg),
+	}
+	f.events = f.sessions.Subscribe(10)
+	f.handler = NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg),
+		WithClock(fake), WithSessions(f.sessions), WithPresence(f.presence), WithOutbox(f.outbox))
+	return f
+}
+
+// fetchCommands takes the commands of deviceID over the connection whose
+// closing is reported by closed
+func (f *sessionFixture) fetchCommands(t *testing.T, deviceID string, closed <-chan struct{}) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/iot/commands", nil)
+	req.Header.Set("X-Device-ID", deviceID)
+	req = req.WithContext(WithPeer(req.Context(), closed))
+	rec := httptest.NewRecorder()
+	f.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// event waits up to a second for the next session event
+func (f *sessionFixture) event(t *testing.T) SessionEvent {
+	t.Helper()
+	select {
+	case e := <-f.events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no session event within a second")
+		return SessionEvent{}
+	}
+}
+
+func TestSessionClosedConnectionTearsDown(t *testing.T) {
+	f := newSessionFixture(t)
+	f.presence.Heartbeat("dev1")
+	cmd, err := f.outbox.Enqueue(Command{DeviceID: "dev1", Action: "reboot", Priority: "high"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan struct{})
+	f.fetchCommands(t, "dev1", closed)
+	if e := f.event(t); e.Kind != SessionConnected || e.DeviceID != "dev1" {
+		t.Fatalf("event %+v, want dev1 connected", e)
+	}
+	if got := f.sessions.List(); len(got) != 1 {
+		t.Fatalf("sessions %+v, want dev1", got)
+	}
+
+	// The clock stands still, so nothing here waits for the heartbeat
+	// timeout
+	close(closed)
+	if e := f.event(t); e.Kind != SessionDisconnected || e.DeviceID != "dev1" {
+		t.Fatalf("event %+v, want dev1 disconnected", e)
+	}
+	// The teardown goes on after the event
+	deadline := time.Now().Add(time.Second)
+	for {
+		d := presenceOf(t, f.presence, "dev1")
+		s, _ := f.outbox.Status(cmd.ID)
+		if !d.Online && s.Status == CommandFailed {
+			if d.Reason != "disconnected" {
+				t.Errorf("offline for %q, want disconnected", d.Reason)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("a second after the connection closed: online %v, command in flight %s", d.Online, s.Status)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := f.sessions.List(); len(got) != 0 {
+		t.Errorf("sessions %+v after the connection closed", got)
+	}
+}
+
+func TestSessionMovedConnectionKeepsDevice(t *testing.T) {
+	f := newSessionFixture(t)
+	f.presence.Heartbeat("dev1")
+
+	old, current := make(chan struct{}), make(chan struct{})
+	defer close(current)
+	f.fetchCommands(t, "dev1", old)
+	f.fetchCommands(t, "dev1", current)
+	f.event(t)
+	f.event(t)
+
+	// The device migrated, so the old connection closing doesn't end its
+	// session
+	close(old)
+	select {
+	case e := <-f.events:
+		t.Fatalf("event %+v after the old connection closed", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if d := presenceOf(t, f.presence, "dev1"); !d.Online {
+		t.Error("device offline after the connection it left closed")
+	}
+	if got := f.sessions.List(); len(got) != 1 {
+		t.Errorf("sessions %+v, want dev1 on its new connection", got)
+	}
+}
+
+func TestSessionRequestWithoutPeer(t *testing.T) {
+	f := newSessionFixture(t)
+	req := httptest.NewRequest(http.MethodGet, "/iot/commands", nil).WithContext(context.Background())
+	req.Header.Set("X-Device-ID", "dev1")
+	f.handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got := f.sessions.List(); len(got) != 0 {
+		t.Errorf("sessions %+v on a connection that doesn't report its closing", got)
+	}
+}
@@ -0,0 +1,177 @@
+package iot
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// Sink receives accepted sensor readings for storage or further processing,
+// e.g. a message queue or a file. Publish should give up once ctx is done.
+type Sink interface {
+	Publish(ctx context.Context, data SensorData) error
+	Close() error
+}
+
+// Dispatcher fans accepted readings out to sinks. Each sink has a bounded
+// queue drained by its own goroutine, so a slow or failing sink only loses
+// its own readings and never holds up the others or the request. Readings
+// that find a sink's queue full are dropped for that sink.
+type Dispatcher struct {
+	logger  logging.Logger
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	closed bool
+	sinks  []*sinkQueue
+	wg     sync.WaitGroup
+
+	// cancel aborts publishes once a drain runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	published *metrics.CounterVec
+	failed    *metrics.CounterVec
+	dropped   *metrics.CounterVec
+}
+
+// sinkQueue is a sink with its queue of readings
+type sinkQueue struct {
+	name  string
+	sink  Sink
+	queue chan SensorData
+}
+
+// NewDispatcher creates a dispatcher without sinks. Each publish to a sink
+// may take up to timeout.
+func NewDispatcher(logger logging.Logger, reg *metrics.Registry, timeout time.Duration) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Dispatcher{
+		logger:    logger,
+		timeout:   timeout,
+		ctx:       ctx,
+		cancel:    cancel,
+		published: reg.CounterVec("sinks", "published_total", "Sensor readings written to a sink", "sink"),
+		failed:    reg.CounterVec("sinks", "failed_total", "Sensor readings a sink failed to write", "sink"),
+		dropped:   reg.CounterVec("sinks", "dropped_total", "Sensor readings dropped because a sink's queue was full or the sink was closing", "sink"),
+	}
+}
+
+// Add starts delivering readings to s under name, queueing up to
+// queueSize of them
+func (d *Dispatcher) Add(name string, s Sink, queueSize int) {
+	q := &sinkQueue{name: name, sink: s, queue: make(chan SensorData, queueSize)}
+	d.mu.Lock()
+	d.sinks = append(d.sinks, q)
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(q)
+	}()
+}
+
+// Len returns the number of sinks
+func (d *Dispatcher) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.sinks)
+}
+
+// queueDepths reports the readings waiting for each sink as "sink:<name>"
+func (d *Dispatcher) queueDepths(into map[string]int) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, q := range d.sinks {
+		into["sink:"+q.name] = len(q.queue)
+	}
+}
+
+// Publish queues a reading for every sink. It never blocks.
+func (d *Dispatcher) Publish(data SensorData) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, q := range d.sinks {
+		select {
+		case q.queue <- data:
+		default:
+			d.dropped.WithLabelValues(q.name).Inc()
+		}
+	}
+}
+
+// deliver writes the readings queued for a sink until the queue is closed
+func (d *Dispatcher) deliver(q *sinkQueue) {
+	for data := range q.queue {
+		if d.ctx.Err() != nil {
+			d.dropped.WithLabelValues(q.name).Inc()
+			continue
+		}
+		ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+		err := q.sink.Publish(ctx, data)
+		cancel()
+		if err != nil {
+			d.failed.WithLabelValues(q.name).Inc()
+			d.logger.Warn("Sink failed to write reading", logging.F("sink", q.name), logging.F("device_id", data.DeviceID), logging.Err(err))
+			continue
+		}
+		d.published.WithLabelValues(q.name).Inc()
+	}
+}
+
+// WithSinks passes accepted readings to the sinks of d, if d isn't nil
+func WithSinks(d *Dispatcher) Option {
+	return func(h *Handler) {
+		if d != nil {
+			h.publishers = append(h.publishers, d)
+		}
+	}
+}
+
+// Close stops taking readings, waits for the sinks to write the queued ones
+// and closes the sinks. Readings still queued when ctx is done are dropped.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, q := range d.sinks {
+		close(q.queue)
+	}
+	d.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(drained)
+	}()
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		d.logger.Warn("Sinks not drained in time, dropping queued readings")
+		d.cancel()
+		<-drained
+	}
+	d.cancel()
+
+	for _, q := range d.sinks {
+		if cerr := q.sink.Close(); cerr != nil {
+			d.logger.Error("Failed to close sink", logging.F("sink", q.name), logging.Err(cerr))
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
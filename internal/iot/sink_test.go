@@ -0,0 +1,140 @@
+package iot
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// recordingSink keeps the readings published to it. A sink with a release
+// channel blocks each publish until the channel is closed or ctx is done.
+type recordingSink struct {
+	err     error
+	release chan struct{}
+
+	mu       sync.Mutex
+	readings []SensorData
+	closed   bool
+}
+
+func (s *recordingSink) Publish(ctx context.Context, data SensorData) error {
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.err != nil {
+		return s.err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readings = append(s.readings, data)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.readings)
+}
+
+func TestDispatcherIsolatesSinks(t *testing.T) {
+	reg := metrics.NewRegistry()
+	d := NewDispatcher(logging.Nop(), reg, time.Minute)
+	healthy := &recordingSink{}
+	failing := &recordingSink{err: errors.New("broker unreachable")}
+	stuck := &recordingSink{release: make(chan struct{})}
+	d.Add("file", healthy, 100)
+	d.Add("nats", failing, 100)
+	d.Add("slow", stuck, 5)
+
+	for i := 0; i < 50; i++ {
+		d.Publish(SensorData{DeviceID: "dev1", SensorType: "temperature", Value: float64(i)})
+	}
+	for deadline := time.Now().Add(time.Second); healthy.count() < 50 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	if n := healthy.count(); n != 50 {
+		t.Fatalf("healthy sink wrote %d readings next to a failing and a stuck one, want 50", n)
+	}
+	for i, r := range healthy.readings {
+		if r.Value != float64(i) {
+			t.Fatalf("reading %d has value %v, out of order", i, r.Value)
+		}
+	}
+
+	close(stuck.release)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := metricValue(t, reg, `commsys_sinks_failed_total{sink="nats"}`); got != "50" {
+		t.Errorf("failing sink failed %s readings, want 50", got)
+	}
+	// The stuck sink queues five readings, and may hold one more it took
+	// before the others arrived; the rest are dropped
+	dropped, _ := strconv.Atoi(metricValue(t, reg, `commsys_sinks_dropped_total{sink="slow"}`))
+	if written := stuck.count(); written+dropped != 50 || dropped < 44 {
+		t.Errorf("stuck sink wrote %d readings once released and dropped %d", written, dropped)
+	}
+}
+
+func TestDispatcherDrainsOnClose(t *testing.T) {
+	reg := metrics.NewRegistry()
+	d := NewDispatcher(logging.Nop(), reg, time.Minute)
+	s := &recordingSink{}
+	d.Add("file", s, 1000)
+	for i := 0; i < 1000; i++ {
+		d.Publish(SensorData{DeviceID: "dev1", Value: float64(i)})
+	}
+
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count(); n != 1000 || !s.closed {
+		t.Errorf("after Close the sink wrote %d readings, closed %v; want 1000 and closed", n, s.closed)
+	}
+	// Readings after Close go nowhere
+	d.Publish(SensorData{DeviceID: "dev1"})
+	if n := s.count(); n != 1000 {
+		t.Errorf("sink wrote %d readings, a reading published after Close got through", n)
+	}
+}
+
+func TestDispatcherCloseGivesUpOnStuckSink(t *testing.T) {
+	reg := metrics.NewRegistry()
+	d := NewDispatcher(logging.Nop(), reg, time.Minute)
+	s := &recordingSink{release: make(chan struct{})}
+	d.Add("slow", s, 10)
+	for i := 0; i < 10; i++ {
+		d.Publish(SensorData{DeviceID: "dev1"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Close() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if !s.closed {
+		t.Error("stuck sink not closed")
+	}
+	if n := s.count(); n != 0 {
+		t.Errorf("stuck sink wrote %d readings", n)
+	}
+}
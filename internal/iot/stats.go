@@ -0,0 +1,139 @@
+package iot
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// messageTypes are the kinds of message counted in Stats: the endpoints,
+// each HTTP/3 datagram, and "unknown" for any other path
+var messageTypes = []string{
+	"register", "sensor", "batch", "command", "commands", "devices", "simulate", "upload", "uploads",
+	"migrate", "datagrams", "datagram", "twin", "state", "firmware", "heartbeat", "health", "subscribe", "unknown",
+}
+
+// Stats is a snapshot of the IoT handler's activity since it was created
+type Stats struct {
+	Since          time.Time            `json:"since"`
+	DevicesOnline  int                  `json:"devices_online"`
+	DevicesOffline int                  `json:"devices_offline"`
+	Messages       map[string]int64     `json:"messages"` // by type, those never received left out
+	Readings       ReadingStats         `json:"readings"`
+	Commands       CommandStats         `json:"commands"`
+	Queues         map[string]int       `json:"queues"`    // items waiting in each internal queue
+	LastSeen       map[string]time.Time `json:"last_seen"` // by device ID
+}
+
+// ReadingStats counts sensor readings by outcome
+type ReadingStats struct {
+	Accepted  int64 `json:"accepted"`
+	Dropped   int64 `json:"dropped"`   // refused as invalid, oversized or over quota
+	Throttled int64 `json:"throttled"` // refused for the device's message rate
+	Duplicate int64 `json:"duplicate"` // retries acknowledged without being processed again
+}
+
+// handlerStats counts what Stats reports that no component keeps. Counters
+// are atomic so that taking a snapshot doesn't hold up ingestion.
+type handlerStats struct {
+	since    time.Time
+	messages map[string]*atomic.Int64 // one per messageTypes entry, never written after creation
+
+	accepted  atomic.Int64
+	dropped   atomic.Int64
+	throttled atomic.Int64
+	duplicate atomic.Int64
+}
+
+func newHandlerStats(since time.Time) *handlerStats {
+	s := &handlerStats{since: since, messages: make(map[string]*atomic.Int64, len(messageTypes))}
+	for _, t := range messageTypes {
+		s.messages[t] = new(atomic.Int64)
+	}
+	return s
+}
+
+// message counts a message of type t
+func (s *handlerStats) message(t string) {
+	n, ok := s.messages[t]
+	if !ok {
+		n = s.messages["unknown"]
+	}
+	n.Add(1)
+}
+
+// queueDepther is a publisher with queues of its own, e.g. a Dispatcher
+type queueDepther interface {
+	queueDepths(into map[string]int)
+}
+
+// Stats returns a snapshot of the handler's activity. Device counts and last
+// seen times come from presence, and command counts from the outbox; both
+// are empty without them.
+func (h *Handler) Stats() Stats {
+	s := Stats{
+		Since:    h.stats.since,
+		Messages: make(map[string]int64),
+		Readings: ReadingStats{
+			Accepted:  h.stats.accepted.Load(),
+			Dropped:   h.stats.dropped.Load(),
+			Throttled: h.stats.throttled.Load(),
+			Duplicate: h.stats.duplicate.Load(),
+		},
+		Queues:   make(map[string]int),
+		LastSeen: make(map[string]time.Time),
+	}
+	for t, n := range h.stats.messages {
+		if v := n.Load(); v > 0 {
+			s.Messages[t] = v
+		}
+	}
+	if h.presence != nil {
+		for _, d := range h.presence.List() {
+			if d.Online {
+				s.DevicesOnline++
+			} else {
+				s.DevicesOffline++
+			}
+			s.LastSeen[d.DeviceID] = d.LastSeen
+		}
+	}
+	if h.outbox != nil {
+		s.Commands = h.outbox.Stats()
+	}
+	for _, p := range h.publishers {
+		if q, ok := p.(queueDepther); ok {
+			q.queueDepths(s.Queues)
+		}
+	}
+	if h.aggregates != nil {
+		s.Queues["aggregates"] = len(h.aggregates.out)
+	}
+	return s
+}
+
+// LogStats logs the handler's statistics every interval until ctx is done
+func (h *Handler) LogStats(ctx context.Context, interval time.Duration) {
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			s := h.Stats()
+			var messages int64
+			for _, n := range s.Messages {
+				messages += n
+			}
+			h.logger.Info("IoT statistics", logging.F("devices_online", s.DevicesOnline),
+				logging.F("devices_offline", s.DevicesOffline), logging.F("messages", messages),
+				logging.F("readings_accepted", s.Readings.Accepted), logging.F("readings_dropped", s.Readings.Dropped),
+				logging.F("readings_throttled", s.Readings.Throttled), logging.F("readings_duplicate", s.Readings.Duplicate),
+				logging.F("commands_queued", s.Commands.Queued), logging.F("queues", s.Queues))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestStatsCountMessagesByType(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	send(t, h, http.MethodPost, "/iot/register", `{"device_id": "dev1", "min_version": 1, "max_version": 1}`)
+	for i := 0; i < 3; i++ {
+		send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21))
+	}
+	send(t, h, http.MethodPost, "/iot/batch", "["+reading("dev1", 21)+", "+reading("dev1", 22)+"]")
+	send(t, h, http.MethodPost, "/iot/command", `{"device_id": "dev1", "action": "reboot"}`)
+	send(t, h, http.MethodPost, "/iot/heartbeat", "", "X-Device-ID", "dev1")
+	send(t, h, http.MethodGet, "/iot/nonexistent", "")
+	send(t, h, http.MethodGet, "/iot/also/nonexistent", "")
+
+	s := h.Stats()
+	want := map[string]int64{"register": 1, "sensor": 3, "batch": 1, "command": 1, "heartbeat": 1, "unknown": 2}
+	for name, n := range want {
+		if s.Messages[name] != n {
+			t.Errorf("%d %s messages, want %d", s.Messages[name], name, n)
+		}
+	}
+	if len(s.Messages) != len(want) {
+		t.Errorf("messages %v, want only %v", s.Messages, want)
+	}
+	if s.Readings.Accepted != 5 {
+		t.Errorf("%d readings accepted, want the 3 sent alone and the 2 batched", s.Readings.Accepted)
+	}
+
+	// The snapshot goes out as is over the admin API
+	if _, err := json.Marshal(s); err != nil {
+		t.Errorf("snapshot doesn't marshal: %v", err)
+	}
+}
+
+func TestStatsCountRefusedReadings(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.Validation.Enabled = true
+	cfg.IoT.DeviceRates = map[string]float64{"slow": 0.001}
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg),
+		WithClock(clock.NewFake(time.Now())))
+
+	tests := []struct {
+		name    string
+		body    string
+		headers []string
+		status  int
+		want    ReadingStats
+	}{
+		{"accepted", reading("dev1", 21), []string{MessageIDHeader, "m1"}, http.StatusOK, ReadingStats{Accepted: 1}},
+		{"retried", reading("dev1", 21), []string{MessageIDHeader, "m1"}, http.StatusOK, ReadingStats{Duplicate: 1}},
+		{"invalid", reading("dev1", 1000), nil, http.StatusUnprocessableEntity, ReadingStats{Dropped: 1}},
+		{"first of a slow device", reading("slow", 21), nil, http.StatusOK, ReadingStats{Accepted: 1}},
+		{"over its rate", reading("slow", 21), nil, http.StatusTooManyRequests, ReadingStats{Throttled: 1}},
+	}
+	for _, tt := range tests {
+		before := h.Stats().Readings
+		rec := send(t, h, http.MethodPost, "/iot/sensor", tt.body, tt.headers...)
+		if rec.Code != tt.status {
+			t.Fatalf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+		}
+		after := h.Stats().Readings
+		got := ReadingStats{
+			Accepted:  after.Accepted - before.Accepted,
+			Dropped:   after.Dropped - before.Dropped,
+			Throttled: after.Throttled - before.Throttled,
+			Duplicate: after.Duplicate - before.Duplicate,
+		}
+		if got != tt.want {
+			t.Errorf("%s: counters moved by %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStatsDevicesAndCommands(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	presence := NewPresence(cfg.IoT.Heartbeat, nil, logging.Nop(), fake, reg)
+	outbox := NewOutbox(cfg.IoT.Commands, logging.Nop(), nil, fake, reg)
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg),
+		WithClock(fake), WithPresence(presence), WithOutbox(outbox))
+
+	send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21))
+	fake.Advance(time.Minute)
+	send(t, h, http.MethodPost, "/iot/sensor", reading("dev2", 21))
+	fake.Advance(cfg.IoT.Heartbeat.Timeout)
+	presence.sweep(fake.Now())
+
+	for _, action := range []string{"reboot", "calibrate"} {
+		if _, err := outbox.Enqueue(Command{DeviceID: "dev2", Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(t, h, http.MethodGet, "/iot/commands", "", "X-Device-ID", "dev2")
+	outbox.Enqueue(Command{DeviceID: "dev1", Action: "reboot"})
+
+	s := h.Stats()
+	if s.DevicesOnline != 1 || s.DevicesOffline != 1 {
+		t.Errorf("%d online, %d offline, want one of each", s.DevicesOnline, s.DevicesOffline)
+	}
+	if !s.LastSeen["dev2"].Equal(s.LastSeen["dev1"].Add(time.Minute)) {
+		t.Errorf("last seen %v", s.LastSeen)
+	}
+	if s.Commands.Queued != 1 || s.Commands.Delivered != 2 {
+		t.Errorf("commands %+v, want 1 queued and 2 delivered", s.Commands)
+	}
+	if s.Messages["commands"] != 1 {
+		t.Errorf("%d commands messages, want 1", s.Messages["commands"])
+	}
+}
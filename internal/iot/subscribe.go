@@ -0,0 +1,261 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SubscriptionFilter selects the readings a subscriber receives. A reading
+// must match every field that is set, and any of the values of a field.
+// An empty filter selects every reading.
+type SubscriptionFilter struct {
+	DeviceIDs   []string          `json:"device_ids,omitempty"`
+	SensorTypes []string          `json:"sensor_types,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"` // registered labels of the device, e.g. location=room_a
+}
+
+// ParseSubscriptionFilter reads a filter from device_id, sensor_type and
+// label=key=value query parameters, each of which may be repeated
+func ParseSubscriptionFilter(query url.Values) (SubscriptionFilter, error) {
+	labels, err := ParseLabelSelector(query)
+	if err != nil {
+		return SubscriptionFilter{}, err
+	}
+	filter := SubscriptionFilter{DeviceIDs: query["device_id"], SensorTypes: query["sensor_type"]}
+	if len(labels) > 0 {
+		filter.Labels = labels
+	}
+	return filter, nil
+}
+
+// SubscriptionStats describes a subscriber
+type SubscriptionStats struct {
+	ID        uint64             `json:"id"`
+	Filter    SubscriptionFilter `json:"filter"`
+	Since     time.Time          `json:"since"`
+	Delivered int64              `json:"delivered"`
+	Dropped   int64              `json:"dropped"` // readings missed while the subscriber's buffer was full
+}
+
+type subscriber struct {
+	id     uint64
+	filter SubscriptionFilter
+	since  time.Time
+	ch     chan SensorData
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+}
+
+// Subscriptions passes live sensor readings to subscribers, each through its
+// own bounded buffer. A subscriber that doesn't keep up misses readings
+// rather than holding up ingestion or the other subscribers. It receives
+// readings as a Publisher.
+type Subscriptions struct {
+	buffer  int
+	max     int
+	devices *DeviceRegistry // resolves the labels of filters; nil matches no labels
+	logger  logging.Logger
+	clock   clock.Clock
+
+	mu     sync.RWMutex
+	subs   map[uint64]*subscriber
+	nextID uint64
+
+	subscribers prometheus.Gauge
+	readings    *metrics.CounterVec
+}
+
+// NewSubscriptions creates a subscription manager with the buffer size and
+// subscriber limit of cfg. Filters by label match the registered labels in
+// devices.
+func NewSubscriptions(cfg config.SubscriptionConfig, devices *DeviceRegistry, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Subscriptions {
+	return &Subscriptions{
+		buffer:      cfg.Buffer,
+		max:         cfg.MaxSubscribers,
+		devices:     devices,
+		logger:      logger,
+		clock:       c,
+		subs:        make(map[uint64]*subscriber),
+		subscribers: reg.Gauge("iot", "subscribers", "Live subscribers to sensor readings"),
+		readings:    reg.CounterVec("iot", "subscription_readings_total", "Readings passed to subscribers by outcome: delivered or dropped", "outcome"),
+	}
+}
+
+// Subscribe returns a channel of the readings that match filter, from now
+// on, and a function that ends the subscription and closes the channel.
+func (s *Subscriptions) Subscribe(filter SubscriptionFilter) (<-chan SensorData, func()) {
+	ch, cancel, _ := s.subscribe(filter, 0)
+	return ch, cancel
+}
+
+// subscribe subscribes unless limit, if positive, subscribers are already
+// subscribed
+func (s *Subscriptions) subscribe(filter SubscriptionFilter, limit int) (<-chan SensorData, func(), bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if limit > 0 && len(s.subs) >= limit {
+		return nil, nil, false
+	}
+	s.nextID++
+	sub := &subscriber{id: s.nextID, filter: filter, since: s.clock.Now(), ch: make(chan SensorData, s.buffer)}
+	s.subs[sub.id] = sub
+	s.subscribers.Inc()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, sub.id)
+			close(sub.ch)
+			s.mu.Unlock()
+			s.subscribers.Dec()
+		})
+	}, true
+}
+
+// Publish passes data to every subscriber whose filter matches it
+func (s *Subscriptions) Publish(data SensorData) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var device *Device // looked up once, for the first filter by label
+	for _, sub := range s.subs {
+		if !s.matches(sub.filter, data, &device) {
+			continue
+		}
+		select {
+		case sub.ch <- data:
+			sub.delivered.Add(1)
+			s.readings.WithLabelValues("delivered").Inc()
+		default:
+			sub.dropped.Add(1)
+			s.readings.WithLabelValues("dropped").Inc()
+		}
+	}
+}
+
+func (s *Subscriptions) matches(f SubscriptionFilter, data SensorData, device **Device) bool {
+	if len(f.DeviceIDs) > 0 && !slices.Contains(f.DeviceIDs, data.DeviceID) {
+		return false
+	}
+	if len(f.SensorTypes) > 0 && !slices.Contains(f.SensorTypes, data.SensorType) {
+		return false
+	}
+	if len(f.Labels) == 0 {
+		return true
+	}
+	if *device == nil {
+		d, ok := Device{}, false
+		if s.devices != nil {
+			d, ok = s.devices.Get(data.DeviceID)
+		}
+		if !ok {
+			return false
+		}
+		*device = &d
+	}
+	return (*device).Matches(f.Labels)
+}
+
+// List returns the current subscribers, oldest first
+func (s *Subscriptions) List() []SubscriptionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]SubscriptionStats, 0, len(s.subs))
+	for _, sub := range s.subs {
+		out = append(out, SubscriptionStats{
+			ID:        sub.id,
+			Filter:    sub.filter,
+			Since:     sub.since,
+			Delivered: sub.delivered.Load(),
+			Dropped:   sub.dropped.Load(),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// queueDepths reports the readings waiting in all subscribers' buffers
+func (s *Subscriptions) queueDepths(into map[string]int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, sub := range s.subs {
+		n += len(sub.ch)
+	}
+	into["subscriptions"] = n
+}
+
+// WithSubscriptions passes accepted readings to the subscribers of s and
+// lets remote clients subscribe at GET /iot/subscribe
+func WithSubscriptions(s *Subscriptions) Option {
+	return func(h *Handler) {
+		if s != nil {
+			h.subscriptions = s
+			h.publishers = append(h.publishers, s)
+		}
+	}
+}
+
+// handleSubscribe streams the readings that match the filter of the query
+// as JSON lines, one per reading, until the client goes away. Over QUIC
+// each subscription is a stream of its own.
+func (h *Handler) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorize(w, r, r.Header.Get("X-Device-ID")) {
+		return
+	}
+	if h.subscriptions == nil || h.subscriptions.max == 0 {
+		http.Error(w, "Subscriptions are disabled", http.StatusNotFound)
+		return
+	}
+	filter, err := ParseSubscriptionFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	readings, unsubscribe, ok := h.subscriptions.subscribe(filter, h.subscriptions.max)
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "Too many subscribers", http.StatusServiceUnavailable)
+		return
+	}
+	defer unsubscribe()
+	h.logger.Debug("Subscriber connected", logging.F("remote_addr", r.RemoteAddr),
+		logging.F("device_ids", filter.DeviceIDs), logging.F("sensor_types", filter.SensorTypes))
+
+	// The stream outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case data := <-readings:
+			if err := enc.Encode(data); err != nil {
+				return
+			}
+			rc.Flush()
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package iot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func newTestSubscriptions(t *testing.T, buffer int) (*Subscriptions, *DeviceRegistry, *metrics.Registry) {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	devices := NewDeviceRegistry(logging.Nop(), fake, reg)
+	cfg := config.SubscriptionConfig{Buffer: buffer, MaxSubscribers: 10}
+	return NewSubscriptions(cfg, devices, logging.Nop(), fake, reg), devices, reg
+}
+
+// drain returns the readings waiting on ch as device/sensor pairs
+func drain(ch <-chan SensorData) []string {
+	var out []string
+	for {
+		select {
+		case d, ok := <-ch:
+			if !ok {
+				return out
+			}
+			out = append(out, d.DeviceID+"/"+d.SensorType)
+		default:
+			return out
+		}
+	}
+}
+
+func TestSubscriptionsOverlappingFilters(t *testing.T) {
+	s, devices, _ := newTestSubscriptions(t, 10)
+	devices.Register("dev1", DeviceInfo{Labels: map[string]string{"location": "room_a"}})
+	devices.Register("dev2", DeviceInfo{Labels: map[string]string{"location": "room_b"}})
+
+	all, cancelAll := s.Subscribe(SubscriptionFilter{})
+	defer cancelAll()
+	dev1, cancelDev1 := s.Subscribe(SubscriptionFilter{DeviceIDs: []string{"dev1"}})
+	defer cancelDev1()
+	temps, cancelTemps := s.Subscribe(SubscriptionFilter{SensorTypes: []string{"temperature"}})
+	defer cancelTemps()
+	roomA, cancelRoomA := s.Subscribe(SubscriptionFilter{Labels: map[string]string{"location": "room_a"}})
+	defer cancelRoomA()
+	both, cancelBoth := s.Subscribe(SubscriptionFilter{DeviceIDs: []string{"dev1", "dev2"}, SensorTypes: []string{"humidity"}})
+	defer cancelBoth()
+
+	for _, d := range []SensorData{
+		{DeviceID: "dev1", SensorType: "temperature"},
+		{DeviceID: "dev1", SensorType: "humidity"},
+		{DeviceID: "dev2", SensorType: "temperature"},
+		{DeviceID: "dev3", SensorType: "humidity"}, // unregistered, no labels
+	} {
+		s.Publish(d)
+	}
+
+	tests := []struct {
+		name string
+		ch   <-chan SensorData
+		want string
+	}{
+		{"all", all, "dev1/temperature dev1/humidity dev2/temperature dev3/humidity"},
+		{"device", dev1, "dev1/temperature dev1/humidity"},
+		{"sensor type", temps, "dev1/temperature dev2/temperature"},
+		{"label", roomA, "dev1/temperature dev1/humidity"},
+		{"device and sensor type", both, "dev1/humidity"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(drain(tt.ch), " "); got != tt.want {
+			t.Errorf("%s: received %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSubscriptionsUnsubscribe(t *testing.T) {
+	s, _, reg := newTestSubscriptions(t, 10)
+	ch, cancel := s.Subscribe(SubscriptionFilter{DeviceIDs: []string{"dev1"}})
+	_, cancelOther := s.Subscribe(SubscriptionFilter{})
+	defer cancelOther()
+	if got := metricValue(t, reg, "commsys_iot_subscribers"); got != "2" {
+		t.Fatalf("subscribers gauge %s, want 2", got)
+	}
+
+	s.Publish(SensorData{DeviceID: "dev1", SensorType: "temperature"})
+	cancel()
+	cancel() // idempotent
+
+	// The buffered reading is still delivered, then the channel is closed
+	if got := drain(ch); len(got) != 1 {
+		t.Errorf("received %v before the close, want the buffered reading", got)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("channel still open after unsubscribing")
+	}
+	if got := s.List(); len(got) != 1 || got[0].ID != 2 {
+		t.Errorf("subscribers %+v, want only the other one", got)
+	}
+	if got := metricValue(t, reg, "commsys_iot_subscribers"); got != "1" {
+		t.Errorf("subscribers gauge %s, want 1", got)
+	}
+
+	// Publishing after the close must not panic on the closed channel
+	s.Publish(SensorData{DeviceID: "dev1", SensorType: "temperature"})
+}
+
+func TestSubscriptionsSlowSubscriberIsolated(t *testing.T) {
+	const (
+		buffer   = 5
+		readings = 20
+	)
+	s, _, reg := newTestSubscriptions(t, buffer)
+	slow, cancelSlow := s.Subscribe(SubscriptionFilter{})
+	defer cancelSlow()
+	fast, cancelFast := s.Subscribe(SubscriptionFilter{})
+	defer cancelFast()
+
+	received := 0
+	for i := 0; i < readings; i++ {
+		done := make(chan struct{})
+		go func() {
+			s.Publish(SensorData{DeviceID: "dev1", SensorType: "temperature"})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Publish blocked on a subscriber that doesn't read")
+		}
+		received += len(drain(fast))
+	}
+	if received != readings {
+		t.Errorf("fast subscriber received %d readings, want %d", received, readings)
+	}
+	if got := len(drain(slow)); got != buffer {
+		t.Errorf("slow subscriber buffered %d readings, want %d", got, buffer)
+	}
+
+	stats := s.List()
+	if stats[0].Delivered != buffer || stats[0].Dropped != readings-buffer {
+		t.Errorf("slow subscriber delivered %d, dropped %d, want %d and %d",
+			stats[0].Delivered, stats[0].Dropped, buffer, readings-buffer)
+	}
+	if stats[1].Delivered != readings || stats[1].Dropped != 0 {
+		t.Errorf("fast subscriber delivered %d, dropped %d, want %d and none", stats[1].Delivered, stats[1].Dropped, readings)
+	}
+	if got := metricValue(t, reg, `commsys_iot_subscription_readings_total{outcome="dropped"}`); got != "15" {
+		t.Errorf("dropped readings counter %s, want %d", got, readings-buffer)
+	}
+}
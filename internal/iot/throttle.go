@@ -0,0 +1,111 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/limits"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// RateLimitErrorCode is returned in the body of messages rejected for
+// exceeding the device's message rate
+const RateLimitErrorCode = "rate_limited"
+
+// RateLimiter caps the sensor messages each device may send per second with
+// the token buckets of limits.RateLimiter, which forget idle devices. A
+// device may burst up to one second's worth of messages.
+type RateLimiter struct {
+	rate      float64             // messages per second, 0 for unlimited
+	all       *limits.RateLimiter // buckets of devices without an override, nil if unlimited
+	overrides map[string]deviceRate
+}
+
+// deviceRate is the rate of a device listed in iot.device_rates
+type deviceRate struct {
+	rate    float64
+	limiter *limits.RateLimiter // nil if the device is unlimited
+}
+
+// NewRateLimiter creates a limiter from cfg.MaxMessagesPerSecond and
+// cfg.DeviceRates, or nil if neither limits any device
+func NewRateLimiter(cfg config.IoTConfig, c clock.Clock) *RateLimiter {
+	if cfg.MaxMessagesPerSecond == 0 && len(cfg.DeviceRates) == 0 {
+		return nil
+	}
+	l := &RateLimiter{
+		rate:      cfg.MaxMessagesPerSecond,
+		all:       newDeviceBuckets(cfg.MaxMessagesPerSecond, c),
+		overrides: make(map[string]deviceRate, len(cfg.DeviceRates)),
+	}
+	for deviceID, rate := range cfg.DeviceRates {
+		l.overrides[deviceID] = deviceRate{rate: rate, limiter: newDeviceBuckets(rate, c)}
+	}
+	return l
+}
+
+// newDeviceBuckets returns buckets allowing rate messages per second after a
+// burst of one second's worth, or nil if rate is unlimited
+func newDeviceBuckets(rate float64, c clock.Clock) *limits.RateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	return limits.NewRateLimiter(rate, max(int(rate), 1), c)
+}
+
+// Rate returns the messages per second deviceID may send, 0 for unlimited
+func (l *RateLimiter) Rate(deviceID string) float64 {
+	if o, ok := l.overrides[deviceID]; ok {
+		return o.rate
+	}
+	return l.rate
+}
+
+// Allow takes a message of deviceID from its bucket. When the bucket is
+// empty it returns false and how long until the next message is allowed.
+func (l *RateLimiter) Allow(deviceID string) (bool, time.Duration) {
+	buckets := l.all
+	if o, ok := l.overrides[deviceID]; ok {
+		buckets = o.limiter
+	}
+	if buckets == nil {
+		return true, 0
+	}
+	return buckets.Allow(deviceID)
+}
+
+// WithRateLimiter makes the handler enforce the message rates of l instead
+// of a limiter of its own, so that messages other components send to
+// devices, such as the Reconciler's, count against the same rates
+func WithRateLimiter(l *RateLimiter) Option {
+	return func(h *Handler) {
+		h.limiter = l
+	}
+}
+
+// throttle takes a message of deviceID from the rate limiter. A device over
+// its rate gets 429 with RateLimitErrorCode, a Retry-After header and its
+// allowed reporting interval in IntervalHeader, and throttle returns false.
+func (h *Handler) throttle(w http.ResponseWriter, deviceID string) bool {
+	if h.limiter == nil {
+		return true
+	}
+	ok, wait := h.limiter.Allow(deviceID)
+	if ok {
+		return true
+	}
+	h.metrics.throttled.WithLabelValues(deviceID).Inc()
+	interval := time.Duration(float64(time.Second) / h.limiter.Rate(deviceID))
+	w.Header().Set(IntervalHeader, interval.Round(time.Millisecond).String())
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "device message rate exceeded",
+		"code":  RateLimitErrorCode,
+	})
+	return false
+}
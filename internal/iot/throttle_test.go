@@ -0,0 +1,90 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestRateLimiterRefillsBucket(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	cfg := config.IoTConfig{MaxMessagesPerSecond: 2, DeviceRates: map[string]float64{"exempt": 0, "slow": 0.5}}
+	l := NewRateLimiter(cfg, c)
+
+	// A burst of one second's worth, then one message every half second
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("dev1"); !ok {
+			t.Fatalf("message %d of the burst rejected", i)
+		}
+	}
+	if ok, wait := l.Allow("dev1"); ok || wait != 500*time.Millisecond {
+		t.Errorf("message over the burst: allowed %v, wait %v, want rejected for 500ms", ok, wait)
+	}
+	c.Advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("dev1"); !ok {
+		t.Error("message after the refill rejected")
+	}
+
+	// A rate below one still allows a single message
+	if ok, _ := l.Allow("slow"); !ok {
+		t.Error("first message of slow rejected")
+	}
+	if ok, wait := l.Allow("slow"); ok || wait != 2*time.Second {
+		t.Errorf("second message of slow: allowed %v, wait %v, want rejected for 2s", ok, wait)
+	}
+	for i := 0; i < 100; i++ {
+		if ok, _ := l.Allow("exempt"); !ok {
+			t.Fatal("exempt device rejected")
+		}
+	}
+	if NewRateLimiter(config.IoTConfig{}, c) != nil {
+		t.Error("limiter created without any rate")
+	}
+}
+
+func TestSensorOverRateRejected(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.MaxMessagesPerSecond = 4
+	reg := metrics.NewRegistry()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(c))
+
+	for i := 0; i < 4; i++ {
+		if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21)); rec.Code != http.StatusOK {
+			t.Fatalf("reading %d: status %d", i, rec.Code)
+		}
+	}
+	rec := send(t, h, http.MethodPost, "/iot/batch", "["+reading("dev1", 21)+"]")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("batch over the rate: status %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get(IntervalHeader); got != "250ms" {
+		t.Errorf("%s %q, want 250ms", IntervalHeader, got)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After %q, want 1", got)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["code"] != RateLimitErrorCode {
+		t.Errorf("body %v (%v), want code %s", body, err, RateLimitErrorCode)
+	}
+	if got := metricValue(t, reg, `commsys_iot_throttled_messages_total{device_id="dev1"}`); got != "1" {
+		t.Errorf("throttled messages of dev1 %q, want 1", got)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev2", 21)); rec.Code != http.StatusOK {
+		t.Errorf("another device: status %d, want 200", rec.Code)
+	}
+
+	c.Advance(250 * time.Millisecond)
+	if rec := send(t, h, http.MethodPost, "/iot/sensor", reading("dev1", 21)); rec.Code != http.StatusOK {
+		t.Errorf("reading after the interval: status %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,262 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// DesiredHeader carries the version of a device's desired state on IoT
+// responses until the device reports having applied it. The device fetches
+// the state with GET /iot/twin and reports what it applied with POST
+// /iot/state.
+const DesiredHeader = "X-IoT-Desired-Version"
+
+// Errors returned by Twins
+var (
+	ErrStaleVersion  = errors.New("desired version is not newer than the current one")
+	ErrFutureVersion = errors.New("reported version was never desired")
+	ErrTwinNotFound  = errors.New("no twin for device")
+)
+
+// DeviceTwin is the state the server wants a device to have next to the
+// state the device last reported. Desired state can be set while the device
+// is offline and is picked up once it contacts the server again.
+type DeviceTwin struct {
+	DeviceID        string                 `json:"device_id"`
+	Desired         map[string]interface{} `json:"desired"`
+	DesiredVersion  int64                  `json:"desired_version"`
+	DesiredAt       time.Time              `json:"desired_at"` // when the desired version was set
+	Reported        map[string]interface{} `json:"reported"`
+	ReportedVersion int64                  `json:"reported_version"`    // desired version the device last applied
+	Conflicts       []string               `json:"conflicts,omitempty"` // properties reported with other values than desired
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// Pending reports whether the device has yet to apply the desired state
+func (t DeviceTwin) Pending() bool {
+	return t.DesiredVersion > t.ReportedVersion
+}
+
+func (t *DeviceTwin) clone() DeviceTwin {
+	c := *t
+	c.Desired = maps.Clone(t.Desired)
+	c.Reported = maps.Clone(t.Reported)
+	c.Conflicts = append([]string(nil), t.Conflicts...)
+	return c
+}
+
+// StateReport is sent by a device to POST /iot/state after applying its
+// desired state, or whenever its state changes
+type StateReport struct {
+	DeviceID   string                 `json:"device_id"`
+	Version    int64                  `json:"version"` // desired version applied, 0 if the report answers none
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Twins holds the device twins
+type Twins struct {
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	twins map[string]*DeviceTwin
+}
+
+// NewTwins creates an empty twin registry
+func NewTwins(logger logging.Logger, c clock.Clock) *Twins {
+	return &Twins{
+		logger: logger,
+		clock:  c,
+		twins:  make(map[string]*DeviceTwin),
+	}
+}
+
+func (t *Twins) twin(deviceID string) *DeviceTwin {
+	twin, ok := t.twins[deviceID]
+	if !ok {
+		twin = &DeviceTwin{DeviceID: deviceID, Desired: map[string]interface{}{}, Reported: map[string]interface{}{}}
+		t.twins[deviceID] = twin
+	}
+	return twin
+}
+
+// SetDesired replaces the desired state of deviceID with props. A zero
+// version takes the one after the current desired version. An explicit
+// version must be newer than the current one, so that of two concurrent
+// updates the one with the higher version wins whatever order they arrive
+// in; the other fails with ErrStaleVersion.
+func (t *Twins) SetDesired(deviceID string, props map[string]interface{}, version int64) (DeviceTwin, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	twin := t.twin(deviceID)
+	if version == 0 {
+		version = twin.DesiredVersion + 1
+	} else if version <= twin.DesiredVersion {
+		return twin.clone(), fmt.Errorf("%w: %d, current is %d", ErrStaleVersion, version, twin.DesiredVersion)
+	}
+	twin.Desired = maps.Clone(props)
+	if twin.Desired == nil {
+		twin.Desired = map[string]interface{}{}
+	}
+	twin.DesiredVersion = version
+	twin.Conflicts = nil
+	twin.DesiredAt = t.clock.Now()
+	twin.UpdatedAt = twin.DesiredAt
+	t.logger.Info("Desired state set", logging.F("device_id", deviceID), logging.F("version", version))
+	return twin.clone(), nil
+}
+
+// Report merges the properties reported by a device into its twin. A report
+// for the current desired version is compared against the desired state,
+// and every property with another value is logged and listed as a
+// conflict. Properties the device didn't report are not conflicts.
+func (t *Twins) Report(report StateReport) (DeviceTwin, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	twin := t.twin(report.DeviceID)
+	if report.Version > twin.DesiredVersion {
+		return twin.clone(), fmt.Errorf("%w: %d, desired is %d", ErrFutureVersion, report.Version, twin.DesiredVersion)
+	}
+	maps.Copy(twin.Reported, report.Properties)
+	twin.ReportedVersion = max(twin.ReportedVersion, report.Version)
+	twin.UpdatedAt = t.clock.Now()
+
+	if report.Version == twin.DesiredVersion {
+		twin.Conflicts = nil
+		for name, want := range twin.Desired {
+			if got, ok := report.Properties[name]; ok && !reflect.DeepEqual(got, want) {
+				twin.Conflicts = append(twin.Conflicts, name)
+			}
+		}
+		sort.Strings(twin.Conflicts)
+		if len(twin.Conflicts) > 0 {
+			t.logger.Warn("Device reported state that conflicts with the desired state", logging.F("device_id", report.DeviceID),
+				logging.F("version", report.Version), logging.F("conflicts", twin.Conflicts))
+		}
+	}
+	return twin.clone(), nil
+}
+
+// Get returns the twin of deviceID
+func (t *Twins) Get(deviceID string) (DeviceTwin, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	twin, ok := t.twins[deviceID]
+	if !ok {
+		return DeviceTwin{}, false
+	}
+	return twin.clone(), true
+}
+
+// List returns every twin ordered by device ID
+func (t *Twins) List() []DeviceTwin {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]DeviceTwin, 0, len(t.twins))
+	for _, twin := range t.twins {
+		out = append(out, twin.clone())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeviceID < out[j].DeviceID })
+	return out
+}
+
+// pending returns the desired version deviceID has yet to apply
+func (t *Twins) pending(deviceID string) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	twin, ok := t.twins[deviceID]
+	if !ok || !twin.Pending() {
+		return 0, false
+	}
+	return twin.DesiredVersion, true
+}
+
+// WithTwins enables device twins
+func WithTwins(t *Twins) Option {
+	return func(h *Handler) {
+		h.twins = t
+	}
+}
+
+// setDesired adds the desired version deviceID has yet to apply to the
+// response headers
+func (h *Handler) setDesired(w http.ResponseWriter, deviceID string) {
+	if h.twins == nil || deviceID == "" {
+		return
+	}
+	if version, ok := h.twins.pending(deviceID); ok {
+		w.Header().Set(DesiredHeader, fmt.Sprint(version))
+	}
+}
+
+// handleTwin returns the twin of the device named by the device_id query
+// parameter or the X-Device-ID header
+func (h *Handler) handleTwin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.URL.Query().Get("device_id")
+	if id == "" {
+		id = r.Header.Get("X-Device-ID")
+	}
+	if !h.authorize(w, r, id) {
+		return
+	}
+	if h.twins == nil {
+		http.Error(w, "Device twins are disabled", http.StatusNotFound)
+		return
+	}
+	twin, ok := h.twins.Get(id)
+	if !ok {
+		http.Error(w, ErrTwinNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(twin)
+}
+
+// handleState records the state reported by a device
+func (h *Handler) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var report StateReport
+	if _, ok := h.decode(w, r, &report, "state report"); !ok {
+		return
+	}
+	if !h.authorize(w, r, report.DeviceID) {
+		return
+	}
+	if h.twins == nil {
+		http.Error(w, "Device twins are disabled", http.StatusNotFound)
+		return
+	}
+	if report.DeviceID == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+	twin, err := h.twins.Report(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	// The header set before the report was applied may be stale
+	w.Header().Del(DesiredHeader)
+	h.setDesired(w, report.DeviceID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Response{Status: "success", Message: "State reported", Data: twin})
+}
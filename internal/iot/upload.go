@@ -0,0 +1,329 @@
+package iot
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// Headers of POST /iot/upload. The file itself is the request body.
+const (
+	UploadSizeHeader   = "X-Upload-Size"
+	UploadSHA256Header = "X-Upload-SHA256" // hex encoded
+)
+
+// Upload errors returned by UploadStore.Save
+var (
+	ErrUploadTooLarge    = errors.New("upload exceeds the maximum size")
+	ErrUploadQuota       = errors.New("device upload quota exceeded")
+	ErrUploadIncomplete  = errors.New("upload body does not match the declared size")
+	ErrUploadHashInvalid = errors.New("upload body does not match the declared sha256")
+)
+
+// safeName matches device and upload IDs that are safe as path elements
+var safeName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// UploadRequest describes a file a device is about to send
+type UploadRequest struct {
+	DeviceID    string
+	ContentType string
+	Size        int64
+	SHA256      string
+}
+
+// SetHeaders writes r to the headers of an upload request
+func (r UploadRequest) SetHeaders(h http.Header) {
+	h.Set("X-Device-ID", r.DeviceID)
+	h.Set("Content-Type", r.ContentType)
+	h.Set(UploadSizeHeader, strconv.FormatInt(r.Size, 10))
+	h.Set(UploadSHA256Header, r.SHA256)
+}
+
+// ParseUploadRequest reads an UploadRequest from request headers
+func ParseUploadRequest(h http.Header) (UploadRequest, error) {
+	req := UploadRequest{
+		DeviceID:    h.Get("X-Device-ID"),
+		ContentType: h.Get("Content-Type"),
+		SHA256:      strings.ToLower(h.Get(UploadSHA256Header)),
+	}
+	if !safeName.MatchString(req.DeviceID) {
+		return req, fmt.Errorf("invalid or missing X-Device-ID")
+	}
+	if req.ContentType == "" {
+		req.ContentType = "application/octet-stream"
+	}
+	size, err := strconv.ParseInt(h.Get(UploadSizeHeader), 10, 64)
+	if err != nil || size <= 0 {
+		return req, fmt.Errorf("invalid or missing %s", UploadSizeHeader)
+	}
+	req.Size = size
+	if sum, err := hex.DecodeString(req.SHA256); err != nil || len(sum) != sha256.Size {
+		return req, fmt.Errorf("invalid or missing %s", UploadSHA256Header)
+	}
+	return req, nil
+}
+
+// Upload describes a stored file. It is the acknowledgement of an upload.
+type Upload struct {
+	ID          string    `json:"id"`
+	DeviceID    string    `json:"device_id"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	ReceivedAt  time.Time `json:"received_at"`
+	URL         string    `json:"url"` // download path on the admin listener
+}
+
+// UploadStore keeps uploaded files on disk, one directory per device, with
+// a JSON metadata file next to each upload
+type UploadStore struct {
+	dir         string
+	maxBytes    int64
+	deviceBytes int64
+	clock       clock.Clock
+
+	mu    sync.Mutex
+	usage map[string]int64 // stored and reserved bytes per device, loaded lazily
+}
+
+// NewUploadStore creates the upload directory of cfg
+func NewUploadStore(cfg config.UploadConfig, c clock.Clock) (*UploadStore, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	return &UploadStore{
+		dir:         cfg.Dir,
+		maxBytes:    cfg.MaxBytes,
+		deviceBytes: cfg.DeviceBytes,
+		clock:       c,
+		usage:       make(map[string]int64),
+	}, nil
+}
+
+// MaxBytes returns the largest accepted upload
+func (s *UploadStore) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+// Save stores the body described by req. The body must contain exactly
+// req.Size bytes hashing to req.SHA256; otherwise nothing is kept.
+func (s *UploadStore) Save(req UploadRequest, body io.Reader) (Upload, error) {
+	if req.Size > s.maxBytes {
+		return Upload{}, ErrUploadTooLarge
+	}
+	if err := s.reserve(req.DeviceID, req.Size); err != nil {
+		return Upload{}, err
+	}
+	upload, err := s.write(req, body)
+	if err != nil {
+		s.release(req.DeviceID, req.Size)
+	}
+	return upload, err
+}
+
+func (s *UploadStore) write(req UploadRequest, body io.Reader) (Upload, error) {
+	deviceDir := filepath.Join(s.dir, req.DeviceID)
+	if err := os.MkdirAll(deviceDir, 0o755); err != nil {
+		return Upload{}, err
+	}
+
+	tmp, err := os.CreateTemp(deviceDir, ".upload-*")
+	if err != nil {
+		return Upload{}, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(body, req.Size+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		return Upload{}, err
+	case n != req.Size:
+		return Upload{}, ErrUploadIncomplete
+	case hex.EncodeToString(hash.Sum(nil)) != req.SHA256:
+		return Upload{}, ErrUploadHashInvalid
+	}
+
+	now := s.clock.Now()
+	id := fmt.Sprintf("%d-%s", now.UnixNano(), randomSuffix())
+	upload := Upload{
+		ID:          id,
+		DeviceID:    req.DeviceID,
+		ContentType: req.ContentType,
+		Size:        req.Size,
+		SHA256:      req.SHA256,
+		ReceivedAt:  now,
+		URL:         "/api/devices/" + req.DeviceID + "/uploads/" + id,
+	}
+	meta, err := json.Marshal(upload)
+	if err != nil {
+		return Upload{}, err
+	}
+	if err := os.WriteFile(filepath.Join(deviceDir, id+".json"), meta, 0o644); err != nil {
+		return Upload{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(deviceDir, id)); err != nil {
+		os.Remove(filepath.Join(deviceDir, id+".json"))
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+// List returns the uploads of deviceID, newest first
+func (s *UploadStore) List(deviceID string) ([]Upload, error) {
+	if !safeName.MatchString(deviceID) {
+		return nil, os.ErrNotExist
+	}
+	paths, err := filepath.Glob(filepath.Join(s.dir, deviceID, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	uploads := make([]Upload, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var upload Upload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			return nil, fmt.Errorf("invalid upload metadata %s: %w", path, err)
+		}
+		uploads = append(uploads, upload)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].ReceivedAt.After(uploads[j].ReceivedAt) })
+	return uploads, nil
+}
+
+// Open returns the stored file of an upload and its metadata
+func (s *UploadStore) Open(deviceID, id string) (*os.File, Upload, error) {
+	if !safeName.MatchString(deviceID) || !safeName.MatchString(id) || strings.HasSuffix(id, ".json") {
+		return nil, Upload{}, os.ErrNotExist
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, deviceID, id+".json"))
+	if err != nil {
+		return nil, Upload{}, err
+	}
+	var upload Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, Upload{}, err
+	}
+	f, err := os.Open(filepath.Join(s.dir, deviceID, id))
+	if err != nil {
+		return nil, Upload{}, err
+	}
+	return f, upload, nil
+}
+
+// reserve accounts size bytes to deviceID, failing if that exceeds the
+// device quota
+func (s *UploadStore) reserve(deviceID string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.usage[deviceID]
+	if !ok {
+		uploads, err := s.List(deviceID)
+		if err != nil {
+			return err
+		}
+		for _, u := range uploads {
+			used += u.Size
+		}
+	}
+	if s.deviceBytes > 0 && used+size > s.deviceBytes {
+		s.usage[deviceID] = used
+		return ErrUploadQuota
+	}
+	s.usage[deviceID] = used + size
+	return nil
+}
+
+func (s *UploadStore) release(deviceID string, size int64) {
+	s.mu.Lock()
+	s.usage[deviceID] -= size
+	s.mu.Unlock()
+}
+
+func randomSuffix() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// WithUploads enables POST /iot/upload. Uploads are downloaded from the
+// admin listener.
+func WithUploads(store *UploadStore) Option {
+	return func(h *Handler) {
+		h.uploads = store
+	}
+}
+
+func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.uploads == nil {
+		http.Error(w, "Uploads are disabled", http.StatusNotFound)
+		return
+	}
+
+	req, err := ParseUploadRequest(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !h.authorize(w, r, req.DeviceID) {
+		return
+	}
+	if req.Size > h.uploads.MaxBytes() {
+		http.Error(w, fmt.Sprintf("Upload exceeds %d bytes", h.uploads.MaxBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !h.quotas.ChargeDevice(w, r, req.DeviceID, req.Size) {
+		return
+	}
+
+	upload, err := h.uploads.Save(req, http.MaxBytesReader(w, r.Body, req.Size+1))
+	if err != nil {
+		h.logger.Warn("Upload rejected", logging.F("device_id", req.DeviceID), logging.F("size", req.Size), logging.Err(err))
+		switch {
+		case errors.Is(err, ErrUploadTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrUploadQuota):
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		case errors.Is(err, ErrUploadIncomplete), errors.Is(err, ErrUploadHashInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to store upload", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.logger.Info("Upload stored", logging.F("device_id", upload.DeviceID), logging.F("id", upload.ID),
+		logging.F("content_type", upload.ContentType), logging.F("size", upload.Size))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(upload)
+}
@@ -0,0 +1,222 @@
+package iot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// IoT application protocol versions. Devices that never register speak
+// ProtocolV1.
+const (
+	ProtocolV1 = 1 // sensor readings and commands as originally shipped
+	ProtocolV2 = 2 // adds trace_id on commands and command responses
+	ProtocolV3 = 3 // adds delta-encoded sensor batches
+
+	MinProtocolVersion = ProtocolV1
+	MaxProtocolVersion = ProtocolV3
+)
+
+// VersionHeader carries the negotiated protocol version on every request
+const VersionHeader = "X-IoT-Protocol-Version"
+
+// negotiatedVersion is the protocol version a device registered with. It
+// is forgotten once the device has neither registered nor relied on it for
+// iot.forget_after.
+type negotiatedVersion struct {
+	version int
+	used    atomic.Int64 // unix nanoseconds of the last registration or request that relied on it
+}
+
+func (v *negotiatedVersion) lastUsed() time.Time {
+	return time.Unix(0, v.used.Load())
+}
+
+// RegisterRequest is sent by a device to negotiate a protocol version and
+// describe itself
+type RegisterRequest struct {
+	DeviceID    string   `json:"device_id"`
+	MinVersion  int      `json:"min_version"`
+	MaxVersion  int      `json:"max_version"`
+	Token       string   `json:"token,omitempty"`       // proves the device ID when the server requires it
+	Epoch       uint64   `json:"epoch,omitempty"`       // epoch of the device's reading sequence numbers
+	Compression []string `json:"compression,omitempty"` // codecs the device can send and receive bodies in, preferred first
+	DeviceInfo
+}
+
+// RegisterResponse carries the version picked by the server
+type RegisterResponse struct {
+	DeviceID    string   `json:"device_id"`
+	Version     int      `json:"version"`
+	MinVersion  int      `json:"min_version"` // server range, useful when negotiation fails
+	MaxVersion  int      `json:"max_version"`
+	Pace        int64    `json:"pace_bytes_per_sec,omitempty"` // rate to send batches and uploads at, 0 unpaced
+	Compression string   `json:"compression,omitempty"`        // codec picked from the device's, empty for plain bodies
+	Error       string   `json:"error,omitempty"`
+	Code        string   `json:"code,omitempty"`     // AuthErrorCode when the token was refused
+	Warnings    []string `json:"warnings,omitempty"` // problems that don't prevent registration
+}
+
+// NegotiateVersion picks the highest version supported by both ranges
+func NegotiateVersion(clientMin, clientMax, serverMin, serverMax int) (int, error) {
+	if clientMin <= 0 || clientMax < clientMin {
+		return 0, fmt.Errorf("invalid version range %d-%d", clientMin, clientMax)
+	}
+	version := clientMax
+	if version > serverMax {
+		version = serverMax
+	}
+	if version < clientMin || version < serverMin {
+		return 0, fmt.Errorf("no common protocol version: client supports %d-%d, server supports %d-%d",
+			clientMin, clientMax, serverMin, serverMax)
+	}
+	return version, nil
+}
+
+// UnsupportedError reports a message type or field used with a protocol
+// version that predates it
+type UnsupportedError struct {
+	Feature string
+	Since   int
+	Version int
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s unsupported in negotiated version %d (requires version %d)", e.Feature, e.Version, e.Since)
+}
+
+type versionKey struct{}
+
+// WithVersion returns a context carrying the negotiated protocol version
+func WithVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// VersionFromContext returns the negotiated protocol version, defaulting to
+// ProtocolV1 for devices that never negotiated
+func VersionFromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(versionKey{}).(int); ok {
+		return v
+	}
+	return ProtocolV1
+}
+
+// requireVersion fails if feature needs a newer version than the one in ctx
+func requireVersion(ctx context.Context, feature string, since int) error {
+	if v := VersionFromContext(ctx); v < since {
+		return &UnsupportedError{Feature: feature, Since: since, Version: v}
+	}
+	return nil
+}
+
+// requestVersion resolves the protocol version of r from VersionHeader, then
+// from the version the device registered with, then ProtocolV1
+func (h *Handler) requestVersion(r *http.Request) (int, error) {
+	if v := r.Header.Get(VersionHeader); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < MinProtocolVersion || version > MaxProtocolVersion {
+			return 0, fmt.Errorf("unsupported protocol version %q: server supports %d-%d",
+				v, MinProtocolVersion, MaxProtocolVersion)
+		}
+		return version, nil
+	}
+
+	if id := r.Header.Get("X-Device-ID"); id != "" {
+		h.versionsMu.RLock()
+		negotiated, ok := h.versions[id]
+		h.versionsMu.RUnlock()
+		if ok {
+			negotiated.used.Store(h.clock.Now().UnixNano())
+			return negotiated.version, nil
+		}
+	}
+	return ProtocolV1, nil
+}
+
+func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RegisterRequest
+	if _, ok := h.decode(w, r, &req, "registration"); !ok {
+		return
+	}
+	if req.DeviceID == "" {
+		http.Error(w, "device_id is required", http.StatusBadRequest)
+		return
+	}
+
+	resp := RegisterResponse{
+		DeviceID:   req.DeviceID,
+		MinVersion: MinProtocolVersion,
+		MaxVersion: MaxProtocolVersion,
+	}
+
+	if h.auth != nil {
+		if err := h.auth.Authenticate(req.DeviceID, req.Token); err != nil {
+			h.metrics.authFailed.Inc()
+			h.logger.Warn("Device registration refused", logging.F("device_id", req.DeviceID),
+				logging.F("remote_addr", r.RemoteAddr), logging.Err(err))
+			resp.Error, resp.Code = err.Error(), AuthErrorCode
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	if err := req.DeviceInfo.validate(); err != nil {
+		resp.Error = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	version, err := NegotiateVersion(req.MinVersion, req.MaxVersion, MinProtocolVersion, MaxProtocolVersion)
+	if err != nil {
+		h.logger.Warn("Version negotiation failed", logging.F("device_id", req.DeviceID), logging.Err(err))
+		resp.Error = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	now := h.clock.Now()
+	negotiated := &negotiatedVersion{version: version}
+	negotiated.used.Store(now.UnixNano())
+	h.versionsMu.Lock()
+	sweepIdle(&h.versionsSweep, h.versions, now, (*negotiatedVersion).lastUsed, nil)
+	h.versions[req.DeviceID] = negotiated
+	h.versionsMu.Unlock()
+	h.order.Reset(req.DeviceID)
+	h.anomalies.Reset(req.DeviceID)
+	h.registerEpoch(req.DeviceID, req.Epoch)
+	h.registerDevice(req.DeviceID, req.DeviceInfo)
+
+	if warning := sensorTypeWarning(req.SensorType); warning != "" {
+		h.logger.Warn("Device registered with an unknown sensor type", logging.F("device_id", req.DeviceID),
+			logging.F("sensor_type", req.SensorType))
+		resp.Warnings = append(resp.Warnings, warning)
+	}
+
+	resp.Version = version
+	resp.Pace = h.uploadPace
+	resp.Compression = h.pickCompression(req.Compression)
+	h.logger.Info("Device registered", logging.F("device_id", req.DeviceID), logging.F("version", version),
+		logging.F("compression", resp.Compression))
+	h.setDesired(w, req.DeviceID)
+	h.setFirmwareOffer(w, req.DeviceID)
+	h.setPendingCommands(w, req.DeviceID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,124 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestNegotiateVersion(t *testing.T) {
+	tests := []struct {
+		clientMin, clientMax int
+		want                 int // 0 for no common version
+	}{
+		{1, 1, 1},
+		{1, 3, 3},
+		{2, 9, 3},
+		{4, 9, 0},
+		{0, 3, 0},
+		{3, 2, 0},
+	}
+	for _, tt := range tests {
+		got, err := NegotiateVersion(tt.clientMin, tt.clientMax, MinProtocolVersion, MaxProtocolVersion)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("%d-%d: negotiated %d, want an error", tt.clientMin, tt.clientMax, got)
+			}
+		} else if err != nil || got != tt.want {
+			t.Errorf("%d-%d: %d (%v), want %d", tt.clientMin, tt.clientMax, got, err, tt.want)
+		}
+	}
+}
+
+func TestRegisteredVersionGatesFeatures(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	register := func(body string) (int, RegisterResponse) {
+		rec := send(t, h, http.MethodPost, "/iot/register", body)
+		var resp RegisterResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+	if status, resp := register(`{"device_id": "new", "min_version": 1, "max_version": 3}`); status != http.StatusOK || resp.Version != ProtocolV3 {
+		t.Fatalf("register 1-3: status %d, version %d, want 3", status, resp.Version)
+	}
+	if status, resp := register(`{"device_id": "old", "min_version": 1, "max_version": 1}`); status != http.StatusOK || resp.Version != ProtocolV1 {
+		t.Fatalf("register 1-1: status %d, version %d, want 1", status, resp.Version)
+	}
+	status, resp := register(`{"device_id": "future", "min_version": 4, "max_version": 5}`)
+	if status != http.StatusBadRequest || resp.Error == "" || resp.MinVersion != MinProtocolVersion || resp.MaxVersion != MaxProtocolVersion {
+		t.Errorf("register 4-5: status %d %+v, want 400 with the server range", status, resp)
+	}
+
+	// Trace IDs on commands need version 2, known from registration
+	command := `{"device_id": "dev1", "action": "reboot", "trace_id": "4bf92f3577b34da6"}`
+	rec := send(t, h, http.MethodPost, "/iot/command", command, "X-Device-ID", "old")
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "trace_id unsupported in negotiated version 1") {
+		t.Errorf("trace_id from a version 1 device: %d %q, want 400", rec.Code, rec.Body)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/command", command, "X-Device-ID", "new"); rec.Code != http.StatusOK {
+		t.Errorf("trace_id from a version 3 device: %d %q", rec.Code, rec.Body)
+	}
+	// The header overrides the registered version
+	if rec := send(t, h, http.MethodPost, "/iot/command", command, "X-Device-ID", "new", VersionHeader, "1"); rec.Code != http.StatusBadRequest {
+		t.Errorf("trace_id with version 1 in the header: status %d, want 400", rec.Code)
+	}
+	if rec := send(t, h, http.MethodPost, "/iot/command", command, VersionHeader, "9"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported version in the header: status %d, want 400", rec.Code)
+	}
+}
+
+// TestRegisteredVersionForgottenWhenIdle checks that the version of a device
+// that hasn't relied on it for iot.forget_after is forgotten, while that of
+// a device still sending without the version header is kept
+func TestRegisteredVersionForgottenWhenIdle(t *testing.T) {
+	cfg := config.Default()
+	cfg.IoT.ForgetAfter = time.Hour
+	reg := metrics.NewRegistry()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg), WithClock(c))
+
+	register := func(deviceID string) {
+		body := fmt.Sprintf(`{"device_id": %q, "min_version": 1, "max_version": 3}`, deviceID)
+		if rec := send(t, h, http.MethodPost, "/iot/register", body); rec.Code != http.StatusOK {
+			t.Fatalf("register %s: status %d", deviceID, rec.Code)
+		}
+	}
+	register("idle")
+	register("active")
+	command := `{"device_id": "dev1", "action": "reboot", "trace_id": "4bf92f3577b34da6"}`
+	for i := 0; i < 4; i++ {
+		c.Advance(30 * time.Minute)
+		if rec := send(t, h, http.MethodPost, "/iot/command", command, "X-Device-ID", "active"); rec.Code != http.StatusOK {
+			t.Fatalf("command of a version 3 device: %d %q", rec.Code, rec.Body)
+		}
+	}
+	// Registrations sweep the versions
+	for i := 2; i < idleSweepEvery; i++ {
+		register(fmt.Sprintf("dev%d", i))
+	}
+
+	h.versionsMu.RLock()
+	_, idle := h.versions["idle"]
+	_, active := h.versions["active"]
+	h.versionsMu.RUnlock()
+	if idle || !active {
+		t.Errorf("idle device kept %v, active device kept %v, want only the active one", idle, active)
+	}
+	// The forgotten device is back to version 1 until it registers again
+	if rec := send(t, h, http.MethodPost, "/iot/command", command, "X-Device-ID", "idle"); rec.Code != http.StatusBadRequest {
+		t.Errorf("trace_id from a forgotten device: status %d, want 400", rec.Code)
+	}
+}
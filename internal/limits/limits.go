@@ -0,0 +1,264 @@
+// Package limits protects the HTTP listeners against request floods,
+// oversized bodies and clients that send their request slowly.
+package limits
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// sweepEvery controls how often idle clients are dropped from a RateLimiter
+const sweepEvery = 1024
+
+// RateLimiter is a token bucket per key
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	clock clock.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	allows  int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter refilling rate tokens per second up to
+// burst for each key
+func NewRateLimiter(rate float64, burst int, c clock.Clock) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		clock:   c,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from key. If none is left it returns false and how
+// long until the next one.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, l.rate, l.burst)
+
+	l.allows++
+	if l.allows%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (b *bucket) refill(now time.Time, rate, burst float64) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.last = now
+}
+
+// sweep drops keys whose bucket has refilled, they are indistinguishable
+// from new keys
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now, l.rate, l.burst)
+		if b.tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Guard applies config.LimitsConfig to the handlers of one or more servers.
+// The rate limit is shared by all servers wrapped by a Guard, the
+// concurrency limit applies to each server on its own.
+type Guard struct {
+	cfg   config.LimitsConfig
+	clock clock.Clock
+	rate  *RateLimiter // nil if rate limiting is disabled
+
+	violations *metrics.CounterVec
+}
+
+// Option configures a Guard
+type Option func(*Guard)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(g *Guard) {
+		g.clock = c
+	}
+}
+
+// NewGuard creates a guard enforcing cfg
+func NewGuard(cfg config.LimitsConfig, reg *metrics.Registry, opts ...Option) *Guard {
+	g := &Guard{
+		cfg:        cfg,
+		clock:      clock.Real(),
+		violations: reg.CounterVec("http", "limit_violations_total", "Requests rejected or cut off by a listener limit by server and limit", "server", "limit"),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if cfg.RatePerIP > 0 {
+		g.rate = NewRateLimiter(cfg.RatePerIP, cfg.Burst, g.clock)
+	}
+	return g
+}
+
+// ConfigureServer applies the header size, header timeout and idle timeout
+// limits to srv. Requests with oversized headers are answered by net/http
+// and never reach the handler, so they are not counted as violations.
+func (g *Guard) ConfigureServer(srv *http.Server) {
+	srv.MaxHeaderBytes = g.cfg.MaxHeaderBytes
+	srv.ReadHeaderTimeout = g.cfg.ReadHeaderTimeout
+	srv.IdleTimeout = g.cfg.IdleTimeout
+}
+
+// unguarded are the liveness endpoints that clients and monitors poll. They
+// are cheap and must keep answering on a saturated server, or clients take a
+// busy server for a dead one and reconnect to it, adding to its load.
+var unguarded = map[string]bool{
+	"/ping":    true,
+	"/healthz": true,
+}
+
+// Wrap enforces the per-IP rate, the concurrency limit of server and the
+// body size and body timeout limits in front of next. Liveness pings and
+// health checks are passed through without any limit.
+func (g *Guard) Wrap(server string, next http.Handler) http.Handler {
+	var slots chan struct{}
+	if g.cfg.MaxConcurrent > 0 {
+		slots = make(chan struct{}, g.cfg.MaxConcurrent)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unguarded[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if g.rate != nil {
+			if ok, wait := g.rate.Allow(clientIP(r)); !ok {
+				g.violations.WithLabelValues(server, "rate").Inc()
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			default:
+				g.violations.WithLabelValues(server, "concurrency").Inc()
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Server busy", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+			raw := r.Body
+			if g.cfg.MaxBodyBytes > 0 {
+				if r.ContentLength > g.cfg.MaxBodyBytes {
+					g.violations.WithLabelValues(server, "body_size").Inc()
+					w.Header().Set("Connection", "close")
+					http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, g.cfg.MaxBodyBytes)
+			}
+			rc := http.NewResponseController(w)
+			if g.cfg.BodyTimeout > 0 {
+				rc.SetReadDeadline(g.clock.Now().Add(g.cfg.BodyTimeout))
+			}
+			r.Body = &guardedBody{ReadCloser: r.Body, raw: raw, rc: rc, deadline: g.cfg.BodyTimeout > 0, violation: func(limit string) {
+				g.violations.WithLabelValues(server, limit).Inc()
+			}}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// guardedBody counts the first body size or body timeout error and lifts
+// the read deadline once the body has been read in full, so that it does not
+// cut off a long response. After an error the deadline stays, so that the
+// server does not wait for the rest of the body.
+type guardedBody struct {
+	io.ReadCloser
+	raw       io.ReadCloser // body without the size limit
+	rc        *http.ResponseController
+	deadline  bool
+	violation func(limit string)
+	done      bool
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == nil || b.done {
+		return n, err
+	}
+	b.done = true
+	if err == io.EOF && b.deadline {
+		b.rc.SetReadDeadline(time.Time{})
+	}
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		b.violation("body_size")
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		b.violation("body_timeout")
+	}
+	return n, err
+}
+
+// LiftBodyLimit removes the body size limit Wrap put on r, for handlers
+// that stream bodies of any length, such as live ingest, once they have
+// authorized the request. Bodies declaring a larger Content-Length are
+// rejected before they reach the handler. The body timeout is lifted by
+// clearing the read deadline.
+func LiftBodyLimit(r *http.Request) {
+	if b, ok := r.Body.(*guardedBody); ok {
+		b.ReadCloser = b.raw
+	}
+}
+
+// clientIP returns the host part of the remote address of r
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
@@ -0,0 +1,284 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// violations returns the violations of limit counted for server
+func violations(t *testing.T, reg *metrics.Registry, server, limit string) float64 {
+	t.Helper()
+	families, err := reg.Gatherer().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		if mf.GetName() != "commsys_http_limit_violations_total" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			if labels["server"] == server && labels["limit"] == limit {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+// echo answers with the body of the request
+var echo = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	w.Write(body)
+})
+
+func TestLiftBodyLimit(t *testing.T) {
+	guard := NewGuard(config.LimitsConfig{MaxBodyBytes: 16}, metrics.NewRegistry())
+	handler := guard.Wrap("test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ingest" {
+			LiftBodyLimit(r)
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.Write(body)
+	}))
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/upload", http.StatusRequestEntityTooLarge},
+		{"/ingest", http.StatusOK},
+	}
+	for _, tt := range tests {
+		body := strings.Repeat("x", 64)
+		// An unknown length, as a streamed body has, reaches the handler
+		req := httptest.NewRequest(http.MethodPost, tt.path, io.NopCloser(strings.NewReader(body)))
+		req.ContentLength = -1
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("POST %s: status %d, want %d", tt.path, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && rec.Body.String() != body {
+			t.Errorf("POST %s: read %d bytes, want %d", tt.path, rec.Body.Len(), len(body))
+		}
+	}
+}
+
+func TestRateLimitBurstPerIP(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	handler := NewGuard(config.LimitsConfig{RatePerIP: 2, Burst: 3}, reg, WithClock(fake)).Wrap("tcp", echo)
+
+	get := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/stream/list", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 3; i++ {
+		if rec := get("10.0.0.1:40000"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d of the burst: status %d", i+1, rec.Code)
+		}
+	}
+	// Another connection from the same IP shares its bucket
+	rec := get("10.0.0.1:40001")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("request past the burst: status %d, Retry-After %q, want 429 and 1", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("10.0.0.2:40000"); rec.Code != http.StatusOK {
+		t.Errorf("other IP: status %d", rec.Code)
+	}
+
+	// Two tokens a second
+	fake.Advance(500 * time.Millisecond)
+	if rec := get("10.0.0.1:40000"); rec.Code != http.StatusOK {
+		t.Errorf("after a token was refilled: status %d", rec.Code)
+	}
+	if rec := get("10.0.0.1:40000"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after the refilled token was taken: status %d, want 429", rec.Code)
+	}
+	if n := violations(t, reg, "tcp", "rate"); n != 2 {
+		t.Errorf("%v rate violations, want 2", n)
+	}
+}
+
+func TestRateLimiterForgetsIdleKeys(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewRateLimiter(1, 1, fake)
+
+	// Keys seen once, then idle until their buckets have refilled
+	for i := 0; i < sweepEvery-1; i++ {
+		l.Allow(fmt.Sprintf("device-%d", i))
+	}
+	fake.Advance(time.Second)
+	l.Allow("busy")
+	if len(l.buckets) != 0 {
+		t.Errorf("%d buckets after the sweep, want the refilled ones dropped", len(l.buckets))
+	}
+
+	// A key that is still refilling is kept
+	l.Allow("busy")
+	for i := 0; i < sweepEvery-1; i++ {
+		l.Allow("busy")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket of a key still refilling dropped")
+	}
+}
+
+func TestPingsPassSaturatedGuard(t *testing.T) {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	guard := NewGuard(config.LimitsConfig{MaxConcurrent: 1, RatePerIP: 1, Burst: 1}, metrics.NewRegistry(), WithClock(fake))
+	mux := http.NewServeMux()
+	entered, release := make(chan struct{}), make(chan struct{})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	})
+	mux.Handle("/ping", health.PingHandler())
+	mux.Handle("/healthz", echo)
+	handler := guard.Wrap("quic", mux)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The one request slot is held and the IP's only token is spent
+	done := make(chan struct{})
+	go func() {
+		serve("/slow")
+		close(done)
+	}()
+	<-entered
+	defer func() {
+		close(release)
+		<-done
+	}()
+	if rec := serve("/stream/list"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request on the saturated guard: status %d, want 429", rec.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		for _, path := range []string{"/ping", "/healthz"} {
+			if rec := serve(path); rec.Code != http.StatusOK {
+				t.Errorf("%s %d on the saturated guard: status %d, want 200", path, i+1, rec.Code)
+			}
+		}
+	}
+}
+
+func TestOversizedHeadersRejected(t *testing.T) {
+	reg := metrics.NewRegistry()
+	guard := NewGuard(config.LimitsConfig{MaxHeaderBytes: 1024}, reg)
+	served := 0
+	srv := httptest.NewUnstartedServer(guard.Wrap("tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})))
+	guard.ConfigureServer(srv.Config)
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	get := func(header string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/stream/list", nil)
+		req.Header.Set("X-Padding", header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("small"); status != http.StatusOK {
+		t.Fatalf("small headers: status %d", status)
+	}
+	// net/http reads somewhat past MaxHeaderBytes before it refuses headers
+	if status := get(strings.Repeat("x", 64<<10)); status != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("oversized headers: status %d, want 431", status)
+	}
+	if served != 1 {
+		t.Errorf("handler served %d requests, want only the one with small headers", served)
+	}
+}
+
+func TestSlowBodyTimesOut(t *testing.T) {
+	const timeout = 200 * time.Millisecond
+	reg := metrics.NewRegistry()
+	readErr := make(chan error, 1)
+	handler := NewGuard(config.LimitsConfig{MaxBodyBytes: 1 << 20, BodyTimeout: timeout}, reg).Wrap("tcp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if r.URL.Path == "/slow" {
+			readErr <- err
+			return
+		}
+		// A response slower than the body timeout isn't cut off once the
+		// body was read
+		time.Sleep(timeout + 100*time.Millisecond)
+		w.Write(body)
+	}))
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	// A client that sends a byte of its body and then nothing
+	r, w := io.Pipe()
+	t.Cleanup(func() { w.Close() })
+	go func() {
+		w.Write([]byte("x"))
+	}()
+	go func() {
+		if resp, err := http.Post(srv.URL+"/slow", "text/plain", r); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf("reading the slow body failed with %v, want the deadline", err)
+		}
+	case <-time.After(5 * timeout):
+		t.Fatal("slow body still read after its timeout")
+	}
+	if n := violations(t, reg, "tcp", "body_timeout"); n != 1 {
+		t.Errorf("%v body timeout violations, want 1", n)
+	}
+
+	resp, err := http.Post(srv.URL+"/fast", "text/plain", strings.NewReader("reading"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "reading" {
+		t.Errorf("slow response to a fast body: status %d, %q", resp.StatusCode, body)
+	}
+	if n := violations(t, reg, "tcp", "body_timeout"); n != 1 {
+		t.Errorf("%v body timeout violations after the fast body, want 1", n)
+	}
+}
@@ -64,4 +64,4 @@ func DefaultConfig() *Config {
 		MaxBandwidth:  1024 * 1024 * 100, // 100MB/s
 		InitialWindow: 1024 * 1024,       // 1MB
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,113 @@
+package quic
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// PathStats is what a connection measured of the path to its peer
+type PathStats struct {
+	RTT         time.Duration // smoothed, 0 before the first sample
+	PacketsSent int64
+	PacketsLost int64 // declared lost, and retransmitted if they carried data that needs it
+}
+
+// LossPercent returns the share of the packets sent that were lost
+func (s PathStats) LossPercent() float64 {
+	if s.PacketsSent == 0 {
+		return 0
+	}
+	return float64(s.PacketsLost) / float64(s.PacketsSent) * 100
+}
+
+// Paths follows the RTT and packet loss of every open connection by the
+// address of its peer, as measured by quic-go's loss recovery. Connections
+// are dropped once they close.
+type Paths struct {
+	mu    sync.Mutex
+	conns map[string]*path
+}
+
+// path holds the measurements of one connection, updated for every packet
+type path struct {
+	rtt  atomic.Int64 // nanoseconds
+	sent atomic.Int64
+	lost atomic.Int64
+}
+
+// NewPaths creates an empty set of connections
+func NewPaths() *Paths {
+	return &Paths{conns: make(map[string]*path)}
+}
+
+// Trace makes the connections of qc report to p, besides any tracer qc
+// already has, and returns qc
+func (p *Paths) Trace(qc *quicgo.Config) *quicgo.Config {
+	prev := qc.Tracer
+	qc.Tracer = func(ctx context.Context, perspective logging.Perspective, id quicgo.ConnectionID) *logging.ConnectionTracer {
+		t := p.tracer()
+		if prev == nil {
+			return t
+		}
+		return logging.NewMultiplexedConnectionTracer(prev(ctx, perspective, id), t)
+	}
+	return qc
+}
+
+// Path returns the measurements of the connection from the peer at remote,
+// as in http.Request.RemoteAddr, or false if there is none. A nil Paths has
+// none.
+func (p *Paths) Path(remote string) (PathStats, bool) {
+	if p == nil {
+		return PathStats{}, false
+	}
+	p.mu.Lock()
+	c := p.conns[remote]
+	p.mu.Unlock()
+	if c == nil {
+		return PathStats{}, false
+	}
+	return PathStats{
+		RTT:         time.Duration(c.rtt.Load()),
+		PacketsSent: c.sent.Load(),
+		PacketsLost: c.lost.Load(),
+	}, true
+}
+
+func (p *Paths) tracer() *logging.ConnectionTracer {
+	c := &path{}
+	var remote string
+	return &logging.ConnectionTracer{
+		StartedConnection: func(_, addr net.Addr, _, _ logging.ConnectionID) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			remote = addr.String()
+			p.conns[remote] = c
+		},
+		SentLongHeaderPacket: func(*logging.ExtendedHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			c.sent.Add(1)
+		},
+		SentShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			c.sent.Add(1)
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			c.lost.Add(1)
+		},
+		UpdatedMetrics: func(rtt *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+			c.rtt.Store(int64(rtt.SmoothedRTT()))
+		},
+		ClosedConnection: func(error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			if p.conns[remote] == c {
+				delete(p.conns, remote)
+			}
+		},
+	}
+}
@@ -0,0 +1,100 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+func TestLossPercent(t *testing.T) {
+	for _, tt := range []struct {
+		stats PathStats
+		want  float64
+	}{
+		{PathStats{}, 0},
+		{PathStats{PacketsSent: 200, PacketsLost: 5}, 2.5},
+	} {
+		if got := tt.stats.LossPercent(); got != tt.want {
+			t.Errorf("%+v: %v%%, want %v%%", tt.stats, got, tt.want)
+		}
+	}
+}
+
+func TestPathsFollowConnections(t *testing.T) {
+	cert, err := GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := NewPaths()
+	ln, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"paths"}}, paths.Trace(&quicgo.Config{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	accepted := make(chan *quicgo.Conn, 1)
+	go func() {
+		conn, err := ln.Accept(ctx)
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+		str, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		io.Copy(str, str)
+		str.Close()
+	}()
+
+	client, err := quicgo.DialAddr(ctx, ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"paths"}}, &quicgo.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := client.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	str.Write([]byte("ping"))
+	str.Close()
+	if echo, err := io.ReadAll(str); err != nil || string(echo) != "ping" {
+		t.Fatalf("echoed %q, %v", echo, err)
+	}
+
+	conn := <-accepted
+	remote := conn.RemoteAddr().String()
+	stats, ok := paths.Path(remote)
+	if !ok || stats.RTT <= 0 || stats.PacketsSent == 0 {
+		t.Errorf("path of %s: %+v, %v, want its RTT and packets", remote, stats, ok)
+	}
+	if _, ok := paths.Path("127.0.0.1:1"); ok {
+		t.Error("path of an unknown peer")
+	}
+	if _, ok := (*Paths)(nil).Path(remote); ok {
+		t.Error("nil Paths has a path")
+	}
+
+	client.CloseWithError(0, "")
+	select {
+	case <-conn.Context().Done():
+	case <-ctx.Done():
+		t.Fatal("server connection still open")
+	}
+	for {
+		if _, ok := paths.Path(remote); !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("path of a closed connection still followed")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package quic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// NewTLSConfig builds a *tls.Config from a TLS config section. A self-signed
+// certificate is generated when no cert/key pair is configured, and
+// defaultALPN is used when the section does not list protocols.
+func NewTLSConfig(cfg config.TLSConfig, defaultALPN ...string) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if cfg.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+	} else {
+		cert, err = GenerateSelfSignedCert()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate certificate: %w", err)
+		}
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   defaultALPN,
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(cfg.ALPN) > 0 {
+		tlsConfig.NextProtos = cfg.ALPN
+	}
+	if cfg.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	if cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
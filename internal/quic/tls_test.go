@@ -0,0 +1,85 @@
+package quic
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// writeCert writes a self-signed certificate and its key as PEM files in a
+// temporary directory and returns their paths
+func writeCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	cert, err := GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	certFile, keyFile := writeCert(t)
+	noPEM := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(noPEM, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.TLSConfig
+		wantErr string
+		check   func(*tls.Config) bool
+	}{
+		{"self-signed", config.TLSConfig{}, "", func(c *tls.Config) bool {
+			return len(c.Certificates) == 1 && c.MinVersion == tls.VersionTLS12 && slices.Equal(c.NextProtos, []string{"h3"})
+		}},
+		{"cert files", config.TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: "1.3", ALPN: []string{"h2"}}, "", func(c *tls.Config) bool {
+			return len(c.Certificates) == 1 && c.MinVersion == tls.VersionTLS13 && slices.Equal(c.NextProtos, []string{"h2"})
+		}},
+		{"client CA", config.TLSConfig{ClientCAFile: certFile}, "", func(c *tls.Config) bool {
+			return c.ClientCAs != nil && c.ClientAuth == tls.RequireAndVerifyClientCert
+		}},
+		{"key of another cert", config.TLSConfig{CertFile: certFile, KeyFile: certFile}, "failed to load certificate", nil},
+		{"missing cert", config.TLSConfig{CertFile: filepath.Join(t.TempDir(), "missing.crt"), KeyFile: keyFile}, "failed to load certificate", nil},
+		{"missing client CA", config.TLSConfig{ClientCAFile: filepath.Join(t.TempDir(), "missing.pem")}, "failed to read client CA", nil},
+		{"client CA without certificates", config.TLSConfig{ClientCAFile: noPEM}, "no certificates found", nil},
+	}
+	for _, tt := range tests {
+		c, err := NewTLSConfig(tt.cfg, "h3")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !tt.check(c) {
+			t.Errorf("%s: unexpected config: min version %x, ALPN %v, client auth %v", tt.name, c.MinVersion, c.NextProtos, c.ClientAuth)
+		}
+	}
+}
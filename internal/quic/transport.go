@@ -0,0 +1,99 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	quicgo "github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// TransportConfig returns the quic-go configuration for cfg. If onBlocked is
+// not nil it is called with every period a connection's sends spent blocked
+// on the peer's flow control.
+func TransportConfig(cfg config.QUICConfig, onBlocked func(time.Duration)) *quicgo.Config {
+	qc := &quicgo.Config{
+		InitialStreamReceiveWindow:     cfg.InitialStreamWindow,
+		MaxStreamReceiveWindow:         cfg.MaxStreamWindow,
+		InitialConnectionReceiveWindow: cfg.InitialConnectionWindow,
+		MaxConnectionReceiveWindow:     cfg.MaxConnectionWindow,
+	}
+	if onBlocked != nil {
+		qc.Tracer = func(context.Context, logging.Perspective, quicgo.ConnectionID) *logging.ConnectionTracer {
+			return newFlowTracer(onBlocked)
+		}
+	}
+	return qc
+}
+
+// NewClientTransport returns an HTTP/3 client transport using the windows of
+// cfg. Certificates are not verified, the servers under test are self-signed.
+func NewClientTransport(cfg config.QUICConfig, onBlocked func(time.Duration)) *http3.Transport {
+	return &http3.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+		},
+		QUICConfig: TransportConfig(cfg, onBlocked),
+	}
+}
+
+// newFlowTracer measures how long a connection was window-limited: from the
+// first DATA_BLOCKED or STREAM_DATA_BLOCKED frame it sends until the peer
+// raises a limit with MAX_DATA or MAX_STREAM_DATA, or the connection closes.
+// Stream and connection limits are not told apart.
+func newFlowTracer(onBlocked func(time.Duration)) *logging.ConnectionTracer {
+	var mu sync.Mutex
+	var since time.Time
+
+	end := func() {
+		mu.Lock()
+		start := since
+		since = time.Time{}
+		mu.Unlock()
+		if !start.IsZero() {
+			onBlocked(time.Since(start))
+		}
+	}
+	sent := func(frames []logging.Frame) {
+		for _, f := range frames {
+			switch f.(type) {
+			case *logging.DataBlockedFrame, *logging.StreamDataBlockedFrame:
+				mu.Lock()
+				if since.IsZero() {
+					since = time.Now()
+				}
+				mu.Unlock()
+			}
+		}
+	}
+	received := func(frames []logging.Frame) {
+		for _, f := range frames {
+			switch f.(type) {
+			case *logging.MaxDataFrame, *logging.MaxStreamDataFrame:
+				end()
+			}
+		}
+	}
+
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(_ *logging.ExtendedHeader, _ logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, frames []logging.Frame) {
+			sent(frames)
+		},
+		SentShortHeaderPacket: func(_ *logging.ShortHeader, _ logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, frames []logging.Frame) {
+			sent(frames)
+		},
+		ReceivedLongHeaderPacket: func(_ *logging.ExtendedHeader, _ logging.ByteCount, _ logging.ECN, frames []logging.Frame) {
+			received(frames)
+		},
+		ReceivedShortHeaderPacket: func(_ *logging.ShortHeader, _ logging.ByteCount, _ logging.ECN, frames []logging.Frame) {
+			received(frames)
+		},
+		ClosedConnection: func(error) {
+			end()
+		},
+	}
+}
@@ -0,0 +1,311 @@
+// Package quota accounts bytes per device and per streaming session over
+// rolling windows and enforces the configured limits.
+package quota
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// ErrorCode is returned in the body of requests rejected for exceeding a quota
+const ErrorCode = "quota_exceeded"
+
+// windowBuckets is the resolution of each rolling window
+const windowBuckets = 60
+
+// sweepEvery controls how often idle keys are dropped from a tracker
+const sweepEvery = 1024
+
+// Decision is the outcome of charging bytes against a quota
+type Decision int
+
+const (
+	// Allow means the key is within its quota
+	Allow Decision = iota
+	// Throttle means the key is past the throttle ratio and should be slowed down
+	Throttle
+	// Reject means the key has exhausted its quota for the current window
+	Reject
+)
+
+// Usage describes the bytes used by one key
+type Usage struct {
+	Key      string `json:"key"`
+	Bytes    int64  `json:"bytes"`
+	Limit    int64  `json:"limit"`
+	WindowMs int64  `json:"window_ms"`
+}
+
+// Tracker accumulates bytes per key in a rolling window
+type Tracker struct {
+	limit    int64
+	window   time.Duration
+	bucket   time.Duration
+	throttle int64
+
+	clock clock.Clock
+
+	mu   sync.Mutex
+	keys map[string]*window
+	adds int
+}
+
+// window is a ring of per-bucket byte counts
+type window struct {
+	counts [windowBuckets]int64
+	last   int64 // absolute index of the newest bucket
+}
+
+// NewTracker creates a tracker for limit. A zero limit accounts usage but
+// never throttles or rejects.
+func NewTracker(limit config.QuotaLimit, throttleRatio float64, c clock.Clock) *Tracker {
+	bucket := limit.Window / windowBuckets
+	if bucket <= 0 {
+		bucket = time.Second
+	}
+	return &Tracker{
+		limit:    limit.Bytes,
+		window:   limit.Window,
+		bucket:   bucket,
+		throttle: int64(float64(limit.Bytes) * throttleRatio),
+		clock:    c,
+		keys:     make(map[string]*window),
+	}
+}
+
+// Add charges n bytes to key and returns the resulting decision
+func (t *Tracker) Add(key string, n int64) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	idx := t.index()
+	w, ok := t.keys[key]
+	if !ok {
+		w = &window{last: idx}
+		t.keys[key] = w
+	}
+	w.advance(idx)
+	w.counts[idx%windowBuckets] += n
+
+	t.adds++
+	if t.adds%sweepEvery == 0 {
+		t.sweep(idx)
+	}
+
+	return t.decide(w.sum())
+}
+
+// Check returns the decision for key without charging it
+func (t *Tracker) Check(key string) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.keys[key]
+	if !ok {
+		return Allow
+	}
+	w.advance(t.index())
+	return t.decide(w.sum())
+}
+
+// RetryAfter returns how long until the oldest bucket of the window expires
+func (t *Tracker) RetryAfter() time.Duration {
+	now := t.clock.Now()
+	return t.bucket - now.Sub(now.Truncate(t.bucket))
+}
+
+// Snapshot returns the current usage of every active key, largest first
+func (t *Tracker) Snapshot() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	idx := t.index()
+	t.sweep(idx)
+
+	usage := make([]Usage, 0, len(t.keys))
+	for key, w := range t.keys {
+		usage = append(usage, Usage{
+			Key:      key,
+			Bytes:    w.sum(),
+			Limit:    t.limit,
+			WindowMs: t.window.Milliseconds(),
+		})
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Bytes != usage[j].Bytes {
+			return usage[i].Bytes > usage[j].Bytes
+		}
+		return usage[i].Key < usage[j].Key
+	})
+	return usage
+}
+
+func (t *Tracker) index() int64 {
+	return t.clock.Now().UnixNano() / int64(t.bucket)
+}
+
+func (t *Tracker) decide(used int64) Decision {
+	switch {
+	case t.limit <= 0:
+		return Allow
+	case used > t.limit:
+		return Reject
+	case used > t.throttle:
+		return Throttle
+	default:
+		return Allow
+	}
+}
+
+// sweep drops keys whose whole window has expired
+func (t *Tracker) sweep(idx int64) {
+	for key, w := range t.keys {
+		if idx-w.last >= windowBuckets {
+			delete(t.keys, key)
+		}
+	}
+}
+
+// advance zeroes the buckets that rolled out of the window since last
+func (w *window) advance(idx int64) {
+	if idx <= w.last {
+		return
+	}
+	if idx-w.last >= windowBuckets {
+		w.counts = [windowBuckets]int64{}
+	} else {
+		for i := w.last + 1; i <= idx; i++ {
+			w.counts[i%windowBuckets] = 0
+		}
+	}
+	w.last = idx
+}
+
+func (w *window) sum() int64 {
+	var total int64
+	for _, c := range w.counts {
+		total += c
+	}
+	return total
+}
+
+// Manager holds the device and session trackers shared by the IoT and
+// streaming handlers
+type Manager struct {
+	Devices  *Tracker
+	Sessions *Tracker
+
+	delay     time.Duration
+	clock     clock.Clock
+	throttled *metrics.CounterVec
+	rejected  *metrics.CounterVec
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(m *Manager) {
+		m.clock = c
+	}
+}
+
+// NewManager creates the trackers described by cfg
+func NewManager(cfg config.QuotaConfig, reg *metrics.Registry, opts ...Option) *Manager {
+	m := &Manager{
+		delay:     cfg.ThrottleDelay,
+		clock:     clock.Real(),
+		throttled: reg.CounterVec("quota", "throttled_total", "Requests delayed for approaching a quota by kind", "kind"),
+		rejected:  reg.CounterVec("quota", "rejected_total", "Requests rejected for exceeding a quota by kind", "kind"),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.Devices = NewTracker(cfg.Device, cfg.ThrottleRatio, m.clock)
+	m.Sessions = NewTracker(cfg.Session, cfg.ThrottleRatio, m.clock)
+	return m
+}
+
+// ChargeDevice charges n received bytes to deviceID and enforces the device
+// quota. It returns false if the request must not be served.
+func (m *Manager) ChargeDevice(w http.ResponseWriter, r *http.Request, deviceID string, n int64) bool {
+	return m.enforce(w, r, m.Devices, "device", m.Devices.Add(deviceID, n))
+}
+
+// CheckSession enforces the session quota of the client of r before a
+// response is sent. It returns false if the request must not be served.
+func (m *Manager) CheckSession(w http.ResponseWriter, r *http.Request) bool {
+	return m.enforce(w, r, m.Sessions, "session", m.Sessions.Check(ClientKey(r)))
+}
+
+// ChargeSession charges n sent bytes to the client of r
+func (m *Manager) ChargeSession(r *http.Request, n int64) {
+	m.Sessions.Add(ClientKey(r), n)
+}
+
+// enforce applies decision d. Throttled requests are delayed; rejected
+// requests get a 429 with ErrorCode and the connection is closed where the
+// protocol allows it.
+func (m *Manager) enforce(w http.ResponseWriter, r *http.Request, t *Tracker, kind string, d Decision) bool {
+	switch d {
+	case Throttle:
+		m.throttled.WithLabelValues(kind).Inc()
+		select {
+		case <-m.clock.After(m.delay):
+		case <-r.Context().Done():
+			return false
+		}
+		return true
+	case Reject:
+		m.rejected.WithLabelValues(kind).Inc()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After", retryAfterSeconds(t.RetryAfter()))
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{
+			"error": kind + " quota exceeded",
+			"code":  ErrorCode,
+		})
+		return false
+	default:
+		return true
+	}
+}
+
+// SessionKey identifies a streaming session by its X-Session-ID header,
+// falling back to the client address. The client picks the ID, so the
+// session quota is charged by ClientKey instead.
+func SessionKey(r *http.Request) string {
+	if id := r.Header.Get("X-Session-ID"); id != "" {
+		return id
+	}
+	return ClientKey(r)
+}
+
+// ClientKey identifies the client of r by its address, which it can't
+// change from one request to the next like a header
+func ClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func retryAfterSeconds(d time.Duration) string {
+	secs := int64((d + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
@@ -0,0 +1,121 @@
+package quota
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+var start = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestTrackerWindowRollsOver(t *testing.T) {
+	c := clock.NewFake(start)
+	// 600 bytes a minute, in buckets of a second
+	tr := NewTracker(config.QuotaLimit{Bytes: 600, Window: time.Minute}, 0.8, c)
+
+	if d := tr.Add("dev1", 500); d != Throttle {
+		t.Fatalf("500 of 600 bytes: %v, want Throttle", d)
+	}
+	c.Advance(30 * time.Second)
+	tr.Add("dev1", 50)
+	c.Advance(29 * time.Second)
+	if d := tr.Check("dev1"); d != Throttle {
+		t.Errorf("550 bytes within the window: %v, want Throttle", d)
+	}
+
+	// The bucket of the first 500 bytes leaves the window a minute later
+	c.Advance(time.Second)
+	if d := tr.Check("dev1"); d != Allow {
+		t.Errorf("after the first bucket rolled out: %v, want Allow", d)
+	}
+	if d := tr.Add("dev1", 600); d != Reject {
+		t.Errorf("650 bytes within the window: %v, want Reject", d)
+	}
+	if usage := tr.Snapshot(); len(usage) != 1 || usage[0].Bytes != 650 {
+		t.Errorf("usage %+v, want 650 bytes of dev1", usage)
+	}
+
+	// A key idle for a whole window is forgotten
+	c.Advance(time.Minute)
+	if usage := tr.Snapshot(); len(usage) != 0 {
+		t.Errorf("usage %+v after a whole window, want none", usage)
+	}
+	if d := tr.Add("dev1", 600); d != Throttle {
+		t.Errorf("600 bytes in a new window: %v, want Throttle", d)
+	}
+}
+
+func TestRejectedRequestGetsRetryAfter(t *testing.T) {
+	c := clock.NewFake(start)
+	cfg := config.QuotaConfig{
+		// Buckets of 10s
+		Device:        config.QuotaLimit{Bytes: 100, Window: 10 * time.Minute},
+		ThrottleRatio: 1,
+	}
+	m := NewManager(cfg, metrics.NewRegistry(), WithClock(c))
+
+	req := httptest.NewRequest(http.MethodPost, "/iot/sensor", nil)
+	if rec := httptest.NewRecorder(); !m.ChargeDevice(rec, req, "dev1", 100) {
+		t.Fatalf("100 of 100 bytes rejected with %d", rec.Code)
+	}
+	c.Advance(2500 * time.Millisecond)
+	rec := httptest.NewRecorder()
+	if m.ChargeDevice(rec, req, "dev1", 1) {
+		t.Fatal("101 of 100 bytes served")
+	}
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status %d, want 429", rec.Code)
+	}
+	// 7.5s until the bucket ends, rounded up
+	if got := rec.Header().Get("Retry-After"); got != "8" {
+		t.Errorf("Retry-After %q, want 8", got)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || body["code"] != ErrorCode {
+		t.Errorf("body %v (%v), want code %s", body, err, ErrorCode)
+	}
+}
+
+func TestDeviceAndSessionQuotasChargedSeparately(t *testing.T) {
+	limit := config.QuotaLimit{Bytes: 100, Window: time.Minute}
+	m := NewManager(config.QuotaConfig{Device: limit, Session: limit, ThrottleRatio: 1}, metrics.NewRegistry(), WithClock(clock.NewFake(start)))
+
+	viewer := func(addr, session string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/stream/chunk/stream_001", nil)
+		req.RemoteAddr = addr
+		req.Header.Set("X-Session-ID", session)
+		return req
+	}
+	req := viewer("10.0.0.1:40000", "dev1")
+	if !m.ChargeDevice(httptest.NewRecorder(), req, "dev1", 90) {
+		t.Fatal("90 bytes of dev1 rejected")
+	}
+	m.ChargeSession(req, 110)
+
+	if !m.ChargeDevice(httptest.NewRecorder(), req, "dev1", 0) {
+		t.Error("device quota charged with the bytes of the session")
+	}
+	if m.CheckSession(httptest.NewRecorder(), req) {
+		t.Error("session over its quota served")
+	}
+	// Another session ID from the same client doesn't get a quota of its own
+	if m.CheckSession(httptest.NewRecorder(), viewer("10.0.0.1:40001", "fresh")) {
+		t.Error("new X-Session-ID from the same client served")
+	}
+	if !m.CheckSession(httptest.NewRecorder(), viewer("10.0.0.2:40000", "dev1")) {
+		t.Error("other client rejected")
+	}
+
+	if usage := m.Devices.Snapshot(); len(usage) != 1 || usage[0].Key != "dev1" || usage[0].Bytes != 90 {
+		t.Errorf("device usage %+v, want 90 bytes of dev1", usage)
+	}
+	if usage := m.Sessions.Snapshot(); len(usage) != 1 || usage[0].Key != "10.0.0.1" || usage[0].Bytes != 110 {
+		t.Errorf("session usage %+v, want 110 bytes of 10.0.0.1", usage)
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/admin"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// quicListener runs the HTTP/3 server and can take it down for a while.
+// An http3.Server cannot be reused once closed, so every restart builds a
+// new one.
+type quicListener struct {
+	newServer func(addr string) *http3.Server
+	logger    logging.Logger
+	control   *admin.Listener
+
+	mu     sync.Mutex
+	server *http3.Server
+	conn   net.PacketConn // socket passed to Serve, closed when taken down
+}
+
+func newQUICListener(addr string, newServer func(addr string) *http3.Server, logger logging.Logger) *quicListener {
+	l := &quicListener{
+		newServer: newServer,
+		logger:    logger,
+		server:    newServer(addr),
+	}
+	l.control = admin.NewListener("quic", logger, l.addr, l.takeDown, l.bringUp)
+	return l
+}
+
+// Start serves until the listener is closed or restarted
+func (l *quicListener) Start() error {
+	l.mu.Lock()
+	srv := l.server
+	l.mu.Unlock()
+
+	l.logger.Info("Starting QUIC server", logging.F("addr", srv.Addr))
+	return serveQUIC(srv)
+}
+
+// Serve serves on conn until the listener is closed or restarted. Later
+// restarts listen on the address of conn.
+func (l *quicListener) Serve(conn net.PacketConn) error {
+	l.mu.Lock()
+	l.server = l.newServer(conn.LocalAddr().String())
+	l.conn = conn
+	srv := l.server
+	l.mu.Unlock()
+
+	l.logger.Info("Starting QUIC server", logging.F("addr", srv.Addr))
+	if err := srv.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Restart closes the listener and every open connection, and listens again
+// once down has elapsed
+func (l *quicListener) Restart(down time.Duration) error {
+	return l.control.Restart(down)
+}
+
+// takeDown closes the listener and every open connection, keeping a fresh
+// server to listen with later
+func (l *quicListener) takeDown() error {
+	l.mu.Lock()
+	old, conn := l.server, l.conn
+	l.server = l.newServer(old.Addr)
+	l.conn = nil
+	l.mu.Unlock()
+
+	err := old.Close()
+	// http3.Server leaves sockets it was given open
+	if conn != nil {
+		conn.Close()
+	}
+	return err
+}
+
+// bringUp serves again after takeDown. Errors opening the UDP socket are
+// logged, as http3.Server only reports them from its serve loop.
+func (l *quicListener) bringUp() error {
+	l.mu.Lock()
+	srv := l.server
+	l.mu.Unlock()
+
+	go func() {
+		if err := serveQUIC(srv); err != nil {
+			l.logger.Error("Server failed", logging.Err(err))
+		}
+	}()
+	return nil
+}
+
+// addr returns the address the listener is opened on
+func (l *quicListener) addr() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.server.Addr
+}
+
+// Subsystem controls the listener from the admin API
+func (l *quicListener) Subsystem() admin.Subsystem {
+	return l.control.Subsystem()
+}
+
+// Close stops the server for good
+func (l *quicListener) Close() error {
+	l.control.Close()
+	l.mu.Lock()
+	srv := l.server
+	l.mu.Unlock()
+	return srv.Close()
+}
+
+// serveQUIC runs srv, treating a deliberate close as success
+func serveQUIC(srv *http3.Server) error {
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
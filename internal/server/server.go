@@ -0,0 +1,440 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/admin"
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	mqttbridge "github.com/nik1740/quic-communication-system/internal/bridge/mqtt"
+	"github.com/nik1740/quic-communication-system/internal/congestion"
+	"github.com/nik1740/quic-communication-system/internal/impair"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/limits"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/internal/sink"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/internal/tcp"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Server is the HTTP/3 server of cmd/server: the IoT and streaming handlers,
+// the components they share, the listener limits and the admin API. The
+// soak test, the demo and the self-test run the same server on ephemeral
+// ports.
+type Server struct {
+	cfg    *config.Config
+	logger logging.Logger
+
+	reg         *metrics.Registry
+	conns       *admin.ConnTracker
+	health      *health.Registry
+	quotas      *quota.Manager
+	guard       *limits.Guard
+	impairments *impair.Registry
+
+	migrations    *iot.Migrations
+	aggregates    *iot.Aggregator
+	twins         *iot.Twins
+	firmware      *iot.FirmwareUpdates
+	alerts        *iot.Alerts
+	deviceHealth  *iot.DeviceHealthTracker
+	presence      *iot.Presence
+	gaps          *iot.GapTracker
+	devices       *iot.DeviceRegistry
+	subscriptions *iot.Subscriptions
+	clockSkew     *iot.ClockSkew
+	anomalies     *iot.Anomalies
+	sessions      *iot.Sessions
+	outbox        *iot.Outbox
+	limiter       *iot.RateLimiter
+	reconciler    *iot.Reconciler
+	bridge        *mqttbridge.Subsystem
+	publisher     iot.Publisher
+	sinks         *iot.Dispatcher
+	uploads       *iot.UploadStore
+
+	timelines *streaming.Timelines
+	content   *streaming.Content
+	catalog   *streaming.Catalog
+
+	iot      *iot.Handler
+	streams  *streaming.Handler
+	listener *quicListener
+	admin    *admin.Server // nil without admin.addr
+}
+
+// New assembles the server described by cfg. Nothing listens until Start or
+// Serve is called.
+func New(cfg *config.Config, logger logging.Logger) (*Server, error) {
+	s, err := assemble(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Admin.Addr != "" {
+		if err := s.newAdmin("quic", cfg.Server.Addr, s.listener.Subsystem(), s.listener.Restart); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewTCP assembles the server described by cfg to serve its handlers over
+// TCP/TLS only, on server.tcp.addr. The admin API controls the returned TCP
+// server; the QUIC listener is never started.
+func NewTCP(cfg *config.Config, logger logging.Logger) (*Server, *tcp.Server, error) {
+	tlsConfig, err := quiclib.NewTLSConfig(cfg.TCPTLS(), "h2", "http/1.1")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+	s, err := assemble(cfg, logger)
+	if err != nil {
+		return nil, nil, err
+	}
+	tcpServer := tcp.NewServer(cfg, tlsConfig, logger.Named("tcp"), s.TCPDeps())
+	if cfg.Admin.Addr != "" {
+		if err := s.newAdmin("tcp", cfg.Server.TCP.Addr, tcpServer.Subsystem(), tcpServer.Restart); err != nil {
+			return nil, nil, err
+		}
+	}
+	return s, tcpServer, nil
+}
+
+// assemble creates the components, the handlers and the QUIC listener
+func assemble(cfg *config.Config, logger logging.Logger) (*Server, error) {
+	tlsConfig, err := quiclib.NewTLSConfig(cfg.QUICTLS(), "h3")
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure TLS: %w", err)
+	}
+
+	s := &Server{cfg: cfg, logger: logger}
+	s.reg = metrics.NewRegistry()
+	s.conns = admin.NewConnTracker(s.reg)
+	cc, err := congestion.ResolveQUIC(cfg.Server.QUIC.Congestion)
+	if err != nil {
+		logger.Warn("Congestion controller unavailable", logging.Err(err))
+	}
+	s.conns.SetCongestion("quic", cc)
+	s.health = health.NewRegistry()
+	s.quotas = quota.NewManager(cfg.Quotas, s.reg)
+	s.guard = limits.NewGuard(cfg.Limits, s.reg)
+	s.impairments = impair.NewRegistry(logger.Named("impair"))
+
+	if err := s.newIoT(); err != nil {
+		return nil, err
+	}
+	if err := s.newStreaming(); err != nil {
+		return nil, err
+	}
+
+	// Set up HTTP handlers
+	mux := http.NewServeMux()
+
+	// IoT endpoints
+	s.iot = iot.NewHandler(cfg.IoT, logger.Named("iot"), s.reg, s.health, s.quotas, iot.WithUploads(s.uploads), iot.WithMigrations(s.migrations), iot.WithAggregator(s.aggregates), iot.WithTwins(s.twins), iot.WithFirmware(s.firmware), iot.WithPresence(s.presence), iot.WithGapTracker(s.gaps), iot.WithDevices(s.devices), iot.WithDeviceHealth(s.deviceHealth), iot.WithSubscriptions(s.subscriptions), iot.WithClockSkew(s.clockSkew), iot.WithSessions(s.sessions), iot.WithOutbox(s.outbox), iot.WithAnomalies(s.anomalies), iot.WithPublisher(s.publisher), iot.WithSinks(s.sinks), iot.WithRateLimiter(s.limiter))
+	mux.Handle("/iot/", s.iot)
+
+	// Video streaming endpoints, with the RTT and loss of the QUIC
+	// connections of their viewers
+	paths := quiclib.NewPaths()
+	s.streams = streaming.NewHandler(logger.Named("streaming"), s.reg, s.quotas, streaming.WithImpairments(s.impairments), streaming.WithContent(s.content), streaming.WithTimelines(s.timelines),
+		streaming.WithSeededStreams(cfg.Streaming.SeedStreams, cfg.Streaming.SeedDurations, cfg.Streaming.SeedLadder), streaming.WithDrainPeer(cfg.Streaming.DrainPeer),
+		streaming.WithLadder(cfg.Streaming.Ladder, cfg.Streaming.ChunkDuration),
+		streaming.WithAdapter(streaming.NewAdapter(cfg.Streaming.ABR, clock.Real(), s.reg)),
+		streaming.WithResumption(streaming.NewResumption(cfg.Streaming.ResumeGrace, cfg.Streaming.ResumeSessions, s.reg)),
+		streaming.WithPacer(streaming.NewPacer(cfg.Streaming.Pacing, clock.Real(), s.reg)),
+		streaming.WithBroadcast(streaming.NewBroadcast(cfg.Streaming.Broadcast, logger.Named("streaming"), clock.Real(), s.reg)),
+		streaming.WithIngest(streaming.NewIngest(cfg.Streaming.Ingest, cfg.Streaming.ChunkDuration, logger.Named("streaming"), clock.Real(), s.reg)),
+		streaming.WithHLS(streaming.NewHLS(cfg.Streaming.HLS, cfg.Streaming.ChunkDuration, clock.Real(), s.reg)),
+		streaming.WithAdmission(streaming.NewAdmission(cfg.Streaming.Admission, clock.Real(), s.reg)),
+		streaming.WithCatalog(s.catalog),
+		streaming.WithPaths(paths))
+	mux.Handle("/stream/", s.streams)
+
+	// Health check
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "QUIC server is running")
+	})
+	mux.Handle("/healthz", s.health.Handler())
+	mux.Handle("/ping", health.PingHandler())
+	mux.Handle("/benchmark/", benchmark.EchoHandler("QUIC", "HTTP/3"))
+
+	// Create HTTP/3 server
+	handler := s.guard.Wrap("quic", mux)
+	newServer := func(addr string) *http3.Server {
+		return &http3.Server{
+			Addr:       addr,
+			TLSConfig:  tlsConfig,
+			QUICConfig: paths.Trace(quiclib.TransportConfig(cfg.QUIC, func(d time.Duration) { s.conns.AddFlowBlocked("quic", d) })),
+			Handler:    handler,
+			// QUIC has no equivalent of ReadHeaderTimeout: headers arrive
+			// on a stream of an established connection
+			MaxHeaderBytes: cfg.Limits.MaxHeaderBytes,
+			IdleTimeout:    cfg.Limits.IdleTimeout,
+			// Unreliable sensor readings, see iot.DatagramPath
+			EnableDatagrams: true,
+			ConnContext: func(ctx context.Context, c *quic.Conn) context.Context {
+				s.conns.Open("quic")
+				go func() {
+					<-c.Context().Done()
+					s.conns.Close("quic")
+				}()
+				return iot.WithPeer(ctx, c.Context().Done())
+			},
+		}
+	}
+	s.listener = newQUICListener(cfg.Server.Addr, newServer, logger.Named("quic"))
+	return s, nil
+}
+
+// newIoT creates the components of the IoT handler
+func (s *Server) newIoT() error {
+	cfg, logger, reg := s.cfg, s.logger, s.reg
+
+	s.migrations = iot.NewMigrations(logger.Named("migrate"), clock.Real())
+	s.aggregates = iot.NewAggregator(cfg.IoT.Aggregation, clock.Real(), reg)
+	s.twins = iot.NewTwins(logger.Named("twins"), clock.Real())
+	s.firmware = iot.NewFirmwareUpdates(cfg.IoT.Firmware, logger.Named("firmware"), clock.Real(), reg)
+	s.alerts = iot.NewAlerts(logger.Named("alerts"), reg)
+	s.deviceHealth = iot.NewDeviceHealthTracker(cfg.IoT.DeviceHealth, cfg.IoT.ForgetAfter, s.alerts, clock.Real(), reg)
+	s.presence = iot.NewPresence(cfg.IoT.Heartbeat, s.deviceHealth, logger.Named("presence"), clock.Real(), reg)
+	s.gaps = iot.NewGapTracker(cfg.IoT.ForgetAfter, clock.Real(), reg)
+	s.devices = iot.NewDeviceRegistry(logger.Named("devices"), clock.Real(), reg)
+	s.subscriptions = iot.NewSubscriptions(cfg.IoT.Subscriptions, s.devices, logger.Named("subscriptions"), clock.Real(), reg)
+	s.clockSkew = iot.NewClockSkew(cfg.IoT.ClockSkew, cfg.IoT.ForgetAfter, s.alerts, clock.Real(), reg)
+	s.anomalies = iot.NewAnomalies(cfg.IoT.Anomaly, iot.NewZScoreDetector(cfg.IoT.Anomaly), s.alerts, clock.Real(), reg)
+	s.sessions = iot.NewSessions(logger.Named("sessions"), clock.Real(), reg)
+
+	// Commands wait in the outbox until their device fetches them
+	s.outbox = iot.NewOutbox(cfg.IoT.Commands, logger.Named("outbox"), s.devices, clock.Real(), reg)
+	// The reconciler's resends count against the message rates of devices
+	s.limiter = iot.NewRateLimiter(cfg.IoT, clock.Real())
+	s.reconciler = iot.NewReconciler(cfg.IoT.Reconcile, s.twins, s.outbox, s.limiter, s.alerts, logger.Named("reconcile"), clock.Real(), reg)
+
+	// Forward readings to an MQTT broker and take device commands from it
+	if cfg.Bridge.MQTT.Broker != "" {
+		bridge := mqttbridge.New(cfg.Bridge.MQTT, s.outbox, logger.Named("mqtt"), reg)
+		s.bridge = bridge.Subsystem()
+		s.publisher = bridge
+	}
+
+	// Write accepted readings to the configured sinks
+	if sc := cfg.IoT.Sinks; sc.File.Path != "" || sc.NATS.URL != "" {
+		s.sinks = iot.NewDispatcher(logger.Named("sinks"), reg, sc.PublishTimeout)
+		if sc.File.Path != "" {
+			file, err := sink.NewFile(sc.File.Path)
+			if err != nil {
+				return fmt.Errorf("failed to create file sink: %w", err)
+			}
+			s.sinks.Add("file", file, sc.QueueSize)
+		}
+		if sc.NATS.URL != "" {
+			natsSink, err := sink.NewNATS(sc.NATS, logger.Named("nats"))
+			if err != nil {
+				return fmt.Errorf("failed to create NATS sink: %w", err)
+			}
+			s.sinks.Add("nats", natsSink, sc.QueueSize)
+		}
+	}
+
+	if cfg.IoT.Uploads.Dir != "" {
+		uploads, err := iot.NewUploadStore(cfg.IoT.Uploads, clock.Real())
+		if err != nil {
+			return fmt.Errorf("failed to create upload store: %w", err)
+		}
+		s.uploads = uploads
+	}
+	return nil
+}
+
+// newStreaming creates the components of the streaming handler
+func (s *Server) newStreaming() error {
+	cfg := s.cfg.Streaming
+	if cfg.TimelineChunks > 0 {
+		s.timelines = streaming.NewTimelines(cfg.TimelineChunks, cfg.TimelineSessions)
+	}
+
+	var err error
+	if cfg.ContentDir != "" {
+		s.content, err = streaming.LoadContent(cfg.ContentDir, cfg.ChunkDuration)
+		if err != nil {
+			return fmt.Errorf("failed to load stream content: %w", err)
+		}
+	}
+	s.catalog, err = streaming.NewCatalog(cfg.CatalogFile, cfg.Ladder, cfg.ChunkDuration, s.logger.Named("streaming"), clock.Real())
+	if err != nil {
+		return fmt.Errorf("failed to load stream catalog: %w", err)
+	}
+	return nil
+}
+
+// newAdmin creates the admin API on admin.addr, with the controls of the
+// listener serving protocol on addr
+func (s *Server) newAdmin(protocol, addr string, listener admin.Subsystem, restart admin.RestartFunc) error {
+	cfg := s.cfg
+	a := admin.NewServer(cfg.Admin.Addr, s.logger.Named("admin"))
+	if cfg.Admin.TLS != nil {
+		adminTLS, err := quiclib.NewTLSConfig(cfg.AdminTLS(), "h2", "http/1.1")
+		if err != nil {
+			return fmt.Errorf("failed to configure admin TLS: %w", err)
+		}
+		a.UseTLS(adminTLS)
+	}
+	a.Limit(s.guard)
+	a.EnableLogging(cfg.Admin.Token, s.logger)
+	a.EnableMetrics(s.reg)
+	a.EnableHealth(s.health)
+	a.Handle("/api/quotas", admin.QuotaUsageHandler(s.quotas))
+	a.EnableImpairments(cfg.Admin.Token, s.impairments)
+	if s.uploads != nil {
+		a.EnableUploads(cfg.Admin.Token, s.uploads)
+	}
+	a.EnableMigrations(cfg.Admin.Token, s.migrations)
+	a.EnableTwins(cfg.Admin.Token, s.twins)
+	if s.reconciler != nil {
+		a.Handle("/api/shadows/diverged", admin.DivergedTwinsHandler(s.reconciler))
+	}
+	a.EnableFirmware(cfg.Admin.Token, s.firmware)
+	a.Handle("/api/presence", admin.PresenceHandler(s.presence))
+	a.Handle("/api/devices", admin.DevicesHandler(s.devices))
+	a.Handle("/api/device-health", admin.DeviceHealthHandler(s.deviceHealth))
+	a.Handle("/api/device-health/", admin.DeviceHealthHandler(s.deviceHealth))
+	a.Handle("/api/alerts", admin.AlertsHandler(s.alerts))
+	a.Handle("/api/subscriptions", admin.SubscriptionsHandler(s.subscriptions))
+	a.Handle("/api/clock-skew", admin.ClockSkewHandler(s.clockSkew))
+	a.Handle("/api/clock-skew/", admin.ClockSkewHandler(s.clockSkew))
+	a.EnableCommands(cfg.Admin.Token, s.outbox)
+	a.Handle("/api/device-sessions", admin.DeviceSessionsHandler(s.sessions))
+	a.Handle("/api/iot/stats", admin.IoTStatsHandler(s.iot.Stats))
+	a.Handle("/api/sequences", admin.SequencesHandler(s.gaps))
+	a.Handle("/api/sequences/", admin.SequencesHandler(s.gaps))
+	if s.aggregates != nil {
+		a.Handle("/api/aggregates", admin.AggregatesHandler(s.aggregates))
+	}
+	a.EnableStreams(cfg.Admin.Token, s.catalog, s.content)
+	if s.timelines != nil {
+		a.Handle("/api/sessions", admin.SessionTimelinesHandler(s.timelines))
+		a.Handle("/api/sessions/", admin.SessionTimelinesHandler(s.timelines))
+	}
+	// The listener publishes readings through the bridge, so it comes
+	// after it
+	var subsystems []admin.Subsystem
+	if s.bridge != nil {
+		subsystems = append(subsystems, s.bridge)
+	}
+	a.EnableSubsystems(cfg.Admin.Token, append(subsystems, listener)...)
+	if cfg.Admin.Debug {
+		a.EnableDebug(cfg.Admin.Token, s.conns)
+		a.EnableRestart(cfg.Admin.Token, restart)
+		a.EnableBenchmark(cfg.Admin.Token, protocol, addr)
+	}
+	s.admin = a
+	return nil
+}
+
+// Registry returns the metrics of every component
+func (s *Server) Registry() *metrics.Registry {
+	return s.reg
+}
+
+// Health returns the health of the background loops
+func (s *Server) Health() *health.Registry {
+	return s.health
+}
+
+// Admin returns the admin API, or nil without admin.addr. It is served with
+// its Start or Serve.
+func (s *Server) Admin() *admin.Server {
+	return s.admin
+}
+
+// TCPDeps returns the components and handlers a TCP/TLS server shares with
+// this server, so that both serve the same devices and streams
+func (s *Server) TCPDeps() tcp.Deps {
+	return tcp.Deps{
+		Conns:   s.conns,
+		Health:  s.health,
+		Guard:   s.guard,
+		IoT:     s.iot,
+		Streams: s.streams,
+	}
+}
+
+// RunBackground starts the background loops of the components, which run
+// until ctx is done
+func (s *Server) RunBackground(ctx context.Context) {
+	// Log loops that stop making progress
+	go s.health.Monitor(ctx, 5*time.Second, s.logger.Named("health"))
+	go s.presence.Monitor(ctx)
+	if s.reconciler != nil {
+		go s.reconciler.Run(ctx)
+	}
+	if s.cfg.IoT.StatsInterval > 0 {
+		go s.iot.LogStats(ctx, s.cfg.IoT.StatsInterval)
+	}
+	if s.anomalies != nil {
+		go s.anomalies.Run(ctx)
+	}
+	if s.bridge != nil {
+		s.bridge.Start(ctx)
+	}
+	if s.aggregates != nil {
+		go s.aggregates.Run(ctx)
+		go s.aggregates.LogClosed(s.logger.Named("aggregates"))
+	}
+}
+
+// Start listens on server.addr and serves until the server is shut down
+func (s *Server) Start() error {
+	return s.listener.Start()
+}
+
+// Serve serves on conn, e.g. a socket on an ephemeral port, until the server
+// is shut down. Restarts listen again on the address of conn.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.listener.Serve(conn)
+}
+
+// Shutdown ends live sessions with an end-of-stream event, waiting at most
+// streaming.drain_grace for them, closes the listener and stops the
+// components that hold connections or buffered readings. Errors are logged.
+func (s *Server) Shutdown(ctx context.Context) {
+	drainCtx, cancelDrain := context.WithTimeout(ctx, s.cfg.Streaming.DrainGrace)
+	if err := s.streams.Drain(drainCtx); err != nil {
+		s.logger.Warn("Live sessions still open after drain grace", logging.F("grace", s.cfg.Streaming.DrainGrace))
+	}
+	cancelDrain()
+
+	if err := s.listener.Close(); err != nil {
+		s.logger.Error("Server shutdown error", logging.Err(err))
+	}
+	if s.bridge != nil && s.bridge.Status().Running {
+		if err := s.bridge.Stop(ctx); err != nil {
+			s.logger.Error("MQTT bridge shutdown error", logging.Err(err))
+		}
+	}
+	if s.aggregates != nil {
+		s.aggregates.Close()
+	}
+	if s.sinks != nil {
+		if err := s.sinks.Close(ctx); err != nil {
+			s.logger.Error("Sink shutdown error", logging.Err(err))
+		}
+	}
+	if s.admin != nil {
+		if err := s.admin.Stop(); err != nil {
+			s.logger.Error("Admin server shutdown error", logging.Err(err))
+		}
+	}
+}
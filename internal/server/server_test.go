@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/tcp"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestServeAndShutdown(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Addr = ""
+	srv, err := New(cfg, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Admin() != nil {
+		t.Error("admin API created without admin.addr")
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(conn) }()
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	for _, path := range []string{"/ping", "/healthz", "/stream/list", "/iot/devices"} {
+		resp, err := client.Get("https://" + conn.LocalAddr().String() + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: status %d, want 200", path, resp.StatusCode)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve still running after shutdown")
+	}
+}
+
+// TestNewTCPAdminControlsTCPListener checks that the admin API of a server
+// serving TCP/TLS only lists and restarts its TCP listener, not the QUIC one
+func TestNewTCPAdminControlsTCPListener(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Addr = "127.0.0.1:0"
+	cfg.Server.TCP.Addr = "127.0.0.1:0"
+	srv, tcpServer, err := NewTCP(cfg, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpServer.Stop()
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Admin().Serve(ln)
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/api/subsystems")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"name":"tcp"`) || strings.Contains(string(body), `"name":"quic"`) {
+		t.Errorf("subsystems: %s, want only the TCP listener", body)
+	}
+}
+
+// waitFor polls cond for up to five seconds
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+// TestQUICDeviceUnaffectedByTCPRestart keeps a device sending readings over
+// QUIC while the TCP/TLS listener sharing the server's components is taken
+// down and brought up again from the admin API
+func TestQUICDeviceUnaffectedByTCPRestart(t *testing.T) {
+	cfg := config.Default()
+	cfg.Admin.Addr = ""
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Server.TCP.Addr = ln.Addr().String()
+	ln.Close()
+
+	srv, err := New(cfg, logging.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go srv.Serve(conn)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+
+	tcpServer := tcp.NewServer(cfg, nil, logging.Nop(), srv.TCPDeps())
+	go tcpServer.Start()
+	t.Cleanup(func() { tcpServer.Stop() })
+	tcpClient := &http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	tcpUp := func() bool {
+		resp, err := tcpClient.Get("http://" + cfg.Server.TCP.Addr + "/ping")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+	if !waitFor(tcpUp) {
+		t.Fatal("TCP/TLS listener not up")
+	}
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	quicClient := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	var sent, failed atomic.Int64
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			body := fmt.Sprintf(`{"device_id": "dev1", "sensor_type": "temperature", "value": %d, "unit": "celsius"}`, 20+i%5)
+			resp, err := quicClient.Post("https://"+conn.LocalAddr().String()+"/iot/sensor", "application/json", strings.NewReader(body))
+			if err != nil || resp.StatusCode != http.StatusOK {
+				failed.Add(1)
+			} else {
+				sent.Add(1)
+			}
+			if err == nil {
+				resp.Body.Close()
+			}
+		}
+	}()
+	// progress waits until the device has sent a few more readings
+	progress := func() bool {
+		from := sent.Load()
+		return waitFor(func() bool { return sent.Load() >= from+5 })
+	}
+
+	sub := tcpServer.Subsystem()
+	if !progress() {
+		t.Fatal("device not sending over QUIC")
+	}
+	if err := sub.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if tcpUp() {
+		t.Fatal("TCP/TLS listener still answers after being stopped")
+	}
+	if !progress() {
+		t.Error("device stopped sending over QUIC while the TCP/TLS listener was down")
+	}
+	if err := sub.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(tcpUp) {
+		t.Fatal("TCP/TLS listener not back up")
+	}
+	if !progress() {
+		t.Error("device stopped sending over QUIC after the TCP/TLS listener came back")
+	}
+	close(stop)
+	<-done
+
+	if failed.Load() != 0 {
+		t.Errorf("%d of the device's readings failed", failed.Load())
+	}
+	if accepted := srv.iot.Stats().Readings.Accepted; accepted != sent.Load() {
+		t.Errorf("QUIC server accepted %d readings, device sent %d", accepted, sent.Load())
+	}
+}
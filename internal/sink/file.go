@@ -0,0 +1,57 @@
+// Package sink holds the iot.Sink implementations that accepted sensor
+// readings can be written to.
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+// File appends readings to a file, one JSON object per line
+type File struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewFile opens path for appending, creating it if needed
+func NewFile(path string) (*File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open sink file: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	return &File{f: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Publish appends a reading. Each line is written out before Publish
+// returns, so a crash loses at most the reading being written.
+func (s *File) Publish(ctx context.Context, data iot.SensorData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.enc.Encode(data); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+// Close flushes and closes the file
+func (s *File) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.w.Flush(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
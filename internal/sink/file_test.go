@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+)
+
+func TestFileAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "readings.jsonl")
+	for _, batch := range [][]float64{{1, 2}, {3}} {
+		s, err := NewFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range batch {
+			if err := s.Publish(context.Background(), iot.SensorData{DeviceID: "dev1", SensorType: "temperature", Value: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var values []float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var data iot.SensorData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		values = append(values, data.Value)
+	}
+	if len(values) != 3 || values[0] != 1 || values[2] != 3 {
+		t.Errorf("file holds %v, want the readings of both opens in order", values)
+	}
+}
+
+func TestFileRefusesAfterCancel(t *testing.T) {
+	s, err := NewFile(filepath.Join(t.TempDir(), "readings.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Publish(ctx, iot.SensorData{DeviceID: "dev1"}); err == nil {
+		t.Error("reading written after its context was cancelled")
+	}
+}
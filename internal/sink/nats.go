@@ -0,0 +1,80 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// subjectEscaper keeps device IDs and sensor types within one subject token
+var subjectEscaper = strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_", "\t", "_")
+
+// NATS publishes readings to a NATS server as JSON. The server need not be
+// up when the sink is created: the client keeps reconnecting, and buffers
+// readings meanwhile until its reconnect buffer is full.
+type NATS struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewNATS connects to the NATS server of cfg
+func NewNATS(cfg config.NATSSinkConfig, logger logging.Logger) (*NATS, error) {
+	opts := []nats.Option{
+		nats.Name("commsys"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				logger.Warn("Disconnected from NATS", logging.Err(err))
+			}
+		}),
+		nats.ReconnectHandler(func(c *nats.Conn) {
+			logger.Info("Reconnected to NATS", logging.F("url", c.ConnectedUrl()))
+		}),
+	}
+	if cfg.User != "" {
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS: %w", err)
+	}
+	return &NATS{conn: conn, subject: cfg.Subject}, nil
+}
+
+// Publish hands a reading to the NATS client, which sends it
+// asynchronously
+func (s *NATS) Publish(ctx context.Context, data iot.SensorData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	subject := strings.NewReplacer(
+		"{device_id}", subjectEscaper.Replace(data.DeviceID),
+		"{type}", subjectEscaper.Replace(data.SensorType),
+	).Replace(s.subject)
+	return s.conn.Publish(subject, payload)
+}
+
+// Close sends the readings still buffered and closes the connection
+func (s *NATS) Close() error {
+	if s.conn.IsConnected() {
+		if err := s.conn.FlushTimeout(5 * time.Second); err != nil {
+			s.conn.Close()
+			return err
+		}
+	}
+	s.conn.Close()
+	return nil
+}
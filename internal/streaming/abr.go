@@ -0,0 +1,215 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxReportSize bounds the body of a client report
+const maxReportSize = 4096
+
+// ClientReport is what a player measured over its last reporting period.
+// Players send it to POST /stream/report/{stream_id}, in the same session
+// as their chunk requests.
+type ClientReport struct {
+	Quality       string  `json:"quality"`        // rendition being played
+	BufferSeconds float64 `json:"buffer_seconds"` // media buffered ahead of the playhead
+	BitrateKbps   float64 `json:"bitrate_kbps"`   // download rate of the chunks fetched, 0 if none
+	DroppedFrames int     `json:"dropped_frames"` // frames skipped, e.g. for chunks that never arrived
+	RTTMillis     float64 `json:"rtt_ms"`
+	CorruptChunks int     `json:"corrupt_chunks"` // that failed their CRCHeader check
+}
+
+// QualityChange answers a report with the rendition the player should
+// request from now on
+type QualityChange struct {
+	Changed bool    `json:"changed"`
+	Quality Bitrate `json:"quality"`
+	Reason  string  `json:"reason,omitempty"` // why the quality changed
+}
+
+// Adapter picks the quality of each streaming session from the reports of
+// its player. A session steps down one rung after a run of poor reports, a
+// buffer below the low mark, dropped frames or a download rate below the
+// current rendition, and up one rung after a longer run of good ones, a full
+// buffer and enough bandwidth for the next rendition. Reports in between
+// break either run, and a session keeps each quality, the first one too,
+// for at least the minimum dwell, so borderline conditions don't make it
+// flip back and forth.
+type Adapter struct {
+	cfg   config.ABRConfig
+	clock clock.Clock
+
+	mu       sync.Mutex
+	sessions map[string]*abrSession
+
+	reports prometheus.Counter
+	changes *metrics.CounterVec
+}
+
+// abrSession is the adaptation state of one session
+type abrSession struct {
+	changed time.Time // last quality change, or the first report
+	poor    int       // consecutive poor reports
+	good    int       // consecutive good reports
+	updated time.Time // last report
+}
+
+// NewAdapter creates an adapter with the thresholds of cfg
+func NewAdapter(cfg config.ABRConfig, c clock.Clock, reg *metrics.Registry) *Adapter {
+	return &Adapter{
+		cfg:      cfg,
+		clock:    c,
+		sessions: make(map[string]*abrSession),
+		reports:  reg.Counter("streaming", "client_reports_total", "Playback reports received from players"),
+		changes:  reg.CounterVec("streaming", "quality_changes_total", "Quality changes recommended to players by direction", "direction"),
+	}
+}
+
+// WithAdapter recommends qualities to players that report on their playback
+// at /stream/report
+func WithAdapter(a *Adapter) Option {
+	return func(h *Handler) {
+		h.abr = a
+	}
+}
+
+// Report records report from session, playing a stream with the renditions
+// of ladder, lowest bitrate first, and returns the quality to play next.
+// report.Quality must be one of ladder.
+func (a *Adapter) Report(session string, ladder []Bitrate, report ClientReport) QualityChange {
+	now := a.clock.Now()
+	a.reports.Inc()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[session]
+	if !ok {
+		if len(a.sessions) >= a.cfg.Sessions {
+			a.evictLocked()
+		}
+		// The first quality dwells too, while the player fills its buffer
+		s = &abrSession{changed: now}
+		a.sessions[session] = s
+	}
+	s.updated = now
+	// The player knows what it plays, e.g. after picking a quality itself
+	i := slices.IndexFunc(ladder, func(b Bitrate) bool { return b.Quality == report.Quality })
+	current := ladder[i]
+
+	poor := report.BufferSeconds < a.cfg.LowBuffer.Seconds() || report.DroppedFrames > 0 ||
+		(report.BitrateKbps > 0 && report.BitrateKbps < float64(current.Bitrate))
+	good := !poor && report.BufferSeconds >= a.cfg.HighBuffer.Seconds() && i+1 < len(ladder) &&
+		report.BitrateKbps >= float64(ladder[i+1].Bitrate)*a.cfg.UpMargin
+	switch {
+	case poor:
+		s.poor, s.good = s.poor+1, 0
+	case good:
+		s.poor, s.good = 0, s.good+1
+	default:
+		s.poor, s.good = 0, 0
+	}
+
+	if now.Sub(s.changed) < a.cfg.MinDwell {
+		return QualityChange{Quality: current}
+	}
+	next, direction := i, ""
+	switch {
+	case s.poor >= a.cfg.DownReports && i > 0:
+		next, direction = i-1, "down"
+	case s.good >= a.cfg.UpReports:
+		next, direction = i+1, "up"
+	default:
+		return QualityChange{Quality: current}
+	}
+	s.changed, s.poor, s.good = now, 0, 0
+	a.changes.WithLabelValues(direction).Inc()
+	return QualityChange{
+		Changed: true,
+		Quality: ladder[next],
+		Reason: fmt.Sprintf("%s after %.1fs buffered, %.0f kbps measured, %d frames dropped", direction,
+			report.BufferSeconds, report.BitrateKbps, report.DroppedFrames),
+	}
+}
+
+// evictLocked drops the session reported least recently. a.mu must be held.
+func (a *Adapter) evictLocked() {
+	var oldest string
+	var at time.Time
+	for id, s := range a.sessions {
+		if oldest == "" || s.updated.Before(at) {
+			oldest, at = id, s.updated
+		}
+	}
+	delete(a.sessions, oldest)
+}
+
+// ladder returns the available renditions of streamID, lowest bitrate
+// first, or false for a stream that isn't in the catalog
+func (h *Handler) ladder(streamID string) ([]Bitrate, bool) {
+	for _, stream := range h.listed() {
+		if stream.StreamID != streamID {
+			continue
+		}
+		var ladder []Bitrate
+		for _, b := range stream.Bitrates {
+			if b.Available {
+				ladder = append(ladder, b)
+			}
+		}
+		slices.SortFunc(ladder, func(x, y Bitrate) int { return x.Bitrate - y.Bitrate })
+		return ladder, true
+	}
+	return nil, false
+}
+
+func (h *Handler) handleReport(w http.ResponseWriter, r *http.Request, streamID string) {
+	if h.abr == nil {
+		http.Error(w, "Bitrate adaptation is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var report ClientReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxReportSize)).Decode(&report); err != nil {
+		http.Error(w, "Invalid report", http.StatusBadRequest)
+		return
+	}
+	ladder, ok := h.ladder(streamID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Stream %s not found", streamID), http.StatusNotFound)
+		return
+	}
+	i := slices.IndexFunc(ladder, func(b Bitrate) bool { return b.Quality == report.Quality })
+	if i < 0 {
+		http.Error(w, fmt.Sprintf("Quality %q is not available for stream %s", report.Quality, streamID), http.StatusBadRequest)
+		return
+	}
+
+	session := quota.SessionKey(r)
+	h.meters.reported(streamID, session, report, h.clock.Now())
+	// A quality the viewer picked holds until it hands it back
+	change := QualityChange{Quality: ladder[i]}
+	if !h.switches.pinned(streamID, session) {
+		change = h.abr.Report(session, ladder, report)
+	}
+	if change.Changed {
+		h.logger.Info("Quality changed", logging.F("session", session), logging.F("stream_id", streamID),
+			logging.F("from", report.Quality), logging.F("to", change.Quality.Quality), logging.F("reason", change.Reason))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(change)
+}
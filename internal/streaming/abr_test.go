@@ -0,0 +1,202 @@
+package streaming
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// reportEvery is how often the players of these tests report
+const reportEvery = 2 * time.Second
+
+var testLadder = []Bitrate{
+	{Quality: "low", Bitrate: 500, Available: true},
+	{Quality: "medium", Bitrate: 1500, Available: true},
+	{Quality: "high", Bitrate: 3000, Available: true},
+	{Quality: "ultra", Bitrate: 6000, Available: true},
+}
+
+// player plays at the quality the adapter recommends, and reports every
+// reportEvery
+type player struct {
+	t       *testing.T
+	adapter *Adapter
+	clock   *clock.Fake
+	quality string
+	changes []time.Time // when the quality changed
+}
+
+func newPlayer(t *testing.T, cfg config.ABRConfig, quality string) *player {
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	return &player{t: t, adapter: NewAdapter(cfg, fake, metrics.NewRegistry()), clock: fake, quality: quality}
+}
+
+// report sends report at the current quality and follows the answer
+func (p *player) report(report ClientReport) {
+	p.t.Helper()
+	report.Quality = p.quality
+	change := p.adapter.Report("session1", testLadder, report)
+	if change.Changed {
+		p.quality = change.Quality.Quality
+		p.changes = append(p.changes, p.clock.Now())
+	} else if change.Quality.Quality != report.Quality {
+		p.t.Fatalf("unchanged answer with quality %s to a report at %s", change.Quality.Quality, report.Quality)
+	}
+	p.clock.Advance(reportEvery)
+}
+
+var (
+	starving = ClientReport{BufferSeconds: 1, BitrateKbps: 1000}
+	healthy  = ClientReport{BufferSeconds: 15, BitrateKbps: 10000}
+)
+
+func TestAdapterDowngradesOnSustainedLowBuffer(t *testing.T) {
+	cfg := config.Default().Streaming.ABR
+	p := newPlayer(t, cfg, "high")
+
+	// Nothing changes while the first quality dwells
+	for elapsed := time.Duration(0); elapsed < cfg.MinDwell; elapsed += reportEvery {
+		p.report(starving)
+	}
+	if p.quality != "high" {
+		t.Fatalf("quality %s within the first %v", p.quality, cfg.MinDwell)
+	}
+	p.report(starving)
+	if p.quality != "medium" {
+		t.Fatalf("quality %s after a sustained low buffer, want one step down to medium", p.quality)
+	}
+
+	// and then one step per dwell, down to the lowest
+	for i := 0; i < 30; i++ {
+		p.report(starving)
+	}
+	if p.quality != "low" || len(p.changes) != 2 {
+		t.Errorf("quality %s after %d changes, want low after 2", p.quality, len(p.changes))
+	}
+}
+
+func TestAdapterSinglePoorReportDoesNotDowngrade(t *testing.T) {
+	cfg := config.Default().Streaming.ABR
+	p := newPlayer(t, cfg, "high")
+	steady := ClientReport{BufferSeconds: 8, BitrateKbps: 3500}
+	for i := 0; i < 30; i++ {
+		if i%5 == 0 {
+			p.report(starving)
+		} else {
+			p.report(steady)
+		}
+	}
+	if len(p.changes) != 0 {
+		t.Errorf("quality changed to %s on isolated poor reports", p.quality)
+	}
+}
+
+func TestAdapterUpgradesOnlyAfterStableGoodReports(t *testing.T) {
+	cfg := config.Default().Streaming.ABR
+	p := newPlayer(t, cfg, "medium")
+	for elapsed := time.Duration(0); elapsed <= cfg.MinDwell; elapsed += reportEvery {
+		p.report(ClientReport{BufferSeconds: 8, BitrateKbps: 5000})
+	}
+
+	// A run of good reports broken before it is long enough
+	for i := 0; i < 10; i++ {
+		for j := 1; j < cfg.UpReports; j++ {
+			p.report(healthy)
+		}
+		p.report(ClientReport{BufferSeconds: 8, BitrateKbps: 5000})
+	}
+	if p.quality != "medium" {
+		t.Fatalf("quality %s after good runs shorter than %d reports", p.quality, cfg.UpReports)
+	}
+
+	// Bandwidth for the next rung without the margin isn't good enough
+	for i := 0; i < 10; i++ {
+		p.report(ClientReport{BufferSeconds: 15, BitrateKbps: 3100})
+	}
+	if p.quality != "medium" {
+		t.Fatalf("quality %s with %.0f kbps for a %d kbps rung", p.quality, 3100.0, testLadder[2].Bitrate)
+	}
+
+	for i := 0; i < cfg.UpReports; i++ {
+		p.report(healthy)
+	}
+	if p.quality != "high" {
+		t.Errorf("quality %s after %d good reports, want high", p.quality, cfg.UpReports)
+	}
+}
+
+func TestAdapterDoesNotOscillate(t *testing.T) {
+	cfg := config.Default().Streaming.ABR
+	p := newPlayer(t, cfg, "high")
+
+	// Borderline reports either side of the low buffer mark
+	for i := 0; i < 100; i++ {
+		buffer := cfg.LowBuffer.Seconds() + 0.1
+		if i%2 == 0 {
+			buffer = cfg.LowBuffer.Seconds() - 0.1
+		}
+		p.report(ClientReport{BufferSeconds: buffer, BitrateKbps: 3500})
+	}
+	if len(p.changes) != 0 {
+		t.Fatalf("%d quality changes on borderline reports", len(p.changes))
+	}
+
+	// Conditions that flip in bursts change the quality at most once a dwell
+	for i := 0; i < 100; i++ {
+		if i/4%2 == 0 {
+			p.report(starving)
+		} else {
+			p.report(healthy)
+		}
+	}
+	if len(p.changes) < 2 {
+		t.Fatalf("%d quality changes on bursts of poor and good reports, want it to follow them", len(p.changes))
+	}
+	for i := 1; i < len(p.changes); i++ {
+		if gap := p.changes[i].Sub(p.changes[i-1]); gap < cfg.MinDwell {
+			t.Errorf("quality changed %v after the change before, within the %v dwell", gap, cfg.MinDwell)
+		}
+	}
+}
+
+func TestReportEndpoint(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(fake),
+		WithAdapter(NewAdapter(cfg.Streaming.ABR, fake, reg)))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/stream/report/stream_001", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := post(`{"quality": "medium", "buffer_seconds": 6.5, "bitrate_kbps": 2000, "rtt_ms": 40}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var change QualityChange
+	if err := json.NewDecoder(rec.Body).Decode(&change); err != nil {
+		t.Fatal(err)
+	}
+	if change.Changed || change.Quality.Quality != "medium" {
+		t.Errorf("answered %+v to the first report, want medium unchanged", change)
+	}
+
+	if rec := post(`{"quality": "8k"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("report at an unknown quality answered %d", rec.Code)
+	}
+	if rec := post(`{"quality":`); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed report answered %d", rec.Code)
+	}
+}
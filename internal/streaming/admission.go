@@ -0,0 +1,255 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// StatusRejectedCapacity is the status of a viewer refused because the
+// server streams to as many as it can
+const StatusRejectedCapacity = "rejected_capacity"
+
+// Rejection answers, with 503 and a Retry-After header, a chunk, segment or
+// live request that would take the server over its capacity
+type Rejection struct {
+	Status      string      `json:"status"` // StatusRejectedCapacity
+	Error       string      `json:"error"`
+	RetryAfter  int         `json:"retry_after"` // seconds until a slot may free
+	Utilization Utilization `json:"utilization"`
+}
+
+// Utilization is how much of its capacity the server streams with
+type Utilization struct {
+	Viewers  int `json:"viewers"`
+	Weight   int `json:"weight"`   // of the qualities the viewers play
+	Capacity int `json:"capacity"` // max_concurrent_streams
+}
+
+// Admission caps the viewers streamed to at once. A viewer of a stream is
+// admitted by its first chunk or segment request, and frees its slot once it
+// has been sent the last one of the stream, once an ingested stream ends, or
+// once it stops requesting them for the idle time. A live session holds a
+// slot until it ends. Each viewer counts the weight of the quality it plays, and one
+// that switches to a heavier quality is refused its chunks while the
+// difference doesn't fit, so it can keep playing the quality it had.
+type Admission struct {
+	max     int
+	weights map[string]int
+	idle    time.Duration
+	clock   clock.Clock
+
+	mu      sync.Mutex
+	viewers map[admissionKey]*admitted
+	weight  int // of the viewers
+	next    int // ID of the next live session
+
+	inUse    prometheus.Gauge
+	rejected *metrics.CounterVec
+}
+
+// admissionKey identifies a viewer: a session watching a stream, or a live
+// session by its ID
+type admissionKey struct {
+	streamID string
+	session  string
+	live     int
+}
+
+// admitted is the slot of one viewer
+type admitted struct {
+	weight int
+	last   time.Time // of its latest chunk request
+	held   bool      // by a live session, until it ends
+}
+
+// NewAdmission creates the cap of cfg. It returns nil if cfg sets none.
+func NewAdmission(cfg config.AdmissionConfig, c clock.Clock, reg *metrics.Registry) *Admission {
+	if cfg.MaxConcurrentStreams == 0 {
+		return nil
+	}
+	return &Admission{
+		max:      cfg.MaxConcurrentStreams,
+		weights:  cfg.Weights,
+		idle:     cfg.Idle,
+		clock:    c,
+		viewers:  make(map[admissionKey]*admitted),
+		inUse:    reg.Gauge("streaming", "admitted_weight", "Weight of the viewers being streamed to"),
+		rejected: reg.CounterVec("streaming", "admission_rejected_total", "Requests refused for lack of capacity by quality", "quality"),
+	}
+}
+
+// WithAdmission caps the viewers streamed to at once
+func WithAdmission(a *Admission) Option {
+	return func(h *Handler) {
+		h.admission = a
+	}
+}
+
+// weightOf returns the weight of a viewer of quality
+func (a *Admission) weightOf(quality string) int {
+	if w, ok := a.weights[quality]; ok {
+		return w
+	}
+	return 1
+}
+
+// admit lets session request a chunk of streamID at quality, or returns
+// how long until a slot may free. A nil Admission admits every request.
+func (a *Admission) admit(streamID, session, quality string) (bool, time.Duration) {
+	if a == nil {
+		return true, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock.Now()
+	a.sweepLocked(now)
+	key := admissionKey{streamID: streamID, session: session}
+	w := a.weightOf(quality)
+	v := a.viewers[key]
+	held := 0
+	if v != nil {
+		held = v.weight
+	}
+	if w > held && a.weight-held+w > a.max {
+		a.rejected.WithLabelValues(quality).Inc()
+		return false, a.retryLocked(now)
+	}
+	if v == nil {
+		v = &admitted{}
+		a.viewers[key] = v
+	}
+	a.weight += w - v.weight
+	v.weight, v.last = w, now
+	a.inUse.Set(float64(a.weight))
+	return true, 0
+}
+
+// leave frees the slot of session watching streamID, which was sent the
+// stream's last chunk
+func (a *Admission) leave(streamID, session string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.freeLocked(func(key admissionKey) bool {
+		return key.streamID == streamID && key.session == session
+	})
+}
+
+// end frees the slots of the viewers of streamID, which has ended
+func (a *Admission) end(streamID string) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.freeLocked(func(key admissionKey) bool {
+		return key.streamID == streamID
+	})
+}
+
+// freeLocked frees the slots of the chunk viewers whose key matches. a.mu
+// must be held.
+func (a *Admission) freeLocked(match func(admissionKey) bool) {
+	for key, v := range a.viewers {
+		if !v.held && match(key) {
+			a.weight -= v.weight
+			delete(a.viewers, key)
+		}
+	}
+	a.inUse.Set(float64(a.weight))
+}
+
+// hold takes a slot for a live session at quality until release is called,
+// or returns how long until a slot may free. A nil Admission admits every
+// session.
+func (a *Admission) hold(quality string) (func(), bool, time.Duration) {
+	if a == nil {
+		return func() {}, true, 0
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.clock.Now()
+	a.sweepLocked(now)
+	w := a.weightOf(quality)
+	if a.weight+w > a.max {
+		a.rejected.WithLabelValues(quality).Inc()
+		return nil, false, a.retryLocked(now)
+	}
+	a.next++
+	key := admissionKey{live: a.next}
+	a.viewers[key] = &admitted{weight: w, last: now, held: true}
+	a.weight += w
+	a.inUse.Set(float64(a.weight))
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if v := a.viewers[key]; v != nil {
+			a.weight -= v.weight
+			delete(a.viewers, key)
+			a.inUse.Set(float64(a.weight))
+		}
+	}, true, 0
+}
+
+// sweepLocked frees the slots of the viewers idle since before now. a.mu
+// must be held.
+func (a *Admission) sweepLocked(now time.Time) {
+	for key, v := range a.viewers {
+		if !v.held && now.Sub(v.last) >= a.idle {
+			a.weight -= v.weight
+			delete(a.viewers, key)
+		}
+	}
+	a.inUse.Set(float64(a.weight))
+}
+
+// retryLocked returns how long until the first idle viewer would free its
+// slot, or the idle time if only live sessions hold one. a.mu must be held.
+func (a *Admission) retryLocked(now time.Time) time.Duration {
+	retry := a.idle
+	for _, v := range a.viewers {
+		if !v.held {
+			retry = min(retry, v.last.Add(a.idle).Sub(now))
+		}
+	}
+	return retry
+}
+
+// Utilization returns how much of the capacity is in use, or false if a
+// caps nothing
+func (a *Admission) Utilization() (Utilization, bool) {
+	if a == nil {
+		return Utilization{}, false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sweepLocked(a.clock.Now())
+	return Utilization{Viewers: len(a.viewers), Weight: a.weight, Capacity: a.max}, true
+}
+
+// reject answers a request refused for lack of capacity, to retry after
+// retry
+func (a *Admission) reject(w http.ResponseWriter, retry time.Duration) {
+	seconds := max(int((retry+time.Second-1)/time.Second), 1)
+	util, _ := a.Utilization()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(Rejection{
+		Status:      StatusRejectedCapacity,
+		Error:       fmt.Sprintf("Server is at capacity, %d of %d in use", util.Weight, util.Capacity),
+		RetryAfter:  seconds,
+		Utilization: util,
+	})
+}
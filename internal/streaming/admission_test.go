@@ -0,0 +1,182 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+var admissionStart = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// admissionCap is a cap of 3 with high counting 2, freeing idle viewers
+// after 10s
+var admissionCap = config.AdmissionConfig{MaxConcurrentStreams: 3, Weights: map[string]int{"high": 2}, Idle: 10 * time.Second}
+
+func TestAdmissionRejectsOverCapacity(t *testing.T) {
+	c := clock.NewFake(admissionStart)
+	a := NewAdmission(admissionCap, c, metrics.NewRegistry())
+
+	if ok, _ := a.admit("stream_001", "s1", "high"); !ok {
+		t.Fatal("first viewer refused")
+	}
+	c.Advance(3500 * time.Millisecond)
+	if ok, _ := a.admit("stream_001", "s2", "low"); !ok {
+		t.Fatal("viewer within the cap refused")
+	}
+	ok, retry := a.admit("stream_002", "s3", "low")
+	if ok {
+		t.Fatal("viewer over the cap admitted")
+	}
+	// s1 is the first to go idle, 6.5s from now
+	if retry != 6500*time.Millisecond {
+		t.Errorf("retry after %v, want 6.5s", retry)
+	}
+
+	rec := httptest.NewRecorder()
+	a.reject(rec, retry)
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "7" {
+		t.Errorf("rejected with %d, Retry-After %q, want 503 and 7", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	var body Rejection
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	want := Utilization{Viewers: 2, Weight: 3, Capacity: 3}
+	if body.Status != StatusRejectedCapacity || body.RetryAfter != 7 || body.Utilization != want {
+		t.Errorf("rejection %+v, want %s after 7s with %+v", body, StatusRejectedCapacity, want)
+	}
+
+	// Once s1 is idle, its slot goes to the next viewer
+	c.Advance(retry)
+	if ok, _ := a.admit("stream_002", "s3", "low"); !ok {
+		t.Error("viewer refused after the first went idle")
+	}
+	if util, _ := a.Utilization(); util.Viewers != 2 || util.Weight != 2 {
+		t.Errorf("utilization %+v, want s2 and s3 of weight 1", util)
+	}
+}
+
+func TestAdmissionRefusesHeavierQuality(t *testing.T) {
+	c := clock.NewFake(admissionStart)
+	a := NewAdmission(admissionCap, c, metrics.NewRegistry())
+	for _, session := range []string{"s1", "s2", "s3"} {
+		if ok, _ := a.admit("stream_001", session, "low"); !ok {
+			t.Fatalf("%s refused", session)
+		}
+	}
+
+	// low to high takes the weight to 4 of 3
+	if ok, _ := a.admit("stream_001", "s2", "high"); ok {
+		t.Fatal("switch to a quality that doesn't fit admitted")
+	}
+	if ok, _ := a.admit("stream_001", "s2", "low"); !ok {
+		t.Error("viewer refused the quality it had after a refused switch")
+	}
+	if util, _ := a.Utilization(); util.Weight != 3 {
+		t.Errorf("weight %d after a refused switch, want 3", util.Weight)
+	}
+
+	// Once s3 has gone idle, the switch fits
+	c.Advance(5 * time.Second)
+	a.admit("stream_001", "s1", "low")
+	a.admit("stream_001", "s2", "low")
+	c.Advance(5 * time.Second)
+	if ok, _ := a.admit("stream_001", "s2", "high"); !ok {
+		t.Fatal("switch that fits refused")
+	}
+	if util, _ := a.Utilization(); util.Viewers != 2 || util.Weight != 3 {
+		t.Errorf("utilization %+v, want s1 at low and s2 at high", util)
+	}
+	// Switching back frees the difference
+	if ok, _ := a.admit("stream_001", "s2", "low"); !ok {
+		t.Fatal("switch to a lighter quality refused")
+	}
+	if util, _ := a.Utilization(); util.Weight != 2 {
+		t.Errorf("weight %d after switching back, want 2", util.Weight)
+	}
+}
+
+func TestAdmissionReleasesLiveSession(t *testing.T) {
+	c := clock.NewFake(admissionStart)
+	a := NewAdmission(admissionCap, c, metrics.NewRegistry())
+
+	release, ok, _ := a.hold("high")
+	if !ok {
+		t.Fatal("live session refused")
+	}
+	if _, ok, _ := a.hold("high"); ok {
+		t.Fatal("live session over the cap admitted")
+	}
+	// Only idle chunk viewers free their slots, so the retry is the idle time
+	_, _, retry := a.hold("high")
+	if retry != admissionCap.Idle {
+		t.Errorf("retry after %v, want the idle time", retry)
+	}
+	c.Advance(time.Minute)
+	if util, _ := a.Utilization(); util.Viewers != 1 || util.Weight != 2 {
+		t.Fatalf("utilization %+v of a live session idle for a minute, want its slot held", util)
+	}
+
+	release()
+	if util, _ := a.Utilization(); util.Viewers != 0 || util.Weight != 0 {
+		t.Errorf("utilization %+v after the session ended, want none", util)
+	}
+	if _, ok, _ := a.hold("high"); !ok {
+		t.Error("live session refused after the last one ended")
+	}
+	release()
+}
+
+func TestAdmissionFreedWhenStreamEnds(t *testing.T) {
+	c := clock.NewFake(admissionStart)
+	reg := metrics.NewRegistry()
+	cfg := config.Default()
+	a := NewAdmission(config.AdmissionConfig{MaxConcurrentStreams: 1, Idle: 10 * time.Second}, c, reg)
+	// A seeded stream of 3 chunks of 2s
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(c), WithAdmission(a),
+		WithSeededStreams(1, []time.Duration{6 * time.Second}, nil))
+	last := SeededChunks(6*time.Second, h.chunk) - 1
+
+	chunk := func(session string, index int) int {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/stream/chunk/seed_001?quality=low&chunk=%d", index), nil)
+		req.Header.Set("X-Session-ID", session)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if status := chunk("first", 0); status != http.StatusOK {
+		t.Fatalf("first chunk: status %d", status)
+	}
+	if status := chunk("restarted", 0); status != http.StatusServiceUnavailable {
+		t.Fatalf("second viewer: status %d, want 503", status)
+	}
+	if status := chunk("first", last); status != http.StatusOK {
+		t.Fatalf("last chunk: status %d", status)
+	}
+	// The player starts over under a new session without waiting to go idle
+	if status := chunk("restarted", 0); status != http.StatusOK {
+		t.Errorf("viewer after the stream ended: status %d, want 200", status)
+	}
+
+	// The viewers of an ingest free their slots when it ends
+	if ok, _ := a.admit("live1", "viewer", "low"); ok {
+		t.Fatal("viewer over the cap admitted")
+	}
+	a.end("seed_001")
+	if ok, _ := a.admit("live1", "viewer", "low"); !ok {
+		t.Error("viewer refused after the stream of the last one ended")
+	}
+	if util, _ := a.Utilization(); util.Viewers != 1 {
+		t.Errorf("%d viewers, want 1", util.Viewers)
+	}
+}
@@ -0,0 +1,276 @@
+package streaming
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// broadcastSweep is how often viewers and sources are checked for leaving
+const broadcastSweep = time.Second
+
+// Broadcast shares the source of each stream between its viewers. A chunk
+// of a stream is generated or read once, by the first request for it at
+// its quality, and the requests that come for it while it is kept get the
+// same bytes. Each viewer still picks its quality and is paced on its own.
+// A viewer that stops requesting chunks leaves the stream, and a stream
+// without viewers keeps its source for a while, so a viewer that comes
+// back shortly doesn't start it afresh.
+type Broadcast struct {
+	chunks int
+	idle   time.Duration
+	linger time.Duration
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	sources map[string]*source
+	swept   time.Time
+
+	reads   *metrics.CounterVec
+	active  prometheus.Gauge
+	viewers prometheus.Gauge
+}
+
+// source is the shared source of one stream
+type source struct {
+	started  time.Time
+	viewers  map[string]*ViewerStats
+	chunks   map[chunkKey]*sharedChunk
+	order    []chunkKey // of chunks, oldest first
+	empty    time.Time  // when the last viewer left, zero while there are viewers
+	produced int64
+	shared   int64
+}
+
+type chunkKey struct {
+	quality string
+	index   int
+}
+
+// sharedChunk is a chunk produced for every viewer that requests it
+type sharedChunk struct {
+	done chan struct{} // closed once data or err is set
+	data []byte
+	err  error
+}
+
+// ViewerStats is what a viewer of a stream was sent
+type ViewerStats struct {
+	Session    string    `json:"session"`
+	Quality    string    `json:"quality"` // of its last chunk
+	ChunksSent int       `json:"chunks_sent"`
+	BytesSent  int64     `json:"bytes_sent"`
+	Joined     time.Time `json:"joined"`
+	LastChunk  time.Time `json:"last_chunk"` // when it last requested one
+}
+
+// SourceStats describes the shared source of a stream
+type SourceStats struct {
+	Viewers        int       `json:"viewers"`
+	ChunksProduced int64     `json:"chunks_produced"` // generated or read from disk
+	ChunksShared   int64     `json:"chunks_shared"`   // sent without producing them again
+	Started        time.Time `json:"started"`
+}
+
+// NewBroadcast creates the shared sources of streams with the settings of
+// cfg. It returns nil if cfg keeps no chunks.
+func NewBroadcast(cfg config.BroadcastConfig, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Broadcast {
+	if cfg.Chunks == 0 {
+		return nil
+	}
+	return &Broadcast{
+		chunks:  cfg.Chunks,
+		idle:    cfg.ViewerIdle,
+		linger:  cfg.Linger,
+		logger:  logger,
+		clock:   c,
+		sources: make(map[string]*source),
+		reads:   reg.CounterVec("streaming", "source_chunks_total", "Chunks requested from the shared stream sources by whether they were produced or shared", "result"),
+		active:  reg.Gauge("streaming", "broadcast_sources", "Streams with a shared source"),
+		viewers: reg.Gauge("streaming", "broadcast_viewers", "Viewers of the streams with a shared source"),
+	}
+}
+
+// WithBroadcast shares the source of each stream between its viewers
+func WithBroadcast(b *Broadcast) Option {
+	return func(h *Handler) {
+		h.broadcast = b
+	}
+}
+
+// chunk returns the chunk of streamID at quality and index for session,
+// which joins the stream. produce makes the chunk if no other request did.
+// A nil Broadcast calls produce for every request.
+func (b *Broadcast) chunk(ctx context.Context, streamID, session, quality string, index int, produce func() ([]byte, error)) ([]byte, error) {
+	if b == nil {
+		return produce()
+	}
+	key := chunkKey{quality, index}
+	b.mu.Lock()
+	now := b.clock.Now()
+	b.sweepLocked(now)
+	src := b.sources[streamID]
+	if src == nil {
+		src = &source{
+			started: now,
+			viewers: make(map[string]*ViewerStats),
+			chunks:  make(map[chunkKey]*sharedChunk),
+		}
+		b.sources[streamID] = src
+		b.active.Inc()
+		b.logger.Info("Stream source started", logging.F("stream_id", streamID))
+	}
+	v := src.viewers[session]
+	if v == nil {
+		v = &ViewerStats{Session: session, Joined: now}
+		src.viewers[session] = v
+		src.empty = time.Time{}
+		b.viewers.Inc()
+		b.logger.Debug("Viewer joined", logging.F("stream_id", streamID), logging.F("session", session),
+			logging.F("viewers", len(src.viewers)))
+	}
+	v.LastChunk = now
+
+	c, ok := src.chunks[key]
+	if ok {
+		src.shared++
+		b.mu.Unlock()
+		b.reads.WithLabelValues("shared").Inc()
+		select {
+		case <-c.done:
+			return c.data, c.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	c = &sharedChunk{done: make(chan struct{})}
+	src.chunks[key] = c
+	src.order = append(src.order, key)
+	if len(src.order) > b.chunks {
+		delete(src.chunks, src.order[0])
+		src.order = src.order[1:]
+	}
+	src.produced++
+	b.mu.Unlock()
+	b.reads.WithLabelValues("produced").Inc()
+
+	c.data, c.err = produce()
+	close(c.done)
+	if c.err != nil {
+		// Let the next request try again
+		b.mu.Lock()
+		if src.chunks[key] == c {
+			delete(src.chunks, key)
+			src.order = slices.DeleteFunc(src.order, func(k chunkKey) bool { return k == key })
+		}
+		b.mu.Unlock()
+	}
+	return c.data, c.err
+}
+
+// sent records that session was sent n bytes of a chunk of streamID at
+// quality
+func (b *Broadcast) sent(streamID, session, quality string, n int) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	src := b.sources[streamID]
+	if src == nil {
+		return
+	}
+	if v := src.viewers[session]; v != nil {
+		v.Quality = quality
+		v.ChunksSent++
+		v.BytesSent += int64(n)
+	}
+}
+
+// forget drops the chunks kept for streamID, e.g. because its content
+// changed. Its viewers stay.
+func (b *Broadcast) forget(streamID string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if src := b.sources[streamID]; src != nil {
+		src.chunks = make(map[chunkKey]*sharedChunk)
+		src.order = nil
+	}
+}
+
+// Source returns the source of streamID and its viewers by session, or
+// false if the stream has no source
+func (b *Broadcast) Source(streamID string) (SourceStats, []ViewerStats, bool) {
+	if b == nil {
+		return SourceStats{}, nil, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweepLocked(b.clock.Now())
+	src := b.sources[streamID]
+	if src == nil {
+		return SourceStats{}, nil, false
+	}
+	viewers := make([]ViewerStats, 0, len(src.viewers))
+	for _, v := range src.viewers {
+		viewers = append(viewers, *v)
+	}
+	sort.Slice(viewers, func(i, j int) bool { return viewers[i].Session < viewers[j].Session })
+	return SourceStats{
+		Viewers:        len(src.viewers),
+		ChunksProduced: src.produced,
+		ChunksShared:   src.shared,
+		Started:        src.started,
+	}, viewers, true
+}
+
+// sweepLocked drops the viewers that stopped requesting chunks, then the
+// sources that were left without viewers for longer than the linger.
+// b.mu must be held.
+func (b *Broadcast) sweepLocked(now time.Time) {
+	if now.Sub(b.swept) < broadcastSweep {
+		return
+	}
+	b.swept = now
+	for streamID, src := range b.sources {
+		var last time.Time // the last viewer that left did
+		for session, v := range src.viewers {
+			left := v.LastChunk.Add(b.idle)
+			if now.Before(left) {
+				continue
+			}
+			delete(src.viewers, session)
+			b.viewers.Dec()
+			b.logger.Debug("Viewer left", logging.F("stream_id", streamID), logging.F("session", session),
+				logging.F("viewers", len(src.viewers)))
+			if left.After(last) {
+				last = left
+			}
+		}
+		if len(src.viewers) > 0 {
+			continue
+		}
+		if !last.IsZero() {
+			src.empty = last
+		}
+		if now.Sub(src.empty) < b.linger {
+			continue
+		}
+		delete(b.sources, streamID)
+		b.active.Dec()
+		b.logger.Info("Stream source stopped", logging.F("stream_id", streamID),
+			logging.F("chunks_produced", src.produced), logging.F("chunks_shared", src.shared))
+	}
+}
@@ -0,0 +1,167 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newTestBroadcast creates a broadcast keeping 2 chunks per stream, whose
+// viewers leave after 10s and sources linger for 30s
+func newTestBroadcast(t *testing.T) (*Broadcast, *clock.Fake, *metrics.Registry) {
+	t.Helper()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	cfg := config.BroadcastConfig{Chunks: 2, ViewerIdle: 10 * time.Second, Linger: 30 * time.Second}
+	return NewBroadcast(cfg, logging.Nop(), c, reg), c, reg
+}
+
+// producer counts the chunks it produces
+type producer struct {
+	calls int
+	err   error
+}
+
+func (p *producer) produce() ([]byte, error) {
+	p.calls++
+	return []byte("chunk"), p.err
+}
+
+func TestBroadcastProducesChunksOnce(t *testing.T) {
+	b, _, reg := newTestBroadcast(t)
+	ctx := context.Background()
+
+	// A request arriving while the chunk is produced waits for it
+	release := make(chan struct{})
+	first := make(chan []byte)
+	go func() {
+		data, _ := b.chunk(ctx, "s1", "alice", "high", 0, func() ([]byte, error) {
+			<-release
+			return []byte("keyframe"), nil
+		})
+		first <- data
+	}()
+	for {
+		if src, _, ok := b.Source("s1"); ok && src.ChunksProduced == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan []byte)
+	go func() {
+		data, _ := b.chunk(ctx, "s1", "bob", "high", 0, (&producer{err: errors.New("produced twice")}).produce)
+		second <- data
+	}()
+	close(release)
+	if alice, bob := string(<-first), string(<-second); alice != "keyframe" || bob != "keyframe" {
+		t.Errorf("viewers got %q and %q, want the same chunk", alice, bob)
+	}
+
+	// Another quality is a chunk of its own, and only the last 2 are kept
+	p := &producer{}
+	b.chunk(ctx, "s1", "bob", "low", 0, p.produce)
+	b.chunk(ctx, "s1", "bob", "low", 1, p.produce)
+	b.chunk(ctx, "s1", "alice", "high", 0, p.produce)
+	if p.calls != 3 {
+		t.Errorf("produced %d chunks, want chunk 0 at high again once evicted", p.calls)
+	}
+
+	src, viewers, ok := b.Source("s1")
+	if !ok || src.Viewers != 2 || src.ChunksProduced != 4 || src.ChunksShared != 1 {
+		t.Errorf("source %+v, want 2 viewers, 4 chunks produced and 1 shared", src)
+	}
+	if len(viewers) != 2 || viewers[0].Session != "alice" || viewers[1].Session != "bob" {
+		t.Errorf("viewers %+v, want alice and bob", viewers)
+	}
+	if got := metricValue(t, reg, `commsys_streaming_source_chunks_total{result="shared"}`); got != "1" {
+		t.Errorf("source_chunks_total shared = %q, want 1", got)
+	}
+}
+
+func TestBroadcastRetriesFailedChunks(t *testing.T) {
+	b, _, _ := newTestBroadcast(t)
+	p := &producer{err: errors.New("disk error")}
+	if _, err := b.chunk(context.Background(), "s1", "alice", "high", 0, p.produce); err == nil {
+		t.Fatal("failed chunk returned no error")
+	}
+	p.err = nil
+	if data, err := b.chunk(context.Background(), "s1", "alice", "high", 0, p.produce); err != nil || string(data) != "chunk" || p.calls != 2 {
+		t.Errorf("got %q, %v after %d calls, want the chunk produced again", data, err, p.calls)
+	}
+}
+
+func TestBroadcastViewersLeaveAndSourceStops(t *testing.T) {
+	b, c, reg := newTestBroadcast(t)
+	p := &producer{}
+	b.chunk(context.Background(), "s1", "alice", "high", 0, p.produce)
+	c.Advance(5 * time.Second)
+	b.chunk(context.Background(), "s1", "bob", "high", 1, p.produce)
+
+	// alice left 10s after her chunk, bob is still watching
+	c.Advance(6 * time.Second)
+	if src, viewers, _ := b.Source("s1"); src.Viewers != 1 || viewers[0].Session != "bob" {
+		t.Errorf("viewers %+v, want bob alone", viewers)
+	}
+	// The source lingers 30s after bob left at 15s
+	c.Advance(30 * time.Second)
+	if src, _, ok := b.Source("s1"); !ok || src.Viewers != 0 {
+		t.Errorf("source %+v, %v, want it kept without viewers", src, ok)
+	}
+	c.Advance(4 * time.Second)
+	if _, _, ok := b.Source("s1"); ok {
+		t.Error("source still kept after the linger")
+	}
+	if got := metricValue(t, reg, "commsys_streaming_broadcast_sources"); got != "0" {
+		t.Errorf("broadcast_sources = %s, want 0", got)
+	}
+	if got := metricValue(t, reg, "commsys_streaming_broadcast_viewers"); got != "0" {
+		t.Errorf("broadcast_viewers = %s, want 0", got)
+	}
+}
+
+func TestStreamStatsReportViewers(t *testing.T) {
+	b, c, reg := newTestBroadcast(t)
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg), WithClock(c), WithBroadcast(b))
+	get := func(target, session string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Session-ID", session)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	for _, session := range []string{"alice", "bob"} {
+		if rec := get("/stream/chunk/stream_001?quality=low&chunk=3", session); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", session, rec.Code)
+		}
+	}
+
+	var stats StreamStats
+	if err := json.NewDecoder(get("/stream/stats/stream_001", "alice").Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ActiveClients == nil || *stats.ActiveClients != 2 || stats.Source == nil || stats.Source.ChunksShared != 1 {
+		t.Errorf("stats %+v, want 2 viewers sharing a chunk", stats)
+	}
+	for _, v := range stats.Viewers {
+		if v.ChunksSent != 1 || v.Quality != "low" || v.BytesSent == 0 {
+			t.Errorf("viewer %+v, want 1 chunk at low", v)
+		}
+	}
+	var unwatched StreamStats
+	if err := json.NewDecoder(get("/stream/stats/stream_002", "alice").Body).Decode(&unwatched); err != nil {
+		t.Fatal(err)
+	}
+	if unwatched.ActiveClients == nil || *unwatched.ActiveClients != 0 || unwatched.Source != nil {
+		t.Errorf("stats of an unwatched stream %+v, want no viewers", unwatched)
+	}
+}
@@ -0,0 +1,448 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// Sources of the streams of a Catalog
+const (
+	SourceSynthetic = "synthetic" // generated chunks
+	SourceFile      = "file"      // segments on disk
+	SourceLive      = "live"      // pushed into the server at /stream/ingest
+)
+
+// StatusNotFound is the status of a request for a stream that isn't in the
+// catalog
+const StatusNotFound = "not_found"
+
+// UnknownStream answers, with 404, a request for a stream that isn't in the
+// catalog
+type UnknownStream struct {
+	Status  string   `json:"status"` // StatusNotFound
+	Error   string   `json:"error"`
+	Streams []string `json:"streams"` // IDs of the streams there are
+}
+
+// Errors of RegisterStream
+var (
+	ErrStreamExists  = errors.New("stream already exists")
+	ErrStreamInvalid = errors.New("invalid stream")
+)
+
+// StreamMeta defines a stream of a Catalog
+type StreamMeta struct {
+	StreamID string `json:"stream_id"`
+	Title    string `json:"title"`
+	Duration int    `json:"duration"` // seconds; -1 for live streams, 0 for a file stream takes it from the segments
+	Source   string `json:"source"`   // SourceSynthetic, SourceFile or SourceLive
+	// Rungs of the ladder the stream is offered at, empty for every rung,
+	// or for a file stream every rung with segments on disk
+	Qualities []string `json:"qualities,omitempty"`
+	// Directory of a file stream, with the segments of each quality in
+	// path/{quality}/ like a stream of the content directory
+	Path         string    `json:"path,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
+// Catalog defines the streams a handler serves beyond the built-in and
+// seeded ones, and persists them to a JSON file so they survive a restart.
+// With a catalog, requests for any other stream are refused with
+// UnknownStream, and streams are only ingested once registered as live.
+// A catalog without a file keeps its streams in memory.
+// The segments of a file stream are checked when it is registered, and
+// again when the catalog is loaded.
+type Catalog struct {
+	path   string
+	ladder []config.QualityConfig
+	chunk  time.Duration
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	streams  map[string]*catalogStream
+	reserved map[string]bool // IDs of the built-in and seeded streams
+}
+
+// catalogStream is a stream of the catalog with its probed segments
+type catalogStream struct {
+	meta       StreamMeta
+	renditions map[string]*Rendition // of a file stream, by quality
+	reason     string                // why it can't be served since loaded, "" if it can
+}
+
+// NewCatalog loads the catalog persisted at path, or starts an empty one if
+// there is no file yet. Streams are offered at the rungs of ladder, in
+// chunks of chunk duration. If path is empty, the catalog isn't persisted.
+func NewCatalog(path string, ladder []config.QualityConfig, chunk time.Duration, logger logging.Logger, c clock.Clock) (*Catalog, error) {
+	cat := &Catalog{
+		path:     path,
+		ladder:   ladder,
+		chunk:    chunk,
+		logger:   logger,
+		clock:    c,
+		streams:  make(map[string]*catalogStream),
+		reserved: make(map[string]bool),
+	}
+	if path == "" {
+		return cat, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cat, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read stream catalog: %w", err)
+	}
+	var metas []StreamMeta
+	if err := json.Unmarshal(data, &metas); err != nil {
+		return nil, fmt.Errorf("failed to parse stream catalog %s: %w", path, err)
+	}
+	for _, meta := range metas {
+		s := &catalogStream{meta: meta}
+		// Segments may have changed since; the stream stays registered
+		if s.renditions, err = cat.check(&s.meta); err != nil {
+			s.reason = err.Error()
+			logger.Warn("Catalog stream unavailable", logging.F("stream_id", meta.StreamID), logging.F("reason", s.reason))
+		}
+		cat.streams[meta.StreamID] = s
+	}
+	logger.Info("Loaded stream catalog", logging.F("path", path), logging.F("streams", len(cat.streams)))
+	return cat, nil
+}
+
+// WithCatalog serves the streams of c and refuses requests for unknown ones
+func WithCatalog(c *Catalog) Option {
+	return func(h *Handler) {
+		h.registry = c
+	}
+}
+
+// RegisterStream adds the stream of meta to the catalog and persists it. It
+// returns an error wrapping ErrStreamInvalid if meta isn't valid, e.g. a file
+// stream whose segments can't be read, or ErrStreamExists.
+func (c *Catalog) RegisterStream(meta StreamMeta) (StreamMeta, error) {
+	renditions, err := c.check(&meta)
+	if err != nil {
+		return StreamMeta{}, err
+	}
+	meta.RegisteredAt = c.clock.Now().UTC()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reserved[meta.StreamID] || c.streams[meta.StreamID] != nil {
+		return StreamMeta{}, fmt.Errorf("%w: %s", ErrStreamExists, meta.StreamID)
+	}
+	c.streams[meta.StreamID] = &catalogStream{meta: meta, renditions: renditions}
+	if err := c.persistLocked(); err != nil {
+		delete(c.streams, meta.StreamID)
+		return StreamMeta{}, err
+	}
+	c.logger.Info("Registered stream", logging.F("stream_id", meta.StreamID), logging.F("source", meta.Source),
+		logging.F("qualities", meta.Qualities))
+	return meta, nil
+}
+
+// RemoveStream removes streamID from the catalog and persists it, or
+// returns false if it isn't registered. Live sessions of a live stream
+// being ingested play on until the ingest ends.
+func (c *Catalog) RemoveStream(streamID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.streams[streamID]
+	if s == nil {
+		return false, nil
+	}
+	delete(c.streams, streamID)
+	if err := c.persistLocked(); err != nil {
+		c.streams[streamID] = s
+		return false, err
+	}
+	c.logger.Info("Removed stream", logging.F("stream_id", streamID))
+	return true, nil
+}
+
+// Stream returns the registered stream streamID, or false
+func (c *Catalog) Stream(streamID string) (StreamMeta, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s := c.streams[streamID]; s != nil {
+		return s.meta, true
+	}
+	return StreamMeta{}, false
+}
+
+// Streams returns the registered streams, ordered by stream ID
+func (c *Catalog) Streams() []StreamMeta {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	metas := make([]StreamMeta, 0, len(c.streams))
+	for _, s := range c.streams {
+		metas = append(metas, s.meta)
+	}
+	slices.SortFunc(metas, func(a, b StreamMeta) int { return strings.Compare(a.StreamID, b.StreamID) })
+	return metas
+}
+
+// reserve keeps the IDs of the handler's own streams from being registered.
+// Streams loaded with one of them are dropped.
+func (c *Catalog) reserve(streams []StreamInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range streams {
+		c.reserved[s.StreamID] = true
+		if c.streams[s.StreamID] != nil {
+			delete(c.streams, s.StreamID)
+			c.logger.Warn("Ignoring catalog stream", logging.F("stream_id", s.StreamID), logging.F("reason", "built into the server"))
+		}
+	}
+}
+
+// entry returns the registered stream streamID, or nil. A nil Catalog has
+// none.
+func (c *Catalog) entry(streamID string) *catalogStream {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streams[streamID]
+}
+
+// entries returns the registered streams, ordered by stream ID. A nil
+// Catalog has none.
+func (c *Catalog) entries() []*catalogStream {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	streams := make([]*catalogStream, 0, len(c.streams))
+	for _, s := range c.streams {
+		streams = append(streams, s)
+	}
+	slices.SortFunc(streams, func(a, b *catalogStream) int { return strings.Compare(a.meta.StreamID, b.meta.StreamID) })
+	return streams
+}
+
+// check validates meta, filling in the qualities and duration of a file
+// stream from its segments, and returns the probed renditions of a file
+// stream
+func (c *Catalog) check(meta *StreamMeta) (map[string]*Rendition, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s", ErrStreamInvalid, fmt.Sprintf(format, args...))
+	}
+	if meta.StreamID == "" || strings.ContainsAny(meta.StreamID, "/?#") {
+		return nil, invalid("stream_id %q must be non-empty, without / ? or #", meta.StreamID)
+	}
+	if meta.Title == "" {
+		meta.Title = meta.StreamID
+	}
+	for i, q := range meta.Qualities {
+		if !slices.ContainsFunc(c.ladder, func(r config.QualityConfig) bool { return r.Name == q }) {
+			return nil, invalid("quality %q is not on the ladder", q)
+		}
+		if slices.Contains(meta.Qualities[:i], q) {
+			return nil, invalid("quality %q listed twice", q)
+		}
+	}
+
+	switch meta.Source {
+	case SourceSynthetic:
+		if meta.Duration <= 0 {
+			return nil, invalid("a synthetic stream needs a positive duration")
+		}
+		return nil, nil
+	case SourceLive:
+		if meta.Duration != 0 && meta.Duration != -1 {
+			return nil, invalid("a live stream has no duration")
+		}
+		meta.Duration = -1
+		return nil, nil
+	case SourceFile:
+	default:
+		return nil, invalid("source must be %s, %s or %s, not %q", SourceSynthetic, SourceFile, SourceLive, meta.Source)
+	}
+
+	if meta.Path == "" {
+		return nil, invalid("a file stream needs a path")
+	}
+	if meta.Duration < 0 {
+		return nil, invalid("a file stream has a duration")
+	}
+	if info, err := os.Stat(meta.Path); err != nil {
+		return nil, invalid("%v", err)
+	} else if !info.IsDir() {
+		return nil, invalid("%s is not a directory", meta.Path)
+	}
+	qualities := meta.Qualities
+	if len(qualities) == 0 {
+		for _, r := range c.ladder {
+			if info, err := os.Stat(filepath.Join(meta.Path, r.Name)); err == nil && info.IsDir() {
+				qualities = append(qualities, r.Name)
+			}
+		}
+		if len(qualities) == 0 {
+			return nil, invalid("%s has no directory for a quality of the ladder", meta.Path)
+		}
+	}
+	renditions := make(map[string]*Rendition)
+	var longest time.Duration
+	for _, q := range qualities {
+		r := probeRendition(filepath.Dir(meta.Path), filepath.Base(meta.Path), q)
+		r.StreamID, r.chunk = meta.StreamID, c.chunk
+		switch {
+		case r.Reason != "":
+			return nil, invalid("quality %s: %s", q, r.Reason)
+		case len(r.Segments) == 0:
+			return nil, invalid("quality %s: no segments on disk", q)
+		}
+		renditions[q] = r
+		longest = max(longest, r.Duration())
+	}
+	if meta.Duration == 0 {
+		meta.Duration = int(longest.Round(time.Second) / time.Second)
+	}
+	// Like content, the segments of every quality cover the duration
+	want := time.Duration(meta.Duration) * time.Second
+	for _, q := range qualities {
+		if d := renditions[q].Duration(); d < want-c.chunk || d > want+c.chunk {
+			return nil, invalid("quality %s: segments cover %v of %v", q, d, want)
+		}
+	}
+	meta.Qualities = qualities
+	return renditions, nil
+}
+
+// persistLocked writes the catalog to its file, if it has one, through a
+// temporary file so a crash can't leave it half written. c.mu must be held.
+func (c *Catalog) persistLocked() error {
+	if c.path == "" {
+		return nil
+	}
+	metas := make([]StreamMeta, 0, len(c.streams))
+	for _, s := range c.streams {
+		metas = append(metas, s.meta)
+	}
+	slices.SortFunc(metas, func(a, b StreamMeta) int { return strings.Compare(a.StreamID, b.StreamID) })
+	data, err := json.MarshalIndent(metas, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".catalog-*")
+	if err != nil {
+		return fmt.Errorf("failed to persist stream catalog: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to persist stream catalog: %w", err)
+	}
+	return nil
+}
+
+// registeredInfo returns the catalog entry of a registered stream. A live
+// stream only lists here while it isn't being ingested, with its renditions
+// unavailable.
+func (h *Handler) registeredInfo(s *catalogStream) StreamInfo {
+	info := StreamInfo{
+		StreamID:  s.meta.StreamID,
+		Title:     s.meta.Title,
+		Duration:  s.meta.Duration,
+		Format:    "h264",
+		CreatedAt: s.meta.RegisteredAt,
+		Live:      s.meta.Source == SourceLive,
+	}
+	h.offer(&info, s.meta.Qualities)
+	for i := range info.Bitrates {
+		b := &info.Bitrates[i]
+		switch {
+		case info.Live:
+			b.Available, b.Reason = false, "not being ingested"
+		case s.reason != "":
+			b.Available, b.Reason = false, s.reason
+		}
+	}
+	return info
+}
+
+// known reports whether streamID is in the catalog. Without a Catalog every
+// stream ID is.
+func (h *Handler) known(streamID string) bool {
+	if h.registry == nil {
+		return true
+	}
+	return slices.ContainsFunc(h.listed(), func(s StreamInfo) bool { return s.StreamID == streamID })
+}
+
+// unknownStream refuses a request for streamID, listing the streams there
+// are
+func (h *Handler) unknownStream(w http.ResponseWriter, streamID string) {
+	streams := h.listed()
+	ids := make([]string, len(streams))
+	for i, s := range streams {
+		ids[i] = s.StreamID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(UnknownStream{
+		Status:  StatusNotFound,
+		Error:   fmt.Sprintf("Stream %s not found", streamID),
+		Streams: ids,
+	})
+}
+
+// locateRegistered returns the source of chunk index of the registered
+// stream s at quality, or the status to refuse it with
+func (h *Handler) locateRegistered(s *catalogStream, quality string, index int) (chunkSource, int, string) {
+	var src chunkSource
+	notFound := fmt.Sprintf("Chunk %d of stream %s at quality %s not found", index, s.meta.StreamID, quality)
+	qualities := s.meta.Qualities
+	if len(qualities) == 0 {
+		for _, q := range h.qualities {
+			qualities = append(qualities, q.Name)
+		}
+	}
+	switch {
+	case !slices.Contains(qualities, quality):
+		return src, http.StatusNotFound, fmt.Sprintf("Rendition %s of stream %s not found", quality, s.meta.StreamID)
+	case s.reason != "":
+		return src, http.StatusServiceUnavailable, fmt.Sprintf("Stream %s unavailable: %s", s.meta.StreamID, s.reason)
+	}
+	switch s.meta.Source {
+	case SourceLive:
+		return src, http.StatusNotFound, fmt.Sprintf("Stream %s is not being ingested", s.meta.StreamID)
+	case SourceFile:
+		var status int
+		if src.segment, src.checksum, status = s.renditions[quality].segment(index); status != http.StatusOK {
+			return src, status, notFound
+		}
+		return src, http.StatusOK, ""
+	}
+	rung, _ := h.rung(quality)
+	if index < 0 || index >= SeededChunks(time.Duration(s.meta.Duration)*time.Second, h.chunk) {
+		return src, http.StatusNotFound, notFound
+	}
+	// Sizes vary per stream like those of the seeded streams
+	ordinal := int(crc32.ChecksumIEEE([]byte(s.meta.StreamID)) % 1000)
+	src.seedSize = vbrChunkSize(rung.BitrateKbps, ordinal, index, h.chunk)
+	return src, http.StatusOK, ""
+}
@@ -0,0 +1,201 @@
+package streaming
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func newTestCatalog(t *testing.T, path string) *Catalog {
+	t.Helper()
+	cfg := config.Default().Streaming
+	fake := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c, err := NewCatalog(path, cfg.Ladder, cfg.ChunkDuration, logging.Nop(), fake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCatalogRejectsUnknownStreams(t *testing.T) {
+	cat := newTestCatalog(t, "")
+	if _, err := cat.RegisterStream(StreamMeta{StreamID: "lecture", Source: SourceSynthetic, Duration: 60}); err != nil {
+		t.Fatal(err)
+	}
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg), WithCatalog(cat))
+
+	for _, target := range []string{"/stream/info/nope", "/stream/chunk/nope/0?quality=low", "/stream/stats/nope"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("%s: status %d, want 404", target, rec.Code)
+		}
+		var resp UnknownStream
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != StatusNotFound || !slices.Contains(resp.Streams, "lecture") || !slices.Contains(resp.Streams, "stream_001") {
+			t.Errorf("%s: answered %+v, want not_found listing the streams there are", target, resp)
+		}
+	}
+
+	for _, target := range []string{"/stream/info/lecture", "/stream/chunk/lecture/0?quality=low", "/stream/info/stream_001"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", target, rec.Code, rec.Body)
+		}
+	}
+
+	// Built-in streams can't be shadowed
+	if _, err := cat.RegisterStream(StreamMeta{StreamID: "stream_001", Source: SourceSynthetic, Duration: 60}); !errors.Is(err, ErrStreamExists) {
+		t.Errorf("registering a built-in stream: %v, want ErrStreamExists", err)
+	}
+}
+
+func TestStreamInfoUnknownWithoutCatalog(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/info/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("info of an unknown stream: status %d, want 404", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/info/stream_001", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("info of a built-in stream: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCatalogPersistsAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "catalog.json")
+	media := filepath.Join(dir, "talk")
+	writeSegments(t, media, "low", 5)
+	writeSegments(t, media, "high", 5)
+
+	cat := newTestCatalog(t, path)
+	for _, meta := range []StreamMeta{
+		{StreamID: "lecture", Title: "Lecture", Source: SourceSynthetic, Duration: 60, Qualities: []string{"low", "medium"}},
+		{StreamID: "camera", Source: SourceLive},
+		{StreamID: "talk", Source: SourceFile, Path: media},
+		{StreamID: "gone", Source: SourceSynthetic, Duration: 10},
+	} {
+		if _, err := cat.RegisterStream(meta); err != nil {
+			t.Fatalf("%s: %v", meta.StreamID, err)
+		}
+	}
+	if removed, err := cat.RemoveStream("gone"); !removed || err != nil {
+		t.Fatalf("RemoveStream = %v, %v", removed, err)
+	}
+	want := cat.Streams()
+
+	restarted := newTestCatalog(t, path)
+	got := restarted.Streams()
+	if len(got) != 3 {
+		t.Fatalf("%d streams after a restart, want 3: %+v", len(got), got)
+	}
+	for i := range want {
+		w, g := want[i], got[i]
+		if g.StreamID != w.StreamID || g.Title != w.Title || g.Source != w.Source || g.Duration != w.Duration ||
+			!slices.Equal(g.Qualities, w.Qualities) || g.Path != w.Path || !g.RegisteredAt.Equal(w.RegisteredAt) {
+			t.Errorf("loaded %+v, want %+v", g, w)
+		}
+	}
+	talk, _ := restarted.Stream("talk")
+	if talk.Duration != 10 || !slices.Equal(talk.Qualities, []string{"low", "high"}) {
+		t.Errorf("file stream %+v, want 10s at low and high from its segments", talk)
+	}
+	if s := restarted.entry("talk"); s.reason != "" || len(s.renditions) != 2 {
+		t.Errorf("file stream loaded with reason %q and %d renditions", s.reason, len(s.renditions))
+	}
+
+	// A file stream whose segments went stays registered, unavailable
+	if err := os.RemoveAll(filepath.Join(media, "high")); err != nil {
+		t.Fatal(err)
+	}
+	if s := newTestCatalog(t, path).entry("talk"); s == nil || s.reason == "" {
+		t.Errorf("file stream without its segments loaded as %+v", s)
+	}
+}
+
+func TestCatalogValidation(t *testing.T) {
+	dir := t.TempDir()
+	complete := filepath.Join(dir, "complete")
+	writeSegments(t, complete, "low", 5)
+	writeSegments(t, complete, "medium", 5)
+	uneven := filepath.Join(dir, "uneven")
+	writeSegments(t, uneven, "low", 5)
+	writeSegments(t, uneven, "medium", 2)
+	empty := filepath.Join(dir, "empty")
+	writeSegments(t, empty, "low", 0)
+	notDir := filepath.Join(dir, "file.mp4")
+	os.WriteFile(notDir, []byte("not a directory"), 0o644)
+	unreadable := filepath.Join(dir, "unreadable")
+	writeSegments(t, unreadable, "low", 3)
+	os.Chmod(filepath.Join(unreadable, "low"), 0)
+	t.Cleanup(func() { os.Chmod(filepath.Join(unreadable, "low"), 0o755) })
+
+	tests := []struct {
+		name string
+		meta StreamMeta
+	}{
+		{"no ID", StreamMeta{Source: SourceSynthetic, Duration: 60}},
+		{"slash in ID", StreamMeta{StreamID: "a/b", Source: SourceSynthetic, Duration: 60}},
+		{"unknown source", StreamMeta{StreamID: "s", Source: "rtmp", Duration: 60}},
+		{"synthetic without duration", StreamMeta{StreamID: "s", Source: SourceSynthetic}},
+		{"live with duration", StreamMeta{StreamID: "s", Source: SourceLive, Duration: 60}},
+		{"quality off the ladder", StreamMeta{StreamID: "s", Source: SourceSynthetic, Duration: 60, Qualities: []string{"8k"}}},
+		{"quality twice", StreamMeta{StreamID: "s", Source: SourceSynthetic, Duration: 60, Qualities: []string{"low", "low"}}},
+		{"file without path", StreamMeta{StreamID: "s", Source: SourceFile}},
+		{"missing path", StreamMeta{StreamID: "s", Source: SourceFile, Path: filepath.Join(dir, "missing")}},
+		{"path not a directory", StreamMeta{StreamID: "s", Source: SourceFile, Path: notDir}},
+		{"no quality directories", StreamMeta{StreamID: "s", Source: SourceFile, Path: filepath.Join(complete, "low")}},
+		{"no segments", StreamMeta{StreamID: "s", Source: SourceFile, Path: empty}},
+		{"quality without segments", StreamMeta{StreamID: "s", Source: SourceFile, Path: complete, Qualities: []string{"low", "high"}}},
+		{"segments short of the duration", StreamMeta{StreamID: "s", Source: SourceFile, Path: uneven}},
+		{"duration beyond the segments", StreamMeta{StreamID: "s", Source: SourceFile, Path: complete, Duration: 60}},
+	}
+	if os.Geteuid() != 0 { // root reads anything
+		tests = append(tests, struct {
+			name string
+			meta StreamMeta
+		}{"unreadable segments", StreamMeta{StreamID: "s", Source: SourceFile, Path: unreadable}})
+	}
+
+	path := filepath.Join(dir, "catalog.json")
+	cat := newTestCatalog(t, path)
+	for _, tt := range tests {
+		if _, err := cat.RegisterStream(tt.meta); !errors.Is(err, ErrStreamInvalid) {
+			t.Errorf("%s: RegisterStream = %v, want ErrStreamInvalid", tt.name, err)
+		}
+	}
+	if got := cat.Streams(); len(got) != 0 {
+		t.Errorf("invalid streams registered: %+v", got)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("catalog file written for invalid streams: %v", err)
+	}
+
+	if _, err := cat.RegisterStream(StreamMeta{StreamID: "s", Source: SourceFile, Path: complete}); err != nil {
+		t.Fatalf("valid file stream refused: %v", err)
+	}
+	if _, err := cat.RegisterStream(StreamMeta{StreamID: "s", Source: SourceLive}); !errors.Is(err, ErrStreamExists) {
+		t.Errorf("registering s again: %v, want ErrStreamExists", err)
+	}
+}
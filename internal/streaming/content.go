@@ -0,0 +1,402 @@
+package streaming
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// SegmentDuration is the default playback time of one chunk, for clients of
+// servers that don't announce theirs
+const SegmentDuration = 2 * time.Second
+
+// bitrateTolerance is how far a rendition's measured bitrate may stray from
+// the advertised one, as a fraction of it
+const bitrateTolerance = 0.5
+
+// ChecksumFile lists the expected SHA-256 of each segment of a rendition in
+// sha256sum format. Renditions with a segment that is missing from it or
+// doesn't match are unavailable. Without it the checksums computed at
+// startup are published as they are.
+const ChecksumFile = "checksums.sha256"
+
+// ChecksumHeader carries the hex SHA-256 of a chunk served from disk
+const ChecksumHeader = "X-Chunk-SHA256"
+
+// Content serves video segments from disk instead of generated chunks. Each
+// rendition lives in dir/{stream_id}/{quality}/ with an optional init.mp4,
+// an optional ChecksumFile and one file per chunk, in name order.
+type Content struct {
+	dir        string
+	renditions map[string]*Rendition // by stream_id/quality
+}
+
+// Rendition is the probed state of one stream quality
+type Rendition struct {
+	StreamID  string
+	Quality   string
+	Init      string   // file path of init.mp4, empty without one
+	Segments  []string // file paths, one per chunk
+	Checksums []string // hex SHA-256 of each segment
+	Bytes     int64
+	Width     int // from the container metadata, 0 if not found
+	Height    int
+	Reason    string // why the rendition is unavailable, empty when it is available
+
+	live  bool
+	chunk time.Duration // playback time of a segment
+}
+
+// Duration is the playback time covered by the segments
+func (r *Rendition) Duration() time.Duration {
+	return time.Duration(len(r.Segments)) * r.chunk
+}
+
+// Kbps estimates the bitrate from the segment sizes
+func (r *Rendition) Kbps() int {
+	if len(r.Segments) == 0 {
+		return 0
+	}
+	return int(float64(r.Bytes*8) / r.Duration().Seconds() / 1000)
+}
+
+// LoadContent scans dir for renditions, each segment of which plays for
+// chunk. Renditions are only checked against the advertised ladder by the
+// handler, see WithContent.
+func LoadContent(dir string, chunk time.Duration) (*Content, error) {
+	streams, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read content directory: %w", err)
+	}
+
+	c := &Content{dir: dir, renditions: make(map[string]*Rendition)}
+	for _, stream := range streams {
+		if !stream.IsDir() {
+			continue
+		}
+		qualities, err := os.ReadDir(filepath.Join(dir, stream.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stream %s: %w", stream.Name(), err)
+		}
+		for _, quality := range qualities {
+			if quality.IsDir() {
+				r := probeRendition(dir, stream.Name(), quality.Name())
+				r.chunk = chunk
+				c.renditions[stream.Name()+"/"+quality.Name()] = r
+			}
+		}
+	}
+	return c, nil
+}
+
+// rendition returns the rendition of streamID at quality, or nil
+func (c *Content) rendition(streamID, quality string) *Rendition {
+	return c.renditions[streamID+"/"+quality]
+}
+
+// segment returns the file of a chunk and its checksum, or the status to
+// refuse it with: 503 for renditions that failed verification and 404 for
+// unknown ones or chunks past the end. Live streams loop over their segments.
+func (c *Content) segment(streamID, quality string, index int) (string, string, int) {
+	return c.rendition(streamID, quality).segment(index)
+}
+
+// segment returns the file of chunk index of r and its checksum, or the
+// status to refuse it with, like Content.segment
+func (r *Rendition) segment(index int) (string, string, int) {
+	switch {
+	case r == nil || index < 0:
+		return "", "", http.StatusNotFound
+	case r.Reason != "":
+		return "", "", http.StatusServiceUnavailable
+	case r.live:
+		index %= len(r.Segments)
+	case index >= len(r.Segments):
+		return "", "", http.StatusNotFound
+	}
+	return r.Segments[index], r.Checksums[index], http.StatusOK
+}
+
+// SegmentChecksum is the checksum of one chunk of a rendition
+type SegmentChecksum struct {
+	Chunk  int    `json:"chunk"`
+	File   string `json:"file"`
+	SHA256 string `json:"sha256"`
+}
+
+// RenditionChecksums lists the segment checksums of one stream quality
+type RenditionChecksums struct {
+	Quality   string            `json:"quality"`
+	Available bool              `json:"available"`
+	Reason    string            `json:"reason,omitempty"`
+	Segments  []SegmentChecksum `json:"segments"`
+}
+
+// Checksums returns the segment checksums of every rendition of streamID on
+// disk, ordered by quality, or false if it has none
+func (c *Content) Checksums(streamID string) ([]RenditionChecksums, bool) {
+	var out []RenditionChecksums
+	for _, r := range c.renditions {
+		if r.StreamID != streamID {
+			continue
+		}
+		rc := RenditionChecksums{Quality: r.Quality, Available: r.Reason == "", Reason: r.Reason, Segments: []SegmentChecksum{}}
+		for i, sum := range r.Checksums {
+			rc.Segments = append(rc.Segments, SegmentChecksum{Chunk: i, File: filepath.Base(r.Segments[i]), SHA256: sum})
+		}
+		out = append(out, rc)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Quality < out[j].Quality })
+	return out, len(out) > 0
+}
+
+// probeRendition reads the segment list, sizes and resolution of a rendition
+func probeRendition(dir, streamID, quality string) *Rendition {
+	r := &Rendition{StreamID: streamID, Quality: quality}
+	path := filepath.Join(dir, streamID, quality)
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		r.Reason = err.Error()
+		return r
+	}
+
+	init := ""
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		if e.Name() == "init.mp4" {
+			init = filepath.Join(path, e.Name())
+			continue
+		}
+		if e.Name() == ChecksumFile {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			r.Reason = err.Error()
+			return r
+		}
+		if info.Size() > MaxChunkSize {
+			r.Reason = fmt.Sprintf("segment %s is %d bytes, above the %d byte chunk limit", e.Name(), info.Size(), MaxChunkSize)
+			return r
+		}
+		r.Segments = append(r.Segments, filepath.Join(path, e.Name()))
+		r.Bytes += info.Size()
+	}
+	sort.Strings(r.Segments)
+
+	if r.Reason = checkSegments(r, path); r.Reason != "" {
+		return r
+	}
+
+	r.Init = init
+	if init == "" && len(r.Segments) > 0 {
+		init = r.Segments[0]
+	}
+	if init != "" {
+		r.Width, r.Height = probeResolution(init)
+	}
+	return r
+}
+
+// checkSegments hashes every segment of r and compares the hashes with the
+// rendition's ChecksumFile, if any. It returns why the rendition can't be
+// served, or "".
+func checkSegments(r *Rendition, path string) string {
+	stored, err := readChecksums(filepath.Join(path, ChecksumFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err.Error()
+	}
+	for _, segment := range r.Segments {
+		sum, err := fileChecksum(segment)
+		if err != nil {
+			return err.Error()
+		}
+		r.Checksums = append(r.Checksums, sum)
+
+		if stored == nil {
+			continue
+		}
+		name := filepath.Base(segment)
+		switch want, ok := stored[name]; {
+		case !ok:
+			return fmt.Sprintf("segment %s has no checksum in %s", name, ChecksumFile)
+		case want != sum:
+			return fmt.Sprintf("segment %s does not match its checksum", name)
+		}
+	}
+	return ""
+}
+
+// readChecksums parses a sha256sum file into hex checksums by file name
+func readChecksums(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		sum, name, ok := strings.Cut(text, " ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s line %d: expected \"<sha256>  <file>\"", ChecksumFile, line)
+		}
+		// sha256sum marks binary mode with a '*' before the name
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		sums[name] = strings.ToLower(sum)
+	}
+	return sums, scanner.Err()
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verify marks renditions of streams that are missing, short, at the wrong
+// resolution or far off their advertised bitrate as unavailable, and logs a
+// line per rendition
+func (c *Content) verify(streams []StreamInfo, logger logging.Logger) {
+	available, total := 0, 0
+	listed := make(map[*Rendition]bool)
+	for i := range streams {
+		s := &streams[i]
+		for j := range s.Bitrates {
+			b := &s.Bitrates[j]
+			r := c.rendition(s.StreamID, b.Quality)
+			if r == nil {
+				r = &Rendition{StreamID: s.StreamID, Quality: b.Quality, Reason: "no segments on disk"}
+				c.renditions[s.StreamID+"/"+b.Quality] = r
+			} else if r.Reason == "" {
+				r.Reason = checkRendition(r, s.Duration, *b)
+			}
+			r.live = s.Duration < 0
+			listed[r] = true
+			b.Available, b.Reason = r.Reason == "", r.Reason
+			total++
+
+			fields := []logging.Field{logging.F("stream_id", s.StreamID), logging.F("quality", b.Quality),
+				logging.F("segments", len(r.Segments)), logging.F("duration", r.Duration()),
+				logging.F("resolution", fmt.Sprintf("%dx%d", r.Width, r.Height)), logging.F("kbps", r.Kbps())}
+			if b.Available {
+				available++
+				logger.Info("Rendition available", fields...)
+			} else {
+				logger.Warn("Rendition unavailable", append(fields, logging.F("reason", r.Reason))...)
+			}
+		}
+	}
+	for _, r := range c.renditions {
+		if !listed[r] {
+			r.Reason = "not in the stream catalog"
+			logger.Warn("Ignoring rendition", logging.F("stream_id", r.StreamID), logging.F("quality", r.Quality),
+				logging.F("reason", r.Reason))
+		}
+	}
+	logger.Info("Verified stream content", logging.F("dir", c.dir), logging.F("available", available),
+		logging.F("renditions", total))
+}
+
+// checkRendition compares a probed rendition with its advertised ladder
+// entry and returns why it can't be served, or ""
+func checkRendition(r *Rendition, duration int, b Bitrate) string {
+	if len(r.Segments) == 0 {
+		return "no segments on disk"
+	}
+	// Live streams (negative duration) loop over whatever is on disk
+	if want := time.Duration(duration) * time.Second; duration >= 0 && (r.Duration() < want-r.chunk || r.Duration() > want+r.chunk) {
+		return fmt.Sprintf("segments cover %v of %v", r.Duration(), want)
+	}
+	if r.Width == 0 {
+		return "no resolution in container metadata"
+	}
+	if got := fmt.Sprintf("%dx%d", r.Width, r.Height); got != b.Resolution {
+		return fmt.Sprintf("resolution %s, expected %s", got, b.Resolution)
+	}
+	if kbps := r.Kbps(); float64(kbps) < float64(b.Bitrate)*(1-bitrateTolerance) || float64(kbps) > float64(b.Bitrate)*(1+bitrateTolerance) {
+		return fmt.Sprintf("estimated bitrate %d kbps, expected %d kbps", kbps, b.Bitrate)
+	}
+	return ""
+}
+
+// probeResolution returns the width and height of the first video track in
+// the MP4 file at path, or zeros
+func probeResolution(path string) (int, int) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	// The moov box sits at the start of init and progressive files
+	data, err := io.ReadAll(io.LimitReader(f, MaxChunkSize))
+	if err != nil {
+		return 0, 0
+	}
+	return findResolution(data, 0)
+}
+
+// findResolution walks MP4 boxes down moov/trak/tkhd. The tkhd box ends with
+// width and height as 16.16 fixed point; audio tracks have zero sizes.
+func findResolution(data []byte, depth int) (int, int) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		typ := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return 0, 0
+			}
+			size, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return 0, 0
+		}
+		body := data[header:size]
+		data = data[size:]
+
+		switch {
+		case typ == "tkhd" && len(body) >= 84:
+			w := binary.BigEndian.Uint32(body[len(body)-8:]) >> 16
+			h := binary.BigEndian.Uint32(body[len(body)-4:]) >> 16
+			if w > 0 && h > 0 {
+				return int(w), int(h)
+			}
+		case (typ == "moov" || typ == "trak") && depth < 2:
+			if w, h := findResolution(body, depth+1); w > 0 {
+				return w, h
+			}
+		}
+	}
+	return 0, 0
+}
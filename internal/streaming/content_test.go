@@ -0,0 +1,85 @@
+package streaming
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSegmentChecksums(t *testing.T) {
+	dir := t.TempDir()
+	stream := filepath.Join(dir, "clip")
+	sum := func(i int) string {
+		h := sha256.Sum256([]byte(fmt.Sprintf("segment %d", i)))
+		return hex.EncodeToString(h[:])
+	}
+	sums := func(quality string, lines ...string) {
+		if err := os.WriteFile(filepath.Join(stream, quality, ChecksumFile), []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, quality := range []string{"low", "medium", "high", "ultra"} {
+		writeSegments(t, stream, quality, 3)
+	}
+	// sha256sum output, in text and binary mode
+	sums("low", sum(0)+"  seg000.m4s", strings.ToUpper(sum(1))+" *seg001.m4s", "", sum(2)+"  seg002.m4s")
+	sums("medium", sum(0)+"  seg000.m4s", sum(0)+"  seg001.m4s", sum(2)+"  seg002.m4s")
+	sums("high", sum(0)+"  seg000.m4s", sum(1)+"  seg001.m4s")
+
+	c, err := LoadContent(dir, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for quality, want := range map[string]string{
+		"low":    "",
+		"medium": "segment seg001.m4s does not match its checksum",
+		"high":   "segment seg002.m4s has no checksum in " + ChecksumFile,
+		"ultra":  "", // hashed without a file to check against
+	} {
+		if r := c.rendition("clip", quality); r == nil || r.Reason != want {
+			t.Errorf("%s: rendition %+v, want reason %q", quality, r, want)
+		}
+	}
+
+	file, checksum, status := c.segment("clip", "low", 1)
+	if status != http.StatusOK || filepath.Base(file) != "seg001.m4s" || checksum != sum(1) {
+		t.Errorf("low chunk 1: %s %s status %d", file, checksum, status)
+	}
+	if _, _, status := c.segment("clip", "medium", 0); status != http.StatusServiceUnavailable {
+		t.Errorf("chunk of a rendition that failed verification: status %d, want 503", status)
+	}
+
+	renditions, ok := c.Checksums("clip")
+	if !ok || len(renditions) != 4 {
+		t.Fatalf("%d renditions, want 4", len(renditions))
+	}
+	for _, r := range renditions {
+		if r.Available != (r.Quality == "low" || r.Quality == "ultra") {
+			t.Errorf("%s: available %v, reason %q", r.Quality, r.Available, r.Reason)
+		}
+		// Hashing stops at the first segment that fails
+		if !r.Available {
+			continue
+		}
+		if len(r.Segments) != 3 || r.Segments[2].SHA256 != sum(2) || r.Segments[2].File != "seg002.m4s" {
+			t.Errorf("%s: segments %+v, want the hashes of the files", r.Quality, r.Segments)
+		}
+	}
+	if _, ok := c.Checksums("other"); ok {
+		t.Error("checksums of a stream not on disk")
+	}
+
+	sums("low", "not a checksum")
+	if c, err = LoadContent(dir, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if r := c.rendition("clip", "low"); !strings.Contains(r.Reason, "line 1") {
+		t.Errorf("malformed checksum file: reason %q", r.Reason)
+	}
+}
@@ -0,0 +1,244 @@
+package streaming
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// DeliveryParam picks how a chunk request is answered. DeliveryReliable,
+// the default, sends the chunk in the response body. DeliveryUnreliable
+// sends delta chunks as HTTP datagrams (RFC 9297) on the request stream
+// instead, split by EncodeFragments, so that a lost packet loses the chunk
+// rather than holding it up for retransmission. Keyframes always come in the
+// body, as does every chunk for a peer that didn't enable HTTP datagrams.
+const DeliveryParam = "delivery"
+
+// Chunk deliveries a request can ask for
+const (
+	DeliveryReliable   = "reliable"
+	DeliveryUnreliable = "unreliable"
+)
+
+// DeliveryHeader tells how the chunk of a response is delivered. The
+// request stream of a DeliveryDatagram response stays open, without a body,
+// until the client cancels reading it or FragmentTimeout passes, as closing
+// it could overtake the last fragments.
+const DeliveryHeader = "X-Chunk-Delivery"
+
+// How the chunk of a response is delivered
+const (
+	DeliveryStream   = "stream"
+	DeliveryDatagram = "datagram"
+)
+
+// MaxFragmentSize bounds a fragment with its header, so that it crosses a
+// minimum QUIC path MTU
+const MaxFragmentSize = 1024
+
+// FragmentTimeout is how long a client waits for the fragments of a chunk
+// before it discards the chunk
+const FragmentTimeout = 2 * time.Second
+
+// fragmentVersion is the first byte of every fragment
+const fragmentVersion = 1
+
+// fragmentHeaderLen is the version byte, the 4-byte chunk index, and the
+// 2-byte index and count of the fragment
+const fragmentHeaderLen = 9
+
+const maxFragmentPayload = MaxFragmentSize - fragmentHeaderLen
+
+// Fragments go out in bursts of fragmentBurst, fragmentGap apart. A peer
+// holds only a few datagrams of a stream that it hasn't taken yet, and drops
+// the ones after, so an unpaced chunk would lose most of its fragments.
+const (
+	fragmentBurst = 16
+	fragmentGap   = time.Millisecond
+)
+
+// Fragment is a piece of a chunk sent as a datagram
+type Fragment struct {
+	Chunk uint32 // index of the chunk
+	Index uint16 // of the fragment in its chunk
+	Count uint16 // fragments in the chunk
+	Data  []byte
+}
+
+// EncodeFragments splits chunk index into fragments of at most
+// MaxFragmentSize bytes: a version byte, the chunk index, the fragment index
+// and the fragment count, big endian, and a slice of data
+func EncodeFragments(index uint32, data []byte) ([][]byte, error) {
+	count := max((len(data)+maxFragmentPayload-1)/maxFragmentPayload, 1)
+	if count > math.MaxUint16 {
+		return nil, fmt.Errorf("chunk of %d bytes needs more than %d fragments", len(data), math.MaxUint16)
+	}
+	fragments := make([][]byte, 0, count)
+	for i := range count {
+		part := data[i*maxFragmentPayload : min((i+1)*maxFragmentPayload, len(data))]
+		b := make([]byte, fragmentHeaderLen, fragmentHeaderLen+len(part))
+		b[0] = fragmentVersion
+		binary.BigEndian.PutUint32(b[1:], index)
+		binary.BigEndian.PutUint16(b[5:], uint16(i))
+		binary.BigEndian.PutUint16(b[7:], uint16(count))
+		fragments = append(fragments, append(b, part...))
+	}
+	return fragments, nil
+}
+
+// DecodeFragment reverses EncodeFragments for one fragment
+func DecodeFragment(b []byte) (Fragment, error) {
+	if len(b) < fragmentHeaderLen {
+		return Fragment{}, errors.New("fragment too short")
+	}
+	if b[0] != fragmentVersion {
+		return Fragment{}, fmt.Errorf("unknown fragment version %d", b[0])
+	}
+	f := Fragment{
+		Chunk: binary.BigEndian.Uint32(b[1:]),
+		Index: binary.BigEndian.Uint16(b[5:]),
+		Count: binary.BigEndian.Uint16(b[7:]),
+		Data:  b[fragmentHeaderLen:],
+	}
+	if f.Index >= f.Count {
+		return Fragment{}, fmt.Errorf("fragment %d of %d", f.Index, f.Count)
+	}
+	return f, nil
+}
+
+// Reassembler puts chunks back together from their fragments, in whatever
+// order they arrive. It isn't safe for concurrent use.
+type Reassembler struct {
+	timeout time.Duration
+	pending map[uint32]*partialChunk
+}
+
+// partialChunk is a chunk still missing fragments
+type partialChunk struct {
+	parts   [][]byte
+	missing int
+	size    int
+	first   time.Time // when the first fragment arrived
+}
+
+// NewReassembler creates a reassembler that discards chunks still
+// incomplete timeout after their first fragment
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{timeout: timeout, pending: make(map[uint32]*partialChunk)}
+}
+
+// Add adds f, received at now, and returns its chunk once every fragment of
+// it arrived. Duplicate fragments are ignored.
+func (r *Reassembler) Add(f Fragment, now time.Time) ([]byte, bool, error) {
+	c, ok := r.pending[f.Chunk]
+	if !ok {
+		c = &partialChunk{parts: make([][]byte, f.Count), missing: int(f.Count), first: now}
+		r.pending[f.Chunk] = c
+	}
+	if int(f.Count) != len(c.parts) {
+		return nil, false, fmt.Errorf("chunk %d has %d fragments, not %d", f.Chunk, len(c.parts), f.Count)
+	}
+	if c.parts[f.Index] != nil {
+		return nil, false, nil
+	}
+	c.parts[f.Index] = f.Data
+	c.missing--
+	c.size += len(f.Data)
+	if c.missing > 0 {
+		return nil, false, nil
+	}
+
+	delete(r.pending, f.Chunk)
+	data := make([]byte, 0, c.size)
+	for _, part := range c.parts {
+		data = append(data, part...)
+	}
+	return data, true, nil
+}
+
+// Expire discards the chunks still incomplete timeout after their first
+// fragment arrived, and returns their indices
+func (r *Reassembler) Expire(now time.Time) []uint32 {
+	var expired []uint32
+	for index, c := range r.pending {
+		if now.Sub(c.first) >= r.timeout {
+			delete(r.pending, index)
+			expired = append(expired, index)
+		}
+	}
+	return expired
+}
+
+// unreliable reports whether r asks for DeliveryUnreliable
+func unreliable(r *http.Request) bool {
+	return r.URL.Query().Get(DeliveryParam) == DeliveryUnreliable
+}
+
+// sendFragments sends chunk index as datagrams on the request stream of w,
+// and returns the bytes of data sent and a func that closes the stream once
+// the client is done with it. It returns false without writing anything if
+// the peer didn't enable HTTP datagrams, e.g. over TCP, or the connection is
+// already gone. The request stream, which datagrams are sent on, only comes
+// with the response header, so everything that decides whether a datagram
+// can be sent is checked before the header goes out. If the first fragment
+// fails all the same, the connection closed in between, and the stream is
+// reset rather than answered in a body the header doesn't announce.
+func (h *Handler) sendFragments(w http.ResponseWriter, r *http.Request, index int, data []byte) (int, func(), bool) {
+	hijacker, ok := w.(http3.Hijacker)
+	if !ok {
+		return 0, nil, false
+	}
+	conn := hijacker.Connection()
+	select {
+	case <-conn.ReceivedSettings():
+	case <-r.Context().Done():
+		return 0, nil, false
+	}
+	if !conn.Settings().EnableDatagrams || !conn.ConnectionState().SupportsDatagrams || conn.Context().Err() != nil {
+		return 0, nil, false
+	}
+	fragments, err := EncodeFragments(uint32(index), data)
+	if err != nil {
+		return 0, nil, false
+	}
+
+	w.Header().Set(DeliveryHeader, DeliveryDatagram)
+	w.WriteHeader(http.StatusOK)
+	str := w.(http3.HTTPStreamer).HTTPStream()
+
+	sent := 0
+	for i, b := range fragments {
+		if i > 0 && i%fragmentBurst == 0 {
+			select {
+			case <-h.clock.After(fragmentGap):
+			case <-r.Context().Done():
+			}
+		}
+		if err := str.SendDatagram(b); err != nil {
+			h.metrics.fragments.WithLabelValues("failed").Add(float64(len(fragments) - i))
+			if i == 0 {
+				h.metrics.deliveries.WithLabelValues("failed").Inc()
+				str.CancelWrite(quic.StreamErrorCode(http3.ErrCodeInternalError))
+				return 0, func() {}, true
+			}
+			break
+		}
+		h.metrics.fragments.WithLabelValues("sent").Inc()
+		sent += len(b) - fragmentHeaderLen
+	}
+	h.metrics.deliveries.WithLabelValues(DeliveryDatagram).Inc()
+
+	return sent, func() {
+		select {
+		case <-r.Context().Done():
+		case <-h.clock.After(FragmentTimeout):
+		}
+		str.Close()
+	}, true
+}
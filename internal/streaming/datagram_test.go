@@ -0,0 +1,100 @@
+package streaming
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestReassemblerOutOfOrder(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300)
+	encoded, err := EncodeFragments(7, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encoded) != 3 {
+		t.Fatalf("%d bytes split into %d fragments, want 3", len(data), len(encoded))
+	}
+	for _, b := range encoded {
+		if len(b) > MaxFragmentSize {
+			t.Errorf("fragment of %d bytes", len(b))
+		}
+	}
+
+	r := NewReassembler(time.Second)
+	now := time.Unix(0, 0)
+	// The duplicate of fragment 2 is ignored
+	for _, index := range []int{2, 0, 2} {
+		f, err := DecodeFragment(encoded[index])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, done, err := r.Add(f, now); done || err != nil {
+			t.Fatalf("fragment %d completed the chunk: %v, %v", index, done, err)
+		}
+	}
+	f, _ := DecodeFragment(encoded[1])
+	got, done, err := r.Add(f, now)
+	if !done || err != nil || !bytes.Equal(got, data) {
+		t.Errorf("reassembled %d bytes, %v, %v, want the chunk", len(got), done, err)
+	}
+	if expired := r.Expire(now.Add(time.Hour)); len(expired) != 0 {
+		t.Errorf("expired %v after the chunk completed", expired)
+	}
+}
+
+func TestReassemblerExpiresIncompleteChunks(t *testing.T) {
+	r := NewReassembler(time.Second)
+	now := time.Unix(0, 0)
+	r.Add(Fragment{Chunk: 1, Index: 0, Count: 2}, now)
+	r.Add(Fragment{Chunk: 2, Index: 0, Count: 2}, now.Add(500*time.Millisecond))
+
+	if expired := r.Expire(now.Add(999 * time.Millisecond)); len(expired) != 0 {
+		t.Errorf("expired %v before the timeout", expired)
+	}
+	if expired := r.Expire(now.Add(time.Second)); len(expired) != 1 || expired[0] != 1 {
+		t.Errorf("expired %v, want chunk 1", expired)
+	}
+	// The rest of an expired chunk starts it over
+	if _, done, _ := r.Add(Fragment{Chunk: 1, Index: 1, Count: 2}, now.Add(time.Second)); done {
+		t.Error("expired chunk completed")
+	}
+}
+
+// TestUnreliableDeliveryOverTCP checks that a peer without HTTP datagrams
+// gets delta chunks in the body
+func TestUnreliableDeliveryOverTCP(t *testing.T) {
+	reg := metrics.NewRegistry()
+	server := httptest.NewServer(NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg)))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/stream/chunk/stream_001?quality=low&chunk=11&" + DeliveryParam + "=" + DeliveryUnreliable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(DeliveryHeader) != DeliveryStream || body.Len() == 0 {
+		t.Errorf("status %d, delivery %q, %d bytes, want the chunk in the body", resp.StatusCode, resp.Header.Get(DeliveryHeader), body.Len())
+	}
+	if err := VerifyChunk(body.Bytes(), resp.Header.Get(CRCHeader)); err != nil {
+		t.Error(err)
+	}
+
+	resp, err = http.Get(server.URL + "/stream/chunk/stream_001?quality=low&chunk=11&" + DeliveryParam + "=lossy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown delivery: status %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,65 @@
+package streaming
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrainEndsLiveSessions(t *testing.T) {
+	const peer = "standby.example:4433"
+	f := newLiveFixture(t, WithDrainPeer(peer))
+	viewers := []*liveViewer{f.watch(t, "quality=low"), f.watch(t, "quality=high")}
+	viewers[0].frame()
+
+	drained := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		drained <- f.handler.Drain(ctx)
+	}()
+	for i, v := range viewers {
+		var last map[string]interface{}
+		for event := range v.events {
+			last = event
+		}
+		if last["type"] != "eos" || last["reason"] != EOSServerShutdown || last["reconnect"] != peer {
+			t.Errorf("viewer %d: last event %v, want eos for shutdown with the peer", i, last)
+		}
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("drain: %v", err)
+	}
+
+	resp, err := http.Get(f.server.URL + "/stream/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(ReconnectHeader) != peer {
+		t.Errorf("live request while draining: status %d, %s %q", resp.StatusCode, ReconnectHeader, resp.Header.Get(ReconnectHeader))
+	}
+	// Draining again returns at once
+	if err := f.handler.Drain(context.Background()); err != nil {
+		t.Errorf("second drain: %v", err)
+	}
+}
+
+func TestLiveSessionEndsComplete(t *testing.T) {
+	f := newLiveFixture(t)
+	v := f.watch(t, "quality=low")
+	for want := 0; want < liveFrames; want++ {
+		if got := v.frame(); got != want {
+			t.Fatalf("frame %d, want %d", got, want)
+		}
+	}
+	select {
+	case event := <-v.events:
+		if event["type"] != "eos" || event["reason"] != EOSComplete {
+			t.Errorf("event %v after the last frame, want eos complete", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no event after the last frame")
+	}
+}
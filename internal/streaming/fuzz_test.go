@@ -0,0 +1,80 @@
+package streaming
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func FuzzReadIngestFrame(f *testing.F) {
+	var stream bytes.Buffer
+	WriteIngestFrame(&stream, IngestFrame{Index: 0, Quality: 2, Keyframe: true, Data: []byte("keyframe")})
+	WriteIngestFrame(&stream, IngestFrame{Index: 1, Quality: 2, Data: []byte("delta")})
+	f.Add(stream.Bytes())
+	f.Add(stream.Bytes()[:ingestFrameHeaderLen+3])
+	// A header announcing a chunk far larger than the body
+	f.Add([]byte{ingestFrameVersion, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := bytes.NewReader(b)
+		for {
+			frame, err := ReadIngestFrame(r)
+			if err != nil {
+				if errors.Is(err, io.EOF) && r.Len() != 0 {
+					t.Fatalf("io.EOF with %d bytes left", r.Len())
+				}
+				return
+			}
+			if len(frame.Data) > MaxChunkSize {
+				t.Fatalf("read a chunk of %d bytes", len(frame.Data))
+			}
+			var again bytes.Buffer
+			if err := WriteIngestFrame(&again, frame); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := ReadIngestFrame(&again); err != nil || got.Index != frame.Index || !bytes.Equal(got.Data, frame.Data) {
+				t.Fatalf("frame %d does not survive a round trip: %+v, %v", frame.Index, got, err)
+			}
+		}
+	})
+}
+
+func FuzzDecodeFragment(f *testing.F) {
+	fragments, err := EncodeFragments(3, bytes.Repeat([]byte("chunk"), 500))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, b := range fragments {
+		f.Add(b)
+	}
+	f.Add([]byte{fragmentVersion, 0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{fragmentVersion, 0, 0, 0, 0, 0xff, 0xff, 0, 1})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		frag, err := DecodeFragment(b)
+		if err != nil {
+			return
+		}
+		if frag.Index >= frag.Count {
+			t.Fatalf("decoded fragment %d of %d", frag.Index, frag.Count)
+		}
+		// The reassembler takes any decoded fragment, alone or next to a
+		// fragment of the same chunk with another count
+		r := NewReassembler(time.Second)
+		now := time.Unix(0, 0)
+		data, done, err := r.Add(frag, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != (frag.Count == 1) || (done && !bytes.Equal(data, frag.Data)) {
+			t.Fatalf("fragment %d of %d completed the chunk: %v", frag.Index, frag.Count, done)
+		}
+		other := Fragment{Chunk: frag.Chunk, Index: 0, Count: frag.Count + 1}
+		if frag.Count < 0xffff && !done {
+			if _, _, err := r.Add(other, now); err == nil {
+				t.Fatal("fragment with a different count accepted")
+			}
+		}
+		r.Expire(now.Add(time.Second))
+	})
+}
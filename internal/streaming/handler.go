@@ -1,26 +1,46 @@
 package streaming
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/impair"
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // StreamInfo represents video stream metadata
 type StreamInfo struct {
-	StreamID    string    `json:"stream_id"`
-	Title       string    `json:"title"`
-	Duration    int       `json:"duration"` // seconds
-	Bitrates    []Bitrate `json:"bitrates"`
-	Format      string    `json:"format"`
-	Resolution  string    `json:"resolution"`
-	FrameRate   int       `json:"frame_rate"`
-	CreatedAt   time.Time `json:"created_at"`
+	StreamID      string    `json:"stream_id"`
+	Title         string    `json:"title"`
+	Duration      int       `json:"duration"` // seconds
+	Bitrates      []Bitrate `json:"bitrates"`
+	Format        string    `json:"format"`
+	Resolution    string    `json:"resolution"`
+	FrameRate     int       `json:"frame_rate"`
+	ChunkDuration int       `json:"chunk_duration"` // milliseconds of playback per chunk
+	CreatedAt     time.Time `json:"created_at"`
+	Seeded        bool      `json:"seeded,omitempty"`      // synthetic entry from WithSeededStreams
+	Live          bool      `json:"live,omitempty"`        // pushed into the server at /stream/ingest
+	StartChunk    int       `json:"start_chunk,omitempty"` // latest keyframe of a live stream, where viewers joining start
 }
 
 // Bitrate represents different quality levels
@@ -28,166 +48,444 @@ type Bitrate struct {
 	Quality    string `json:"quality"`    // "low", "medium", "high", "ultra"
 	Bitrate    int    `json:"bitrate"`    // kbps
 	Resolution string `json:"resolution"` // e.g., "1920x1080"
+	FrameRate  int    `json:"frame_rate,omitempty"`
 	URL        string `json:"url"`
+	Available  bool   `json:"available"`
+	Reason     string `json:"reason,omitempty"` // why the rendition is unavailable
+}
+
+// rendition describes an available quality of streamID
+func rendition(streamID, quality string, kbps int, resolution string) Bitrate {
+	return Bitrate{
+		Quality:    quality,
+		Bitrate:    kbps,
+		Resolution: resolution,
+		URL:        fmt.Sprintf("/stream/chunk/%s?quality=%s", streamID, quality),
+		Available:  true,
+	}
 }
 
+// MaxChunkSize is the largest video payload the server generates, whatever
+// the bitrate of the quality
+const MaxChunkSize = 1000000
+
+// MaxChunkMessageSize bounds any chunk response, including the JSON form
+// with a base64 payload
+const MaxChunkMessageSize = MaxChunkSize*4/3 + 4096
+
 // StreamChunk represents a video chunk
 type StreamChunk struct {
-	StreamID    string `json:"stream_id"`
-	ChunkIndex  int    `json:"chunk_index"`
-	Quality     string `json:"quality"`
-	Data        []byte `json:"data,omitempty"`
-	Size        int    `json:"size"`
-	Duration    int    `json:"duration"` // milliseconds
-	Timestamp   int64  `json:"timestamp"`
-	IsKeyFrame  bool   `json:"is_keyframe"`
+	StreamID   string `json:"stream_id"`
+	ChunkIndex int    `json:"chunk_index"`
+	Quality    string `json:"quality"`
+	Data       []byte `json:"data,omitempty"`
+	Size       int    `json:"size"`
+	Duration   int    `json:"duration"` // milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	IsKeyFrame bool   `json:"is_keyframe"`
 }
 
-// StreamStats represents streaming statistics
+// StreamStats represents streaming statistics, measured since the server
+// started. Fields that nothing was measured for are null.
 type StreamStats struct {
-	StreamID      string  `json:"stream_id"`
-	BytesSent     int64   `json:"bytes_sent"`
-	ChunksSent    int     `json:"chunks_sent"`
-	Latency       float64 `json:"latency_ms"`
-	Bandwidth     float64 `json:"bandwidth_mbps"`
-	PacketLoss    float64 `json:"packet_loss_percent"`
-	ActiveClients int     `json:"active_clients"`
-	Uptime        int64   `json:"uptime_seconds"`
+	StreamID         string   `json:"stream_id"`
+	BytesSent        int64    `json:"bytes_sent"`
+	ChunksSent       int      `json:"chunks_sent"`
+	ChecksumFailures int      `json:"checksum_failures"`     // chunks the viewers reported corrupt
+	Latency          *float64 `json:"latency_ms"`            // mean time a chunk write blocked
+	Bandwidth        *float64 `json:"bandwidth_mbps"`        // bytes sent over the time writing them
+	RTT              *float64 `json:"rtt_ms"`                // mean of the viewers, from their QUIC connection or else their reports
+	PacketLoss       *float64 `json:"packet_loss_percent"`   // of the viewers' QUIC connections
+	BufferHealth     *float64 `json:"buffer_health_seconds"` // mean buffer reported by the viewers
+	ActiveClients    *int     `json:"active_clients"`        // viewers of the shared source
+	Uptime           *int64   `json:"uptime_seconds"`        // since the first chunk was sent
+
+	// Set while the stream has a shared source
+	Source  *SourceStats  `json:"source,omitempty"`
+	Viewers []ViewerStats `json:"viewers,omitempty"`
 }
 
 // Handler handles video streaming HTTP/3 requests
-func Handler(w http.ResponseWriter, r *http.Request) {
+type Handler struct {
+	logger    logging.Logger
+	tracer    trace.Tracer
+	metrics   handlerMetrics
+	quotas    *quota.Manager
+	clock     clock.Clock
+	impair    *impair.Registry
+	content   *Content // nil serves generated chunks
+	timings   *Timelines
+	abr       *Adapter       // nil refuses playback reports
+	resume    *Resumption    // nil starts every live session afresh
+	pace      *Pacer         // nil writes chunks at once
+	broadcast *Broadcast     // nil produces every chunk for each request
+	ingest    *Ingest        // nil refuses live ingest
+	hls       *HLS           // nil refuses HLS requests
+	admission *Admission     // nil streams to every viewer
+	registry  *Catalog       // nil accepts any stream ID
+	paths     *quiclib.Paths // nil measures no connections
+	meters    *streamMeters
+	seed      seedConfig
+	qualities []config.QualityConfig // the ladder, ascending bitrates
+	chunk     time.Duration          // playback time of a chunk
+
+	keyframes *keyframeGate // in flight for sessions that prioritize them
+	switches  *switches     // qualities picked by the viewers
+
+	streams []StreamInfo   // catalog served by /stream/list
+	seeded  map[string]int // index in streams of each seeded stream
+
+	drainPeer string // reconnect hint sent to live viewers on shutdown
+	liveMu    sync.Mutex
+	live      sync.WaitGroup // live sessions in progress
+	draining  chan struct{}  // closed once Drain is called
+	drained   bool
+}
+
+// Option configures a Handler
+type Option func(*Handler)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(h *Handler) {
+		h.clock = c
+	}
+}
+
+type handlerMetrics struct {
+	requests   *metrics.CounterVec
+	chunksSent *metrics.CounterVec
+	bytesSent  *metrics.CounterVec
+	fragments  *metrics.CounterVec
+	deliveries *metrics.CounterVec
+}
+
+// WithImpairments degrades chunks of the sessions that have a profile in reg
+func WithImpairments(reg *impair.Registry) Option {
+	return func(h *Handler) {
+		h.impair = reg
+	}
+}
+
+// WithContent serves chunks from segments on disk. The advertised ladder is
+// verified against content when the handler is created, and renditions that
+// don't match are listed as unavailable and refused.
+func WithContent(content *Content) Option {
+	return func(h *Handler) {
+		h.content = content
+	}
+}
+
+// WithTimelines records the send timeline of every chunk in t
+func WithTimelines(t *Timelines) Option {
+	return func(h *Handler) {
+		h.timings = t
+	}
+}
+
+// WithDrainPeer tells live viewers to reconnect to addr when the server shuts
+// down
+func WithDrainPeer(addr string) Option {
+	return func(h *Handler) {
+		h.drainPeer = addr
+	}
+}
+
+// NewHandler creates a new streaming handler
+func NewHandler(logger logging.Logger, reg *metrics.Registry, quotas *quota.Manager, opts ...Option) *Handler {
+	h := &Handler{
+		logger:    logger,
+		tracer:    tracing.Tracer("streaming"),
+		quotas:    quotas,
+		clock:     clock.Real(),
+		draining:  make(chan struct{}),
+		keyframes: newKeyframeGate(reg),
+		switches:  newSwitches(reg),
+		meters:    newStreamMeters(),
+		qualities: config.Default().Streaming.Ladder,
+		chunk:     config.Default().Streaming.ChunkDuration,
+		metrics: handlerMetrics{
+			requests:   reg.CounterVec("streaming", "requests_total", "Streaming API requests by endpoint", "endpoint"),
+			chunksSent: reg.CounterVec("streaming", "chunks_sent_total", "Video chunks sent by quality", "quality"),
+			bytesSent:  reg.CounterVec("streaming", "bytes_sent_total", "Video bytes sent by quality", "quality"),
+			fragments:  reg.CounterVec("streaming", "datagram_fragments_total", "Chunk fragments sent as datagrams by result", "result"),
+			deliveries: reg.CounterVec("streaming", "unreliable_deliveries_total", "Chunks requested with unreliable delivery by how they were delivered", "delivery"),
+		},
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.streams = h.catalog(h.clock.Now())
+	// Seeded streams are generated, so content only covers the built-in ones
+	if h.content != nil {
+		h.content.verify(h.streams, h.logger)
+	}
+	h.seeded = make(map[string]int)
+	for _, s := range h.seedCatalog(h.clock.Now()) {
+		h.seeded[s.StreamID] = len(h.streams)
+		h.streams = append(h.streams, s)
+	}
+	if len(h.seeded) > 0 {
+		h.logger.Info("Seeded stream catalog", logging.F("streams", len(h.seeded)))
+	}
+	if h.registry != nil {
+		h.registry.reserve(h.streams)
+	}
+	return h
+}
+
+// ServeHTTP routes streaming requests to the matching endpoint
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Parse the URL path
 	path := strings.TrimPrefix(r.URL.Path, "/stream/")
 	parts := strings.Split(path, "/")
-	
+
 	if len(parts) == 0 {
 		http.Error(w, "Invalid streaming endpoint", http.StatusBadRequest)
 		return
 	}
 
+	ctx := tracing.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := h.tracer.Start(ctx, "streaming."+parts[0], trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+	r = r.WithContext(ctx)
+	h.metrics.requests.WithLabelValues(parts[0]).Inc()
+	// Endpoints of a stream refuse those the catalog doesn't have
+	switch parts[0] {
+	case "info", "chunk", "stats", "report", "quality", "hls":
+		if len(parts) >= 2 && !h.known(parts[1]) {
+			h.unknownStream(w, parts[1])
+			return
+		}
+	}
+
 	switch parts[0] {
 	case "list":
-		handleStreamList(w, r)
+		h.handleStreamList(w, r)
 	case "info":
 		if len(parts) < 2 {
 			http.Error(w, "Stream ID required", http.StatusBadRequest)
 			return
 		}
-		handleStreamInfo(w, r, parts[1])
+		h.handleStreamInfo(w, r, parts[1])
 	case "chunk":
 		if len(parts) < 2 {
 			http.Error(w, "Stream ID required", http.StatusBadRequest)
 			return
 		}
-		handleStreamChunk(w, r, parts[1])
+		h.handleStreamChunk(w, r, parts[1])
 	case "stats":
 		if len(parts) < 2 {
 			http.Error(w, "Stream ID required", http.StatusBadRequest)
 			return
 		}
-		handleStreamStats(w, r, parts[1])
+		h.handleStreamStats(w, r, parts[1])
 	case "live":
-		handleLiveStream(w, r)
+		h.handleLiveStream(w, r)
+	case "report":
+		if len(parts) < 2 {
+			http.Error(w, "Stream ID required", http.StatusBadRequest)
+			return
+		}
+		h.handleReport(w, r, parts[1])
+	case "quality":
+		if len(parts) < 2 {
+			http.Error(w, "Stream ID required", http.StatusBadRequest)
+			return
+		}
+		h.handleQualityChange(w, r, parts[1])
+	case "cap":
+		h.handleCap(w, r)
+	case "ingest":
+		if len(parts) < 2 {
+			http.Error(w, "Stream ID required", http.StatusBadRequest)
+			return
+		}
+		h.handleIngest(w, r, parts[1])
+	case "hls":
+		if len(parts) < 3 {
+			http.Error(w, "Stream ID and playlist required", http.StatusBadRequest)
+			return
+		}
+		h.handleHLS(w, r, parts[1], parts[2:])
 	default:
 		http.Error(w, "Unknown streaming endpoint", http.StatusNotFound)
 	}
 }
 
-func handleStreamList(w http.ResponseWriter, r *http.Request) {
-	streams := []StreamInfo{
-		{
-			StreamID: "stream_001",
-			Title:    "Sample Video 1",
-			Duration: 120,
-			Bitrates: []Bitrate{
-				{"low", 500, "640x360", "/stream/chunk/stream_001?quality=low"},
-				{"medium", 1500, "1280x720", "/stream/chunk/stream_001?quality=medium"},
-				{"high", 3000, "1920x1080", "/stream/chunk/stream_001?quality=high"},
-				{"ultra", 6000, "3840x2160", "/stream/chunk/stream_001?quality=ultra"},
-			},
-			Format:    "h264",
-			Resolution: "1920x1080",
-			FrameRate: 30,
-			CreatedAt: time.Now().Add(-time.Hour),
-		},
+// catalog returns the streams offered by the server, created relative to
+// now. The sample video offers the whole ladder; the camera has its own.
+func (h *Handler) catalog(now time.Time) []StreamInfo {
+	sample := StreamInfo{
+		StreamID:  "stream_001",
+		Title:     "Sample Video 1",
+		Duration:  120,
+		Format:    "h264",
+		CreatedAt: now.Add(-time.Hour),
+	}
+	h.offer(&sample, nil)
+	return []StreamInfo{
+		sample,
 		{
 			StreamID: "stream_002",
 			Title:    "Live Camera Feed",
 			Duration: -1, // Live stream
 			Bitrates: []Bitrate{
-				{"low", 300, "480x270", "/stream/chunk/stream_002?quality=low"},
-				{"medium", 800, "854x480", "/stream/chunk/stream_002?quality=medium"},
-				{"high", 1500, "1280x720", "/stream/chunk/stream_002?quality=high"},
+				rendition("stream_002", "low", 300, "480x270"),
+				rendition("stream_002", "medium", 800, "854x480"),
+				rendition("stream_002", "high", 1500, "1280x720"),
 			},
-			Format:    "h264",
-			Resolution: "1280x720",
-			FrameRate: 25,
-			CreatedAt: time.Now().Add(-10 * time.Minute),
+			Format:        "h264",
+			Resolution:    "1280x720",
+			FrameRate:     25,
+			ChunkDuration: int(h.chunk / time.Millisecond),
+			CreatedAt:     now.Add(-10 * time.Minute),
 		},
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
+}
+
+// listed returns the catalog with the registered streams and the live
+// streams being ingested
+func (h *Handler) listed() []StreamInfo {
+	live := h.ingest.list()
+	registered := h.registry.entries()
+	if len(live) == 0 && len(registered) == 0 {
+		return h.streams
+	}
+	streams := append(slices.Clip(h.streams), live...)
+	for _, s := range registered {
+		if !slices.ContainsFunc(live, func(l StreamInfo) bool { return l.StreamID == s.meta.StreamID }) {
+			streams = append(streams, h.registeredInfo(s))
+		}
+	}
+	return streams
+}
+
+func (h *Handler) handleStreamList(w http.ResponseWriter, r *http.Request) {
+	streams := h.listed()
+
+	resp := map[string]interface{}{
 		"streams": streams,
 		"count":   len(streams),
-	})
+	}
+	if util, ok := h.admission.Utilization(); ok {
+		resp["capacity"] = util
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
 }
 
-func handleStreamInfo(w http.ResponseWriter, r *http.Request, streamID string) {
-	// Simulate stream info retrieval
-	stream := StreamInfo{
-		StreamID: streamID,
-		Title:    fmt.Sprintf("Stream %s", streamID),
-		Duration: 300,
-		Bitrates: []Bitrate{
-			{"low", 500, "640x360", fmt.Sprintf("/stream/chunk/%s?quality=low", streamID)},
-			{"medium", 1500, "1280x720", fmt.Sprintf("/stream/chunk/%s?quality=medium", streamID)},
-			{"high", 3000, "1920x1080", fmt.Sprintf("/stream/chunk/%s?quality=high", streamID)},
-		},
-		Format:    "h264",
-		Resolution: "1920x1080",
-		FrameRate: 30,
-		CreatedAt: time.Now().Add(-time.Hour),
+func (h *Handler) handleStreamInfo(w http.ResponseWriter, r *http.Request, streamID string) {
+	for _, stream := range h.listed() {
+		if stream.StreamID == streamID {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(stream)
+			return
+		}
 	}
-	
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stream)
+	h.unknownStream(w, streamID)
 }
 
-func handleStreamChunk(w http.ResponseWriter, r *http.Request, streamID string) {
+// lastChunk reports whether index is the last chunk of streamID at quality.
+// Streams without an end have none.
+func (h *Handler) lastChunk(streamID, quality string, index int) bool {
+	for _, s := range h.listed() {
+		if s.StreamID == streamID {
+			total := h.streamChunks(s, quality)
+			return total >= 0 && index >= total-1
+		}
+	}
+	return false
+}
+
+func (h *Handler) handleStreamChunk(w http.ResponseWriter, r *http.Request, streamID string) {
+	received := h.clock.Now()
 	quality := r.URL.Query().Get("quality")
 	if quality == "" {
 		quality = "medium"
 	}
-	
+
 	chunkIndex := 0
 	if idx := r.URL.Query().Get("chunk"); idx != "" {
 		if i, err := strconv.Atoi(idx); err == nil {
 			chunkIndex = i
 		}
 	}
-	
-	// Simulate video chunk generation
-	chunkSize := getChunkSize(quality)
+	if d := r.URL.Query().Get(DeliveryParam); d != "" && d != DeliveryReliable && d != DeliveryUnreliable {
+		http.Error(w, fmt.Sprintf("Unknown delivery %q", d), http.StatusBadRequest)
+		return
+	}
+	capKbps, ok := requestedCap(r)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid %s %q", CapParam, r.URL.Query().Get(CapParam)), http.StatusBadRequest)
+		return
+	}
+
+	// Refuse renditions that failed verification before charging the session
+	src, status, msg := h.locate(streamID, quality, chunkIndex)
+	if status != http.StatusOK {
+		http.Error(w, msg, status)
+		return
+	}
+	session := quota.SessionKey(r)
+	// The quality the viewer switched to replaces the one asked for
+	if pinned, ok := h.switches.apply(streamID, session, chunkIndex, h.role(src, chunkIndex) == RoleKeyframe); ok && pinned != quality {
+		quality = pinned
+		if src, status, msg = h.locate(streamID, quality, chunkIndex); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	if !h.quotas.CheckSession(w, r) {
+		return
+	}
+	if ok, retry := h.admission.admit(streamID, session, quality); !ok {
+		h.admission.reject(w, retry)
+		return
+	}
+	if h.lastChunk(streamID, quality, chunkIndex) {
+		defer h.admission.leave(streamID, session)
+	}
+	if capKbps >= 0 && h.pace != nil {
+		h.pace.SetCap(session, capKbps)
+	}
+
+	// Produce the chunk once for all viewers of the stream
+	data, err := h.broadcast.chunk(r.Context(), streamID, session, quality, chunkIndex, func() ([]byte, error) {
+		return h.produce(src, quality, chunkIndex)
+	})
+	if err != nil {
+		h.chunkError(w, r, src, streamID, quality, chunkIndex, err)
+		return
+	}
+	chunkSize := len(data)
+	role := h.role(src, chunkIndex)
 	chunk := StreamChunk{
 		StreamID:   streamID,
 		ChunkIndex: chunkIndex,
 		Quality:    quality,
-		Data:       generateVideoData(chunkSize),
+		Data:       data,
 		Size:       chunkSize,
-		Duration:   2000, // 2 seconds
-		Timestamp:  time.Now().UnixMilli(),
-		IsKeyFrame: chunkIndex%10 == 0, // Every 10th chunk is a keyframe
+		Duration:   int(h.chunk / time.Millisecond),
+		Timestamp:  h.clock.Now().UnixMilli(),
+		IsKeyFrame: role == RoleKeyframe,
 	}
-	
+
 	// Set appropriate headers for video streaming
 	w.Header().Set("Content-Type", "video/mp4")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("X-Stream-ID", streamID)
 	w.Header().Set("X-Chunk-Index", strconv.Itoa(chunkIndex))
+	w.Header().Set(CRCHeader, ChunkCRC(data))
 	w.Header().Set("X-Quality", quality)
-	
+	w.Header().Set(RoleHeader, role)
+	w.Header().Set(DeliveryHeader, DeliveryStream)
+	w.Header().Set(health.ServerTimeHeader, strconv.FormatInt(received.UnixNano(), 10))
+	if src.checksum != "" {
+		w.Header().Set(ChecksumHeader, src.checksum)
+	}
+
 	// For JSON response (metadata)
 	if r.Header.Get("Accept") == "application/json" {
 		w.Header().Set("Content-Type", "application/json")
@@ -195,77 +493,282 @@ func handleStreamChunk(w http.ResponseWriter, r *http.Request, streamID string)
 		json.NewEncoder(w).Encode(chunk)
 		return
 	}
-	
+
 	// Return binary video data
-	w.Write(chunk.Data)
-	
-	log.Printf("Served chunk %d for stream %s (quality: %s, size: %d bytes)", 
-		chunkIndex, streamID, quality, chunkSize)
+	_, span := h.tracer.Start(r.Context(), "streaming.send_chunk",
+		trace.WithAttributes(tracing.String("stream_id", streamID), tracing.String("quality", quality),
+			tracing.Int("chunk", chunkIndex), tracing.Int("size", chunkSize)))
+	// Deltas yield and chunks are paced under the impairment, which
+	// throttles whatever they write
+	var out http.ResponseWriter = w
+	if prioritized(r) {
+		if chunk.IsKeyFrame {
+			done := h.keyframes.begin(session)
+			defer done()
+		} else {
+			out = h.keyframes.deltas(r.Context(), w, session, h.clock)
+		}
+	}
+	out = h.pace.pace(r.Context(), out, session, chunkSize, h.chunkInterval(r))
+	out, drop := h.impair.Apply(out, r, session)
+	if drop {
+		span.End()
+		h.logger.Debug("Dropped chunk by impairment", logging.F("session", session), logging.F("chunk", chunkIndex))
+		// Reset the stream without a response, like a lost chunk
+		panic(http.ErrAbortHandler)
+	}
+	writeStart := h.clock.Now()
+	var n int
+	delivered := false
+	if unreliable(r) && !chunk.IsKeyFrame {
+		var linger func()
+		if n, linger, delivered = h.sendFragments(w, r, chunkIndex, chunk.Data); delivered {
+			defer linger()
+		}
+	}
+	if !delivered {
+		if unreliable(r) {
+			h.metrics.deliveries.WithLabelValues(DeliveryStream).Inc()
+		}
+		if n, err = out.Write(chunk.Data); err != nil {
+			tracing.RecordError(span, err)
+		}
+		// The write is only complete once it has left the response buffer
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	span.End()
+	writeEnd := h.clock.Now()
+	h.timings.record(session, chunkIndex, quality, n, h.chunkInterval(r), received, writeStart, writeEnd)
+	path, measured := h.paths.Path(r.RemoteAddr)
+	h.meters.sent(streamID, session, 1, n, writeEnd.Sub(writeStart), path, measured, writeEnd)
+	h.quotas.ChargeSession(r, int64(n))
+	h.broadcast.sent(streamID, session, quality, n)
+	h.metrics.chunksSent.WithLabelValues(quality).Inc()
+	h.metrics.bytesSent.WithLabelValues(quality).Add(float64(n))
+
+	h.logger.Debug("Served chunk", logging.F("stream_id", streamID), logging.F("chunk", chunkIndex),
+		logging.F("quality", quality), logging.F("size", chunkSize))
 }
 
-func handleStreamStats(w http.ResponseWriter, r *http.Request, streamID string) {
-	stats := StreamStats{
-		StreamID:      streamID,
-		BytesSent:     int64(rand.Intn(1000000000)), // Random bytes sent
-		ChunksSent:    rand.Intn(10000),
-		Latency:       float64(rand.Intn(100)) + rand.Float64(),
-		Bandwidth:     float64(rand.Intn(50)) + rand.Float64(),
-		PacketLoss:    rand.Float64() * 5, // 0-5% packet loss
-		ActiveClients: rand.Intn(100),
-		Uptime:        int64(rand.Intn(86400)), // Up to 24 hours
-	}
-	
+// chunkSource is where a chunk comes from: a live stream being ingested, a
+// segment on disk, or generated data of seedSize bytes, or of the size of
+// its quality without either
+type chunkSource struct {
+	live     *liveSource
+	segment  string
+	checksum string
+	seedSize int
+}
+
+// locate returns the source of chunk index of streamID at quality, or the
+// status and message to refuse it with, e.g. because its rendition failed
+// verification
+func (h *Handler) locate(streamID, quality string, index int) (chunkSource, int, string) {
+	var src chunkSource
+	if src.live = h.ingest.source(streamID); src.live != nil {
+		if !slices.Contains(src.live.qualities, quality) {
+			return src, http.StatusNotFound, fmt.Sprintf("Rendition %s of stream %s not found", quality, streamID)
+		}
+	} else if i, ok := h.seeded[streamID]; ok {
+		var status int
+		var msg string
+		if src.seedSize, status, msg = seededChunkSize(&h.streams[i], i, quality, index, h.chunk); status != http.StatusOK {
+			return src, status, msg
+		}
+	} else if s := h.registry.entry(streamID); s != nil {
+		return h.locateRegistered(s, quality, index)
+	} else if h.content != nil {
+		var status int
+		if src.segment, src.checksum, status = h.content.segment(streamID, quality, index); status != http.StatusOK {
+			msg := fmt.Sprintf("Chunk %d of stream %s at quality %s not found", index, streamID, quality)
+			if rend := h.content.rendition(streamID, quality); rend != nil && rend.Reason != "" {
+				msg = fmt.Sprintf("Rendition %s of stream %s unavailable: %s", quality, streamID, rend.Reason)
+			}
+			return src, status, msg
+		}
+	}
+	return src, http.StatusOK, ""
+}
+
+// produce serves the segment of src from disk, relays it from the ingest,
+// or simulates video chunk generation
+func (h *Handler) produce(src chunkSource, quality string, index int) ([]byte, error) {
+	if src.live != nil {
+		data, _, err := h.ingest.chunk(src.live, quality, index)
+		return data, err
+	} else if src.segment != "" {
+		return os.ReadFile(src.segment)
+	} else if src.seedSize > 0 {
+		return generateVideoData(src.seedSize), nil
+	}
+	return generateVideoData(h.generatedChunkSize(quality)), nil
+}
+
+// chunkError answers a request for a chunk of src that couldn't be produced
+func (h *Handler) chunkError(w http.ResponseWriter, r *http.Request, src chunkSource, streamID, quality string, index int, err error) {
+	if r.Context().Err() != nil {
+		// The request ended while another one produced the chunk
+		return
+	}
+	if errors.Is(err, errNotIngested) {
+		http.Error(w, fmt.Sprintf("Chunk %d of stream %s at quality %s not found", index, streamID, quality), http.StatusNotFound)
+		return
+	}
+	h.logger.Error("Failed to read segment", logging.F("path", src.segment), logging.Err(err))
+	http.Error(w, "Failed to read chunk", http.StatusInternalServerError)
+}
+
+func (h *Handler) handleStreamStats(w http.ResponseWriter, r *http.Request, streamID string) {
+	stats := StreamStats{StreamID: streamID}
+	h.meters.fill(&stats, h.clock.Now())
+	if h.broadcast != nil {
+		stats.ActiveClients = new(int)
+	}
+	if src, viewers, ok := h.broadcast.Source(streamID); ok {
+		stats.ActiveClients = &src.Viewers
+		stats.Source = &src
+		stats.Viewers = viewers
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
 }
 
-func handleLiveStream(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) handleLiveStream(w http.ResponseWriter, r *http.Request) {
 	// Set SSE headers for live streaming
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	
+
+	// Sessions started after Drain would be cut by the listener closing
+	h.liveMu.Lock()
+	if h.drained {
+		h.liveMu.Unlock()
+		if h.drainPeer != "" {
+			w.Header().Set(ReconnectHeader, h.drainPeer)
+		}
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	h.live.Add(1)
+	h.liveMu.Unlock()
+	defer h.live.Done()
+
+	release, ok, retry := h.admission.hold(r.URL.Query().Get("quality"))
+	if !ok {
+		h.admission.reject(w, retry)
+		return
+	}
+	defer release()
+
+	// A viewer that lost its connection resumes after the last frame it got
+	last := -1
+	if v := r.URL.Query().Get("last_sequence"); v != "" {
+		var err error
+		if last, err = strconv.Atoi(v); err != nil || last < -1 {
+			http.Error(w, "Invalid last_sequence", http.StatusBadRequest)
+			return
+		}
+	}
+	session, status := h.resume.begin(r.URL.Query().Get("resume_token"), last, r.URL.Query().Get("quality"), h.clock.Now())
+	defer func() { h.resume.end(session, h.clock.Now()) }()
+	if session.token != "" {
+		w.Header().Set(ResumeTokenHeader, session.token)
+		h.sendEvent(w, SessionEvent{Type: "session", ResumeToken: session.token, Status: status, Sequence: session.next,
+			Quality: session.quality, FramesSent: session.frames, BytesSent: session.bytes, Resumes: session.resumes})
+	}
+
 	// Simulate live stream events
-	ticker := time.NewTicker(time.Second)
+	ticker := h.clock.NewTicker(time.Second)
 	defer ticker.Stop()
-	
-	for i := 0; i < 30; i++ { // Stream for 30 seconds
+
+	for session.next < liveFrames { // Stream for 30 seconds
 		select {
-		case <-ticker.C:
-			event := map[string]interface{}{
-				"type":      "frame",
-				"timestamp": time.Now().UnixMilli(),
-				"frame_id":  i,
-				"size":      rand.Intn(50000) + 10000,
-				"quality":   []string{"low", "medium", "high"}[rand.Intn(3)],
-			}
-			
-			data, _ := json.Marshal(event)
-			fmt.Fprintf(w, "data: %s\n\n", data)
-			
-			if f, ok := w.(http.Flusher); ok {
-				f.Flush()
+		case <-ticker.C():
+			size := rand.Intn(50000) + 10000
+			quality := session.quality
+			if quality == "" {
+				quality = []string{"low", "medium", "high"}[rand.Intn(3)]
 			}
-			
+			h.sendEvent(w, map[string]interface{}{
+				"type":      "frame",
+				"timestamp": h.clock.Now().UnixMilli(),
+				"frame_id":  session.next,
+				"size":      size,
+				"quality":   quality,
+			})
+			session.next++
+			session.frames++
+			session.bytes += int64(size)
+
+		case <-h.draining:
+			eos := EndOfStream{Type: "eos", Reason: EOSServerShutdown, Reconnect: h.drainPeer}
+			h.sendEvent(w, eos)
+			h.logger.Debug("Live session ended by shutdown", logging.F("frames", session.next))
+			return
+
+		case <-session.takeover:
+			h.logger.Debug("Live session resumed on another connection", logging.F("frames", session.next))
+			return
+
 		case <-r.Context().Done():
 			return
 		}
 	}
+	h.sendEvent(w, EndOfStream{Type: "eos", Reason: EOSComplete})
 }
 
-func getChunkSize(quality string) int {
-	switch quality {
-	case "low":
-		return 50000 + rand.Intn(20000) // 50-70KB
-	case "medium":
-		return 150000 + rand.Intn(50000) // 150-200KB
-	case "high":
-		return 400000 + rand.Intn(100000) // 400-500KB
-	case "ultra":
-		return 800000 + rand.Intn(200000) // 800KB-1MB
-	default:
-		return 150000
+// sendEvent writes v as a server-sent event and flushes it
+func (h *Handler) sendEvent(w http.ResponseWriter, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Reasons of an end-of-stream event
+const (
+	EOSComplete       = "complete"        // the stream reached its end
+	EOSServerShutdown = "server_shutdown" // the server is draining
+)
+
+// ReconnectHeader carries the peer to reconnect to on a live request that
+// is refused during shutdown
+const ReconnectHeader = "X-Reconnect-To"
+
+// EndOfStream is the last event of a live session
+type EndOfStream struct {
+	Type      string `json:"type"` // always "eos"
+	Reason    string `json:"reason"`
+	Reconnect string `json:"reconnect,omitempty"` // peer address to resume on
+}
+
+// Drain ends every live session with an end-of-stream event and refuses new
+// ones, then waits for the sessions to return. It gives up when ctx is done,
+// e.g. because a viewer stopped reading and its write is blocked.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.liveMu.Lock()
+	if !h.drained {
+		h.drained = true
+		close(h.draining)
+	}
+	h.liveMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.live.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
@@ -274,4 +777,13 @@ func generateVideoData(size int) []byte {
 	data := make([]byte, size)
 	rand.Read(data)
 	return data
-}
\ No newline at end of file
+}
+
+// chunkInterval returns the request interval declared by the client, or
+// the chunk duration
+func (h *Handler) chunkInterval(r *http.Request) time.Duration {
+	if d, err := time.ParseDuration(r.Header.Get(ChunkIntervalHeader)); err == nil && d > 0 {
+		return d
+	}
+	return h.chunk
+}
@@ -0,0 +1,150 @@
+package streaming
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// writeSegments writes n segments of quality in dir, as a file stream has
+// them on disk
+func writeSegments(t *testing.T, dir, quality string, n int) {
+	t.Helper()
+	path := filepath.Join(dir, quality)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if err := os.WriteFile(filepath.Join(path, fmt.Sprintf("seg%03d.m4s", i)), []byte(fmt.Sprintf("segment %d", i)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testGrace is how long the sessions of a liveFixture can be resumed
+const testGrace = 30 * time.Second
+
+// liveFixture serves a handler on a fake clock for live sessions, which can
+// be resumed for testGrace
+type liveFixture struct {
+	clock   *clock.Fake
+	resume  *Resumption
+	handler *Handler
+	server  *httptest.Server
+}
+
+func newLiveFixture(t *testing.T, opts ...Option) *liveFixture {
+	t.Helper()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	f := &liveFixture{
+		clock:  clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)),
+		resume: NewResumption(testGrace, 10, reg),
+	}
+	opts = append([]Option{WithClock(f.clock), WithResumption(f.resume)}, opts...)
+	f.handler = NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), opts...)
+	f.server = httptest.NewServer(f.handler)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// liveViewer is a connection playing a live session
+type liveViewer struct {
+	t       *testing.T
+	clock   *clock.Fake
+	cancel  context.CancelFunc
+	events  chan map[string]interface{}
+	session SessionEvent
+}
+
+// watch starts a live session with the query
+func (f *liveFixture) watch(t *testing.T, query string) *liveViewer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, f.server.URL+"/stream/live?"+query, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	v := &liveViewer{t: t, clock: f.clock, cancel: cancel, events: make(chan map[string]interface{}, 100)}
+	t.Cleanup(v.close)
+	go func() {
+		defer resp.Body.Close()
+		defer close(v.events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var event map[string]interface{}
+			json.Unmarshal([]byte(data), &event)
+			v.events <- event
+		}
+	}()
+
+	select {
+	case event := <-v.events:
+		b, _ := json.Marshal(event)
+		json.Unmarshal(b, &v.session)
+	case <-time.After(time.Second):
+		t.Fatal("no session event")
+	}
+	if v.session.Type != "session" {
+		t.Fatalf("first event %+v, want the session", v.session)
+	}
+	return v
+}
+
+// frame moves the clock on until the session sends a frame, and returns
+// its frame_id
+func (v *liveViewer) frame() int {
+	v.t.Helper()
+	for i := 0; i < 50; i++ {
+		v.clock.Advance(time.Second)
+		select {
+		case event := <-v.events:
+			if event["type"] != "frame" {
+				v.t.Fatalf("event %v, want a frame", event)
+			}
+			return int(event["frame_id"].(float64))
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	v.t.Fatal("no frame")
+	return 0
+}
+
+func (v *liveViewer) close() {
+	v.cancel()
+	for range v.events {
+	}
+}
+
+// metricValue returns the line of the exposition of reg starting with series
+func metricValue(t *testing.T, reg *metrics.Registry, series string) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			return value
+		}
+	}
+	return ""
+}
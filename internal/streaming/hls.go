@@ -0,0 +1,355 @@
+package streaming
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// Playlist types of the streams that have a duration
+const (
+	PlaylistVOD  = "vod"  // listed in full, once
+	PlaylistLive = "live" // listed in a window that slides as they play, like live streams
+)
+
+// hlsVersion is the protocol version of the playlists, the first to allow
+// EXT-X-MAP in playlists of whole segments
+const hlsVersion = 6
+
+// PlaylistContentType is the media type of HLS playlists
+const PlaylistContentType = "application/vnd.apple.mpegurl"
+
+// Names of the playlists and the init segment of a stream under
+// /stream/hls/{stream_id}/
+const (
+	MasterPlaylist = "master.m3u8"
+	MediaPlaylist  = "index.m3u8" // under {quality}/
+	InitSegment    = "init.mp4"   // under {quality}/, for content with one
+)
+
+// segmentExt ends the name of every media segment, {quality}/{n}.m4s
+const segmentExt = ".m4s"
+
+// HLS serves streams as HLS (RFC 8216) playlists and segments, for standard
+// players. The master playlist of a stream lists each of its available
+// qualities as a variant, and the media playlist of a quality lists its
+// segments, each one or more chunks long. Segments are produced from their
+// chunks like chunk requests are: read from disk, relayed from an ingest or
+// generated, and shared between viewers. Streams without a duration, and
+// with the live playlist type those that have one too, list a window of
+// their latest segments that slides as they play.
+type HLS struct {
+	chunk   time.Duration
+	chunks  int // per segment
+	window  int
+	live    bool // slide a window over streams that have a duration too
+	started time.Time
+
+	sent *metrics.CounterVec
+}
+
+// hlsWindow is the segments a media playlist lists
+type hlsWindow struct {
+	first  int
+	last   int // below first if there are none
+	chunks int // of the stream, -1 if it has no end
+	vod    bool
+}
+
+// NewHLS creates the HLS playlists with the settings of cfg, for streams in
+// chunks of chunk duration
+func NewHLS(cfg config.HLSConfig, chunk time.Duration, c clock.Clock, reg *metrics.Registry) *HLS {
+	return &HLS{
+		chunk:   chunk,
+		chunks:  max(int((cfg.TargetDuration+chunk/2)/chunk), 1),
+		window:  cfg.Window,
+		live:    cfg.PlaylistType == PlaylistLive,
+		started: c.Now(),
+		sent:    reg.CounterVec("streaming", "hls_segments_total", "HLS segments sent by quality", "quality"),
+	}
+}
+
+// WithHLS serves streams as HLS playlists and segments at /stream/hls
+func WithHLS(hl *HLS) Option {
+	return func(h *Handler) {
+		h.hls = hl
+	}
+}
+
+// duration returns the playback time of a whole segment
+func (hl *HLS) duration() time.Duration {
+	return time.Duration(hl.chunks) * hl.chunk
+}
+
+// segments returns the number of segments of a stream of chunks
+func (hl *HLS) segments(chunks int) int {
+	return (chunks + hl.chunks - 1) / hl.chunks
+}
+
+// writeMaster writes the master playlist of s, with a variant for each
+// available quality, and returns how many it has
+func (hl *HLS) writeMaster(w io.Writer, s StreamInfo) int {
+	variants := 0
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:%d\n", hlsVersion)
+	for _, b := range s.Bitrates {
+		if !b.Available {
+			continue
+		}
+		fps := b.FrameRate
+		if fps == 0 {
+			fps = s.FrameRate
+		}
+		fmt.Fprintf(w, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%s,FRAME-RATE=%d\n%s/%s\n",
+			b.Bitrate*1000, b.Resolution, fps, b.Quality, MediaPlaylist)
+		variants++
+	}
+	return variants
+}
+
+// writeMedia writes a media playlist listing the segments of win. init
+// maps them to the init segment.
+func (hl *HLS) writeMedia(w io.Writer, win hlsWindow, init bool) {
+	fmt.Fprintf(w, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:%d\n",
+		hlsVersion, int(math.Ceil(hl.duration().Seconds())), max(win.first, 0))
+	if win.vod {
+		fmt.Fprintf(w, "#EXT-X-PLAYLIST-TYPE:VOD\n")
+	}
+	if init {
+		fmt.Fprintf(w, "#EXT-X-MAP:URI=%q\n", InitSegment)
+	}
+	for n := win.first; n <= win.last; n++ {
+		chunks := hl.chunks
+		if win.chunks >= 0 {
+			chunks = min(chunks, win.chunks-n*hl.chunks)
+		}
+		fmt.Fprintf(w, "#EXTINF:%.3f,\n%d%s\n", (time.Duration(chunks) * hl.chunk).Seconds(), n, segmentExt)
+	}
+	if win.chunks >= 0 && win.last == hl.segments(win.chunks)-1 {
+		fmt.Fprintf(w, "#EXT-X-ENDLIST\n")
+	}
+}
+
+// hlsWindow returns the segments listed by the media playlist of s at
+// quality
+func (h *Handler) hlsWindow(s StreamInfo, quality string) hlsWindow {
+	hl := h.hls
+	now := h.clock.Now()
+	win := hlsWindow{chunks: h.streamChunks(s, quality), last: -1}
+	switch {
+	case s.Live:
+		// Only segments whose chunks were all ingested, and are still buffered
+		if live := h.ingest.source(s.StreamID); live != nil {
+			oldest, newest := h.ingest.buffered(live)
+			win.first = (oldest + hl.chunks - 1) / hl.chunks
+			win.last = (newest+1)/hl.chunks - 1
+		}
+	case win.chunks < 0:
+		win.last = int(now.Sub(s.CreatedAt)/hl.duration()) - 1
+	case !hl.live:
+		win.last = hl.segments(win.chunks) - 1
+		win.vod = true
+		return win
+	default:
+		// The window starts full, as if the stream started playing a
+		// window before the server
+		win.last = min(hl.window-1+int(now.Sub(hl.started)/hl.duration()), hl.segments(win.chunks)-1)
+	}
+	win.first = max(win.first, win.last-hl.window+1, 0)
+	return win
+}
+
+// streamChunks returns the chunks of s at quality, or -1 if it has no end
+func (h *Handler) streamChunks(s StreamInfo, quality string) int {
+	if s.Duration < 0 {
+		return -1
+	}
+	if r := h.diskRendition(s.StreamID, quality); r != nil {
+		return len(r.Segments)
+	}
+	return SeededChunks(time.Duration(s.Duration)*time.Second, h.chunk)
+}
+
+// hlsInit returns the init segment of s at quality on disk, or ""
+func (h *Handler) hlsInit(s StreamInfo, quality string) string {
+	if s.Live {
+		return ""
+	}
+	if r := h.diskRendition(s.StreamID, quality); r != nil && r.Reason == "" {
+		return r.Init
+	}
+	return ""
+}
+
+// diskRendition returns the rendition of streamID at quality on disk, or nil
+// if its chunks are generated
+func (h *Handler) diskRendition(streamID, quality string) *Rendition {
+	if _, ok := h.seeded[streamID]; ok {
+		return nil
+	}
+	if s := h.registry.entry(streamID); s != nil {
+		return s.renditions[quality]
+	}
+	if h.content != nil {
+		return h.content.rendition(streamID, quality)
+	}
+	return nil
+}
+
+func (h *Handler) handleHLS(w http.ResponseWriter, r *http.Request, streamID string, path []string) {
+	if h.hls == nil {
+		http.Error(w, "HLS is not enabled", http.StatusNotFound)
+		return
+	}
+	var stream StreamInfo
+	found := false
+	for _, s := range h.listed() {
+		if s.StreamID == streamID {
+			stream, found = s, true
+			break
+		}
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("Stream %s not found", streamID), http.StatusNotFound)
+		return
+	}
+
+	if len(path) == 1 && path[0] == MasterPlaylist {
+		var b strings.Builder
+		if h.hls.writeMaster(&b, stream) == 0 {
+			http.Error(w, fmt.Sprintf("Stream %s has no available renditions", streamID), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", PlaylistContentType)
+		io.WriteString(w, b.String())
+		return
+	}
+	if len(path) != 2 {
+		http.Error(w, "Unknown HLS resource", http.StatusNotFound)
+		return
+	}
+	quality := path[0]
+	var rend *Bitrate
+	for i := range stream.Bitrates {
+		if stream.Bitrates[i].Quality == quality {
+			rend = &stream.Bitrates[i]
+			break
+		}
+	}
+	switch {
+	case rend == nil:
+		http.Error(w, fmt.Sprintf("Rendition %s of stream %s not found", quality, streamID), http.StatusNotFound)
+		return
+	case !rend.Available:
+		http.Error(w, fmt.Sprintf("Rendition %s of stream %s unavailable: %s", quality, streamID, rend.Reason), http.StatusServiceUnavailable)
+		return
+	}
+
+	switch name := path[1]; {
+	case name == MediaPlaylist:
+		win := h.hlsWindow(stream, quality)
+		w.Header().Set("Content-Type", PlaylistContentType)
+		if !win.vod {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		h.hls.writeMedia(w, win, h.hlsInit(stream, quality) != "")
+	case name == InitSegment:
+		init := h.hlsInit(stream, quality)
+		if init == "" {
+			http.Error(w, fmt.Sprintf("Rendition %s of stream %s has no init segment", quality, streamID), http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, init)
+	case strings.HasSuffix(name, segmentExt):
+		n, err := strconv.Atoi(strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			http.Error(w, "Unknown HLS resource", http.StatusNotFound)
+			return
+		}
+		h.serveSegment(w, r, stream, quality, n)
+	default:
+		http.Error(w, "Unknown HLS resource", http.StatusNotFound)
+	}
+}
+
+// serveSegment writes segment n of s at quality, made of its chunks
+func (h *Handler) serveSegment(w http.ResponseWriter, r *http.Request, s StreamInfo, quality string, n int) {
+	first, count := n*h.hls.chunks, h.hls.chunks
+	if total := h.streamChunks(s, quality); total >= 0 {
+		count = min(count, total-first)
+	}
+	if n < 0 || count <= 0 {
+		http.Error(w, fmt.Sprintf("Segment %d of stream %s at quality %s not found", n, s.StreamID, quality), http.StatusNotFound)
+		return
+	}
+	srcs := make([]chunkSource, count)
+	for i := range srcs {
+		var status int
+		var msg string
+		if srcs[i], status, msg = h.locate(s.StreamID, quality, first+i); status != http.StatusOK {
+			http.Error(w, msg, status)
+			return
+		}
+	}
+
+	session := quota.SessionKey(r)
+	if !h.quotas.CheckSession(w, r) {
+		return
+	}
+	if ok, retry := h.admission.admit(s.StreamID, session, quality); !ok {
+		h.admission.reject(w, retry)
+		return
+	}
+	if total := h.streamChunks(s, quality); total >= 0 && first+count >= total {
+		defer h.admission.leave(s.StreamID, session)
+	}
+	chunks := make([][]byte, count)
+	size := 0
+	for i, src := range srcs {
+		index := first + i
+		data, err := h.broadcast.chunk(r.Context(), s.StreamID, session, quality, index, func() ([]byte, error) {
+			return h.produce(src, quality, index)
+		})
+		if err != nil {
+			h.chunkError(w, r, src, s.StreamID, quality, index, err)
+			return
+		}
+		chunks[i] = data
+		size += len(data)
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	w.Header().Set(CRCHeader, chunksCRC(chunks))
+	w.Header().Set("X-Stream-ID", s.StreamID)
+	w.Header().Set("X-Quality", quality)
+	out := h.pace.pace(r.Context(), w, session, size, h.hls.duration())
+	writeStart := h.clock.Now()
+	sent := 0
+	for _, data := range chunks {
+		n, err := out.Write(data)
+		sent += n
+		h.broadcast.sent(s.StreamID, session, quality, n)
+		if err != nil {
+			break
+		}
+	}
+	writeEnd := h.clock.Now()
+	path, measured := h.paths.Path(r.RemoteAddr)
+	h.meters.sent(s.StreamID, session, count, sent, writeEnd.Sub(writeStart), path, measured, writeEnd)
+	h.quotas.ChargeSession(r, int64(sent))
+	h.hls.sent.WithLabelValues(quality).Inc()
+	h.metrics.bytesSent.WithLabelValues(quality).Add(float64(sent))
+
+	h.logger.Debug("Served HLS segment", logging.F("stream_id", s.StreamID), logging.F("segment", n),
+		logging.F("quality", quality), logging.F("chunks", count), logging.F("size", sent))
+}
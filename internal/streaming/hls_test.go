@@ -0,0 +1,133 @@
+package streaming
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newTestHLS creates a handler serving HLS with segments of 3 chunks of 2s
+func newTestHLS(t *testing.T, playlistType string) (*Handler, *clock.Fake, *metrics.Registry) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Streaming.HLS.PlaylistType = playlistType
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(c),
+		WithHLS(NewHLS(cfg.Streaming.HLS, 2*time.Second, c, reg)))
+	return h, c, reg
+}
+
+func getHLS(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/hls/"+target, nil))
+	return rec
+}
+
+// segmentsListed returns the segments a media playlist lists
+func segmentsListed(playlist string) []string {
+	var segments []string
+	for _, line := range strings.Split(playlist, "\n") {
+		if strings.HasSuffix(line, segmentExt) {
+			segments = append(segments, line)
+		}
+	}
+	return segments
+}
+
+func TestHLSVODPlaylists(t *testing.T) {
+	h, _, reg := newTestHLS(t, PlaylistVOD)
+
+	rec := getHLS(h, "stream_001/"+MasterPlaylist)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != PlaylistContentType {
+		t.Fatalf("master playlist: status %d, %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	master := rec.Body.String()
+	if variants := strings.Count(master, "#EXT-X-STREAM-INF:"); variants != len(h.streams[0].Bitrates) {
+		t.Errorf("master playlist lists %d variants, want %d:\n%s", variants, len(h.streams[0].Bitrates), master)
+	}
+	if !strings.Contains(master, "low/"+MediaPlaylist) {
+		t.Errorf("master playlist doesn't list the low variant:\n%s", master)
+	}
+
+	// 120s in segments of 6s
+	media := getHLS(h, "stream_001/low/"+MediaPlaylist).Body.String()
+	for _, tag := range []string{"#EXT-X-TARGETDURATION:6\n", "#EXT-X-MEDIA-SEQUENCE:0\n", "#EXT-X-PLAYLIST-TYPE:VOD\n", "#EXTINF:6.000,\n", "#EXT-X-ENDLIST\n"} {
+		if !strings.Contains(media, tag) {
+			t.Errorf("media playlist lacks %q:\n%s", tag, media)
+		}
+	}
+	if segments := segmentsListed(media); len(segments) != 20 || segments[19] != "19"+segmentExt {
+		t.Errorf("media playlist lists %v, want segments 0 to 19", segments)
+	}
+
+	rec = getHLS(h, "stream_001/low/1"+segmentExt)
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 || rec.Header().Get("Content-Length") != fmt.Sprint(rec.Body.Len()) {
+		t.Fatalf("segment 1: status %d, %d bytes of %s", rec.Code, rec.Body.Len(), rec.Header().Get("Content-Length"))
+	}
+	if err := VerifyChunk(rec.Body.Bytes(), rec.Header().Get(CRCHeader)); err != nil {
+		t.Errorf("segment 1: %v", err)
+	}
+	if got := metricValue(t, reg, `commsys_streaming_hls_segments_total{quality="low"}`); got != "1" {
+		t.Errorf("hls_segments_total = %q, want 1", got)
+	}
+
+	for _, target := range []string{
+		"stream_001/low/20" + segmentExt,
+		"stream_001/low/-1" + segmentExt,
+		"stream_001/low/" + InitSegment,
+		"stream_001/8k/" + MediaPlaylist,
+		"stream_001/low/next" + segmentExt,
+		"stream_009/" + MasterPlaylist,
+	} {
+		if rec := getHLS(h, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestHLSLiveWindowSlides(t *testing.T) {
+	h, c, _ := newTestHLS(t, PlaylistLive)
+	tests := []struct {
+		after       time.Duration
+		first, last int
+		ended       bool
+	}{
+		{0, 0, 4, false},
+		{12 * time.Second, 2, 6, false},
+		{time.Hour, 15, 19, true},
+	}
+	for _, tt := range tests {
+		c.Advance(tt.after)
+		rec := getHLS(h, "stream_001/low/"+MediaPlaylist)
+		media := rec.Body.String()
+		segments := segmentsListed(media)
+		if len(segments) != tt.last-tt.first+1 || segments[0] != fmt.Sprint(tt.first, segmentExt) ||
+			!strings.Contains(media, fmt.Sprintf("#EXT-X-MEDIA-SEQUENCE:%d\n", tt.first)) {
+			t.Errorf("after %v: listed %v, want segments %d to %d", tt.after, segments, tt.first, tt.last)
+		}
+		if strings.Contains(media, "#EXT-X-ENDLIST") != tt.ended || strings.Contains(media, "#EXT-X-PLAYLIST-TYPE:VOD") {
+			t.Errorf("after %v: ended %v, want %v:\n%s", tt.after, !tt.ended, tt.ended, media)
+		}
+		if rec.Header().Get("Cache-Control") != "no-cache" {
+			t.Errorf("after %v: sliding playlist may be cached", tt.after)
+		}
+	}
+}
+
+func TestHLSDisabled(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg))
+	if rec := getHLS(h, "stream_001/"+MasterPlaylist); rec.Code != http.StatusNotFound {
+		t.Errorf("master playlist without HLS: status %d, want 404", rec.Code)
+	}
+}
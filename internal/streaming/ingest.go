@@ -0,0 +1,453 @@
+package streaming
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/limits"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxIngestRequestSize bounds the IngestRequest line of an ingest
+const maxIngestRequestSize = 4096
+
+// ingestFrameVersion is the first byte of every ingest frame
+const ingestFrameVersion = 1
+
+// ingestFrameHeaderLen is the version byte, a flags byte, the quality byte,
+// and the 4-byte chunk index and data length
+const ingestFrameHeaderLen = 11
+
+// Flags of an ingest frame
+const ingestKeyframe = 1 << 0
+
+// errNotIngested is returned for a chunk of a live stream that isn't
+// buffered: it was dropped already, didn't arrive in time or never will
+var errNotIngested = errors.New("chunk not ingested")
+
+// IngestRequest opens a live stream, POSTed as the first line of the body
+// of /stream/ingest/{stream_id} with the ingest token as bearer token. The
+// rest of the body is the chunks of the stream, each an ingest frame written
+// by WriteIngestFrame, until the ingest ends the body.
+type IngestRequest struct {
+	StreamID  string   `json:"stream_id"`
+	Title     string   `json:"title,omitempty"`
+	Qualities []string `json:"qualities"` // renditions ingested, referred to by their position in frames
+}
+
+// IngestFrame is a chunk of a live stream at one of its qualities
+type IngestFrame struct {
+	Index    uint32 // of the chunk in the stream
+	Quality  uint8  // position in IngestRequest.Qualities
+	Keyframe bool   // playback can start at the chunk
+	Data     []byte
+}
+
+// IngestResult answers an ingest once its body ended
+type IngestResult struct {
+	StreamID string `json:"stream_id"`
+	Chunks   int    `json:"chunks"`
+	Bytes    int64  `json:"bytes"`
+	Error    string `json:"error,omitempty"` // why the ingest was cut short
+}
+
+// WriteIngestFrame writes f to w: a version byte, a flags byte, the quality
+// byte, then the chunk index and data length, big endian, and the data
+func WriteIngestFrame(w io.Writer, f IngestFrame) error {
+	if len(f.Data) > MaxChunkSize {
+		return fmt.Errorf("chunk of %d bytes exceeds %d", len(f.Data), MaxChunkSize)
+	}
+	b := make([]byte, ingestFrameHeaderLen, ingestFrameHeaderLen+len(f.Data))
+	b[0] = ingestFrameVersion
+	if f.Keyframe {
+		b[1] |= ingestKeyframe
+	}
+	b[2] = f.Quality
+	binary.BigEndian.PutUint32(b[3:], f.Index)
+	binary.BigEndian.PutUint32(b[7:], uint32(len(f.Data)))
+	_, err := w.Write(append(b, f.Data...))
+	return err
+}
+
+// ReadIngestFrame reads the next frame written by WriteIngestFrame from r.
+// It returns io.EOF if r ends before the frame starts.
+func ReadIngestFrame(r io.Reader) (IngestFrame, error) {
+	var h [ingestFrameHeaderLen]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return IngestFrame{}, err
+	}
+	if h[0] != ingestFrameVersion {
+		return IngestFrame{}, fmt.Errorf("unknown ingest frame version %d", h[0])
+	}
+	size := binary.BigEndian.Uint32(h[7:])
+	if size > MaxChunkSize {
+		return IngestFrame{}, fmt.Errorf("chunk of %d bytes exceeds %d", size, MaxChunkSize)
+	}
+	f := IngestFrame{
+		Index:    binary.BigEndian.Uint32(h[3:]),
+		Quality:  h[2],
+		Keyframe: h[1]&ingestKeyframe != 0,
+		Data:     make([]byte, size),
+	}
+	if _, err := io.ReadFull(r, f.Data); err != nil {
+		return IngestFrame{}, io.ErrUnexpectedEOF
+	}
+	return f, nil
+}
+
+// Ingest holds the live streams pushed into the server. Each is listed in
+// the catalog while its ingest lasts, and keeps the chunks of the last
+// buffer of media, so a viewer that joins late starts at the latest keyframe
+// among them. A keyframe older than the buffer is dropped like any chunk.
+type Ingest struct {
+	token  string
+	keep   int           // chunks of the buffer
+	wait   time.Duration // bounds how long a chunk request waits for its chunk to be ingested
+	max    int
+	logger logging.Logger
+	clock  clock.Clock
+
+	mu      sync.Mutex
+	streams map[string]*liveSource
+
+	chunks   prometheus.Counter
+	rejected *metrics.CounterVec
+}
+
+// liveSource is the buffer of a live stream being ingested
+type liveSource struct {
+	info      StreamInfo
+	qualities []string
+	chunks    map[int]*liveChunk
+	oldest    int           // lowest index buffered
+	newest    int           // highest index ingested, -1 before the first
+	keyframe  int           // latest keyframe ingested, -1 before the first
+	arrived   chan struct{} // closed and replaced whenever a chunk arrives, or once the ingest ends
+	ended     bool
+}
+
+// liveChunk is a chunk of a live stream at the qualities ingested so far
+type liveChunk struct {
+	data     map[string][]byte
+	keyframe bool
+}
+
+// NewIngest creates the live streams of cfg, ingested in chunks of chunk
+// duration. It returns nil if cfg has no token.
+func NewIngest(cfg config.IngestConfig, chunk time.Duration, logger logging.Logger, c clock.Clock, reg *metrics.Registry) *Ingest {
+	if cfg.Token == "" {
+		return nil
+	}
+	return &Ingest{
+		token:    cfg.Token,
+		keep:     max(int(cfg.Buffer/chunk), 1),
+		wait:     2 * chunk,
+		max:      cfg.Streams,
+		logger:   logger,
+		clock:    c,
+		streams:  make(map[string]*liveSource),
+		chunks:   reg.Counter("streaming", "ingest_chunks_total", "Chunks ingested into live streams"),
+		rejected: reg.CounterVec("streaming", "ingest_rejected_total", "Ingests refused by reason", "reason"),
+	}
+}
+
+// WithIngest lets live streams be pushed into the server at /stream/ingest
+func WithIngest(in *Ingest) Option {
+	return func(h *Handler) {
+		h.ingest = in
+	}
+}
+
+// authorized reports whether r carries the ingest token
+func (in *Ingest) authorized(r *http.Request) bool {
+	got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(got), []byte(in.token)) == 1
+}
+
+// open adds the live stream of req, described by info, or returns the
+// status to refuse it with
+func (in *Ingest) open(req IngestRequest, info StreamInfo) (*liveSource, int, string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if _, ok := in.streams[req.StreamID]; ok {
+		return nil, http.StatusConflict, fmt.Sprintf("Stream %s is already being ingested", req.StreamID)
+	}
+	if len(in.streams) >= in.max {
+		return nil, http.StatusServiceUnavailable, "Too many live streams"
+	}
+	info.CreatedAt = in.clock.Now()
+	s := &liveSource{
+		info:      info,
+		qualities: req.Qualities,
+		chunks:    make(map[int]*liveChunk),
+		newest:    -1,
+		keyframe:  -1,
+		arrived:   make(chan struct{}),
+	}
+	in.streams[req.StreamID] = s
+	return s, http.StatusOK, ""
+}
+
+// close ends s and drops it from the catalog. Requests waiting for its
+// chunks give up.
+func (in *Ingest) close(s *liveSource) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	s.ended = true
+	close(s.arrived)
+	delete(in.streams, s.info.StreamID)
+}
+
+// add buffers f, a chunk of s, and drops the chunks that are too old
+func (in *Ingest) add(s *liveSource, f IngestFrame) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	index := int(f.Index)
+	if index < s.oldest {
+		return
+	}
+	c := s.chunks[index]
+	if c == nil {
+		c = &liveChunk{data: make(map[string][]byte)}
+		s.chunks[index] = c
+	}
+	c.data[s.qualities[f.Quality]] = f.Data
+	c.keyframe = c.keyframe || f.Keyframe
+	if c.keyframe {
+		s.keyframe = max(s.keyframe, index)
+	}
+	s.newest = max(s.newest, index)
+	in.chunks.Inc()
+
+	// The index comes from the publisher and may jump arbitrarily far, so
+	// the chunks to drop are found among those buffered
+	if oldest := s.newest - in.keep + 1; oldest > s.oldest {
+		for i := range s.chunks {
+			if i < oldest {
+				delete(s.chunks, i)
+			}
+		}
+		s.oldest = oldest
+	}
+	close(s.arrived)
+	s.arrived = make(chan struct{})
+}
+
+// source returns the live stream of streamID, or nil if it isn't being
+// ingested
+func (in *Ingest) source(streamID string) *liveSource {
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.streams[streamID]
+}
+
+// list returns the live streams being ingested, ordered by stream ID, each
+// with the chunk a viewer should start at
+func (in *Ingest) list() []StreamInfo {
+	if in == nil {
+		return nil
+	}
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	streams := make([]StreamInfo, 0, len(in.streams))
+	for _, s := range in.streams {
+		streams = append(streams, in.infoLocked(s))
+	}
+	slices.SortFunc(streams, func(a, b StreamInfo) int { return strings.Compare(a.StreamID, b.StreamID) })
+	return streams
+}
+
+// info returns the catalog entry of s
+func (in *Ingest) info(s *liveSource) StreamInfo {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.infoLocked(s)
+}
+
+// infoLocked returns the catalog entry of s. in.mu must be held.
+func (in *Ingest) infoLocked(s *liveSource) StreamInfo {
+	info := s.info
+	info.StartChunk = max(s.keyframe, s.oldest)
+	return info
+}
+
+// chunk waits up to in.wait for chunk index of s at quality, and returns
+// its data and whether it is a keyframe
+func (in *Ingest) chunk(s *liveSource, quality string, index int) ([]byte, bool, error) {
+	timeout := in.clock.After(in.wait)
+	for {
+		in.mu.Lock()
+		if c := s.chunks[index]; c != nil && c.data[quality] != nil {
+			in.mu.Unlock()
+			return c.data[quality], c.keyframe, nil
+		}
+		if s.ended || index < s.oldest {
+			in.mu.Unlock()
+			return nil, false, errNotIngested
+		}
+		arrived := s.arrived
+		in.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-timeout:
+			return nil, false, errNotIngested
+		}
+	}
+}
+
+// buffered returns the lowest index of s still buffered and the highest
+// ingested, -1 before the first
+func (in *Ingest) buffered(s *liveSource) (int, int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return s.oldest, s.newest
+}
+
+// role returns the role of chunk index of s, once it was ingested
+func (in *Ingest) role(s *liveSource, index int) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if c := s.chunks[index]; c != nil && c.keyframe {
+		return RoleKeyframe
+	}
+	return RoleDelta
+}
+
+func (h *Handler) handleIngest(w http.ResponseWriter, r *http.Request, streamID string) {
+	if h.ingest == nil {
+		http.Error(w, "Ingest is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.ingest.authorized(r) {
+		h.ingest.rejected.WithLabelValues("unauthorized").Inc()
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The ingest outlives the body size limit of the listener
+	limits.LiftBodyLimit(r)
+	body := bufio.NewReaderSize(r.Body, maxIngestRequestSize)
+	line, err := body.ReadSlice('\n')
+	var req IngestRequest
+	if err != nil || json.Unmarshal(line, &req) != nil {
+		h.ingest.rejected.WithLabelValues("invalid").Inc()
+		http.Error(w, "Invalid ingest request", http.StatusBadRequest)
+		return
+	}
+	if msg := h.validIngest(req, streamID); msg != "" {
+		h.ingest.rejected.WithLabelValues("invalid").Inc()
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if slices.ContainsFunc(h.streams, func(s StreamInfo) bool { return s.StreamID == streamID }) {
+		h.ingest.rejected.WithLabelValues("duplicate").Inc()
+		http.Error(w, fmt.Sprintf("Stream %s already exists", streamID), http.StatusConflict)
+		return
+	}
+	info := StreamInfo{StreamID: req.StreamID, Title: req.Title, Duration: -1, Format: "h264", Live: true}
+	// With a catalog, only streams registered as live are ingested
+	if h.registry != nil {
+		s := h.registry.entry(streamID)
+		if s == nil || s.meta.Source != SourceLive {
+			h.ingest.rejected.WithLabelValues("unknown").Inc()
+			h.unknownStream(w, streamID)
+			return
+		}
+		if info.Title == "" {
+			info.Title = s.meta.Title
+		}
+	}
+	if info.Title == "" {
+		info.Title = fmt.Sprintf("Live %s", req.StreamID)
+	}
+	h.offer(&info, req.Qualities)
+	live, status, msg := h.ingest.open(req, info)
+	if status != http.StatusOK {
+		reason := "duplicate"
+		if status != http.StatusConflict {
+			reason = "full"
+		}
+		h.ingest.rejected.WithLabelValues(reason).Inc()
+		http.Error(w, msg, status)
+		return
+	}
+	// Viewers of the stream free their slots once it has ended
+	defer h.admission.end(streamID)
+	defer h.ingest.close(live)
+	// Chunks shared under the same stream ID by an earlier ingest are stale
+	h.broadcast.forget(streamID)
+	h.logger.Info("Ingest started", logging.F("stream_id", streamID), logging.F("qualities", req.Qualities))
+
+	// The ingest outlives any request body or write timeout
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	result := IngestResult{StreamID: streamID}
+	for {
+		f, err := ReadIngestFrame(body)
+		if err == io.EOF {
+			break
+		}
+		if err == nil && int(f.Quality) >= len(req.Qualities) {
+			err = fmt.Errorf("quality %d of %d", f.Quality, len(req.Qualities))
+		}
+		if err != nil {
+			result.Error = err.Error()
+			break
+		}
+		h.ingest.add(live, f)
+		result.Chunks++
+		result.Bytes += int64(len(f.Data))
+	}
+	h.logger.Info("Ingest ended", logging.F("stream_id", streamID), logging.F("chunks", result.Chunks),
+		logging.F("bytes", result.Bytes), logging.F("error", result.Error))
+
+	w.Header().Set("Content-Type", "application/json")
+	if result.Error != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+// validIngest returns why req can't open streamID, or "" if it can
+func (h *Handler) validIngest(req IngestRequest, streamID string) string {
+	if req.StreamID != streamID {
+		return fmt.Sprintf("Ingest request is for stream %q, not %s", req.StreamID, streamID)
+	}
+	if len(req.Qualities) == 0 {
+		return "Ingest request declares no qualities"
+	}
+	for i, q := range req.Qualities {
+		if _, ok := h.rung(q); !ok {
+			return fmt.Sprintf("Unknown quality %q", q)
+		}
+		if slices.Contains(req.Qualities[:i], q) {
+			return fmt.Sprintf("Quality %q declared twice", q)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,248 @@
+package streaming
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+const ingestToken = "ingest-secret"
+
+// ingestBuffer is the chunks a live stream of these tests keeps
+const ingestBuffer = 5
+
+type ingestFixture struct {
+	ingest *Ingest
+	server *httptest.Server
+}
+
+func newIngestFixture(t *testing.T) *ingestFixture {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Streaming.Ingest.Token = ingestToken
+	cfg.Streaming.Ingest.Buffer = ingestBuffer * cfg.Streaming.ChunkDuration
+	reg := metrics.NewRegistry()
+	f := &ingestFixture{ingest: NewIngest(cfg.Streaming.Ingest, cfg.Streaming.ChunkDuration, logging.Nop(), clock.Real(), reg)}
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithIngest(f.ingest))
+	f.server = httptest.NewServer(h)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// publisher is an ingest in progress, written frame by frame
+type publisher struct {
+	t    *testing.T
+	f    *ingestFixture
+	id   string
+	body *io.PipeWriter
+	resp chan *http.Response
+}
+
+// publish opens the ingest of streamID at low and high
+func (f *ingestFixture) publish(t *testing.T, streamID string) *publisher {
+	t.Helper()
+	r, w := io.Pipe()
+	p := &publisher{t: t, f: f, id: streamID, body: w, resp: make(chan *http.Response, 1)}
+	req, _ := http.NewRequest(http.MethodPost, f.server.URL+"/stream/ingest/"+streamID, r)
+	req.Header.Set("Authorization", "Bearer "+ingestToken)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			r.CloseWithError(err)
+			close(p.resp)
+			return
+		}
+		p.resp <- resp
+	}()
+	t.Cleanup(func() { w.Close() })
+	line, _ := json.Marshal(IngestRequest{StreamID: streamID, Qualities: []string{"low", "high"}})
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// send ingests chunk index at both qualities and waits until it is buffered
+func (p *publisher) send(index uint32, keyframe bool) {
+	p.t.Helper()
+	for q := uint8(0); q < 2; q++ {
+		data := []byte(fmt.Sprintf("chunk %d quality %d", index, q))
+		if err := WriteIngestFrame(p.body, IngestFrame{Index: index, Quality: q, Keyframe: keyframe, Data: data}); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if s := p.f.ingest.source(p.id); s != nil {
+			if _, newest := p.f.ingest.buffered(s); newest == int(index) {
+				return
+			}
+		}
+	}
+	p.t.Fatalf("chunk %d not buffered within a second", index)
+}
+
+// end ends the body of the ingest and returns its answer
+func (p *publisher) end() (*http.Response, IngestResult) {
+	p.t.Helper()
+	p.body.Close()
+	resp := <-p.resp
+	if resp == nil {
+		p.t.Fatal("ingest failed")
+	}
+	defer resp.Body.Close()
+	var result IngestResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	return resp, result
+}
+
+// chunk requests chunk index of streamID at quality
+func (f *ingestFixture) chunk(t *testing.T, streamID, quality string, index int) (int, []byte) {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("%s/stream/chunk/%s?quality=%s&chunk=%d", f.server.URL, streamID, quality, index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// info returns the catalog entry of streamID
+func (f *ingestFixture) info(t *testing.T, streamID string) StreamInfo {
+	t.Helper()
+	resp, err := http.Get(f.server.URL + "/stream/info/" + streamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info StreamInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestIngestRelaysChunksInOrder(t *testing.T) {
+	f := newIngestFixture(t)
+	p := f.publish(t, "live1")
+	for i := uint32(0); i < 4; i++ {
+		p.send(i, i == 0)
+	}
+	if info := f.info(t, "live1"); !info.Live || info.Duration != -1 || info.StartChunk != 0 {
+		t.Errorf("info %+v, want live from chunk 0", info)
+	}
+	for i := 0; i < 4; i++ {
+		for q, quality := range []string{"low", "high"} {
+			status, data := f.chunk(t, "live1", quality, i)
+			want := fmt.Sprintf("chunk %d quality %d", i, q)
+			if status != http.StatusOK || !bytes.Equal(data, []byte(want)) {
+				t.Errorf("chunk %d at %s: status %d, %q, want %q", i, quality, status, data, want)
+			}
+		}
+	}
+
+	resp, result := p.end()
+	if resp.StatusCode != http.StatusOK || result.Chunks != 8 || result.Error != "" {
+		t.Errorf("ingest answered %d %+v, want 8 chunks", resp.StatusCode, result)
+	}
+	if f.ingest.source("live1") != nil {
+		t.Error("stream still ingested after its body ended")
+	}
+}
+
+func TestIngestLateJoinStartsAtKeyframe(t *testing.T) {
+	f := newIngestFixture(t)
+	p := f.publish(t, "live1")
+	for i := uint32(0); i <= 12; i++ {
+		p.send(i, i%5 == 0)
+	}
+
+	info := f.info(t, "live1")
+	if info.StartChunk != 10 {
+		t.Fatalf("late joiner starts at %d, want the keyframe at 10", info.StartChunk)
+	}
+	if status, _ := f.chunk(t, "live1", "low", info.StartChunk); status != http.StatusOK {
+		t.Errorf("start chunk: status %d", status)
+	}
+	if status, _ := f.chunk(t, "live1", "low", 12-ingestBuffer); status != http.StatusNotFound {
+		t.Errorf("chunk older than the buffer: status %d, want 404", status)
+	}
+}
+
+func TestIngestBufferIsBoundedWithoutKeyframes(t *testing.T) {
+	f := newIngestFixture(t)
+	p := f.publish(t, "live1")
+	p.send(0, true)
+	for i := uint32(1); i < 100; i++ {
+		p.send(i, false)
+	}
+	s := f.ingest.source("live1")
+	f.ingest.mu.Lock()
+	buffered := len(s.chunks)
+	f.ingest.mu.Unlock()
+	if buffered != ingestBuffer {
+		t.Errorf("%d chunks buffered, want %d however old the keyframe", buffered, ingestBuffer)
+	}
+	if info := f.info(t, "live1"); info.StartChunk != 100-ingestBuffer {
+		t.Errorf("late joiner starts at %d, want the oldest chunk buffered", info.StartChunk)
+	}
+
+	// An index far ahead moves the buffer there at once, within the second
+	// send waits
+	p.send(4_000_000_000, true)
+	f.ingest.mu.Lock()
+	buffered = len(s.chunks)
+	f.ingest.mu.Unlock()
+	if buffered != 1 {
+		t.Errorf("%d chunks buffered after the jump, want 1", buffered)
+	}
+}
+
+func TestIngestRejectsDuplicateStream(t *testing.T) {
+	f := newIngestFixture(t)
+	p := f.publish(t, "live1")
+	p.send(0, true)
+
+	ingest := func(streamID, token string) int {
+		line, _ := json.Marshal(IngestRequest{StreamID: streamID, Qualities: []string{"low"}})
+		req, _ := http.NewRequest(http.MethodPost, f.server.URL+"/stream/ingest/"+streamID, bytes.NewReader(append(line, '\n')))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := ingest("live1", ingestToken); status != http.StatusConflict {
+		t.Errorf("second ingest of live1: status %d, want 409", status)
+	}
+	if status := ingest("stream_001", ingestToken); status != http.StatusConflict {
+		t.Errorf("ingest of a built-in stream: status %d, want 409", status)
+	}
+	if status := ingest("live2", "guess"); status != http.StatusUnauthorized {
+		t.Errorf("ingest with a wrong token: status %d, want 401", status)
+	}
+	if status, _ := f.chunk(t, "live1", "low", 0); status != http.StatusOK {
+		t.Errorf("first ingest disturbed: chunk status %d", status)
+	}
+
+	// A malformed frame ends the ingest with 400
+	if _, err := p.body.Write([]byte{9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, result := p.end(); resp.StatusCode != http.StatusBadRequest || result.Error == "" || result.Chunks != 2 {
+		t.Errorf("malformed frame answered %d %+v, want 400 after 2 chunks", resp.StatusCode, result)
+	}
+}
@@ -0,0 +1,49 @@
+package streaming
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// CRCHeader carries the CRC-32C of every chunk, or of the chunks of an HLS
+// segment, as 8 hex digits. A chunk sent as datagrams carries it too, for
+// the chunk put back together from its fragments.
+const CRCHeader = "X-Chunk-CRC32C"
+
+// ErrChunkCorrupt is returned by VerifyChunk for a chunk that doesn't match
+// its CRC
+var ErrChunkCorrupt = errors.New("chunk corrupt")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ChunkCRC returns the CRC-32C of data as sent in CRCHeader
+func ChunkCRC(data []byte) string {
+	return formatCRC(crc32.Checksum(data, castagnoli))
+}
+
+// VerifyChunk checks data against want, the CRCHeader of its response. A
+// response without one, from an older server, isn't checked.
+func VerifyChunk(data []byte, want string) error {
+	if want == "" {
+		return nil
+	}
+	if got := ChunkCRC(data); got != want {
+		return fmt.Errorf("%w: CRC-32C %s, expected %s", ErrChunkCorrupt, got, want)
+	}
+	return nil
+}
+
+// chunksCRC returns the CRC-32C of chunks one after the other, without
+// copying them together
+func chunksCRC(chunks [][]byte) string {
+	var crc uint32
+	for _, c := range chunks {
+		crc = crc32.Update(crc, castagnoli, c)
+	}
+	return formatCRC(crc)
+}
+
+func formatCRC(crc uint32) string {
+	return fmt.Sprintf("%08x", crc)
+}
@@ -0,0 +1,47 @@
+package streaming
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestChunkCRC(t *testing.T) {
+	// The check value of CRC-32C
+	if got := ChunkCRC([]byte("123456789")); got != "e3069283" {
+		t.Errorf("CRC-32C of 123456789 is %s, want e3069283", got)
+	}
+	if got, want := chunksCRC([][]byte{[]byte("1234"), nil, []byte("56789")}), ChunkCRC([]byte("123456789")); got != want {
+		t.Errorf("CRC of the chunks one after the other %s, want %s", got, want)
+	}
+
+	if err := VerifyChunk([]byte("123456789"), "e3069283"); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyChunk([]byte("123456780"), "e3069283"); !errors.Is(err, ErrChunkCorrupt) {
+		t.Errorf("corrupt chunk returned %v, want ErrChunkCorrupt", err)
+	}
+	if err := VerifyChunk([]byte("anything"), ""); err != nil {
+		t.Errorf("chunk without a CRC returned %v", err)
+	}
+}
+
+func TestChunksCarryCRC(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/chunk/stream_001?quality=low&chunk=4", nil))
+	crc := rec.Header().Get(CRCHeader)
+	if rec.Code != http.StatusOK || len(crc) != 8 {
+		t.Fatalf("status %d, CRC %q", rec.Code, crc)
+	}
+	if err := VerifyChunk(rec.Body.Bytes(), crc); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,57 @@
+package streaming
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// WithLadder offers the qualities of ladder, ordered by ascending bitrate, in
+// chunks of chunk duration. Without it the handler uses the ladder and chunk
+// duration of config.Default.
+func WithLadder(ladder []config.QualityConfig, chunk time.Duration) Option {
+	return func(h *Handler) {
+		h.qualities = ladder
+		h.chunk = chunk
+	}
+}
+
+// rung returns the rung of the ladder named quality, or false
+func (h *Handler) rung(quality string) (config.QualityConfig, bool) {
+	i := slices.IndexFunc(h.qualities, func(q config.QualityConfig) bool { return q.Name == quality })
+	if i < 0 {
+		return config.QualityConfig{}, false
+	}
+	return h.qualities[i], true
+}
+
+// offer adds the rungs of the ladder named in qualities, or every rung if it
+// is empty, to the renditions of s, gives s the resolution and frame rate of
+// the highest and sets its chunk duration
+func (h *Handler) offer(s *StreamInfo, qualities []string) {
+	s.ChunkDuration = int(h.chunk / time.Millisecond)
+	for _, q := range h.qualities {
+		if len(qualities) > 0 && !slices.Contains(qualities, q.Name) {
+			continue
+		}
+		b := rendition(s.StreamID, q.Name, q.BitrateKbps, fmt.Sprintf("%dx%d", q.Width, q.Height))
+		b.FrameRate = q.FrameRate
+		s.Bitrates = append(s.Bitrates, b)
+		s.Resolution, s.FrameRate = b.Resolution, q.FrameRate
+	}
+}
+
+// generatedChunkSize returns the size of a generated chunk at quality: 40 to
+// 55% of a chunk at the rung's nominal bitrate, like encoders fall short of
+// it, capped at MaxChunkSize. Qualities off the ladder get 150KB.
+func (h *Handler) generatedChunkSize(quality string) int {
+	q, ok := h.rung(quality)
+	if !ok {
+		return 150000
+	}
+	nominal := float64(q.BitrateKbps) * 1000 / 8 * h.chunk.Seconds()
+	return min(int(nominal*(0.4+0.15*rand.Float64())), MaxChunkSize)
+}
@@ -0,0 +1,54 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestStreamsOfferConfiguredLadder(t *testing.T) {
+	ladder := []config.QualityConfig{
+		{Name: "sd", Width: 640, Height: 360, BitrateKbps: 1000, FrameRate: 25},
+		{Name: "hd", Width: 1920, Height: 1080, BitrateKbps: 1600, FrameRate: 50},
+	}
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg), WithLadder(ladder, 4*time.Second))
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	var info StreamInfo
+	if err := json.NewDecoder(get("/stream/info/stream_001").Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Bitrates) != len(ladder) || info.ChunkDuration != 4000 || info.Resolution != "1920x1080" || info.FrameRate != 50 {
+		t.Fatalf("stream info %+v, want the sd and hd rungs in chunks of 4s", info)
+	}
+	for i, q := range ladder {
+		b := info.Bitrates[i]
+		if b.Quality != q.Name || b.Bitrate != q.BitrateKbps || b.Resolution != fmt.Sprintf("%dx%d", q.Width, q.Height) || b.FrameRate != q.FrameRate {
+			t.Errorf("rendition %d: %+v, want %+v", i, b, q)
+		}
+	}
+
+	// 40 to 55% of 4s at 1000 kbps
+	for i := 0; i < 5; i++ {
+		rec := get(fmt.Sprintf("/stream/chunk/stream_001?quality=sd&chunk=%d", i))
+		if size := rec.Body.Len(); rec.Code != http.StatusOK || size < 200_000 || size > 275_000 {
+			t.Errorf("chunk %d at sd: status %d, %d bytes, want 200 to 275 kB", i, rec.Code, size)
+		}
+	}
+	if size := h.generatedChunkSize("off-ladder"); size != 150000 {
+		t.Errorf("chunk off the ladder of %d bytes, want 150000", size)
+	}
+}
@@ -0,0 +1,281 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// CapParam is the query parameter of a chunk request that caps the
+// bandwidth of its session, in kbps, e.g.
+// /stream/chunk/stream_001?quality=high&chunk=7&max_kbps=2000. The cap
+// holds for the later chunks of the session too, until it is changed by
+// another request or at /stream/cap. 0 lifts it.
+const CapParam = "max_kbps"
+
+// maxCapSize bounds the body of a cap request
+const maxCapSize = 1024
+
+// paceTick is how often a spread chunk releases a slice
+const paceTick = 10 * time.Millisecond
+
+// capBurst is how much of its cap a session can send at once
+const capBurst = 50 * time.Millisecond
+
+// minPaceSlice is the smallest slice a paced chunk is written in, so a low
+// cap doesn't end up flushing a few bytes at a time
+const minPaceSlice = 1024
+
+// BandwidthCap sets the bandwidth cap of the player's session when POSTed
+// to /stream/cap, in the same session as its chunk requests. The response
+// carries the cap in effect, which is never above the server's.
+type BandwidthCap struct {
+	MaxKbps int `json:"max_kbps"` // 0 for none
+}
+
+// Pacer writes the chunks of each session in slices rather than at once. A
+// chunk can be spread over part of the interval its player requests chunks
+// at, so it doesn't go out in a burst that overflows shallow buffers on the
+// path, and a session can be capped to a bandwidth, shared by all of its
+// chunks, so one player of a high quality can't starve the others. The cap
+// of a session is kept in a token bucket, and can be changed while its
+// chunks are written.
+type Pacer struct {
+	spread  float64
+	maxKbps int
+	max     int
+	clock   clock.Clock
+
+	mu       sync.Mutex
+	sessions map[string]*paceBucket
+
+	waited *metrics.CounterVec
+}
+
+// paceBucket is the bandwidth cap of one session
+type paceBucket struct {
+	requested int     // cap asked for by the player in kbps, 0 for none
+	tokens    float64 // bytes the session can send now, negative while in debt
+	last      time.Time
+}
+
+// NewPacer creates a pacer with the settings of cfg
+func NewPacer(cfg config.PacingConfig, c clock.Clock, reg *metrics.Registry) *Pacer {
+	return &Pacer{
+		spread:   cfg.Spread,
+		maxKbps:  cfg.MaxKbps,
+		max:      cfg.Sessions,
+		clock:    c,
+		sessions: make(map[string]*paceBucket),
+		waited:   reg.CounterVec("streaming", "pace_wait_seconds_total", "Time chunk writes waited to be spread or to stay within the cap of their session by reason", "reason"),
+	}
+}
+
+// WithPacer paces the chunks of every session with p
+func WithPacer(p *Pacer) Option {
+	return func(h *Handler) {
+		h.pace = p
+	}
+}
+
+// SetCap caps session to kbps, or lifts its own cap if kbps is 0, and
+// returns the cap in effect
+func (p *Pacer) SetCap(session string, kbps int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.sessions[session]
+	if b == nil {
+		if kbps == 0 {
+			return p.maxKbps
+		}
+		b = p.bucketLocked(session, kbps)
+	}
+	b.requested = kbps
+	return p.capLocked(b)
+}
+
+// bucketLocked adds a full bucket for session capped to requested kbps,
+// dropping the least recently active session if there are too many. p.mu
+// must be held.
+func (p *Pacer) bucketLocked(session string, requested int) *paceBucket {
+	if len(p.sessions) >= p.max {
+		var oldest string
+		var at time.Time
+		for id, b := range p.sessions {
+			if oldest == "" || b.last.Before(at) {
+				oldest, at = id, b.last
+			}
+		}
+		delete(p.sessions, oldest)
+	}
+	b := &paceBucket{requested: requested, last: p.clock.Now()}
+	p.sessions[session] = b
+	b.tokens = burstBytes(p.capLocked(b))
+	return b
+}
+
+// capLocked returns the cap of b in kbps, the lower of the server's and the
+// player's, 0 for none. p.mu must be held.
+func (p *Pacer) capLocked(b *paceBucket) int {
+	switch {
+	case b == nil || b.requested == 0:
+		return p.maxKbps
+	case p.maxKbps == 0:
+		return b.requested
+	}
+	return min(p.maxKbps, b.requested)
+}
+
+// take reserves a slice of at most want bytes for session and returns its
+// size, with how long to wait before writing it to stay within the cap
+func (p *Pacer) take(session string, want int) (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	b := p.sessions[session]
+	if b == nil {
+		if p.maxKbps == 0 {
+			return want, 0
+		}
+		b = p.bucketLocked(session, 0)
+	}
+	kbps := p.capLocked(b)
+	if kbps == 0 {
+		return want, 0
+	}
+	now := p.clock.Now()
+	rate := float64(kbps) * 1000 / 8
+	burst := burstBytes(kbps)
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*rate)
+	}
+	b.last = now
+
+	n := min(want, int(burst))
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return n, 0
+	}
+	return n, time.Duration(-b.tokens / rate * float64(time.Second))
+}
+
+// burstBytes returns how much a session capped to kbps can send at once
+func burstBytes(kbps int) float64 {
+	return max(float64(kbps)*1000/8*capBurst.Seconds(), minPaceSlice)
+}
+
+// pace returns a writer for a chunk of size bytes of session, requested
+// every interval, that spreads and caps what is written through it
+func (p *Pacer) pace(ctx context.Context, w http.ResponseWriter, session string, size int, interval time.Duration) http.ResponseWriter {
+	if p == nil {
+		return w
+	}
+	pw := &pacedWriter{ResponseWriter: w, pacer: p, session: session, ctx: ctx, size: size, start: p.clock.Now()}
+	if p.spread > 0 && size > 0 {
+		pw.window = time.Duration(float64(interval) * p.spread)
+		pw.slice = max(size/max(int(pw.window/paceTick), 1), minPaceSlice)
+	}
+	return pw
+}
+
+// pacedWriter writes a chunk in slices, each one no earlier than its share
+// of the spread window and within the cap of its session
+type pacedWriter struct {
+	http.ResponseWriter
+	pacer   *Pacer
+	session string
+	ctx     context.Context
+
+	size   int           // of the chunk
+	window time.Duration // the chunk is spread over, 0 for none
+	slice  int           // bytes released per paceTick of window
+	start  time.Time
+	sent   int
+}
+
+func (w *pacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		want := len(b)
+		if w.window > 0 {
+			want = min(want, w.slice)
+			due := w.start.Add(time.Duration(float64(w.window) * float64(w.sent) / float64(w.size)))
+			if err := w.wait(due.Sub(w.pacer.clock.Now()), "spread"); err != nil {
+				return written, err
+			}
+		}
+		n, d := w.pacer.take(w.session, want)
+		if err := w.wait(d, "cap"); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(b[:n])
+		written += n
+		w.sent += n
+		if err != nil {
+			return written, err
+		}
+		w.Flush()
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// Flush sends what was written so far
+func (w *pacedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wait waits for d, or returns the error of the request if it ends first,
+// e.g. because the player stopped reading the chunk
+func (w *pacedWriter) wait(d time.Duration, reason string) error {
+	if d <= 0 {
+		return nil
+	}
+	select {
+	case <-w.pacer.clock.After(d):
+		w.pacer.waited.WithLabelValues(reason).Add(d.Seconds())
+		return nil
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// requestedCap returns the cap r asks for with CapParam, -1 if it asks for
+// none, or false if the cap is invalid
+func requestedCap(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get(CapParam)
+	if v == "" {
+		return -1, true
+	}
+	kbps, err := strconv.Atoi(v)
+	return kbps, err == nil && kbps >= 0
+}
+
+func (h *Handler) handleCap(w http.ResponseWriter, r *http.Request) {
+	if h.pace == nil {
+		http.Error(w, "Pacing is not enabled", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var c BandwidthCap
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCapSize)).Decode(&c); err != nil || c.MaxKbps < 0 {
+		http.Error(w, "Invalid cap", http.StatusBadRequest)
+		return
+	}
+	c.MaxKbps = h.pace.SetCap(quota.SessionKey(r), c.MaxKbps)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
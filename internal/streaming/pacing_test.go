@@ -0,0 +1,162 @@
+package streaming
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// skipClock is a fake clock on which waiting takes no time: After moves
+// the clock on by the wait and fires at once
+type skipClock struct {
+	*clock.Fake
+}
+
+func (c skipClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	fired := make(chan time.Time, 1)
+	fired <- c.Now()
+	return fired
+}
+
+// newTestPacer creates a pacer with cfg on a skipClock
+func newTestPacer(cfg config.PacingConfig) (*Pacer, skipClock) {
+	c := skipClock{clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))}
+	cfg.Sessions = 10
+	return NewPacer(cfg, c, metrics.NewRegistry()), c
+}
+
+// writePaced writes a chunk of size bytes of session through p and
+// returns how long it took on c
+func writePaced(t *testing.T, p *Pacer, c skipClock, session string, size int, interval time.Duration) time.Duration {
+	t.Helper()
+	start := c.Now()
+	rec := httptest.NewRecorder()
+	if n, err := p.pace(context.Background(), rec, session, size, interval).Write(make([]byte, size)); n != size || err != nil {
+		t.Fatalf("wrote %d of %d bytes: %v", n, size, err)
+	}
+	return c.Now().Sub(start)
+}
+
+func TestPacerCapsSession(t *testing.T) {
+	p, c := newTestPacer(config.PacingConfig{})
+	if got := writePaced(t, p, c, "viewer", 50_000, time.Second); got != 0 {
+		t.Errorf("uncapped chunk took %v", got)
+	}
+
+	// 800 kbps is 100 kB/s, after a burst of 5 kB
+	if got := p.SetCap("viewer", 800); got != 800 {
+		t.Fatalf("cap set to %d, want 800", got)
+	}
+	if got := writePaced(t, p, c, "viewer", 50_000, time.Second); got != 450*time.Millisecond {
+		t.Errorf("50 kB at 800 kbps took %v, want 450ms", got)
+	}
+	// The next chunk shares the bucket, now empty
+	if got := writePaced(t, p, c, "viewer", 10_000, time.Second); got != 100*time.Millisecond {
+		t.Errorf("10 kB more took %v, want 100ms", got)
+	}
+	if got := writePaced(t, p, c, "other", 50_000, time.Second); got != 0 {
+		t.Errorf("chunk of an uncapped session took %v", got)
+	}
+}
+
+func TestPlayersCanOnlyLowerTheCap(t *testing.T) {
+	p, _ := newTestPacer(config.PacingConfig{MaxKbps: 1000})
+	for _, tt := range []struct{ kbps, want int }{{500, 500}, {2000, 1000}, {0, 1000}} {
+		if got := p.SetCap("viewer", tt.kbps); got != tt.want {
+			t.Errorf("cap of %d kbps: %d in effect, want %d", tt.kbps, got, tt.want)
+		}
+	}
+	if got := p.SetCap("new", 0); got != 1000 {
+		t.Errorf("lifting the cap of a new session left %d kbps, want the server's 1000", got)
+	}
+}
+
+func TestPacerSpreadsChunk(t *testing.T) {
+	p, c := newTestPacer(config.PacingConfig{Spread: 0.5})
+	// Slices of 1 kB, each due at its share of 500ms
+	got := writePaced(t, p, c, "viewer", 10_240, time.Second)
+	if want := 450 * time.Millisecond; got != want {
+		t.Errorf("chunk spread over %v, want %v", got, want)
+	}
+	if got := writePaced(t, p, c, "viewer", 0, time.Second); got != 0 {
+		t.Errorf("empty chunk took %v", got)
+	}
+}
+
+func TestPacedWriteEndsWithRequest(t *testing.T) {
+	cfg := config.PacingConfig{MaxKbps: 8, Sessions: 10}
+	p := NewPacer(cfg, clock.Real(), metrics.NewRegistry())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// 8 kbps lets the first 1 kB out, the rest would take seconds
+	n, err := p.pace(ctx, httptest.NewRecorder(), "viewer", 10_000, time.Second).Write(make([]byte, 10_000))
+	if !errors.Is(err, context.Canceled) || n != minPaceSlice {
+		t.Errorf("wrote %d bytes for an ended request: %v, want the first slice and context.Canceled", n, err)
+	}
+}
+
+func TestCapEndpoint(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	c := skipClock{clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))}
+	pacer := NewPacer(config.PacingConfig{MaxKbps: 4000, Sessions: 10}, c, reg)
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(c), WithPacer(pacer))
+	post := func(h http.Handler, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/stream/cap", strings.NewReader(body))
+		req.Header.Set("X-Session-ID", "viewer")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(h, http.MethodPost, `{"max_kbps": 8000}`)
+	var got BandwidthCap
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || got.MaxKbps != 4000 {
+		t.Errorf("status %d, cap %+v, %v, want the server's 4000 kbps", rec.Code, got, err)
+	}
+	for _, tt := range []struct {
+		method, body string
+		want         int
+	}{
+		{http.MethodPost, `{"max_kbps": -1}`, http.StatusBadRequest},
+		{http.MethodPost, `not json`, http.StatusBadRequest},
+		{http.MethodGet, ``, http.StatusMethodNotAllowed},
+	} {
+		if rec := post(h, tt.method, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %q: status %d, want %d", tt.method, tt.body, rec.Code, tt.want)
+		}
+	}
+
+	// A chunk request lowers the cap of its session too
+	req := httptest.NewRequest(http.MethodGet, "/stream/chunk/stream_001?quality=low&chunk=0&"+CapParam+"=1000", nil)
+	req.Header.Set("X-Session-ID", "viewer")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || pacer.sessions["viewer"].requested != 1000 {
+		t.Errorf("status %d, cap of %d kbps asked for, want 1000", rec.Code, pacer.sessions["viewer"].requested)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/stream/chunk/stream_001?quality=low&chunk=0&"+CapParam+"=fast", nil)
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid cap on a chunk request: status %d, want 400", rec.Code)
+	}
+
+	reg = metrics.NewRegistry()
+	unpaced := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg))
+	if rec := post(unpaced, http.MethodPost, `{"max_kbps": 1000}`); rec.Code != http.StatusNotFound {
+		t.Errorf("cap without pacing: status %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,182 @@
+package streaming
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RoleHeader tells whether a chunk is a keyframe, which playback can start
+// from, or a delta chunk that needs the keyframe before it. Clients that
+// fetch chunks concurrently reassemble them by X-Chunk-Index and skip delta
+// chunks that arrive after a later keyframe.
+const RoleHeader = "X-Chunk-Role"
+
+// Chunk roles
+const (
+	RoleKeyframe = "keyframe"
+	RoleDelta    = "delta"
+)
+
+// PrioritizeParam is the query parameter that asks for a session's
+// keyframes to go out ahead of its delta chunks, e.g.
+// /stream/chunk/stream_001?quality=high&chunk=7&prioritize_keyframes=true
+const PrioritizeParam = "prioritize_keyframes"
+
+// deltaSlice is how much of a delta chunk is written between checks for
+// keyframes of its session
+const deltaSlice = 16 << 10
+
+// maxDeltaHold bounds how long a delta chunk yields to keyframes in all,
+// so a session sending keyframes back to back can't starve its deltas
+const maxDeltaHold = SegmentDuration
+
+// chunkRole returns the role of chunk index
+func chunkRole(index int) string {
+	if index%10 == 0 {
+		return RoleKeyframe
+	}
+	return RoleDelta
+}
+
+// role returns the role of chunk index of src: every 10th chunk is a
+// keyframe, unless the ingest says otherwise
+func (h *Handler) role(src chunkSource, index int) string {
+	if src.live != nil {
+		return h.ingest.role(src.live, index)
+	}
+	return chunkRole(index)
+}
+
+// prioritized reports whether r asks for keyframe priority
+func prioritized(r *http.Request) bool {
+	ok, _ := strconv.ParseBool(r.URL.Query().Get(PrioritizeParam))
+	return ok
+}
+
+// keyframeGate lets the keyframes of a session go out ahead of its delta
+// chunks. Each chunk request is a stream of its own, and QUIC shares the
+// connection's window between streams without regard to what they carry,
+// so under congestion a backlog of deltas would delay the keyframe behind
+// them. Delta chunks of a prioritized session are written in slices instead,
+// and wait before each one while a keyframe of the session is in flight.
+type keyframeGate struct {
+	mu       sync.Mutex
+	sessions map[string]*keyframes
+
+	held prometheus.Counter
+}
+
+// keyframes are the keyframes of a session being written
+type keyframes struct {
+	inFlight int
+	clear    chan struct{} // closed once inFlight drops to 0
+}
+
+func newKeyframeGate(reg *metrics.Registry) *keyframeGate {
+	return &keyframeGate{
+		sessions: make(map[string]*keyframes),
+		held:     reg.Counter("streaming", "delta_hold_seconds_total", "Time delta chunks waited for keyframes of their session"),
+	}
+}
+
+// begin marks a keyframe of session in flight until the returned func is
+// called
+func (g *keyframeGate) begin(session string) func() {
+	g.mu.Lock()
+	k := g.sessions[session]
+	if k == nil {
+		k = &keyframes{clear: make(chan struct{})}
+		g.sessions[session] = k
+	}
+	k.inFlight++
+	g.mu.Unlock()
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if k.inFlight--; k.inFlight == 0 {
+			close(k.clear)
+			delete(g.sessions, session)
+		}
+	}
+}
+
+// pending returns a channel closed once session has no keyframe in flight,
+// or nil if it has none now
+func (g *keyframeGate) pending(session string) <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if k := g.sessions[session]; k != nil {
+		return k.clear
+	}
+	return nil
+}
+
+// deltas returns a writer for a delta chunk of session that yields to its
+// keyframes
+func (g *keyframeGate) deltas(ctx context.Context, w http.ResponseWriter, session string, c clock.Clock) http.ResponseWriter {
+	return &deltaWriter{ResponseWriter: w, gate: g, session: session, ctx: ctx, clock: c}
+}
+
+// deltaWriter writes a delta chunk in slices, yielding to the keyframes of
+// its session before each one
+type deltaWriter struct {
+	http.ResponseWriter
+	gate    *keyframeGate
+	session string
+	ctx     context.Context
+	held    time.Duration // spent yielding so far
+	clock   clock.Clock
+}
+
+func (d *deltaWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		d.yield()
+		n, err := d.ResponseWriter.Write(p[:min(len(p), deltaSlice)])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		// Hand the slice to the stream now, so that it is what waits on
+		// the connection's window rather than the rest of the chunk
+		d.Flush()
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Flush sends what was written so far, so the slices of a throttled writer
+// wrapping d still go out one by one
+func (d *deltaWriter) Flush() {
+	if f, ok := d.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// yield waits while a keyframe of the session is in flight, for at most
+// what is left of maxDeltaHold
+func (d *deltaWriter) yield() {
+	clear := d.gate.pending(d.session)
+	if clear == nil || d.held >= maxDeltaHold {
+		return
+	}
+	start := d.clock.Now()
+	timer := time.NewTimer(maxDeltaHold - d.held)
+	defer timer.Stop()
+	select {
+	case <-clear:
+	case <-timer.C:
+	case <-d.ctx.Done():
+	}
+	waited := d.clock.Now().Sub(start)
+	d.held += waited
+	d.gate.held.Add(waited.Seconds())
+}
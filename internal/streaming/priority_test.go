@@ -0,0 +1,94 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestChunkRoles(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg))
+	for _, tt := range []struct {
+		index int
+		want  string
+	}{{0, RoleKeyframe}, {9, RoleDelta}, {10, RoleKeyframe}, {11, RoleDelta}} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/stream/chunk/stream_001?quality=low&chunk=%d&%s=true", tt.index, PrioritizeParam), nil))
+		if rec.Code != http.StatusOK || rec.Header().Get(RoleHeader) != tt.want {
+			t.Errorf("chunk %d: status %d, role %q, want %s", tt.index, rec.Code, rec.Header().Get(RoleHeader), tt.want)
+		}
+	}
+}
+
+// writeDelta writes a delta chunk of session through g and reports when
+// it's done
+func writeDelta(ctx context.Context, g *keyframeGate, session string) <-chan int {
+	done := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		g.deltas(ctx, rec, session, clock.Real()).Write(make([]byte, 3*deltaSlice))
+		done <- rec.Body.Len()
+	}()
+	return done
+}
+
+func TestDeltasYieldToKeyframes(t *testing.T) {
+	reg := metrics.NewRegistry()
+	g := newKeyframeGate(reg)
+	keyframeDone := g.begin("viewer")
+
+	held := writeDelta(context.Background(), g, "viewer")
+	// Deltas of other sessions don't wait for it
+	select {
+	case n := <-writeDelta(context.Background(), g, "other"):
+		if n != 3*deltaSlice {
+			t.Errorf("delta of another session wrote %d bytes", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delta of another session waited for the keyframe")
+	}
+	select {
+	case <-held:
+		t.Fatal("delta went out while a keyframe of its session was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	keyframeDone()
+	select {
+	case n := <-held:
+		if n != 3*deltaSlice {
+			t.Errorf("held delta wrote %d bytes", n)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delta still held after the keyframe went out")
+	}
+	if g.pending("viewer") != nil {
+		t.Error("keyframe still pending once written")
+	}
+	if got := metricValue(t, reg, "commsys_streaming_delta_hold_seconds_total"); got == "" || got == "0" {
+		t.Errorf("delta_hold_seconds_total = %q, want the time held", got)
+	}
+}
+
+func TestHeldDeltaEndsWithRequest(t *testing.T) {
+	g := newKeyframeGate(metrics.NewRegistry())
+	defer g.begin("viewer")()
+	ctx, cancel := context.WithCancel(context.Background())
+	held := writeDelta(ctx, g, "viewer")
+	cancel()
+	select {
+	case <-held:
+	case <-time.After(time.Second):
+		t.Fatal("delta of an ended request kept waiting for the keyframe")
+	}
+}
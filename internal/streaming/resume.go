@@ -0,0 +1,208 @@
+package streaming
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// ResumeTokenHeader carries the token a live viewer resumes its session with,
+// in the resume_token parameter of its next /stream/live request
+const ResumeTokenHeader = "X-Resume-Token"
+
+// Statuses of a live session, given by its first event
+const (
+	ResumeNew     = "new"     // no token was given
+	ResumeResumed = "resumed" // the session continues
+	ResumeExpired = "expired" // the session ended longer than the grace period ago
+	ResumeUnknown = "unknown" // the token was never issued, or its session was dropped
+	ResumeActive  = "active"  // the session still plays on a connection that didn't let go
+)
+
+// liveFrames is how many frames a live session sends before it completes
+const liveFrames = 30
+
+// takeoverWait bounds how long a resume waits for the connection still
+// playing the session to let go of it
+const takeoverWait = 2 * time.Second
+
+// SessionEvent is the first event of a live session. Counts are over every
+// connection that played the session.
+type SessionEvent struct {
+	Type        string `json:"type"` // always "session"
+	ResumeToken string `json:"resume_token"`
+	Status      string `json:"status"`
+	Sequence    int    `json:"sequence"` // frame_id of the next frame
+	Quality     string `json:"quality,omitempty"`
+	FramesSent  int    `json:"frames_sent"`
+	BytesSent   int64  `json:"bytes_sent"`
+	Resumes     int    `json:"resumes"`
+}
+
+// Resumption keeps live sessions for a grace period after their connection
+// ends, so a viewer that lost it, e.g. as it moved to another network, can
+// pick up where it left off. Beyond the session limit, the sessions that
+// ended first are dropped.
+type Resumption struct {
+	grace    time.Duration
+	max      int
+	mu       sync.Mutex
+	sessions map[string]*liveSession
+
+	resumes *metrics.CounterVec
+}
+
+// liveSession is what a live session carries over from one connection to
+// the next
+type liveSession struct {
+	token   string
+	quality string // empty picks one per frame
+	next    int    // frame_id of the next frame
+	frames  int
+	bytes   int64
+	resumes int
+
+	ended    time.Time     // zero while a connection plays the session
+	takeover chan struct{} // closed to make that connection let go
+	released chan struct{} // closed once it did
+}
+
+// NewResumption creates a store of live sessions ended less than grace ago,
+// at most sessions of them. It returns nil if grace is 0.
+func NewResumption(grace time.Duration, sessions int, reg *metrics.Registry) *Resumption {
+	if grace == 0 {
+		return nil
+	}
+	return &Resumption{
+		grace:    grace,
+		max:      sessions,
+		sessions: make(map[string]*liveSession),
+		resumes:  reg.CounterVec("streaming", "live_resumes_total", "Live session resume attempts by status", "status"),
+	}
+}
+
+// WithResumption lets live viewers resume their sessions from r
+func WithResumption(r *Resumption) Option {
+	return func(h *Handler) {
+		h.resume = r
+	}
+}
+
+// begin returns the session of token to play from after frame last, or a
+// new session of quality if it can't be resumed, with its status. last is
+// -1 to continue after the last frame sent. A nil Resumption starts a
+// session without a token.
+func (r *Resumption) begin(token string, last int, quality string, now time.Time) (*liveSession, string) {
+	if r == nil {
+		return &liveSession{quality: quality}, ResumeNew
+	}
+	status := ResumeNew
+	if token != "" {
+		var s *liveSession
+		s, status = r.resume(token, now)
+		if s != nil {
+			if last >= 0 {
+				s.next = min(last+1, s.next)
+			}
+			r.resumes.WithLabelValues(status).Inc()
+			return s, status
+		}
+		r.resumes.WithLabelValues(status).Inc()
+	}
+
+	s := &liveSession{
+		token:    newResumeToken(),
+		quality:  quality,
+		takeover: make(chan struct{}),
+		released: make(chan struct{}),
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evictLocked(now)
+	r.sessions[s.token] = s
+	return s, status
+}
+
+// resume claims the session of token, taking it over from a connection
+// still playing it
+func (r *Resumption) resume(token string, now time.Time) (*liveSession, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.sessions[token]
+	if !ok {
+		return nil, ResumeUnknown
+	}
+	if s.ended.IsZero() {
+		// The old connection may not have noticed it is gone yet
+		select {
+		case <-s.takeover:
+		default:
+			close(s.takeover)
+		}
+		released := s.released
+		r.mu.Unlock()
+		timer := time.NewTimer(takeoverWait)
+		select {
+		case <-released:
+		case <-timer.C:
+		}
+		timer.Stop()
+		r.mu.Lock()
+		if r.sessions[token] != s || s.ended.IsZero() {
+			return nil, ResumeActive
+		}
+	} else if now.Sub(s.ended) >= r.grace {
+		delete(r.sessions, token)
+		return nil, ResumeExpired
+	}
+	s.ended = time.Time{}
+	s.resumes++
+	s.takeover = make(chan struct{})
+	s.released = make(chan struct{})
+	return s, ResumeResumed
+}
+
+// end keeps s for resumption once its connection ended at now
+func (r *Resumption) end(s *liveSession, now time.Time) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.ended = now
+	close(s.released)
+}
+
+// evictLocked drops the sessions that ended longer than the grace period
+// ago, then those that ended first while there are too many. Sessions being
+// played are never dropped. r.mu must be held.
+func (r *Resumption) evictLocked(now time.Time) {
+	var ended []*liveSession
+	for token, s := range r.sessions {
+		switch {
+		case s.ended.IsZero():
+		case now.Sub(s.ended) >= r.grace:
+			delete(r.sessions, token)
+		default:
+			ended = append(ended, s)
+		}
+	}
+	excess := len(r.sessions) + 1 - r.max
+	if excess <= 0 {
+		return
+	}
+	sort.Slice(ended, func(i, j int) bool { return ended[i].ended.Before(ended[j].ended) })
+	for _, s := range ended[:min(excess, len(ended))] {
+		delete(r.sessions, s.token)
+	}
+}
+
+func newResumeToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,132 @@
+package streaming
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// ended waits until no connection plays the session of token
+func (f *liveFixture) ended(t *testing.T, token string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		f.resume.mu.Lock()
+		s := f.resume.sessions[token]
+		done := s != nil && !s.ended.IsZero()
+		f.resume.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("session %s still played a second after its connection closed", token)
+}
+
+func TestResumeWithinGraceContinues(t *testing.T) {
+	f := newLiveFixture(t)
+	v := f.watch(t, "quality=medium")
+	if v.session.Status != ResumeNew || v.session.ResumeToken == "" || v.session.Sequence != 0 {
+		t.Fatalf("session %+v, want a new one with a token", v.session)
+	}
+	for want := 0; want < 5; want++ {
+		if got := v.frame(); got != want {
+			t.Fatalf("frame %d, want %d", got, want)
+		}
+	}
+	v.close()
+	f.ended(t, v.session.ResumeToken)
+
+	f.clock.Advance(testGrace / 2)
+	resumed := f.watch(t, "resume_token="+v.session.ResumeToken+"&last_sequence=4")
+	s := resumed.session
+	if s.Status != ResumeResumed || s.ResumeToken != v.session.ResumeToken {
+		t.Fatalf("session %+v, want %s resumed", s, v.session.ResumeToken)
+	}
+	// Quality and counts carry over; a frame sent as the connection went
+	// may be counted too
+	if s.Sequence != 5 || s.Quality != "medium" || s.FramesSent < 5 || s.BytesSent == 0 || s.Resumes != 1 {
+		t.Errorf("resumed session %+v, want sequence 5 at medium after 5 frames", s)
+	}
+	if got := resumed.frame(); got != 5 {
+		t.Errorf("first frame after resuming %d, want 5", got)
+	}
+}
+
+func TestResumeReplaysFramesAfterLastSequence(t *testing.T) {
+	f := newLiveFixture(t)
+	v := f.watch(t, "")
+	for i := 0; i < 5; i++ {
+		v.frame()
+	}
+	v.close()
+	f.ended(t, v.session.ResumeToken)
+
+	// The viewer only got frames up to 2 before the connection went
+	resumed := f.watch(t, "resume_token="+v.session.ResumeToken+"&last_sequence=2")
+	if resumed.session.Sequence != 3 {
+		t.Errorf("resumed at %d, want 3", resumed.session.Sequence)
+	}
+}
+
+func TestResumeAfterExpiryStartsFresh(t *testing.T) {
+	f := newLiveFixture(t)
+	v := f.watch(t, "quality=high")
+	for i := 0; i < 3; i++ {
+		v.frame()
+	}
+	v.close()
+	f.ended(t, v.session.ResumeToken)
+
+	f.clock.Advance(testGrace)
+	fresh := f.watch(t, "quality=low&resume_token="+v.session.ResumeToken+"&last_sequence=2")
+	s := fresh.session
+	if s.Status != ResumeExpired || s.ResumeToken == v.session.ResumeToken {
+		t.Fatalf("session %+v, want a new one after expiry", s)
+	}
+	if s.Sequence != 0 || s.FramesSent != 0 || s.Quality != "low" || s.Resumes != 0 {
+		t.Errorf("session %+v, want a fresh start at the requested quality", s)
+	}
+}
+
+func TestResumeUnknownTokenStartsFresh(t *testing.T) {
+	f := newLiveFixture(t)
+	v := f.watch(t, "resume_token=0123456789abcdef&last_sequence=10")
+	if v.session.Status != ResumeUnknown || v.session.Sequence != 0 {
+		t.Errorf("session %+v, want a fresh one for an unknown token", v.session)
+	}
+}
+
+func TestResumptionBoundsEndedSessions(t *testing.T) {
+	const max = 5
+	r := NewResumption(testGrace, max, metrics.NewRegistry())
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tokens []string
+	for i := 0; i < 3*max; i++ {
+		s, _ := r.begin("", -1, "", now)
+		now = now.Add(time.Second)
+		r.end(s, now)
+		tokens = append(tokens, s.token)
+	}
+	if len(r.sessions) > max {
+		t.Fatalf("%d sessions kept, want at most %d", len(r.sessions), max)
+	}
+	// The sessions that ended first went first
+	if _, status := r.begin(tokens[0], -1, "", now); status != ResumeUnknown {
+		t.Errorf("oldest session %s, want it dropped", status)
+	}
+	if _, status := r.begin(tokens[len(tokens)-1], -1, "", now); status != ResumeResumed {
+		t.Errorf("latest session %s, want it resumed", status)
+	}
+}
+
+func TestResumptionDisabled(t *testing.T) {
+	if r := NewResumption(0, 10, metrics.NewRegistry()); r != nil {
+		t.Error("resumption created without a grace period")
+	}
+	var r *Resumption
+	if s, status := r.begin("token", 3, "high", time.Now()); s.token != "" || status != ResumeNew {
+		t.Errorf("nil resumption began %+v, %s", s, status)
+	}
+}
@@ -0,0 +1,89 @@
+package streaming
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// WithSeededStreams adds count synthetic streams to the catalog so clients
+// have something to play without any content. The streams are flagged as
+// seeded, take their durations from durations in turn and offer the
+// qualities of ladder, or every rung when it is empty. Their chunks are
+// always generated, even when content is served from disk.
+func WithSeededStreams(count int, durations []time.Duration, ladder []string) Option {
+	return func(h *Handler) {
+		h.seed = seedConfig{count: count, durations: durations, ladder: ladder}
+	}
+}
+
+type seedConfig struct {
+	count     int
+	durations []time.Duration
+	ladder    []string
+}
+
+// SeededStreamID returns the stream ID of the i-th seeded stream, from 0
+func SeededStreamID(i int) string {
+	return fmt.Sprintf("seed_%03d", i+1)
+}
+
+// seedCatalog returns the seeded streams, created at now
+func (h *Handler) seedCatalog(now time.Time) []StreamInfo {
+	cfg := h.seed
+	if cfg.count <= 0 || len(cfg.durations) == 0 {
+		return nil
+	}
+	streams := make([]StreamInfo, cfg.count)
+	for i := range streams {
+		id := SeededStreamID(i)
+		s := StreamInfo{
+			StreamID:  id,
+			Title:     fmt.Sprintf("Synthetic Stream %d", i+1),
+			Duration:  int(cfg.durations[i%len(cfg.durations)] / time.Second),
+			Format:    "h264",
+			CreatedAt: now,
+			Seeded:    true,
+		}
+		h.offer(&s, cfg.ladder)
+		streams[i] = s
+	}
+	return streams
+}
+
+// SeededChunks returns the number of chunks of chunk duration of a seeded
+// stream lasting d
+func SeededChunks(d, chunk time.Duration) int {
+	seconds := d / time.Second * time.Second
+	return int((seconds + chunk - 1) / chunk)
+}
+
+// seededChunkSize returns the size of a chunk of a seeded stream, or the
+// status and message to refuse it with: 404 for qualities the stream doesn't
+// offer and chunks past its end
+func seededChunkSize(s *StreamInfo, ordinal int, quality string, index int, chunk time.Duration) (int, int, string) {
+	for _, b := range s.Bitrates {
+		if b.Quality != quality {
+			continue
+		}
+		if index < 0 || index >= SeededChunks(time.Duration(s.Duration)*time.Second, chunk) {
+			return 0, http.StatusNotFound, fmt.Sprintf("Chunk %d of stream %s at quality %s not found", index, s.StreamID, quality)
+		}
+		return vbrChunkSize(b.Bitrate, ordinal, index, chunk), http.StatusOK, ""
+	}
+	return 0, http.StatusNotFound, fmt.Sprintf("Rendition %s of stream %s not found", quality, s.StreamID)
+}
+
+// vbrChunkSize models a variable bitrate encode at about kbps: scene
+// complexity drifts slowly, offset per stream, and every keyframe chunk is
+// larger. Sizes are deterministic so a chunk is the same on every request,
+// and capped at MaxChunkSize.
+func vbrChunkSize(kbps, ordinal, index int, chunk time.Duration) int {
+	factor := 0.9 + 0.25*math.Sin(float64(index)/5+float64(ordinal))
+	if index%10 == 0 {
+		factor += 0.6
+	}
+	size := float64(kbps) * 1000 / 8 * chunk.Seconds() * factor
+	return min(int(size), MaxChunkSize)
+}
@@ -0,0 +1,88 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestSeededChunks(t *testing.T) {
+	for _, tt := range []struct {
+		d, chunk time.Duration
+		want     int
+	}{
+		{time.Minute, 2 * time.Second, 30},
+		{90 * time.Second, 4 * time.Second, 23},
+		{61500 * time.Millisecond, 2 * time.Second, 31}, // whole seconds only
+		{0, 2 * time.Second, 0},
+	} {
+		if got := SeededChunks(tt.d, tt.chunk); got != tt.want {
+			t.Errorf("%v in chunks of %v: %d, want %d", tt.d, tt.chunk, got, tt.want)
+		}
+	}
+}
+
+func TestSeededStreamsListedAndPlayable(t *testing.T) {
+	reg := metrics.NewRegistry()
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg),
+		WithSeededStreams(3, []time.Duration{time.Minute, 90 * time.Second}, []string{"low", "high"}))
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	var list struct {
+		Streams []StreamInfo `json:"streams"`
+	}
+	if err := json.NewDecoder(get("/stream/list").Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	seeded := map[string]StreamInfo{}
+	for _, s := range list.Streams {
+		if s.Seeded {
+			seeded[s.StreamID] = s
+		}
+	}
+	for i, duration := range []int{60, 90, 60} {
+		s, ok := seeded[SeededStreamID(i)]
+		if !ok || s.Duration != duration || len(s.Bitrates) != 2 || s.Bitrates[0].Quality != "low" || s.Bitrates[1].Quality != "high" {
+			t.Errorf("seeded stream %d: %+v, want %ds at low and high", i, s, duration)
+		}
+	}
+	if len(seeded) != 3 {
+		t.Errorf("%d seeded streams listed, want 3", len(seeded))
+	}
+
+	// Chunks are the same on every request, and keyframes are larger
+	chunk := func(quality string, index int) (int, int) {
+		rec := get(fmt.Sprintf("/stream/chunk/seed_001?quality=%s&chunk=%d", quality, index))
+		return rec.Code, rec.Body.Len()
+	}
+	status, keyframe := chunk("low", 10)
+	if _, again := chunk("low", 10); status != http.StatusOK || again != keyframe {
+		t.Errorf("chunk 10: status %d, %d then %d bytes", status, keyframe, again)
+	}
+	if _, delta := chunk("low", 11); delta >= keyframe {
+		t.Errorf("delta chunk of %d bytes, want less than the keyframe's %d", delta, keyframe)
+	}
+	if _, high := chunk("high", 10); high <= keyframe {
+		t.Errorf("chunk of %d bytes at high, want more than %d at low", high, keyframe)
+	}
+	for _, tt := range []struct {
+		quality string
+		index   int
+	}{{"low", 30}, {"low", -1}, {"medium", 0}} {
+		if status, _ := chunk(tt.quality, tt.index); status != http.StatusNotFound {
+			t.Errorf("chunk %d at %s: status %d, want 404", tt.index, tt.quality, status)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package streaming
+
+import (
+	"sync"
+	"time"
+
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+)
+
+// statWindow is how recently a viewer must have been sent a chunk, or have
+// reported, for its path and buffer to count in the stats of its stream
+const statWindow = 30 * time.Second
+
+// Bounds of the streams and viewers followed for stats. The least recently
+// active one is dropped first.
+const (
+	maxMeteredStreams = 1000
+	maxMeteredViewers = 1000 // per stream
+)
+
+// WithPaths reports the RTT and packet loss of the QUIC connections of each
+// stream's viewers in its stats
+func WithPaths(p *quiclib.Paths) Option {
+	return func(h *Handler) {
+		h.paths = p
+	}
+}
+
+// streamMeters measures what the viewers of each stream were sent, how long
+// writing it took, and what their connections and players reported
+type streamMeters struct {
+	mu      sync.Mutex
+	streams map[string]*streamMeter
+}
+
+type streamMeter struct {
+	first   time.Time // first chunk sent
+	last    time.Time // latest chunk sent or report
+	bytes   int64
+	chunks  int
+	writing time.Duration // blocked in writes of chunks
+	corrupt int           // chunks reported corrupt
+	viewers map[string]*viewerMeter
+}
+
+// viewerMeter is the latest that is known of one session of a stream
+type viewerMeter struct {
+	seen     time.Time
+	path     quiclib.PathStats
+	hasPath  bool
+	rtt      time.Duration // reported by the player, 0 if unknown
+	buffer   float64
+	reported bool
+}
+
+func newStreamMeters() *streamMeters {
+	return &streamMeters{streams: make(map[string]*streamMeter)}
+}
+
+// sent records that session was sent n bytes of chunks of streamID in a
+// write that blocked for d, over the connection measured by path if ok
+func (m *streamMeters) sent(streamID, session string, chunks, n int, d time.Duration, path quiclib.PathStats, ok bool, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, v := m.viewerLocked(streamID, session, now)
+	if s.first.IsZero() {
+		s.first = now
+	}
+	s.bytes += int64(n)
+	s.chunks += chunks
+	s.writing += d
+	if ok {
+		v.path, v.hasPath = path, true
+	}
+}
+
+// reported records the playback report of session on streamID
+func (m *streamMeters) reported(streamID, session string, report ClientReport, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, v := m.viewerLocked(streamID, session, now)
+	s.corrupt += report.CorruptChunks
+	v.buffer, v.reported = report.BufferSeconds, true
+	v.rtt = time.Duration(report.RTTMillis * float64(time.Millisecond))
+}
+
+// viewerLocked returns the meters of streamID and session, added if
+// needed, and marks them active at now. m.mu must be held.
+func (m *streamMeters) viewerLocked(streamID, session string, now time.Time) (*streamMeter, *viewerMeter) {
+	s := m.streams[streamID]
+	if s == nil {
+		if len(m.streams) >= maxMeteredStreams {
+			oldest := ""
+			for id, other := range m.streams {
+				if oldest == "" || other.last.Before(m.streams[oldest].last) {
+					oldest = id
+				}
+			}
+			delete(m.streams, oldest)
+		}
+		s = &streamMeter{viewers: make(map[string]*viewerMeter)}
+		m.streams[streamID] = s
+	}
+	v := s.viewers[session]
+	if v == nil {
+		if len(s.viewers) >= maxMeteredViewers {
+			oldest := ""
+			for id, other := range s.viewers {
+				if oldest == "" || other.seen.Before(s.viewers[oldest].seen) {
+					oldest = id
+				}
+			}
+			delete(s.viewers, oldest)
+		}
+		v = &viewerMeter{}
+		s.viewers[session] = v
+	}
+	s.last, v.seen = now, now
+	return s, v
+}
+
+// fill sets the measured fields of stats, and leaves those nothing was
+// measured for nil
+func (m *streamMeters) fill(stats *StreamStats, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.streams[stats.StreamID]
+	if s == nil {
+		return
+	}
+	stats.BytesSent = s.bytes
+	stats.ChunksSent = s.chunks
+	stats.ChecksumFailures = s.corrupt
+	if s.chunks > 0 {
+		stats.Latency = ptr(float64(s.writing) / float64(time.Millisecond) / float64(s.chunks))
+		stats.Uptime = ptr(int64(now.Sub(s.first) / time.Second))
+	}
+	if s.writing > 0 {
+		stats.Bandwidth = ptr(float64(s.bytes*8) / s.writing.Seconds() / 1e6)
+	}
+
+	var rtt time.Duration
+	var rtts, buffers int
+	var buffer float64
+	var sent, lost int64
+	for _, v := range s.viewers {
+		if now.Sub(v.seen) > statWindow {
+			continue
+		}
+		// The connection measures the RTT better than the player
+		switch {
+		case v.hasPath && v.path.RTT > 0:
+			rtt += v.path.RTT
+			rtts++
+		case v.rtt > 0:
+			rtt += v.rtt
+			rtts++
+		}
+		if v.hasPath {
+			sent += v.path.PacketsSent
+			lost += v.path.PacketsLost
+		}
+		if v.reported {
+			buffer += v.buffer
+			buffers++
+		}
+	}
+	if rtts > 0 {
+		stats.RTT = ptr(float64(rtt) / float64(time.Millisecond) / float64(rtts))
+	}
+	if sent > 0 {
+		stats.PacketLoss = ptr(quiclib.PathStats{PacketsSent: sent, PacketsLost: lost}.LossPercent())
+	}
+	if buffers > 0 {
+		stats.BufferHealth = ptr(buffer / float64(buffers))
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,101 @@
+package streaming
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	quiclib "github.com/nik1740/quic-communication-system/internal/quic"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestStreamMetersFillStats(t *testing.T) {
+	m := newStreamMeters()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	path := quiclib.PathStats{RTT: 20 * time.Millisecond, PacketsSent: 100, PacketsLost: 4}
+
+	// alice's connection is measured, bob only reports
+	m.sent("s1", "alice", 1, 250_000, 100*time.Millisecond, path, true, start)
+	m.sent("s1", "bob", 2, 250_000, 100*time.Millisecond, quiclib.PathStats{}, false, start.Add(10*time.Second))
+	m.reported("s1", "alice", ClientReport{RTTMillis: 90, BufferSeconds: 4}, start.Add(10*time.Second))
+	m.reported("s1", "bob", ClientReport{RTTMillis: 40, BufferSeconds: 8, CorruptChunks: 1}, start.Add(10*time.Second))
+
+	stats := StreamStats{StreamID: "s1"}
+	m.fill(&stats, start.Add(20*time.Second))
+	if stats.BytesSent != 500_000 || stats.ChunksSent != 3 || stats.ChecksumFailures != 1 {
+		t.Errorf("sent %d bytes in %d chunks with %d corrupt, want 500000 in 3 with 1", stats.BytesSent, stats.ChunksSent, stats.ChecksumFailures)
+	}
+	for _, f := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"latency", *stats.Latency, 200.0 / 3},
+		{"bandwidth", *stats.Bandwidth, 20},
+		{"RTT", *stats.RTT, 30}, // alice's connection, bob's report
+		{"packet loss", *stats.PacketLoss, 4},
+		{"buffer", *stats.BufferHealth, 6},
+	} {
+		if diff := f.got - f.want; diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("%s %v, want %v", f.name, f.got, f.want)
+		}
+	}
+	if *stats.Uptime != 20 {
+		t.Errorf("uptime %ds, want 20s", *stats.Uptime)
+	}
+
+	// Viewers gone quiet no longer count
+	stats = StreamStats{StreamID: "s1"}
+	m.fill(&stats, start.Add(time.Minute))
+	if stats.RTT != nil || stats.PacketLoss != nil || stats.BufferHealth != nil || stats.BytesSent != 500_000 {
+		t.Errorf("stats %+v, want the totals without viewers", stats)
+	}
+}
+
+func TestStreamStatsMeasured(t *testing.T) {
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(c),
+		WithAdapter(NewAdapter(cfg.Streaming.ABR, c, reg)))
+	stats := func() StreamStats {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/stats/stream_001", nil))
+		var stats StreamStats
+		if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+			t.Fatal(err)
+		}
+		return stats
+	}
+
+	if s := stats(); s.ChunksSent != 0 || s.Latency != nil || s.Bandwidth != nil || s.RTT != nil || s.Uptime != nil || s.ActiveClients != nil {
+		t.Errorf("stats of a stream never sent %+v, want nothing measured", s)
+	}
+
+	size := 0
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream/chunk/stream_001?quality=low&chunk=1", nil))
+		size += rec.Body.Len()
+		c.Advance(5 * time.Second)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/stream/report/stream_001", strings.NewReader(`{"quality": "low", "buffer_seconds": 3, "rtt_ms": 25}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("report: status %d", rec.Code)
+	}
+
+	s := stats()
+	if s.ChunksSent != 2 || s.BytesSent != int64(size) || s.Uptime == nil || *s.Uptime != 10 {
+		t.Errorf("stats %+v, want 2 chunks of %d bytes over 10s", s, size)
+	}
+	if s.RTT == nil || *s.RTT != 25 || s.BufferHealth == nil || *s.BufferHealth != 3 || s.PacketLoss != nil {
+		t.Errorf("stats %+v, want the reported RTT and buffer, and no packet loss over TCP", s)
+	}
+}
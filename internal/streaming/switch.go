@@ -0,0 +1,217 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// maxSwitchRequestSize bounds the body of a quality change request
+const maxSwitchRequestSize = 1024
+
+// maxSwitchSessions bounds the sessions whose manual switches are kept
+const maxSwitchSessions = 10000
+
+// QualityChangeRequest switches the quality of a player by hand. Players
+// send it to POST /stream/quality/{stream_id}, in the same session as their
+// chunk requests. The quality picked is pinned: the adapter recommends no
+// other until the player sends Auto.
+type QualityChangeRequest struct {
+	Quality string `json:"quality,omitempty"` // rendition to switch to
+	Auto    bool   `json:"auto,omitempty"`    // hand the quality back to the adapter
+	Chunk   int    `json:"chunk"`             // next chunk the player requests
+}
+
+// QualitySwitch acknowledges a QualityChangeRequest. The server serves the
+// chunks of the session at Quality, whatever quality they ask for, from the
+// first keyframe at or after the chunk of the request on, and tells which in
+// their X-Quality header.
+type QualitySwitch struct {
+	Quality   string `json:"quality"`    // effective once applied
+	Seq       int    `json:"seq"`        // of the switches of the session, from 1
+	FromChunk int    `json:"from_chunk"` // keyframe it applies at, -1 until ingested for live streams
+	Pinned    bool   `json:"pinned"`     // false once handed back to the adapter
+}
+
+// switches are the manual quality switches of the sessions watching each
+// stream
+type switches struct {
+	mu       sync.Mutex
+	sessions map[switchKey]*manualSwitch
+
+	results *metrics.CounterVec
+}
+
+type switchKey struct {
+	streamID string
+	session  string
+}
+
+// manualSwitch is the latest switch of a session
+type manualSwitch struct {
+	quality string // "" once handed back to the adapter
+	seq     int
+	from    int // first chunk it may apply at
+	applied int // chunk it applied at, -1 while pending
+	updated time.Time
+}
+
+func newSwitches(reg *metrics.Registry) *switches {
+	return &switches{
+		sessions: make(map[switchKey]*manualSwitch),
+		results:  reg.CounterVec("streaming", "manual_switches_total", "Manual quality switches by result", "result"),
+	}
+}
+
+// request pins session to quality from chunk from on, at the first keyframe
+func (s *switches) request(streamID, session, quality string, from int, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sessionLocked(streamID, session, now)
+	m.seq++
+	m.quality, m.from, m.applied = quality, from, -1
+	return m.seq
+}
+
+// release hands the quality of session back to the adapter
+func (s *switches) release(streamID, session string, now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sessionLocked(streamID, session, now)
+	m.seq++
+	m.quality = ""
+	return m.seq
+}
+
+// sessionLocked returns the switches of session, making room for them if it
+// has none. s.mu must be held.
+func (s *switches) sessionLocked(streamID, session string, now time.Time) *manualSwitch {
+	key := switchKey{streamID, session}
+	m := s.sessions[key]
+	if m == nil {
+		if len(s.sessions) >= maxSwitchSessions {
+			s.evictLocked()
+		}
+		m = &manualSwitch{}
+		s.sessions[key] = m
+	}
+	m.updated = now
+	return m
+}
+
+// evictLocked drops the session switched least recently. s.mu must be held.
+func (s *switches) evictLocked() {
+	var oldest switchKey
+	var at time.Time
+	for key, m := range s.sessions {
+		if at.IsZero() || m.updated.Before(at) {
+			oldest, at = key, m.updated
+		}
+	}
+	delete(s.sessions, oldest)
+}
+
+// apply returns the quality session switched to for chunk index, or false if
+// it plays the quality it asks for. A pending switch applies at the first
+// keyframe at or after the chunk it was requested at.
+func (s *switches) apply(streamID, session string, index int, keyframe bool) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sessions[switchKey{streamID, session}]
+	if m == nil || m.quality == "" {
+		return "", false
+	}
+	if m.applied < 0 {
+		if index < m.from || !keyframe {
+			return "", false
+		}
+		m.applied = index
+		s.results.WithLabelValues("applied").Inc()
+	}
+	// Chunks from before the switch, e.g. asked for again, keep their quality
+	if index < m.applied {
+		return "", false
+	}
+	return m.quality, true
+}
+
+// pinned reports whether session picked its quality by hand
+func (s *switches) pinned(streamID, session string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.sessions[switchKey{streamID, session}]
+	return m != nil && m.quality != ""
+}
+
+// nextKeyframe returns the first keyframe of streamID at or after index, or
+// -1 if the stream is live and it wasn't ingested yet
+func (h *Handler) nextKeyframe(streamID string, index int) int {
+	if src := h.ingest.source(streamID); src != nil {
+		_, newest := h.ingest.buffered(src)
+		for i := index; i <= newest; i++ {
+			if h.ingest.role(src, i) == RoleKeyframe {
+				return i
+			}
+		}
+		return -1
+	}
+	for chunkRole(index) != RoleKeyframe {
+		index++
+	}
+	return index
+}
+
+func (h *Handler) handleQualityChange(w http.ResponseWriter, r *http.Request, streamID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req QualityChangeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSwitchRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "Invalid quality change request", http.StatusBadRequest)
+		return
+	}
+	if (req.Quality == "") == !req.Auto {
+		http.Error(w, "Either quality or auto is required", http.StatusBadRequest)
+		return
+	}
+	if req.Chunk < 0 {
+		http.Error(w, fmt.Sprintf("Invalid chunk %d", req.Chunk), http.StatusBadRequest)
+		return
+	}
+	ladder, ok := h.ladder(streamID)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Stream %s not found", streamID), http.StatusNotFound)
+		return
+	}
+
+	session := quota.SessionKey(r)
+	now := h.clock.Now()
+	var ack QualitySwitch
+	if req.Auto {
+		ack = QualitySwitch{Seq: h.switches.release(streamID, session, now), FromChunk: req.Chunk}
+	} else {
+		if !slices.ContainsFunc(ladder, func(b Bitrate) bool { return b.Quality == req.Quality }) {
+			h.switches.results.WithLabelValues("rejected").Inc()
+			http.Error(w, fmt.Sprintf("Quality %q is not available for stream %s", req.Quality, streamID), http.StatusBadRequest)
+			return
+		}
+		ack = QualitySwitch{
+			Quality:   req.Quality,
+			Seq:       h.switches.request(streamID, session, req.Quality, req.Chunk, now),
+			FromChunk: h.nextKeyframe(streamID, req.Chunk),
+			Pinned:    true,
+		}
+	}
+	h.logger.Info("Quality switched by the viewer", logging.F("session", session), logging.F("stream_id", streamID),
+		logging.F("quality", ack.Quality), logging.F("auto", req.Auto), logging.F("seq", ack.Seq), logging.F("from_chunk", ack.FromChunk))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ack)
+}
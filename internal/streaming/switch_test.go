@@ -0,0 +1,156 @@
+package streaming
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// switchFixture is a handler with adaptation whose viewers switch quality
+type switchFixture struct {
+	t     *testing.T
+	h     *Handler
+	clock *clock.Fake
+	reg   *metrics.Registry
+}
+
+func newSwitchFixture(t *testing.T) *switchFixture {
+	t.Helper()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(cfg.Quotas, reg), WithClock(c),
+		WithAdapter(NewAdapter(cfg.Streaming.ABR, c, reg)))
+	return &switchFixture{t: t, h: h, clock: c, reg: reg}
+}
+
+func (f *switchFixture) do(method, target, session, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("X-Session-ID", session)
+	rec := httptest.NewRecorder()
+	f.h.ServeHTTP(rec, req)
+	return rec
+}
+
+// switchTo asks for a switch of session and returns the acknowledgement
+func (f *switchFixture) switchTo(session, body string) QualitySwitch {
+	f.t.Helper()
+	rec := f.do(http.MethodPost, "/stream/quality/stream_001", session, body)
+	var ack QualitySwitch
+	if err := json.NewDecoder(rec.Body).Decode(&ack); rec.Code != http.StatusOK || err != nil {
+		f.t.Fatalf("switch %s: status %d, %v", body, rec.Code, err)
+	}
+	return ack
+}
+
+// served returns the quality chunk index asked for at low is served at
+func (f *switchFixture) served(session string, index int) string {
+	f.t.Helper()
+	rec := f.do(http.MethodGet, fmt.Sprintf("/stream/chunk/stream_001?quality=low&chunk=%d", index), session, "")
+	if rec.Code != http.StatusOK {
+		f.t.Fatalf("chunk %d: status %d", index, rec.Code)
+	}
+	return rec.Header().Get("X-Quality")
+}
+
+func TestManualSwitchAppliesAtKeyframe(t *testing.T) {
+	f := newSwitchFixture(t)
+	ack := f.switchTo("viewer", `{"quality": "high", "chunk": 4}`)
+	if ack != (QualitySwitch{Quality: "high", Seq: 1, FromChunk: 10, Pinned: true}) {
+		t.Errorf("acknowledged %+v, want high from keyframe 10", ack)
+	}
+
+	for _, tt := range []struct {
+		index int
+		want  string
+	}{
+		{4, "low"},
+		{9, "low"},
+		{10, "high"},
+		{11, "high"},
+		// Chunks from before the switch keep their quality
+		{9, "low"},
+	} {
+		if got := f.served("viewer", tt.index); got != tt.want {
+			t.Errorf("chunk %d served at %s, want %s", tt.index, got, tt.want)
+		}
+	}
+	if got := f.served("other", 11); got != "low" {
+		t.Errorf("chunk of another session served at %s", got)
+	}
+
+	// Auto hands the quality back
+	if ack := f.switchTo("viewer", `{"auto": true, "chunk": 12}`); ack.Seq != 2 || ack.Pinned {
+		t.Errorf("acknowledged %+v, want switch 2 unpinned", ack)
+	}
+	if got := f.served("viewer", 12); got != "low" {
+		t.Errorf("chunk 12 served at %s after auto, want low", got)
+	}
+	if got := metricValue(t, f.reg, `commsys_streaming_manual_switches_total{result="applied"}`); got != "1" {
+		t.Errorf("manual_switches_total applied = %q, want 1", got)
+	}
+}
+
+func TestPinnedQualityOverridesAdaptation(t *testing.T) {
+	f := newSwitchFixture(t)
+	f.switchTo("viewer", `{"quality": "high", "chunk": 0}`)
+	// Starving reports well past the adapter's dwell
+	report := func() QualityChange {
+		t.Helper()
+		var change QualityChange
+		rec := f.do(http.MethodPost, "/stream/report/stream_001", "viewer", `{"quality": "high", "buffer_seconds": 1, "bitrate_kbps": 1000}`)
+		if err := json.NewDecoder(rec.Body).Decode(&change); err != nil {
+			t.Fatal(err)
+		}
+		f.clock.Advance(reportEvery)
+		return change
+	}
+	for i := 0; i < 10; i++ {
+		if change := report(); change.Changed || change.Quality.Quality != "high" {
+			t.Fatalf("report %d of a pinned session answered %+v, want high unchanged", i, change)
+		}
+	}
+
+	// Once handed back, the adapter steps down after its dwell
+	f.switchTo("viewer", `{"auto": true, "chunk": 0}`)
+	var change QualityChange
+	for i := 0; i < 10 && !change.Changed; i++ {
+		change = report()
+	}
+	if !change.Changed || change.Quality.Quality != "medium" {
+		t.Errorf("reports after auto answered %+v, want a step down to medium", change)
+	}
+}
+
+func TestQualityChangeRejected(t *testing.T) {
+	f := newSwitchFixture(t)
+	for _, tt := range []struct {
+		method, target, body string
+		want                 int
+	}{
+		{http.MethodPost, "/stream/quality/stream_001", `{"quality": "8k"}`, http.StatusBadRequest},
+		{http.MethodPost, "/stream/quality/stream_001", `{"quality": "high", "auto": true}`, http.StatusBadRequest},
+		{http.MethodPost, "/stream/quality/stream_001", `{"chunk": 3}`, http.StatusBadRequest},
+		{http.MethodPost, "/stream/quality/stream_001", `{"quality": "high", "chunk": -1}`, http.StatusBadRequest},
+		{http.MethodPost, "/stream/quality/stream_001", `{"quality":`, http.StatusBadRequest},
+		{http.MethodPost, "/stream/quality/stream_009", `{"quality": "high"}`, http.StatusNotFound},
+		{http.MethodGet, "/stream/quality/stream_001", ``, http.StatusMethodNotAllowed},
+	} {
+		if rec := f.do(tt.method, tt.target, "viewer", tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s %s: status %d, want %d", tt.method, tt.target, tt.body, rec.Code, tt.want)
+		}
+	}
+	if got := metricValue(t, f.reg, `commsys_streaming_manual_switches_total{result="rejected"}`); got != "1" {
+		t.Errorf("manual_switches_total rejected = %q, want 1", got)
+	}
+}
@@ -0,0 +1,176 @@
+package streaming
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ChunkIntervalHeader carries the interval at which a client requests
+// chunks, e.g. "100ms". Sessions without it are scheduled at one chunk
+// duration per chunk.
+const ChunkIntervalHeader = "X-Chunk-Interval"
+
+// ChunkTiming is the send timeline of one chunk. Times are microsecond
+// offsets from the session's first recorded chunk, taken from the monotonic
+// clock.
+type ChunkTiming struct {
+	Chunk      int    `json:"chunk"`
+	Quality    string `json:"quality"`
+	Size       int    `json:"size"`
+	Scheduled  int64  `json:"scheduled_us"` // when the chunk is due at the session's chunk interval
+	Received   int64  `json:"received_us"`
+	WriteStart int64  `json:"write_start_us"`
+	WriteEnd   int64  `json:"write_end_us"`
+}
+
+// Timelines keeps the last chunk timings of recent streaming sessions. Memory
+// is bounded by chunks per session times the number of sessions; the session
+// that sent a chunk least recently is dropped first.
+type Timelines struct {
+	chunks      int
+	maxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]*timeline
+}
+
+type timeline struct {
+	start      time.Time // first recorded chunk, carries the monotonic reading
+	firstChunk int
+	interval   time.Duration
+	last       time.Time
+	entries    []ChunkTiming // ring buffer of cap chunks
+	next       int
+}
+
+// NewTimelines creates a recorder keeping the last chunks timings of each of
+// at most sessions sessions
+func NewTimelines(chunks, sessions int) *Timelines {
+	return &Timelines{
+		chunks:      chunks,
+		maxSessions: sessions,
+		sessions:    make(map[string]*timeline),
+	}
+}
+
+// record adds the timing of a chunk sent to session. The session's schedule
+// is fixed by the interval of its first chunk.
+func (t *Timelines) record(session string, chunk int, quality string, size int, interval time.Duration, received, writeStart, writeEnd time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tl, ok := t.sessions[session]
+	if !ok {
+		if len(t.sessions) >= t.maxSessions {
+			t.evict()
+		}
+		tl = &timeline{start: received, firstChunk: chunk, interval: interval, entries: make([]ChunkTiming, 0, t.chunks)}
+		t.sessions[session] = tl
+	}
+	tl.last = writeEnd
+
+	entry := ChunkTiming{
+		Chunk:      chunk,
+		Quality:    quality,
+		Size:       size,
+		Scheduled:  (time.Duration(chunk-tl.firstChunk) * tl.interval).Microseconds(),
+		Received:   received.Sub(tl.start).Microseconds(),
+		WriteStart: writeStart.Sub(tl.start).Microseconds(),
+		WriteEnd:   writeEnd.Sub(tl.start).Microseconds(),
+	}
+	if len(tl.entries) < t.chunks {
+		tl.entries = append(tl.entries, entry)
+		return
+	}
+	tl.entries[tl.next] = entry
+	tl.next = (tl.next + 1) % t.chunks
+}
+
+// evict drops the least recently active session
+func (t *Timelines) evict() {
+	var oldest string
+	var oldestAt time.Time
+	for id, tl := range t.sessions {
+		if oldest == "" || tl.last.Before(oldestAt) {
+			oldest, oldestAt = id, tl.last
+		}
+	}
+	delete(t.sessions, oldest)
+}
+
+// Sessions returns the IDs of the sessions with a timeline, sorted
+func (t *Timelines) Sessions() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ids := make([]string, 0, len(t.sessions))
+	for id := range t.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Timeline returns the recorded chunks of session, oldest first
+func (t *Timelines) Timeline(session string) ([]ChunkTiming, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tl, ok := t.sessions[session]
+	if !ok {
+		return nil, false
+	}
+	entries := make([]ChunkTiming, 0, len(tl.entries))
+	entries = append(entries, tl.entries[tl.next:]...)
+	entries = append(entries, tl.entries[:tl.next]...)
+	return entries, true
+}
+
+// Pacing summarizes how far chunk writes started from their schedule. A
+// positive error is a late chunk. Drift is the change in error from the
+// first to the last chunk, which grows when the sender falls behind.
+type Pacing struct {
+	Chunks    int   `json:"chunks"`
+	MeanError int64 `json:"mean_error_us"`
+	MaxError  int64 `json:"max_error_us"`
+	StdDev    int64 `json:"stddev_us"`
+	Drift     int64 `json:"drift_us"`
+}
+
+// AnalyzePacing computes the pacing error of a timeline. The schedule is
+// anchored on the first chunk, so its error is always zero.
+func AnalyzePacing(entries []ChunkTiming) Pacing {
+	p := Pacing{Chunks: len(entries)}
+	if len(entries) == 0 {
+		return p
+	}
+
+	// Re-anchor on the first retained chunk, the session start may have
+	// been dropped from the ring
+	offset := entries[0].WriteStart - entries[0].Scheduled
+	errs := make([]float64, len(entries))
+	var sum float64
+	for i, e := range entries {
+		err := e.WriteStart - e.Scheduled - offset
+		errs[i] = float64(err)
+		sum += float64(err)
+		if err > p.MaxError {
+			p.MaxError = err
+		}
+	}
+	mean := sum / float64(len(errs))
+
+	var sq float64
+	for _, e := range errs {
+		sq += (e - mean) * (e - mean)
+	}
+	p.MeanError = int64(mean)
+	p.StdDev = int64(math.Sqrt(sq / float64(len(errs))))
+	p.Drift = int64(errs[len(errs)-1] - errs[0])
+	return p
+}
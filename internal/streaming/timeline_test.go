@@ -0,0 +1,86 @@
+package streaming
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+func TestTimelineKeepsLastChunks(t *testing.T) {
+	tl := NewTimelines(3, 2)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Chunks due every 100ms from chunk 10, each written 5ms after it arrives
+	// and falling 1ms further behind
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i) * 101 * time.Millisecond)
+		tl.record("s1", 10+i, "low", 1000, 100*time.Millisecond, at, at.Add(5*time.Millisecond), at.Add(8*time.Millisecond))
+	}
+
+	entries, ok := tl.Timeline("s1")
+	if !ok || len(entries) != 3 {
+		t.Fatalf("%d entries, want the last 3", len(entries))
+	}
+	for i, e := range entries {
+		n := i + 2
+		want := ChunkTiming{Chunk: 10 + n, Quality: "low", Size: 1000, Scheduled: int64(n) * 100_000,
+			Received: int64(n) * 101_000, WriteStart: int64(n)*101_000 + 5000, WriteEnd: int64(n)*101_000 + 8000}
+		if e != want {
+			t.Errorf("entry %d: %+v, want %+v", i, e, want)
+		}
+	}
+
+	p := AnalyzePacing(entries)
+	if p.Chunks != 3 || p.MeanError != 1000 || p.MaxError != 2000 || p.Drift != 2000 {
+		t.Errorf("pacing %+v, want 1ms mean error and 2ms drift", p)
+	}
+	if p := AnalyzePacing(nil); p != (Pacing{}) {
+		t.Errorf("pacing of no chunks %+v", p)
+	}
+
+	// The session that sent least recently makes room for a new one
+	tl.record("s2", 0, "low", 1000, time.Second, start.Add(time.Second), start.Add(time.Second), start.Add(time.Second))
+	tl.record("s3", 0, "low", 1000, time.Second, start.Add(2*time.Second), start.Add(2*time.Second), start.Add(2*time.Second))
+	if got := fmt.Sprint(tl.Sessions()); got != "[s2 s3]" {
+		t.Errorf("sessions %s, want s1 evicted", got)
+	}
+}
+
+func TestChunkRequestsRecordTimeline(t *testing.T) {
+	c := clock.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg := metrics.NewRegistry()
+	timelines := NewTimelines(10, 10)
+	h := NewHandler(logging.Nop(), reg, quota.NewManager(config.Default().Quotas, reg), WithClock(c), WithTimelines(timelines))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/stream/chunk/stream_001?quality=low&chunk=%d", i), nil)
+		req.Header.Set("X-Session-ID", "viewer")
+		req.Header.Set(ChunkIntervalHeader, "250ms")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("chunk %d: status %d", i, rec.Code)
+		}
+		c.Advance(250 * time.Millisecond)
+	}
+
+	entries, ok := timelines.Timeline("viewer")
+	if !ok || len(entries) != 3 {
+		t.Fatalf("%d chunks recorded, want 3", len(entries))
+	}
+	for i, e := range entries {
+		if e.Chunk != i || e.Scheduled != int64(i)*250_000 || e.Size == 0 {
+			t.Errorf("entry %d: %+v, want chunk %d due at %dms", i, e, i, i*250)
+		}
+	}
+	if p := AnalyzePacing(entries); p.MaxError != 0 || p.Drift != 0 {
+		t.Errorf("pacing %+v of chunks sent on schedule", p)
+	}
+}
@@ -0,0 +1,47 @@
+package tcp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// FuzzHandlers sends arbitrary requests through the server's handler chain.
+// Rejections, including 503 when shedding load, are fine; panics and other
+// server errors aren't.
+func FuzzHandlers(f *testing.F) {
+	cfg := config.Default()
+	cfg.IoT.OrderWait = time.Millisecond
+	cfg.IoT.MaxMessageBytes = 4 << 10
+	cfg.Limits.MaxBodyBytes = 64 << 10
+	handler := newTestServer(f, cfg).server.Handler
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+
+	f.Add(uint8(1), "/iot/sensor", []byte(`{"device_id": "dev1", "sensor_type": "temperature", "value": 21.5}`))
+	f.Add(uint8(1), "/iot/command", []byte(`{"device_id": "dev1", "action": "reboot"}`))
+	f.Add(uint8(0), "/iot/devices", []byte(nil))
+	f.Add(uint8(0), "/stream/list", []byte(nil))
+	f.Add(uint8(0), "/stream/chunk/stream_001/0?quality=low", []byte(nil))
+	f.Add(uint8(1), "/benchmark/", bytes.Repeat([]byte("x"), 1024))
+	f.Add(uint8(0), "/healthz", []byte(nil))
+	f.Add(uint8(3), "/iot/commands/dev1/../../stream", []byte("{"))
+	f.Fuzz(func(t *testing.T, method uint8, target string, body []byte) {
+		u, err := url.ParseRequestURI(target)
+		if err != nil {
+			return
+		}
+		req := httptest.NewRequest(methods[int(method)%len(methods)], "/", bytes.NewReader(body))
+		req.URL = u
+		req.RequestURI = u.RequestURI()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusInternalServerError && rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s %s: status %d: %s", req.Method, target, rec.Code, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package tcp
+
+import (
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/admin"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/limits"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// newTestServer creates a server with only the required components
+func newTestServer(t testing.TB, cfg *config.Config) *Server {
+	t.Helper()
+	reg := metrics.NewRegistry()
+	healthReg := health.NewRegistry()
+	quotas := quota.NewManager(cfg.Quotas, reg)
+	return NewServer(cfg, nil, logging.Nop(), Deps{
+		Conns:   admin.NewConnTracker(reg),
+		Health:  healthReg,
+		Guard:   limits.NewGuard(cfg.Limits, reg),
+		IoT:     iot.NewHandler(cfg.IoT, logging.Nop(), reg, healthReg, quotas),
+		Streams: streaming.NewHandler(logging.Nop(), reg, quotas),
+	})
+}
@@ -3,123 +3,251 @@ package tcp
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/nik1740/quic-communication-system/internal/admin"
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/internal/congestion"
 	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/limits"
 	"github.com/nik1740/quic-communication-system/internal/streaming"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
 )
 
 // Server represents a TCP/TLS server for comparison
 type Server struct {
-	server   *http.Server
-	tlsConfig *tls.Config
+	server     *http.Server
+	tlsConfig  *tls.Config
+	logger     logging.Logger
+	conns      *admin.ConnTracker
+	congestion string // requested congestion controller, empty for the system default
+	iot        *iot.Handler
+	streams    *streaming.Handler
+	drainGrace time.Duration // bound on ending live sessions in Stop
+
+	mu        sync.Mutex
+	newServer func() *http.Server // builds a fresh listener after a restart
+	listener  *admin.Listener
+}
+
+// Deps are the components the server shares with the QUIC server and the
+// admin API. IoT and Streams are the handlers of the QUIC server, so that
+// both serve the same devices and streams. All of them are required.
+type Deps struct {
+	Conns   *admin.ConnTracker
+	Health  *health.Registry
+	Guard   *limits.Guard
+	IoT     *iot.Handler
+	Streams *streaming.Handler
 }
 
-// NewServer creates a new TCP/TLS server
-func NewServer(addr string, tlsConfig *tls.Config) *Server {
+// NewServer creates a new TCP/TLS server listening on cfg.Server.TCP.Addr
+func NewServer(cfg *config.Config, tlsConfig *tls.Config, logger logging.Logger, deps Deps) *Server {
 	mux := http.NewServeMux()
-	
-	// IoT endpoints (same as QUIC)
-	mux.HandleFunc("/iot/", iot.Handler)
-	
-	// Video streaming endpoints (same as QUIC)
-	mux.HandleFunc("/stream/", streaming.Handler)
-	
+	mux.Handle("/iot/", deps.IoT)
+	mux.Handle("/stream/", deps.Streams)
+
 	// Health check
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprint(w, "TCP/TLS server is running")
 	})
+	mux.Handle("/healthz", deps.Health.Handler())
+	mux.Handle("/ping", health.PingHandler())
 
 	// Benchmark endpoint
-	mux.HandleFunc("/benchmark/", handleBenchmark)
+	mux.Handle("/benchmark/", benchmark.EchoHandler("TCP", "HTTP/1.1 or HTTP/2"))
 
-	return &Server{
-		server: &http.Server{
-			Addr:         addr,
-			Handler:      mux,
+	handler := deps.Guard.Wrap("tcp", mux)
+	newServer := func() *http.Server {
+		srv := &http.Server{
+			Addr:         cfg.Server.TCP.Addr,
+			Handler:      handler,
 			TLSConfig:    tlsConfig,
 			ReadTimeout:  30 * time.Second,
 			WriteTimeout: 30 * time.Second,
-		},
-		tlsConfig: tlsConfig,
+			ConnState: func(c net.Conn, state http.ConnState) {
+				switch state {
+				case http.StateNew:
+					deps.Conns.Open("tcp")
+				case http.StateClosed, http.StateHijacked:
+					deps.Conns.Close("tcp")
+				}
+			},
+			ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+				// Clients open and close HTTP/1.1 and HTTP/2 connections as
+				// their pool sees fit, so they don't end device sessions
+				return iot.WithPeer(ctx, nil)
+			},
+		}
+		deps.Guard.ConfigureServer(srv)
+		return srv
+	}
+
+	s := &Server{
+		server:     newServer(),
+		tlsConfig:  tlsConfig,
+		logger:     logger,
+		conns:      deps.Conns,
+		congestion: cfg.Server.TCP.Congestion,
+		iot:        deps.IoT,
+		streams:    deps.Streams,
+		drainGrace: cfg.Streaming.DrainGrace,
+		newServer:  newServer,
 	}
+	s.listener = admin.NewListener("tcp", logger, s.addr, s.takeDown, s.bringUp)
+	return s
 }
 
-// Start starts the TCP/TLS server
+// Start starts the TCP/TLS server. It returns http.ErrServerClosed once the server is stopped or restarted.
 func (s *Server) Start() error {
-	log.Printf("Starting TCP/TLS server on %s", s.server.Addr)
+	s.mu.Lock()
+	srv := s.server
+	s.mu.Unlock()
+
+	s.logger.Info("Starting TCP/TLS server", logging.F("addr", srv.Addr))
+	return s.serve(srv)
+}
+
+// Serve serves on l, e.g. a listener on an ephemeral port. The congestion
+// controller is whatever l was opened with.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	srv := s.server
+	s.mu.Unlock()
+
+	s.logger.Info("Starting TCP/TLS server", logging.F("addr", l.Addr().String()))
 	if s.tlsConfig != nil {
-		return s.server.ListenAndServeTLS("", "")
+		return srv.ServeTLS(l, "", "")
 	}
-	return s.server.ListenAndServe()
+	return srv.Serve(l)
 }
 
-// Stop stops the TCP/TLS server
-func (s *Server) Stop() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	return s.server.Shutdown(ctx)
+func (s *Server) serve(srv *http.Server) error {
+	ln, err := s.listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	if s.tlsConfig != nil {
+		return srv.ServeTLS(ln, "", "")
+	}
+	return srv.Serve(ln)
 }
 
-func handleBenchmark(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		// Return connection info for benchmarking
-		info := map[string]interface{}{
-			"protocol":   "TCP/TLS",
-			"connection": "HTTP/1.1 or HTTP/2",
-			"timestamp":  time.Now().Unix(),
-			"server":     "tcp-comparison",
-		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Protocol", "TCP")
-		
-		if err := writeJSON(w, info); err != nil {
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		}
-		
-	case http.MethodPost:
-		// Echo test for latency measurement
-		start := time.Now()
-		
-		// Read and echo the request body
-		body := make([]byte, r.ContentLength)
-		n, err := r.Body.Read(body)
-		if err != nil && err.Error() != "EOF" {
-			http.Error(w, "Failed to read body", http.StatusBadRequest)
-			return
+// listen opens the listener with the requested congestion controller, which
+// accepted connections inherit. If the kernel refuses the controller it falls
+// back to the system default. The controller in effect is recorded in the
+// connection stats.
+func (s *Server) listen(addr string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if s.congestion != "" {
+		lc.Control = congestion.Control(s.congestion)
+	}
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err != nil && s.congestion != "" {
+		s.logger.Warn("Congestion controller unavailable, using the system default",
+			logging.F("congestion", s.congestion), logging.Err(err))
+		ln, err = net.Listen("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpLn, ok := ln.(*net.TCPListener); ok {
+		if name, err := congestion.Of(tcpLn); err == nil {
+			s.conns.SetCongestion("tcp", name)
+			s.logger.Info("TCP congestion controller", logging.F("congestion", name))
 		}
-		
-		latency := time.Since(start)
-		
-		response := map[string]interface{}{
-			"protocol":     "TCP/TLS",
-			"bytes_read":   n,
-			"latency_ns":   latency.Nanoseconds(),
-			"latency_ms":   float64(latency.Nanoseconds()) / 1e6,
-			"timestamp":    time.Now().Unix(),
+	}
+	return ln, nil
+}
+
+// Restart closes the listener and every open connection, and listens again
+// once down has elapsed
+func (s *Server) Restart(down time.Duration) error {
+	return s.listener.Restart(down)
+}
+
+// takeDown closes the listener and every open connection, keeping a fresh
+// server to listen with later
+func (s *Server) takeDown() error {
+	s.mu.Lock()
+	old := s.server
+	s.server = s.newServer()
+	s.mu.Unlock()
+
+	return old.Close()
+}
+
+// bringUp listens again after takeDown and serves in the background
+func (s *Server) bringUp() error {
+	s.mu.Lock()
+	srv := s.server
+	s.mu.Unlock()
+
+	ln, err := s.listen(srv.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var err error
+		if s.tlsConfig != nil {
+			err = srv.ServeTLS(ln, "", "")
+		} else {
+			err = srv.Serve(ln)
 		}
-		
-		w.Header().Set("Content-Type", "application/json")
-		w.Header().Set("X-Protocol", "TCP")
-		w.Header().Set("X-Latency-Ms", fmt.Sprintf("%.2f", response["latency_ms"]))
-		
-		if err := writeJSON(w, response); err != nil {
-			http.Error(w, "Failed to write response", http.StatusInternalServerError)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("Server failed", logging.Err(err))
 		}
-		
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
+	}()
+	return nil
+}
+
+// addr returns the address the listener is opened on
+func (s *Server) addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.server.Addr
+}
+
+// IoTStats returns the statistics of the IoT endpoints served over TCP/TLS
+func (s *Server) IoTStats() iot.Stats {
+	return s.iot.Stats()
+}
+
+// LogIoTStats logs the statistics of the IoT endpoints every interval until
+// ctx is done
+func (s *Server) LogIoTStats(ctx context.Context, interval time.Duration) {
+	s.iot.LogStats(ctx, interval)
 }
 
-func writeJSON(w http.ResponseWriter, data interface{}) error {
-	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(data)
-}
\ No newline at end of file
+// Subsystem controls the listener from the admin API
+func (s *Server) Subsystem() admin.Subsystem {
+	return s.listener.Subsystem()
+}
+
+// Stop stops the TCP/TLS server. Live streaming sessions are ended with an
+// end-of-stream event first, waiting at most streaming.drain_grace for them.
+func (s *Server) Stop() error {
+	s.listener.Close()
+	s.mu.Lock()
+	srv := s.server
+	s.mu.Unlock()
+
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), s.drainGrace)
+	defer cancelDrain()
+	if err := s.streams.Drain(drainCtx); err != nil {
+		s.logger.Warn("Live sessions still open after drain grace", logging.F("grace", s.drainGrace))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,32 @@
+package tcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+func TestIoTStatsOverTCP(t *testing.T) {
+	s := newTestServer(t, config.Default())
+	for _, body := range []string{
+		`{"device_id": "dev1", "sensor_type": "temperature", "value": 21.5, "unit": "celsius"}`,
+		`{"device_id": "dev1", "sensor_type": "temperature", "value": 21.7, "unit": "celsius"}`,
+		`{"device_id": "dev1"`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/iot/sensor", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		s.server.Handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	s.server.Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/iot/devices", nil))
+
+	stats := s.IoTStats()
+	if stats.Messages["sensor"] != 3 || stats.Messages["devices"] != 1 {
+		t.Errorf("messages %v, want 3 sensor and 1 devices", stats.Messages)
+	}
+	if stats.Readings.Accepted != 2 {
+		t.Errorf("%d readings accepted, want 2", stats.Readings.Accepted)
+	}
+}
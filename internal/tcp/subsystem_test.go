@@ -0,0 +1,71 @@
+package tcp
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// freeAddr returns a loopback address with a port nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// up reports whether the server at addr answers within a second
+func up(addr string) bool {
+	client := &http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		resp, err := client.Get("http://" + addr + "/health")
+		if err == nil {
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusOK
+		}
+	}
+	return false
+}
+
+func TestSubsystemRestartsStoppedListener(t *testing.T) {
+	cfg := config.Default()
+	cfg.Server.TCP.Addr = freeAddr(t)
+	s := newTestServer(t, cfg)
+	go s.Start()
+	t.Cleanup(func() { s.Stop() })
+	if !up(cfg.Server.TCP.Addr) {
+		t.Fatal("listener not up")
+	}
+
+	sub := s.Subsystem()
+	ctx := context.Background()
+	if err := sub.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := sub.Status(); status.Running || status.Name != "tcp" {
+		t.Fatalf("status %+v after stopping, want tcp stopped", status)
+	}
+	if _, err := net.DialTimeout("tcp", cfg.Server.TCP.Addr, time.Second); err == nil {
+		t.Fatal("stopped listener accepts connections")
+	}
+	if err := sub.Stop(ctx); err == nil {
+		t.Error("stopped a stopped listener")
+	}
+
+	if err := sub.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !sub.Status().Running || !up(cfg.Server.TCP.Addr) {
+		t.Fatal("listener not back up after starting")
+	}
+	if err := sub.Start(ctx); err == nil {
+		t.Error("started a running listener")
+	}
+}
@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fired reports whether c has a value ready
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	f := NewFake(epoch)
+	c := f.After(time.Second)
+	f.Advance(999 * time.Millisecond)
+	if fired(c) {
+		t.Fatal("After fired before its duration")
+	}
+	f.Advance(time.Millisecond)
+	select {
+	case at := <-c:
+		if !at.Equal(epoch.Add(time.Second)) {
+			t.Errorf("After fired at %v, want %v", at, epoch.Add(time.Second))
+		}
+	default:
+		t.Fatal("After did not fire after its duration")
+	}
+	if !fired(f.After(0)) {
+		t.Error("After(0) did not fire at once")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(epoch)
+	ticker := f.NewTicker(time.Second)
+
+	f.Advance(time.Second)
+	if at := <-ticker.C(); !at.Equal(epoch.Add(time.Second)) {
+		t.Errorf("first tick at %v", at)
+	}
+	// Like time.Ticker, ticks not drained are dropped
+	f.Advance(3 * time.Second)
+	if at := <-ticker.C(); !at.Equal(epoch.Add(2 * time.Second)) {
+		t.Errorf("tick kept at %v, want the first undrained one", at)
+	}
+	if fired(ticker.C()) {
+		t.Error("dropped ticks delivered")
+	}
+	if !f.Now().Equal(epoch.Add(4 * time.Second)) {
+		t.Errorf("now %v after advancing 4s", f.Now())
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	if fired(ticker.C()) {
+		t.Error("stopped ticker ticked")
+	}
+}
+
+func TestFakeSetIgnoresThePast(t *testing.T) {
+	f := NewFake(epoch)
+	c := f.After(time.Minute)
+	f.Set(epoch.Add(-time.Hour))
+	if !f.Now().Equal(epoch) {
+		t.Errorf("moved back to %v", f.Now())
+	}
+	f.Set(epoch.Add(time.Hour))
+	if !fired(c) || !f.Now().Equal(epoch.Add(time.Hour)) {
+		t.Errorf("Set to %v did not fire the timer", f.Now())
+	}
+}
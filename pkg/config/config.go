@@ -0,0 +1,656 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the configuration shared by all components
+type Config struct {
+	Server    ServerConfig    `json:"server" yaml:"server"`
+	QUIC      QUICConfig      `json:"quic" yaml:"quic"`
+	Admin     AdminConfig     `json:"admin" yaml:"admin"`
+	TLS       TLSConfig       `json:"tls" yaml:"tls"`
+	Logging   LoggingConfig   `json:"logging" yaml:"logging"`
+	Tracing   TracingConfig   `json:"tracing" yaml:"tracing"`
+	IoT       IoTConfig       `json:"iot" yaml:"iot"`
+	Streaming StreamingConfig `json:"streaming" yaml:"streaming"`
+	Quotas    QuotaConfig     `json:"quotas" yaml:"quotas"`
+	Limits    LimitsConfig    `json:"limits" yaml:"limits"`
+	Bridge    BridgeConfig    `json:"bridge" yaml:"bridge"`
+
+	// sources records which layer supplied each non-default key
+	sources map[string]Source
+}
+
+// ServerConfig holds listener settings
+type ServerConfig struct {
+	Addr string         `json:"addr" yaml:"addr"` // QUIC listen address
+	QUIC ListenerConfig `json:"quic" yaml:"quic"`
+	TCP  ListenerConfig `json:"tcp" yaml:"tcp"`
+}
+
+// ListenerConfig holds per-listener overrides
+type ListenerConfig struct {
+	Addr       string     `json:"addr,omitempty" yaml:"addr,omitempty"`
+	TLS        *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`               // overrides the top-level tls section
+	Congestion string     `json:"congestion,omitempty" yaml:"congestion,omitempty"` // congestion controller, e.g. "cubic" or "bbr"; empty for the system default
+}
+
+// QUICConfig tunes the QUIC transport of the server and of the benchmark's
+// HTTP/3 client. Windows are receive flow-control windows in bytes; quic-go
+// grows each window from its initial to its max size as the peer keeps it
+// full. Zero keeps the quic-go default.
+type QUICConfig struct {
+	InitialStreamWindow     uint64 `json:"initial_stream_window" yaml:"initial_stream_window"`         // default 512 KB
+	MaxStreamWindow         uint64 `json:"max_stream_window" yaml:"max_stream_window"`                 // default 6 MB
+	InitialConnectionWindow uint64 `json:"initial_connection_window" yaml:"initial_connection_window"` // default 768 KB
+	MaxConnectionWindow     uint64 `json:"max_connection_window" yaml:"max_connection_window"`         // default 15 MB
+}
+
+// TLSConfig describes certificates and protocol settings for a TLS listener.
+// An empty cert/key pair means a self-signed certificate is generated.
+type TLSConfig struct {
+	CertFile     string   `json:"cert_file" yaml:"cert_file"`
+	KeyFile      string   `json:"key_file" yaml:"key_file"`
+	ClientCAFile string   `json:"client_ca_file" yaml:"client_ca_file"` // require client certificates signed by this CA
+	MinVersion   string   `json:"min_version" yaml:"min_version"`       // "1.2" or "1.3"
+	ALPN         []string `json:"alpn" yaml:"alpn"`                     // empty uses the listener default
+}
+
+// AdminConfig holds settings for the admin HTTP listener
+type AdminConfig struct {
+	Addr  string `json:"addr" yaml:"addr"`                 // empty disables the admin API
+	Debug bool   `json:"debug" yaml:"debug"`               // mount pprof and runtime stats endpoints
+	Token string `json:"token" yaml:"token" secret:"true"` // bearer token required by debug endpoints and admin API changes
+
+	// TLS serves the admin API over HTTPS with these overrides of the
+	// top-level tls section. Without it the API is plain HTTP and addr must
+	// be a loopback address.
+	TLS *TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// LoggingConfig controls log level, format and output for every component
+type LoggingConfig struct {
+	Level  string            `json:"level" yaml:"level"`   // "debug", "info", "warn", "error"
+	Format string            `json:"format" yaml:"format"` // "text" or "json"
+	File   string            `json:"file" yaml:"file"`     // empty means stderr
+	Levels map[string]string `json:"levels" yaml:"levels"` // per-component overrides, e.g. {"streaming": "debug"}
+}
+
+// TracingConfig controls OpenTelemetry tracing
+type TracingConfig struct {
+	Enabled     bool    `json:"enabled" yaml:"enabled"`
+	Endpoint    string  `json:"endpoint" yaml:"endpoint"` // OTLP/HTTP collector host:port
+	Insecure    bool    `json:"insecure" yaml:"insecure"` // plain HTTP to the collector
+	ServiceName string  `json:"service_name" yaml:"service_name"`
+	SampleRatio float64 `json:"sample_ratio" yaml:"sample_ratio"`
+}
+
+// IoTConfig holds settings for the IoT endpoints
+type IoTConfig struct {
+	MaxMessageBytes      int64              `json:"max_message_bytes" yaml:"max_message_bytes"` // larger request bodies are rejected with 413
+	MaxViolations        int                `json:"max_violations" yaml:"max_violations"`       // oversized messages before the connection is closed, 0 never closes
+	MaxBatch             int                `json:"max_batch" yaml:"max_batch"`                 // larger sensor batches are rejected with 413
+	UploadPace           int64              `json:"upload_pace" yaml:"upload_pace"`             // bytes per second devices pace batches and uploads at, 0 unpaced
+	Uploads              UploadConfig       `json:"uploads" yaml:"uploads"`
+	Sampling             SamplingConfig     `json:"sampling" yaml:"sampling"`
+	Validation           ValidationConfig   `json:"validation" yaml:"validation"`
+	Aggregation          AggregationConfig  `json:"aggregation" yaml:"aggregation"`
+	Firmware             FirmwareConfig     `json:"firmware" yaml:"firmware"`
+	Heartbeat            HeartbeatConfig    `json:"heartbeat" yaml:"heartbeat"`
+	DeviceHealth         DeviceHealthConfig `json:"device_health" yaml:"device_health"`
+	Sinks                SinksConfig        `json:"sinks" yaml:"sinks"`
+	Dedup                DedupConfig        `json:"dedup" yaml:"dedup"`
+	Subscriptions        SubscriptionConfig `json:"subscriptions" yaml:"subscriptions"`
+	ClockSkew            ClockSkewConfig    `json:"clock_skew" yaml:"clock_skew"`
+	Commands             CommandConfig      `json:"commands" yaml:"commands"`
+	Reconcile            ReconcileConfig    `json:"reconcile" yaml:"reconcile"`
+	Compression          CompressionConfig  `json:"compression" yaml:"compression"`
+	Anomaly              AnomalyConfig      `json:"anomaly" yaml:"anomaly"`
+	OrderWait            time.Duration      `json:"order_wait" yaml:"order_wait"`                           // how long a sequenced message waits for the ones before it
+	StatsInterval        time.Duration      `json:"stats_interval" yaml:"stats_interval"`                   // how often handler statistics are logged, 0 never
+	ForgetAfter          time.Duration      `json:"forget_after" yaml:"forget_after"`                       // how long the state of a device that sends nothing is kept, 0 forever
+	DeviceTokens         map[string]string  `json:"device_tokens" yaml:"device_tokens" secret:"true"`       // token of each device ID; empty trusts any device
+	MaxMessagesPerSecond float64            `json:"max_messages_per_second" yaml:"max_messages_per_second"` // sensor messages per device, 0 for unlimited
+	DeviceRates          map[string]float64 `json:"device_rates" yaml:"device_rates"`                       // per-device overrides of max_messages_per_second
+}
+
+// SamplingConfig controls adaptive reporting intervals. The server widens a
+// device's interval while its readings are stable and tightens it when they
+// start to vary, in the sensor's own unit.
+type SamplingConfig struct {
+	Enabled        bool          `json:"enabled" yaml:"enabled"`
+	Window         int           `json:"window" yaml:"window"` // readings analysed per device
+	MinInterval    time.Duration `json:"min_interval" yaml:"min_interval"`
+	MaxInterval    time.Duration `json:"max_interval" yaml:"max_interval"`
+	StableStdDev   float64       `json:"stable_stddev" yaml:"stable_stddev"`     // below this the interval is doubled
+	VolatileStdDev float64       `json:"volatile_stddev" yaml:"volatile_stddev"` // above this the interval is halved
+}
+
+// ValidationConfig controls checks of incoming sensor readings against the
+// rule of their sensor type. Readings of types without a rule only have
+// their timestamp checked.
+type ValidationConfig struct {
+	Enabled bool                  `json:"enabled" yaml:"enabled"`
+	MaxSkew time.Duration         `json:"max_skew" yaml:"max_skew"` // how far ahead of the server's clock a reading may be stamped
+	Sensors map[string]SensorRule `json:"sensors" yaml:"sensors"`   // replaces the built-in rule of each listed sensor type
+}
+
+// AggregationConfig controls the per-device summaries of sensor readings
+// kept for dashboards
+type AggregationConfig struct {
+	Windows []time.Duration `json:"windows" yaml:"windows"` // e.g. [1m, 5m], empty disables aggregation
+}
+
+// SensorRule bounds the readings of a sensor type
+type SensorRule struct {
+	Min     float64  `json:"min" yaml:"min"`
+	Max     float64  `json:"max" yaml:"max"`
+	Units   []string `json:"units" yaml:"units"`     // accepted units, empty for any
+	Integer bool     `json:"integer" yaml:"integer"` // only whole values, e.g. 0 and 1 for motion
+}
+
+// UploadConfig controls files uploaded by devices, e.g. camera snapshots
+type UploadConfig struct {
+	Dir         string `json:"dir" yaml:"dir"`                   // empty disables uploads
+	MaxBytes    int64  `json:"max_bytes" yaml:"max_bytes"`       // largest accepted file
+	DeviceBytes int64  `json:"device_bytes" yaml:"device_bytes"` // total stored per device, 0 for unlimited
+}
+
+// FirmwareConfig controls over-the-air firmware updates of devices
+type FirmwareConfig struct {
+	ChunkSize     int64 `json:"chunk_size" yaml:"chunk_size"`           // bytes per firmware chunk
+	MaxImageBytes int64 `json:"max_image_bytes" yaml:"max_image_bytes"` // largest accepted firmware image
+}
+
+// HeartbeatConfig controls when devices are considered offline
+type HeartbeatConfig struct {
+	Interval time.Duration `json:"interval" yaml:"interval"` // how often devices are asked to send heartbeats
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`   // silence after which a device is offline
+}
+
+// DeviceHealthConfig controls the battery and health reports of devices
+type DeviceHealthConfig struct {
+	LowBattery float64       `json:"low_battery" yaml:"low_battery"` // battery percentage below which an alert is raised
+	StaleAfter time.Duration `json:"stale_after" yaml:"stale_after"` // a battery device without a health report for this long is offline; 0 never
+}
+
+// SubscriptionConfig controls live subscriptions to sensor readings
+type SubscriptionConfig struct {
+	Buffer         int `json:"buffer" yaml:"buffer"`                   // readings queued per subscriber before they are dropped
+	MaxSubscribers int `json:"max_subscribers" yaml:"max_subscribers"` // remote subscribers at once; 0 disables GET /iot/subscribe
+}
+
+// ClockSkewConfig controls the correction of reading timestamps for the
+// offset of each device's clock from the server's
+type ClockSkewConfig struct {
+	Enabled   bool          `json:"enabled" yaml:"enabled"`
+	Smoothing float64       `json:"smoothing" yaml:"smoothing"` // weight of each new sample in the moving average of the offset, in (0, 1]
+	Tolerance time.Duration `json:"tolerance" yaml:"tolerance"` // offsets up to this, e.g. network delay, are left uncorrected
+	Threshold time.Duration `json:"threshold" yaml:"threshold"` // offset or jitter beyond which a device's clock is flagged
+}
+
+// AnomalyConfig controls the detection of readings that stray from the
+// recent values of their device and sensor type
+type AnomalyConfig struct {
+	Enabled bool    `json:"enabled" yaml:"enabled"`
+	Window  int     `json:"window" yaml:"window"`   // recent readings the mean and deviation are taken over
+	Sigma   float64 `json:"sigma" yaml:"sigma"`     // deviations from the mean beyond which a reading is anomalous
+	WarmUp  int     `json:"warm_up" yaml:"warm_up"` // readings of a series before any is judged
+	Buffer  int     `json:"buffer" yaml:"buffer"`   // readings waiting for the detector; more are skipped
+}
+
+// CommandConfig controls the commands queued for devices to fetch
+type CommandConfig struct {
+	History int `json:"history" yaml:"history"` // finished commands whose status is kept, oldest forgotten first
+}
+
+// ReconcileConfig controls the job that brings devices whose reported state
+// hasn't converged to their desired state back in line
+type ReconcileConfig struct {
+	Interval   time.Duration `json:"interval" yaml:"interval"`       // how often twins are scanned, 0 disables the job
+	CommandTTL time.Duration `json:"command_ttl" yaml:"command_ttl"` // how long a resent delta waits for its device before it is replaced
+	Deadline   time.Duration `json:"deadline" yaml:"deadline"`       // divergence after which the device is escalated with an alert
+}
+
+// CompressionConfig controls the compression of IoT request and response
+// bodies in a codec agreed with each device
+type CompressionConfig struct {
+	Enabled bool     `json:"enabled" yaml:"enabled"`
+	Codecs  []string `json:"codecs" yaml:"codecs"`     // offered to devices, in order of preference: zstd, gzip
+	MinSize int      `json:"min_size" yaml:"min_size"` // shorter responses go plain; streams are always compressed
+}
+
+// DedupConfig bounds the message IDs remembered to acknowledge retried
+// messages without processing them again
+type DedupConfig struct {
+	Size int           `json:"size" yaml:"size"` // IDs kept, least recently seen evicted first; 0 disables deduplication
+	TTL  time.Duration `json:"ttl" yaml:"ttl"`   // how long an ID is kept after it was last seen
+}
+
+// SinksConfig selects where accepted sensor readings are written. Each sink
+// has its own queue of up to QueueSize readings.
+type SinksConfig struct {
+	QueueSize      int            `json:"queue_size" yaml:"queue_size"`
+	PublishTimeout time.Duration  `json:"publish_timeout" yaml:"publish_timeout"` // per reading and sink
+	File           FileSinkConfig `json:"file" yaml:"file"`
+	NATS           NATSSinkConfig `json:"nats" yaml:"nats"`
+}
+
+// FileSinkConfig appends readings to a file as JSON lines
+type FileSinkConfig struct {
+	Path string `json:"path" yaml:"path"` // empty disables the sink
+}
+
+// NATSSinkConfig publishes readings to NATS as JSON. In Subject,
+// {device_id} stands for the device ID and {type} for the sensor type.
+type NATSSinkConfig struct {
+	URL      string `json:"url" yaml:"url"` // e.g. nats://localhost:4222; empty disables the sink
+	Subject  string `json:"subject" yaml:"subject"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password" secret:"true"`
+}
+
+// StreamingConfig holds settings for the streaming endpoints
+type StreamingConfig struct {
+	ContentDir       string          `json:"content_dir" yaml:"content_dir"`             // segments on disk; empty serves generated chunks
+	CatalogFile      string          `json:"catalog_file" yaml:"catalog_file"`           // streams registered over the admin API, as JSON; empty keeps them in memory
+	ChunkDuration    time.Duration   `json:"chunk_duration" yaml:"chunk_duration"`       // playback time of one chunk
+	Ladder           []QualityConfig `json:"ladder" yaml:"ladder"`                       // qualities streams are offered at, lowest bitrate first
+	TimelineChunks   int             `json:"timeline_chunks" yaml:"timeline_chunks"`     // chunk timings kept per session, 0 disables
+	TimelineSessions int             `json:"timeline_sessions" yaml:"timeline_sessions"` // sessions with a timeline; the least recent is dropped
+	SeedStreams      int             `json:"seed_streams" yaml:"seed_streams"`           // synthetic streams added to the catalog, 0 disables
+	SeedDurations    []time.Duration `json:"seed_durations" yaml:"seed_durations"`       // playback time of the seeded streams, cycled over them
+	SeedLadder       []string        `json:"seed_ladder" yaml:"seed_ladder"`             // qualities of every seeded stream; empty for the whole ladder
+	DrainGrace       time.Duration   `json:"drain_grace" yaml:"drain_grace"`             // wait for live sessions to end on shutdown
+	DrainPeer        string          `json:"drain_peer" yaml:"drain_peer"`               // address live viewers are told to reconnect to on shutdown
+	ResumeGrace      time.Duration   `json:"resume_grace" yaml:"resume_grace"`           // how long a live session can be resumed after its connection ended, 0 disables
+	ResumeSessions   int             `json:"resume_sessions" yaml:"resume_sessions"`     // ended live sessions kept; those that ended first are dropped
+	ABR              ABRConfig       `json:"abr" yaml:"abr"`
+	Pacing           PacingConfig    `json:"pacing" yaml:"pacing"`
+	Broadcast        BroadcastConfig `json:"broadcast" yaml:"broadcast"`
+	Ingest           IngestConfig    `json:"ingest" yaml:"ingest"`
+	HLS              HLSConfig       `json:"hls" yaml:"hls"`
+	Admission        AdmissionConfig `json:"admission" yaml:"admission"`
+}
+
+// QualityConfig is a rung of the quality ladder
+type QualityConfig struct {
+	Name        string `json:"name" yaml:"name"` // e.g. "high", requested as quality=high
+	Width       int    `json:"width" yaml:"width"`
+	Height      int    `json:"height" yaml:"height"`
+	BitrateKbps int    `json:"bitrate_kbps" yaml:"bitrate_kbps"`
+	FrameRate   int    `json:"frame_rate" yaml:"frame_rate"`
+}
+
+// ABRConfig controls the quality the server recommends to players from
+// the reports they send on their playback
+type ABRConfig struct {
+	LowBuffer   time.Duration `json:"low_buffer" yaml:"low_buffer"`     // buffer below which a report counts towards a downgrade
+	HighBuffer  time.Duration `json:"high_buffer" yaml:"high_buffer"`   // buffer a report needs to count towards an upgrade
+	DownReports int           `json:"down_reports" yaml:"down_reports"` // consecutive poor reports before a downgrade
+	UpReports   int           `json:"up_reports" yaml:"up_reports"`     // consecutive good reports before an upgrade
+	UpMargin    float64       `json:"up_margin" yaml:"up_margin"`       // measured bitrate needed over the next quality's, e.g. 1.3
+	MinDwell    time.Duration `json:"min_dwell" yaml:"min_dwell"`       // least time between two quality changes of a session
+	Sessions    int           `json:"sessions" yaml:"sessions"`         // sessions followed; the least recently reported is dropped
+}
+
+// PacingConfig controls how chunk responses are written. Each session's
+// chunks can be spread over part of the interval the player requests them
+// at, rather than written at once, and capped to a bandwidth.
+type PacingConfig struct {
+	Spread   float64 `json:"spread" yaml:"spread"`     // fraction of the chunk interval a chunk is written over, 0 writes it at once
+	MaxKbps  int     `json:"max_kbps" yaml:"max_kbps"` // bandwidth cap of every session, 0 for none; players can only lower it
+	Sessions int     `json:"sessions" yaml:"sessions"` // capped sessions followed; the least recently active is dropped
+}
+
+// BroadcastConfig controls how the viewers of a stream share its source.
+// Each chunk is generated or read once and sent to every viewer requesting
+// it.
+type BroadcastConfig struct {
+	Chunks     int           `json:"chunks" yaml:"chunks"`           // chunks kept per stream for its viewers, 0 produces every chunk for each request
+	ViewerIdle time.Duration `json:"viewer_idle" yaml:"viewer_idle"` // time without a chunk request before a viewer leaves
+	Linger     time.Duration `json:"linger" yaml:"linger"`           // time a stream's source is kept after its last viewer left
+}
+
+// IngestConfig controls the live streams pushed into the server
+type IngestConfig struct {
+	Token   string        `json:"token" yaml:"token" secret:"true"` // bearer token of ingest requests; empty disables ingest
+	Buffer  time.Duration `json:"buffer" yaml:"buffer"`             // media kept per live stream, whether or not it holds a keyframe
+	Streams int           `json:"streams" yaml:"streams"`           // live streams ingested at once
+}
+
+// HLSConfig controls the HLS playlists and segments served under
+// /stream/hls. Each segment is one or more chunks of its stream.
+type HLSConfig struct {
+	TargetDuration time.Duration `json:"target_duration" yaml:"target_duration"` // playback time of a segment, rounded to whole chunks
+	Window         int           `json:"window" yaml:"window"`                   // segments listed by the playlist of a live stream
+	PlaylistType   string        `json:"playlist_type" yaml:"playlist_type"`     // "vod" lists streams that have a duration in full, "live" slides a window over them
+}
+
+// AdmissionConfig caps the viewers streamed to at once. Each viewer counts
+// the weight of the quality it plays, so the cap follows bandwidth rather
+// than the number of viewers.
+type AdmissionConfig struct {
+	MaxConcurrentStreams int            `json:"max_concurrent_streams" yaml:"max_concurrent_streams"` // total weight of the viewers, 0 for no cap
+	Weights              map[string]int `json:"weights" yaml:"weights"`                               // of each quality, 1 for those not listed
+	Idle                 time.Duration  `json:"idle" yaml:"idle"`                                     // time without a chunk request before a viewer frees its slot
+}
+
+// QuotaConfig caps the bytes a device or streaming session may transfer
+// within a rolling window
+type QuotaConfig struct {
+	Device        QuotaLimit    `json:"device" yaml:"device"`
+	Session       QuotaLimit    `json:"session" yaml:"session"`
+	ThrottleRatio float64       `json:"throttle_ratio" yaml:"throttle_ratio"` // fraction of the quota after which requests are delayed
+	ThrottleDelay time.Duration `json:"throttle_delay" yaml:"throttle_delay"`
+}
+
+// QuotaLimit is a byte budget per rolling window. Zero bytes disables it.
+type QuotaLimit struct {
+	Bytes  int64         `json:"bytes" yaml:"bytes"`
+	Window time.Duration `json:"window" yaml:"window"`
+}
+
+// LimitsConfig hardens the QUIC, TCP and admin listeners against oversized
+// and slow requests and request floods
+type LimitsConfig struct {
+	MaxHeaderBytes    int           `json:"max_header_bytes" yaml:"max_header_bytes"`
+	MaxBodyBytes      int64         `json:"max_body_bytes" yaml:"max_body_bytes"`           // any request body, 0 for unlimited; endpoints apply their own smaller limits
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout" yaml:"read_header_timeout"` // TCP and admin; QUIC headers arrive on an already open stream
+	BodyTimeout       time.Duration `json:"body_timeout" yaml:"body_timeout"`               // to read a whole request body, 0 disables
+	IdleTimeout       time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+	MaxConcurrent     int           `json:"max_concurrent" yaml:"max_concurrent"` // requests in flight per listener, 0 for unlimited
+	RatePerIP         float64       `json:"rate_per_ip" yaml:"rate_per_ip"`       // requests per second per client IP, 0 disables
+	Burst             int           `json:"burst" yaml:"burst"`                   // requests a client IP may send at once
+}
+
+// BridgeConfig holds bridges to other messaging systems
+type BridgeConfig struct {
+	MQTT MQTTBridgeConfig `json:"mqtt" yaml:"mqtt"`
+}
+
+// MQTTBridgeConfig connects the IoT handler to an MQTT broker. Readings are
+// published to Topic and commands for devices are taken from CommandTopic.
+// In both, {device_id} stands for the device ID and, in Topic, {type} for
+// the sensor type.
+type MQTTBridgeConfig struct {
+	Broker               string        `json:"broker" yaml:"broker"` // e.g. tcp://localhost:1883; empty disables the bridge
+	ClientID             string        `json:"client_id" yaml:"client_id"`
+	Username             string        `json:"username" yaml:"username"`
+	Password             string        `json:"password" yaml:"password" secret:"true"`
+	QoS                  int           `json:"qos" yaml:"qos"`
+	Topic                string        `json:"topic" yaml:"topic"`
+	CommandTopic         string        `json:"command_topic" yaml:"command_topic"`                   // empty takes no commands
+	BufferSize           int           `json:"buffer_size" yaml:"buffer_size"`                       // readings kept while the broker is unreachable
+	MaxReconnectInterval time.Duration `json:"max_reconnect_interval" yaml:"max_reconnect_interval"` // longest wait between connection attempts
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr: ":8443",
+			TCP: ListenerConfig{
+				Addr: ":8080",
+			},
+		},
+		Admin: AdminConfig{
+			Addr: "127.0.0.1:9090",
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		Tracing: TracingConfig{
+			Endpoint:    "localhost:4318",
+			ServiceName: "quic-communication-system",
+			SampleRatio: 1.0,
+		},
+		IoT: IoTConfig{
+			MaxMessageBytes: 1 << 20,
+			MaxViolations:   3,
+			MaxBatch:        1000,
+			OrderWait:       2 * time.Second,
+			StatsInterval:   time.Minute,
+			ForgetAfter:     24 * time.Hour,
+			Uploads: UploadConfig{
+				MaxBytes:    10 << 20,
+				DeviceBytes: 100 << 20,
+			},
+			Validation: ValidationConfig{
+				MaxSkew: time.Minute,
+			},
+			Firmware: FirmwareConfig{
+				ChunkSize:     64 << 10,
+				MaxImageBytes: 32 << 20,
+			},
+			Heartbeat: HeartbeatConfig{
+				Interval: 30 * time.Second,
+				Timeout:  90 * time.Second,
+			},
+			DeviceHealth: DeviceHealthConfig{
+				LowBattery: 20,
+				StaleAfter: 10 * time.Minute,
+			},
+			Subscriptions: SubscriptionConfig{
+				Buffer:         256,
+				MaxSubscribers: 100,
+			},
+			ClockSkew: ClockSkewConfig{
+				Enabled:   true,
+				Smoothing: 0.2,
+				Tolerance: 2 * time.Second,
+				Threshold: time.Minute,
+			},
+			Commands: CommandConfig{
+				History: 1000,
+			},
+			Reconcile: ReconcileConfig{
+				Interval:   30 * time.Second,
+				CommandTTL: 2 * time.Minute,
+				Deadline:   10 * time.Minute,
+			},
+			Compression: CompressionConfig{
+				Codecs:  []string{"zstd", "gzip"},
+				MinSize: 256,
+			},
+			Anomaly: AnomalyConfig{
+				Window: 60,
+				Sigma:  3,
+				WarmUp: 20,
+				Buffer: 1000,
+			},
+			Dedup: DedupConfig{
+				Size: 10000,
+				TTL:  10 * time.Minute,
+			},
+			Sinks: SinksConfig{
+				QueueSize:      1000,
+				PublishTimeout: 5 * time.Second,
+				NATS: NATSSinkConfig{
+					Subject: "iot.readings.{device_id}.{type}",
+				},
+			},
+			Sampling: SamplingConfig{
+				Window:         12,
+				MinInterval:    5 * time.Second,
+				MaxInterval:    5 * time.Minute,
+				StableStdDev:   0.1,
+				VolatileStdDev: 1.0,
+			},
+		},
+		Streaming: StreamingConfig{
+			ChunkDuration: 2 * time.Second,
+			Ladder: []QualityConfig{
+				{Name: "low", Width: 640, Height: 360, BitrateKbps: 500, FrameRate: 30},
+				{Name: "medium", Width: 1280, Height: 720, BitrateKbps: 1500, FrameRate: 30},
+				{Name: "high", Width: 1920, Height: 1080, BitrateKbps: 3000, FrameRate: 30},
+				{Name: "ultra", Width: 3840, Height: 2160, BitrateKbps: 6000, FrameRate: 30},
+			},
+			TimelineChunks:   256,
+			TimelineSessions: 100,
+			SeedDurations:    []time.Duration{2 * time.Minute},
+			DrainGrace:       10 * time.Second,
+			ResumeGrace:      30 * time.Second,
+			ResumeSessions:   1000,
+			ABR: ABRConfig{
+				LowBuffer:   4 * time.Second,
+				HighBuffer:  10 * time.Second,
+				DownReports: 2,
+				UpReports:   3,
+				UpMargin:    1.3,
+				MinDwell:    10 * time.Second,
+				Sessions:    1000,
+			},
+			Pacing: PacingConfig{
+				Sessions: 1000,
+			},
+			Broadcast: BroadcastConfig{
+				Chunks:     16,
+				ViewerIdle: 10 * time.Second,
+				Linger:     30 * time.Second,
+			},
+			Ingest: IngestConfig{
+				Buffer:  10 * time.Second,
+				Streams: 10,
+			},
+			HLS: HLSConfig{
+				TargetDuration: 6 * time.Second,
+				Window:         5,
+				PlaylistType:   "vod",
+			},
+			Admission: AdmissionConfig{
+				Idle: 10 * time.Second,
+			},
+		},
+		Quotas: QuotaConfig{
+			Device:        QuotaLimit{Window: time.Hour},
+			Session:       QuotaLimit{Window: 24 * time.Hour},
+			ThrottleRatio: 0.8,
+			ThrottleDelay: 500 * time.Millisecond,
+		},
+		Bridge: BridgeConfig{
+			MQTT: MQTTBridgeConfig{
+				ClientID:             "commsys-bridge",
+				QoS:                  1,
+				Topic:                "iot/{device_id}/{type}",
+				CommandTopic:         "iot/{device_id}/commands",
+				BufferSize:           1000,
+				MaxReconnectInterval: 30 * time.Second,
+			},
+		},
+		Limits: LimitsConfig{
+			MaxHeaderBytes:    64 << 10,
+			MaxBodyBytes:      32 << 20,
+			ReadHeaderTimeout: 10 * time.Second,
+			BodyTimeout:       30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			MaxConcurrent:     1024,
+			Burst:             50,
+		},
+	}
+}
+
+// Load returns the default configuration overridden by environment variables
+func Load() *Config {
+	cfg := Default()
+	cfg.applyEnv()
+	return cfg
+}
+
+// LoadFile returns the default configuration overridden by the YAML file at
+// path and then by environment variables. An empty path behaves like Load.
+func LoadFile(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		if err := doc.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		cfg.markFile(&doc)
+	}
+	cfg.applyEnv()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// QUICTLS returns the TLS settings of the QUIC listener
+func (c *Config) QUICTLS() TLSConfig {
+	return c.TLS.merge(c.Server.QUIC.TLS)
+}
+
+// TCPTLS returns the TLS settings of the TCP listener
+func (c *Config) TCPTLS() TLSConfig {
+	return c.TLS.merge(c.Server.TCP.TLS)
+}
+
+// AdminTLS returns the TLS settings of the admin listener
+func (c *Config) AdminTLS() TLSConfig {
+	return c.TLS.merge(c.Admin.TLS)
+}
+
+// merge returns t with every field set in override replaced
+func (t TLSConfig) merge(override *TLSConfig) TLSConfig {
+	if override == nil {
+		return t
+	}
+	merged := t
+	if override.CertFile != "" || override.KeyFile != "" {
+		merged.CertFile = override.CertFile
+		merged.KeyFile = override.KeyFile
+	}
+	if override.ClientCAFile != "" {
+		merged.ClientCAFile = override.ClientCAFile
+	}
+	if override.MinVersion != "" {
+		merged.MinVersion = override.MinVersion
+	}
+	if len(override.ALPN) > 0 {
+		merged.ALPN = override.ALPN
+	}
+	return merged
+}
+
+// envVars maps environment variables onto config keys
+var envVars = []struct {
+	name  string
+	key   string
+	field func(c *Config) *string
+}{
+	{"SERVER_ADDR", "server.addr", func(c *Config) *string { return &c.Server.Addr }},
+	{"TCP_ADDR", "server.tcp.addr", func(c *Config) *string { return &c.Server.TCP.Addr }},
+	{"TLS_CERT_FILE", "tls.cert_file", func(c *Config) *string { return &c.TLS.CertFile }},
+	{"TLS_KEY_FILE", "tls.key_file", func(c *Config) *string { return &c.TLS.KeyFile }},
+	{"ADMIN_ADDR", "admin.addr", func(c *Config) *string { return &c.Admin.Addr }},
+	{"ADMIN_TOKEN", "admin.token", func(c *Config) *string { return &c.Admin.Token }},
+	{"LOG_LEVEL", "logging.level", func(c *Config) *string { return &c.Logging.Level }},
+	{"LOG_FORMAT", "logging.format", func(c *Config) *string { return &c.Logging.Format }},
+	{"LOG_FILE", "logging.file", func(c *Config) *string { return &c.Logging.File }},
+	{"NATS_PASSWORD", "iot.sinks.nats.password", func(c *Config) *string { return &c.IoT.Sinks.NATS.Password }},
+	{"MQTT_PASSWORD", "bridge.mqtt.password", func(c *Config) *string { return &c.Bridge.MQTT.Password }},
+	{"STREAM_CONTENT_DIR", "streaming.content_dir", func(c *Config) *string { return &c.Streaming.ContentDir }},
+	{"INGEST_TOKEN", "streaming.ingest.token", func(c *Config) *string { return &c.Streaming.Ingest.Token }},
+}
+
+func (c *Config) applyEnv() {
+	for _, env := range envVars {
+		if v := os.Getenv(env.name); v != "" {
+			*env.field(c) = v
+			c.SetSource(env.key, SourceEnv)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source identifies the layer that supplied a config value
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// redacted replaces the value of fields tagged `secret:"true"` in dumps
+const redacted = "REDACTED"
+
+// SetSource records that key (a dotted YAML path such as "server.addr") was
+// supplied by src. Commands call it with SourceFlag after applying flags.
+func (c *Config) SetSource(key string, src Source) {
+	if c.sources == nil {
+		c.sources = make(map[string]Source)
+	}
+	c.sources[key] = src
+}
+
+// SourceOf returns the layer that supplied key
+func (c *Config) SourceOf(key string) Source {
+	for k := key; k != ""; k = parentKey(k) {
+		if src, ok := c.sources[k]; ok {
+			return src
+		}
+	}
+	return SourceDefault
+}
+
+// markFile records every leaf key present in a parsed config file
+func (c *Config) markFile(doc *yaml.Node) {
+	walkNode(doc, "", nil, func(key string, keyNode, node *yaml.Node) {
+		c.SetSource(key, SourceFile)
+	})
+}
+
+// Dump writes the resolved configuration as YAML, annotating each value
+// with the layer that supplied it and redacting secret fields. Sections
+// aren't annotated, as their fields may come from different layers.
+func (c *Config) Dump(w io.Writer) error {
+	var doc yaml.Node
+	if err := doc.Encode(c); err != nil {
+		return err
+	}
+
+	secrets := secretKeys(reflect.TypeOf(*c), "")
+	walkNode(&doc, "", nil, func(key string, keyNode, node *yaml.Node) {
+		if isSecret(secrets, key) {
+			redact(node)
+		}
+		// The comment of the key of an empty collection would end up on the
+		// line after it
+		if node.Kind == yaml.ScalarNode || keyNode == nil || len(node.Content) == 0 {
+			node.LineComment = string(c.SourceOf(key))
+		} else {
+			keyNode.LineComment = string(c.SourceOf(key))
+		}
+	})
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+func redact(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Value != "" {
+			node.Value = redacted
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			redact(child)
+		}
+	}
+}
+
+// walkNode calls fn for every scalar, sequence or empty mapping value below
+// node with its dotted key path and the mapping key node it belongs to
+func walkNode(node *yaml.Node, prefix string, keyNode *yaml.Node, fn func(key string, keyNode, node *yaml.Node)) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			walkNode(child, prefix, nil, fn)
+		}
+	case yaml.MappingNode:
+		if len(node.Content) == 0 && prefix != "" {
+			fn(prefix, keyNode, node)
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walkNode(node.Content[i+1], key, node.Content[i], fn)
+		}
+	default:
+		fn(prefix, keyNode, node)
+	}
+}
+
+// secretKeys returns the dotted paths of fields tagged `secret:"true"`
+func secretKeys(t reflect.Type, prefix string) []string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		if field.Tag.Get("secret") == "true" {
+			keys = append(keys, key)
+			continue
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr || ft.Kind() == reflect.Slice || ft.Kind() == reflect.Map {
+			if ft.Kind() == reflect.Map {
+				// Map values are addressed by their dynamic key
+				keys = append(keys, prefixSecrets(secretKeys(ft.Elem(), ""), key+".*")...)
+				ft = nil
+				break
+			}
+			ft = ft.Elem()
+		}
+		if ft != nil {
+			keys = append(keys, secretKeys(ft, key)...)
+		}
+	}
+	return keys
+}
+
+func prefixSecrets(keys []string, prefix string) []string {
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = fmt.Sprintf("%s.%s", prefix, k)
+	}
+	return out
+}
+
+// isSecret reports whether key equals or is nested below a secret path.
+// A "*" segment in a secret path matches any single key segment.
+func isSecret(secrets []string, key string) bool {
+	parts := strings.Split(key, ".")
+	for _, secret := range secrets {
+		sparts := strings.Split(secret, ".")
+		if len(parts) < len(sparts) {
+			continue
+		}
+		match := true
+		for i, sp := range sparts {
+			if sp != "*" && sp != parts[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func parentKey(key string) string {
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
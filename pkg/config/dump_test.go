@@ -0,0 +1,220 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// credentials sets every credential field of c to a distinct value and
+// returns the values
+func credentials(c *Config) []string {
+	c.Admin.Token = "admin-token-value"
+	c.IoT.DeviceTokens = map[string]string{
+		"dev1": "device-token-one",
+		"dev2": "device-token-two",
+	}
+	c.IoT.Sinks.NATS.Password = "nats-password-value"
+	c.Streaming.Ingest.Token = "ingest-token-value"
+	c.Bridge.MQTT.Password = "mqtt-password-value"
+	return []string{
+		"admin-token-value",
+		"device-token-one",
+		"device-token-two",
+		"nats-password-value",
+		"ingest-token-value",
+		"mqtt-password-value",
+	}
+}
+
+func TestDumpRedactsCredentials(t *testing.T) {
+	cfg := Default()
+	values := credentials(cfg)
+
+	var out bytes.Buffer
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	for _, v := range values {
+		if strings.Contains(out.String(), v) {
+			t.Errorf("dump contains credential %q:\n%s", v, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "dev1: "+redacted) {
+		t.Errorf("device tokens are not redacted by device ID:\n%s", out.String())
+	}
+}
+
+func TestDumpRedactsCredentialsFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	doc := `admin:
+  token: admin-token-value
+iot:
+  device_tokens:
+    dev1: device-token-one
+  sinks:
+    nats:
+      password: nats-password-value
+streaming:
+  ingest:
+    token: ingest-token-value
+bridge:
+  mqtt:
+    password: mqtt-password-value
+`
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	for _, v := range []string{"admin-token-value", "device-token-one", "nats-password-value", "ingest-token-value", "mqtt-password-value"} {
+		if strings.Contains(out.String(), v) {
+			t.Errorf("dump contains credential %q:\n%s", v, out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "token: "+redacted+" # file") {
+		t.Errorf("redacted keys lost their provenance:\n%s", out.String())
+	}
+}
+
+func TestDumpRedactsDeviceTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	// A token YAML reads as a number is a credential all the same
+	doc := `iot:
+  device_tokens:
+    dev1: device-token-one
+    dev2: 20261016
+  device_rates:
+    dev1: 2
+`
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	for _, v := range []string{"device-token-one", "20261016"} {
+		if strings.Contains(out.String(), v) {
+			t.Errorf("dump contains device token %q:\n%s", v, out.String())
+		}
+	}
+	for _, want := range []string{"dev1: " + redacted, "dev2: " + redacted, "dev1: 2"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dump lacks %q:\n%s", want, out.String())
+		}
+	}
+}
+
+// TestCredentialFieldsAreSecret guards against new credential fields that
+// are not tagged `secret:"true"`, and against credentials() missing one
+func TestCredentialFieldsAreSecret(t *testing.T) {
+	secrets := secretKeys(reflect.TypeOf(Config{}), "")
+	want := []string{
+		"admin.token",
+		"iot.device_tokens",
+		"iot.sinks.nats.password",
+		"streaming.ingest.token",
+		"bridge.mqtt.password",
+	}
+	for _, key := range want {
+		if !slices.Contains(secrets, key) {
+			t.Errorf("%s is not tagged secret", key)
+		}
+	}
+	for _, key := range secrets {
+		if !slices.Contains(want, key) {
+			t.Errorf("secret %s is not covered by credentials()", key)
+		}
+	}
+
+	var untagged func(t reflect.Type, prefix string)
+	untagged = func(typ reflect.Type, prefix string) {
+		for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct {
+			return
+		}
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			key := prefix + name
+			if (strings.Contains(name, "token") || strings.Contains(name, "password")) && field.Tag.Get("secret") != "true" {
+				t.Errorf("%s looks like a credential but is not tagged secret", key)
+			}
+			untagged(field.Type, key+".")
+		}
+	}
+	untagged(reflect.TypeOf(Config{}), "")
+}
+
+func TestIsSecret(t *testing.T) {
+	secrets := []string{"admin.token", "iot.device_tokens", "streams.*.key"}
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"admin.token", true},
+		{"admin.addr", false},
+		{"iot.device_tokens.dev1", true},
+		{"iot.device_rates.dev1", false},
+		{"streams.main.key", true},
+		{"streams.main.name", false},
+		{"admin", false},
+	}
+	for _, tt := range tests {
+		if got := isSecret(secrets, tt.key); got != tt.want {
+			t.Errorf("isSecret(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
+
+// TestDumpAnnotatesValuesNotSections checks that each value is annotated
+// with its source, and that sections, whose fields may come from several
+// layers, aren't
+func TestDumpAnnotatesValuesNotSections(t *testing.T) {
+	cfg := Default()
+	cfg.SetSource("logging.level", SourceEnv)
+	cfg.SetSource("iot.firmware.chunk_size", SourceFile)
+
+	var out bytes.Buffer
+	if err := cfg.Dump(&out); err != nil {
+		t.Fatalf("Dump: %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	for _, want := range []string{"logging:", "  level: info # env", "  format: text # default", "  levels: {} # default",
+		"  firmware:", "    chunk_size: 65536 # file", "  alpn: [] # default"} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("dump has no line %q:\n%s", want, out.String())
+		}
+	}
+
+	indent := func(line string) int { return len(line) - len(strings.TrimLeft(line, " ")) }
+	for i := 0; i+1 < len(lines); i++ {
+		line, next := lines[i], lines[i+1]
+		section := strings.Contains(line, ": #") && strings.HasSuffix(strings.TrimSpace(strings.Split(line, "#")[0]), ":")
+		if section && indent(next) > indent(line) && !strings.HasPrefix(strings.TrimSpace(next), "- ") {
+			t.Errorf("section %q is annotated", line)
+		}
+	}
+}
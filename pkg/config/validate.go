@@ -0,0 +1,637 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Validate checks the configuration for settings that would only fail later
+// at listener startup. Errors name the offending key path.
+func (c *Config) Validate() error {
+	if err := validateTLS("tls", c.TLS); err != nil {
+		return err
+	}
+	if c.Server.QUIC.TLS != nil {
+		if err := validateTLS("server.quic.tls", c.QUICTLS()); err != nil {
+			return err
+		}
+	}
+	if c.Server.TCP.TLS != nil {
+		if err := validateTLS("server.tcp.tls", c.TCPTLS()); err != nil {
+			return err
+		}
+	}
+	// The admin API changes what the servers do, and its token must not
+	// cross the network in the clear
+	if c.Admin.TLS != nil {
+		if err := validateTLS("admin.tls", c.AdminTLS()); err != nil {
+			return err
+		}
+	} else if c.Admin.Addr != "" && !loopback(c.Admin.Addr) {
+		return fmt.Errorf("admin.addr: %s is reachable from other hosts; set admin.tls to serve it over TLS", c.Admin.Addr)
+	}
+	if err := validateCongestion("server.quic.congestion", c.Server.QUIC.Congestion); err != nil {
+		return err
+	}
+	if err := validateCongestion("server.tcp.congestion", c.Server.TCP.Congestion); err != nil {
+		return err
+	}
+	if err := c.QUIC.validate(); err != nil {
+		return err
+	}
+	if c.IoT.MaxMessageBytes <= 0 {
+		return fmt.Errorf("iot.max_message_bytes: must be positive")
+	}
+	if c.IoT.Uploads.Dir != "" && c.IoT.Uploads.MaxBytes <= 0 {
+		return fmt.Errorf("iot.uploads.max_bytes: must be positive when dir is set")
+	}
+	if c.IoT.Uploads.DeviceBytes < 0 {
+		return fmt.Errorf("iot.uploads.device_bytes: must not be negative")
+	}
+	if c.IoT.Firmware.ChunkSize <= 0 {
+		return fmt.Errorf("iot.firmware.chunk_size: must be positive")
+	}
+	if c.IoT.Firmware.MaxImageBytes <= 0 {
+		return fmt.Errorf("iot.firmware.max_image_bytes: must be positive")
+	}
+	// Images are uploaded through the admin listener, which cuts bodies off there
+	if c.Limits.MaxBodyBytes > 0 && c.IoT.Firmware.MaxImageBytes > c.Limits.MaxBodyBytes {
+		return fmt.Errorf("iot.firmware.max_image_bytes: must not exceed limits.max_body_bytes (%d)", c.Limits.MaxBodyBytes)
+	}
+	if c.IoT.Heartbeat.Interval <= 0 {
+		return fmt.Errorf("iot.heartbeat.interval: must be positive")
+	}
+	if c.IoT.Heartbeat.Timeout < c.IoT.Heartbeat.Interval {
+		return fmt.Errorf("iot.heartbeat.timeout: must not be shorter than interval")
+	}
+	if c.IoT.DeviceHealth.LowBattery < 0 || c.IoT.DeviceHealth.LowBattery > 100 {
+		return fmt.Errorf("iot.device_health.low_battery: must be between 0 and 100")
+	}
+	if c.IoT.DeviceHealth.StaleAfter < 0 {
+		return fmt.Errorf("iot.device_health.stale_after: must not be negative")
+	}
+	if c.IoT.MaxBatch <= 0 {
+		return fmt.Errorf("iot.max_batch: must be positive")
+	}
+	if c.IoT.UploadPace < 0 {
+		return fmt.Errorf("iot.upload_pace: must not be negative")
+	}
+	if c.IoT.MaxViolations < 0 {
+		return fmt.Errorf("iot.max_violations: must not be negative")
+	}
+	if c.IoT.OrderWait < 0 {
+		return fmt.Errorf("iot.order_wait: must not be negative")
+	}
+	if c.IoT.StatsInterval < 0 {
+		return fmt.Errorf("iot.stats_interval: must not be negative")
+	}
+	if c.IoT.ForgetAfter < 0 {
+		return fmt.Errorf("iot.forget_after: must not be negative")
+	}
+	if c.IoT.Subscriptions.Buffer <= 0 {
+		return fmt.Errorf("iot.subscriptions.buffer: must be positive")
+	}
+	if c.IoT.Subscriptions.MaxSubscribers < 0 {
+		return fmt.Errorf("iot.subscriptions.max_subscribers: must not be negative")
+	}
+	if c.IoT.ClockSkew.Enabled {
+		if c.IoT.ClockSkew.Smoothing <= 0 || c.IoT.ClockSkew.Smoothing > 1 {
+			return fmt.Errorf("iot.clock_skew.smoothing: must be in (0, 1]")
+		}
+		if c.IoT.ClockSkew.Tolerance < 0 {
+			return fmt.Errorf("iot.clock_skew.tolerance: must not be negative")
+		}
+		if c.IoT.ClockSkew.Threshold <= c.IoT.ClockSkew.Tolerance {
+			return fmt.Errorf("iot.clock_skew.threshold: must be longer than tolerance")
+		}
+	}
+	if c.IoT.Anomaly.Enabled {
+		if c.IoT.Anomaly.Window < 2 {
+			return fmt.Errorf("iot.anomaly.window: must be at least 2")
+		}
+		if c.IoT.Anomaly.Sigma <= 0 {
+			return fmt.Errorf("iot.anomaly.sigma: must be positive")
+		}
+		if c.IoT.Anomaly.WarmUp < 2 || c.IoT.Anomaly.WarmUp > c.IoT.Anomaly.Window {
+			return fmt.Errorf("iot.anomaly.warm_up: must be between 2 and window")
+		}
+		if c.IoT.Anomaly.Buffer <= 0 {
+			return fmt.Errorf("iot.anomaly.buffer: must be positive")
+		}
+	}
+	if c.IoT.Commands.History <= 0 {
+		return fmt.Errorf("iot.commands.history: must be positive")
+	}
+	if r := c.IoT.Reconcile; r.Interval < 0 {
+		return fmt.Errorf("iot.reconcile.interval: must not be negative")
+	} else if r.Interval > 0 && (r.CommandTTL <= 0 || r.Deadline <= 0) {
+		return fmt.Errorf("iot.reconcile: command_ttl and deadline must be positive when interval is set")
+	}
+	if c.IoT.Compression.Enabled {
+		if len(c.IoT.Compression.Codecs) == 0 {
+			return fmt.Errorf("iot.compression.codecs: at least one codec is required")
+		}
+		for i, codec := range c.IoT.Compression.Codecs {
+			if codec != "zstd" && codec != "gzip" {
+				return fmt.Errorf("iot.compression.codecs: unknown codec %q, supported: zstd, gzip", codec)
+			}
+			if slices.Contains(c.IoT.Compression.Codecs[:i], codec) {
+				return fmt.Errorf("iot.compression.codecs: %s listed twice", codec)
+			}
+		}
+		if c.IoT.Compression.MinSize < 0 {
+			return fmt.Errorf("iot.compression.min_size: must not be negative")
+		}
+	}
+	if c.IoT.Dedup.Size < 0 {
+		return fmt.Errorf("iot.dedup.size: must not be negative")
+	}
+	if c.IoT.Dedup.Size > 0 && c.IoT.Dedup.TTL <= 0 {
+		return fmt.Errorf("iot.dedup.ttl: must be positive when size is set")
+	}
+	if c.IoT.MaxMessagesPerSecond < 0 {
+		return fmt.Errorf("iot.max_messages_per_second: must not be negative")
+	}
+	for device, rate := range c.IoT.DeviceRates {
+		if rate < 0 {
+			return fmt.Errorf("iot.device_rates: negative rate for device %q", device)
+		}
+	}
+	for device, token := range c.IoT.DeviceTokens {
+		if token == "" {
+			return fmt.Errorf("iot.device_tokens: empty token for device %q", device)
+		}
+	}
+	if err := c.IoT.Sampling.validate(); err != nil {
+		return err
+	}
+	if err := c.IoT.Validation.validate(); err != nil {
+		return err
+	}
+	if err := c.IoT.Aggregation.validate(); err != nil {
+		return err
+	}
+	if err := c.IoT.Sinks.validate(); err != nil {
+		return err
+	}
+	if dir := c.Streaming.ContentDir; dir != "" {
+		if info, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("streaming.content_dir: %w", err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("streaming.content_dir: %s is not a directory", dir)
+		}
+	}
+	if c.Streaming.TimelineChunks < 0 {
+		return fmt.Errorf("streaming.timeline_chunks: must not be negative")
+	}
+	if c.Streaming.TimelineChunks > 0 && c.Streaming.TimelineSessions <= 0 {
+		return fmt.Errorf("streaming.timeline_sessions: must be positive when timeline_chunks is set")
+	}
+	if c.Streaming.DrainGrace < 0 {
+		return fmt.Errorf("streaming.drain_grace: must not be negative")
+	}
+	if c.Streaming.ResumeGrace < 0 {
+		return fmt.Errorf("streaming.resume_grace: must not be negative")
+	}
+	if c.Streaming.ResumeGrace > 0 && c.Streaming.ResumeSessions <= 0 {
+		return fmt.Errorf("streaming.resume_sessions: must be positive when resume_grace is set")
+	}
+	if err := c.Streaming.validateLadder(); err != nil {
+		return err
+	}
+	if err := c.Streaming.validateSeed(); err != nil {
+		return err
+	}
+	if err := c.Streaming.ABR.validate(); err != nil {
+		return err
+	}
+	if err := c.Streaming.Pacing.validate(); err != nil {
+		return err
+	}
+	if err := c.Streaming.Broadcast.validate(); err != nil {
+		return err
+	}
+	if c.Streaming.Ingest.Token != "" && (c.Streaming.Ingest.Buffer <= 0 || c.Streaming.Ingest.Streams <= 0) {
+		return fmt.Errorf("streaming.ingest: buffer and streams must be positive when token is set")
+	}
+	if err := c.Streaming.HLS.validate(); err != nil {
+		return err
+	}
+	if err := c.Streaming.validateAdmission(); err != nil {
+		return err
+	}
+	if err := c.Limits.validate(); err != nil {
+		return err
+	}
+	if err := c.Bridge.MQTT.validate(); err != nil {
+		return err
+	}
+	return c.Quotas.validate()
+}
+
+func (a ABRConfig) validate() error {
+	if a.LowBuffer <= 0 || a.HighBuffer <= a.LowBuffer {
+		return fmt.Errorf("streaming.abr: low_buffer must be positive and below high_buffer")
+	}
+	if a.DownReports < 1 || a.UpReports < 1 {
+		return fmt.Errorf("streaming.abr: down_reports and up_reports must be at least 1")
+	}
+	if a.UpMargin < 1 {
+		return fmt.Errorf("streaming.abr.up_margin: must be at least 1")
+	}
+	if a.MinDwell < 0 {
+		return fmt.Errorf("streaming.abr.min_dwell: must not be negative")
+	}
+	if a.Sessions <= 0 {
+		return fmt.Errorf("streaming.abr.sessions: must be positive")
+	}
+	return nil
+}
+
+func (p PacingConfig) validate() error {
+	if p.Spread < 0 || p.Spread > 1 {
+		return fmt.Errorf("streaming.pacing.spread: must be between 0 and 1")
+	}
+	if p.MaxKbps < 0 {
+		return fmt.Errorf("streaming.pacing.max_kbps: must not be negative")
+	}
+	if p.Sessions <= 0 {
+		return fmt.Errorf("streaming.pacing.sessions: must be positive")
+	}
+	return nil
+}
+
+func (b BroadcastConfig) validate() error {
+	if b.Chunks < 0 {
+		return fmt.Errorf("streaming.broadcast.chunks: must not be negative")
+	}
+	if b.Chunks > 0 && b.ViewerIdle <= 0 {
+		return fmt.Errorf("streaming.broadcast.viewer_idle: must be positive when chunks is set")
+	}
+	if b.Linger < 0 {
+		return fmt.Errorf("streaming.broadcast.linger: must not be negative")
+	}
+	return nil
+}
+
+func (h HLSConfig) validate() error {
+	if h.TargetDuration <= 0 {
+		return fmt.Errorf("streaming.hls.target_duration: must be positive")
+	}
+	if h.Window <= 0 {
+		return fmt.Errorf("streaming.hls.window: must be positive")
+	}
+	if h.PlaylistType != "vod" && h.PlaylistType != "live" {
+		return fmt.Errorf("streaming.hls.playlist_type: must be vod or live")
+	}
+	return nil
+}
+
+func (s SinksConfig) validate() error {
+	if s.File.Path == "" && s.NATS.URL == "" {
+		return nil
+	}
+	if s.QueueSize <= 0 {
+		return fmt.Errorf("iot.sinks.queue_size: must be positive")
+	}
+	if s.PublishTimeout <= 0 {
+		return fmt.Errorf("iot.sinks.publish_timeout: must be positive")
+	}
+	if s.NATS.URL == "" {
+		return nil
+	}
+	if u, err := url.Parse(s.NATS.URL); err != nil || u.Host == "" {
+		return fmt.Errorf("iot.sinks.nats.url: %q is not a NATS URL such as nats://localhost:4222", s.NATS.URL)
+	}
+	if s.NATS.Subject == "" || strings.ContainsAny(s.NATS.Subject, "*> \t") {
+		return fmt.Errorf("iot.sinks.nats.subject: must be set and contain no wildcards or spaces")
+	}
+	return nil
+}
+
+func (m MQTTBridgeConfig) validate() error {
+	if m.Broker == "" {
+		return nil
+	}
+	u, err := url.Parse(m.Broker)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("bridge.mqtt.broker: %q is not a broker URL such as tcp://localhost:1883", m.Broker)
+	}
+	if !slices.Contains([]string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}, u.Scheme) {
+		return fmt.Errorf("bridge.mqtt.broker: unsupported scheme %q", u.Scheme)
+	}
+	if m.QoS < 0 || m.QoS > 2 {
+		return fmt.Errorf("bridge.mqtt.qos: must be 0, 1 or 2")
+	}
+	if m.Topic == "" || strings.ContainsAny(m.Topic, "+#") {
+		return fmt.Errorf("bridge.mqtt.topic: must be set and contain no wildcards")
+	}
+	if strings.ContainsAny(m.CommandTopic, "+#") {
+		return fmt.Errorf("bridge.mqtt.command_topic: must contain no wildcards; use {device_id} for the device")
+	}
+	for _, level := range strings.Split(m.CommandTopic, "/") {
+		if strings.Contains(level, "{device_id}") && level != "{device_id}" {
+			return fmt.Errorf("bridge.mqtt.command_topic: {device_id} must be a whole topic level")
+		}
+	}
+	if m.BufferSize <= 0 {
+		return fmt.Errorf("bridge.mqtt.buffer_size: must be positive")
+	}
+	if m.MaxReconnectInterval < time.Second {
+		return fmt.Errorf("bridge.mqtt.max_reconnect_interval: must be at least 1s")
+	}
+	return nil
+}
+
+func (l LimitsConfig) validate() error {
+	switch {
+	case l.MaxHeaderBytes < 1<<10:
+		return fmt.Errorf("limits.max_header_bytes: must be at least 1 KB")
+	case l.MaxBodyBytes < 0:
+		return fmt.Errorf("limits.max_body_bytes: must not be negative")
+	case l.ReadHeaderTimeout < 0, l.BodyTimeout < 0, l.IdleTimeout < 0:
+		return fmt.Errorf("limits: timeouts must not be negative")
+	case l.MaxConcurrent < 0:
+		return fmt.Errorf("limits.max_concurrent: must not be negative")
+	case l.RatePerIP < 0:
+		return fmt.Errorf("limits.rate_per_ip: must not be negative")
+	case l.RatePerIP > 0 && l.Burst <= 0:
+		return fmt.Errorf("limits.burst: must be positive when rate_per_ip is set")
+	}
+	return nil
+}
+
+func (s StreamingConfig) validateAdmission() error {
+	a := s.Admission
+	if a.MaxConcurrentStreams < 0 {
+		return fmt.Errorf("streaming.admission.max_concurrent_streams: must not be negative")
+	}
+	if a.MaxConcurrentStreams > 0 && a.Idle <= 0 {
+		return fmt.Errorf("streaming.admission.idle: must be positive when max_concurrent_streams is set")
+	}
+	for quality, w := range a.Weights {
+		if !slices.ContainsFunc(s.Ladder, func(q QualityConfig) bool { return q.Name == quality }) {
+			return fmt.Errorf("streaming.admission.weights: unknown quality %q", quality)
+		}
+		if w <= 0 {
+			return fmt.Errorf("streaming.admission.weights: weight of %q must be positive", quality)
+		}
+		if a.MaxConcurrentStreams > 0 && w > a.MaxConcurrentStreams {
+			return fmt.Errorf("streaming.admission.weights: weight %d of %q is above max_concurrent_streams, no viewer could play it", w, quality)
+		}
+	}
+	return nil
+}
+
+func (s StreamingConfig) validateLadder() error {
+	if s.ChunkDuration <= 0 || s.ChunkDuration%time.Millisecond != 0 {
+		return fmt.Errorf("streaming.chunk_duration: must be a positive whole number of milliseconds")
+	}
+	if len(s.Ladder) == 0 {
+		return fmt.Errorf("streaming.ladder: at least one quality is required")
+	}
+	seen := make(map[string]bool)
+	for i, q := range s.Ladder {
+		switch {
+		case q.Name == "":
+			return fmt.Errorf("streaming.ladder[%d]: name is required", i)
+		case strings.Contains(q.Name, "/"):
+			return fmt.Errorf("streaming.ladder[%d]: name %q must not contain /", i, q.Name)
+		case seen[q.Name]:
+			return fmt.Errorf("streaming.ladder[%d]: quality %q listed twice", i, q.Name)
+		case q.Width <= 0 || q.Height <= 0:
+			return fmt.Errorf("streaming.ladder[%d]: width and height of %q must be positive", i, q.Name)
+		case q.BitrateKbps <= 0:
+			return fmt.Errorf("streaming.ladder[%d]: bitrate_kbps of %q must be positive", i, q.Name)
+		case q.FrameRate <= 0:
+			return fmt.Errorf("streaming.ladder[%d]: frame_rate of %q must be positive", i, q.Name)
+		case i > 0 && q.BitrateKbps <= s.Ladder[i-1].BitrateKbps:
+			return fmt.Errorf("streaming.ladder[%d]: bitrate of %q must be above the %d kbps of %q, rungs go from lowest to highest",
+				i, q.Name, s.Ladder[i-1].BitrateKbps, s.Ladder[i-1].Name)
+		}
+		seen[q.Name] = true
+	}
+	return nil
+}
+
+func (s StreamingConfig) validateSeed() error {
+	if s.SeedStreams < 0 || s.SeedStreams > 1000 {
+		return fmt.Errorf("streaming.seed_streams: must be between 0 and 1000")
+	}
+	if s.SeedStreams == 0 {
+		return nil
+	}
+	if len(s.SeedDurations) == 0 {
+		return fmt.Errorf("streaming.seed_durations: at least one duration is required when seed_streams is set")
+	}
+	for _, d := range s.SeedDurations {
+		if d < s.ChunkDuration {
+			return fmt.Errorf("streaming.seed_durations: %v is shorter than one %v chunk", d, s.ChunkDuration)
+		}
+	}
+	names := make([]string, len(s.Ladder))
+	for i, q := range s.Ladder {
+		names[i] = q.Name
+	}
+	seen := make(map[string]bool)
+	for _, q := range s.SeedLadder {
+		if !slices.Contains(names, q) {
+			return fmt.Errorf("streaming.seed_ladder: unknown quality %q, expected one of %s", q, strings.Join(names, ", "))
+		}
+		if seen[q] {
+			return fmt.Errorf("streaming.seed_ladder: quality %q listed twice", q)
+		}
+		seen[q] = true
+	}
+	return nil
+}
+
+// Flow-control windows of the QUIC transport. Below minWindow a single
+// HTTP/3 frame can stall a stream; above maxWindow one peer could make the
+// server buffer that much per stream. Max windows should cover the path's
+// bandwidth-delay product, about 2 MB for 25 Mbps at 600 ms RTT on a
+// satellite link, and the connection window the streams sending at once.
+const (
+	minWindow = 16 << 10
+	maxWindow = 256 << 20
+)
+
+// quic-go defaults applied to zero windows
+const (
+	defaultInitialStreamWindow     = 512 << 10
+	defaultMaxStreamWindow         = 6 << 20
+	defaultInitialConnectionWindow = 768 << 10
+	defaultMaxConnectionWindow     = 15 << 20
+)
+
+func (q QUICConfig) validate() error {
+	windows := []struct {
+		key      string
+		value    *uint64
+		fallback uint64
+	}{
+		{"quic.initial_stream_window", &q.InitialStreamWindow, defaultInitialStreamWindow},
+		{"quic.max_stream_window", &q.MaxStreamWindow, defaultMaxStreamWindow},
+		{"quic.initial_connection_window", &q.InitialConnectionWindow, defaultInitialConnectionWindow},
+		{"quic.max_connection_window", &q.MaxConnectionWindow, defaultMaxConnectionWindow},
+	}
+	for _, w := range windows {
+		if *w.value == 0 {
+			*w.value = w.fallback
+			continue
+		}
+		if *w.value < minWindow || *w.value > maxWindow {
+			return fmt.Errorf("%s: must be between %d KB and %d MB", w.key, minWindow>>10, maxWindow>>20)
+		}
+	}
+
+	switch {
+	case q.InitialStreamWindow > q.MaxStreamWindow:
+		return fmt.Errorf("quic.initial_stream_window: must not exceed max_stream_window (%d)", q.MaxStreamWindow)
+	case q.InitialConnectionWindow > q.MaxConnectionWindow:
+		return fmt.Errorf("quic.initial_connection_window: must not exceed max_connection_window (%d)", q.MaxConnectionWindow)
+	case q.MaxStreamWindow > q.MaxConnectionWindow:
+		return fmt.Errorf("quic.max_connection_window: must be at least max_stream_window (%d)", q.MaxStreamWindow)
+	}
+	return nil
+}
+
+// validateCongestion checks that name could be a kernel congestion controller
+// name. Whether the controller is available is only known at listener startup.
+func validateCongestion(key, name string) error {
+	if len(name) > 15 {
+		return fmt.Errorf("%s: name longer than 15 characters", key)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("%s: invalid controller name %q", key, name)
+		}
+	}
+	return nil
+}
+
+func (s SamplingConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	switch {
+	case s.Window < 2:
+		return fmt.Errorf("iot.sampling.window: must be at least 2")
+	case s.MinInterval <= 0:
+		return fmt.Errorf("iot.sampling.min_interval: must be positive")
+	case s.MaxInterval < s.MinInterval:
+		return fmt.Errorf("iot.sampling.max_interval: must not be below min_interval")
+	case s.StableStdDev < 0:
+		return fmt.Errorf("iot.sampling.stable_stddev: must not be negative")
+	case s.VolatileStdDev <= s.StableStdDev:
+		return fmt.Errorf("iot.sampling.volatile_stddev: must be above stable_stddev")
+	}
+	return nil
+}
+
+func (v ValidationConfig) validate() error {
+	if !v.Enabled {
+		return nil
+	}
+	if v.MaxSkew < 0 {
+		return fmt.Errorf("iot.validation.max_skew: must not be negative")
+	}
+	for sensorType, rule := range v.Sensors {
+		if rule.Max < rule.Min {
+			return fmt.Errorf("iot.validation.sensors.%s: max must not be below min", sensorType)
+		}
+	}
+	return nil
+}
+
+func (a AggregationConfig) validate() error {
+	for i, d := range a.Windows {
+		// Windows that divide a day start at the same wall-clock times
+		// every day
+		if d < time.Second || (24*time.Hour)%d != 0 {
+			return fmt.Errorf("iot.aggregation.windows: %v must be at least 1s and divide 24h", d)
+		}
+		if slices.Contains(a.Windows[:i], d) {
+			return fmt.Errorf("iot.aggregation.windows: %v is listed twice", d)
+		}
+	}
+	return nil
+}
+
+func (q QuotaConfig) validate() error {
+	limits := []struct {
+		key   string
+		limit QuotaLimit
+	}{
+		{"quotas.device", q.Device},
+		{"quotas.session", q.Session},
+	}
+	for _, l := range limits {
+		if l.limit.Bytes < 0 {
+			return fmt.Errorf("%s.bytes: must not be negative", l.key)
+		}
+		if l.limit.Bytes > 0 && l.limit.Window <= 0 {
+			return fmt.Errorf("%s.window: must be positive when bytes is set", l.key)
+		}
+	}
+	if q.ThrottleRatio <= 0 || q.ThrottleRatio > 1 {
+		return fmt.Errorf("quotas.throttle_ratio: must be in (0, 1]")
+	}
+	if q.ThrottleDelay < 0 {
+		return fmt.Errorf("quotas.throttle_delay: must not be negative")
+	}
+	return nil
+}
+
+// loopback reports whether addr only accepts connections from this host
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateTLS(prefix string, t TLSConfig) error {
+	switch {
+	case t.CertFile != "" && t.KeyFile == "":
+		return fmt.Errorf("%s.key_file: must be set when cert_file is set", prefix)
+	case t.KeyFile != "" && t.CertFile == "":
+		return fmt.Errorf("%s.cert_file: must be set when key_file is set", prefix)
+	}
+
+	files := []struct {
+		key  string
+		path string
+	}{
+		{"cert_file", t.CertFile},
+		{"key_file", t.KeyFile},
+		{"client_ca_file", t.ClientCAFile},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		f, err := os.Open(file.path)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", prefix, file.key, err)
+		}
+		f.Close()
+	}
+
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("%s.min_version: unsupported TLS version %q (use 1.2 or 1.3)", prefix, t.MinVersion)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateDefault(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+}
+
+func TestValidateStreaming(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string // key named by the error, empty if valid
+	}{
+		{"paced and capped", func(c *Config) { c.Streaming.Pacing = PacingConfig{Spread: 0.5, MaxKbps: 4000, Sessions: 10} }, ""},
+		{"spread over the interval", func(c *Config) { c.Streaming.Pacing.Spread = 1.5 }, "streaming.pacing.spread"},
+		{"negative cap", func(c *Config) { c.Streaming.Pacing.MaxKbps = -1 }, "streaming.pacing.max_kbps"},
+		{"no paced sessions", func(c *Config) { c.Streaming.Pacing.Sessions = 0 }, "streaming.pacing.sessions"},
+		{"unshared sources", func(c *Config) { c.Streaming.Broadcast = BroadcastConfig{} }, ""},
+		{"negative shared chunks", func(c *Config) { c.Streaming.Broadcast.Chunks = -1 }, "streaming.broadcast.chunks"},
+		{"viewers never idle", func(c *Config) { c.Streaming.Broadcast.ViewerIdle = 0 }, "streaming.broadcast.viewer_idle"},
+		{"negative linger", func(c *Config) { c.Streaming.Broadcast.Linger = -time.Second }, "streaming.broadcast.linger"},
+		{"live playlists", func(c *Config) { c.Streaming.HLS.PlaylistType = "live" }, ""},
+		{"no target duration", func(c *Config) { c.Streaming.HLS.TargetDuration = 0 }, "streaming.hls.target_duration"},
+		{"empty window", func(c *Config) { c.Streaming.HLS.Window = 0 }, "streaming.hls.window"},
+		{"unknown playlist type", func(c *Config) { c.Streaming.HLS.PlaylistType = "event" }, "streaming.hls.playlist_type"},
+		{"one rung in 4s chunks", func(c *Config) {
+			c.Streaming.Ladder = c.Streaming.Ladder[:1]
+			c.Streaming.ChunkDuration = 4 * time.Second
+		}, ""},
+		{"no chunk duration", func(c *Config) { c.Streaming.ChunkDuration = 0 }, "streaming.chunk_duration"},
+		{"chunks in microseconds", func(c *Config) { c.Streaming.ChunkDuration = 1500 * time.Microsecond }, "streaming.chunk_duration"},
+		{"empty ladder", func(c *Config) { c.Streaming.Ladder = nil }, "streaming.ladder"},
+		{"unnamed rung", func(c *Config) { c.Streaming.Ladder[1].Name = "" }, "streaming.ladder[1]"},
+		{"rung with a slash", func(c *Config) { c.Streaming.Ladder[1].Name = "hd/60" }, "streaming.ladder[1]"},
+		{"rung listed twice", func(c *Config) { c.Streaming.Ladder[1].Name = c.Streaming.Ladder[0].Name }, "streaming.ladder[1]"},
+		{"rung without resolution", func(c *Config) { c.Streaming.Ladder[0].Height = 0 }, "streaming.ladder[0]"},
+		{"rung without frame rate", func(c *Config) { c.Streaming.Ladder[0].FrameRate = 0 }, "streaming.ladder[0]"},
+		{"descending bitrates", func(c *Config) { c.Streaming.Ladder[1].BitrateKbps = c.Streaming.Ladder[0].BitrateKbps }, "streaming.ladder[1]"},
+		{"seeded off the ladder", func(c *Config) {
+			c.Streaming.SeedStreams = 1
+			c.Streaming.SeedDurations = []time.Duration{time.Minute}
+			c.Streaming.SeedLadder = []string{"8k"}
+		}, "streaming.seed_ladder"},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		tt.modify(cfg)
+		err := cfg.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate() = %v, want an error naming %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateFirmwareImageFitsBodyLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   int64
+		body    int64
+		wantErr bool
+	}{
+		{"equal", 32 << 20, 32 << 20, false},
+		{"smaller", 1 << 20, 32 << 20, false},
+		{"unlimited body", 1 << 30, 0, false},
+		{"larger", 64 << 20, 32 << 20, true},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		cfg.IoT.Firmware.MaxImageBytes = tt.image
+		cfg.Limits.MaxBodyBytes = tt.body
+		err := cfg.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Validate() = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !strings.Contains(err.Error(), "iot.firmware.max_image_bytes") {
+			t.Errorf("%s: error %q does not name the key", tt.name, err)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "server.crt")
+	key := filepath.Join(dir, "server.key")
+	for _, path := range []string{cert, key} {
+		if err := os.WriteFile(path, []byte("pem"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	missing := filepath.Join(dir, "missing.pem")
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string // key named by the error, empty if valid
+	}{
+		{"cert and key", func(c *Config) { c.TLS = TLSConfig{CertFile: cert, KeyFile: key} }, ""},
+		{"cert without key", func(c *Config) { c.TLS.CertFile = cert }, "tls.key_file"},
+		{"key without cert", func(c *Config) { c.TLS.KeyFile = key }, "tls.cert_file"},
+		{"missing client CA", func(c *Config) { c.TLS.ClientCAFile = missing }, "tls.client_ca_file"},
+		{"unsupported version", func(c *Config) { c.TLS.MinVersion = "1.1" }, "tls.min_version"},
+		{"listener override", func(c *Config) { c.Server.TCP.TLS = &TLSConfig{CertFile: missing, KeyFile: key} }, "server.tcp.tls.cert_file"},
+		{"admin on loopback", func(c *Config) { c.Admin.Addr = "[::1]:9090" }, ""},
+		{"admin on localhost", func(c *Config) { c.Admin.Addr = "localhost:9090" }, ""},
+		{"admin disabled", func(c *Config) { c.Admin.Addr = "" }, ""},
+		{"admin on every interface", func(c *Config) { c.Admin.Addr = ":9090" }, "admin.addr"},
+		{"admin on another host", func(c *Config) { c.Admin.Addr = "10.0.0.5:9090" }, "admin.addr"},
+		{"admin over TLS", func(c *Config) { c.Admin.Addr = "0.0.0.0:9090"; c.Admin.TLS = &TLSConfig{} }, ""},
+		{"admin TLS override", func(c *Config) { c.Admin.TLS = &TLSConfig{MinVersion: "1.0"} }, "admin.tls.min_version"},
+	}
+	for _, tt := range tests {
+		cfg := Default()
+		tt.modify(cfg)
+		err := cfg.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: Validate() = %v, want nil", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: Validate() = %v, want an error naming %s", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Package health tracks the liveness of long-running loops and serves the
+// /healthz endpoint.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// DefaultUtilizationThreshold is the queue utilization above which a
+// component is reported unhealthy
+const DefaultUtilizationThreshold = 0.9
+
+// Registry tracks the progress of long-running loops
+type Registry struct {
+	mu         sync.Mutex
+	components map[string]*Component
+	threshold  float64
+	clock      clock.Clock
+}
+
+// Option configures a Registry
+type Option func(*Registry)
+
+// WithClock replaces the real clock, e.g. with a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(r *Registry) {
+		r.clock = c
+	}
+}
+
+// Component is a registered loop that must beat within its deadline
+type Component struct {
+	clock    clock.Clock
+	name     string
+	deadline time.Duration
+	capacity int
+
+	mu       sync.Mutex
+	lastBeat time.Time
+	depth    int
+}
+
+// Status describes the health of one component
+type Status struct {
+	Name        string    `json:"name"`
+	Healthy     bool      `json:"healthy"`
+	Reason      string    `json:"reason,omitempty"`
+	LastBeat    time.Time `json:"last_beat"`
+	SinceBeatMs int64     `json:"since_beat_ms"`
+	DeadlineMs  int64     `json:"deadline_ms"`
+	QueueDepth  int       `json:"queue_depth"`
+	Capacity    int       `json:"capacity,omitempty"`
+	Utilization float64   `json:"utilization,omitempty"`
+}
+
+// Report is the body of GET /healthz
+type Report struct {
+	Healthy    bool      `json:"healthy"`
+	Unhealthy  []Status  `json:"unhealthy,omitempty"`
+	Components []Status  `json:"components"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// NewRegistry creates a new health registry
+func NewRegistry(opts ...Option) *Registry {
+	r := &Registry{
+		components: make(map[string]*Component),
+		threshold:  DefaultUtilizationThreshold,
+		clock:      clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Register adds a loop that must call Beat at least every deadline. A
+// non-zero capacity enables the queue utilization check.
+func (r *Registry) Register(name string, deadline time.Duration, capacity int) *Component {
+	c := &Component{
+		clock:    r.clock,
+		name:     name,
+		deadline: deadline,
+		capacity: capacity,
+		lastBeat: r.clock.Now(),
+	}
+
+	r.mu.Lock()
+	r.components[name] = c
+	r.mu.Unlock()
+	return c
+}
+
+// Unregister removes a loop that exited normally
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	delete(r.components, name)
+	r.mu.Unlock()
+}
+
+// Beat records progress and the current depth of the loop's queue
+func (c *Component) Beat(queueDepth int) {
+	c.mu.Lock()
+	c.lastBeat = c.clock.Now()
+	c.depth = queueDepth
+	c.mu.Unlock()
+}
+
+// Check returns the status of every registered component
+func (r *Registry) Check() Report {
+	r.mu.Lock()
+	components := make([]*Component, 0, len(r.components))
+	for _, c := range r.components {
+		components = append(components, c)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(components, func(i, j int) bool { return components[i].name < components[j].name })
+
+	now := r.clock.Now()
+	report := Report{
+		Healthy:    true,
+		Components: make([]Status, 0, len(components)),
+		Timestamp:  now,
+	}
+	for _, c := range components {
+		status := c.status(now, r.threshold)
+		report.Components = append(report.Components, status)
+		if !status.Healthy {
+			report.Healthy = false
+			report.Unhealthy = append(report.Unhealthy, status)
+		}
+	}
+	return report
+}
+
+func (c *Component) status(now time.Time, threshold float64) Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	since := now.Sub(c.lastBeat)
+	status := Status{
+		Name:        c.name,
+		Healthy:     true,
+		LastBeat:    c.lastBeat,
+		SinceBeatMs: since.Milliseconds(),
+		DeadlineMs:  c.deadline.Milliseconds(),
+		QueueDepth:  c.depth,
+		Capacity:    c.capacity,
+	}
+	if c.capacity > 0 {
+		status.Utilization = float64(c.depth) / float64(c.capacity)
+	}
+
+	switch {
+	case since > c.deadline:
+		status.Healthy = false
+		status.Reason = fmt.Sprintf("no progress for %v (deadline %v)", since.Round(time.Millisecond), c.deadline)
+	case c.capacity > 0 && status.Utilization > threshold:
+		status.Healthy = false
+		status.Reason = fmt.Sprintf("queue %.0f%% full", status.Utilization*100)
+	}
+	return status
+}
+
+// Handler serves GET /healthz: 200 when every component is healthy, 503
+// otherwise, with the report as JSON
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Check()
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Healthy {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Monitor checks the registry every interval until ctx is cancelled and logs
+// components that become unhealthy or recover. The monitor registers itself
+// so a wedged monitor is visible on /healthz too.
+func (r *Registry) Monitor(ctx context.Context, interval time.Duration, logger logging.Logger) {
+	self := r.Register("health.monitor", 3*interval, 0)
+	defer r.Unregister("health.monitor")
+
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	degraded := make(map[string]bool)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			self.Beat(0)
+			report := r.Check()
+
+			current := make(map[string]bool, len(report.Unhealthy))
+			for _, status := range report.Unhealthy {
+				current[status.Name] = true
+				if !degraded[status.Name] {
+					logger.Warn("Component degraded", logging.F("component", status.Name),
+						logging.F("reason", status.Reason), logging.F("queue_depth", status.QueueDepth))
+				}
+			}
+			for name := range degraded {
+				if !current[name] {
+					logger.Info("Component recovered", logging.F("component", name))
+				}
+			}
+			degraded = current
+		}
+	}
+}
+
+// ServerTimeHeader carries the Unix time in nanoseconds at which the server
+// received a request, read from the same clock as PingHandler's timestamp, so
+// clients that estimated their offset from pings can split round trips into
+// one-way latencies
+const ServerTimeHeader = "X-Server-Time"
+
+// PingHandler answers application-level liveness pings from clients. It does
+// no work beyond writing the server time so it keeps responding while the
+// IoT and streaming handlers are saturated.
+func PingHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		fmt.Fprintf(w, `{"pong":true,"timestamp":%d}`, time.Now().UnixNano())
+	})
+}
@@ -0,0 +1,137 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/clock"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+)
+
+// syncBuffer is a log output the test reads while the monitor writes it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// waitFor fails the test if the output has not contained s within a second
+func (b *syncBuffer) waitFor(t *testing.T, s string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		b.mu.Lock()
+		found := strings.Contains(b.buf.String(), s)
+		b.mu.Unlock()
+		if found {
+			return
+		}
+	}
+	t.Fatalf("%q not logged", s)
+}
+
+// get returns the status and report of GET /healthz
+func get(t *testing.T, r *Registry) (int, Report) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	return rec.Code, report
+}
+
+func TestStalledConsumerFailsHealthz(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewRegistry(WithClock(fake))
+	queue := make(chan int, 10)
+	consumer := r.Register("iot.sensor_pipeline", time.Second, cap(queue))
+	r.Register("streaming.broadcast", time.Minute, 0)
+
+	// A consumer keeping up beats with an empty queue
+	for i := 0; i < 5; i++ {
+		queue <- i
+		<-queue
+		consumer.Beat(len(queue))
+		fake.Advance(500 * time.Millisecond)
+	}
+	if code, report := get(t, r); code != http.StatusOK || !report.Healthy {
+		t.Fatalf("healthy consumer: status %d, report %+v", code, report)
+	}
+
+	// The consumer stalls while the producer keeps filling its queue
+	for i := 0; i < cap(queue); i++ {
+		queue <- i
+	}
+	fake.Advance(2 * time.Second)
+	code, report := get(t, r)
+	if code != http.StatusServiceUnavailable || report.Healthy {
+		t.Fatalf("stalled consumer: status %d, healthy %v", code, report.Healthy)
+	}
+	if len(report.Unhealthy) != 1 || report.Unhealthy[0].Name != "iot.sensor_pipeline" {
+		t.Fatalf("unhealthy %+v, want only the stalled consumer", report.Unhealthy)
+	}
+
+	// Beating again with a full queue still fails on utilization
+	consumer.Beat(len(queue))
+	code, report = get(t, r)
+	if code != http.StatusServiceUnavailable || len(report.Unhealthy) != 1 || report.Unhealthy[0].Utilization != 1 {
+		t.Fatalf("full queue: status %d, unhealthy %+v", code, report.Unhealthy)
+	}
+
+	// Draining the queue recovers
+	for len(queue) > 0 {
+		<-queue
+	}
+	consumer.Beat(len(queue))
+	if code, _ := get(t, r); code != http.StatusOK {
+		t.Errorf("drained consumer: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestUnregisteredLoopIsForgotten(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewRegistry(WithClock(fake))
+	r.Register("stream.relay", time.Second, 0)
+	fake.Advance(time.Minute)
+	r.Unregister("stream.relay")
+	if code, report := get(t, r); code != http.StatusOK || len(report.Components) != 0 {
+		t.Errorf("status %d, components %+v after the loop exited", code, report.Components)
+	}
+}
+
+func TestMonitorLogsDegradation(t *testing.T) {
+	fake := clock.NewFake(time.Unix(0, 0))
+	r := NewRegistry(WithClock(fake))
+	consumer := r.Register("iot.command_router", 500*time.Millisecond, 0)
+	out := &syncBuffer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Monitor(ctx, time.Second, logging.NewWithWriter(out, "text", logging.LevelInfo))
+	// The monitor registers itself before it starts ticking
+	for len(r.Check().Components) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	fake.Advance(time.Second)
+	out.waitFor(t, "Component degraded component=iot.command_router")
+
+	// Beat between ticks so the next one finds it within its deadline
+	fake.Advance(700 * time.Millisecond)
+	consumer.Beat(0)
+	fake.Advance(300 * time.Millisecond)
+	out.waitFor(t, "Component recovered component=iot.command_router")
+}
@@ -0,0 +1,84 @@
+package logging_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/benchmark"
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+)
+
+// readEntries returns the JSON lines of the log file at path by component
+func readEntries(t *testing.T, path string) map[string][]map[string]interface{} {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	entries := make(map[string][]map[string]interface{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		component, _ := entry["component"].(string)
+		entries[component] = append(entries[component], entry)
+	}
+	return entries
+}
+
+// TestConfigControlsEveryComponent logs from the IoT handler, which used to
+// log with zap, and the benchmarker, which used logrus, through one logging
+// section and checks both follow its level, format and file
+func TestConfigControlsEveryComponent(t *testing.T) {
+	tests := []struct {
+		level string
+		want  map[string]int // entries logged per component
+	}{
+		{"info", map[string]int{"iot": 1, "benchmark": 1}},
+		{"warn", map[string]int{"iot": 0, "benchmark": 1}},
+		{"error", map[string]int{"iot": 0, "benchmark": 0}},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), "commsys.log")
+		logger, err := logging.New(config.LoggingConfig{Level: tt.level, Format: "json", File: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// A received command is logged as info
+		cfg := config.Default()
+		reg := metrics.NewRegistry()
+		h := iot.NewHandler(cfg.IoT, logger.With(logging.F("component", "iot")), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/iot/command", strings.NewReader(`{"device_id": "dev1", "action": "reboot"}`)))
+		// The HTTP/2 simulation of QUIC is logged as a warning
+		benchmark.NewBenchmarker(benchmark.TestConfig{Protocol: "quic", TestType: "latency"}, logger.With(logging.F("component", "benchmark")))
+
+		entries := readEntries(t, path)
+		for component, want := range tt.want {
+			if got := len(entries[component]); got != want {
+				t.Errorf("level %s: %d %s entries, want %d", tt.level, got, component, want)
+				continue
+			}
+			for _, entry := range entries[component] {
+				if entry["level"] == "" || entry["message"] == "" {
+					t.Errorf("level %s: %s entry %v", tt.level, component, entry)
+				}
+			}
+		}
+	}
+}
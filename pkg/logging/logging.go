@@ -0,0 +1,323 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nik1740/quic-communication-system/pkg/config"
+)
+
+// Level represents a log severity
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lower-case level name
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel converts a level name into a Level
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+// Field is a structured key/value pair attached to a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Err creates an "error" Field
+func Err(err error) Field {
+	return Field{Key: "error", Value: err}
+}
+
+// Logger is the logging interface accepted by all internal packages
+type Logger interface {
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	With(fields ...Field) Logger
+	Named(component string) Logger
+}
+
+// New creates a Logger from the logging configuration
+func New(cfg config.LoggingConfig) (Logger, error) {
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = f
+	}
+
+	var format string
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		format = "text"
+	case "json":
+		format = "json"
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	l := NewWithWriter(out, format, level)
+	if _, err := SetLevels(l, cfg.Level, cfg.Levels); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewWithWriter creates a Logger writing to out in the given format ("text" or "json")
+func NewWithWriter(out io.Writer, format string, level Level) Logger {
+	return &logger{
+		sink: &sink{
+			out:        out,
+			json:       format == "json",
+			level:      level,
+			components: make(map[string]bool),
+			overrides:  make(map[string]Level),
+		},
+	}
+}
+
+// Nop returns a Logger that discards everything
+func Nop() Logger {
+	return NewWithWriter(io.Discard, "text", LevelError+1)
+}
+
+// sink is the shared output of a logger and all loggers derived from it
+type sink struct {
+	mu   sync.Mutex
+	out  io.Writer
+	json bool
+
+	levelMu    sync.RWMutex
+	level      Level
+	components map[string]bool  // names handed out via Named
+	overrides  map[string]Level // per-component levels
+}
+
+func (s *sink) enabled(component string, level Level) bool {
+	s.levelMu.RLock()
+	defer s.levelMu.RUnlock()
+	if min, ok := s.overrides[component]; ok {
+		return level >= min
+	}
+	return level >= s.level
+}
+
+type logger struct {
+	sink      *sink
+	component string
+	fields    []Field
+}
+
+func (l *logger) Debug(msg string, fields ...Field) { l.log(LevelDebug, msg, fields) }
+func (l *logger) Info(msg string, fields ...Field)  { l.log(LevelInfo, msg, fields) }
+func (l *logger) Warn(msg string, fields ...Field)  { l.log(LevelWarn, msg, fields) }
+func (l *logger) Error(msg string, fields ...Field) { l.log(LevelError, msg, fields) }
+
+func (l *logger) With(fields ...Field) Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	merged = append(merged, l.fields...)
+	merged = append(merged, fields...)
+	return &logger{sink: l.sink, component: l.component, fields: merged}
+}
+
+func (l *logger) Named(component string) Logger {
+	l.sink.levelMu.Lock()
+	l.sink.components[component] = true
+	l.sink.levelMu.Unlock()
+
+	fields := make([]Field, 0, len(l.fields)+1)
+	fields = append(fields, F("component", component))
+	for _, f := range l.fields {
+		if f.Key != "component" {
+			fields = append(fields, f)
+		}
+	}
+	return &logger{sink: l.sink, component: component, fields: fields}
+}
+
+func (l *logger) log(level Level, msg string, fields []Field) {
+	if !l.sink.enabled(l.component, level) {
+		return
+	}
+
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	all = append(all, l.fields...)
+	all = append(all, fields...)
+
+	var line []byte
+	if l.sink.json {
+		line = formatJSON(level, msg, all)
+	} else {
+		line = formatText(level, msg, all)
+	}
+
+	l.sink.mu.Lock()
+	l.sink.out.Write(line)
+	l.sink.mu.Unlock()
+}
+
+func formatText(level Level, msg string, fields []Field) []byte {
+	var b strings.Builder
+	b.WriteString(time.Now().Format("2006/01/02 15:04:05"))
+	b.WriteByte(' ')
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.Key)
+		b.WriteByte('=')
+		b.WriteString(formatValue(f.Value))
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+func formatJSON(level Level, msg string, fields []Field) []byte {
+	entry := make(map[string]interface{}, len(fields)+3)
+	for _, f := range fields {
+		if err, ok := f.Value.(error); ok {
+			entry[f.Key] = err.Error()
+			continue
+		}
+		entry[f.Key] = f.Value
+	}
+	entry["timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["message"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		// Fall back to stringified values if a field is not serializable
+		safe := make(map[string]string, len(entry))
+		for k, v := range entry {
+			safe[k] = formatValue(v)
+		}
+		data, _ = json.Marshal(safe)
+	}
+	return append(data, '\n')
+}
+
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		if strings.ContainsAny(val, " \t\"=") {
+			return fmt.Sprintf("%q", val)
+		}
+		return val
+	case error:
+		return fmt.Sprintf("%q", val.Error())
+	case time.Duration:
+		return val.String()
+	default:
+		return fmt.Sprintf("%v", val)
+	}
+}
+
+// SetLevels replaces the base level and the per-component overrides of l and
+// every logger derived from it. It returns the override names that do not
+// match any component created via Named so callers can warn about typos.
+func SetLevels(l Logger, base string, levels map[string]string) ([]string, error) {
+	lg, ok := l.(*logger)
+	if !ok {
+		return nil, fmt.Errorf("logger does not support runtime levels")
+	}
+
+	baseLevel, err := ParseLevel(base)
+	if err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]Level, len(levels))
+	for name, value := range levels {
+		level, err := ParseLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("component %s: %w", name, err)
+		}
+		overrides[name] = level
+	}
+
+	s := lg.sink
+	s.levelMu.Lock()
+	defer s.levelMu.Unlock()
+
+	s.level = baseLevel
+	s.overrides = overrides
+
+	var unknown []string
+	for name := range overrides {
+		if !s.components[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// Levels returns the base level and per-component overrides of l
+func Levels(l Logger) (string, map[string]string) {
+	lg, ok := l.(*logger)
+	if !ok {
+		return "", nil
+	}
+
+	s := lg.sink
+	s.levelMu.RLock()
+	defer s.levelMu.RUnlock()
+
+	levels := make(map[string]string, len(s.overrides))
+	for name, level := range s.overrides {
+		levels[name] = level.String()
+	}
+	return s.level.String(), levels
+}
@@ -0,0 +1,70 @@
+package logging
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSetLevelsPerComponent(t *testing.T) {
+	var out bytes.Buffer
+	root := NewWithWriter(&out, "text", LevelInfo)
+	streaming := root.Named("streaming")
+	iot := root.Named("iot")
+	quic := root.Named("quic").With(F("conn", 1))
+
+	unknown, err := SetLevels(root, "info", map[string]string{"streaming": "debug", "iot": "warn", "stremaing": "debug"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(unknown, []string{"stremaing"}) {
+		t.Errorf("unknown components %v, want [stremaing]", unknown)
+	}
+
+	streaming.Debug("chunk served")
+	iot.Info("reading accepted")
+	iot.Warn("device offline")
+	quic.Debug("packet lost")
+	quic.Info("connection opened")
+
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{"chunk served", true},
+		{"reading accepted", false},
+		{"device offline", true},
+		{"packet lost", false},
+		{"connection opened", true},
+	}
+	for _, tt := range tests {
+		if got := strings.Contains(out.String(), tt.msg); got != tt.want {
+			t.Errorf("%q logged %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+
+	// Levels change at runtime for loggers already handed out
+	out.Reset()
+	if _, err := SetLevels(root, "warn", nil); err != nil {
+		t.Fatal(err)
+	}
+	streaming.Debug("chunk served")
+	iot.Warn("device offline")
+	if strings.Contains(out.String(), "chunk served") || !strings.Contains(out.String(), "device offline") {
+		t.Errorf("after dropping the overrides logged %q", out.String())
+	}
+	if base, levels := Levels(root); base != "warn" || len(levels) != 0 {
+		t.Errorf("Levels() = %s %v, want warn and no overrides", base, levels)
+	}
+}
+
+func TestSetLevelsRejectsInvalidLevel(t *testing.T) {
+	root := NewWithWriter(&bytes.Buffer{}, "text", LevelInfo)
+	if _, err := SetLevels(root, "info", map[string]string{"iot": "verbose"}); err == nil {
+		t.Error("invalid component level accepted")
+	}
+	if base, _ := Levels(root); base != "info" {
+		t.Errorf("base level %s after a rejected update, want info", base)
+	}
+}
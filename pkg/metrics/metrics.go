@@ -0,0 +1,259 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes every metric registered through this package
+const Namespace = "commsys"
+
+// DefaultMaxLabelValues bounds the distinct values of each label. Values seen
+// after the limit is reached are reported as OverflowLabelValue.
+const DefaultMaxLabelValues = 100
+
+// OverflowLabelValue replaces label values beyond the cardinality limit
+const OverflowLabelValue = "other"
+
+var nameRE = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// Registry is the process-wide metrics registry shared by all components.
+// Registering the same metric twice returns the existing collector instead of
+// panicking, so components can be constructed more than once per process.
+type Registry struct {
+	reg *prometheus.Registry
+
+	mu         sync.Mutex
+	collectors map[string]prometheus.Collector
+	infos      map[string]Info
+	limiters   map[string]*limiter // of labelled metrics, shared by their registrations
+}
+
+// Info describes a metric registered through a Registry
+type Info struct {
+	Name      string // commsys_<subsystem>_<name>
+	Subsystem string
+	Type      string // "counter", "gauge" or "histogram"
+	Help      string
+	Labels    []string
+}
+
+// NewRegistry creates a registry with Go runtime and process collectors
+func NewRegistry() *Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector())
+	reg.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	return &Registry{
+		reg:        reg,
+		collectors: make(map[string]prometheus.Collector),
+		infos:      make(map[string]Info),
+		limiters:   make(map[string]*limiter),
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.reg, promhttp.HandlerOpts{Registry: r.reg})
+}
+
+// Gatherer exposes the underlying registry for inspection
+func (r *Registry) Gatherer() prometheus.Gatherer {
+	return r.reg
+}
+
+// Metrics describes the metrics registered so far, ordered by name. Unlike
+// Gatherer it includes labelled metrics that have no values yet.
+func (r *Registry) Metrics() []Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	infos := make([]Info, 0, len(r.infos))
+	for _, info := range r.infos {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Counter registers a counter named commsys_<subsystem>_<name>. Counter
+// names must end in _total.
+func (r *Registry) Counter(subsystem, name, help string) prometheus.Counter {
+	checkCounterName(name)
+	opts := prometheus.CounterOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return r.register(Info{fqName(subsystem, name), subsystem, "counter", help, nil}, prometheus.NewCounter(opts)).(prometheus.Counter)
+}
+
+// CounterVec registers a labelled counter with bounded label cardinality
+func (r *Registry) CounterVec(subsystem, name, help string, labels ...string) *CounterVec {
+	checkCounterName(name)
+	checkLabels(labels)
+	opts := prometheus.CounterOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	vec := r.register(Info{fqName(subsystem, name), subsystem, "counter", help, labels}, prometheus.NewCounterVec(opts, labels)).(*prometheus.CounterVec)
+	return &CounterVec{vec: vec, limiter: r.labelLimiter(fqName(subsystem, name), len(labels))}
+}
+
+// Gauge registers a gauge named commsys_<subsystem>_<name>
+func (r *Registry) Gauge(subsystem, name, help string) prometheus.Gauge {
+	checkName(name)
+	opts := prometheus.GaugeOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	return r.register(Info{fqName(subsystem, name), subsystem, "gauge", help, nil}, prometheus.NewGauge(opts)).(prometheus.Gauge)
+}
+
+// GaugeVec registers a labelled gauge with bounded label cardinality
+func (r *Registry) GaugeVec(subsystem, name, help string, labels ...string) *GaugeVec {
+	checkName(name)
+	checkLabels(labels)
+	opts := prometheus.GaugeOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help}
+	vec := r.register(Info{fqName(subsystem, name), subsystem, "gauge", help, labels}, prometheus.NewGaugeVec(opts, labels)).(*prometheus.GaugeVec)
+	return &GaugeVec{vec: vec, limiter: r.labelLimiter(fqName(subsystem, name), len(labels))}
+}
+
+// Histogram registers a histogram named commsys_<subsystem>_<name>. A nil
+// buckets slice uses prometheus.DefBuckets.
+func (r *Registry) Histogram(subsystem, name, help string, buckets []float64) prometheus.Histogram {
+	checkName(name)
+	opts := prometheus.HistogramOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help, Buckets: buckets}
+	return r.register(Info{fqName(subsystem, name), subsystem, "histogram", help, nil}, prometheus.NewHistogram(opts)).(prometheus.Histogram)
+}
+
+// HistogramVec registers a labelled histogram with bounded label cardinality
+func (r *Registry) HistogramVec(subsystem, name, help string, buckets []float64, labels ...string) *HistogramVec {
+	checkName(name)
+	checkLabels(labels)
+	opts := prometheus.HistogramOpts{Namespace: Namespace, Subsystem: subsystem, Name: name, Help: help, Buckets: buckets}
+	vec := r.register(Info{fqName(subsystem, name), subsystem, "histogram", help, labels}, prometheus.NewHistogramVec(opts, labels)).(*prometheus.HistogramVec)
+	return &HistogramVec{vec: vec, limiter: r.labelLimiter(fqName(subsystem, name), len(labels))}
+}
+
+func (r *Registry) register(info Info, c prometheus.Collector) prometheus.Collector {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := info.Name
+	if existing, ok := r.collectors[name]; ok {
+		if fmt.Sprintf("%T", existing) != fmt.Sprintf("%T", c) {
+			panic(fmt.Sprintf("metrics: %s already registered as %T", name, existing))
+		}
+		return existing
+	}
+	r.reg.MustRegister(c)
+	r.collectors[name] = c
+	r.infos[name] = info
+	return c
+}
+
+// labelLimiter returns the limiter of the labelled metric name, so that
+// every registration of it counts against the same bound
+func (r *Registry) labelLimiter(name string, labels int) *limiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	l, ok := r.limiters[name]
+	if !ok {
+		l = newLimiter(labels)
+		r.limiters[name] = l
+	}
+	return l
+}
+
+func fqName(subsystem, name string) string {
+	return prometheus.BuildFQName(Namespace, subsystem, name)
+}
+
+func checkName(name string) {
+	if !nameRE.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q (use snake_case)", name))
+	}
+}
+
+func checkCounterName(name string) {
+	checkName(name)
+	if !strings.HasSuffix(name, "_total") {
+		panic(fmt.Sprintf("metrics: counter %q must end in _total", name))
+	}
+}
+
+func checkLabels(labels []string) {
+	for _, label := range labels {
+		if !nameRE.MatchString(label) {
+			panic(fmt.Sprintf("metrics: invalid label name %q", label))
+		}
+	}
+}
+
+// limiter caps the number of distinct values per label. Values often come
+// from requests, so invalid UTF-8, which Prometheus refuses, is replaced.
+type limiter struct {
+	mu   sync.Mutex
+	seen []map[string]bool
+}
+
+func newLimiter(labels int) *limiter {
+	seen := make([]map[string]bool, labels)
+	for i := range seen {
+		seen[i] = make(map[string]bool)
+	}
+	return &limiter{seen: seen}
+}
+
+func (l *limiter) bound(values []string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bounded := make([]string, len(values))
+	for i, v := range values {
+		v = strings.ToValidUTF8(v, "\uFFFD")
+		if i >= len(l.seen) {
+			bounded[i] = v
+			continue
+		}
+		if !l.seen[i][v] && len(l.seen[i]) >= DefaultMaxLabelValues {
+			v = OverflowLabelValue
+		}
+		l.seen[i][v] = true
+		bounded[i] = v
+	}
+	return bounded
+}
+
+// CounterVec is a prometheus.CounterVec with bounded label values
+type CounterVec struct {
+	vec     *prometheus.CounterVec
+	limiter *limiter
+}
+
+// WithLabelValues returns the counter for the given label values
+func (v *CounterVec) WithLabelValues(values ...string) prometheus.Counter {
+	return v.vec.WithLabelValues(v.limiter.bound(values)...)
+}
+
+// GaugeVec is a prometheus.GaugeVec with bounded label values
+type GaugeVec struct {
+	vec     *prometheus.GaugeVec
+	limiter *limiter
+}
+
+// WithLabelValues returns the gauge for the given label values
+func (v *GaugeVec) WithLabelValues(values ...string) prometheus.Gauge {
+	return v.vec.WithLabelValues(v.limiter.bound(values)...)
+}
+
+// HistogramVec is a prometheus.HistogramVec with bounded label values
+type HistogramVec struct {
+	vec     *prometheus.HistogramVec
+	limiter *limiter
+}
+
+// WithLabelValues returns the observer for the given label values
+func (v *HistogramVec) WithLabelValues(values ...string) prometheus.Observer {
+	return v.vec.WithLabelValues(v.limiter.bound(values)...)
+}
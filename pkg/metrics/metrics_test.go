@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the exposition of reg
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	return rec.Body.String()
+}
+
+func TestLabelValuesInvalidUTF8(t *testing.T) {
+	reg := NewRegistry()
+	chunks := reg.CounterVec("streaming", "chunks_total", "Chunks by quality", "quality")
+	chunks.WithLabelValues("\xec").Inc()
+	if out := scrape(t, reg); !strings.Contains(out, `commsys_streaming_chunks_total{quality="�"} 1`) {
+		t.Errorf("invalid label value not replaced:\n%s", out)
+	}
+}
+
+func TestLabelValuesBounded(t *testing.T) {
+	reg := NewRegistry()
+	throttled := reg.CounterVec("iot", "throttled_total", "Throttled devices", "device_id")
+	for i := 0; i < DefaultMaxLabelValues+10; i++ {
+		throttled.WithLabelValues(fmt.Sprintf("dev%d", i)).Inc()
+	}
+	out := scrape(t, reg)
+	if !strings.Contains(out, `commsys_iot_throttled_total{device_id="other"} 10`) {
+		t.Errorf("values past the limit not reported as %s", OverflowLabelValue)
+	}
+	if n := strings.Count(out, "commsys_iot_throttled_total{"); n != DefaultMaxLabelValues+1 {
+		t.Errorf("%d series, want %d", n, DefaultMaxLabelValues+1)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	reg := NewRegistry()
+	for i := 0; i < 2; i++ {
+		reg.Counter("iot", "readings_total", "Readings").Inc()
+		reg.GaugeVec("iot", "devices", "Devices by status", "status").WithLabelValues("online").Add(1)
+		reg.HistogramVec("streaming", "chunk_seconds", "Chunk time by quality", nil, "quality").WithLabelValues("low").Observe(0.5)
+	}
+	if _, err := reg.Gatherer().Gather(); err != nil {
+		t.Fatalf("gathering metrics registered twice: %v", err)
+	}
+
+	out := scrape(t, reg)
+	for _, want := range []string{
+		"commsys_iot_readings_total 2",
+		`commsys_iot_devices{status="online"} 2`,
+		`commsys_streaming_chunk_seconds_count{quality="low"} 2`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("scrape lacks %q", want)
+		}
+	}
+	for _, name := range []string{"commsys_iot_readings_total", "commsys_iot_devices", "commsys_streaming_chunk_seconds"} {
+		if n := strings.Count(out, "# HELP "+name+" "); n != 1 {
+			t.Errorf("%s described %d times, want once", name, n)
+		}
+	}
+}
+
+func TestLabelValuesBoundedAcrossRegistrations(t *testing.T) {
+	reg := NewRegistry()
+	first := reg.CounterVec("iot", "throttled_total", "Throttled devices", "device_id")
+	second := reg.CounterVec("iot", "throttled_total", "Throttled devices", "device_id")
+	for i := 0; i < DefaultMaxLabelValues; i++ {
+		first.WithLabelValues(fmt.Sprintf("a%d", i)).Inc()
+		second.WithLabelValues(fmt.Sprintf("b%d", i)).Inc()
+	}
+	out := scrape(t, reg)
+	want := fmt.Sprintf(`commsys_iot_throttled_total{device_id="%s"} %d`, OverflowLabelValue, DefaultMaxLabelValues)
+	if !strings.Contains(out, want+"\n") {
+		t.Errorf("values of the second registration past the limit not reported as %s", OverflowLabelValue)
+	}
+	if n := strings.Count(out, "commsys_iot_throttled_total{"); n != DefaultMaxLabelValues+1 {
+		t.Errorf("%d series, want %d", n, DefaultMaxLabelValues+1)
+	}
+}
@@ -0,0 +1,102 @@
+package tracing_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nik1740/quic-communication-system/internal/iot"
+	"github.com/nik1740/quic-communication-system/internal/quota"
+	"github.com/nik1740/quic-communication-system/pkg/config"
+	"github.com/nik1740/quic-communication-system/pkg/health"
+	"github.com/nik1740/quic-communication-system/pkg/logging"
+	"github.com/nik1740/quic-communication-system/pkg/metrics"
+	"github.com/nik1740/quic-communication-system/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The span of a client calling the server, in W3C trace context format
+const (
+	traceparent  = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	remoteTrace  = "4bf92f3577b34da6a3ce929d0e0e4736"
+	remoteParent = "00f067aa0ba902b7"
+)
+
+// exporter records the spans of every test. The global provider delegates
+// to the first provider installed, so it is installed once for all of them.
+var exporter = tracetest.NewInMemoryExporter()
+
+func init() {
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+func TestExtract(t *testing.T) {
+	header := http.Header{}
+	header.Set("traceparent", traceparent)
+	ctx := tracing.Extract(context.Background(), propagation.HeaderCarrier(header))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsRemote() || !sc.IsSampled() || sc.SpanID().String() != remoteParent {
+		t.Errorf("extracted %+v, want the sampled remote span %s", sc, remoteParent)
+	}
+	if got := tracing.TraceID(ctx); got != remoteTrace {
+		t.Errorf("TraceID %q, want %s", got, remoteTrace)
+	}
+
+	ctx = tracing.Extract(context.Background(), propagation.HeaderCarrier(http.Header{}))
+	if got := tracing.TraceID(ctx); got != "" {
+		t.Errorf("TraceID %q without a traceparent, want none", got)
+	}
+}
+
+func TestSensorReadingSpans(t *testing.T) {
+	exporter.Reset()
+	cfg := config.Default()
+	reg := metrics.NewRegistry()
+	h := iot.NewHandler(cfg.IoT, logging.Nop(), reg, health.NewRegistry(), quota.NewManager(cfg.Quotas, reg))
+
+	req := httptest.NewRequest(http.MethodPost, "/iot/sensor", strings.NewReader(`{"device_id": "dev1", "sensor_type": "temperature", "value": 21, "unit": "celsius"}`))
+	req.Header.Set("traceparent", traceparent)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reading: status %d: %s", rec.Code, rec.Body)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, s := range exporter.GetSpans() {
+		spans[s.Name] = s
+	}
+	request, ok := spans["iot.sensor"]
+	if !ok {
+		t.Fatalf("no iot.sensor span among %v", exporter.GetSpans().Snapshots())
+	}
+	if request.SpanKind != trace.SpanKindServer {
+		t.Errorf("iot.sensor is a %v span, want server", request.SpanKind)
+	}
+	if request.SpanContext.TraceID().String() != remoteTrace || request.Parent.SpanID().String() != remoteParent || !request.Parent.IsRemote() {
+		t.Errorf("iot.sensor in trace %s under %s, want the client's trace %s under %s",
+			request.SpanContext.TraceID(), request.Parent.SpanID(), remoteTrace, remoteParent)
+	}
+
+	process, ok := spans["iot.process_sensor_data"]
+	if !ok {
+		t.Fatal("no iot.process_sensor_data span")
+	}
+	if process.Parent.SpanID() != request.SpanContext.SpanID() || process.SpanContext.TraceID() != request.SpanContext.TraceID() {
+		t.Errorf("iot.process_sensor_data under %s, want the iot.sensor span %s", process.Parent.SpanID(), request.SpanContext.SpanID())
+	}
+	attrs := make(map[string]string)
+	for _, kv := range process.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["device_id"] != "dev1" || attrs["sensor_type"] != "temperature" {
+		t.Errorf("iot.process_sensor_data attributes %v, want dev1 and temperature", attrs)
+	}
+}
@@ -23,6 +23,12 @@ go build -o bin/streaming-client ./cmd/streaming-client
 echo "Building benchmark tool..."
 go build -o bin/benchmark ./cmd/benchmark
 
+echo "Building commsys tool..."
+go build -o bin/commsys ./cmd/commsys
+
+echo "Building soak harness..."
+go build -o bin/soak ./cmd/soak
+
 echo "Build completed successfully!"
 echo "Binaries are available in the bin/ directory:"
 ls -la bin/
\ No newline at end of file
@@ -0,0 +1,33 @@
+#!/bin/bash
+
+# Runs the throughput test once per available TCP congestion controller under
+# lossy network profiles, and renders all runs into one heatmap report.
+# Profiles are applied with netem on $IFACE, which needs root.
+
+set -e
+
+IFACE=${IFACE:-lo}
+DURATION=${DURATION:-30s}
+OUT=${OUT:-results/congestion}
+PROFILES=(
+	"lossy-1pct:delay 20ms loss 1%"
+	"lossy-2pct:delay 20ms loss 2%"
+	"lossy-5pct:delay 20ms loss 5%"
+)
+
+mkdir -p "$OUT"
+trap 'tc qdisc del dev "$IFACE" root 2>/dev/null || true' EXIT
+
+inputs=""
+for profile in "${PROFILES[@]}"; do
+	name=${profile%%:*}
+	echo "Applying $name to $IFACE..."
+	tc qdisc replace dev "$IFACE" root netem ${profile#*:}
+
+	./bin/benchmark -test throughput -duration "$DURATION" -congestion available \
+		-condition "$name" -output "$OUT/$name.json" \
+		-report "$OUT/report.html" -report-inputs "$inputs"
+	inputs="${inputs:+$inputs,}$OUT/$name.json"
+done
+
+echo "Report written to $OUT/report.html"
@@ -0,0 +1,25 @@
+#!/bin/bash
+
+# Sweeps the benchmark client's QUIC receive windows on a satellite link
+# (600 ms round trip at 25 Mbps, about 2 MB in flight) and renders the runs
+# into one heatmap report. The profile is applied with netem on $IFACE, which
+# needs root; on loopback the delay is paid in both directions.
+
+set -e
+
+IFACE=${IFACE:-lo}
+DURATION=${DURATION:-60s}
+WINDOWS=${WINDOWS:-256KB,512KB,1MB,2MB,4MB,8MB}
+OUT=${OUT:-results/windows}
+
+mkdir -p "$OUT"
+trap 'tc qdisc del dev "$IFACE" root 2>/dev/null || true' EXIT
+
+echo "Applying satellite profile to $IFACE..."
+tc qdisc replace dev "$IFACE" root netem delay 300ms rate 25mbit
+
+./bin/benchmark -test streaming -duration "$DURATION" \
+	-quic-windows "$WINDOWS" -condition satellite \
+	-output "$OUT/satellite.json" -report "$OUT/report.html"
+
+echo "Report written to $OUT/report.html"